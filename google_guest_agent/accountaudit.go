@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// accountAuditKey is the guest attribute the last account changes are reported
+	// to.
+	accountAuditKey = "guest-agent/account-audit"
+	// maxAccountAudits is the number of account changes kept in the guest attribute,
+	// the Event Log keeps the whole trail.
+	maxAccountAudits = 20
+	// auditEventID is the Event Log event ID of the account changes.
+	auditEventID = 883
+)
+
+// The audited account changes.
+const (
+	auditActionCreateAccount = "create-account"
+	auditActionResetPassword = "reset-password"
+)
+
+// The metadata keys requesting the account changes.
+const (
+	auditSourceWindowsKeys = "windows-keys"
+	auditSourceSSHKeys     = "ssh-keys"
+)
+
+var (
+	// accountAuditsMutex protects accountAudits.
+	accountAuditsMutex sync.Mutex
+	// accountAudits are the last account changes reported to the guest attribute.
+	accountAudits []accountAudit
+)
+
+// accountAudit is the audit record of an account change.
+type accountAudit struct {
+	// Action is the account change, i.e. create-account.
+	Action string `json:"action"`
+	// User is the changed account.
+	User string `json:"user"`
+	// Requester is the email of the windows-keys entry requesting the change.
+	Requester string `json:"requester,omitempty"`
+	// Source is the metadata key requesting the change.
+	Source string `json:"source"`
+	// ExpireOn is the expiration of the windows-keys entry.
+	ExpireOn string `json:"expireOn,omitempty"`
+	// Groups are the local groups the account was added to.
+	Groups []string `json:"groups,omitempty"`
+	// Timestamp is the time of the change.
+	Timestamp string `json:"timestamp"`
+	// Error is the reason the change failed, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// auditAccountChange records the account change, failed if err isn't nil, to the
+// Event Log, the agent's logs and the guest attribute.
+func auditAccountChange(ctx context.Context, record accountAudit, err error) {
+	record.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	data, merr := json.Marshal(record)
+	if merr != nil {
+		logger.Errorf("Failed to marshal account audit record: %v", merr)
+		return
+	}
+
+	logger.Infof("Account audit: %s", data)
+	if err := writeAuditEvent(string(data), err != nil); err != nil {
+		logger.Errorf("Failed to write the account audit record to the Event Log: %v", err)
+	}
+
+	accountAuditsMutex.Lock()
+	defer accountAuditsMutex.Unlock()
+	accountAudits = append(accountAudits, record)
+	if len(accountAudits) > maxAccountAudits {
+		accountAudits = accountAudits[len(accountAudits)-maxAccountAudits:]
+	}
+	if mdsClient == nil {
+		return
+	}
+	report, merr := json.Marshal(accountAudits)
+	if merr != nil {
+		logger.Errorf("Failed to marshal account audit records: %v", merr)
+		return
+	}
+	if err := mdsClient.WriteGuestAttributes(ctx, accountAuditKey, string(report)); err != nil {
+		logger.Errorf("Failed to report the account audit records to guest attributes: %v", err)
+	}
+}
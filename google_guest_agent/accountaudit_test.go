@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestAuditAccountChange(t *testing.T) {
+	oldClient, oldAudits := mdsClient, accountAudits
+	t.Cleanup(func() { mdsClient, accountAudits = oldClient, oldAudits })
+	mdsClient, accountAudits = nil, nil
+
+	ctx := context.Background()
+	for i := 0; i < maxAccountAudits+5; i++ {
+		auditAccountChange(ctx, accountAudit{Action: auditActionCreateAccount, User: fmt.Sprintf("user%d", i), Source: auditSourceSSHKeys}, nil)
+	}
+	record := accountAudit{Action: auditActionResetPassword, User: "admin", Requester: "admin@example.com", Source: auditSourceWindowsKeys}
+	auditAccountChange(ctx, record, errors.New("error running resetPwd"))
+
+	if len(accountAudits) != maxAccountAudits {
+		t.Fatalf("auditAccountChange() kept %d records, want %d", len(accountAudits), maxAccountAudits)
+	}
+	if got := accountAudits[0].User; got != "user6" {
+		t.Errorf("auditAccountChange() kept the oldest record of %s, want user6", got)
+	}
+
+	want := record
+	want.Error = "error running resetPwd"
+	got := accountAudits[len(accountAudits)-1]
+	if got.Timestamp == "" {
+		t.Errorf("auditAccountChange() recorded %+v without a timestamp", got)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(accountAudit{}, "Timestamp")); diff != "" {
+		t.Errorf("auditAccountChange() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
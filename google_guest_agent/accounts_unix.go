@@ -21,6 +21,9 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
@@ -36,23 +39,78 @@ func getUIDAndGID(path string) (string, string) {
 	return "", ""
 }
 
+// createUser creates username with the configured useradd command and the
+// [Accounts] provisioning options. uid and gid, the reused home directory's owner,
+// take precedence over the UID range, the user's own group with gid is created
+// unless a primary group is configured.
 func createUser(ctx context.Context, username, uid, gid string) error {
 	config := cfg.Get()
-	useradd := config.Accounts.UserAddCmd
+	extraArgs, err := userAddArgs(config.Accounts, username)
+	if err != nil {
+		return err
+	}
 	if uid != "" {
-		useradd = fmt.Sprintf("%s -u %s", useradd, uid)
+		extraArgs = append(extraArgs, "-u", uid)
 	}
-	if gid != "" {
+	if gid != "" && config.Accounts.PrimaryGroup == "" {
 		groupadd := config.Accounts.GroupAddCmd
 		groupadd = fmt.Sprintf("%s -g %s", groupadd, gid)
 		cmd, args := createUserGroupCmd(groupadd, "", username)
 		if err := run.Quiet(ctx, cmd, args...); err != nil {
 			return err
 		}
-		useradd = fmt.Sprintf("%s -g %s", useradd, gid)
+		owned.recordGroup(username)
+		extraArgs = append(extraArgs, "-g", gid)
 	}
-	cmd, args := createUserGroupCmd(useradd, username, "")
-	return run.Quiet(ctx, cmd, args...)
+	cmd, args := createUserGroupCmd(config.Accounts.UserAddCmd, username, "")
+	if err := run.Quiet(ctx, cmd, append(args, extraArgs...)...); err != nil {
+		return err
+	}
+	owned.recordUser(username)
+	return nil
+}
+
+// userAddArgs returns the useradd arguments setting the [Accounts] provisioning
+// options of username: its shell, home directory, primary group and UID range.
+func userAddArgs(config *cfg.Accounts, username string) ([]string, error) {
+	var args []string
+	if config.Shell != "" {
+		args = append(args, "-s", config.Shell)
+	}
+	if config.HomeDirTemplate != "" {
+		args = append(args, "-d", homeDir(config, username))
+	}
+	if config.PrimaryGroup != "" {
+		args = append(args, "-g", config.PrimaryGroup)
+	}
+	if config.UIDRange != "" {
+		uidMin, uidMax, err := parseUIDRange(config.UIDRange)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-K", fmt.Sprintf("UID_MIN=%d", uidMin), "-K", fmt.Sprintf("UID_MAX=%d", uidMax))
+	}
+	return args, nil
+}
+
+// parseUIDRange parses the "min-max" UID range.
+func parseUIDRange(uidRange string) (int, int, error) {
+	minStr, maxStr, ok := strings.Cut(uidRange, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid uid range %q, want min-max", uidRange)
+	}
+	uidMin, err := strconv.Atoi(strings.TrimSpace(minStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid range %q: %w", uidRange, err)
+	}
+	uidMax, err := strconv.Atoi(strings.TrimSpace(maxStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid range %q: %w", uidRange, err)
+	}
+	if uidMin <= 0 || uidMax < uidMin {
+		return 0, 0, fmt.Errorf("invalid uid range %q, want 0 < min <= max", uidRange)
+	}
+	return uidMin, uidMax, nil
 }
 
 func addUserToGroup(ctx context.Context, user, group string) error {
@@ -62,6 +120,31 @@ func addUserToGroup(ctx context.Context, user, group string) error {
 	return run.Quiet(ctx, cmd, args...)
 }
 
+// deleteUser deletes the user with the configured userdel command, its home
+// directory is only removed if removeHome is set.
+func deleteUser(ctx context.Context, username string, removeHome bool) error {
+	cmd, args := userDelCmd(cfg.Get().Accounts.UserDelCmd, username, removeHome)
+	return run.Quiet(ctx, cmd, args...)
+}
+
+// userDelCmd returns the userdel command deleting user, without its home
+// directory removal flags (-r, --remove) unless removeHome is set.
+func userDelCmd(userdel, user string, removeHome bool) (string, []string) {
+	cmd, args := createUserGroupCmd(userdel, user, "")
+	if removeHome {
+		return cmd, args
+	}
+	return cmd, slices.DeleteFunc(args, func(arg string) bool {
+		return arg == "-r" || arg == "--remove"
+	})
+}
+
+// deleteGroup deletes the group with the configured groupdel command.
+func deleteGroup(ctx context.Context, group string) error {
+	cmd, args := createUserGroupCmd(cfg.Get().Accounts.GroupDelCmd, "", group)
+	return run.Quiet(ctx, cmd, args...)
+}
+
 func userExists(name string) (bool, error) {
 	if _, err := user.Lookup(name); err != nil {
 		return false, err
@@ -17,16 +17,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"syscall"
 	"unsafe"
 
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
 	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/eventlog"
 )
 
 var (
 	netAPI32                    = windows.NewLazySystemDLL("netapi32.dll")
 	procNetUserAdd              = netAPI32.NewProc("NetUserAdd")
+	procNetUserDel              = netAPI32.NewProc("NetUserDel")
 	procNetUserGetInfo          = netAPI32.NewProc("NetUserGetInfo")
+	procNetUserModalsGet        = netAPI32.NewProc("NetUserModalsGet")
 	procNetUserSetInfo          = netAPI32.NewProc("NetUserSetInfo")
 	procNetLocalGroupAddMembers = netAPI32.NewProc("NetLocalGroupAddMembers")
 )
@@ -54,6 +60,14 @@ type (
 	USER_INFO_1003 struct {
 		Usri1003_password LPWSTR
 	}
+
+	USER_MODALS_INFO_0 struct {
+		Usrmod0_min_passwd_len    DWORD
+		Usrmod0_max_passwd_age    DWORD
+		Usrmod0_min_passwd_age    DWORD
+		Usrmod0_force_logoff      DWORD
+		Usrmod0_password_hist_len DWORD
+	}
 )
 
 const (
@@ -167,10 +181,30 @@ func createUser(_ context.Context, username, pwd, _ string) error {
 	if ret != 0 && ret != 2236 {
 		return fmt.Errorf("nonzero return code from NetUserAdd: %s", syscall.Errno(ret))
 	}
+	if ret == 0 {
+		owned.recordUser(username)
+	}
+
+	return nil
+}
 
+// deleteUser deletes the local user account, its profile is always left behind.
+func deleteUser(_ context.Context, username string, _ bool) error {
+	uPtr, err := syscall.UTF16PtrFromString(username)
+	if err != nil {
+		return fmt.Errorf("error encoding username to UTF16: %v", err)
+	}
+	if ret, _, _ := procNetUserDel.Call(uintptr(0), uintptr(unsafe.Pointer(uPtr))); ret != 0 {
+		return fmt.Errorf("nonzero return code from NetUserDel: %s", syscall.Errno(ret))
+	}
 	return nil
 }
 
+// deleteGroup isn't supported on Windows, the agent doesn't create groups.
+func deleteGroup(_ context.Context, group string) error {
+	return fmt.Errorf("deleting group %s unsupported on Windows", group)
+}
+
 func userExists(name string) (bool, error) {
 	uPtr, err := syscall.UTF16PtrFromString(name)
 	if err != nil {
@@ -189,6 +223,61 @@ func userExists(name string) (bool, error) {
 	return true, nil
 }
 
+// getPasswordPolicy returns the machine's effective password policy, the minimum
+// length from NetUserModalsGet and the complexity requirement from the exported
+// security policy.
+func getPasswordPolicy(ctx context.Context) (winPasswordPolicy, error) {
+	var policy winPasswordPolicy
+
+	var buf *byte
+	if ret, _, _ := procNetUserModalsGet.Call(uintptr(0), uintptr(0), uintptr(unsafe.Pointer(&buf))); ret != 0 {
+		return policy, fmt.Errorf("nonzero return code from NetUserModalsGet: %s", syscall.Errno(ret))
+	}
+	policy.minLength = int((*USER_MODALS_INFO_0)(unsafe.Pointer(buf)).Usrmod0_min_passwd_len)
+	windows.NetApiBufferFree(buf)
+
+	dir, err := os.MkdirTemp("", "guest-agent-secpol")
+	if err != nil {
+		return policy, err
+	}
+	defer os.RemoveAll(dir)
+	cfgPath := filepath.Join(dir, "secpol.inf")
+	if res := run.WithOutput(ctx, "secedit", "/export", "/areas", "SECURITYPOLICY", "/cfg", cfgPath, "/quiet"); res.ExitCode != 0 {
+		return policy, fmt.Errorf("failed to export the security policy: %v", res.Error())
+	}
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return policy, err
+	}
+	policy.complexity = parsePasswordComplexity(data)
+	return policy, nil
+}
+
+// writeAuditEvent writes the account audit record msg to the Event Log, as a
+// warning if the change failed.
+func writeAuditEvent(msg string, failed bool) error {
+	el, err := eventlog.Open(programName)
+	if err != nil {
+		return err
+	}
+	defer el.Close()
+	if failed {
+		return el.Warning(auditEventID, msg)
+	}
+	return el.Info(auditEventID, msg)
+}
+
+// isDomainJoined returns true if the machine is joined to a domain.
+func isDomainJoined() (bool, error) {
+	var name *uint16
+	var status uint32
+	if err := windows.NetGetJoinInformation(nil, &name, &status); err != nil {
+		return false, fmt.Errorf("error running NetGetJoinInformation: %v", err)
+	}
+	windows.NetApiBufferFree((*byte)(unsafe.Pointer(name)))
+	return status == windows.NetSetupDomainName, nil
+}
+
 func getUIDAndGID(_ string) (string, string) {
 	return "", ""
 }
@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// The domain modes, how the Windows accounts are managed on domain joined
+// machines, domain controllers included.
+const (
+	// domainModeManage manages the local accounts as on any other machine.
+	domainModeManage = "manage"
+	// domainModeSkip disables the Windows accounts manager.
+	domainModeSkip = "skip"
+	// domainModeRestrict only manages the configured local accounts.
+	domainModeRestrict = "restrict"
+	// domainModeEventsOnly doesn't change any account, the requested changes are
+	// only audited.
+	domainModeEventsOnly = "events-only"
+)
+
+// domainJoined returns true if the machine is joined to a domain. The machine is
+// assumed to be joined if it can't be determined, so the restrictive modes apply.
+func domainJoined() bool {
+	joined, err := isDomainJoined()
+	if err != nil {
+		logger.Warningf("Failed to determine whether the machine is domain joined, assuming it is: %v", err)
+		return true
+	}
+	return joined
+}
+
+// domainMode returns config's domain mode. An unknown mode, i.e. misspelled, is
+// treated as events-only rather than managing the accounts of a domain joined
+// machine.
+func domainMode(config *cfg.WindowsAccounts) string {
+	switch config.DomainMode {
+	case domainModeManage, domainModeSkip, domainModeRestrict, domainModeEventsOnly:
+		return config.DomainMode
+	}
+	logger.Errorf("Unknown domain_mode %q, not changing any account as with %s.", config.DomainMode, domainModeEventsOnly)
+	return domainModeEventsOnly
+}
+
+// checkDomainMode returns an error if config's domain mode doesn't allow changing
+// the local account user, joined is set if the machine is domain joined.
+func checkDomainMode(config *cfg.WindowsAccounts, joined bool, user string) error {
+	if !joined {
+		return nil
+	}
+
+	switch mode := domainMode(config); mode {
+	case domainModeSkip, domainModeEventsOnly:
+		return fmt.Errorf("account %s not changed, the machine is domain joined and the domain_mode is %s", user, mode)
+	case domainModeRestrict:
+		accounts := splitList(config.DomainLocalAccounts)
+		if !slices.ContainsFunc(accounts, func(a string) bool { return strings.EqualFold(a, user) }) {
+			return fmt.Errorf("account %s not changed, the machine is domain joined and only the %q local accounts are managed", user, accounts)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+)
+
+func TestCheckDomainMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		joined  bool
+		user    string
+		wantErr bool
+	}{
+		{mode: domainModeSkip, joined: false, user: "admin"},
+		{mode: domainModeManage, joined: true, user: "admin"},
+		{mode: domainModeSkip, joined: true, user: "admin", wantErr: true},
+		{mode: domainModeEventsOnly, joined: true, user: "admin", wantErr: true},
+		{mode: domainModeRestrict, joined: true, user: "GCEAdmin"},
+		{mode: domainModeRestrict, joined: true, user: "admin", wantErr: true},
+		{mode: "mange", joined: true, user: "admin", wantErr: true},
+		{mode: "", joined: true, user: "admin", wantErr: true},
+		{mode: "mange", joined: false, user: "admin"},
+	}
+
+	for _, tc := range tests {
+		config := &cfg.WindowsAccounts{DomainMode: tc.mode, DomainLocalAccounts: "gceadmin, breakglass"}
+		if err := checkDomainMode(config, tc.joined, tc.user); (err != nil) != tc.wantErr {
+			t.Errorf("checkDomainMode(%s, %t, %s) = %v, want error: %t", tc.mode, tc.joined, tc.user, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDomainMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: domainModeManage, want: domainModeManage},
+		{mode: domainModeSkip, want: domainModeSkip},
+		{mode: domainModeRestrict, want: domainModeRestrict},
+		{mode: domainModeEventsOnly, want: domainModeEventsOnly},
+		{mode: "Skip", want: domainModeEventsOnly},
+		{mode: "mange", want: domainModeEventsOnly},
+	}
+
+	for _, tc := range tests {
+		if got := domainMode(&cfg.WindowsAccounts{DomainMode: tc.mode}); got != tc.want {
+			t.Errorf("domainMode(%q) = %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+}
@@ -23,13 +23,26 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/features"
 	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
+const (
+	// netlinkFeature is the feature flag programming the forwarded IPs local routes
+	// with rtnetlink instead of the ip command on Linux.
+	netlinkFeature = "network-netlink"
+	// routesRenderingFeature is the feature flag turning off rendering the forwarded
+	// IPs routes as configuration, see routes_mode, they're then programmed directly.
+	routesRenderingFeature = "network-routes-rendering"
+)
+
 var (
 	addressKey       = regKeyBase + `\ForwardedIps`
 	oldWSFCAddresses string
@@ -38,30 +51,37 @@ var (
 
 type addressMgr struct{}
 
-func (a *addressMgr) parseWSFCAddresses(config *cfg.Sections) string {
+func init() {
+	// rtnetlink is rolled out gradually, rendering the routes is opted in with
+	// routes_mode and the flag only allows turning it off without a new release.
+	features.Register(netlinkFeature, false)
+	features.Register(routesRenderingFeature, true)
+}
+
+func (a *addressMgr) parseWSFCAddresses(config *cfg.Sections, md *metadata.Descriptor) string {
 	if config.WSFC != nil && config.WSFC.Addresses != "" {
 		return config.WSFC.Addresses
 	}
-	if newMetadata.Instance.Attributes.WSFCAddresses != "" {
-		return newMetadata.Instance.Attributes.WSFCAddresses
+	if md.Instance.Attributes.WSFCAddresses != "" {
+		return md.Instance.Attributes.WSFCAddresses
 	}
-	if newMetadata.Project.Attributes.WSFCAddresses != "" {
-		return newMetadata.Project.Attributes.WSFCAddresses
+	if md.Project.Attributes.WSFCAddresses != "" {
+		return md.Project.Attributes.WSFCAddresses
 	}
 
 	return ""
 }
 
-func (a *addressMgr) parseWSFCEnable(config *cfg.Sections) bool {
+func (a *addressMgr) parseWSFCEnable(config *cfg.Sections, md *metadata.Descriptor) bool {
 	if config.WSFC != nil {
 		return config.WSFC.Enable
 	}
 
-	if newMetadata.Instance.Attributes.EnableWSFC != nil {
-		return *newMetadata.Instance.Attributes.EnableWSFC
+	if md.Instance.Attributes.EnableWSFC != nil {
+		return *md.Instance.Attributes.EnableWSFC
 	}
-	if newMetadata.Project.Attributes.EnableWSFC != nil {
-		return *newMetadata.Project.Attributes.EnableWSFC
+	if md.Project.Attributes.EnableWSFC != nil {
+		return *md.Project.Attributes.EnableWSFC
 	}
 	return false
 }
@@ -101,6 +121,12 @@ func compareRoutes(configuredRoutes, desiredRoutes []string) (toAdd, toRm []stri
 
 var badMAC []string
 
+// agentRouteMetric is the metric of the routes added by the agent on Windows, which
+// has no route protocol to tag them with, telling them apart from the ones added by
+// an administrator. The metadata server route keeps the default route's metric,
+// it's never removed.
+const agentRouteMetric = 66
+
 // https://www.ietf.org/rfc/rfc1354.txt
 // Only fields that we currently care about.
 type ipForwardEntry struct {
@@ -111,11 +137,26 @@ type ipForwardEntry struct {
 	ipForwardMetric1 int32
 }
 
+// useNetlink returns true if the local routes are programmed with rtnetlink, see
+// addresses_linux.go.
+func useNetlink() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	_, md := metadataSnapshot()
+	return features.Enabled(md, netlinkFeature)
+}
+
 // TODO: getLocalRoutes and getIPForwardEntries should be merged.
 func getLocalRoutes(ctx context.Context, config *cfg.Sections, ifname string) ([]string, error) {
 	if runtime.GOOS == "windows" {
 		return nil, errors.New("getLocalRoutes unimplemented on Windows")
 	}
+	// The routes are programmed with rtnetlink on Linux if enabled, images without
+	// iproute2 are supported.
+	if useNetlink() {
+		return getLocalRoutesNetlink(config.IPForwarding.EthernetProtoID, ifname)
+	}
 
 	protoID := config.IPForwarding.EthernetProtoID
 	args := fmt.Sprintf("route list table local type local scope host dev %s proto %s", ifname, protoID)
@@ -149,19 +190,39 @@ func getLocalRoutes(ctx context.Context, config *cfg.Sections, ifname string) ([
 	return res, nil
 }
 
-// TODO: addLocalRoute and addRoute should be merged with the addition of ipForwardType to ipForwardEntry.
-func addLocalRoute(ctx context.Context, config *cfg.Sections, ip, ifname string) error {
-	if runtime.GOOS == "windows" {
-		return errors.New("addLocalRoute unimplemented on Windows")
+// localRouteArgs returns the ip command arguments to add or delete (op) the local
+// route of ip, an address or a range, on ifname. IPv6 routes are added with the
+// -6 flag and without 'scope host' which is IPv4 only. Linux may use rtnetlink instead,
+// see useNetlink().
+func localRouteArgs(config *cfg.Sections, op, ip, ifname string) []string {
+	protoID := config.IPForwarding.EthernetProtoID
+
+	addr, _, _ := strings.Cut(ip, "/")
+	if netip := net.ParseIP(addr); netip != nil && isIPv6(netip) {
+		if !strings.Contains(ip, "/") {
+			ip = ip + "/128"
+		}
+		args := fmt.Sprintf("-6 route %s to local %s dev %s proto %s", op, ip, ifname, protoID)
+		return strings.Split(args, " ")
 	}
 
 	// TODO: Subnet size should be parsed from alias IP entries.
 	if !strings.Contains(ip, "/") {
 		ip = ip + "/32"
 	}
-	protoID := config.IPForwarding.EthernetProtoID
-	args := fmt.Sprintf("route add to local %s scope host dev %s proto %s", ip, ifname, protoID)
-	return run.Quiet(ctx, "ip", strings.Split(args, " ")...)
+	args := fmt.Sprintf("route %s to local %s scope host dev %s proto %s", op, ip, ifname, protoID)
+	return strings.Split(args, " ")
+}
+
+// TODO: addLocalRoute and addRoute should be merged with the addition of ipForwardType to ipForwardEntry.
+func addLocalRoute(ctx context.Context, config *cfg.Sections, ip, ifname string) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("addLocalRoute unimplemented on Windows")
+	}
+	if useNetlink() {
+		return addLocalRouteNetlink(config.IPForwarding.EthernetProtoID, ip, ifname)
+	}
+	return run.Quiet(ctx, "ip", localRouteArgs(config, "add", ip, ifname)...)
 }
 
 // TODO: removeLocalRoute should be changed to removeIPForwardEntry and match getIPForwardEntries.
@@ -169,22 +230,19 @@ func removeLocalRoute(ctx context.Context, config *cfg.Sections, ip, ifname stri
 	if runtime.GOOS == "windows" {
 		return errors.New("removeLocalRoute unimplemented on Windows")
 	}
-
-	// TODO: Subnet size should be parsed from alias IP entries.
-	if !strings.Contains(ip, "/") {
-		ip = ip + "/32"
+	if useNetlink() {
+		return removeLocalRouteNetlink(config.IPForwarding.EthernetProtoID, ip, ifname)
 	}
-	protoID := config.IPForwarding.EthernetProtoID
-	args := fmt.Sprintf("route delete to local %s scope host dev %s proto %s", ip, ifname, protoID)
-	return run.Quiet(ctx, "ip", strings.Split(args, " ")...)
+	return run.Quiet(ctx, "ip", localRouteArgs(config, "delete", ip, ifname)...)
 }
 
 // Filter out forwarded ips based on WSFC (Windows Failover Cluster Settings).
 // If only EnableWSFC is set, all ips in the ForwardedIps and TargetInstanceIps will be ignored.
 // If WSFCAddresses is set (with or without EnableWSFC), only ips in the list will be filtered out.
-// TODO return a filtered list rather than modifying the metadata object. liamh@15-11-19
-func (a *addressMgr) applyWSFCFilter(config *cfg.Sections) {
-	wsfcAddresses := a.parseWSFCAddresses(config)
+// The metadata isn't modified, the filtered network interfaces are returned.
+func (a *addressMgr) applyWSFCFilter(config *cfg.Sections, md *metadata.Descriptor) []metadata.NetworkInterfaces {
+	interfaces := slices.Clone(md.Instance.NetworkInterfaces)
+	wsfcAddresses := a.parseWSFCAddresses(config, md)
 
 	var wsfcAddrs []string
 	for _, wsfcAddr := range strings.Split(wsfcAddresses, ",") {
@@ -201,7 +259,6 @@ func (a *addressMgr) applyWSFCFilter(config *cfg.Sections) {
 	}
 
 	if len(wsfcAddrs) != 0 {
-		interfaces := newMetadata.Instance.NetworkInterfaces
 		for idx := range interfaces {
 			var filteredForwardedIps []string
 			for _, ip := range interfaces[idx].ForwardedIps {
@@ -220,28 +277,29 @@ func (a *addressMgr) applyWSFCFilter(config *cfg.Sections) {
 			interfaces[idx].TargetInstanceIps = filteredTargetInstanceIps
 		}
 	} else {
-		wsfcEnable := a.parseWSFCEnable(config)
+		wsfcEnable := a.parseWSFCEnable(config, md)
 		if wsfcEnable {
-			for idx := range newMetadata.Instance.NetworkInterfaces {
-				newMetadata.Instance.NetworkInterfaces[idx].ForwardedIps = nil
-				newMetadata.Instance.NetworkInterfaces[idx].TargetInstanceIps = nil
+			for idx := range interfaces {
+				interfaces[idx].ForwardedIps = nil
+				interfaces[idx].TargetInstanceIps = nil
 			}
 		}
 	}
+	return interfaces
 }
 
-func (a *addressMgr) Diff(ctx context.Context) (bool, error) {
+func (a *addressMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
 	// Return true if this is the first call (when the first mds descriptor is available).
-	if oldMetadata == nil {
+	if oldMd == nil {
 		return true, nil
 	}
 
 	config := cfg.Get()
-	wsfcAddresses := a.parseWSFCAddresses(config)
-	wsfcEnable := a.parseWSFCEnable(config)
+	wsfcAddresses := a.parseWSFCAddresses(config, newMd)
+	wsfcEnable := a.parseWSFCEnable(config, newMd)
 
-	diff := !reflect.DeepEqual(newMetadata.Instance.NetworkInterfaces, oldMetadata.Instance.NetworkInterfaces) ||
-		!reflect.DeepEqual(newMetadata.Instance.VlanNetworkInterfaces, oldMetadata.Instance.VlanNetworkInterfaces) ||
+	diff := !reflect.DeepEqual(newMd.Instance.NetworkInterfaces, oldMd.Instance.NetworkInterfaces) ||
+		!reflect.DeepEqual(newMd.Instance.VlanNetworkInterfaces, oldMd.Instance.VlanNetworkInterfaces) ||
 		wsfcEnable != oldWSFCEnable || wsfcAddresses != oldWSFCAddresses
 
 	oldWSFCAddresses = wsfcAddresses
@@ -253,7 +311,7 @@ func (a *addressMgr) Timeout(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (a *addressMgr) Disabled(ctx context.Context) (bool, error) {
+func (a *addressMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	config := cfg.Get()
 
 	// Local configuration takes precedence over metadata's configuration.
@@ -261,11 +319,11 @@ func (a *addressMgr) Disabled(ctx context.Context) (bool, error) {
 		return config.AddressManager.Disable, nil
 	}
 
-	if newMetadata.Instance.Attributes.DisableAddressManager != nil {
-		return *newMetadata.Instance.Attributes.DisableAddressManager, nil
+	if newMd.Instance.Attributes.DisableAddressManager != nil {
+		return *newMd.Instance.Attributes.DisableAddressManager, nil
 	}
-	if newMetadata.Project.Attributes.DisableAddressManager != nil {
-		return *newMetadata.Project.Attributes.DisableAddressManager, nil
+	if newMd.Project.Attributes.DisableAddressManager != nil {
+		return *newMd.Project.Attributes.DisableAddressManager, nil
 	}
 
 	// This is the linux config key, defaulting to true. On Linux, the
@@ -273,88 +331,140 @@ func (a *addressMgr) Disabled(ctx context.Context) (bool, error) {
 	return !config.Daemons.NetworkDaemon, nil
 }
 
-func (a *addressMgr) Set(ctx context.Context) error {
+func (a *addressMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
 	config := cfg.Get()
 
-	if runtime.GOOS == "windows" {
-		a.applyWSFCFilter(config)
+	// The changes are applied as a transaction, rolled back if they cut the agent off
+	// the metadata server.
+	var before networkState
+	rollback := rollbackEnabled(config, newMd)
+	if rollback {
+		if delay := rollbackBackoff(newMd); delay > 0 {
+			return fmt.Errorf("network interfaces changes rolled back, not re-applying them for %s", delay.Round(time.Second))
+		}
+		before = a.snapshotNetworkState(ctx, config, newMd)
 	}
 
 	// Guest Agent does not manage interfaces on Windows.
 	if runtime.GOOS != "windows" {
 		// Setup network interfaces.
-		err := network.SetupInterfaces(ctx, config, newMetadata)
+		err := network.SetupInterfaces(ctx, config, newMd)
 		if err != nil {
 			return fmt.Errorf("failed to setup network interfaces: %v", err)
 		}
+		resetHotpluggedNICs()
+	} else {
+		setupVlanAdapters(ctx, config, newMd)
 	}
 
-	if !config.NetworkInterfaces.IPForwarding {
+	tuneNICs(ctx, config, newMd)
+	applyMTU(ctx, config, newMd)
+	a.applyForwardedIPs(ctx, config, newMd)
+
+	if rollback {
+		return a.verifyNetworkChanges(ctx, config, newMd, before)
+	}
+	return nil
+}
+
+// networkChanged re-applies the forwarded IPs after a network configuration change,
+// i.e. a NIC hotplug or a DHCP renewal wiping the routes, instead of waiting for the
+// next metadata change.
+func (a *addressMgr) networkChanged(ctx context.Context, evType string, evData *events.EventData) {
+	if evData.Error != nil {
+		logger.Debugf("Network change watcher %q failed: %+v", evType, evData.Error)
+		return
+	}
+
+	md := a.reapplyMetadata(ctx)
+	if md == nil {
+		return
+	}
+
+	config := cfg.Get()
+	if config.IPForwarding == nil || !config.IPForwarding.WatchNetworkChanges {
+		return
+	}
+
+	logger.Debugf("Network configuration changed (%s), re-applying forwarded IPs.", evType)
+	a.applyForwardedIPs(ctx, config, md)
+}
+
+// verifyForwardedIPs periodically re-applies the forwarded IPs missing, i.e. routes
+// removed by a third party tool not producing any network change event.
+func (a *addressMgr) verifyForwardedIPs(ctx context.Context, evType string, evData *events.EventData) {
+	if evData.Error != nil {
+		logger.Debugf("Forwarded IPs verification timer %q failed: %+v", evType, evData.Error)
+		return
+	}
+
+	md := a.reapplyMetadata(ctx)
+	if md == nil {
+		return
+	}
+
+	logger.Debugf("Verifying forwarded IPs.")
+	a.applyForwardedIPs(ctx, cfg.Get(), md)
+}
+
+// reapplyMetadata returns the metadata to re-apply the forwarded IPs with outside of
+// the manager's Set(), nil if the metadata wasn't fetched yet or the manager is
+// disabled.
+func (a *addressMgr) reapplyMetadata(ctx context.Context) *metadata.Descriptor {
+	// No metadata yet, the first metadata event applies everything.
+	_, md := metadataSnapshot()
+	if md == nil {
 		return nil
 	}
 
+	if disabled, err := a.Disabled(ctx, md); err != nil || disabled {
+		return nil
+	}
+	// The rolled back changes wait for their backoff.
+	if rollbackEnabled(cfg.Get(), md) && rollbackBackoff(md) > 0 {
+		return nil
+	}
+	return md
+}
+
+// forwardedInterfaces returns the network interfaces of md whose forwarded IPs are
+// managed, the WSFC addresses are filtered out on Windows.
+func (a *addressMgr) forwardedInterfaces(config *cfg.Sections, md *metadata.Descriptor) []metadata.NetworkInterfaces {
+	if runtime.GOOS == "windows" {
+		return a.applyWSFCFilter(config, md)
+	}
+	return md.Instance.NetworkInterfaces
+}
+
+// applyForwardedIPs adds the routes (or addresses on Windows) of the IP aliases,
+// forwarded and target-instance IPs of md missing and removes the ones no longer
+// wanted, along with their firewall rules if enabled.
+func (a *addressMgr) applyForwardedIPs(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) {
+	a.applyForwardedIPsFirewall(ctx, config, md)
+	if !config.NetworkInterfaces.IPForwarding {
+		return
+	}
+
+	if runtime.GOOS == "linux" {
+		if network.RendersRoutes(config.IPForwarding.RoutesMode) && features.Enabled(md, routesRenderingFeature) {
+			a.renderForwardedIPs(ctx, config, md)
+			return
+		}
+		// Remove the routes rendered before switching to the imperative mode.
+		if _, err := network.RenderLocalRoutes(ctx, network.RoutesModeImperative, "", nil); err != nil {
+			logger.Errorf("Failed to remove the rendered forwarded IPs routes: %v", err)
+		}
+	}
+
 	logger.Debugf("Add routes for aliases, forwarded IP and target-instance IPs")
 	// Add routes for IP aliases, forwarded and target-instance IPs.
-	for _, ni := range newMetadata.Instance.NetworkInterfaces {
-		iface, err := network.GetInterfaceByMAC(ni.Mac)
+	for _, ni := range a.forwardedInterfaces(config, md) {
+		r, err := nicForwardedIPs(ctx, config, ni)
 		if err != nil {
-			if !slices.Contains(badMAC, ni.Mac) {
-				logger.Errorf("Error getting interface: %s", err)
-				badMAC = append(badMAC, ni.Mac)
-			}
 			continue
 		}
-		wantIPs := ni.ForwardedIps
-		wantIPs = append(wantIPs, ni.ForwardedIpv6s...)
-		if config.IPForwarding.TargetInstanceIPs {
-			wantIPs = append(wantIPs, ni.TargetInstanceIps...)
-		}
-		// IP Aliases are not supported on windows.
-		if runtime.GOOS != "windows" && config.IPForwarding.IPAliases {
-			wantIPs = append(wantIPs, ni.IPAliases...)
-		}
-
-		var forwardedIPs []string
-		var configuredIPs []string
-		if runtime.GOOS == "windows" {
-			addrs, err := iface.Addrs()
-			if err != nil {
-				logger.Errorf("Error getting addresses for interface %s: %s", iface.Name, err)
-			}
-			for _, addr := range addrs {
-				configuredIPs = append(configuredIPs, strings.TrimSuffix(addr.String(), "/32"))
-			}
-			regFwdIPs, err := getForwardsFromRegistry(ni.Mac)
-			if err != nil {
-				logger.Errorf("Error getting forwards from registry: %s", err)
-				continue
-			}
-			for _, ip := range configuredIPs {
-				// Only add to `forwardedIPs` if it is recorded in the registry.
-				if slices.Contains(regFwdIPs, ip) {
-					forwardedIPs = append(forwardedIPs, ip)
-				}
-			}
-		} else {
-			forwardedIPs, err = getLocalRoutes(ctx, config, iface.Name)
-			if err != nil {
-				logger.Errorf("Error getting routes: %v", err)
-				continue
-			}
-		}
-
-		// Trims any '/32' suffix for consistency.
-		trimSuffix := func(entries []string) []string {
-			var res []string
-			for _, entry := range entries {
-				res = append(res, strings.TrimSuffix(entry, "/32"))
-			}
-			return res
-		}
-		forwardedIPs = trimSuffix(forwardedIPs)
-		wantIPs = trimSuffix(wantIPs)
-
-		toAdd, toRm := compareRoutes(forwardedIPs, wantIPs)
+		iface, wantIPs, forwardedIPs, configuredIPs := r.iface, r.wantIPs, r.forwardedIPs, r.configuredIPs
+		toAdd, toRm := r.changes()
 
 		if len(toAdd) != 0 || len(toRm) != 0 {
 			var msg string
@@ -373,6 +483,10 @@ func (a *addressMgr) Set(ctx context.Context) error {
 
 		var registryEntries []string
 		for _, ip := range wantIPs {
+			// The foreign IPs aren't recorded as forwarded by the agent.
+			if slices.Contains(r.foreignIPs, ip) {
+				continue
+			}
 			// If the IP is not in toAdd, add to registry list and continue.
 			if !slices.Contains(toAdd, ip) {
 				registryEntries = append(registryEntries, ip)
@@ -382,7 +496,7 @@ func (a *addressMgr) Set(ctx context.Context) error {
 			if runtime.GOOS == "windows" {
 				// Don't addAddress if this is already configured.
 				if !slices.Contains(configuredIPs, ip) {
-					// In case of forwardedIpv6 we get IPV6 CIDR and Parse IP will return nil.
+					// IPv6 ranges (not parsed by ParseIP) and addresses are added with their prefix.
 					netip := net.ParseIP(ip)
 					if netip != nil && !isIPv6(netip) {
 						// Retains existing behavior for ipv4 addresses.
@@ -396,6 +510,7 @@ func (a *addressMgr) Set(ctx context.Context) error {
 			}
 			if err == nil {
 				registryEntries = append(registryEntries, ip)
+				owned.recordRoute(iface.Name, ip)
 			} else {
 				logger.Errorf("error adding route: %v", err)
 			}
@@ -408,7 +523,7 @@ func (a *addressMgr) Set(ctx context.Context) error {
 					continue
 				}
 				netip := net.ParseIP(ip)
-				// In case of forwardedIpv6 we get IPV6 CIDR and Parse IP will return nil.
+				// IPv6 ranges (not parsed by ParseIP) and addresses are removed with their prefix.
 				if netip != nil && !isIPv6(netip) {
 					// Retains existing behavior for ipv4 addresses.
 					err = removeAddress(netip, net.IPv4Mask(255, 255, 255, 255), uint32(iface.Index))
@@ -422,7 +537,9 @@ func (a *addressMgr) Set(ctx context.Context) error {
 				logger.Errorf("error removing route: %v", err)
 				// Add IPs we fail to remove to registry to maintain accurate record.
 				registryEntries = append(registryEntries, ip)
+				continue
 			}
+			owned.forgetRoute(iface.Name, ip)
 		}
 
 		if runtime.GOOS == "windows" {
@@ -432,8 +549,209 @@ func (a *addressMgr) Set(ctx context.Context) error {
 		}
 	}
 	logger.Infof("Completed adding/removing routes for aliases, forwarded IP and target-instance IPs")
+}
 
-	return nil
+// renderForwardedIPs renders the local routes of the IP aliases, forwarded and
+// target-instance IPs of md as systemd-networkd, netplan or NetworkManager
+// configuration, see routes_mode, for the network stacks overwriting the routes
+// programmed directly.
+func (a *addressMgr) renderForwardedIPs(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) {
+	routes := make(map[string][]string)
+	for _, ni := range a.forwardedInterfaces(config, md) {
+		iface, err := network.GetInterfaceByMAC(ni.Mac)
+		if err != nil {
+			if !slices.Contains(badMAC, ni.Mac) {
+				logger.Errorf("Error getting interface: %s", err)
+				badMAC = append(badMAC, ni.Mac)
+			}
+			continue
+		}
+		// The NICs without forwarded IPs are kept to remove their routes.
+		routes[iface.Name] = []string{}
+		for _, ip := range wantedForwardedIPs(config, ni) {
+			routes[iface.Name] = append(routes[iface.Name], hostPrefix(ip))
+		}
+	}
+
+	files, err := network.RenderLocalRoutes(ctx, config.IPForwarding.RoutesMode, config.IPForwarding.EthernetProtoID, routes)
+	if err != nil {
+		logger.Errorf("Failed to render the forwarded IPs routes: %v", err)
+	}
+	for _, file := range files {
+		owned.recordFile(file)
+	}
+}
+
+// DryRun returns the forwarded IPs Set() would add or remove by NIC, the network
+// interfaces setup isn't covered.
+func (a *addressMgr) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	config := cfg.Get()
+	if !config.NetworkInterfaces.IPForwarding {
+		return nil, nil
+	}
+	if rollbackEnabled(config, newMd) && rollbackBackoff(newMd) > 0 {
+		return nil, nil
+	}
+
+	var changes []string
+	for _, ni := range a.forwardedInterfaces(config, newMd) {
+		r, err := nicForwardedIPs(ctx, config, ni)
+		if err != nil {
+			changes = append(changes, fmt.Sprintf("can't update the forwarded IPs of %s: %v", ni.Mac, err))
+			continue
+		}
+		toAdd, toRm := r.changes()
+		for _, ip := range toAdd {
+			changes = append(changes, fmt.Sprintf("add forwarded IP %s on %s", ip, r.iface.Name))
+		}
+		for _, ip := range toRm {
+			changes = append(changes, fmt.Sprintf("remove forwarded IP %s from %s", ip, r.iface.Name))
+		}
+	}
+	return changes, nil
+}
+
+// nicRoutes holds the forwarded IPs state of a NIC.
+type nicRoutes struct {
+	// iface is the interface matching the NIC's MAC address.
+	iface net.Interface
+	// wantIPs are the IPs that should be forwarded according to the metadata.
+	wantIPs []string
+	// forwardedIPs are the IPs currently forwarded.
+	forwardedIPs []string
+	// configuredIPs are the addresses configured on the interface, only set on Windows.
+	configuredIPs []string
+	// foreignIPs are the IPs forwarded by someone else than the agent, i.e. an
+	// administrator, the agent doesn't take them over.
+	foreignIPs []string
+}
+
+// changes returns the IPs to forward and the ones to stop forwarding. The IPs
+// already forwarded by someone else are left alone, taking them over would
+// eventually remove them.
+func (r *nicRoutes) changes() ([]string, []string) {
+	toAdd, toRm := compareRoutes(r.forwardedIPs, r.wantIPs)
+	toAdd = slices.DeleteFunc(toAdd, func(ip string) bool { return slices.Contains(r.foreignIPs, ip) })
+	return toAdd, toRm
+}
+
+// nicForwardedIPs computes the forwarded IPs wanted and currently set for the NIC ni.
+func nicForwardedIPs(ctx context.Context, config *cfg.Sections, ni metadata.NetworkInterfaces) (*nicRoutes, error) {
+	iface, err := network.GetInterfaceByMAC(ni.Mac)
+	if err != nil {
+		if !slices.Contains(badMAC, ni.Mac) {
+			logger.Errorf("Error getting interface: %s", err)
+			badMAC = append(badMAC, ni.Mac)
+		}
+		return nil, err
+	}
+	wantIPs := wantedForwardedIPs(config, ni)
+
+	var forwardedIPs []string
+	var configuredIPs []string
+	var foreignIPs []string
+	if runtime.GOOS == "windows" {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			logger.Errorf("Error getting addresses for interface %s: %s", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			configuredIPs = append(configuredIPs, trimHostPrefix(addr.String()))
+		}
+		regFwdIPs, err := getForwardsFromRegistry(ni.Mac)
+		if err != nil {
+			logger.Errorf("Error getting forwards from registry: %s", err)
+			return nil, err
+		}
+		for _, ip := range configuredIPs {
+			// Only add to `forwardedIPs` if it is recorded in the registry.
+			if slices.Contains(regFwdIPs, ip) {
+				forwardedIPs = append(forwardedIPs, ip)
+			} else {
+				foreignIPs = append(foreignIPs, ip)
+			}
+		}
+	} else {
+		forwardedIPs, err = getLocalRoutes(ctx, config, iface.Name)
+		if err != nil {
+			logger.Errorf("Error getting routes: %v", err)
+			return nil, err
+		}
+		// Only the routes programmed with rtnetlink tell apart the foreign ones.
+		if runtime.GOOS == "linux" {
+			foreignIPs, err = getForeignLocalRoutesNetlink(config.IPForwarding.EthernetProtoID, iface.Name)
+			if err != nil {
+				logger.Errorf("Error getting routes: %v", err)
+				return nil, err
+			}
+		}
+	}
+
+	// Trims any '/32' and '/128' suffix for consistency.
+	trimSuffix := func(entries []string) []string {
+		var res []string
+		for _, entry := range entries {
+			res = append(res, trimHostPrefix(entry))
+		}
+		return res
+	}
+
+	return &nicRoutes{
+		iface:         iface,
+		wantIPs:       trimSuffix(wantIPs),
+		forwardedIPs:  trimSuffix(forwardedIPs),
+		configuredIPs: configuredIPs,
+		foreignIPs:    trimSuffix(foreignIPs),
+	}, nil
+}
+
+// wantedForwardedIPs returns the IPv4 and IPv6 IP aliases, forwarded and
+// target-instance IPs of ni enabled by config.
+func wantedForwardedIPs(config *cfg.Sections, ni metadata.NetworkInterfaces) []string {
+	wantIPs := slices.Clone(ni.ForwardedIps)
+	if config.IPForwarding.ForwardedIPv6s {
+		wantIPs = append(wantIPs, ni.ForwardedIpv6s...)
+	}
+	if config.IPForwarding.TargetInstanceIPs {
+		wantIPs = append(wantIPs, ni.TargetInstanceIps...)
+	}
+	// IP Aliases are not supported on windows.
+	if runtime.GOOS != "windows" && config.IPForwarding.IPAliases {
+		wantIPs = append(wantIPs, ni.IPAliases...)
+	}
+	if runtime.GOOS != "windows" && config.IPForwarding.IPv6Aliases {
+		wantIPs = append(wantIPs, ni.IPv6Aliases...)
+	}
+	return wantIPs
+}
+
+// trimHostPrefix trims the '/32' or '/128' suffix of a single address.
+func trimHostPrefix(ip string) string {
+	if strings.Contains(ip, ":") {
+		return strings.TrimSuffix(ip, "/128")
+	}
+	return strings.TrimSuffix(ip, "/32")
+}
+
+// hostPrefix returns ip in CIDR notation, a single address gets the '/32' or
+// '/128' prefix length.
+func hostPrefix(ip string) string {
+	if strings.Contains(ip, "/") {
+		return ip
+	}
+	if strings.Contains(ip, ":") {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
+
+// parseIPv6Prefix parses s, an IPv6 range or a single IPv6 address without a
+// prefix length, i.e. one trimmed by trimHostPrefix.
+func parseIPv6Prefix(s string) (net.IP, *net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		s += "/128"
+	}
+	return net.ParseCIDR(s)
 }
 
 // isIPv6 returns true if the IP address is an IPv6 address.
@@ -443,7 +761,7 @@ func isIPv6(ip net.IP) bool {
 
 // addIpv6Address adds given IP on the provided network interface.
 func addIpv6Address(s string, idx uint32) error {
-	ip, n, err := net.ParseCIDR(s)
+	ip, n, err := parseIPv6Prefix(s)
 	if err != nil {
 		return err
 	}
@@ -452,7 +770,7 @@ func addIpv6Address(s string, idx uint32) error {
 
 // removeIpv6Address removes the given IP on the network interface.
 func removeIpv6Address(s string, idx uint32) error {
-	ip, n, err := net.ParseCIDR(s)
+	ip, n, err := parseIPv6Prefix(s)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,297 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// rtnetlinkSeq is the sequence number of the last rtnetlink request.
+	rtnetlinkSeq atomic.Uint32
+)
+
+// rtnetlinkRequest sends a rtnetlink request of msgType with data as payload and
+// returns the replies, for dump requests all of them until the done message. The
+// kernel's errors are returned as syscall.Errno.
+func rtnetlinkRequest(msgType uint16, flags uint16, data []byte) ([]syscall.NetlinkMessage, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	seq := rtnetlinkSeq.Add(1)
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(data))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.SizeofNlMsghdr+len(data)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|flags)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, data...)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to send netlink request: %w", err)
+	}
+
+	var res []syscall.NetlinkMessage
+	for {
+		// The parsed messages reference buf, it's not reused across reads.
+		buf := make([]byte, unix.Getpagesize()*4)
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read netlink reply: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse netlink reply: %w", err)
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return res, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, errors.New("truncated netlink error reply")
+				}
+				// A zero error is the acknowledgment of a non dump request.
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+				return res, nil
+			default:
+				res = append(res, m)
+			}
+		}
+
+		if flags&unix.NLM_F_DUMP == 0 {
+			return res, nil
+		}
+	}
+}
+
+// rtAttr returns the route attribute attrType with value, padded to 4 bytes.
+func rtAttr(attrType uint16, value []byte) []byte {
+	attr := make([]byte, unix.SizeofRtAttr, rtaAlign(unix.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(unix.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(attr[2:4], attrType)
+	attr = append(attr, value...)
+	return attr[:cap(attr)]
+}
+
+// rtaAlign rounds length up to the route attributes' 4 bytes alignment.
+func rtaAlign(length int) int {
+	return (length + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
+
+// localRoute is a local route of a forwarded IP, see localRouteArgs().
+type localRoute struct {
+	// dst is the route's destination address.
+	dst net.IP
+	// dstLen is the destination's prefix length.
+	dstLen int
+	// ifindex is the index of the interface the route is on.
+	ifindex int
+	// proto is the protocol identifying the routes added by the agent.
+	proto uint8
+}
+
+// newLocalRoute returns the local route of ip, an address or a range, on ifname.
+func newLocalRoute(protoID, ip, ifname string) (*localRoute, error) {
+	proto, err := strconv.ParseUint(protoID, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ethernet proto id %q: %w", protoID, err)
+	}
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	route := &localRoute{ifindex: iface.Index, proto: uint8(proto)}
+	if strings.Contains(ip, "/") {
+		_, ipnet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, err
+		}
+		route.dst = ipnet.IP
+		route.dstLen, _ = ipnet.Mask.Size()
+	} else {
+		route.dst = net.ParseIP(ip)
+		if route.dst == nil {
+			return nil, fmt.Errorf("invalid IP address %q", ip)
+		}
+		route.dstLen = net.IPv6len * 8
+	}
+
+	if ipv4 := route.dst.To4(); ipv4 != nil {
+		route.dst = ipv4
+		route.dstLen = min(route.dstLen, net.IPv4len*8)
+	}
+	return route, nil
+}
+
+// family returns the route's address family.
+func (r *localRoute) family() uint8 {
+	if len(r.dst) == net.IPv4len {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// marshal returns the route's rtmsg and attributes. IPv4 routes are scoped to the
+// host, the scope is IPv4 only.
+func (r *localRoute) marshal() []byte {
+	scope := uint8(unix.RT_SCOPE_UNIVERSE)
+	if r.family() == unix.AF_INET {
+		scope = unix.RT_SCOPE_HOST
+	}
+
+	msg := []byte{r.family(), uint8(r.dstLen), 0, 0, unix.RT_TABLE_LOCAL, r.proto, scope, unix.RTN_LOCAL, 0, 0, 0, 0}
+	msg = append(msg, rtAttr(unix.RTA_DST, r.dst)...)
+	oif := make([]byte, 4)
+	binary.NativeEndian.PutUint32(oif, uint32(r.ifindex))
+	return append(msg, rtAttr(unix.RTA_OIF, oif)...)
+}
+
+// String returns the route's destination like the ip command does, without the
+// prefix length of single addresses.
+func (r *localRoute) String() string {
+	if r.dstLen == len(r.dst)*8 {
+		return r.dst.String()
+	}
+	return fmt.Sprintf("%s/%d", r.dst, r.dstLen)
+}
+
+// addLocalRouteNetlink adds the local route of ip on ifname, an existing route isn't
+// an error. The existing route isn't replaced, it could be one added by an
+// administrator the agent would then take over and eventually remove.
+func addLocalRouteNetlink(protoID, ip, ifname string) error {
+	route, err := newLocalRoute(protoID, ip, ifname)
+	if err != nil {
+		return err
+	}
+	_, err = rtnetlinkRequest(unix.RTM_NEWROUTE, unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_EXCL, route.marshal())
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add local route %s on %s: %w", route, ifname, err)
+	}
+	return nil
+}
+
+// removeLocalRouteNetlink removes the local route of ip on ifname, a missing
+// route isn't an error.
+func removeLocalRouteNetlink(protoID, ip, ifname string) error {
+	route, err := newLocalRoute(protoID, ip, ifname)
+	if err != nil {
+		return err
+	}
+	_, err = rtnetlinkRequest(unix.RTM_DELROUTE, unix.NLM_F_ACK, route.marshal())
+	if err != nil && !errors.Is(err, unix.ESRCH) {
+		return fmt.Errorf("failed to remove local route %s on %s: %w", route, ifname, err)
+	}
+	return nil
+}
+
+// getLocalRoutesNetlink returns the local routes on ifname added with protoID,
+// IPv4 and IPv6.
+func getLocalRoutesNetlink(protoID, ifname string) ([]string, error) {
+	return filterLocalRoutesNetlink(protoID, ifname, true)
+}
+
+// getForeignLocalRoutesNetlink returns the local routes on ifname not added with
+// protoID, i.e. added by an administrator, IPv4 and IPv6.
+func getForeignLocalRoutesNetlink(protoID, ifname string) ([]string, error) {
+	return filterLocalRoutesNetlink(protoID, ifname, false)
+}
+
+// filterLocalRoutesNetlink returns the local routes on ifname added with protoID if
+// owned is true, the ones added otherwise if it's false.
+func filterLocalRoutesNetlink(protoID, ifname string, owned bool) ([]string, error) {
+	proto, err := strconv.ParseUint(protoID, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ethernet proto id %q: %w", protoID, err)
+	}
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		msgs, err := rtnetlinkRequest(unix.RTM_GETROUTE, unix.NLM_F_DUMP, []byte{family, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes: %w", err)
+		}
+		for _, msg := range msgs {
+			if route := parseLocalRoute(msg); route != nil && route.ifindex == iface.Index && (route.proto == uint8(proto)) == owned {
+				res = append(res, route.String())
+			}
+		}
+	}
+	return res, nil
+}
+
+// parseLocalRoute parses the route of msg, nil if it's not a local route of the
+// local table.
+func parseLocalRoute(msg syscall.NetlinkMessage) *localRoute {
+	if msg.Header.Type != unix.RTM_NEWROUTE || len(msg.Data) < unix.SizeofRtMsg {
+		return nil
+	}
+	family, dstLen, table, proto, scope, routeType := msg.Data[0], msg.Data[1], uint32(msg.Data[4]), msg.Data[5], msg.Data[6], msg.Data[7]
+	if routeType != unix.RTN_LOCAL || (family == unix.AF_INET && scope != unix.RT_SCOPE_HOST) {
+		return nil
+	}
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+	if err != nil {
+		return nil
+	}
+	route := &localRoute{dstLen: int(dstLen), proto: proto}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.RTA_DST:
+			route.dst = net.IP(attr.Value)
+		case unix.RTA_OIF:
+			if len(attr.Value) >= 4 {
+				route.ifindex = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		case unix.RTA_TABLE:
+			if len(attr.Value) >= 4 {
+				table = binary.NativeEndian.Uint32(attr.Value)
+			}
+		}
+	}
+	if table != unix.RT_TABLE_LOCAL || route.dst == nil {
+		return nil
+	}
+	return route
+}
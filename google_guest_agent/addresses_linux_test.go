@@ -0,0 +1,198 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+func TestLocalRouteMarshal(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+
+	tests := []struct {
+		ip     string
+		want   string
+		family uint8
+	}{
+		{ip: "10.0.0.1", want: "10.0.0.1", family: unix.AF_INET},
+		{ip: "10.0.0.0/24", want: "10.0.0.0/24", family: unix.AF_INET},
+		{ip: "2600:1900::1", want: "2600:1900::1", family: unix.AF_INET6},
+		{ip: "2600:1900::/96", want: "2600:1900::/96", family: unix.AF_INET6},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ip, func(t *testing.T) {
+			route, err := newLocalRoute("66", tc.ip, "lo")
+			if err != nil {
+				t.Fatalf("newLocalRoute(%q) failed: %v", tc.ip, err)
+			}
+			if route.family() != tc.family {
+				t.Errorf("newLocalRoute(%q).family() = %d, want %d", tc.ip, route.family(), tc.family)
+			}
+
+			msg := syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: unix.RTM_NEWROUTE}, Data: route.marshal()}
+			got := parseLocalRoute(msg)
+			if got == nil {
+				t.Fatalf("parseLocalRoute() of the marshaled %q route returned nil", tc.ip)
+			}
+			if got.String() != tc.want || got.ifindex != lo.Index || got.proto != 66 {
+				t.Errorf("parseLocalRoute() = %s on %d proto %d, want %s on %d proto 66", got, got.ifindex, got.proto, tc.want, lo.Index)
+			}
+		})
+	}
+
+	if _, err := newLocalRoute("not-a-number", "10.0.0.1", "lo"); err == nil {
+		t.Errorf("newLocalRoute() with an invalid proto id succeeded, want error")
+	}
+}
+
+func TestLocalRouteNetlink(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Programming routes requires root")
+	}
+
+	// TEST-NET-1 and TEST-NET-2 addresses on the loopback interface.
+	ips := []string{"192.0.2.1", "198.51.100.0/24"}
+	for _, ip := range ips {
+		if err := addLocalRouteNetlink("66", ip, "lo"); err != nil {
+			if errors.Is(err, unix.EPERM) {
+				t.Skipf("Not allowed to program routes: %v", err)
+			}
+			t.Fatalf("addLocalRouteNetlink(%q) failed: %v", ip, err)
+		}
+		defer removeLocalRouteNetlink("66", ip, "lo")
+	}
+	// Adding an existing route is a no-op.
+	if err := addLocalRouteNetlink("66", ips[0], "lo"); err != nil {
+		t.Errorf("addLocalRouteNetlink(%q) of an existing route failed: %v", ips[0], err)
+	}
+
+	got, err := getLocalRoutesNetlink("66", "lo")
+	if err != nil {
+		t.Fatalf("getLocalRoutesNetlink() failed: %v", err)
+	}
+	slices.Sort(got)
+	if diff := cmp.Diff(ips, got); diff != "" {
+		t.Errorf("getLocalRoutesNetlink() returned unexpected routes (-want +got):\n%s", diff)
+	}
+
+	for _, ip := range ips {
+		if err := removeLocalRouteNetlink("66", ip, "lo"); err != nil {
+			t.Errorf("removeLocalRouteNetlink(%q) failed: %v", ip, err)
+		}
+	}
+	// Removing a missing route isn't an error.
+	if err := removeLocalRouteNetlink("66", ips[0], "lo"); err != nil {
+		t.Errorf("removeLocalRouteNetlink(%q) of a missing route failed: %v", ips[0], err)
+	}
+
+	if got, err := getLocalRoutesNetlink("66", "lo"); err != nil || len(got) != 0 {
+		t.Errorf("getLocalRoutesNetlink() after removal = (%v, %v), want no routes", got, err)
+	}
+}
+
+func TestForeignLocalRouteNetlink(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Programming routes requires root")
+	}
+
+	// A TEST-NET-3 route added by an administrator, with another protocol.
+	ip := "203.0.113.1"
+	if err := addLocalRouteNetlink("67", ip, "lo"); err != nil {
+		if errors.Is(err, unix.EPERM) {
+			t.Skipf("Not allowed to program routes: %v", err)
+		}
+		t.Fatalf("addLocalRouteNetlink(%q) failed: %v", ip, err)
+	}
+	defer removeLocalRouteNetlink("67", ip, "lo")
+
+	// The agent neither takes the route over nor removes it.
+	if err := addLocalRouteNetlink("66", ip, "lo"); err != nil {
+		t.Errorf("addLocalRouteNetlink(%q) of a foreign route failed: %v", ip, err)
+	}
+	if err := removeLocalRouteNetlink("66", ip, "lo"); err != nil {
+		t.Errorf("removeLocalRouteNetlink(%q) of a foreign route failed: %v", ip, err)
+	}
+
+	if got, err := getLocalRoutesNetlink("66", "lo"); err != nil || len(got) != 0 {
+		t.Errorf("getLocalRoutesNetlink() = (%v, %v), want no routes", got, err)
+	}
+	got, err := getForeignLocalRoutesNetlink("66", "lo")
+	if err != nil {
+		t.Fatalf("getForeignLocalRoutesNetlink() failed: %v", err)
+	}
+	if !slices.Contains(got, ip) {
+		t.Errorf("getForeignLocalRoutesNetlink() = %v, want %s included", got, ip)
+	}
+}
+
+func TestUseNetlink(t *testing.T) {
+	_, origMd := metadataSnapshot()
+	t.Cleanup(func() { setNewMetadata(origMd) })
+	setNewMetadata(nil)
+
+	tests := []struct {
+		name   string
+		config string
+		md     *metadata.Descriptor
+		want   bool
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:   "config_enabled",
+			config: "[Features]\nnetwork-netlink = true",
+			want:   true,
+		},
+		{
+			name: "metadata_enabled",
+			md:   &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{GuestAgentFeatures: "network-netlink=100%"}}},
+			want: true,
+		},
+		{
+			name:   "config_overrides_metadata",
+			config: "[Features]\nnetwork-netlink = false",
+			md:     &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{GuestAgentFeatures: "network-netlink=true"}}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := cfg.Load([]byte(tc.config)); err != nil {
+				t.Fatalf("cfg.Load() = %v, want nil", err)
+			}
+			setNewMetadata(tc.md)
+			if got := useNetlink(); got != tc.want {
+				t.Errorf("useNetlink() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+func addLocalRouteNetlink(protoID, ip, ifname string) error {
+	return errors.ErrUnsupported
+}
+
+func removeLocalRouteNetlink(protoID, ip, ifname string) error {
+	return errors.ErrUnsupported
+}
+
+func getLocalRoutesNetlink(protoID, ifname string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func getForeignLocalRoutesNetlink(protoID, ifname string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -20,10 +20,14 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
 )
 
 func reloadConfig(t *testing.T, extraDefaults []byte) {
@@ -65,6 +69,22 @@ func TestCompareRoutes(t *testing.T) {
 	}
 }
 
+func TestNICRoutesChanges(t *testing.T) {
+	r := &nicRoutes{
+		wantIPs:      []string{"1.2.3.4", "5.6.7.8", "9.9.9.9"},
+		forwardedIPs: []string{"1.2.3.4", "10.0.0.1"},
+		foreignIPs:   []string{"5.6.7.8", "10.0.0.2"},
+	}
+
+	toAdd, toRm := r.changes()
+	if diff := cmp.Diff([]string{"9.9.9.9"}, toAdd); diff != "" {
+		t.Errorf("changes() returned unexpected IPs to add (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"10.0.0.1"}, toRm); diff != "" {
+		t.Errorf("changes() returned unexpected IPs to remove (-want +got):\n%s", diff)
+	}
+}
+
 func TestAddressDisabled(t *testing.T) {
 	var tests = []struct {
 		name string
@@ -88,8 +108,7 @@ func TestAddressDisabled(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			reloadConfig(t, tt.data)
-			newMetadata = tt.md
-			got, err := (&addressMgr{}).Disabled(ctx)
+			got, err := (&addressMgr{}).Disabled(ctx, tt.md)
 			if err != nil {
 				t.Errorf("Failed to run addressMgr's Disabled() call, got error: %+v", err)
 			}
@@ -126,10 +145,8 @@ func TestAddressDiff(t *testing.T) {
 			reloadConfig(t, tt.data)
 
 			oldWSFCEnable = false
-			oldMetadata = &metadata.Descriptor{}
-			newMetadata = tt.md
 
-			got, err := (&addressMgr{}).Diff(ctx)
+			got, err := (&addressMgr{}).Diff(ctx, &metadata.Descriptor{}, tt.md)
 			if err != nil {
 				t.Errorf("Failed to run addressMgr's Diff() call, got error: %+v", err)
 			}
@@ -168,12 +185,11 @@ func TestWsfcFilter(t *testing.T) {
 				t.Error("failed to unmarshal test JSON:", tt, err)
 			}
 
-			newMetadata = &md
 			testAddress := addressMgr{}
-			testAddress.applyWSFCFilter(cfg.Get())
+			interfaces := testAddress.applyWSFCFilter(cfg.Get(), &md)
 
 			forwardedIps := []string{}
-			for _, ni := range newMetadata.Instance.NetworkInterfaces {
+			for _, ni := range interfaces {
 				forwardedIps = append(forwardedIps, ni.ForwardedIps...)
 			}
 
@@ -201,11 +217,9 @@ func TestWsfcFlagTriggerAddressDiff(t *testing.T) {
 			reloadConfig(t, nil)
 
 			oldWSFCAddresses = tt.oldMetadata.Instance.Attributes.WSFCAddresses
-			newMetadata = tt.newMetadata
-			oldMetadata = tt.oldMetadata
 			testAddress := addressMgr{}
 
-			diff, err := testAddress.Diff(ctx)
+			diff, err := testAddress.Diff(ctx, tt.oldMetadata, tt.newMetadata)
 			if err != nil {
 				t.Errorf("Failed to run addressMgr's Diff() call, got error: %+v", err)
 			}
@@ -243,3 +257,152 @@ func TestIsIPv6(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalRouteArgs(t *testing.T) {
+	reloadConfig(t, nil)
+	config := cfg.Get()
+
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{
+			name: "ipv4_address",
+			ip:   "10.0.0.1",
+			want: "route add to local 10.0.0.1/32 scope host dev eth0 proto 66",
+		},
+		{
+			name: "ipv4_range",
+			ip:   "10.0.0.0/24",
+			want: "route add to local 10.0.0.0/24 scope host dev eth0 proto 66",
+		},
+		{
+			name: "ipv6_address",
+			ip:   "2600:1900::1",
+			want: "-6 route add to local 2600:1900::1/128 dev eth0 proto 66",
+		},
+		{
+			name: "ipv6_range",
+			ip:   "2600:1900::/96",
+			want: "-6 route add to local 2600:1900::/96 dev eth0 proto 66",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := strings.Join(localRouteArgs(config, "add", tc.ip, "eth0"), " ")
+			if got != tc.want {
+				t.Errorf("localRouteArgs(%q) = %q, want %q", tc.ip, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWantedForwardedIPs(t *testing.T) {
+	ni := metadata.NetworkInterfaces{
+		ForwardedIps:      []string{"10.0.0.1"},
+		ForwardedIpv6s:    []string{"2001:db8::/96"},
+		TargetInstanceIps: []string{"10.0.0.2"},
+		IPAliases:         []string{"10.1.0.0/24"},
+		IPv6Aliases:       []string{"2001:db8:1::/96"},
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"10.0.0.1", "2001:db8::/96", "10.0.0.2", "10.1.0.0/24", "2001:db8:1::/96"},
+		},
+		{
+			name: "ipv6_disabled",
+			data: []byte("[IpForwarding]\nforwarded_ipv6s = false\nipv6_aliases = false"),
+			want: []string{"10.0.0.1", "10.0.0.2", "10.1.0.0/24"},
+		},
+		{
+			name: "ipv4_disabled",
+			data: []byte("[IpForwarding]\nip_aliases = false\ntarget_instance_ips = false"),
+			want: []string{"10.0.0.1", "2001:db8::/96", "2001:db8:1::/96"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reloadConfig(t, tc.data)
+
+			want := tc.want
+			if runtime.GOOS == "windows" {
+				want = slices.DeleteFunc(slices.Clone(want), func(ip string) bool {
+					return slices.Contains(ni.IPAliases, ip) || slices.Contains(ni.IPv6Aliases, ip)
+				})
+			}
+
+			got := wantedForwardedIPs(cfg.Get(), ni)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("wantedForwardedIPs() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if diff := cmp.Diff([]string{"10.0.0.1"}, ni.ForwardedIps); diff != "" {
+		t.Errorf("wantedForwardedIPs() modified the metadata (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseIPv6Prefix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "2001:db8::/96", want: "2001:db8::/96"},
+		{in: "2001:db8::1/128", want: "2001:db8::1/128"},
+		{in: trimHostPrefix("2001:db8::1/128"), want: "2001:db8::1/128"},
+		{in: "2001:db8::x", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			_, n, err := parseIPv6Prefix(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseIPv6Prefix(%q) = %v, want error: %t", tc.in, err, tc.wantErr)
+			}
+			if err == nil && n.String() != tc.want {
+				t.Errorf("parseIPv6Prefix(%q) = %s, want %s", tc.in, n, tc.want)
+			}
+		})
+	}
+}
+
+func TestTrimHostPrefix(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1/32":      "10.0.0.1",
+		"10.0.0.0/24":      "10.0.0.0/24",
+		"2001:db8::1/128":  "2001:db8::1",
+		"2001:db8::/96":    "2001:db8::/96",
+		"2001:db8::1:32":   "2001:db8::1:32",
+		"2001:db8::32/128": "2001:db8::32",
+	}
+	for in, want := range tests {
+		if got := trimHostPrefix(in); got != want {
+			t.Errorf("trimHostPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHostPrefix(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1":      "10.0.0.1/32",
+		"10.0.0.0/24":   "10.0.0.0/24",
+		"2001:db8::1":   "2001:db8::1/128",
+		"2001:db8::/96": "2001:db8::/96",
+	}
+	for in, want := range tests {
+		if got := hostPrefix(in); got != want {
+			t.Errorf("hostPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
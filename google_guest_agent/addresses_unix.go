@@ -27,6 +27,10 @@ func addIPForwardEntry(ipForwardEntry) error {
 	return errors.New("addIPForwardEntry unimplemented on non Windows systems")
 }
 
+func deleteIPForwardEntry(ipForwardEntry) error {
+	return errors.New("deleteIPForwardEntry unimplemented on non Windows systems")
+}
+
 // TODO: getLocalRoutes and getIPForwardEntries should be merged.
 func getIPForwardEntries() ([]ipForwardEntry, error) {
 	return nil, errors.New("getIPForwardEntries unimplemented on non Windows systems")
@@ -22,6 +22,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"slices"
 	"syscall"
 	"unsafe"
 
@@ -34,9 +35,11 @@ var (
 
 	procAddIPAddress                    = ipHlpAPI.NewProc("AddIPAddress")
 	procDeleteIPAddress                 = ipHlpAPI.NewProc("DeleteIPAddress")
-	procCreateIpForwardEntry            = ipHlpAPI.NewProc("CreateIpForwardEntry")
-	procDeleteIpForwardEntry            = ipHlpAPI.NewProc("DeleteIpForwardEntry")
-	procGetIpForwardTable               = ipHlpAPI.NewProc("GetIpForwardTable")
+	procCreateIpForwardEntry2           = ipHlpAPI.NewProc("CreateIpForwardEntry2")
+	procDeleteIpForwardEntry2           = ipHlpAPI.NewProc("DeleteIpForwardEntry2")
+	procInitializeIpForwardEntry        = ipHlpAPI.NewProc("InitializeIpForwardEntry")
+	procGetIpForwardTable2              = ipHlpAPI.NewProc("GetIpForwardTable2")
+	procFreeMibTable                    = ipHlpAPI.NewProc("FreeMibTable")
 	procGetIpInterfaceEntry             = ipHlpAPI.NewProc("GetIpInterfaceEntry")
 	procSetIpInterfaceEntry             = ipHlpAPI.NewProc("SetIpInterfaceEntry")
 	procCreateUnicastIpAddressEntry     = ipHlpAPI.NewProc("CreateUnicastIpAddressEntry")
@@ -50,13 +53,11 @@ const (
 	AF_INET6 = 23
 )
 
-type MIB_IPFORWARD_TYPE DWORD
-
+// The IP Helper API errors the addresses and routes programming tolerates, see
+// https://learn.microsoft.com/en-us/windows/win32/debug/system-error-codes
 const (
-	MIB_IPROUTE_TYPE_OTHER    MIB_IPFORWARD_TYPE = 1
-	MIB_IPROUTE_TYPE_INVALID                     = 2
-	MIB_IPROUTE_TYPE_DIRECT                      = 3
-	MIB_IPROUTE_TYPE_INDIRECT                    = 4
+	ERROR_NOT_FOUND             syscall.Errno = 1168
+	ERROR_OBJECT_ALREADY_EXISTS syscall.Errno = 5010
 )
 
 type MIB_IPFORWARD_PROTO DWORD
@@ -99,25 +100,6 @@ type (
 		OnLinkPrefixLength uint8
 		SkipAsSource       bool
 	}
-
-	IF_INDEX DWORD
-
-	MIB_IPFORWARDROW struct {
-		dwForwardDest      uint32
-		dwForwardMask      uint32
-		dwForwardPolicy    uint32
-		dwForwardNextHop   uint32
-		dwForwardIfIndex   IF_INDEX
-		dwForwardType      MIB_IPFORWARD_TYPE
-		dwForwardProto     MIB_IPFORWARD_PROTO
-		dwForwardAge       int32
-		dwForwardNextHopAS int32
-		dwForwardMetric1   int32
-		dwForwardMetric2   int32
-		dwForwardMetric3   int32
-		dwForwardMetric4   int32
-		dwForwardMetric5   int32
-	}
 )
 
 func addAddress(ip net.IP, mask net.IPMask, index uint32) error {
@@ -182,11 +164,59 @@ type MIBUnicastIPAddressRow6 struct {
 
 // setAddr is helper function to set net.IP in a structure windows syscalls understand.
 func (addr *RawSockaddrInet) setAddr(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		addr4 := (*windows.RawSockaddrInet4)(unsafe.Pointer(addr))
+		addr4.Family = windows.AF_INET
+		copy(addr4.Addr[:], ip4)
+		return
+	}
 	addr6 := (*windows.RawSockaddrInet6)(unsafe.Pointer(addr))
 	addr6.Family = windows.AF_INET6
 	copy(addr6.Addr[:], ip)
 }
 
+// ip returns the address as net.IP, nil if it's neither IPv4 nor IPv6.
+func (addr *RawSockaddrInet) ip() net.IP {
+	switch addr.Family {
+	case windows.AF_INET:
+		addr4 := (*windows.RawSockaddrInet4)(unsafe.Pointer(addr))
+		return net.IPv4(addr4.Addr[0], addr4.Addr[1], addr4.Addr[2], addr4.Addr[3]).To4()
+	case windows.AF_INET6:
+		addr6 := (*windows.RawSockaddrInet6)(unsafe.Pointer(addr))
+		return net.IP(slices.Clone(addr6.Addr[:]))
+	}
+	return nil
+}
+
+// IPAddressPrefix is an IP address prefix, padded like its C counterpart which is
+// aligned to the SOCKADDR_IN6's 4 bytes.
+// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-ip_address_prefix
+type IPAddressPrefix struct {
+	Prefix       RawSockaddrInet
+	PrefixLength uint8
+	_            [3]byte
+}
+
+// MIBIPForwardRow2 stores an IPv4 or IPv6 route entry.
+// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-mib_ipforward_row2
+type MIBIPForwardRow2 struct {
+	InterfaceLuid        LUID
+	InterfaceIndex       uint32
+	DestinationPrefix    IPAddressPrefix
+	NextHop              RawSockaddrInet
+	SitePrefixLength     uint8
+	ValidLifetime        uint32
+	PreferredLifetime    uint32
+	Metric               uint32
+	Protocol             MIB_IPFORWARD_PROTO
+	Loopback             bool
+	AutoconfigureAddress bool
+	Publish              bool
+	Immortal             bool
+	Age                  uint32
+	Origin               uint32
+}
+
 // initIpRow6 initializes the MIB_UNICASTIPADDRESS_ROW6 struct based on Ip, prefix length
 // and the index.
 func initIpRow6(ip net.IP, prefix uint8, index uint32) (*MIBUnicastIPAddressRow6, error) {
@@ -214,8 +244,8 @@ func createUnicastIpAddressEntry6(ip net.IP, prefix uint8, index uint32) error {
 		return fmt.Errorf("initialize MIB_UNICASTIPADDRESS_ROW failed: %w", err)
 	}
 
-	if ret, _, err := procCreateUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 {
-		return fmt.Errorf("nonzero return code from CreateUnicastIpAddressEntry: %s, last err: %w", syscall.Errno(ret), err)
+	if ret, _, _ := procCreateUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 && syscall.Errno(ret) != ERROR_OBJECT_ALREADY_EXISTS {
+		return fmt.Errorf("nonzero return code from CreateUnicastIpAddressEntry: %w", syscall.Errno(ret))
 	}
 	return nil
 }
@@ -229,8 +259,8 @@ func deleteUnicastIpAddressEntry6(ip net.IP, prefix uint8, index uint32) error {
 		return fmt.Errorf("initialize MIB_UNICASTIPADDRESS_ROW failed: %w", err)
 	}
 
-	if ret, _, err := procDeleteUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 {
-		return fmt.Errorf("nonzero return code from DeleteUnicastIpAddressEntry: %s, last err: %w", syscall.Errno(ret), err)
+	if ret, _, _ := procDeleteUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 && syscall.Errno(ret) != ERROR_NOT_FOUND {
+		return fmt.Errorf("nonzero return code from DeleteUnicastIpAddressEntry: %w", syscall.Errno(ret))
 	}
 	return nil
 }
@@ -248,8 +278,8 @@ func createUnicastIpAddressEntry(ip net.IP, prefix uint8, index uint32) error {
 	ipRow.Address.Ipv4.sin_family = AF_NET
 	ipRow.Address.Ipv4.sin_addr.S_un.S_addr = binary.LittleEndian.Uint32(ip.To4())
 
-	if ret, _, _ := procCreateUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 {
-		return fmt.Errorf("nonzero return code from CreateUnicastIpAddressEntry: %s", syscall.Errno(ret))
+	if ret, _, _ := procCreateUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 && syscall.Errno(ret) != ERROR_OBJECT_ALREADY_EXISTS {
+		return fmt.Errorf("nonzero return code from CreateUnicastIpAddressEntry: %w", syscall.Errno(ret))
 	}
 	return nil
 }
@@ -264,18 +294,17 @@ func deleteUnicastIpAddressEntry(ip net.IP, index uint32) error {
 
 	ret, _, _ := procGetUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow)))
 
-	// ERROR_NOT_FOUND
-	if ret == 1168 {
+	if syscall.Errno(ret) == ERROR_NOT_FOUND {
 		// This address was added by addIPAddress(), need to remove with deleteIPAddress()
 		return deleteIPAddress(ip)
 	}
 
 	if ret != 0 {
-		return fmt.Errorf("nonzero return code from GetUnicastIpAddressEntry: %s", syscall.Errno(ret))
+		return fmt.Errorf("nonzero return code from GetUnicastIpAddressEntry: %w", syscall.Errno(ret))
 	}
 
-	if ret, _, _ := procDeleteUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 {
-		return fmt.Errorf("nonzero return code from DeleteUnicastIpAddressEntry: %s", syscall.Errno(ret))
+	if ret, _, _ := procDeleteUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(ipRow))); ret != 0 && syscall.Errno(ret) != ERROR_NOT_FOUND {
+		return fmt.Errorf("nonzero return code from DeleteUnicastIpAddressEntry: %w", syscall.Errno(ret))
 	}
 	return nil
 }
@@ -326,80 +355,77 @@ func deleteIPAddress(ip net.IP) error {
 	return fmt.Errorf("did not find address %s on system", ip)
 }
 
+// getIPForwardEntries returns the IPv4 route table.
 func getIPForwardEntries() ([]ipForwardEntry, error) {
-	buf := make([]byte, 1)
-	size := uint32(len(buf))
-	// First call gets the size of MIB_IPFORWARDTABLE.
-	procGetIpForwardTable.Call(
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(unsafe.Pointer(&size)),
-		0,
-	)
-
-	buf = make([]byte, size)
-	if ret, _, _ := procGetIpForwardTable.Call(
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(unsafe.Pointer(&size)),
-		0,
-	); ret != 0 {
-		return nil, fmt.Errorf("nonzero return code from GetIpForwardTable: %s", syscall.Errno(ret))
+	// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/nf-netioapi-getipforwardtable2
+	var table unsafe.Pointer
+	if ret, _, _ := procGetIpForwardTable2.Call(uintptr(windows.AF_INET), uintptr(unsafe.Pointer(&table))); ret != 0 {
+		return nil, fmt.Errorf("nonzero return code from GetIpForwardTable2: %w", syscall.Errno(ret))
 	}
+	defer procFreeMibTable.Call(uintptr(table))
 
 	/*
-	  struct MIB_IPFORWARDTABLE {
-	    DWORD            dwNumEntries;
-	    MIB_IPFORWARDROW table[ANY_SIZE];
+	  struct MIB_IPFORWARD_TABLE2 {
+	    ULONG              NumEntries;
+	    MIB_IPFORWARD_ROW2 Table[ANY_SIZE];
 	  }
 	*/
-	numEntries := *(*uint32)(unsafe.Pointer(&buf[0]))
-	// Walk through the returned table for each entry.
+	numEntries := *(*uint32)(table)
+	rows := unsafe.Slice((*MIBIPForwardRow2)(unsafe.Add(table, unsafe.Offsetof(struct {
+		n   uint32
+		row MIBIPForwardRow2
+	}{}.row))), numEntries)
+
 	var fes []ipForwardEntry
-	for i := uint32(0); i < numEntries; i++ {
-		// Extract each MIB_IPFORWARDROW from MIB_IPFORWARDTABLE
-		fr := *((*MIB_IPFORWARDROW)(unsafe.Pointer(
-			(uintptr(unsafe.Pointer(&buf[0])) + unsafe.Sizeof(numEntries)) + (unsafe.Sizeof(MIB_IPFORWARDROW{}) * uintptr(i)),
-		)))
-		fd := make([]byte, 4)
-		binary.LittleEndian.PutUint32(fd, uint32(fr.dwForwardDest))
-		fm := make([]byte, 4)
-		binary.LittleEndian.PutUint32(fm, uint32(fr.dwForwardMask))
-		nh := make([]byte, 4)
-		binary.LittleEndian.PutUint32(nh, uint32(fr.dwForwardNextHop))
-		fe := ipForwardEntry{
-			ipForwardDest:    net.IP(fd),
-			ipForwardMask:    net.IPMask(fm),
-			ipForwardNextHop: net.IP(nh),
-			ipForwardIfIndex: int32(fr.dwForwardIfIndex),
-			ipForwardMetric1: fr.dwForwardMetric1,
-		}
-		fes = append(fes, fe)
+	for i := range rows {
+		row := &rows[i]
+		fes = append(fes, ipForwardEntry{
+			ipForwardDest:    row.DestinationPrefix.Prefix.ip(),
+			ipForwardMask:    net.CIDRMask(int(row.DestinationPrefix.PrefixLength), net.IPv4len*8),
+			ipForwardNextHop: row.NextHop.ip(),
+			ipForwardIfIndex: int32(row.InterfaceIndex),
+			ipForwardMetric1: int32(row.Metric),
+		})
 	}
-
 	return fes, nil
 }
 
+// addIPForwardEntry adds the IPv4 route fe, an existing route isn't an error.
 func addIPForwardEntry(fe ipForwardEntry) error {
-	// https://docs.microsoft.com/en-us/windows/win32/api/iphlpapi/nf-iphlpapi-createipforwardentry
-
-	fr := &MIB_IPFORWARDROW{
-		dwForwardDest:      binary.LittleEndian.Uint32(fe.ipForwardDest.To4()),
-		dwForwardMask:      binary.LittleEndian.Uint32(fe.ipForwardMask),
-		dwForwardPolicy:    0, // unused
-		dwForwardNextHop:   binary.LittleEndian.Uint32(fe.ipForwardNextHop.To4()),
-		dwForwardIfIndex:   IF_INDEX(fe.ipForwardIfIndex),
-		dwForwardType:      MIB_IPROUTE_TYPE_INDIRECT, // unused
-		dwForwardProto:     MIB_IPPROTO_NETMGMT,
-		dwForwardAge:       0, // unused
-		dwForwardNextHopAS: 0, // unused
-		dwForwardMetric1:   fe.ipForwardMetric1,
-		dwForwardMetric2:   -1, // unused
-		dwForwardMetric3:   -1, // unused
-		dwForwardMetric4:   -1, // unused
-		dwForwardMetric5:   -1, // unused
+	// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/nf-netioapi-createipforwardentry2
+	row := new(MIBIPForwardRow2)
+	// No return value.
+	procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(row)))
+
+	prefixLength, _ := fe.ipForwardMask.Size()
+	row.InterfaceIndex = uint32(fe.ipForwardIfIndex)
+	row.DestinationPrefix.Prefix.setAddr(fe.ipForwardDest)
+	row.DestinationPrefix.PrefixLength = uint8(prefixLength)
+	row.NextHop.setAddr(fe.ipForwardNextHop)
+	row.Metric = uint32(fe.ipForwardMetric1)
+	row.Protocol = MIB_IPPROTO_NETMGMT
+
+	if ret, _, _ := procCreateIpForwardEntry2.Call(uintptr(unsafe.Pointer(row))); ret != 0 && syscall.Errno(ret) != ERROR_OBJECT_ALREADY_EXISTS {
+		return fmt.Errorf("nonzero return code from CreateIpForwardEntry2: %w", syscall.Errno(ret))
 	}
+	return nil
+}
+
+// deleteIPForwardEntry removes the IPv4 route fe, a missing route isn't an error.
+func deleteIPForwardEntry(fe ipForwardEntry) error {
+	// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/nf-netioapi-deleteipforwardentry2
+	row := new(MIBIPForwardRow2)
+	// No return value.
+	procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(row)))
+
+	prefixLength, _ := fe.ipForwardMask.Size()
+	row.InterfaceIndex = uint32(fe.ipForwardIfIndex)
+	row.DestinationPrefix.Prefix.setAddr(fe.ipForwardDest)
+	row.DestinationPrefix.PrefixLength = uint8(prefixLength)
+	row.NextHop.setAddr(fe.ipForwardNextHop)
 
-	if ret, _, _ := procCreateIpForwardEntry.Call(uintptr(unsafe.Pointer(fr))); ret != 0 {
-		return fmt.Errorf("nonzero return code from CreateIpForwardEntry: %s", syscall.Errno(ret))
+	if ret, _, _ := procDeleteIpForwardEntry2.Call(uintptr(unsafe.Pointer(row))); ret != 0 && syscall.Errno(ret) != ERROR_NOT_FOUND {
+		return fmt.Errorf("nonzero return code from DeleteIpForwardEntry2: %w", syscall.Errno(ret))
 	}
 	return nil
 }
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"unsafe"
+)
+
+// TestMIBIPForwardRow2Layout checks the route entry matches the layout of
+// MIB_IPFORWARD_ROW2 the IP Helper API expects.
+func TestMIBIPForwardRow2Layout(t *testing.T) {
+	var row MIBIPForwardRow2
+	offsets := []struct {
+		name   string
+		offset uintptr
+		want   uintptr
+	}{
+		{name: "DestinationPrefix", offset: unsafe.Offsetof(row.DestinationPrefix), want: 12},
+		{name: "NextHop", offset: unsafe.Offsetof(row.NextHop), want: 44},
+		{name: "SitePrefixLength", offset: unsafe.Offsetof(row.SitePrefixLength), want: 72},
+		{name: "Metric", offset: unsafe.Offsetof(row.Metric), want: 84},
+		{name: "Protocol", offset: unsafe.Offsetof(row.Protocol), want: 88},
+		{name: "Origin", offset: unsafe.Offsetof(row.Origin), want: 100},
+	}
+	for _, tc := range offsets {
+		if tc.offset != tc.want {
+			t.Errorf("MIBIPForwardRow2.%s offset = %d, want %d", tc.name, tc.offset, tc.want)
+		}
+	}
+	if size := unsafe.Sizeof(row); size != 104 {
+		t.Errorf("MIBIPForwardRow2 size = %d, want 104", size)
+	}
+}
+
+func TestRawSockaddrInet(t *testing.T) {
+	for _, ip := range []string{"169.254.169.254", "2600:1900::1"} {
+		var addr RawSockaddrInet
+		addr.setAddr(net.ParseIP(ip))
+		if got := addr.ip(); !got.Equal(net.ParseIP(ip)) {
+			t.Errorf("RawSockaddrInet.ip() = %v, want %s", got, ip)
+		}
+	}
+}
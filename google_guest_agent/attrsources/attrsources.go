@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package attrsources implements additional sources of metadata attributes. Sources
+// are JSON objects mapping attribute names to their values (the same format used by
+// the metadata server) hosted in http(s):// or gs:// URLs, they allow settings to be
+// shared by a group of projects, i.e. all the projects of a folder or environment.
+//
+// Attributes are merged with the following precedence: instance attributes, project
+// attributes and then the configured sources in the order they were listed.
+package attrsources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/download"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// defaultRefreshInterval is used if the configured refresh interval is invalid.
+	defaultRefreshInterval = 10 * time.Minute
+)
+
+var (
+	// cache maps the source URLs to their last successfully fetched attributes.
+	cache = make(map[string]*source)
+
+	// cacheMutex protects the cache map.
+	cacheMutex sync.Mutex
+
+	// defaultRetryPolicy is the policy used when fetching a source.
+	defaultRetryPolicy = retry.Policy{MaxAttempts: 3, BackoffFactor: 1, Jitter: time.Second}
+
+	// fetchTimeout bounds the fetching of a source, retries included.
+	fetchTimeout = 30 * time.Second
+)
+
+// source is a fetched attribute source.
+type source struct {
+	// attributes are the attributes last fetched from the source.
+	attributes metadata.Attributes
+	// fetched is the time the attributes were last fetched.
+	fetched time.Time
+	// refreshing is set while the source is refreshed in the background.
+	refreshing bool
+}
+
+// parseURLs parses the comma separated list of source URLs.
+func parseURLs(urls string) []string {
+	var res []string
+	for _, curr := range strings.Split(urls, ",") {
+		if curr = strings.TrimSpace(curr); curr != "" {
+			res = append(res, curr)
+		}
+	}
+	return res
+}
+
+// refreshInterval returns the configured refresh interval.
+func refreshInterval(config *cfg.AttributeSources) time.Duration {
+	interval, err := time.ParseDuration(config.RefreshInterval)
+	if err != nil || interval <= 0 {
+		logger.Debugf("Invalid attribute sources refresh interval %q, using default: %s", config.RefreshInterval, defaultRefreshInterval)
+		return defaultRefreshInterval
+	}
+	return interval
+}
+
+// fetch returns the attributes of the source url. A source is only fetched in the
+// caller's go routine the first time, once the refresh interval has elapsed it's
+// refreshed in the background and the last known attributes are returned meanwhile.
+func fetch(ctx context.Context, url string, interval time.Duration) (metadata.Attributes, bool) {
+	cacheMutex.Lock()
+	if cached, found := cache[url]; found {
+		if !cached.refreshing && time.Since(cached.fetched) >= interval {
+			cached.refreshing = true
+			// The refresh outlives the caller, i.e. a metadata change handler.
+			go refresh(context.WithoutCancel(ctx), url)
+		}
+		attributes := cached.attributes
+		cacheMutex.Unlock()
+		return attributes, true
+	}
+	cacheMutex.Unlock()
+
+	attributes, err := fetchSource(ctx, url)
+	if err != nil {
+		logger.Errorf("Failed to fetch attribute source %q: %v", url, err)
+		return metadata.Attributes{}, false
+	}
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cache[url] = &source{attributes: attributes, fetched: time.Now()}
+	return attributes, true
+}
+
+// refresh fetches the cached source url again, if fetching fails the last known
+// attributes are kept.
+func refresh(ctx context.Context, url string) {
+	attributes, err := fetchSource(ctx, url)
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	cached, found := cache[url]
+	if !found {
+		return
+	}
+	cached.refreshing = false
+	if err != nil {
+		logger.Errorf("Failed to refresh attribute source %q, using last known attributes: %v", url, err)
+		return
+	}
+	cached.attributes, cached.fetched = attributes, time.Now()
+}
+
+// fetchSource downloads and decodes the attributes of the source url, giving up
+// after fetchTimeout.
+func fetchSource(ctx context.Context, url string) (metadata.Attributes, error) {
+	data, err := download.Get(ctx, url, defaultRetryPolicy, fetchTimeout)
+	if err != nil {
+		return metadata.Attributes{}, err
+	}
+
+	var attributes metadata.Attributes
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return metadata.Attributes{}, fmt.Errorf("invalid attribute source: %w", err)
+	}
+	return attributes, nil
+}
+
+// Merge merges the attributes of the configured sources into md's project attributes.
+// Attributes set in the instance or project metadata are never overridden.
+func Merge(ctx context.Context, md *metadata.Descriptor) {
+	config := cfg.Get().AttributeSources
+	if md == nil || config == nil {
+		return
+	}
+
+	urls := parseURLs(config.URLs)
+	if len(urls) == 0 {
+		return
+	}
+
+	interval := refreshInterval(config)
+	for _, url := range urls {
+		if attributes, ok := fetch(ctx, url, interval); ok {
+			md.Project.Attributes.Merge(attributes)
+		}
+	}
+}
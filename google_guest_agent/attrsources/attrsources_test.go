@@ -0,0 +1,223 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attrsources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseURLs(t *testing.T) {
+	tests := []struct {
+		urls string
+		want []string
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"gs://bucket/object", []string{"gs://bucket/object"}},
+		{"https://example.com/a.json, gs://bucket/b.json", []string{"https://example.com/a.json", "gs://bucket/b.json"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.urls, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, parseURLs(tc.urls)); diff != "" {
+				t.Errorf("parseURLs(%q) returned unexpected diff (-want +got):\n%s", tc.urls, diff)
+			}
+		})
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     time.Duration
+	}{
+		{"5m", 5 * time.Minute},
+		{"", defaultRefreshInterval},
+		{"-1s", defaultRefreshInterval},
+		{"bogus", defaultRefreshInterval},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.interval, func(t *testing.T) {
+			if got := refreshInterval(&cfg.AttributeSources{RefreshInterval: tc.interval}); got != tc.want {
+				t.Errorf("refreshInterval(%q) = %s, want: %s", tc.interval, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	defaultRetryPolicy.MaxAttempts = 1
+
+	var fail bool
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/first.json":
+			fmt.Fprint(w, `{"enable-oslogin": "true", "wsfc-agent-port": "1111"}`)
+		case "/second.json":
+			fmt.Fprint(w, `{"enable-oslogin": "false", "wsfc-agent-port": "2222", "enable-wsfc": "true", "disable-guest-telemetry": "true"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	config := fmt.Sprintf("[AttributeSources]\nurls = %s/first.json, %s/second.json, %s/missing.json\nrefresh_interval = 1h", srv.URL, srv.URL, srv.URL)
+	if err := cfg.Load([]byte(config)); err != nil {
+		t.Fatalf("cfg.Load() failed: %v", err)
+	}
+
+	truebool, falsebool := true, false
+	md := &metadata.Descriptor{}
+	md.Project.Attributes.EnableOSLogin = &falsebool
+
+	Merge(ctx, md)
+
+	want := metadata.Attributes{
+		EnableOSLogin:    &falsebool,
+		EnableWSFC:       &truebool,
+		WSFCAgentPort:    "1111",
+		DisableTelemetry: true,
+	}
+	if diff := cmp.Diff(want, md.Project.Attributes); diff != "" {
+		t.Errorf("Merge() returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Cached sources must not be fetched again within the refresh interval.
+	prevRequests := requests
+	Merge(ctx, &metadata.Descriptor{})
+	if requests != prevRequests+1 {
+		t.Errorf("Merge() made %d requests, want only the uncached source to be fetched", requests-prevRequests)
+	}
+
+	// Expire the cache and make the sources fail, the last known attributes must be used.
+	fail = true
+	expireCache()
+
+	md = &metadata.Descriptor{}
+	Merge(ctx, md)
+	waitRefreshed(t)
+	want.EnableOSLogin = &truebool
+	if diff := cmp.Diff(want, md.Project.Attributes); diff != "" {
+		t.Errorf("Merge() with failing sources returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	md = &metadata.Descriptor{}
+	Merge(ctx, md)
+	waitRefreshed(t)
+	if diff := cmp.Diff(want, md.Project.Attributes); diff != "" {
+		t.Errorf("Merge() after failed refreshes returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+// expireCache expires all the cached sources.
+func expireCache() {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	for _, curr := range cache {
+		curr.fetched = time.Time{}
+	}
+}
+
+// waitRefreshed waits for the background refreshes to finish.
+func waitRefreshed(t *testing.T) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		cacheMutex.Lock()
+		refreshing := false
+		for _, curr := range cache {
+			refreshing = refreshing || curr.refreshing
+		}
+		cacheMutex.Unlock()
+		if !refreshing {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the attribute sources to be refreshed")
+}
+
+func TestFetchRefresh(t *testing.T) {
+	ctx := context.Background()
+	defaultRetryPolicy.MaxAttempts = 1
+
+	var port atomic.Int32
+	port.Store(1111)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if port.Load() != 1111 {
+			<-release
+		}
+		fmt.Fprintf(w, `{"wsfc-agent-port": "%d"}`, port.Load())
+	}))
+	defer srv.Close()
+	url := srv.URL + "/refresh.json"
+
+	got, ok := fetch(ctx, url, time.Hour)
+	if !ok || got.WSFCAgentPort != "1111" {
+		t.Fatalf("fetch(%q) = %+v, %t, want port 1111", url, got, ok)
+	}
+
+	// The expired source is served from the cache while its refresh hangs.
+	port.Store(2222)
+	expireCache()
+	got, ok = fetch(ctx, url, time.Hour)
+	if !ok || got.WSFCAgentPort != "1111" {
+		t.Errorf("fetch(%q) while refreshing = %+v, %t, want the cached port 1111", url, got, ok)
+	}
+
+	close(release)
+	waitRefreshed(t)
+	got, ok = fetch(ctx, url, time.Hour)
+	if !ok || got.WSFCAgentPort != "2222" {
+		t.Errorf("fetch(%q) after the refresh = %+v, %t, want port 2222", url, got, ok)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	defaultRetryPolicy.MaxAttempts = 1
+	oldTimeout := fetchTimeout
+	fetchTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { fetchTimeout = oldTimeout })
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	url := srv.URL + "/hanging.json"
+	if got, ok := fetch(context.Background(), url, time.Hour); ok {
+		t.Errorf("fetch(%q) of a hanging source = %+v, want it to time out", url, got)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/control"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// agentAuthorizedKeysCommand is the sshd AuthorizedKeysCommand querying the
+	// metadata SSH keys from the agent, see printAuthorizedKeys().
+	agentAuthorizedKeysCommand = "AuthorizedKeysCommand /usr/bin/google_guest_agent authorized-keys %u"
+	// authorizedKeysQueryTimeout is how long the AuthorizedKeysCommand waits for the
+	// agent's reply, sshd is waiting on it.
+	authorizedKeysQueryTimeout = 10 * time.Second
+)
+
+// authorizedKeysForUser returns the metadata SSH keys of user, one per line, from
+// the last fetched metadata. The expired keys are skipped at the time of the query.
+func authorizedKeysForUser(user string) (string, error) {
+	_, md := metadataSnapshot()
+	if md == nil {
+		return "", errors.New("no metadata was fetched yet")
+	}
+	return userAuthorizedKeys(md, user)
+}
+
+// userAuthorizedKeys returns the SSH keys of user in md, one per line.
+func userAuthorizedKeys(md *metadata.Descriptor, user string) (string, error) {
+	if !cfg.Get().Accounts.AuthorizedKeysCommand {
+		return "", errors.New("the authorized keys command is disabled")
+	}
+	if user == "" {
+		return "", errors.New("no user given")
+	}
+	if enable, _, _, _ := getOSLoginEnabled(md); enable {
+		// The users' keys are served by OS Login.
+		return "", nil
+	}
+
+	keys := metadataUserKeys(md)[user]
+	if len(keys) == 0 {
+		return "", nil
+	}
+	return strings.Join(keys, "\n") + "\n", nil
+}
+
+// printAuthorizedKeys prints user's metadata SSH keys queried from the agent over
+// the control socket, it's run by sshd as the AuthorizedKeysCommand. If the control
+// socket isn't available, i.e. the agent isn't running, the keys are read from the
+// metadata server instead.
+func printAuthorizedKeys(ctx context.Context, user string) error {
+	ctx, cancel := context.WithTimeout(ctx, authorizedKeysQueryTimeout)
+	defer cancel()
+
+	var keys string
+	req := control.Request{Command: control.AuthorizedKeysCommand, Args: map[string]string{"user": user}}
+	resp, err := control.Send(ctx, cfg.Get().Core.ControlSocketPath, req)
+	switch {
+	case errors.Is(err, control.ErrUnavailable):
+		md, err := metadata.New().Get(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the metadata: %w", err)
+		}
+		if keys, err = userAuthorizedKeys(md, user); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case resp.Status != 0:
+		return fmt.Errorf("%s", resp.StatusMessage)
+	default:
+		keys = resp.Output
+	}
+
+	_, err = fmt.Fprint(os.Stdout, keys)
+	return err
+}
+
+// keysCommandConfigChanged returns true if the sshd configuration doesn't match
+// the authorized keys command mode, the OS Login configuration is kept.
+func keysCommandConfigChanged(md *metadata.Descriptor) bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	sshConfig, err := os.ReadFile("/etc/ssh/sshd_config")
+	if err != nil {
+		return false
+	}
+	enable, twofactor, skey, reqCerts := getOSLoginEnabled(md)
+	return updateSSHConfig(string(sshConfig), enable, twofactor, skey, reqCerts) != string(sshConfig)
+}
+
+// writeKeysCommandConfig adds or removes the agent's AuthorizedKeysCommand from the
+// sshd configuration according to the authorized keys command mode, sshd is
+// reloaded if it changed.
+func writeKeysCommandConfig(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) error {
+	if !keysCommandConfigChanged(md) {
+		return nil
+	}
+
+	logger.Infof("Updating the sshd AuthorizedKeysCommand, authorized keys command mode: %t.", config.Accounts.AuthorizedKeysCommand)
+	if err := writeSSHConfig(getOSLoginEnabled(md)); err != nil {
+		return err
+	}
+	for _, svc := range []string{"ssh", "sshd"} {
+		if err := systemctlReloadOrRestart(ctx, svc); err != nil {
+			logger.Errorf("Error reloading service: %v.", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+func TestAuthorizedKeysForUser(t *testing.T) {
+	if err := cfg.Load(nil); err != nil {
+		t.Fatalf("cfg.Load(nil) = %v, want nil", err)
+	}
+	_, origMd := metadataSnapshot()
+	t.Cleanup(func() { setNewMetadata(origMd) })
+
+	aliceKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOh54lWcJ60jwWy3NeKFPfMKLuhdk2guqc0Osy6tcxad alice"
+	aliceProjectKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ7oajSDtPl2ykE96krDkSAsmqAaBWz6UeKaDG17V548 alice"
+	bobKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF+/yw34avqy6AhuvNg/rG6PWOfbqJKoMAGgViRRfKGg bob"
+	expiredKey := `ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF+/yw34avqy6AhuvNg/rG6PWOfbqJKoMAGgViRRfKGg google-ssh {"userName":"bob","expireOn":"2020-01-01T00:00:00+0000"}`
+
+	md := &metadata.Descriptor{}
+	md.Instance.Attributes.SSHKeys = []string{"alice:" + aliceKey, "bob:" + bobKey, "bob:" + expiredKey}
+	md.Project.Attributes.SSHKeys = []string{"alice:" + aliceProjectKey}
+	setNewMetadata(md)
+
+	if _, err := authorizedKeysForUser("alice"); err == nil {
+		t.Errorf("authorizedKeysForUser(alice) with the mode disabled = nil, want error")
+	}
+
+	cfg.Get().Accounts.AuthorizedKeysCommand = true
+	t.Cleanup(func() { cfg.Get().Accounts.AuthorizedKeysCommand = false })
+
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"alice", aliceKey + "\n" + aliceProjectKey + "\n"},
+		{"bob", bobKey + "\n"},
+		{"carol", ""},
+	}
+	for _, tc := range tests {
+		got, err := authorizedKeysForUser(tc.user)
+		if err != nil {
+			t.Errorf("authorizedKeysForUser(%s) = %v, want nil", tc.user, err)
+		}
+		if got != tc.want {
+			t.Errorf("authorizedKeysForUser(%s) = %q, want %q", tc.user, got, tc.want)
+		}
+	}
+
+	enable := true
+	md.Instance.Attributes.EnableOSLogin = &enable
+	if got, err := authorizedKeysForUser("alice"); err != nil || got != "" {
+		t.Errorf("authorizedKeysForUser(alice) with OS Login = (%q, %v), want no keys", got, err)
+	}
+}
+
+func TestUpdateSSHConfigKeysCommand(t *testing.T) {
+	if err := cfg.Load(nil); err != nil {
+		t.Fatalf("cfg.Load(nil) = %v, want nil", err)
+	}
+	cfg.Get().Accounts.AuthorizedKeysCommand = true
+	t.Cleanup(func() { cfg.Get().Accounts.AuthorizedKeysCommand = false })
+
+	contents := strings.Join([]string{"line1", googleBlockStart, "line2", googleBlockEnd}, "\n")
+	want := strings.Join([]string{googleBlockStart, agentAuthorizedKeysCommand, "AuthorizedKeysCommandUser root", googleBlockEnd, "line1"}, "\n")
+	got := updateSSHConfig(contents, false, false, false, false)
+	if got != want {
+		t.Errorf("updateSSHConfig() with the authorized keys command\nwant:\n%v\ngot:\n%v", want, got)
+	}
+
+	// The block is removed once the mode is disabled.
+	cfg.Get().Accounts.AuthorizedKeysCommand = false
+	if got := updateSSHConfig(got, false, false, false, false); got != "line1" {
+		t.Errorf("updateSSHConfig() without the authorized keys command = %q, want %q", got, "line1")
+	}
+}
@@ -17,7 +17,11 @@ package cfg
 
 import (
 	"fmt"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/go-ini/ini"
 )
@@ -34,6 +38,13 @@ var (
 	// dataSource is a pointer to a data source loading/defining function, unit tests will
 	// want to change this pointer to whatever makes sense to its implementation.
 	dataSources = defaultDataSources
+
+	// warnings are the problems found in the configuration by the last Load() call.
+	warnings []string
+
+	// mu protects instance, warnings, overrides and the secrets' state, the
+	// configuration is reloaded while the agent's go routines read it.
+	mu sync.RWMutex
 )
 
 const (
@@ -43,26 +54,77 @@ const (
 	defaultConfig = `
 [Core]
 cloud_logging_enabled = true
+config_watcher_enabled = false
+control_socket_path =
+control_watcher_enabled = false
+inject_allowed_users =
+inject_socket_path =
+inject_watcher_enabled = false
+manager_retry_max_delay = 30m
+manager_timeout = 5m
+managers_dry_run = false
+managers_reconcile_interval = 0
+metadata_cache_enabled = true
+scheduler_job_timeout = 10m
+scheduler_max_parallel_jobs = 0
+scheduler_splay = 0
+shutdown_drain_timeout = 10s
+unit_watcher_enabled = false
 
 [Accounts]
+authorized_keys_command = false
+deprovision_grace_period = 0s
+deprovision_mode = remove
 deprovision_remove = false
 gpasswd_add_cmd = gpasswd -a {user} {group}
 gpasswd_remove_cmd = gpasswd -d {user} {group}
 groupadd_cmd = groupadd {group}
+groupdel_cmd = groupdel {group}
 groups = adm,dip,docker,lxd,plugdev,video
+home_dir_template =
+key_source_overrides =
+key_source_policy = merge
+primary_group =
+reject_weak_ssh_keys = false
 reuse_homedir = false
+shell =
+ssh_key_types =
+sudoers_group = google-sudoers
+uid_range =
 useradd_cmd = useradd -m -s /bin/bash -p * {user}
 userdel_cmd = userdel -r {user}
 
+[AttributeSources]
+refresh_interval = 10m
+urls =
+
 [Daemons]
 accounts_daemon = true
 clock_skew_daemon = true
 network_daemon = true
 
+[DNS]
+enabled = false
+nameservers =
+reapply_on_dhcp = true
+search_domains =
+
+[Hostname]
+enabled = false
+hosts_file = true
+reapply_on_dhcp = true
+use_fqdn = false
+
 [IpForwarding]
 ethernet_proto_id = 66
+firewall_rules = false
+forwarded_ipv6s = true
 ip_aliases = true
+ipv6_aliases = true
+routes_mode = imperative
 target_instance_ips = true
+verify_interval = 5m
+watch_network_changes = false
 
 [Instance]
 instance_id =
@@ -86,20 +148,58 @@ startup = true
 startup-windows = true
 sysprep-specialize = true
 
+[NICDrivers]
+gvnic_offloads =
+gvnic_queues = 0
+idpf_offloads =
+idpf_queues = 0
+virtio_offloads =
+virtio_queues = 0
+
 [NetworkInterfaces]
 dhcp_command =
+hotplug = true
 ip_forwarding = true
 setup = true
+manage_mtu = true
 manage_primary_nic =
+policy_routing = false
+policy_routing_table_base = 100
 restore_debian12_netplan_config = true
+rollback_changes = false
 vlan_setup_enabled = false
 
 [OSLogin]
 cert_authentication = true
+restore_on_disable = true
+
+[Plugins]
+dir =
+enabled = false
 
 [MDS]
+change-debounce = 500ms
 disable-https-mds-setup = true
 enable-https-mds-native-cert-store = false
+proxy =
+retry-attempts = 10
+retry-base-delay = 100ms
+retry-max-delay = 5s
+retry-jitter = 0.2
+source-interface =
+url =
+
+[Service]
+manager = auto
+pid_file = /run/google-guest-agent.pid
+ready_file = /run/google-guest-agent.ready
+
+[ServiceRecovery]
+enabled = true
+first_failure_delay = 1s
+reset_period = 24h
+second_failure_delay = 10s
+subsequent_failure_delay = 1m
 
 [Snapshots]
 enabled = false
@@ -113,6 +213,19 @@ command_pipe_mode = 0770
 command_pipe_group =
 command_request_timeout = 10s
 systemd_config_dir = /usr/lib/systemd/network
+
+[Watchdog]
+enabled = true
+timeout = 10m
+handler_timeout = 5m
+
+[WindowsAccounts]
+allowed_groups = Administrators,Remote Desktop Users,Users
+default_groups = Administrators
+domain_local_accounts =
+domain_mode = manage
+password_complexity = 3
+password_length = 15
 `
 )
 
@@ -122,6 +235,76 @@ type Core struct {
 	// CloudLoggingEnabled config toggle controls Guest Agent cloud logger.
 	// Disabling it will stop Guest Agent for configuring and logging to Cloud Logging.
 	CloudLoggingEnabled bool `ini:"cloud_logging_enabled,omitempty"`
+
+	// ConfigWatcherEnabled enables reloading the configuration when the configuration files change,
+	// off by default.
+	ConfigWatcherEnabled bool `ini:"config_watcher_enabled,omitempty"`
+
+	// ControlWatcherEnabled enables the root only control socket (named pipe on Windows),
+	// other on-host tools use it to re-run the managers, dump the agent's state and set
+	// the log level. It's off by default, the authorized_keys_command mode enables it
+	// regardless.
+	ControlWatcherEnabled bool `ini:"control_watcher_enabled,omitempty"`
+
+	// ControlSocketPath is the control socket (named pipe) path, the platform's default
+	// is used if empty.
+	ControlSocketPath string `ini:"control_socket_path,omitempty"`
+
+	// InjectWatcherEnabled enables the local gRPC event injection service, other on-host
+	// agents use it to inject events in the agent's event bus.
+	InjectWatcherEnabled bool `ini:"inject_watcher_enabled,omitempty"`
+
+	// InjectSocketPath is the event injection socket (named pipe) path, the platform's
+	// default is used if empty.
+	InjectSocketPath string `ini:"inject_socket_path,omitempty"`
+
+	// InjectAllowedUsers is a comma separated list of the users (names or UIDs), besides
+	// root, allowed to inject events. Linux only, the named pipe is restricted to
+	// LocalSystem and the Administrators.
+	InjectAllowedUsers string `ini:"inject_allowed_users,omitempty"`
+
+	// UnitWatcherEnabled enables watching the sshd and chronyd units, so the OS Login sshd
+	// configuration is re-applied after sshd restarts and the clock synced after chronyd restarts,
+	// off by default.
+	UnitWatcherEnabled bool `ini:"unit_watcher_enabled,omitempty"`
+
+	// ManagerRetryMaxDelay is the maximum delay between the retries of a failed manager,
+	// the delay doubles with each consecutive failure. Zero disables the retries.
+	ManagerRetryMaxDelay string `ini:"manager_retry_max_delay,omitempty" validate:"duration"`
+
+	// ManagerTimeout is how long a manager's run may take before it's given up, so a hung
+	// manager (i.e. useradd never returning) doesn't stall the next metadata updates.
+	ManagerTimeout string `ini:"manager_timeout,omitempty" validate:"duration"`
+
+	// ManagersDryRun makes the managers only log the changes they would make, i.e. the
+	// users to add or the routes to install, instead of applying them.
+	ManagersDryRun bool `ini:"managers_dry_run,omitempty"`
+
+	// ManagersReconcileInterval is how often the managers able to detect drift, i.e. the
+	// network and accounts managers, are checked and re-applied if the system's configuration
+	// drifted from the metadata. Zero disables the reconciliation.
+	ManagersReconcileInterval string `ini:"managers_reconcile_interval,omitempty" validate:"duration"`
+
+	// MetadataCacheEnabled enables caching the last fetched metadata to disk, the cache is
+	// applied at startup if the metadata server is unreachable.
+	MetadataCacheEnabled bool `ini:"metadata_cache_enabled,omitempty"`
+
+	// SchedulerJobTimeout is how long a scheduled job's run may take before it's given up,
+	// so a stuck job (i.e. the telemetry on a dead network) doesn't pile up runs.
+	SchedulerJobTimeout string `ini:"scheduler_job_timeout,omitempty" validate:"duration"`
+
+	// SchedulerMaxParallelJobs limits the number of scheduled jobs running at the same time,
+	// i.e. on small footprint VMs, zero means no limit.
+	SchedulerMaxParallelJobs int `ini:"scheduler_max_parallel_jobs,omitempty"`
+
+	// SchedulerSplay is the maximum random delay of the scheduled jobs' runs, i.e. the
+	// telemetry, so the VMs booted at the same time don't run them at the same time.
+	// Zero, the default, disables it.
+	SchedulerSplay string `ini:"scheduler_splay,omitempty" validate:"duration"`
+
+	// ShutdownDrainTimeout is how long the agent waits for in-flight event handlers (and the
+	// managers they run) to complete when stopping, before canceling them.
+	ShutdownDrainTimeout string `ini:"shutdown_drain_timeout,omitempty" validate:"duration"`
 }
 
 // Sections encapsulates all the configuration sections.
@@ -143,15 +326,45 @@ type Sections struct {
 	// pointer is nil or not.
 	AddressManager *AddressManager `ini:"addressManager,omitempty"`
 
+	// AttributeSources defines additional sources of metadata attributes merged with the
+	// instance's and project's attributes, i.e. a per folder or per environment policy blob.
+	AttributeSources *AttributeSources `ini:"AttributeSources,omitempty"`
+
 	// Daemons defines the availability of clock skew, network and account managers.
 	Daemons *Daemons `ini:"Daemons,omitempty"`
 
+	// DNS defines the DNS manager's options, i.e. whether the nameservers and search
+	// domains are set from the metadata.
+	DNS *DNS `ini:"DNS,omitempty"`
+
+	// Hostname defines the hostname manager's options, i.e. whether the metadata
+	// hostname is set as the system's hostname.
+	Hostname *Hostname `ini:"Hostname,omitempty"`
+
+	// Features maps feature flag names to their locally configured values, it's populated from
+	// the free form [Features] section. See the features package for the accepted values.
+	Features map[string]string `ini:"-"`
+
+	// Managers maps the manager names to whether they are enabled, it's populated from the
+	// free form [Managers] section. The managers not listed are enabled.
+	Managers map[string]string `ini:"-"`
+
 	// Diagnostics defines the diagnostics configurations. It takes precedence over instance's
 	// and project's metadata configuration. The default configuration doesn't define values to it, if the
 	// user has defined it then we shouldn't even consider metadata values. Users must check if this
 	// pointer is nil or not.
 	Diagnostics *Diagnostics `ini:"diagnostics,omitempty"`
 
+	// DSC defines the Windows desired state configuration options. It takes precedence over
+	// instance's and project's metadata configuration. The default configuration doesn't define
+	// values to it, users must check if this pointer is nil or not.
+	DSC *DSC `ini:"dsc,omitempty"`
+
+	// ScheduledTasks defines the metadata scheduled tasks options. It takes precedence over
+	// instance's and project's metadata configuration. The default configuration doesn't define
+	// values to it, users must check if this pointer is nil or not.
+	ScheduledTasks *ScheduledTasks `ini:"ScheduledTasks,omitempty"`
+
 	// IPForwarding defines the ip forwarding configuration options.
 	IPForwarding *IPForwarding `ini:"IpForwarding,omitempty"`
 
@@ -165,6 +378,10 @@ type Sections struct {
 	// MetadataScripts contains the configurations of the metadata-scripts service.
 	MetadataScripts *MetadataScripts `ini:"MetadataScripts,omitempty"`
 
+	// NICDrivers defines the tunables applied to the NICs by driver, i.e. their queues
+	// count.
+	NICDrivers *NICDrivers `ini:"NICDrivers,omitempty"`
+
 	// NetworkInterfaces defines if the network interfaces should be managed/configured by guest-agent
 	// as well as the commands definitions for network configuration.
 	NetworkInterfaces *NetworkInterfaces `ini:"NetworkInterfaces,omitempty"`
@@ -175,6 +392,17 @@ type Sections struct {
 	// MDS defines the MDS configuration options.
 	MDS *MDS `ini:"MDS,omitempty"`
 
+	// Plugins defines the out-of-process manager plugins options, i.e. the directory
+	// they are discovered in.
+	Plugins *Plugins `ini:"Plugins,omitempty"`
+
+	// Service defines how the agent integrates with the service manager running it.
+	Service *Service `ini:"Service,omitempty"`
+
+	// ServiceRecovery defines the Windows service recovery actions, i.e. how long to wait before
+	// restarting the agent after it fails.
+	ServiceRecovery *ServiceRecovery `ini:"ServiceRecovery,omitempty"`
+
 	// Snpashots defines the snapshot listener configuration and behavior i.e. the server address and port.
 	Snapshots *Snapshots `ini:"Snapshots,omitempty"`
 
@@ -182,6 +410,14 @@ type Sections struct {
 	// guaranteed for any keys under this section. No application, script or utility should rely on it.
 	Unstable *Unstable `ini:"Unstable,omitempty"`
 
+	// Watchdog defines the event loop watchdog configuration, i.e. how long the metadata
+	// watcher or the event dispatching may go without progress.
+	Watchdog *Watchdog `ini:"Watchdog,omitempty"`
+
+	// WindowsAccounts defines the Windows account management options, i.e. the generated
+	// passwords' length and complexity.
+	WindowsAccounts *WindowsAccounts `ini:"WindowsAccounts,omitempty"`
+
 	// WSFC defines the wsfc configurations. It takes precedence over instance's and project's
 	// metadata configuration. The default configuration doesn't define values to it, if the user
 	// has defined it then we shouldn't even consider metadata values. Users must check if this
@@ -196,14 +432,46 @@ type AccountManager struct {
 
 // Accounts contains the configurations of Accounts section.
 type Accounts struct {
+	// AuthorizedKeysCommand makes sshd query the metadata SSH keys from the agent
+	// instead of the agent writing them to the users' authorized_keys files.
+	AuthorizedKeysCommand bool `ini:"authorized_keys_command,omitempty"`
+	// DeprovisionGracePeriod is how long the users removed from the metadata keep
+	// their account, their keys are revoked right away.
+	DeprovisionGracePeriod string `ini:"deprovision_grace_period,omitempty" validate:"duration"`
+	// DeprovisionMode is "remove" to deprovision the users removed from the
+	// metadata, "never" to only revoke their keys.
+	DeprovisionMode   string `ini:"deprovision_mode,omitempty"`
 	DeprovisionRemove bool   `ini:"deprovision_remove,omitempty"`
 	GPasswdAddCmd     string `ini:"gpasswd_add_cmd,omitempty"`
 	GPasswdRemoveCmd  string `ini:"gpasswd_remove_cmd,omitempty"`
 	GroupAddCmd       string `ini:"groupadd_cmd,omitempty"`
+	GroupDelCmd       string `ini:"groupdel_cmd,omitempty"`
 	Groups            string `ini:"groups,omitempty"`
-	ReuseHomedir      bool   `ini:"reuse_homedir,omitempty"`
-	UserAddCmd        string `ini:"useradd_cmd,omitempty"`
-	UserDelCmd        string `ini:"userdel_cmd,omitempty"`
+	// HomeDirTemplate is the home directory of the created users, {user} is replaced
+	// by the user name, i.e. "/home/users/{user}".
+	HomeDirTemplate string `ini:"home_dir_template,omitempty"`
+	// KeySourceOverrides is the comma separated list of the user:policy and
+	// %group:policy key source policies overriding KeySourcePolicy.
+	KeySourceOverrides string `ini:"key_source_overrides,omitempty"`
+	// KeySourcePolicy is how the instance and the project SSH keys of the users are
+	// combined: merge, merge-all, instance, instance-first or project-first.
+	KeySourcePolicy string `ini:"key_source_policy,omitempty"`
+	// PrimaryGroup is the primary group of the created users, instead of their own.
+	PrimaryGroup string `ini:"primary_group,omitempty"`
+	// RejectWeakSSHKeys rejects the DSA and the small RSA metadata SSH keys.
+	RejectWeakSSHKeys bool `ini:"reject_weak_ssh_keys,omitempty"`
+	ReuseHomedir      bool `ini:"reuse_homedir,omitempty"`
+	// Shell is the login shell of the created users, overriding useradd_cmd's.
+	Shell string `ini:"shell,omitempty"`
+	// SSHKeyTypes is the comma separated list of the accepted metadata SSH key types,
+	// all of them if empty.
+	SSHKeyTypes string `ini:"ssh_key_types,omitempty"`
+	// SudoersGroup is the group granted sudo permissions the users are added to.
+	SudoersGroup string `ini:"sudoers_group,omitempty"`
+	// UIDRange is the "min-max" range the created users' UIDs are allocated from.
+	UIDRange   string `ini:"uid_range,omitempty"`
+	UserAddCmd string `ini:"useradd_cmd,omitempty"`
+	UserDelCmd string `ini:"userdel_cmd,omitempty"`
 }
 
 // AddressManager contains the configuration of addressManager section.
@@ -211,6 +479,15 @@ type AddressManager struct {
 	Disable bool `ini:"disable,omitempty"`
 }
 
+// AttributeSources contains the configurations of AttributeSources section.
+type AttributeSources struct {
+	// RefreshInterval defines how often the attribute sources are fetched again.
+	RefreshInterval string `ini:"refresh_interval,omitempty" validate:"duration"`
+	// URLs is a comma separated list of http(s):// or gs:// URLs of JSON attribute blobs,
+	// sources listed first take precedence over the ones listed later.
+	URLs string `ini:"urls,omitempty"`
+}
+
 // Daemons contains the configurations of Daemons section.
 type Daemons struct {
 	AccountsDaemon  bool `ini:"accounts_daemon,omitempty"`
@@ -218,16 +495,74 @@ type Daemons struct {
 	NetworkDaemon   bool `ini:"network_daemon,omitempty"`
 }
 
+// DNS contains the configurations of DNS section.
+type DNS struct {
+	// Enabled enables setting the nameservers and the search domains from the
+	// metadata.
+	Enabled bool `ini:"enabled,omitempty"`
+	// Nameservers are the comma separated nameservers set, they take precedence over
+	// the metadata ones.
+	Nameservers string `ini:"nameservers,omitempty"`
+	// ReapplyOnDHCP re-applies the DNS configuration after a DHCP lease renewal, i.e.
+	// when the DHCP client hooks reset it, Linux only.
+	ReapplyOnDHCP bool `ini:"reapply_on_dhcp,omitempty"`
+	// SearchDomains are the comma separated search domains set, they take precedence
+	// over the metadata ones.
+	SearchDomains string `ini:"search_domains,omitempty"`
+}
+
+// Hostname contains the configurations of Hostname section.
+type Hostname struct {
+	// Enabled enables setting the system's hostname from the metadata hostname.
+	Enabled bool `ini:"enabled,omitempty"`
+	// HostsFile enables keeping the hosts file entry of the hostname consistent with
+	// the primary NIC's address, Linux only.
+	HostsFile bool `ini:"hosts_file,omitempty"`
+	// ReapplyOnDHCP re-applies the hostname after a DHCP lease renewal, i.e. when the
+	// DHCP client hooks reset it, Linux only.
+	ReapplyOnDHCP bool `ini:"reapply_on_dhcp,omitempty"`
+	// UseFQDN sets the fully qualified domain name as the hostname instead of its
+	// first label.
+	UseFQDN bool `ini:"use_fqdn,omitempty"`
+}
+
 // Diagnostics contains the configurations of Diagnostics section.
 type Diagnostics struct {
 	Enable bool `ini:"enable,omitempty"`
 }
 
+// DSC contains the configurations of DSC section.
+type DSC struct {
+	Enable bool `ini:"enable,omitempty"`
+}
+
+// ScheduledTasks contains the configurations of ScheduledTasks section.
+type ScheduledTasks struct {
+	Enable bool `ini:"enable,omitempty"`
+}
+
 // IPForwarding contains the configurations of IPForwarding section.
 type IPForwarding struct {
-	EthernetProtoID   string `ini:"ethernet_proto_id,omitempty"`
-	IPAliases         bool   `ini:"ip_aliases,omitempty"`
-	TargetInstanceIPs bool   `ini:"target_instance_ips,omitempty"`
+	EthernetProtoID string `ini:"ethernet_proto_id,omitempty"`
+	// FirewallRules allows the traffic to the forwarded IPs in the host firewall,
+	// iptables and ip6tables on Linux or Windows Firewall.
+	FirewallRules     bool `ini:"firewall_rules,omitempty"`
+	ForwardedIPv6s    bool `ini:"forwarded_ipv6s,omitempty"`
+	IPAliases         bool `ini:"ip_aliases,omitempty"`
+	IPv6Aliases       bool `ini:"ipv6_aliases,omitempty"`
+	TargetInstanceIPs bool `ini:"target_instance_ips,omitempty"`
+	// RoutesMode is how the forwarded IPs local routes are set up on Linux, either
+	// programmed directly (imperative) or rendered as networkd, netplan or
+	// NetworkManager configuration, for the network stacks overwriting the
+	// programmed routes.
+	RoutesMode string `ini:"routes_mode,omitempty"`
+	// WatchNetworkChanges re-applies the forwarded IPs routes as soon as the network
+	// configuration or the DHCP leases change, i.e. after a NIC hotplug or a DHCP
+	// renewal wiping them. Off by default, the routes are verified every VerifyInterval.
+	WatchNetworkChanges bool `ini:"watch_network_changes,omitempty"`
+	// VerifyInterval is how often the forwarded IPs routes are verified, and the
+	// missing ones re-applied, zero disables the verification.
+	VerifyInterval string `ini:"verify_interval,omitempty" validate:"duration"`
 }
 
 // Instance contains the configurations of Instance section.
@@ -260,18 +595,25 @@ type MetadataScripts struct {
 	ShutdownWindows   bool   `ini:"shutdown-windows,omitempty"`
 	Startup           bool   `ini:"startup,omitempty"`
 	StartupWindows    bool   `ini:"startup-windows,omitempty"`
-	SysprepSpecialize bool   `ini:"sysprep_specialize,omitempty"`
+	SysprepSpecialize bool   `ini:"sysprep-specialize,omitempty"`
 }
 
 // OSLogin contains the configurations of OSLogin section.
 type OSLogin struct {
 	CertAuthentication bool `ini:"cert_authentication,omitempty"`
+	// RestoreOnDisable backs up the configuration files before OS Login changes them
+	// and restores them when it's disabled, unless they were changed since.
+	RestoreOnDisable bool `ini:"restore_on_disable,omitempty"`
 }
 
 // MDS contains the configurations for MDS section. Currently its opt-in only
 // and should not assume any defaults. Setting any [defaultConfig] values will
 // override user settings from Metadata.
 type MDS struct {
+	// ChangeDebounce is the window metadata changes are coalesced in, the handlers
+	// only see the latest metadata once no change happened for the window, i.e. 500ms.
+	// Empty or zero disables the coalescing.
+	ChangeDebounce string `ini:"change-debounce,omitempty" validate:"duration"`
 	// DisableHTTPSMdsSetup enables/disables the mTLS credential refresher.
 	DisableHTTPSMdsSetup bool `ini:"disable-https-mds-setup,omitempty"`
 	// HTTPSMDSEnableNativeStore enables/disables the use of OSs native store. Native
@@ -279,43 +621,159 @@ type MDS struct {
 	// Root certificate where as its trust store that hosts root certs like
 	// `/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem` on Linux.
 	HTTPSMDSEnableNativeStore bool `ini:"enable-https-mds-native-cert-store,omitempty"`
+	// RetryAttempts is the maximum number of attempts of a metadata server request.
+	RetryAttempts int `ini:"retry-attempts,omitempty"`
+	// RetryBaseDelay is the delay before retrying a failed request, it doubles after
+	// each attempt.
+	RetryBaseDelay string `ini:"retry-base-delay,omitempty" validate:"duration"`
+	// RetryMaxDelay caps the delay between attempts.
+	RetryMaxDelay string `ini:"retry-max-delay,omitempty" validate:"duration"`
+	// RetryJitter is the fraction, between 0 and 1, each delay is randomized by so a
+	// fleet of instances doesn't retry in lockstep.
+	RetryJitter float64 `ini:"retry-jitter,omitempty"`
+	// Proxy is the URL of the proxy the metadata server requests are sent through,
+	// if empty the requests honor the HTTP_PROXY environment variables.
+	Proxy string `ini:"proxy,omitempty" validate:"url"`
+	// SourceInterface is the network interface the metadata server connections are
+	// bound to, if empty the OS picks the interface from its routes.
+	SourceInterface string `ini:"source-interface,omitempty"`
+	// URL overrides the metadata server URL, i.e. to point the agent to a fake
+	// metadata server during development.
+	URL string `ini:"url,omitempty" validate:"url"`
+}
+
+// NICDrivers contains the configurations of NICDrivers section, the tunables
+// applied with ethtool to the NICs of each driver on Linux. A zero queues count
+// keeps the driver's default. The offloads are comma separated ethtool features
+// settings, i.e. "tso=off,gro=on".
+type NICDrivers struct {
+	GVNICOffloads  string `ini:"gvnic_offloads,omitempty"`
+	GVNICQueues    int    `ini:"gvnic_queues,omitempty"`
+	IDPFOffloads   string `ini:"idpf_offloads,omitempty"`
+	IDPFQueues     int    `ini:"idpf_queues,omitempty"`
+	VirtioOffloads string `ini:"virtio_offloads,omitempty"`
+	VirtioQueues   int    `ini:"virtio_queues,omitempty"`
 }
 
 // NetworkInterfaces contains the configurations of NetworkInterfaces section.
 type NetworkInterfaces struct {
+	DHClientScript               string `ini:"dhclient_script,omitempty"`
 	DHCPCommand                  string `ini:"dhcp_command,omitempty"`
 	IPForwarding                 bool   `ini:"ip_forwarding,omitempty"`
 	Setup                        bool   `ini:"setup,omitempty"`
 	ManagePrimaryNIC             bool   `ini:"manage_primary_nic,omitempty"`
 	RestoreDebian12NetplanConfig bool   `ini:"restore_debian12_netplan_config,omitempty"`
 	VlanSetupEnabled             bool   `ini:"vlan_setup_enabled,omitempty"`
+	// Hotplug sets up the NICs hot-attached as soon as they appear instead of
+	// waiting for the metadata change, Linux only.
+	Hotplug bool `ini:"hotplug,omitempty"`
+	// ManageMTU sets the NICs' MTU to their metadata MTU, and re-applies it after
+	// a link change on Linux.
+	ManageMTU bool `ini:"manage_mtu,omitempty"`
+	// PolicyRouting sets up a routing table and a source rule per secondary NIC so
+	// the return traffic egresses the NIC it came from, Linux only.
+	PolicyRouting bool `ini:"policy_routing,omitempty"`
+	// PolicyRoutingTableBase is added to the NIC index to compute its routing table.
+	PolicyRoutingTableBase int `ini:"policy_routing_table_base,omitempty"`
+	// RollbackChanges rolls the MTU and forwarded IPs changes back if the metadata
+	// server can no longer be reached after applying them.
+	RollbackChanges bool `ini:"rollback_changes,omitempty"`
+}
+
+// Plugins contains the configurations of Plugins section.
+type Plugins struct {
+	// Dir is the directory the manager plugins are discovered in, the platform's
+	// default is used if empty.
+	Dir string `ini:"dir,omitempty"`
+	// Enabled enables starting the manager plugins found in Dir and feeding them the
+	// metadata changes.
+	Enabled bool `ini:"enabled,omitempty"`
 }
 
 // Snapshots contains the configurations of Snapshots section.
 type Snapshots struct {
 	Enabled             bool   `ini:"enabled,omitempty"`
 	SnapshotServiceIP   string `ini:"snapshot_service_ip,omitempty"`
-	SnapshotServicePort int    `ini:"snapshot_service_port,omitempty"`
+	SnapshotServicePort int    `ini:"snapshot_service_port,omitempty" validate:"port"`
 	TimeoutInSeconds    int    `ini:"timeout_in_seconds,omitempty"`
 }
 
+// Service contains the configurations of Service section.
+type Service struct {
+	// Manager is the service manager running the agent, one of auto, systemd, openrc,
+	// sysv or none. With auto the service manager is detected by probing the environment.
+	Manager string `ini:"manager,omitempty"`
+	// PIDFile is where the agent writes its pid when run by OpenRC or SysV init.
+	PIDFile string `ini:"pid_file,omitempty"`
+	// ReadyFile is created when the agent is ready when run by OpenRC or SysV init,
+	// i.e. the init script may wait for it to exist.
+	ReadyFile string `ini:"ready_file,omitempty"`
+}
+
+// ServiceRecovery contains the configurations of ServiceRecovery section.
+type ServiceRecovery struct {
+	// Enabled defines whether the agent configures its Windows service recovery actions.
+	Enabled bool `ini:"enabled,omitempty"`
+	// FirstFailureDelay is the delay before restarting the agent after its first failure.
+	FirstFailureDelay string `ini:"first_failure_delay,omitempty" validate:"duration"`
+	// ResetPeriod is the period without failures after which the failure count is reset.
+	ResetPeriod string `ini:"reset_period,omitempty" validate:"duration"`
+	// SecondFailureDelay is the delay before restarting the agent after its second failure.
+	SecondFailureDelay string `ini:"second_failure_delay,omitempty" validate:"duration"`
+	// SubsequentFailureDelay is the delay before restarting the agent after subsequent failures.
+	SubsequentFailureDelay string `ini:"subsequent_failure_delay,omitempty" validate:"duration"`
+}
+
 // Unstable contains the configurations of Unstable section. No long term stability or support
 // is guaranteed for configurations defined in the Unstable section. By default all flags defined
 // in this section is disabled and is intended to isolate under development features.
 type Unstable struct {
 	CommandMonitorEnabled bool   `ini:"command_monitor_enabled,omitempty"`
 	CommandPipePath       string `ini:"command_pipe_path,omitempty"`
-	CommandRequestTimeout string `ini:"command_request_timeout,omitempty"`
+	CommandRequestTimeout string `ini:"command_request_timeout,omitempty" validate:"duration"`
 	CommandPipeMode       string `ini:"command_pipe_mode,omitempty"`
 	CommandPipeGroup      string `ini:"command_pipe_group,omitempty"`
 	SystemdConfigDir      string `ini:"systemd_config_dir,omitempty"`
 }
 
+// Watchdog contains the configurations of Watchdog section.
+type Watchdog struct {
+	// Enabled defines whether the event loop watchdog is enabled.
+	Enabled bool `ini:"enabled,omitempty"`
+	// Timeout is the maximum period without progress before the watchdog acts, i.e. 10m.
+	Timeout string `ini:"timeout,omitempty" validate:"duration"`
+	// HandlerTimeout is the maximum time the event dispatching waits for each event
+	// handler before reporting it and moving on, i.e. 5m. Empty or zero waits indefinitely.
+	HandlerTimeout string `ini:"handler_timeout,omitempty" validate:"duration"`
+}
+
+// WindowsAccounts contains the configurations of WindowsAccounts section.
+type WindowsAccounts struct {
+	// AllowedGroups is the comma separated list of the local groups the windows-keys
+	// entries may add their user to.
+	AllowedGroups string `ini:"allowed_groups,omitempty"`
+	// DefaultGroups is the comma separated list of the local groups the created users
+	// are added to, unless their windows-keys entry names its groups.
+	DefaultGroups string `ini:"default_groups,omitempty"`
+	// DomainLocalAccounts is the comma separated list of the local accounts managed
+	// on domain joined machines with the restrict DomainMode.
+	DomainLocalAccounts string `ini:"domain_local_accounts,omitempty"`
+	// DomainMode is how the accounts are managed on domain joined machines: manage,
+	// skip, restrict to DomainLocalAccounts or events-only.
+	DomainMode string `ini:"domain_mode,omitempty" validate:"oneof=manage skip restrict events-only"`
+	// PasswordComplexity is the number of character classes, lower and upper case
+	// letters, numbers and special characters, the generated passwords contain.
+	PasswordComplexity int `ini:"password_complexity,omitempty"`
+	// PasswordLength is the minimum length of the generated passwords, the
+	// windows-keys entries may request longer ones.
+	PasswordLength int `ini:"password_length,omitempty"`
+}
+
 // WSFC contains the configurations of WSFC section.
 type WSFC struct {
 	Addresses string `ini:"addresses,omitempty"`
 	Enable    bool   `ini:"enable,omitempty"`
-	Port      string `ini:"port,omitempty"`
+	Port      string `ini:"port,omitempty" validate:"port"`
 }
 
 func defaultConfigFile(osName string) string {
@@ -325,19 +783,62 @@ func defaultConfigFile(osName string) string {
 	return unixConfigPath
 }
 
+// DropInDir returns the drop-in configuration directory, its *.cfg fragments are
+// loaded on top of the configuration file.
+func DropInDir() string {
+	return configFile(runtime.GOOS) + ".d"
+}
+
+// Files returns the configuration files, in the order they are loaded. Later
+// files override the keys set by the earlier ones. On top of the ini files the
+// configuration may be written as instance_configs.yaml or instance_configs.json,
+// with the same sections and keys, and drop-in fragments may use any of the formats.
+func Files() []string {
+	config := configFile(runtime.GOOS)
+	res := []string{
+		config,
+		config + ".distro",
+		config + ".template",
+	}
+
+	base := strings.TrimSuffix(config, filepath.Ext(config))
+	for _, ext := range []string{".yaml", ".yml", ".json"} {
+		res = append(res, base+ext)
+	}
+
+	var fragments []string
+	for _, ext := range []string{".cfg", ".yaml", ".yml", ".json"} {
+		// A missing directory just matches nothing.
+		matches, err := filepath.Glob(filepath.Join(DropInDir(), "*"+ext))
+		if err == nil {
+			fragments = append(fragments, matches...)
+		}
+	}
+	sort.Strings(fragments)
+
+	return append(res, fragments...)
+}
+
 func defaultDataSources(extraDefaults []byte) []interface{} {
 	var res = []interface{}{[]byte(defaultConfig)}
-	config := configFile(runtime.GOOS)
 
 	if len(extraDefaults) > 0 {
 		res = append(res, extraDefaults)
 	}
 
-	return append(res, []interface{}{
-		config,
-		config + ".distro",
-		config + ".template",
-	}...)
+	for _, file := range Files() {
+		res = append(res, file)
+	}
+
+	// The registry overrides the files, i.e. settings managed with group policies.
+	res = append(res, registrySource{})
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, doc := range overrides {
+		res = append(res, doc)
+	}
+	return res
 }
 
 // Load loads default configuration and the configuration from default config files.
@@ -347,23 +848,57 @@ func Load(extraDefaults []byte) error {
 		Insensitive: true,
 	}
 
-	sources := dataSources(extraDefaults)
+	sources, err := structuredSources(dataSources(extraDefaults))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
 	cfg, err := ini.LoadSources(opts, sources[0], sources[1:]...)
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %+v", err)
 	}
 
+	mu.RLock()
+	resolver, cached, generation := secretResolver, resolvedSecrets, secretsGeneration
+	mu.RUnlock()
+	resolved, secretWarnings, unresolved := resolveSecrets(cfg, resolver, cached)
+
 	sections := new(Sections)
 	if err := cfg.MapTo(sections); err != nil {
 		return fmt.Errorf("failed to map configuration to object: %+v", err)
 	}
 
+	// Feature flags and managers are free form keys and can't be mapped to a struct.
+	sections.Features = cfg.Section("Features").KeysHash()
+	sections.Managers = cfg.Section("Managers").KeysHash()
+
+	newWarnings := append(secretWarnings, validate(cfg)...)
+
+	mu.Lock()
+	defer mu.Unlock()
 	instance = sections
+	warnings = newWarnings
+	unresolvedSecrets = unresolved
+	if generation == secretsGeneration {
+		resolvedSecrets = resolved
+	}
 	return nil
 }
 
+// Warnings returns the problems found in the configuration loaded by the last
+// Load() call, i.e. unknown sections and keys or values of the wrong type. The
+// configuration is loaded before the logger is initialized so it's up to the
+// caller to log them.
+func Warnings() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return warnings
+}
+
 // Get returns the configuration's instance previously loaded with Load().
 func Get() *Sections {
+	mu.RLock()
+	defer mu.RUnlock()
 	if instance == nil {
 		panic("cfg package was not initialized, Load() " +
 			"should be called in the early initialization code path")
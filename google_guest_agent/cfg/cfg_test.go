@@ -15,6 +15,9 @@
 package cfg
 
 import (
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -56,7 +59,7 @@ key = value
 }
 
 func TestDefaultDataSources(t *testing.T) {
-	expectedDataSources := 4
+	expectedDataSources := 8
 	sources := defaultDataSources(nil)
 	if len(sources) != expectedDataSources {
 		t.Errorf("defaultDataSources() returned wrong number of sources, expected: %d, got: %d",
@@ -82,6 +85,47 @@ func TestDefaultConfigFile(t *testing.T) {
 	}
 }
 
+func TestDropInDir(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "instance_configs.cfg")
+
+	configFile = func(string) string { return config }
+	defer func() {
+		configFile = defaultConfigFile
+	}()
+
+	files := map[string]string{
+		config:                          "[Accounts]\ndeprovision_remove = true\ngroups = base\n",
+		DropInDir() + "/20-second.cfg":  "[Accounts]\ngroups = second\n",
+		DropInDir() + "/10-first.cfg":   "[Accounts]\ngroups = first\nuseradd_cmd = first\n",
+		DropInDir() + "/30-ignored.txt": "[Accounts]\ngroups = ignored\n",
+	}
+	if err := os.MkdirAll(DropInDir(), 0755); err != nil {
+		t.Fatalf("Failed to create drop-in directory: %v", err)
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+
+	if err := Load(nil); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+	defer Load(nil)
+
+	accounts := Get().Accounts
+	if !accounts.DeprovisionRemove {
+		t.Errorf("Accounts.deprovision_remove from the base file = false, want: true")
+	}
+	if accounts.Groups != "second" {
+		t.Errorf("Accounts.groups = %q, want the last fragment's value: %q", accounts.Groups, "second")
+	}
+	if accounts.UserAddCmd != "first" {
+		t.Errorf("Accounts.useradd_cmd = %q, want: %q", accounts.UserAddCmd, "first")
+	}
+}
+
 func TestGetTwice(t *testing.T) {
 	if err := Load(nil); err != nil {
 		t.Fatalf("Failed to load configuration: %+v", err)
@@ -94,3 +138,41 @@ func TestGetTwice(t *testing.T) {
 		t.Errorf("Get() should return always the same pointer, expected: %p, got: %p", firstCfg, secondCfg)
 	}
 }
+
+func TestConcurrentLoad(t *testing.T) {
+	t.Cleanup(func() {
+		overrides = nil
+		Load(nil)
+	})
+
+	if err := Load(nil); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+
+	// Reload the configuration, as done on configuration changes, while it's read.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := SetOverrides("[Accounts]\ngroups = override\n"); err != nil {
+					t.Errorf("SetOverrides() failed: %v", err)
+				}
+				if err := Load(nil); err != nil {
+					t.Errorf("Failed to load configuration: %+v", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if Get().Accounts == nil {
+					t.Errorf("Get() returned a configuration without the Accounts section")
+				}
+				Warnings()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// Setting is a configuration key's effective value and where it was set.
+type Setting struct {
+	// Section is the lower case section name.
+	Section string
+	// Key is the lower case key name.
+	Key string
+	// Value is the effective value of the key.
+	Value string
+	// Source is the data source setting the effective value, i.e. a file path.
+	Source string
+}
+
+// Effective returns the effective configuration, as merged by Load(), with the
+// source setting each key. Settings are sorted by section and key.
+func Effective() ([]Setting, error) {
+	opts := ini.LoadOptions{
+		Loose:       true,
+		Insensitive: true,
+	}
+
+	settings := make(map[string]Setting)
+
+	for i, source := range dataSources(nil) {
+		resolved, err := structuredSources([]interface{}{source})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration: %w", err)
+		}
+		// Structured files that don't exist and an empty registry resolve to nothing.
+		if len(resolved) == 0 {
+			continue
+		}
+
+		file, err := ini.LoadSources(opts, resolved[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration: %w", err)
+		}
+
+		name := sourceName(i, source)
+		for _, section := range file.Sections() {
+			for _, key := range section.Keys() {
+				id := section.Name() + "." + key.Name()
+				settings[id] = Setting{Section: section.Name(), Key: key.Name(), Value: key.Value(), Source: name}
+			}
+		}
+	}
+
+	res := make([]Setting, 0, len(settings))
+	for _, setting := range settings {
+		res = append(res, setting)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Section != res[j].Section {
+			return res[i].Section < res[j].Section
+		}
+		return res[i].Key < res[j].Key
+	})
+
+	return res, nil
+}
+
+// sourceName returns a human readable name of the data source at index i.
+func sourceName(i int, source interface{}) string {
+	switch src := source.(type) {
+	case string:
+		return src
+	case registrySource:
+		return "registry"
+	case []byte:
+		if i == 0 {
+			return "defaults"
+		}
+		return "instance-configs metadata"
+	default:
+		return strings.TrimSpace(fmt.Sprintf("%T", source))
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEffective(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "instance_configs.cfg")
+
+	configFile = func(string) string { return config }
+	t.Cleanup(func() {
+		configFile = defaultConfigFile
+		overrides = nil
+	})
+
+	if err := os.WriteFile(config, []byte("[Accounts]\ngroups = file\ndeprovision_remove = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", config, err)
+	}
+	if err := SetOverrides(`{"Accounts": {"groups": "metadata"}}`); err != nil {
+		t.Fatalf("SetOverrides() failed: %v", err)
+	}
+
+	settings, err := Effective()
+	if err != nil {
+		t.Fatalf("Effective() failed: %v", err)
+	}
+
+	got := make(map[string]Setting)
+	for _, setting := range settings {
+		got[setting.Section+"."+setting.Key] = setting
+	}
+
+	want := map[string]Setting{
+		"accounts.groups":             {Section: "accounts", Key: "groups", Value: "metadata", Source: "instance-configs metadata"},
+		"accounts.deprovision_remove": {Section: "accounts", Key: "deprovision_remove", Value: "true", Source: config},
+		"accounts.useradd_cmd":        {Section: "accounts", Key: "useradd_cmd", Value: got["accounts.useradd_cmd"].Value, Source: "defaults"},
+	}
+
+	for id, wantSetting := range want {
+		if diff := cmp.Diff(wantSetting, got[id]); diff != "" {
+			t.Errorf("Effective() returned unexpected setting for %q (-want +got):\n%s", id, diff)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"gopkg.in/yaml.v3"
+)
+
+// unmarshalFunc decodes a structured document, i.e. json.Unmarshal.
+type unmarshalFunc func([]byte, any) error
+
+// structuredFormats maps the extensions of the structured configuration formats
+// to their decoding function, files with any other extension are ini files.
+var structuredFormats = map[string]unmarshalFunc{
+	".json": json.Unmarshal,
+	".yaml": yaml.Unmarshal,
+	".yml":  yaml.Unmarshal,
+}
+
+// registrySource is the data source of the configuration overrides read from the
+// Windows registry, see readRegistryConfig().
+type registrySource struct{}
+
+// structuredSources replaces the paths of structured configuration files in
+// sources with their content converted to the ini format, the structured files
+// that don't exist are dropped. A registrySource is replaced with the registry
+// configuration, if any.
+func structuredSources(sources []interface{}) ([]interface{}, error) {
+	var res []interface{}
+
+	for _, source := range sources {
+		if _, ok := source.(registrySource); ok {
+			sections, err := readRegistryConfig()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read registry configuration: %w", err)
+			}
+			if len(sections) > 0 {
+				data, err := sectionsToIni(sections)
+				if err != nil {
+					return nil, fmt.Errorf("invalid registry configuration: %w", err)
+				}
+				res = append(res, data)
+			}
+			continue
+		}
+
+		path, ok := source.(string)
+		if !ok {
+			res = append(res, source)
+			continue
+		}
+
+		unmarshal, found := structuredFormats[strings.ToLower(filepath.Ext(path))]
+		if !found {
+			res = append(res, source)
+			continue
+		}
+
+		doc, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", path, err)
+		}
+
+		data, err := structuredToIni(doc, unmarshal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+		}
+		res = append(res, data)
+	}
+
+	return res, nil
+}
+
+// structuredToIni converts a structured document mapping section names to
+// objects of keys and values, i.e. {"accountManager": {"disable": true}}, to the
+// ini format.
+func structuredToIni(doc []byte, unmarshal unmarshalFunc) ([]byte, error) {
+	var sections map[string]map[string]any
+	if err := unmarshal(doc, &sections); err != nil {
+		return nil, err
+	}
+	return sectionsToIni(sections)
+}
+
+// sectionsToIni converts sections, mapping section names to keys and scalar
+// values, to the ini format.
+func sectionsToIni(sections map[string]map[string]any) ([]byte, error) {
+	file := ini.Empty()
+	for name, keys := range sections {
+		section, err := file.NewSection(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range keys {
+			var str string
+			switch v := value.(type) {
+			case nil:
+			case map[string]any, []any:
+				return nil, fmt.Errorf("key %q of section %q: nested values are not supported", key, name)
+			case float64:
+				// JSON numbers are decoded as floats, fmt.Sprint() would render the
+				// large ones in exponent notation, i.e. 1e+06.
+				str = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				str = fmt.Sprint(value)
+			}
+
+			if _, err := section.NewKey(key, str); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := file.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
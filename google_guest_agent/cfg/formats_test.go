@@ -0,0 +1,147 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ini/ini"
+	"gopkg.in/yaml.v3"
+)
+
+func TestStructuredConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "instance_configs.cfg")
+
+	configFile = func(string) string { return config }
+	defer func() {
+		configFile = defaultConfigFile
+	}()
+
+	files := map[string]string{
+		config: "[Accounts]\ngroups = ini\n",
+		filepath.Join(dir, "instance_configs.yaml"): "Accounts:\n  groups: yaml\n  deprovision_remove: true\nWatchdog:\n  timeout: 5m\n",
+		filepath.Join(dir, "instance_configs.json"): `{"Accounts": {"groups": "json"}, "Snapshots": {"snapshot_service_port": 8082}}`,
+		DropInDir() + "/10-fragment.yaml":           "Accounts:\n  gpasswd_add_cmd: fragment\n",
+	}
+	if err := os.MkdirAll(DropInDir(), 0755); err != nil {
+		t.Fatalf("Failed to create drop-in directory: %v", err)
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+
+	if err := Load(nil); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+	defer Load(nil)
+
+	sections := Get()
+	if sections.Accounts.Groups != "json" {
+		t.Errorf("Accounts.groups = %q, want the json file's value: %q", sections.Accounts.Groups, "json")
+	}
+	if !sections.Accounts.DeprovisionRemove {
+		t.Errorf("Accounts.deprovision_remove = false, want: true from the yaml file")
+	}
+	if sections.Watchdog.Timeout != "5m" {
+		t.Errorf("Watchdog.timeout = %q, want: %q", sections.Watchdog.Timeout, "5m")
+	}
+	if sections.Snapshots.SnapshotServicePort != 8082 {
+		t.Errorf("Snapshots.snapshot_service_port = %d, want: 8082", sections.Snapshots.SnapshotServicePort)
+	}
+	if sections.Accounts.GPasswdAddCmd != "fragment" {
+		t.Errorf("Accounts.gpasswd_add_cmd = %q, want the fragment's value: %q", sections.Accounts.GPasswdAddCmd, "fragment")
+	}
+}
+
+func TestStructuredConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "instance_configs.cfg")
+
+	configFile = func(string) string { return config }
+	defer func() {
+		configFile = defaultConfigFile
+		Load(nil)
+	}()
+
+	if err := os.WriteFile(filepath.Join(dir, "instance_configs.yaml"), []byte("Accounts:\n  groups:\n    - nested\n"), 0644); err != nil {
+		t.Fatalf("Failed to write configuration: %v", err)
+	}
+
+	if err := Load(nil); err == nil {
+		t.Errorf("Load() with a nested yaml value succeeded, want error")
+	}
+}
+
+func TestStructuredToIniNumbers(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		unmarshal unmarshalFunc
+		want      string
+	}{
+		{
+			name:      "json_large_integer",
+			doc:       `{"Section": {"key": 1000000}}`,
+			unmarshal: json.Unmarshal,
+			want:      "1000000",
+		},
+		{
+			name:      "json_fraction",
+			doc:       `{"Section": {"key": 0.25}}`,
+			unmarshal: json.Unmarshal,
+			want:      "0.25",
+		},
+		{
+			name:      "json_negative",
+			doc:       `{"Section": {"key": -12345678901}}`,
+			unmarshal: json.Unmarshal,
+			want:      "-12345678901",
+		},
+		{
+			name:      "yaml_large_float",
+			doc:       "Section:\n  key: 2500000.0\n",
+			unmarshal: yaml.Unmarshal,
+			want:      "2500000",
+		},
+		{
+			name:      "yaml_large_integer",
+			doc:       "Section:\n  key: 1000000\n",
+			unmarshal: yaml.Unmarshal,
+			want:      "1000000",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := structuredToIni([]byte(tc.doc), tc.unmarshal)
+			if err != nil {
+				t.Fatalf("structuredToIni(%q) = %v, want nil", tc.doc, err)
+			}
+			file, err := ini.Load(data)
+			if err != nil {
+				t.Fatalf("ini.Load(%q) = %v, want nil", data, err)
+			}
+			if got := file.Section("Section").Key("key").String(); got != tc.want {
+				t.Errorf("structuredToIni(%q) key = %q, want %q", tc.doc, got, tc.want)
+			}
+		})
+	}
+}
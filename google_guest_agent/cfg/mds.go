@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+const (
+	// defaultRetryBaseDelay is used if the configured base delay is invalid.
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	// defaultRetryMaxDelay is used if the configured max delay is invalid.
+	defaultRetryMaxDelay = 5 * time.Second
+)
+
+// RetryPolicy returns the retry policy of the metadata server requests, the delay
+// between attempts doubles after each attempt up to RetryMaxDelay. Invalid delays
+// fall back to their defaults, if no attempts are configured the returned policy
+// has no attempts and the metadata client uses its own default policy.
+func (m *MDS) RetryPolicy() retry.Policy {
+	if m == nil || m.RetryAttempts <= 0 {
+		return retry.Policy{}
+	}
+
+	baseDelay, err := time.ParseDuration(m.RetryBaseDelay)
+	if err != nil || baseDelay <= 0 {
+		baseDelay = defaultRetryBaseDelay
+	}
+
+	maxDelay, err := time.ParseDuration(m.RetryMaxDelay)
+	if err != nil || maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	jitter := m.RetryJitter
+	if jitter < 0 || jitter > 1 {
+		jitter = 0
+	}
+
+	return retry.Policy{
+		MaxAttempts:   m.RetryAttempts,
+		BackoffFactor: 2,
+		Jitter:        baseDelay,
+		MaxBackoff:    maxDelay,
+		Randomization: jitter,
+	}
+}
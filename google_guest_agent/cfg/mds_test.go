@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		mds  *MDS
+		want retry.Policy
+	}{
+		{
+			name: "default_config",
+			mds:  &MDS{RetryAttempts: 10, RetryBaseDelay: "100ms", RetryMaxDelay: "5s", RetryJitter: 0.2},
+			want: retry.Policy{MaxAttempts: 10, BackoffFactor: 2, Jitter: 100 * time.Millisecond, MaxBackoff: 5 * time.Second, Randomization: 0.2},
+		},
+		{
+			name: "invalid_values",
+			mds:  &MDS{RetryAttempts: 3, RetryBaseDelay: "soon", RetryMaxDelay: "-1s", RetryJitter: 2},
+			want: retry.Policy{MaxAttempts: 3, BackoffFactor: 2, Jitter: defaultRetryBaseDelay, MaxBackoff: defaultRetryMaxDelay},
+		},
+		{
+			name: "no_attempts",
+			mds:  &MDS{RetryBaseDelay: "1s"},
+			want: retry.Policy{},
+		},
+		{
+			name: "no_section",
+			want: retry.Policy{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.mds.RetryPolicy()
+			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreFields(retry.Policy{}, "ShouldRetry", "RetryAfter")); diff != "" {
+				t.Errorf("RetryPolicy() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
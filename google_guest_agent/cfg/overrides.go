@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// overrides are the configuration documents loaded on top of the configuration
+// files, later documents take precedence.
+var overrides [][]byte
+
+// SetOverrides sets the configuration documents loaded on top of the configuration
+// files by the following Load() calls, i.e. the ones defined in metadata. Later
+// documents take precedence and empty documents are skipped. A document is either
+// in the ini format or a JSON object mapping section names to objects of keys and
+// values, i.e. {"accountManager": {"disable": true}}. If any of the documents is
+// invalid an error is returned and the previous overrides are kept.
+func SetOverrides(docs ...string) error {
+	var res [][]byte
+
+	for _, doc := range docs {
+		doc = strings.TrimSpace(doc)
+		if doc == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(doc, "{") {
+			if _, err := ini.Load([]byte(doc)); err != nil {
+				return fmt.Errorf("invalid ini configuration override: %w", err)
+			}
+			res = append(res, []byte(doc))
+			continue
+		}
+
+		data, err := structuredToIni([]byte(doc), json.Unmarshal)
+		if err != nil {
+			return fmt.Errorf("invalid JSON configuration override: %w", err)
+		}
+		res = append(res, data)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	overrides = res
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"testing"
+)
+
+func TestSetOverrides(t *testing.T) {
+	t.Cleanup(func() {
+		overrides = nil
+		Load(nil)
+	})
+
+	project := `{"accountManager": {"disable": true}, "Accounts": {"groups": "project", "deprovision_remove": true}}`
+	instance := "[Accounts]\ngroups = instance\n"
+
+	if err := SetOverrides(project, "", instance); err != nil {
+		t.Fatalf("SetOverrides() failed: %v", err)
+	}
+
+	if err := Load(nil); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+
+	config := Get()
+	if config.AccountManager == nil || !config.AccountManager.Disable {
+		t.Errorf("accountManager.disable = %+v, want: true from the project override", config.AccountManager)
+	}
+	if !config.Accounts.DeprovisionRemove {
+		t.Errorf("Accounts.deprovision_remove = false, want: true from the project override")
+	}
+	if config.Accounts.Groups != "instance" {
+		t.Errorf("Accounts.groups = %q, want the instance override: %q", config.Accounts.Groups, "instance")
+	}
+}
+
+func TestSetOverridesInvalid(t *testing.T) {
+	t.Cleanup(func() { overrides = nil })
+
+	if err := SetOverrides("[Accounts]\ngroups = valid\n"); err != nil {
+		t.Fatalf("SetOverrides() failed: %v", err)
+	}
+
+	for _, doc := range []string{
+		`{"Accounts": "not an object"}`,
+		`{"Accounts": {"groups": ["nested"]}}`,
+		"[Accounts\ngroups = invalid\n",
+	} {
+		if err := SetOverrides(doc); err == nil {
+			t.Errorf("SetOverrides(%q) succeeded, want error", doc)
+		}
+	}
+
+	if len(overrides) != 1 {
+		t.Errorf("SetOverrides() with invalid documents changed the overrides, got: %d documents, want: 1", len(overrides))
+	}
+}
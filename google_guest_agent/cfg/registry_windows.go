@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// registryKey is the registry key holding the configuration overrides, each of its
+// subkeys is a section and each value of a subkey is a key of that section. It's
+// under the agent's SOFTWARE\Google\ComputeEngine registry key.
+const registryKey = `SOFTWARE\Google\ComputeEngine\GuestAgent`
+
+// readRegistryConfig reads the configuration overrides from the registry, string
+// and integer values are supported. It returns no sections if the registry key
+// doesn't exist.
+func readRegistryConfig() (map[string]map[string]any, error) {
+	root, err := registry.OpenKey(registry.LOCAL_MACHINE, registryKey, registry.ENUMERATE_SUB_KEYS)
+	if errors.Is(err, registry.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %w", registryKey, err)
+	}
+	defer root.Close()
+
+	names, err := root.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %q subkeys: %w", registryKey, err)
+	}
+
+	res := make(map[string]map[string]any)
+	for _, name := range names {
+		keys, err := readRegistrySection(registryKey + `\` + name)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = keys
+	}
+
+	return res, nil
+}
+
+// readRegistrySection reads the values of the section's registry key, values of
+// unsupported types are skipped.
+func readRegistrySection(path string) (map[string]any, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	defer key.Close()
+
+	names, err := key.ReadValueNames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %q values: %w", path, err)
+	}
+
+	res := make(map[string]any)
+	for _, name := range names {
+		_, valType, err := key.GetValue(name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q value %q: %w", path, name, err)
+		}
+
+		switch valType {
+		case registry.SZ, registry.EXPAND_SZ:
+			value, _, err := key.GetStringValue(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %q value %q: %w", path, name, err)
+			}
+			res[name] = value
+		case registry.DWORD, registry.QWORD:
+			value, _, err := key.GetIntegerValue(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %q value %q: %w", path, name, err)
+			}
+			res[name] = value
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// SecretPrefix prefixes the configuration values referencing a Secret Manager
+// secret, i.e. sm://projects/my-project/secrets/my-secret.
+const SecretPrefix = "sm://"
+
+// SecretResolver resolves a secret reference, including its SecretPrefix, to the
+// secret's value.
+type SecretResolver func(ref string) (string, error)
+
+// secretResolver resolves the secret references found by Load(), if nil the
+// references are left unresolved.
+var secretResolver SecretResolver
+
+// unresolvedSecrets is set if the last Load() found secret references but had no
+// resolver to resolve them.
+var unresolvedSecrets bool
+
+// resolvedSecrets caches the secrets' values resolved by the last Load() by
+// reference, so the configuration can be loaded again, i.e. for an instance-configs
+// change, without resolving them again. See RefreshSecrets().
+var resolvedSecrets = make(map[string]string)
+
+// secretsGeneration is incremented each time resolvedSecrets is dropped, a Load()
+// racing with it doesn't cache the values it resolved before.
+var secretsGeneration int
+
+// SetSecretResolver sets the resolver used by the following Load() calls to
+// resolve secret references. Until a resolver is set keys referencing a secret
+// are loaded with an empty value.
+func SetSecretResolver(resolver SecretResolver) {
+	mu.Lock()
+	defer mu.Unlock()
+	secretResolver = resolver
+	resolvedSecrets = make(map[string]string)
+	secretsGeneration++
+}
+
+// RefreshSecrets drops the cached secrets' values, the following Load() resolves
+// the secret references again, i.e. when the configuration is explicitly reloaded.
+func RefreshSecrets() {
+	mu.Lock()
+	defer mu.Unlock()
+	resolvedSecrets = make(map[string]string)
+	secretsGeneration++
+}
+
+// UnresolvedSecrets returns true if the current configuration has secret
+// references left unresolved for the lack of a resolver.
+func UnresolvedSecrets() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return unresolvedSecrets
+}
+
+// resolveSecrets replaces the secret references in file with the secrets' values
+// found in cached or resolved by resolver, it returns a warning for each reference
+// that couldn't be resolved. These keys are set to an empty value so the reference
+// is never used as a setting. resolved maps the references of file to their values,
+// the failed references are left out so they're resolved again next time.
+// unresolved is true if references were found but resolver is nil.
+func resolveSecrets(file *ini.File, resolver SecretResolver, cached map[string]string) (resolved map[string]string, warnings []string, unresolved bool) {
+	resolved = make(map[string]string)
+
+	for _, section := range file.Sections() {
+		for _, key := range section.Keys() {
+			ref := strings.TrimSpace(key.Value())
+			if !strings.HasPrefix(ref, SecretPrefix) {
+				continue
+			}
+
+			if resolver == nil {
+				key.SetValue("")
+				unresolved = true
+				continue
+			}
+
+			value, found := resolved[ref]
+			if !found {
+				value, found = cached[ref]
+			}
+			if !found {
+				var err error
+				value, err = resolver(ref)
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("failed to resolve secret %q for key %q in section [%s]: %v", ref, key.Name(), section.Name(), err))
+					key.SetValue("")
+					continue
+				}
+			}
+			resolved[ref] = value
+			key.SetValue(value)
+		}
+	}
+
+	return resolved, warnings, unresolved
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	t.Cleanup(func() {
+		SetSecretResolver(nil)
+		Load(nil)
+	})
+
+	config := []byte(`
+[wsfc]
+port = sm://projects/p/secrets/port
+addresses = sm://projects/p/secrets/missing
+`)
+
+	// Without a resolver the references are not used as values.
+	if err := Load(config); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+	if got := Get().WSFC.Port; got != "" {
+		t.Errorf("WSFC.port without a secret resolver = %q, want: empty", got)
+	}
+	if !UnresolvedSecrets() {
+		t.Errorf("UnresolvedSecrets() = false without a secret resolver, want: true")
+	}
+
+	SetSecretResolver(func(ref string) (string, error) {
+		if ref == "sm://projects/p/secrets/port" {
+			return "1234", nil
+		}
+		return "", fmt.Errorf("secret not found")
+	})
+
+	if err := Load(config); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+
+	if UnresolvedSecrets() {
+		t.Errorf("UnresolvedSecrets() = true with a secret resolver, want: false")
+	}
+
+	wsfc := Get().WSFC
+	if wsfc.Port != "1234" {
+		t.Errorf("WSFC.port = %q, want the resolved secret: %q", wsfc.Port, "1234")
+	}
+	if wsfc.Addresses != "" {
+		t.Errorf("WSFC.addresses = %q, want empty for a secret failing to resolve", wsfc.Addresses)
+	}
+
+	want := []string{`failed to resolve secret "sm://projects/p/secrets/missing" for key "addresses" in section [wsfc]: secret not found`}
+	if diff := cmp.Diff(want, Warnings()); diff != "" {
+		t.Errorf("Warnings() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolveSecretsCache(t *testing.T) {
+	t.Cleanup(func() {
+		SetSecretResolver(nil)
+		Load(nil)
+	})
+
+	config := []byte(`
+[wsfc]
+port = sm://projects/p/secrets/port
+addresses = sm://projects/p/secrets/missing
+`)
+
+	resolved := make(map[string]int)
+	value := "1234"
+	SetSecretResolver(func(ref string) (string, error) {
+		resolved[ref]++
+		if ref == "sm://projects/p/secrets/port" {
+			return value, nil
+		}
+		return "", fmt.Errorf("secret not found")
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := Load(config); err != nil {
+			t.Fatalf("Failed to load configuration: %+v", err)
+		}
+	}
+
+	// The resolved secret is cached, the failed one is resolved again.
+	want := map[string]int{"sm://projects/p/secrets/port": 1, "sm://projects/p/secrets/missing": 2}
+	if diff := cmp.Diff(want, resolved); diff != "" {
+		t.Errorf("Load() resolved unexpected secrets (-want +got):\n%s", diff)
+	}
+
+	value = "5678"
+	if err := Load(config); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+	if got := Get().WSFC.Port; got != "1234" {
+		t.Errorf("WSFC.port = %q, want the cached secret: %q", got, "1234")
+	}
+
+	RefreshSecrets()
+	if err := Load(config); err != nil {
+		t.Fatalf("Failed to load configuration: %+v", err)
+	}
+	if got := Get().WSFC.Port; got != "5678" {
+		t.Errorf("WSFC.port = %q after RefreshSecrets(), want the refreshed secret: %q", got, "5678")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// validate checks the loaded configuration against the Sections schema, it returns
+// a warning for each unknown section or key and for each value that can't be
+// parsed to its key's type. Values that fail to parse are silently mapped to
+// their zero value by ini's MapTo, the warnings make these misconfigurations visible.
+func validate(file *ini.File) []string {
+	schema := sectionsSchema()
+	var warnings []string
+
+	for _, section := range file.Sections() {
+		name := strings.ToLower(section.Name())
+		// The default section only holds keys set outside of any section and
+		// Features is free form.
+		if name == strings.ToLower(ini.DefaultSection) || name == "features" {
+			continue
+		}
+
+		// Managers is free form, its keys are booleans.
+		if name == "managers" {
+			for _, key := range section.Keys() {
+				if _, err := key.Bool(); err != nil {
+					warnings = append(warnings, fmt.Sprintf("invalid value %q for key %q in section [%s]: not a boolean", key.Value(), key.Name(), section.Name()))
+				}
+			}
+			continue
+		}
+
+		keys, found := schema[name]
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("unknown section [%s]", section.Name()))
+			continue
+		}
+
+		for _, key := range section.Keys() {
+			field, found := keys[strings.ToLower(key.Name())]
+			if !found {
+				warnings = append(warnings, fmt.Sprintf("unknown key %q in section [%s]", key.Name(), section.Name()))
+				continue
+			}
+
+			if err := validateValue(field, key); err != nil {
+				warnings = append(warnings, fmt.Sprintf("invalid value %q for key %q in section [%s]: %v", key.Value(), key.Name(), section.Name(), err))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// sectionsSchema maps the lower case section names to their lower case key names
+// and struct fields, as defined by the Sections ini tags.
+func sectionsSchema() map[string]map[string]reflect.StructField {
+	res := make(map[string]map[string]reflect.StructField)
+
+	sections := reflect.TypeOf(Sections{})
+	for i := 0; i < sections.NumField(); i++ {
+		field := sections.Field(i)
+		name := tagName(field)
+		if name == "" {
+			continue
+		}
+
+		typ := field.Type
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			continue
+		}
+
+		keys := make(map[string]reflect.StructField)
+		for j := 0; j < typ.NumField(); j++ {
+			if key := tagName(typ.Field(j)); key != "" {
+				keys[key] = typ.Field(j)
+			}
+		}
+		res[name] = keys
+	}
+
+	return res
+}
+
+// tagName returns the lower case name of field's ini tag, or an empty string if
+// the field is not mapped.
+func tagName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("ini"), ",")
+	if name == "-" {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// validateValue checks key's value can be parsed to field's type. String fields
+// may be further constrained with a validate tag, either duration, port, url or
+// oneof, the space separated list of the accepted values. Empty values are
+// accepted, they map to the zero value.
+func validateValue(field reflect.StructField, key *ini.Key) error {
+	if key.Value() == "" {
+		return nil
+	}
+
+	switch field.Type.Kind() {
+	case reflect.Bool:
+		if _, err := key.Bool(); err != nil {
+			return fmt.Errorf("not a boolean")
+		}
+	case reflect.Int:
+		if _, err := key.Int(); err != nil {
+			return fmt.Errorf("not an integer")
+		}
+	case reflect.Float64:
+		if _, err := key.Float64(); err != nil {
+			return fmt.Errorf("not a number")
+		}
+	}
+
+	tag := field.Tag.Get("validate")
+	if values, ok := strings.CutPrefix(tag, "oneof="); ok {
+		if accepted := strings.Fields(values); !slices.Contains(accepted, key.Value()) {
+			return fmt.Errorf("not one of %s", strings.Join(accepted, ", "))
+		}
+		return nil
+	}
+
+	switch tag {
+	case "duration":
+		if _, err := time.ParseDuration(key.Value()); err != nil {
+			return fmt.Errorf("not a duration, i.e. 30s or 10m")
+		}
+	case "port":
+		port, err := strconv.Atoi(key.Value())
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("not a port number between 1 and 65535")
+		}
+	case "url":
+		u, err := url.Parse(key.Value())
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("not an absolute URL, i.e. http://proxy:3128")
+		}
+	}
+
+	return nil
+}
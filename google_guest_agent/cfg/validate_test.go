@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfg
+
+import (
+	"testing"
+
+	"github.com/go-ini/ini"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateDefaultConfig(t *testing.T) {
+	file, err := ini.LoadSources(ini.LoadOptions{Loose: true, Insensitive: true}, []byte(defaultConfig))
+	if err != nil {
+		t.Fatalf("Failed to load the default configuration: %v", err)
+	}
+
+	if warnings := validate(file); len(warnings) != 0 {
+		t.Errorf("validate() returned warnings for the default configuration: %v", warnings)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := `
+[Accounts]
+deprovision_remove = ture
+groups = adm
+
+[Acounts]
+groups = adm
+
+[Core]
+cloud_loging_enabled = false
+
+[Features]
+some_feature = true
+
+[Managers]
+oslogin = false
+dsc = maybe
+
+[MDS]
+proxy = 127.0.0.1:3128
+retry-jitter = lots
+
+[Snapshots]
+snapshot_service_port = 80808
+
+[Watchdog]
+timeout = 10
+
+[WindowsAccounts]
+domain_mode = mange
+
+[wsfc]
+port = 59998
+`
+
+	file, err := ini.LoadSources(ini.LoadOptions{Loose: true, Insensitive: true}, []byte(config))
+	if err != nil {
+		t.Fatalf("Failed to load the configuration: %v", err)
+	}
+
+	want := []string{
+		`invalid value "ture" for key "deprovision_remove" in section [accounts]: not a boolean`,
+		`unknown section [acounts]`,
+		`unknown key "cloud_loging_enabled" in section [core]`,
+		`invalid value "maybe" for key "dsc" in section [managers]: not a boolean`,
+		`invalid value "127.0.0.1:3128" for key "proxy" in section [mds]: not an absolute URL, i.e. http://proxy:3128`,
+		`invalid value "lots" for key "retry-jitter" in section [mds]: not a number`,
+		`invalid value "80808" for key "snapshot_service_port" in section [snapshots]: not a port number between 1 and 65535`,
+		`invalid value "10" for key "timeout" in section [watchdog]: not a duration, i.e. 30s or 10m`,
+		`invalid value "mange" for key "domain_mode" in section [windowsaccounts]: not one of manage, skip, restrict, events-only`,
+	}
+
+	if diff := cmp.Diff(want, validate(file)); diff != "" {
+		t.Errorf("validate() returned unexpected warnings (-want +got):\n%s", diff)
+	}
+}
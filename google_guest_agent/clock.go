@@ -20,25 +20,40 @@ import (
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
 type clockskewMgr struct{}
 
-func (a *clockskewMgr) Diff(ctx context.Context) (bool, error) {
-	return oldMetadata.Instance.VirtualClock.DriftToken != newMetadata.Instance.VirtualClock.DriftToken, nil
+func (a *clockskewMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return oldMd.Instance.VirtualClock.DriftToken != newMd.Instance.VirtualClock.DriftToken, nil
 }
 
 func (a *clockskewMgr) Timeout(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (a *clockskewMgr) Disabled(ctx context.Context) (bool, error) {
+func (a *clockskewMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	enabled := cfg.Get().Daemons.ClockSkewDaemon
 	return runtime.GOOS == "windows" || !enabled, nil
 }
 
-func (a *clockskewMgr) Set(ctx context.Context) error {
+// timeSyncRestarted syncs the clock again after the time sync daemon was
+// restarted, i.e. chronyd flapped and may have stepped the clock while failing.
+func (a *clockskewMgr) timeSyncRestarted(ctx context.Context, unit string) {
+	oldMd, newMd := metadataSnapshot()
+	if disabled, _ := a.Disabled(ctx, newMd); disabled {
+		return
+	}
+
+	logger.Infof("%s was restarted, syncing the clock.", unit)
+	if err := a.Set(ctx, oldMd, newMd); err != nil {
+		logger.Errorf("Failed to sync the clock after %s was restarted: %v", unit, err)
+	}
+}
+
+func (a *clockskewMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
 	if runtime.GOOS == "freebsd" {
 		err := run.Quiet(ctx, "service", "ntpd", "status")
 		if err == nil {
@@ -24,6 +24,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-agent/utils"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
@@ -50,15 +51,15 @@ type diagnosticsMgr struct {
 	fakeWindows bool
 }
 
-func (d *diagnosticsMgr) Diff(ctx context.Context) (bool, error) {
-	return !reflect.DeepEqual(newMetadata.Instance.Attributes.Diagnostics, oldMetadata.Instance.Attributes.Diagnostics), nil
+func (d *diagnosticsMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return !reflect.DeepEqual(newMd.Instance.Attributes.Diagnostics, oldMd.Instance.Attributes.Diagnostics), nil
 }
 
 func (d *diagnosticsMgr) Timeout(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (d *diagnosticsMgr) Disabled(ctx context.Context) (bool, error) {
+func (d *diagnosticsMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	var disabled bool
 	config := cfg.Get()
 
@@ -78,23 +79,23 @@ func (d *diagnosticsMgr) Disabled(ctx context.Context) (bool, error) {
 		return !config.Diagnostics.Enable, nil
 	}
 
-	if newMetadata.Instance.Attributes.EnableDiagnostics != nil {
-		return !*newMetadata.Instance.Attributes.EnableDiagnostics, nil
+	if newMd.Instance.Attributes.EnableDiagnostics != nil {
+		return !*newMd.Instance.Attributes.EnableDiagnostics, nil
 	}
-	if newMetadata.Project.Attributes.EnableDiagnostics != nil {
-		return !*newMetadata.Project.Attributes.EnableDiagnostics, nil
+	if newMd.Project.Attributes.EnableDiagnostics != nil {
+		return !*newMd.Project.Attributes.EnableDiagnostics, nil
 	}
 	return diagnosticsDisabled, nil
 }
 
-func (d *diagnosticsMgr) Set(ctx context.Context) error {
+func (d *diagnosticsMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
 	logger.Infof("Diagnostics: logs export requested.")
 	diagnosticsEntries, err := readRegMultiString(regKeyBase, diagnosticsRegKey)
 	if err != nil && err != errRegNotExist {
 		return err
 	}
 
-	strEntry := newMetadata.Instance.Attributes.Diagnostics
+	strEntry := newMd.Instance.Attributes.Diagnostics
 	if slices.Contains(diagnosticsEntries, strEntry) {
 		return nil
 	}
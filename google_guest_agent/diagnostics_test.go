@@ -66,12 +66,11 @@ func TestDiagnosticsDisabled(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			reloadConfig(t, tt.data)
 
-			newMetadata = tt.md
 			mgr := diagnosticsMgr{
 				fakeWindows: true,
 			}
 
-			got, err := mgr.Disabled(ctx)
+			got, err := mgr.Disabled(ctx, tt.md)
 			if err != nil {
 				t.Errorf("Failed to run diagnosticsMgr's Disable() call: %+v", err)
 			}
@@ -0,0 +1,191 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// defaultNameserver is the metadata server, serving the internal DNS.
+	defaultNameserver = "169.254.169.254"
+)
+
+var (
+	// searchDomainRegex matches the valid search domains, the metadata values end up
+	// in configuration files and commands.
+	searchDomainRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+)
+
+// dnsMgr sets the nameservers and the search domains from the metadata, keeping the
+// internal DNS working where other software overrides the DHCP options.
+type dnsMgr struct{}
+
+// dnsConfig is the DNS configuration set by the DNS manager.
+type dnsConfig struct {
+	nameservers   []string
+	searchDomains []string
+}
+
+// equal returns true if c and other are the same configuration.
+func (c dnsConfig) equal(other dnsConfig) bool {
+	return slices.Equal(c.nameservers, other.nameservers) && slices.Equal(c.searchDomains, other.searchDomains)
+}
+
+// String returns the configuration as logged and reported by DryRun().
+func (c dnsConfig) String() string {
+	return fmt.Sprintf("nameservers %q and search domains %q", c.nameservers, c.searchDomains)
+}
+
+// desiredDNS returns the DNS configuration to set from, by precedence, the [DNS]
+// configuration section, the instance and the project dns-nameservers and
+// dns-search-domains attributes, and the internal DNS defaults. The invalid entries
+// are skipped.
+func desiredDNS(config *cfg.Sections, md *metadata.Descriptor) dnsConfig {
+	var res dnsConfig
+
+	nameservers := firstNonEmpty(config.DNS.Nameservers, md.Instance.Attributes.DNSNameservers, md.Project.Attributes.DNSNameservers)
+	for _, ns := range splitDNSList(nameservers) {
+		if net.ParseIP(ns) == nil {
+			logger.Warningf("Skipping invalid nameserver %q.", ns)
+			continue
+		}
+		res.nameservers = append(res.nameservers, ns)
+	}
+	if len(res.nameservers) == 0 {
+		res.nameservers = []string{defaultNameserver}
+	}
+
+	domains := firstNonEmpty(config.DNS.SearchDomains, md.Instance.Attributes.DNSSearchDomains, md.Project.Attributes.DNSSearchDomains)
+	for _, domain := range splitDNSList(domains) {
+		if !searchDomainRegex.MatchString(domain) {
+			logger.Warningf("Skipping invalid search domain %q.", domain)
+			continue
+		}
+		res.searchDomains = append(res.searchDomains, domain)
+	}
+	if len(res.searchDomains) == 0 {
+		res.searchDomains = defaultSearchDomains(md)
+	}
+	return res
+}
+
+// defaultSearchDomains returns the internal DNS search domains of md's hostname,
+// i.e. "zone.c.project.internal", "c.project.internal" and "google.internal".
+func defaultSearchDomains(md *metadata.Descriptor) []string {
+	var res []string
+	_, domain, _ := strings.Cut(strings.TrimSuffix(md.Instance.Hostname, "."), ".")
+	if strings.HasSuffix(domain, ".internal") && searchDomainRegex.MatchString(domain) {
+		res = append(res, domain)
+		// The zonal DNS names are also resolved globally.
+		if _, global, ok := strings.Cut(domain, "."); ok && strings.HasPrefix(global, "c.") {
+			res = append(res, global)
+		}
+	}
+	return append(res, "google.internal")
+}
+
+// splitDNSList splits the comma or space separated list s.
+func splitDNSList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' })
+}
+
+// firstNonEmpty returns the first of values not empty.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// dnsChanges returns the changes Set() would make to apply md.
+func dnsChanges(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) ([]string, error) {
+	want := desiredDNS(config, md)
+	current, err := currentDNS(ctx, md)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the current DNS configuration: %w", err)
+	}
+	if current.equal(want) {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("set %s", want)}, nil
+}
+
+// Diff returns true on the first run or if the DNS configuration from the metadata
+// changed.
+func (d *dnsMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	config := cfg.Get()
+	return oldMd.Project.ProjectID == "" || !desiredDNS(config, oldMd).equal(desiredDNS(config, newMd)), nil
+}
+
+// Timeout returns false, the DNS manager doesn't run periodically.
+func (d *dnsMgr) Timeout(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// Disabled returns true unless enabled in the [DNS] configuration section.
+func (d *dnsMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	return !cfg.Get().DNS.Enabled, nil
+}
+
+// Set sets the nameservers and the search domains if they differ from the current
+// ones.
+func (d *dnsMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	config := cfg.Get()
+	changes, err := dnsChanges(ctx, config, newMd)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	want := desiredDNS(config, newMd)
+	logger.Infof("Setting %s.", want)
+	if err := setDNS(ctx, newMd, want); err != nil {
+		return fmt.Errorf("failed to set %s: %w", want, err)
+	}
+	return nil
+}
+
+// DryRun returns the DNS configuration Set() would change.
+func (d *dnsMgr) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	return dnsChanges(ctx, cfg.Get(), newMd)
+}
+
+// reapplyDNS re-applies the DNS configuration after a DHCP lease renewal, the DHCP
+// client hooks of some distributions reset it.
+func reapplyDNS(ctx context.Context, evType string, evData *events.EventData) {
+	if evData.Error != nil {
+		logger.Debugf("DHCP lease watcher %q failed: %+v", evType, evData.Error)
+		return
+	}
+
+	m := managerByName("dns")
+	_, md := metadataSnapshot()
+	if m == nil || md == nil {
+		return
+	}
+	m.execute(ctx, md, md, applyOnDrift)
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDesiredDNS(t *testing.T) {
+	tests := []struct {
+		name   string
+		config cfg.DNS
+		md     func(md *metadata.Descriptor)
+		want   dnsConfig
+	}{
+		{
+			name: "defaults",
+			want: dnsConfig{[]string{"169.254.169.254"}, []string{"us-central1-a.c.my-project.internal", "c.my-project.internal", "google.internal"}},
+		},
+		{
+			name: "global hostname",
+			md:   func(md *metadata.Descriptor) { md.Instance.Hostname = "vm.c.my-project.internal" },
+			want: dnsConfig{[]string{"169.254.169.254"}, []string{"c.my-project.internal", "google.internal"}},
+		},
+		{
+			name: "custom hostname",
+			md:   func(md *metadata.Descriptor) { md.Instance.Hostname = "vm.example.com" },
+			want: dnsConfig{[]string{"169.254.169.254"}, []string{"google.internal"}},
+		},
+		{
+			name: "project attributes",
+			md: func(md *metadata.Descriptor) {
+				md.Project.Attributes.DNSNameservers = "10.0.0.53, 10.0.1.53"
+				md.Project.Attributes.DNSSearchDomains = "example.com corp.example.com"
+			},
+			want: dnsConfig{[]string{"10.0.0.53", "10.0.1.53"}, []string{"example.com", "corp.example.com"}},
+		},
+		{
+			name: "instance attributes override project",
+			md: func(md *metadata.Descriptor) {
+				md.Project.Attributes.DNSNameservers = "10.0.0.53"
+				md.Instance.Attributes.DNSNameservers = "10.0.2.53"
+			},
+			want: dnsConfig{[]string{"10.0.2.53"}, []string{"us-central1-a.c.my-project.internal", "c.my-project.internal", "google.internal"}},
+		},
+		{
+			name:   "config overrides metadata",
+			config: cfg.DNS{Nameservers: "fd00::53", SearchDomains: "example.org"},
+			md: func(md *metadata.Descriptor) {
+				md.Instance.Attributes.DNSNameservers = "10.0.2.53"
+				md.Instance.Attributes.DNSSearchDomains = "example.com"
+			},
+			want: dnsConfig{[]string{"fd00::53"}, []string{"example.org"}},
+		},
+		{
+			name: "invalid entries skipped",
+			md: func(md *metadata.Descriptor) {
+				md.Instance.Attributes.DNSNameservers = "10.0.0.53,not-an-ip"
+				md.Instance.Attributes.DNSSearchDomains = "example.com,bad;domain,-bad.com"
+			},
+			want: dnsConfig{[]string{"10.0.0.53"}, []string{"example.com"}},
+		},
+		{
+			name: "all invalid falls back to defaults",
+			md: func(md *metadata.Descriptor) {
+				md.Instance.Attributes.DNSNameservers = "$(reboot)"
+				md.Instance.Attributes.DNSSearchDomains = "a'b"
+			},
+			want: dnsConfig{[]string{"169.254.169.254"}, []string{"us-central1-a.c.my-project.internal", "c.my-project.internal", "google.internal"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			md := &metadata.Descriptor{}
+			md.Project.ProjectID = "my-project"
+			md.Instance.Hostname = "vm.us-central1-a.c.my-project.internal"
+			if tc.md != nil {
+				tc.md(md)
+			}
+			config := &cfg.Sections{DNS: &tc.config}
+
+			got := desiredDNS(config, md)
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(dnsConfig{})); diff != "" {
+				t.Errorf("desiredDNS() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
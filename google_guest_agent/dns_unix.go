@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+const (
+	// dnsComment marks the DNS configuration written by the agent.
+	dnsComment = "# Added by Google Compute Engine Guest Agent."
+)
+
+var (
+	// resolvConfFile is the resolver configuration file, set if systemd-resolved
+	// isn't running.
+	resolvConfFile = "/etc/resolv.conf"
+	// resolvedDropinFile is the systemd-resolved configuration drop-in set if it's
+	// running.
+	resolvedDropinFile = "/etc/systemd/resolved.conf.d/google-guest-agent.conf"
+	// resolvedRuntimeDir exists if systemd-resolved is running.
+	resolvedRuntimeDir = "/run/systemd/resolve"
+)
+
+// usesResolved returns true if systemd-resolved is running.
+func usesResolved() bool {
+	return utils.FileExists(resolvedRuntimeDir, utils.TypeDir)
+}
+
+// currentDNS returns the DNS configuration of the systemd-resolved drop-in if it's
+// running, of the resolv.conf file otherwise.
+func currentDNS(ctx context.Context, md *metadata.Descriptor) (dnsConfig, error) {
+	path := resolvConfFile
+	if usesResolved() {
+		path = resolvedDropinFile
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return dnsConfig{}, nil
+	}
+	if err != nil {
+		return dnsConfig{}, err
+	}
+
+	if usesResolved() {
+		return parseResolvedDropin(string(contents)), nil
+	}
+	return parseResolvConf(string(contents)), nil
+}
+
+// setDNS writes dns as a systemd-resolved drop-in, reloading it, if it's running,
+// to the resolv.conf file otherwise.
+func setDNS(ctx context.Context, md *metadata.Descriptor, dns dnsConfig) error {
+	if !usesResolved() {
+		contents, err := os.ReadFile(resolvConfFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.WriteFile(resolvConfFile, []byte(updateResolvConf(string(contents), dns)), 0644)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(resolvedDropinFile), 0755); err != nil {
+		return err
+	}
+	contents := fmt.Sprintf("%s\n[Resolve]\nDNS=%s\nDomains=%s\n", dnsComment, strings.Join(dns.nameservers, " "), strings.Join(dns.searchDomains, " "))
+	if err := os.WriteFile(resolvedDropinFile, []byte(contents), 0644); err != nil {
+		return err
+	}
+	owned.recordFile(resolvedDropinFile)
+
+	if err := run.Quiet(ctx, "systemctl", "try-reload-or-restart", "systemd-resolved"); err != nil {
+		return fmt.Errorf("failed to reload systemd-resolved: %w", err)
+	}
+	return nil
+}
+
+// parseResolvedDropin parses the DNS and Domains of a systemd-resolved drop-in.
+func parseResolvedDropin(contents string) dnsConfig {
+	var res dnsConfig
+	for _, line := range strings.Split(contents, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "DNS":
+			res.nameservers = strings.Fields(value)
+		case "Domains":
+			res.searchDomains = strings.Fields(value)
+		}
+	}
+	return res
+}
+
+// parseResolvConf parses the nameservers and the search domains of a resolv.conf
+// file, the last search or domain line wins.
+func parseResolvConf(contents string) dnsConfig {
+	var res dnsConfig
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			res.nameservers = append(res.nameservers, fields[1])
+		case "search", "domain":
+			res.searchDomains = fields[1:]
+		}
+	}
+	return res
+}
+
+// updateResolvConf returns the resolv.conf contents with the nameservers and the
+// search domains of dns replacing the existing ones, the other lines are kept.
+func updateResolvConf(contents string, dns dnsConfig) string {
+	lines := []string{dnsComment}
+	for _, line := range strings.Split(strings.TrimSuffix(contents, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if line == dnsComment || (len(fields) > 0 && (fields[0] == "nameserver" || fields[0] == "search" || fields[0] == "domain")) {
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	lines = append(lines, "search "+strings.Join(dns.searchDomains, " "))
+	for _, ns := range dns.nameservers {
+		lines = append(lines, "nameserver "+ns)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateResolvConf(t *testing.T) {
+	dns := dnsConfig{[]string{"10.0.0.53", "10.0.1.53"}, []string{"example.com", "google.internal"}}
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "empty",
+			contents: "",
+			want:     dnsComment + "\nsearch example.com google.internal\nnameserver 10.0.0.53\nnameserver 10.0.1.53\n",
+		},
+		{
+			name:     "dhcp written",
+			contents: "domain c.my-project.internal\nsearch c.my-project.internal google.internal\nnameserver 169.254.169.254\noptions edns0\n",
+			want:     dnsComment + "\noptions edns0\nsearch example.com google.internal\nnameserver 10.0.0.53\nnameserver 10.0.1.53\n",
+		},
+		{
+			name:     "already updated",
+			contents: dnsComment + "\n# Other comment\nsearch old.example.com\nnameserver 10.0.0.1\n",
+			want:     dnsComment + "\n# Other comment\nsearch example.com google.internal\nnameserver 10.0.0.53\nnameserver 10.0.1.53\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := updateResolvConf(tc.contents, dns)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("updateResolvConf() returned unexpected diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(dns, parseResolvConf(got), cmp.AllowUnexported(dnsConfig{})); diff != "" {
+				t.Errorf("parseResolvConf() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseResolvedDropin(t *testing.T) {
+	contents := dnsComment + "\n[Resolve]\nDNS=10.0.0.53 fd00::53\nDomains=example.com google.internal\n"
+	want := dnsConfig{[]string{"10.0.0.53", "fd00::53"}, []string{"example.com", "google.internal"}}
+
+	if diff := cmp.Diff(want, parseResolvedDropin(contents), cmp.AllowUnexported(dnsConfig{})); diff != "" {
+		t.Errorf("parseResolvedDropin() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetDNSResolvConf(t *testing.T) {
+	oldResolvConf, oldRuntimeDir := resolvConfFile, resolvedRuntimeDir
+	t.Cleanup(func() { resolvConfFile, resolvedRuntimeDir = oldResolvConf, oldRuntimeDir })
+
+	dir := t.TempDir()
+	resolvConfFile = filepath.Join(dir, "resolv.conf")
+	resolvedRuntimeDir = filepath.Join(dir, "resolve")
+	if err := os.WriteFile(resolvConfFile, []byte("nameserver 169.254.169.254\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%s) = %v, want nil", resolvConfFile, err)
+	}
+
+	ctx := context.Background()
+	md := &metadata.Descriptor{}
+	dns := dnsConfig{[]string{"10.0.0.53"}, []string{"example.com"}}
+	if err := setDNS(ctx, md, dns); err != nil {
+		t.Fatalf("setDNS() = %v, want nil", err)
+	}
+
+	got, err := currentDNS(ctx, md)
+	if err != nil {
+		t.Fatalf("currentDNS() = %v, want nil", err)
+	}
+	if diff := cmp.Diff(dns, got, cmp.AllowUnexported(dnsConfig{})); diff != "" {
+		t.Errorf("currentDNS() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+// primaryInterfaceIndex returns the interface index of md's primary NIC.
+func primaryInterfaceIndex(md *metadata.Descriptor) (int, error) {
+	if len(md.Instance.NetworkInterfaces) == 0 {
+		return 0, errors.New("no network interface in the metadata")
+	}
+	iface, err := network.GetInterfaceByMAC(md.Instance.NetworkInterfaces[0].Mac)
+	if err != nil {
+		return 0, err
+	}
+	return iface.Index, nil
+}
+
+// runPowershell runs the powershell command psCmd and returns its output.
+func runPowershell(ctx context.Context, psCmd string) (string, error) {
+	res := run.WithOutput(ctx, "powershell", "-NoProfile", "-NonInteractive", "-c", psCmd)
+	if res.ExitCode != 0 {
+		return "", res
+	}
+	return strings.TrimSpace(res.StdOut), nil
+}
+
+// currentDNS returns the IPv4 DNS servers of the primary NIC and the global suffix
+// search list.
+func currentDNS(ctx context.Context, md *metadata.Descriptor) (dnsConfig, error) {
+	index, err := primaryInterfaceIndex(md)
+	if err != nil {
+		return dnsConfig{}, err
+	}
+
+	var res dnsConfig
+	out, err := runPowershell(ctx, fmt.Sprintf("(Get-DnsClientServerAddress -InterfaceIndex %d -AddressFamily IPv4).ServerAddresses -join ','", index))
+	if err != nil {
+		return dnsConfig{}, fmt.Errorf("failed to get the DNS servers: %w", err)
+	}
+	res.nameservers = splitDNSList(out)
+
+	out, err = runPowershell(ctx, "(Get-DnsClientGlobalSetting).SuffixSearchList -join ','")
+	if err != nil {
+		return dnsConfig{}, fmt.Errorf("failed to get the DNS suffix search list: %w", err)
+	}
+	res.searchDomains = splitDNSList(out)
+	return res, nil
+}
+
+// setDNS sets dns' nameservers as the DNS servers of the primary NIC and its search
+// domains as the global suffix search list. The values were validated by
+// desiredDNS(), they're safe to quote.
+func setDNS(ctx context.Context, md *metadata.Descriptor, dns dnsConfig) error {
+	index, err := primaryInterfaceIndex(md)
+	if err != nil {
+		return err
+	}
+
+	psCmd := fmt.Sprintf("Set-DnsClientServerAddress -InterfaceIndex %d -ServerAddresses %s", index, psList(dns.nameservers))
+	if _, err := runPowershell(ctx, psCmd); err != nil {
+		return fmt.Errorf("failed to set the DNS servers: %w", err)
+	}
+
+	psCmd = fmt.Sprintf("Set-DnsClientGlobalSetting -SuffixSearchList %s", psList(dns.searchDomains))
+	if _, err := runPowershell(ctx, psCmd); err != nil {
+		return fmt.Errorf("failed to set the DNS suffix search list: %w", err)
+	}
+	return nil
+}
+
+// psList returns values as a powershell array of strings.
+func psList(values []string) string {
+	return fmt.Sprintf("@('%s')", strings.Join(values, "','"))
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package download fetches the content of the gs:// and http(s):// URLs referenced
+// by the agent's configuration and metadata, i.e. attribute sources or DSC
+// configuration documents.
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+// Get fetches the content of url, url is either a gs:// or a http(s):// URL. Failed
+// attempts are retried according to policy, the download is given up after timeout,
+// retries included.
+func Get(ctx context.Context, url string, policy retry.Policy, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if path, found := strings.CutPrefix(url, "gs://"); found {
+		bucket, object, found := strings.Cut(path, "/")
+		if !found || bucket == "" || object == "" {
+			return nil, fmt.Errorf("invalid gs url: %q", url)
+		}
+		return getGS(ctx, bucket, object, policy)
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported url scheme: %q", url)
+	}
+
+	return retry.RunWithResponse(ctx, policy, func() ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %q, bad status: %s", url, res.Status)
+		}
+		return io.ReadAll(res.Body)
+	})
+}
+
+// getGS fetches the content of object from the Cloud Storage bucket.
+func getGS(ctx context.Context, bucket, object string, policy retry.Policy) ([]byte, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	defer client.Close()
+
+	return retry.RunWithResponse(ctx, policy, func() ([]byte, error) {
+		r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	})
+}
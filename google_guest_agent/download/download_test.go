@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+func TestGet(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/document":
+			fmt.Fprint(w, "content")
+		case "/hanging":
+			<-release
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "http", url: srv.URL + "/document", want: "content"},
+		{name: "bad status", url: srv.URL + "/missing", wantErr: true},
+		{name: "timeout", url: srv.URL + "/hanging", wantErr: true},
+		{name: "unsupported scheme", url: "ftp://example.com/document", wantErr: true},
+		{name: "invalid gs url", url: "gs://bucket", wantErr: true},
+	}
+
+	policy := retry.Policy{MaxAttempts: 1}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Get(context.Background(), tc.url, policy, 100*time.Millisecond)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Get(%q) = %v, want error: %t", tc.url, err, tc.wantErr)
+			}
+			if string(got) != tc.want {
+				t.Errorf("Get(%q) = %q, want: %q", tc.url, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// inflight tracks the event handlers currently running, so the agent can let
+// them finish before shutting down.
+var inflight = &drainTracker{}
+
+// drainTracker tracks in-flight work and allows waiting for its completion.
+type drainTracker struct {
+	// mutex protects draining and serializes it with wg.Add().
+	mutex sync.Mutex
+	// draining is set once drain() is called, no new work is accepted afterwards.
+	draining bool
+	// wg counts the in-flight work.
+	wg sync.WaitGroup
+}
+
+// begin registers new in-flight work, it returns false if the tracker is
+// draining in which case the work must not be started.
+func (d *drainTracker) begin() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.wg.Add(1)
+	return true
+}
+
+// end reports the completion of work previously registered with begin().
+func (d *drainTracker) end() {
+	d.wg.Done()
+}
+
+// drain stops accepting new work and waits for the in-flight work to complete,
+// it returns false if timeout expired before the work completed.
+func (d *drainTracker) drain(timeout time.Duration) bool {
+	d.mutex.Lock()
+	d.draining = true
+	d.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
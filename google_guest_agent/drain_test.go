@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	d := &drainTracker{}
+
+	if !d.begin() {
+		t.Fatalf("begin() = false before draining, want: true")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		d.end()
+	}()
+
+	if !d.drain(5 * time.Second) {
+		t.Errorf("drain() = false, want in-flight work to complete before the timeout")
+	}
+
+	if d.begin() {
+		t.Errorf("begin() = true while draining, want: false")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	d := &drainTracker{}
+
+	if !d.begin() {
+		t.Fatalf("begin() = false before draining, want: true")
+	}
+	defer d.end()
+
+	if d.drain(100 * time.Millisecond) {
+		t.Errorf("drain() = true with work still in-flight, want: false")
+	}
+}
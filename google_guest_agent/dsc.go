@@ -0,0 +1,219 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/download"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// dscStatusKey is the guest attribute the DSC application status is reported to.
+	dscStatusKey = "dsc/status"
+	// dscDocumentName is the name of the downloaded document, Start-DscConfiguration
+	// applies the localhost.mof document found in the configuration directory.
+	dscDocumentName = "localhost.mof"
+)
+
+var (
+	dscDisabled = true
+	// Indicate whether an existing job is applying a configuration document.
+	// 0 -> not running, 1 -> running
+	isDSCRunning int32 = 0
+	// dscRetryPolicy is the policy used when downloading a configuration document.
+	dscRetryPolicy = retry.Policy{MaxAttempts: 3, BackoffFactor: 1, Jitter: time.Second}
+	// dscDownloadTimeout bounds the download of a configuration document, retries
+	// included.
+	dscDownloadTimeout = 5 * time.Minute
+	// dscApplied is the URL of the last successfully applied configuration document.
+	dscApplied atomic.Value
+)
+
+// dscStatus is the DSC application status reported to guest attributes.
+type dscStatus struct {
+	// Config is the URL of the configuration document.
+	Config string `json:"config"`
+	// Status is one of applying, applied or failed.
+	Status string `json:"status"`
+	// Error is the failure reason, only set if Status is failed.
+	Error string `json:"error,omitempty"`
+	// Timestamp is the time the status was reported in RFC3339 format.
+	Timestamp string `json:"timestamp"`
+}
+
+type dscMgr struct {
+	// fakeWindows forces Disabled to run as if it was running in a windows system.
+	// mostly target for unit tests.
+	fakeWindows bool
+}
+
+// dscConfig returns the configuration document URL, instance metadata takes
+// precedence over project metadata.
+func dscConfig(md *metadata.Descriptor) string {
+	if md.Instance.Attributes.WindowsDSCConfig != "" {
+		return md.Instance.Attributes.WindowsDSCConfig
+	}
+	return md.Project.Attributes.WindowsDSCConfig
+}
+
+// appliedDSCConfig returns the URL of the last successfully applied configuration
+// document, empty if none was.
+func appliedDSCConfig() string {
+	applied, _ := dscApplied.Load().(string)
+	return applied
+}
+
+// Diff compares the configured document with the last one successfully applied,
+// rather than with the previous metadata, so a document failing to be applied is
+// tried again.
+func (d *dscMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return dscConfig(newMd) != appliedDSCConfig(), nil
+}
+
+func (d *dscMgr) Timeout(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func (d *dscMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	var disabled bool
+	config := cfg.Get()
+
+	if !d.fakeWindows && runtime.GOOS != "windows" {
+		return true, nil
+	}
+
+	defer func() {
+		if disabled != dscDisabled {
+			dscDisabled = disabled
+			logStatus("dsc", disabled)
+		}
+	}()
+
+	// DSC is opt-in and disabled by default.
+	if config.DSC != nil {
+		disabled = !config.DSC.Enable
+		return disabled, nil
+	}
+
+	if newMd.Instance.Attributes.EnableWindowsDSC != nil {
+		disabled = !*newMd.Instance.Attributes.EnableWindowsDSC
+		return disabled, nil
+	}
+	if newMd.Project.Attributes.EnableWindowsDSC != nil {
+		disabled = !*newMd.Project.Attributes.EnableWindowsDSC
+		return disabled, nil
+	}
+
+	disabled = true
+	return disabled, nil
+}
+
+func (d *dscMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	configURL := dscConfig(newMd)
+	if configURL == "" {
+		logger.Infof("DSC: no configuration document defined, nothing to apply.")
+		dscApplied.Store("")
+		return nil
+	}
+
+	// If no existing running job, set it to 1 and block other requests.
+	if !atomic.CompareAndSwapInt32(&isDSCRunning, 0, 1) {
+		return fmt.Errorf("a configuration document is already being applied, rejecting %q", configURL)
+	}
+
+	// The document is applied after Set() returns, and the manager's run context
+	// is canceled.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		// Job is done, unblock the following requests.
+		defer atomic.SwapInt32(&isDSCRunning, 0)
+
+		reportDSCStatus(ctx, dscStatus{Config: configURL, Status: "applying"})
+
+		if err := applyDSCConfig(ctx, configURL); err != nil {
+			logger.Errorf("DSC: failed to apply configuration document %q: %v", configURL, err)
+			reportDSCStatus(ctx, dscStatus{Config: configURL, Status: "failed", Error: err.Error()})
+			return
+		}
+
+		logger.Infof("DSC: configuration document %q applied.", configURL)
+		dscApplied.Store(configURL)
+		reportDSCStatus(ctx, dscStatus{Config: configURL, Status: "applied"})
+	}()
+
+	return nil
+}
+
+// applyDSCConfig downloads the configuration document and applies it with
+// Start-DscConfiguration.
+func applyDSCConfig(ctx context.Context, configURL string) error {
+	if !strings.EqualFold(filepath.Ext(configURL), ".mof") {
+		return fmt.Errorf("unsupported configuration document %q, only compiled .mof documents are supported", configURL)
+	}
+
+	content, err := download.Get(ctx, configURL, dscRetryPolicy, dscDownloadTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to download configuration document: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "gce-dsc")
+	if err != nil {
+		return fmt.Errorf("failed to create configuration directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := utils.SaferWriteFile(content, filepath.Join(dir, dscDocumentName), 0600); err != nil {
+		return fmt.Errorf("failed to write configuration document: %w", err)
+	}
+
+	psCmd := fmt.Sprintf("Start-DscConfiguration -Path '%s' -Wait -Force -Verbose -ErrorAction Stop", dir)
+	res := run.WithCombinedOutput(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", psCmd)
+	logger.Debugf("DSC: Start-DscConfiguration output: %s", res.Combined)
+	if res.ExitCode != 0 {
+		return fmt.Errorf("Start-DscConfiguration failed: %s", res.Error())
+	}
+
+	return nil
+}
+
+// reportDSCStatus writes status to the dsc/status guest attribute.
+func reportDSCStatus(ctx context.Context, status dscStatus) {
+	status.Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		logger.Errorf("DSC: failed to marshal status: %v", err)
+		return
+	}
+
+	if err := mdsClient.WriteGuestAttributes(ctx, dscStatusKey, string(data)); err != nil {
+		logger.Errorf("DSC: failed to report status to guest attributes: %v", err)
+	}
+}
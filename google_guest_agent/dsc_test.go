@@ -0,0 +1,99 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+func TestDSCDisabled(t *testing.T) {
+	var tests = []struct {
+		name string
+		data []byte
+		md   *metadata.Descriptor
+		want bool
+	}{
+		{"not explicitly enabled", []byte(""), &metadata.Descriptor{}, true},
+		{"enabled in cfg only", []byte("[dsc]\nenable=true"), &metadata.Descriptor{}, false},
+		{"disabled in cfg, enabled in instance metadata", []byte("[dsc]\nenable=false"), &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{EnableWindowsDSC: mkptr(true)}}}, true},
+		{"enabled in instance metadata only", []byte(""), &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{EnableWindowsDSC: mkptr(true)}}}, false},
+		{"enabled in project metadata only", []byte(""), &metadata.Descriptor{Project: metadata.Project{Attributes: metadata.Attributes{EnableWindowsDSC: mkptr(true)}}}, false},
+		{"disabled in instance metadata, enabled in project metadata", []byte(""), &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{EnableWindowsDSC: mkptr(false)}}, Project: metadata.Project{Attributes: metadata.Attributes{EnableWindowsDSC: mkptr(true)}}}, true},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloadConfig(t, tt.data)
+
+			mgr := dscMgr{
+				fakeWindows: true,
+			}
+
+			got, err := mgr.Disabled(ctx, tt.md)
+			if err != nil {
+				t.Errorf("Failed to run dscMgr's Disabled() call: %+v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("test case %q, dsc.disabled() got: %t, want: %t", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDSCDiff(t *testing.T) {
+	mkmd := func(instance, project string) *metadata.Descriptor {
+		return &metadata.Descriptor{
+			Instance: metadata.Instance{Attributes: metadata.Attributes{WindowsDSCConfig: instance}},
+			Project:  metadata.Project{Attributes: metadata.Attributes{WindowsDSCConfig: project}},
+		}
+	}
+
+	var tests = []struct {
+		name     string
+		applied  string
+		old, new *metadata.Descriptor
+		want     bool
+	}{
+		{"no config", "", mkmd("", ""), mkmd("", ""), false},
+		{"instance config added", "", mkmd("", ""), mkmd("gs://bucket/a.mof", ""), true},
+		{"project config changed", "gs://bucket/a.mof", mkmd("", "gs://bucket/a.mof"), mkmd("", "gs://bucket/b.mof"), true},
+		{"project config changed, instance overrides", "gs://bucket/i.mof", mkmd("gs://bucket/i.mof", "gs://bucket/a.mof"), mkmd("gs://bucket/i.mof", "gs://bucket/b.mof"), false},
+		{"config failed to apply", "", mkmd("", "gs://bucket/a.mof"), mkmd("", "gs://bucket/a.mof"), true},
+		{"config removed", "gs://bucket/a.mof", mkmd("", "gs://bucket/a.mof"), mkmd("", ""), true},
+	}
+
+	t.Cleanup(func() { dscApplied.Store("") })
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := dscMgr{}
+			dscApplied.Store(tt.applied)
+
+			got, err := mgr.Diff(ctx, tt.old, tt.new)
+			if err != nil {
+				t.Errorf("Failed to run dscMgr's Diff() call: %+v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("test case %q, dsc.Diff() got: %t, want: %t", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+// dumpConfigMetadataTimeout is how long dumpConfig waits for the metadata server
+// when fetching the instance-configs overrides.
+const dumpConfigMetadataTimeout = 10 * time.Second
+
+// dumpConfig writes the effective configuration to w, each key followed by the
+// source setting it, i.e. a configuration file, the registry or metadata. It's
+// meant to debug why a manager is enabled or disabled.
+func dumpConfig(ctx context.Context, client metadata.MDSClientInterface, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, dumpConfigMetadataTimeout)
+	defer cancel()
+
+	fmt.Fprintln(w, "# Effective configuration, each key is followed by the source setting it.")
+
+	md, err := client.Get(ctx)
+	if err != nil {
+		fmt.Fprintf(w, "# instance-configs metadata overrides not included, failed to get metadata: %v\n", err)
+	} else if err := cfg.SetOverrides(md.Project.Attributes.InstanceConfigs, md.Instance.Attributes.InstanceConfigs); err != nil {
+		fmt.Fprintf(w, "# instance-configs metadata overrides not included, they are ignored by the agent: %v\n", err)
+	}
+
+	settings, err := cfg.Effective()
+	if err != nil {
+		return err
+	}
+
+	var section string
+	for _, setting := range settings {
+		if setting.Section != section {
+			section = setting.Section
+			fmt.Fprintf(w, "\n[%s]\n", section)
+		}
+		fmt.Fprintf(w, "%s = %s ; %s\n", setting.Key, setting.Value, setting.Source)
+	}
+
+	return nil
+}
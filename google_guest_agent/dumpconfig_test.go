@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+// dumpConfigMDS is a metadata client returning a fixed descriptor or error.
+type dumpConfigMDS struct {
+	md  *metadata.Descriptor
+	err error
+}
+
+func (m *dumpConfigMDS) Get(context.Context) (*metadata.Descriptor, error) {
+	return m.md, m.err
+}
+
+func (m *dumpConfigMDS) GetKey(context.Context, string, map[string]string) (string, error) {
+	return "", nil
+}
+
+func (m *dumpConfigMDS) GetKeyRecursive(context.Context, string) (string, error) {
+	return "", nil
+}
+
+func (m *dumpConfigMDS) Watch(context.Context) (*metadata.Descriptor, error) {
+	return m.md, m.err
+}
+
+func (m *dumpConfigMDS) WriteGuestAttributes(context.Context, string, string) error {
+	return nil
+}
+
+func TestDumpConfig(t *testing.T) {
+	ctx := context.Background()
+	t.Cleanup(func() {
+		cfg.SetOverrides()
+		reloadConfig(t, nil)
+	})
+
+	md := &metadata.Descriptor{}
+	md.Instance.Attributes.InstanceConfigs = "[accountManager]\ndisable = true\n"
+
+	var buf bytes.Buffer
+	if err := dumpConfig(ctx, &dumpConfigMDS{md: md}, &buf); err != nil {
+		t.Fatalf("dumpConfig() failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"[accountmanager]\ndisable = true ; instance-configs metadata\n",
+		"[core]\n",
+		"cloud_logging_enabled = true ; defaults\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("dumpConfig() output doesn't contain %q, got:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := dumpConfig(ctx, &dumpConfigMDS{err: fmt.Errorf("no metadata server")}, &buf); err != nil {
+		t.Fatalf("dumpConfig() without metadata failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "overrides not included") {
+		t.Errorf("dumpConfig() without metadata didn't note the overrides are missing, got:\n%s", buf.String())
+	}
+}
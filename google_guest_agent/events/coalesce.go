@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// coalescer merges the events of an event type produced in a burst, only the
+// latest event is dispatched once no new event was produced for the debounce
+// window.
+type coalescer struct {
+	// mutex protects pending and timer.
+	mutex sync.Mutex
+
+	// sendMutex serializes the flushes so the events are dispatched in order.
+	sendMutex sync.Mutex
+
+	// window is the debounce window.
+	window time.Duration
+
+	// pending is the latest event not yet dispatched, nil if there's none.
+	pending *eventBusData
+
+	// timer flushes the pending event once the window expires.
+	timer *time.Timer
+}
+
+// EnableCoalescing enables the coalescing of the provided event types, events
+// produced less than window apart are merged and the subscribers are only called
+// with the latest one once window has passed without a new event. It must be
+// called before Run().
+func (mngr *Manager) EnableCoalescing(window time.Duration, evTypes ...string) {
+	for _, curr := range evTypes {
+		mngr.coalescers[curr] = &coalescer{window: window}
+	}
+}
+
+// post replaces the pending event with data and restarts the debounce window, bus
+// is where the event is sent once the window expires, unless ctx is done. A failed
+// event doesn't replace a pending successful one, the subscribers would miss the
+// change. It returns true if data was merged with a pending event.
+func (c *coalescer) post(ctx context.Context, bus chan<- eventBusData, data eventBusData) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	merged := c.pending != nil
+	switch {
+	case !merged:
+		c.pending = &data
+	case c.pending.data.Error == nil && data.data.Error != nil:
+		logger.Debugf("Dropping event %q failure, a successful event is pending: %v", data.evType, data.data.Error)
+	default:
+		logger.Debugf("Coalescing event %q with the pending one", data.evType)
+		c.pending = &data
+	}
+
+	// If the timer already fired its flush takes this event, and the new timer
+	// finds nothing pending.
+	if c.timer != nil && c.timer.Stop() {
+		c.timer.Reset(c.window)
+		return merged
+	}
+	c.timer = time.AfterFunc(c.window, func() { c.flush(ctx, bus) })
+	return merged
+}
+
+// flush sends the pending event, if any, to bus unless ctx is done.
+func (c *coalescer) flush(ctx context.Context, bus chan<- eventBusData) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.mutex.Lock()
+	data := c.pending
+	c.pending = nil
+	c.mutex.Unlock()
+
+	if data == nil {
+		return
+	}
+
+	select {
+	case bus <- *data:
+	case <-ctx.Done():
+	}
+}
+
+// drain stops the debounce window and sends the pending event right away, it's
+// called when the watcher is done so its last event is not lost.
+func (c *coalescer) drain(ctx context.Context, bus chan<- eventBusData) {
+	c.mutex.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mutex.Unlock()
+	c.flush(ctx, bus)
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type burstWatcher struct {
+	// events are the events produced before the watcher blocks until the context
+	// is canceled or gives up.
+	events []int
+	// giveUp makes the watcher give up after producing its events.
+	giveUp bool
+}
+
+func (bw *burstWatcher) ID() string {
+	return "burst-watcher"
+}
+
+func (bw *burstWatcher) Events() []string {
+	return []string{"burst-watcher,test-event"}
+}
+
+func (bw *burstWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	if len(bw.events) == 0 {
+		<-ctx.Done()
+		return false, nil, nil
+	}
+	data := bw.events[0]
+	bw.events = bw.events[1:]
+	return !bw.giveUp || len(bw.events) > 0, data, nil
+}
+
+func TestCoalescing(t *testing.T) {
+	tests := []struct {
+		name   string
+		giveUp bool
+	}{
+		{
+			name: "debounce_window",
+		},
+		{
+			name:   "drain_on_give_up",
+			giveUp: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			eventManager := newManager()
+			eventManager.EnableCoalescing(50*time.Millisecond, "burst-watcher,test-event")
+
+			if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1, 2, 3, 4, 5}, giveUp: tc.giveUp}); err != nil {
+				t.Fatalf("Failed to add watcher to event manager: %+v", err)
+			}
+
+			var got []int
+			eventManager.Subscribe("burst-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+				if evData.Data != nil {
+					got = append(got, evData.Data.(int))
+				}
+				cancel()
+				return true
+			})
+
+			if err := eventManager.Run(ctx); err != nil {
+				t.Errorf("Failed to run event managed, expected success, got error: %+v", err)
+			}
+
+			if len(got) != 1 || got[0] != 5 {
+				t.Errorf("Coalesced subscriber got events %v, expected: [5]", got)
+			}
+		})
+	}
+}
+
+func TestCoalescingKeepsChangeOverError(t *testing.T) {
+	tests := []struct {
+		name   string
+		posted []*EventData
+		want   *EventData
+	}{
+		{
+			name:   "error_after_change",
+			posted: []*EventData{{Data: 1}, {Error: errors.New("failed")}},
+			want:   &EventData{Data: 1},
+		},
+		{
+			name:   "change_after_error",
+			posted: []*EventData{{Error: errors.New("failed")}, {Data: 2}},
+			want:   &EventData{Data: 2},
+		},
+		{
+			name:   "change_error_change",
+			posted: []*EventData{{Data: 1}, {Error: errors.New("failed")}, {Data: 2}},
+			want:   &EventData{Data: 2},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			bus := make(chan eventBusData, len(tc.posted))
+			c := &coalescer{window: time.Hour}
+
+			for _, data := range tc.posted {
+				c.post(ctx, bus, eventBusData{evType: "test-event", data: data})
+			}
+			c.drain(ctx, bus)
+
+			if len(bus) != 1 {
+				t.Fatalf("Coalescer flushed %d events, want 1", len(bus))
+			}
+			got := (<-bus).data
+			if got.Data != tc.want.Data || (got.Error != nil) != (tc.want.Error != nil) {
+				t.Errorf("Coalescer flushed %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
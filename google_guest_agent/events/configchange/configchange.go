@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package configchange implements the configuration change events watcher, it
+// relays the changes published by the code (re)loading the configuration to the
+// event manager so subscribers can reconfigure themselves.
+package configchange
+
+import (
+	"context"
+)
+
+const (
+	// WatcherID is the configuration change watcher's ID.
+	WatcherID = "config-change-watcher"
+	// ChangedEvent is the configuration changed event type ID, the event data is
+	// the source of the change.
+	ChangedEvent = "config-change-watcher,changed"
+)
+
+// changes relays the published changes to the watcher, a single pending change
+// is kept as subscribers read the whole configuration anyway.
+var changes = make(chan string, 1)
+
+// Publish notifies the configuration was changed by source, i.e. a reload request
+// or metadata overrides. It doesn't block, if a change is already pending this
+// one is coalesced with it.
+func Publish(source string) {
+	select {
+	case changes <- source:
+	default:
+	}
+}
+
+// Watcher is the configuration change event watcher implementation.
+type Watcher struct{}
+
+// New allocates and initializes a new Watcher.
+func New() *Watcher {
+	return &Watcher{}
+}
+
+// ID returns the configuration change event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{ChangedEvent}
+}
+
+// Run waits for a published change and report back the event.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	select {
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	case source := <-changes:
+		return true, source, nil
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configchange
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The second change is coalesced with the pending one.
+	Publish("first")
+	Publish("second")
+
+	renew, data, err := New().Run(ctx, ChangedEvent)
+	if !renew || err != nil {
+		t.Fatalf("Run() = (%t, %v), want: (true, nil)", renew, err)
+	}
+	if data != "first" {
+		t.Errorf("Run() returned data %v, want: first", data)
+	}
+
+	select {
+	case source := <-changes:
+		t.Errorf("Publish() didn't coalesce changes, got pending change %q", source)
+	default:
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	renew, _, err := New().Run(ctx, ChangedEvent)
+	if renew || err == nil {
+		t.Errorf("Run() with canceled context = (%t, %v), want: (false, error)", renew, err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package configfile implements the configuration file events watcher, it reports
+// changes to the configuration files so the agent can reload them.
+package configfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the configuration file watcher's ID.
+	WatcherID = "config-file-watcher"
+	// ChangedEvent is the configuration file changed event type ID.
+	ChangedEvent = "config-file-watcher,config-changed"
+)
+
+var (
+	// settleDelay is how long to wait after a change before checking the files,
+	// editors and config management tools often write a file in several steps.
+	settleDelay = time.Second
+
+	// pollInterval is how often the files are checked when the OS notification
+	// mechanism is not available.
+	pollInterval = 10 * time.Second
+)
+
+// fileState is the state of a watched file, a file that doesn't exist has a
+// zero state.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// notifier waits for changes in a set of directories.
+type notifier interface {
+	// wait blocks until something changes in the watched directories or ctx is
+	// done, it returns ctx's error in the latter case.
+	wait(ctx context.Context) error
+	// close releases the notifier resources.
+	close()
+}
+
+// Watcher is the configuration file event watcher implementation.
+type Watcher struct {
+	// files returns the watched configuration files, it's called on every check
+	// so files added to a watched directory are picked up.
+	files func() []string
+	// extraDirs are watched on top of the directories holding the files, i.e. a
+	// drop-in directory with no files yet.
+	extraDirs []string
+	// last is the files state when the last change was reported.
+	last map[string]fileState
+}
+
+// New allocates and initializes a new Watcher watching the files returned by
+// files and the directories dirs, changes are relative to the files state at the
+// time New is called.
+func New(files func() []string, dirs ...string) *Watcher {
+	w := &Watcher{files: files, extraDirs: dirs}
+	w.last = w.snapshot()
+	return w
+}
+
+// ID returns the configuration file event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{ChangedEvent}
+}
+
+// snapshot returns the current state of the watched files.
+func (w *Watcher) snapshot() map[string]fileState {
+	res := make(map[string]fileState)
+	for _, file := range w.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			res[file] = fileState{}
+			continue
+		}
+		res[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return res
+}
+
+// changed returns true if curr differs from the last reported state, including
+// files being added or removed.
+func (w *Watcher) changed(curr map[string]fileState) bool {
+	if len(curr) != len(w.last) {
+		return true
+	}
+	for file, state := range curr {
+		if !state.modTime.Equal(w.last[file].modTime) || state.size != w.last[file].size {
+			return true
+		}
+	}
+	return false
+}
+
+// dirs returns the directories containing the watched files. The directories are
+// watched rather than the files so files replaced by renaming are still noticed.
+func (w *Watcher) dirs() []string {
+	var dirs []string
+	for _, file := range w.files() {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+
+	seen := make(map[string]bool)
+	var res []string
+	for _, dir := range append(dirs, w.extraDirs...) {
+		if !seen[dir] {
+			seen[dir] = true
+			res = append(res, dir)
+		}
+	}
+	return res
+}
+
+// Run waits for a change to the configuration files and report back the event.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	n, err := newNotifier(w.dirs())
+	if err != nil {
+		logger.Debugf("Failed to set up configuration file notifications, polling instead: %v", err)
+		n = &pollNotifier{}
+	}
+	defer n.close()
+
+	for {
+		// Checking after the notifier is set up makes sure changes made between
+		// runs aren't missed.
+		if curr := w.snapshot(); w.changed(curr) {
+			w.last = curr
+			logger.Infof("Configuration file changed, requesting configuration reload.")
+			return true, nil, nil
+		}
+
+		if err := n.wait(ctx); err != nil {
+			return false, nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		case <-time.After(settleDelay):
+		}
+	}
+}
+
+// pollNotifier is the notifier used when the OS notification mechanism is not
+// available, it just waits for pollInterval.
+type pollNotifier struct{}
+
+func (p *pollNotifier) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(pollInterval):
+		return nil
+	}
+}
+
+func (p *pollNotifier) close() {}
@@ -0,0 +1,127 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	oldSettleDelay, oldPollInterval := settleDelay, pollInterval
+	t.Cleanup(func() { settleDelay, pollInterval = oldSettleDelay, oldPollInterval })
+	settleDelay, pollInterval = 10*time.Millisecond, 100*time.Millisecond
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "instance_configs.cfg")
+	if err := os.WriteFile(config, []byte("[Core]\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", config, err)
+	}
+
+	files := []string{config, config + ".distro", config + ".template"}
+	watcher := New(func() []string { return files })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan bool)
+	go func() {
+		renew, _, err := watcher.Run(ctx, ChangedEvent)
+		if err != nil {
+			t.Errorf("Run() returned error: %v", err)
+		}
+		result <- renew
+	}()
+
+	// Give Run() a chance to start waiting before creating a companion file.
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(config+".distro", []byte("[Accounts]\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", config+".distro", err)
+	}
+
+	select {
+	case renew := <-result:
+		if !renew {
+			t.Errorf("Run() returned renew: false, want: true")
+		}
+	case <-ctx.Done():
+		t.Fatalf("Run() didn't return after the configuration file changed")
+	}
+}
+
+func TestRunDropInAdded(t *testing.T) {
+	oldSettleDelay, oldPollInterval := settleDelay, pollInterval
+	t.Cleanup(func() { settleDelay, pollInterval = oldSettleDelay, oldPollInterval })
+	settleDelay, pollInterval = 10*time.Millisecond, 100*time.Millisecond
+
+	dropIn := filepath.Join(t.TempDir(), "instance_configs.cfg.d")
+	if err := os.Mkdir(dropIn, 0755); err != nil {
+		t.Fatalf("os.Mkdir(%q) failed: %v", dropIn, err)
+	}
+
+	files := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(dropIn, "*.cfg"))
+		return matches
+	}
+	watcher := New(files, dropIn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(filepath.Join(dropIn, "10-fragment.cfg"), []byte("[Core]\n"), 0644)
+	}()
+
+	renew, _, err := watcher.Run(ctx, ChangedEvent)
+	if !renew || err != nil {
+		t.Errorf("Run() = (%t, %v), want: (true, nil) after a fragment was added", renew, err)
+	}
+}
+
+func TestRunIgnoresOtherFiles(t *testing.T) {
+	oldSettleDelay, oldPollInterval := settleDelay, pollInterval
+	t.Cleanup(func() { settleDelay, pollInterval = oldSettleDelay, oldPollInterval })
+	settleDelay, pollInterval = 10*time.Millisecond, 100*time.Millisecond
+
+	dir := t.TempDir()
+	watcher := New(func() []string { return []string{filepath.Join(dir, "instance_configs.cfg")} })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, "unrelated.cfg"), []byte("foo"), 0644)
+	}()
+
+	renew, _, err := watcher.Run(ctx, ChangedEvent)
+	if renew || err == nil {
+		t.Errorf("Run() = (%t, %v), want it to keep waiting until the context is done", renew, err)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	renew, _, err := New(func() []string { return []string{filepath.Join(t.TempDir(), "instance_configs.cfg")} }).Run(ctx, ChangedEvent)
+	if renew || err == nil {
+		t.Errorf("Run() with canceled context = (%t, %v), want: (false, error)", renew, err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configfile
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// notifyFilter are the changes signaling a file was written, replaced or removed.
+	notifyFilter = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_LAST_WRITE | windows.FILE_NOTIFY_CHANGE_SIZE
+	// waitTimeout is how long, in milliseconds, a wait lasts before checking ctx again.
+	waitTimeout = 1000
+)
+
+// changeNotifier is the directory change notification based notifier.
+type changeNotifier struct {
+	handles []windows.Handle
+}
+
+// newNotifier returns a change notification notifier watching dirs, directories
+// that don't exist are skipped.
+func newNotifier(dirs []string) (notifier, error) {
+	n := &changeNotifier{}
+	for _, dir := range dirs {
+		handle, err := windows.FindFirstChangeNotification(dir, false, notifyFilter)
+		if err != nil {
+			continue
+		}
+		n.handles = append(n.handles, handle)
+	}
+
+	if len(n.handles) == 0 {
+		return nil, fmt.Errorf("none of the directories %v could be watched", dirs)
+	}
+
+	return n, nil
+}
+
+func (n *changeNotifier) wait(ctx context.Context) error {
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		event, err := windows.WaitForMultipleObjects(n.handles, false, waitTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to wait for change notifications: %w", err)
+		}
+		if event == uint32(windows.WAIT_TIMEOUT) {
+			continue
+		}
+
+		index := event - windows.WAIT_OBJECT_0
+		if index < uint32(len(n.handles)) {
+			// Re-arm the notification for the next wait.
+			if err := windows.FindNextChangeNotification(n.handles[index]); err != nil {
+				return fmt.Errorf("failed to re-arm change notification: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+func (n *changeNotifier) close() {
+	for _, handle := range n.handles {
+		windows.FindCloseChangeNotification(handle)
+	}
+}
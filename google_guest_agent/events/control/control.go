@@ -0,0 +1,346 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control implements the control socket events watcher, it listens on a
+// root only Unix socket (Windows named pipe) and publishes the commands sent to
+// it, i.e. re-running the managers, as agent events.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the control socket watcher's ID.
+	WatcherID = "control-watcher"
+	// RerunEvent is the event type of the command re-running the managers.
+	RerunEvent = "control-watcher,rerun-managers"
+	// DumpStateEvent is the event type of the command dumping the agent's state.
+	DumpStateEvent = "control-watcher,dump-state"
+	// LogLevelEvent is the event type of the command setting the log level, the
+	// level is passed in the "level" argument.
+	LogLevelEvent = "control-watcher,set-log-level"
+	// JobStatusEvent is the event type of the command listing the scheduled jobs'
+	// status.
+	JobStatusEvent = "control-watcher,job-status"
+	// DryRunEvent is the event type of the command reporting the changes the managers
+	// would make, the managers are optionally passed in the "managers" argument.
+	DryRunEvent = "control-watcher,dry-run-managers"
+	// ManagerStatusEvent is the event type of the command listing the managers' last
+	// run, result, duration, changes and last error.
+	ManagerStatusEvent = "control-watcher,manager-status"
+	// eventPrefix prefixes the command's name in its event type ID.
+	eventPrefix = "control-watcher,"
+
+	// AuthorizedKeysCommand is the command listing the metadata SSH keys of the user
+	// passed in the "user" argument, used by sshd's AuthorizedKeysCommand. It's
+	// answered by a Handler, see Handle(), as sshd is waiting on it.
+	AuthorizedKeysCommand = "authorized-keys"
+)
+
+var (
+	// requestTimeout is how long a client has to send its request once connected.
+	requestTimeout = 10 * time.Second
+	// replyTimeout is how long a client waits for the command's handler reply.
+	replyTimeout = 5 * time.Minute
+
+	// ErrUnavailable is returned by Send() when nothing listens on the control
+	// socket, i.e. the agent isn't running.
+	ErrUnavailable = errors.New("the control socket is unavailable")
+
+	// ReplyTimeoutError is returned when the command was published but no handler
+	// replied to it in time.
+	ReplyTimeoutError = command.Response{
+		Status:        107,
+		StatusMessage: "The command was accepted but its handler did not reply in time",
+	}
+)
+
+// Request is a command request sent to the control socket.
+type Request struct {
+	// Command is the command's name, i.e. rerun-managers.
+	Command string
+	// Args are the command's arguments.
+	Args map[string]string `json:",omitempty"`
+}
+
+// Response is the control socket's response to a Request.
+type Response struct {
+	command.Response
+	// Output is the command's output, i.e. the dumped state.
+	Output string `json:",omitempty"`
+}
+
+// Command is the event data of a command received by the control socket, the
+// handler must Reply() to it so the client gets a response.
+type Command struct {
+	Request
+	// reply carries the handler's reply back to the connection.
+	reply chan Response
+	// replyOnce makes sure only the first reply is sent.
+	replyOnce sync.Once
+}
+
+// Reply replies to the command with the handler's output, or err if the command
+// failed. Only the first reply is sent back to the client.
+func (c *Command) Reply(output string, err error) {
+	c.replyOnce.Do(func() {
+		resp := Response{Output: output}
+		if err != nil {
+			resp.Response = command.HandlerError
+			resp.StatusMessage = err.Error()
+		}
+		c.reply <- resp
+	})
+}
+
+// Handler answers a command directly, see Handle().
+type Handler func(ctx context.Context, req Request) (string, error)
+
+// Event returns the event type of the command name.
+func Event(name string) string {
+	return eventPrefix + name
+}
+
+// Watcher is the control socket event watcher implementation.
+type Watcher struct {
+	// path is the socket (named pipe) path.
+	path string
+	// commands maps the event types to the channel their commands are passed
+	// from the connections to Run().
+	commands map[string]chan *Command
+	// handlers maps the command names to the handlers answering them directly,
+	// see Handle().
+	handlers map[string]Handler
+	// listenerMutex protects listener, each event type is run in its own go
+	// routine.
+	listenerMutex sync.Mutex
+	// listener is the socket listener, nil until the first run.
+	listener net.Listener
+	// cancel cancels the context of the connections served by listener.
+	cancel context.CancelFunc
+}
+
+// New allocates and initializes a new Watcher listening on path, if path is empty
+// the platform's DefaultPath is used.
+func New(path string) *Watcher {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	w := &Watcher{
+		path:     path,
+		commands: make(map[string]chan *Command),
+		handlers: make(map[string]Handler),
+	}
+	for _, curr := range []string{RerunEvent, DumpStateEvent, LogLevelEvent, JobStatusEvent, DryRunEvent, ManagerStatusEvent} {
+		w.commands[curr] = make(chan *Command)
+	}
+	return w
+}
+
+// ID returns the control socket event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{RerunEvent, DumpStateEvent, LogLevelEvent, JobStatusEvent, DryRunEvent, ManagerStatusEvent}
+}
+
+// Handle makes handler answer the command name in the connection's go routine
+// instead of publishing it as an event, so the reply doesn't wait for the events
+// being dispatched, i.e. a running manager. It must be called before the watcher
+// is added to the event manager.
+func (w *Watcher) Handle(name string, handler Handler) {
+	w.handlers[name] = handler
+}
+
+// start starts listening on the socket on first use, the listener is kept across
+// runs and closed by Close().
+func (w *Watcher) start(ctx context.Context) error {
+	w.listenerMutex.Lock()
+	defer w.listenerMutex.Unlock()
+
+	if w.listener != nil {
+		return nil
+	}
+
+	l, err := listen(ctx, w.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket %s: %w", w.path, err)
+	}
+
+	// The connections outlive the run starting the listener.
+	serveCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	w.listener = l
+	w.cancel = cancel
+
+	go func() {
+		defer w.forget(l)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				logger.Debugf("Failed to accept control socket connection: %v", err)
+				continue
+			}
+			go w.serve(serveCtx, conn)
+		}
+	}()
+
+	return nil
+}
+
+// forget forgets the closed listener l so the next run listens again.
+func (w *Watcher) forget(l net.Listener) {
+	w.listenerMutex.Lock()
+	defer w.listenerMutex.Unlock()
+
+	if w.listener == l {
+		w.cancel()
+		w.listener, w.cancel = nil, nil
+	}
+}
+
+// Close closes the listener and drops its pending connections, it's called by the
+// event manager once all the event types are removed or the manager is leaving.
+func (w *Watcher) Close() {
+	w.listenerMutex.Lock()
+	defer w.listenerMutex.Unlock()
+
+	if w.listener != nil {
+		w.cancel()
+		w.listener.Close()
+		w.listener, w.cancel = nil, nil
+	}
+}
+
+// serve reads a request from conn, publishes it to the command's Run() and writes
+// back the handler's reply.
+func (w *Watcher) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	respond := func(resp Response) {
+		if err := json.NewEncoder(conn).Encode(resp); err != nil {
+			logger.Debugf("Failed to write control socket response: %v", err)
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(requestTimeout)); err != nil {
+		logger.Debugf("Failed to set control socket read deadline: %v", err)
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		respond(Response{Response: command.BadRequestError})
+		return
+	}
+
+	if handler, found := w.handlers[req.Command]; found {
+		output, err := handler(ctx, req)
+		resp := Response{Output: output}
+		if err != nil {
+			resp.Response = command.HandlerError
+			resp.StatusMessage = err.Error()
+		}
+		respond(resp)
+		return
+	}
+
+	commands, found := w.commands[Event(req.Command)]
+	if !found {
+		respond(Response{Response: command.CmdNotFoundError})
+		return
+	}
+
+	cmd := &Command{Request: req, reply: make(chan Response, 1)}
+	select {
+	case commands <- cmd:
+	case <-ctx.Done():
+		return
+	}
+
+	timer := time.NewTimer(replyTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-cmd.reply:
+		respond(resp)
+	case <-timer.C:
+		respond(Response{Response: ReplyTimeoutError})
+	case <-ctx.Done():
+	}
+}
+
+// Run waits for a command of evType to be sent to the control socket and report
+// it back, the socket is shared by all the event types and kept across runs.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	commands, found := w.commands[evType]
+	if !found {
+		return false, nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+
+	if err := w.start(ctx); err != nil {
+		return false, nil, err
+	}
+
+	select {
+	case cmd := <-commands:
+		return true, cmd, nil
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
+}
+
+// Send sends req to the control socket listening on path, if path is empty the
+// platform's DefaultPath is used.
+func Send(ctx context.Context, path string, req Request) (*Response, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	conn, err := dial(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to %s: %w", ErrUnavailable, path, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return &resp, nil
+}
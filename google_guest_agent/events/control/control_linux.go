@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultPath is the default control socket path for linux.
+const DefaultPath = "/run/google-guest-agent/control.sock"
+
+// listen listens on the Unix socket path, only accessible by the agent's user
+// (root). A stale socket left by a previous run is removed.
+func listen(ctx context.Context, path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	// Bind the socket in a private (0700) directory, give it its final permissions
+	// and only then move it to path, it's never reachable with the umask's ones.
+	tmpDir, err := os.MkdirTemp(dir, ".listen-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary socket directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, filepath.Base(path))
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	// The listener would unlink the temporary name, socketListener unlinks path.
+	ul.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		ul.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		ul.Close()
+		return nil, fmt.Errorf("failed to move socket in place: %w", err)
+	}
+
+	return &socketListener{UnixListener: ul, path: path}, nil
+}
+
+// socketListener is a Unix socket listener removing its socket once closed.
+type socketListener struct {
+	*net.UnixListener
+	// path is the socket path.
+	path string
+	// removeOnce guards the socket removal, a new socket may have been created
+	// at path by a later listen().
+	removeOnce sync.Once
+}
+
+// Close closes the listener and removes its socket.
+func (l *socketListener) Close() error {
+	err := l.UnixListener.Close()
+	l.removeOnce.Do(func() {
+		os.Remove(l.path)
+	})
+	return err
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", path)
+}
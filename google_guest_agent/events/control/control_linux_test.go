@@ -0,0 +1,326 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/google/go-cmp/cmp"
+)
+
+// runWatcher runs the watcher's events until ctx is done, replying to the
+// commands with handle.
+func runWatcher(ctx context.Context, t *testing.T, w *Watcher, handle func(*Command)) {
+	t.Helper()
+	for _, evType := range w.Events() {
+		go func(evType string) {
+			for {
+				renew, data, err := w.Run(ctx, evType)
+				if !renew {
+					return
+				}
+				if err != nil {
+					t.Errorf("Run(%s) failed: %v", evType, err)
+					return
+				}
+				handle(data.(*Command))
+			}
+		}(evType)
+	}
+}
+
+// waitSocket waits for the socket path to be created.
+func waitSocket(t *testing.T, path string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("socket %s was not created", path)
+}
+
+func TestSend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "run", "control.sock")
+	w := New(path)
+	defer w.Close()
+	runWatcher(ctx, t, w, func(cmd *Command) {
+		switch cmd.Command {
+		case "dump-state":
+			cmd.Reply("state", nil)
+		case "job-status":
+			cmd.Reply("jobs", nil)
+		case "manager-status":
+			cmd.Reply("managers", nil)
+		case "dry-run-managers":
+			cmd.Reply("changes of "+cmd.Args["managers"], nil)
+		case "set-log-level":
+			if cmd.Args["level"] != "debug" {
+				cmd.Reply("", errors.New("invalid level"))
+				return
+			}
+			cmd.Reply("", nil)
+		}
+	})
+	waitSocket(t, path)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", path, err)
+	}
+	if got := stat.Mode().Perm(); got != 0600 {
+		t.Errorf("control socket mode = %o, want 0600", got)
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Response
+	}{
+		{
+			name: "dump-state",
+			req:  Request{Command: "dump-state"},
+			want: Response{Output: "state"},
+		},
+		{
+			name: "job-status",
+			req:  Request{Command: "job-status"},
+			want: Response{Output: "jobs"},
+		},
+		{
+			name: "manager-status",
+			req:  Request{Command: "manager-status"},
+			want: Response{Output: "managers"},
+		},
+		{
+			name: "dry-run-managers",
+			req:  Request{Command: "dry-run-managers", Args: map[string]string{"managers": "accounts"}},
+			want: Response{Output: "changes of accounts"},
+		},
+		{
+			name: "set-log-level",
+			req:  Request{Command: "set-log-level", Args: map[string]string{"level": "debug"}},
+			want: Response{},
+		},
+		{
+			name: "handler-error",
+			req:  Request{Command: "set-log-level", Args: map[string]string{"level": "verbose"}},
+			want: Response{Response: command.Response{Status: command.HandlerError.Status, StatusMessage: "invalid level"}},
+		},
+		{
+			name: "unknown-command",
+			req:  Request{Command: "unknown"},
+			want: Response{Response: command.CmdNotFoundError},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Send(ctx, path, tc.req)
+			if err != nil {
+				t.Fatalf("Send(%+v) failed: %v", tc.req, err)
+			}
+			if diff := cmp.Diff(tc.want, *got); diff != "" {
+				t.Errorf("Send(%+v) returned unexpected response (-want +got):\n%s", tc.req, diff)
+			}
+		})
+	}
+}
+
+func TestManagerRuns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "run", "control.sock")
+	w := New(path)
+
+	eventManager := events.Get()
+	if err := eventManager.AddWatcher(ctx, w); err != nil {
+		t.Fatalf("AddWatcher(%s) failed: %v", w.ID(), err)
+	}
+	for _, evType := range w.Events() {
+		eventManager.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			if cmd, ok := evData.Data.(*Command); ok {
+				cmd.Reply(cmd.Command, nil)
+			}
+			return true
+		})
+	}
+
+	managerDone := make(chan error)
+	go func() {
+		managerDone <- eventManager.Run(ctx)
+	}()
+	waitSocket(t, path)
+
+	// Each command is reported by its own run, whichever run started listening the
+	// listener must outlive them.
+	for i := 0; i < 2; i++ {
+		for _, evType := range w.Events() {
+			name := strings.TrimPrefix(evType, eventPrefix)
+			got, err := Send(ctx, path, Request{Command: name})
+			if err != nil {
+				t.Fatalf("Send(%s) #%d failed: %v", name, i, err)
+			}
+			if diff := cmp.Diff(Response{Output: name}, *got); diff != "" {
+				t.Errorf("Send(%s) #%d returned unexpected response (-want +got):\n%s", name, i, diff)
+			}
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-managerDone:
+		if err != nil {
+			t.Errorf("Run() failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the event manager to leave")
+	}
+
+	// The manager closed the watcher on its way out.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v, want the socket removed once the watcher is closed", path, err)
+	}
+}
+
+func TestReplyTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	oldTimeout := replyTimeout
+	replyTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { replyTimeout = oldTimeout })
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	w := New(path)
+	defer w.Close()
+	runWatcher(ctx, t, w, func(cmd *Command) {})
+	waitSocket(t, path)
+
+	got, err := Send(ctx, path, Request{Command: "rerun-managers"})
+	if err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if diff := cmp.Diff(Response{Response: ReplyTimeoutError}, *got); diff != "" {
+		t.Errorf("Send() returned unexpected response (-want +got):\n%s", diff)
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	w := New(path)
+	defer w.Close()
+	runWatcher(ctx, t, w, func(cmd *Command) { cmd.Reply("", nil) })
+	waitSocket(t, path)
+
+	conn, err := dial(ctx, path)
+	if err != nil {
+		t.Fatalf("dial(%s) failed: %v", path, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("not json\n")); err != nil {
+		t.Fatalf("conn.Write() failed: %v", err)
+	}
+
+	var got Response
+	if err := json.NewDecoder(conn).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if diff := cmp.Diff(Response{Response: command.BadRequestError}, got); diff != "" {
+		t.Errorf("unexpected response (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	w := New(path)
+	defer w.Close()
+	w.Handle(AuthorizedKeysCommand, func(_ context.Context, req Request) (string, error) {
+		if req.Args["user"] == "" {
+			return "", errors.New("no user given")
+		}
+		return "keys of " + req.Args["user"], nil
+	})
+
+	// The published commands are never replied to, as if the agent was busy
+	// dispatching another event.
+	blocked := make(chan struct{})
+	defer close(blocked)
+	runWatcher(ctx, t, w, func(*Command) { <-blocked })
+	waitSocket(t, path)
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, time.Second)
+	defer reqCancel()
+	go Send(reqCtx, path, Request{Command: "dump-state"})
+
+	tests := []struct {
+		name string
+		req  Request
+		want Response
+	}{
+		{
+			name: "keys",
+			req:  Request{Command: AuthorizedKeysCommand, Args: map[string]string{"user": "alice"}},
+			want: Response{Output: "keys of alice"},
+		},
+		{
+			name: "handler-error",
+			req:  Request{Command: AuthorizedKeysCommand},
+			want: Response{Response: command.Response{Status: command.HandlerError.Status, StatusMessage: "no user given"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Send(reqCtx, path, tc.req)
+			if err != nil {
+				t.Fatalf("Send(%+v) failed: %v", tc.req, err)
+			}
+			if diff := cmp.Diff(tc.want, *got); diff != "" {
+				t.Errorf("Send(%+v) returned unexpected response (-want +got):\n%s", tc.req, diff)
+			}
+		})
+	}
+}
+
+func TestSendUnavailable(t *testing.T) {
+	_, err := Send(context.Background(), filepath.Join(t.TempDir(), "control.sock"), Request{Command: "dump-state"})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Send() to a missing socket returned %v, want %v", err, ErrUnavailable)
+	}
+}
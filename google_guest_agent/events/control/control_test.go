@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []error
+		want    Response
+	}{
+		{
+			name:    "success",
+			replies: []error{nil},
+			want:    Response{Output: "output"},
+		},
+		{
+			name:    "failure",
+			replies: []error{errors.New("failed")},
+			want: Response{
+				Response: command.Response{Status: command.HandlerError.Status, StatusMessage: "failed"},
+				Output:   "output",
+			},
+		},
+		{
+			name:    "first-reply-wins",
+			replies: []error{nil, errors.New("failed")},
+			want:    Response{Output: "output"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &Command{reply: make(chan Response, 1)}
+			for _, err := range tc.replies {
+				cmd.Reply("output", err)
+			}
+
+			if diff := cmp.Diff(tc.want, <-cmd.reply); diff != "" {
+				t.Errorf("Reply() sent unexpected response (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEvent(t *testing.T) {
+	for _, evType := range New("").Events() {
+		if got := Event(evType[len(eventPrefix):]); got != evType {
+			t.Errorf("Event(%q) = %q, want %q", evType[len(eventPrefix):], got, evType)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"context"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+const (
+	// DefaultPath is the default named pipe path for windows.
+	DefaultPath = `\\.\pipe\google-guest-agent-control`
+	// securityDescriptor only grants access to LocalSystem and the Administrators.
+	securityDescriptor = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+)
+
+// listen listens on the named pipe path, only accessible by LocalSystem and the
+// Administrators.
+func listen(ctx context.Context, path string) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		InputBufferSize:    1024,
+		OutputBufferSize:   1024,
+		SecurityDescriptor: securityDescriptor,
+	})
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// correlationKey is the context key of the correlation ID of the event being
+// handled.
+type correlationKey struct{}
+
+// newCorrelationID returns a new random correlation ID.
+func newCorrelationID() string {
+	return fmt.Sprintf("%08x", rand.Uint32())
+}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID id.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID of the event handled with ctx, empty if
+// ctx isn't a callback's context.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// CorrelationLabel is the label carrying the correlation ID of the log entries
+// emitted with Logf().
+const CorrelationLabel = "correlation_id"
+
+// Logf logs a message with severity, labeled with the correlation ID of the event
+// handled with ctx if any. The ID is read from ctx rather than from the event being
+// dispatched so the lines logged concurrently, i.e. by a callback left running after
+// the handler timeout, aren't tagged with another event's ID.
+func Logf(ctx context.Context, severity logger.Severity, format string, v ...any) {
+	e := logger.LogEntry{Message: fmt.Sprintf(format, v...), Severity: severity}
+	if id := CorrelationID(ctx); id != "" {
+		e.Labels = map[string]string{CorrelationLabel: id}
+	}
+	logger.Log(e)
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+func TestCorrelationID(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1, 2}, giveUp: true}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var got []string
+	eventManager.Subscribe("burst-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		id := CorrelationID(ctx)
+		if id == "" {
+			t.Errorf("CorrelationID() returned an empty ID in the callback's context")
+		}
+		if id != evData.CorrelationID {
+			t.Errorf("CorrelationID() = %q, want event's correlation ID %q", id, evData.CorrelationID)
+		}
+		got = append(got, id)
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Callback called %d times, want 2", len(got))
+	}
+	if got[0] == got[1] {
+		t.Errorf("Events got the same correlation ID %q, want distinct IDs", got[0])
+	}
+}
+
+func TestCorrelationIDContext(t *testing.T) {
+	if got := CorrelationID(context.Background()); got != "" {
+		t.Errorf("CorrelationID(context.Background()) = %q, want empty", got)
+	}
+
+	ctx := WithCorrelationID(context.Background(), "1a2b3c4d")
+	if got := CorrelationID(ctx); got != "1a2b3c4d" {
+		t.Errorf("CorrelationID() = %q, want %q", got, "1a2b3c4d")
+	}
+}
+
+func TestLogfConcurrent(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	var logs bytes.Buffer
+	format := func(e logger.LogEntry) string {
+		return fmt.Sprintf("[%s] %s", e.Labels[CorrelationLabel], e.Message)
+	}
+	opts := logger.LogOpts{LoggerName: "test", DisableLocalLogging: true, DisableCloudLogging: true, FormatFunction: format, Writers: []io.Writer{&logs}}
+	if err := logger.Init(ctx, opts); err != nil {
+		t.Fatalf("logger.Init() = %v, want nil", err)
+	}
+	t.Cleanup(func() {
+		logger.Init(ctx, logger.LogOpts{LoggerName: "test", DisableLocalLogging: true, DisableCloudLogging: true})
+	})
+
+	if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1}, giveUp: true}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var id string
+	eventManager.Subscribe("burst-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		id = evData.CorrelationID
+
+		// A goroutine not handling the event logs while it's being dispatched.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			Logf(context.Background(), logger.Info, "concurrent")
+		}()
+		<-done
+
+		Logf(ctx, logger.Info, "handled")
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	want := []string{"[] concurrent", "[" + id + "] handled"}
+	if got := strings.Split(strings.TrimSpace(logs.String()), "\n"); !slices.Equal(got, want) {
+		t.Errorf("Logged lines = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcplease implements the DHCP lease events watcher, it reports the
+// lease files written by the DHCP clients, i.e. on lease renewals.
+package dhcplease
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the DHCP lease watcher's ID.
+	WatcherID = "dhcp-lease-watcher"
+	// LeaseEvent is the DHCP lease changed event type ID.
+	LeaseEvent = "dhcp-lease-watcher,lease-changed"
+)
+
+var (
+	// defaultPatterns are the lease files of the DHCP clients known to the agent.
+	defaultPatterns = []string{
+		// dhclient on Debian and RHEL based distributions.
+		"/var/lib/dhcp/dhclient*.leases*",
+		"/var/lib/dhclient/dhclient*.leases*",
+		// dhcpcd.
+		"/var/lib/dhcpcd/*.lease*",
+		"/var/lib/dhcpcd5/*.lease*",
+		// NetworkManager's internal DHCP client and dhclient.
+		"/var/lib/NetworkManager/*.lease",
+		// systemd-networkd.
+		"/run/systemd/netif/leases/*",
+		// wicked.
+		"/var/lib/wicked/lease-*",
+	}
+
+	// settleDelay is how long to wait after a change before checking the files,
+	// the clients often write and rename the lease files in several steps.
+	settleDelay = time.Second
+
+	// pollInterval is how often the files are checked when the OS notification
+	// mechanism is not available.
+	pollInterval = 10 * time.Second
+)
+
+// Lease is the event data of a DHCP lease change.
+type Lease struct {
+	// Files are the lease files written or removed.
+	Files []string
+}
+
+// notifier waits for changes in a set of directories.
+type notifier interface {
+	// wait blocks until something changes in the watched directories or ctx is
+	// done, it returns ctx's error in the latter case.
+	wait(ctx context.Context) error
+	// close releases the notifier resources.
+	close()
+}
+
+// Watcher is the DHCP lease event watcher implementation.
+type Watcher struct {
+	// patterns are the glob patterns of the watched lease files.
+	patterns []string
+	// last maps the lease files to their modification time when the last change
+	// was reported.
+	last map[string]time.Time
+}
+
+// New allocates and initializes a new Watcher watching the lease files of the
+// known DHCP clients, changes are relative to the files at the time New is called.
+func New() *Watcher {
+	return newWatcher(defaultPatterns)
+}
+
+func newWatcher(patterns []string) *Watcher {
+	w := &Watcher{patterns: patterns}
+	w.last = w.snapshot()
+	return w
+}
+
+// ID returns the DHCP lease event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{LeaseEvent}
+}
+
+// snapshot returns the modification time of the lease files.
+func (w *Watcher) snapshot() map[string]time.Time {
+	res := make(map[string]time.Time)
+	for _, pattern := range w.patterns {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			res[file] = info.ModTime()
+		}
+	}
+	return res
+}
+
+// changed returns the files written, added or removed in curr compared to the
+// last reported state.
+func (w *Watcher) changed(curr map[string]time.Time) []string {
+	var res []string
+	for file, modTime := range curr {
+		if last, found := w.last[file]; !found || !last.Equal(modTime) {
+			res = append(res, file)
+		}
+	}
+	for file := range w.last {
+		if _, found := curr[file]; !found {
+			res = append(res, file)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// dirs returns the directories holding the watched lease files.
+func (w *Watcher) dirs() []string {
+	var res []string
+	for _, pattern := range w.patterns {
+		if dir := filepath.Dir(pattern); !slices.Contains(res, dir) {
+			res = append(res, dir)
+		}
+	}
+	return res
+}
+
+// Run waits for a lease file to be written or removed and report back the event.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	n, err := newNotifier(w.dirs())
+	if err != nil {
+		logger.Debugf("Failed to set up DHCP lease notifications, polling instead: %v", err)
+		n = &pollNotifier{}
+	}
+	defer n.close()
+
+	for {
+		// Checking after the notifier is set up makes sure changes made between
+		// runs aren't missed.
+		curr := w.snapshot()
+		if files := w.changed(curr); len(files) > 0 {
+			w.last = curr
+			logger.Debugf("DHCP lease files changed: %v", files)
+			return true, &Lease{Files: files}, nil
+		}
+
+		if err := n.wait(ctx); err != nil {
+			return false, nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		case <-time.After(settleDelay):
+		}
+	}
+}
+
+// pollNotifier is the notifier used when the OS notification mechanism is not
+// available, it just waits for pollInterval.
+type pollNotifier struct{}
+
+func (p *pollNotifier) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(pollInterval):
+		return nil
+	}
+}
+
+func (p *pollNotifier) close() {}
@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package dhcplease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// inotifyMask are the inotify events signaling a file was written, replaced or removed.
+	inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CREATE | unix.IN_DELETE
+	// pollTimeout is how long, in milliseconds, a poll waits before checking ctx again.
+	pollTimeout = 1000
+)
+
+// inotifyNotifier is the inotify based notifier.
+type inotifyNotifier struct {
+	fd int
+}
+
+// newNotifier returns an inotify notifier watching dirs, directories that don't
+// exist are skipped.
+func newNotifier(dirs []string) (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	var watched int
+	for _, dir := range dirs {
+		if _, err := unix.InotifyAddWatch(fd, dir, inotifyMask); err == nil {
+			watched++
+		}
+	}
+
+	if watched == 0 {
+		unix.Close(fd)
+		return nil, fmt.Errorf("none of the directories %v could be watched", dirs)
+	}
+
+	return &inotifyNotifier{fd: fd}, nil
+}
+
+func (n *inotifyNotifier) wait(ctx context.Context) error {
+	fds := []unix.PollFd{{Fd: int32(n.fd), Events: unix.POLLIN}}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		ready, err := unix.Poll(fds, pollTimeout)
+		if err != nil && !errors.Is(err, unix.EINTR) {
+			return fmt.Errorf("failed to poll inotify: %w", err)
+		}
+		if ready > 0 {
+			break
+		}
+	}
+
+	// Consume the pending events, the files are checked by the caller.
+	buf := make([]byte, 4096)
+	for {
+		if _, err := unix.Read(n.fd, buf); err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (n *inotifyNotifier) close() {
+	unix.Close(n.fd)
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package dhcplease
+
+import (
+	"fmt"
+)
+
+// newNotifier is not implemented, the files are polled instead.
+func newNotifier(dirs []string) (notifier, error) {
+	return nil, fmt.Errorf("DHCP lease notifications are not implemented for this OS")
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcplease
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRun(t *testing.T) {
+	oldSettleDelay, oldPollInterval := settleDelay, pollInterval
+	t.Cleanup(func() { settleDelay, pollInterval = oldSettleDelay, oldPollInterval })
+	settleDelay, pollInterval = 10*time.Millisecond, 100*time.Millisecond
+
+	dir := t.TempDir()
+	lease := filepath.Join(dir, "dhclient.eth0.leases")
+	if err := os.WriteFile(lease, []byte("lease {}\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", lease, err)
+	}
+
+	watcher := newWatcher([]string{filepath.Join(dir, "dhclient*.leases")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		renew bool
+		data  interface{}
+	}
+	results := make(chan result)
+	go func() {
+		renew, data, err := watcher.Run(ctx, LeaseEvent)
+		if err != nil {
+			t.Errorf("Run() returned error: %v", err)
+		}
+		results <- result{renew, data}
+	}()
+
+	// Give Run() a chance to start waiting before renewing the leases.
+	time.Sleep(100 * time.Millisecond)
+	renewed := filepath.Join(dir, "dhclient.eth1.leases")
+	if err := os.WriteFile(renewed, []byte("lease {}\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", renewed, err)
+	}
+	// Files not matching the patterns are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "resolv.conf"), []byte("nameserver 169.254.169.254\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile() failed: %v", err)
+	}
+
+	select {
+	case res := <-results:
+		if !res.renew {
+			t.Errorf("Run() returned renew: false, want: true")
+		}
+		want := &Lease{Files: []string{renewed}}
+		if diff := cmp.Diff(want, res.data); diff != "" {
+			t.Errorf("Run() returned unexpected diff (-want +got):\n%s", diff)
+		}
+	case <-ctx.Done():
+		t.Fatalf("Run() didn't return after the lease file changed")
+	}
+}
+
+func TestChanged(t *testing.T) {
+	now := time.Now()
+	watcher := &Watcher{last: map[string]time.Time{
+		"/var/lib/dhcp/dhclient.eth0.leases": now,
+		"/var/lib/dhcp/dhclient.eth1.leases": now,
+		"/var/lib/dhcp/dhclient.eth2.leases": now,
+	}}
+
+	curr := map[string]time.Time{
+		"/var/lib/dhcp/dhclient.eth0.leases": now,
+		"/var/lib/dhcp/dhclient.eth1.leases": now.Add(time.Second),
+		"/var/lib/dhcp/dhclient.eth3.leases": now,
+	}
+
+	want := []string{
+		"/var/lib/dhcp/dhclient.eth1.leases",
+		"/var/lib/dhcp/dhclient.eth2.leases",
+		"/var/lib/dhcp/dhclient.eth3.leases",
+	}
+	if diff := cmp.Diff(want, watcher.changed(curr)); diff != "" {
+		t.Errorf("changed() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	watcher := newWatcher([]string{filepath.Join(t.TempDir(), "*.lease")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	renew, _, err := watcher.Run(ctx, LeaseEvent)
+	if renew {
+		t.Errorf("Run() returned renew: true after the context was canceled, want: false")
+	}
+	if err == nil {
+		t.Errorf("Run() returned no error after the context was canceled, want: context error")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventlog implements the Windows Event Log events watcher, it publishes
+// the records written to the subscribed channels as agent events.
+package eventlog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WatcherID is the Windows Event Log watcher's ID.
+	WatcherID = "eventlog-watcher"
+	// eventPrefix prefixes the filter's name in its event type ID.
+	eventPrefix = "eventlog-watcher,"
+)
+
+// Filter selects the records of a channel published as an event.
+type Filter struct {
+	// Name identifies the filter, it's the suffix of the filter's event type.
+	Name string
+	// Channel is the Event Log channel, i.e. System or
+	// Microsoft-Windows-FailoverClustering/Operational.
+	Channel string
+	// Providers are the names of the providers whose records are selected, if
+	// empty the records of all providers are selected.
+	Providers []string
+	// EventIDs are the IDs of the records selected, if empty the records with any
+	// ID are selected.
+	EventIDs []uint32
+}
+
+// Record is the event data of an Event Log record.
+type Record struct {
+	// Channel is the channel the record was written to.
+	Channel string
+	// Provider is the name of the provider that wrote the record.
+	Provider string
+	// EventID is the record's event ID.
+	EventID uint32
+	// Level is the record's level, i.e. 2 for errors and 4 for information.
+	Level uint8
+	// TimeCreated is the time the record was written.
+	TimeCreated time.Time
+	// Data maps the names of the record's event data to their values.
+	Data map[string]string
+	// XML is the record rendered as XML.
+	XML string
+}
+
+// Event returns the event type of the records selected by the filter name.
+func Event(name string) string {
+	return eventPrefix + name
+}
+
+// query returns the XPath query selecting the filter's records.
+func (f Filter) query() string {
+	var conditions []string
+
+	if len(f.Providers) > 0 {
+		var names []string
+		for _, provider := range f.Providers {
+			names = append(names, fmt.Sprintf("@Name='%s'", provider))
+		}
+		conditions = append(conditions, fmt.Sprintf("Provider[%s]", strings.Join(names, " or ")))
+	}
+
+	if len(f.EventIDs) > 0 {
+		var ids []string
+		for _, id := range f.EventIDs {
+			ids = append(ids, fmt.Sprintf("EventID=%d", id))
+		}
+		conditions = append(conditions, "("+strings.Join(ids, " or ")+")")
+	}
+
+	if len(conditions) == 0 {
+		return "*"
+	}
+	return fmt.Sprintf("*[System[%s]]", strings.Join(conditions, " and "))
+}
+
+// xmlEvent is the subset of the event schema parsed from the rendered records.
+type xmlEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     uint32 `xml:"EventID"`
+		Level       uint8  `xml:"Level"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		Channel string `xml:"Channel"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"EventData"`
+}
+
+// parseRecord parses a record rendered as XML.
+func parseRecord(data string) (*Record, error) {
+	var ev xmlEvent
+	if err := xml.Unmarshal([]byte(data), &ev); err != nil {
+		return nil, fmt.Errorf("failed to parse event record: %w", err)
+	}
+
+	res := &Record{
+		Channel:  ev.System.Channel,
+		Provider: ev.System.Provider.Name,
+		EventID:  ev.System.EventID,
+		Level:    ev.System.Level,
+		Data:     make(map[string]string),
+		XML:      data,
+	}
+
+	if ev.System.TimeCreated.SystemTime != "" {
+		created, err := time.Parse(time.RFC3339Nano, ev.System.TimeCreated.SystemTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse event record creation time: %w", err)
+		}
+		res.TimeCreated = created
+	}
+
+	for _, curr := range ev.EventData.Data {
+		res.Data[curr.Name] = curr.Value
+	}
+
+	return res, nil
+}
+
+// Watcher is the Windows Event Log event watcher implementation.
+type Watcher struct {
+	// filters maps the event types to their filters.
+	filters map[string]Filter
+	// events are the event types in the filters order.
+	events []string
+	// subscriptionsMutex protects subscriptions, each event type is run in its
+	// own go routine.
+	subscriptionsMutex sync.Mutex
+	// subscriptions maps the event types to their platform subscription.
+	subscriptions map[string]*subscription
+}
+
+// New allocates and initializes a new Watcher publishing the records selected by
+// filters, only the records written after the watcher is run are published.
+func New(filters ...Filter) *Watcher {
+	w := &Watcher{
+		filters:       make(map[string]Filter),
+		subscriptions: make(map[string]*subscription),
+	}
+
+	for _, curr := range filters {
+		evType := Event(curr.Name)
+		w.filters[evType] = curr
+		w.events = append(w.events, evType)
+	}
+
+	return w
+}
+
+// ID returns the Windows Event Log event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return w.events
+}
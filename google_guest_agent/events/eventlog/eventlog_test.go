@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilterQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{
+			name:   "all",
+			filter: Filter{Name: "all", Channel: "System"},
+			want:   "*",
+		},
+		{
+			name:   "providers",
+			filter: Filter{Name: "rdp", Channel: "System", Providers: []string{"TermService", "TermDD"}},
+			want:   "*[System[Provider[@Name='TermService' or @Name='TermDD']]]",
+		},
+		{
+			name:   "event_ids",
+			filter: Filter{Name: "cluster", Channel: "System", EventIDs: []uint32{1069, 1205}},
+			want:   "*[System[(EventID=1069 or EventID=1205)]]",
+		},
+		{
+			name:   "providers_and_event_ids",
+			filter: Filter{Name: "sysprep", Channel: "Application", Providers: []string{"Microsoft-Windows-Sysprep"}, EventIDs: []uint32{1}},
+			want:   "*[System[Provider[@Name='Microsoft-Windows-Sysprep'] and (EventID=1)]]",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.query(); got != tc.want {
+				t.Errorf("query() = %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	data := `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'>
+  <System>
+    <Provider Name='Microsoft-Windows-FailoverClustering' Guid='{baf908ea-3421-4ca9-9b84-6689b8c6f85f}'/>
+    <EventID>1069</EventID>
+    <Level>2</Level>
+    <TimeCreated SystemTime='2024-05-02T10:11:12.1234567Z'/>
+    <Channel>System</Channel>
+    <Computer>wsfc-1</Computer>
+  </System>
+  <EventData>
+    <Data Name='ResourceName'>Cluster IP Address</Data>
+    <Data Name='ResourceGroup'>Cluster Group</Data>
+  </EventData>
+</Event>`
+
+	got, err := parseRecord(data)
+	if err != nil {
+		t.Fatalf("parseRecord() failed unexpectedly: %v", err)
+	}
+
+	want := &Record{
+		Channel:     "System",
+		Provider:    "Microsoft-Windows-FailoverClustering",
+		EventID:     1069,
+		Level:       2,
+		TimeCreated: time.Date(2024, 5, 2, 10, 11, 12, 123456700, time.UTC),
+		Data:        map[string]string{"ResourceName": "Cluster IP Address", "ResourceGroup": "Cluster Group"},
+		XML:         data,
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseRecord() returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseRecord("<Event"); err == nil {
+		t.Errorf("parseRecord() succeeded for malformed XML, want error")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	w := New(Filter{Name: "rdp", Channel: "System"}, Filter{Name: "sysprep", Channel: "Application"})
+
+	want := []string{"eventlog-watcher,rdp", "eventlog-watcher,sysprep"}
+	if diff := cmp.Diff(want, w.Events()); diff != "" {
+		t.Errorf("Events() returned unexpected diff (-want +got):\n%s", diff)
+	}
+	if w.ID() != WatcherID {
+		t.Errorf("ID() = %q, want: %q", w.ID(), WatcherID)
+	}
+}
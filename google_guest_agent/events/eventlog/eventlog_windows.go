@@ -0,0 +1,241 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// https://learn.microsoft.com/en-us/windows/win32/wes/windows-event-log-reference
+	wevtapiDLL = windows.NewLazySystemDLL("wevtapi.dll")
+	// https://learn.microsoft.com/en-us/windows/win32/api/winevt/nf-winevt-evtsubscribe
+	// procEvtSubscribe subscribes to the events written to a channel.
+	procEvtSubscribe = wevtapiDLL.NewProc("EvtSubscribe")
+	// https://learn.microsoft.com/en-us/windows/win32/api/winevt/nf-winevt-evtnext
+	// procEvtNext gets the next events of a subscription.
+	procEvtNext = wevtapiDLL.NewProc("EvtNext")
+	// https://learn.microsoft.com/en-us/windows/win32/api/winevt/nf-winevt-evtrender
+	// procEvtRender renders an event as XML.
+	procEvtRender = wevtapiDLL.NewProc("EvtRender")
+	// https://learn.microsoft.com/en-us/windows/win32/api/winevt/nf-winevt-evtclose
+	// procEvtClose closes the subscription and event handles.
+	procEvtClose = wevtapiDLL.NewProc("EvtClose")
+)
+
+const (
+	// evtSubscribeToFutureEvents subscribes to the events written from now on.
+	evtSubscribeToFutureEvents = 1
+	// evtRenderEventXml renders the event as an XML string.
+	evtRenderEventXml = 1
+	// batchSize is the maximum number of events fetched by a EvtNext() call.
+	batchSize = 16
+	// waitTimeout is how long, in milliseconds, a wait lasts before checking ctx again.
+	waitTimeout = 1000
+)
+
+// subscription is a pull subscription to a channel, signal is set whenever
+// there are events to be fetched.
+type subscription struct {
+	handle  windows.Handle
+	signal  windows.Handle
+	pending []*Record
+}
+
+// subscribe subscribes to the records selected by filter.
+func subscribe(filter Filter) (*subscription, error) {
+	signal, err := windows.CreateEvent(nil, 1, 1, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subscription signal: %w", err)
+	}
+
+	channel, err := windows.UTF16PtrFromString(filter.Channel)
+	if err != nil {
+		windows.CloseHandle(signal)
+		return nil, fmt.Errorf("invalid channel %q: %w", filter.Channel, err)
+	}
+	query, err := windows.UTF16PtrFromString(filter.query())
+	if err != nil {
+		windows.CloseHandle(signal)
+		return nil, fmt.Errorf("invalid query %q: %w", filter.query(), err)
+	}
+
+	handle, _, err := procEvtSubscribe.Call(
+		0,
+		uintptr(signal),
+		uintptr(unsafe.Pointer(channel)),
+		uintptr(unsafe.Pointer(query)),
+		0,
+		0,
+		0,
+		evtSubscribeToFutureEvents,
+	)
+	if handle == 0 {
+		windows.CloseHandle(signal)
+		return nil, fmt.Errorf("failed to subscribe to channel %q: %w", filter.Channel, err)
+	}
+
+	return &subscription{handle: windows.Handle(handle), signal: signal}, nil
+}
+
+// close closes the subscription handles.
+func (s *subscription) close() {
+	procEvtClose.Call(uintptr(s.handle))
+	windows.CloseHandle(s.signal)
+}
+
+// fetch fetches the available records into pending, it returns false if there
+// are no records available.
+func (s *subscription) fetch() (bool, error) {
+	var events [batchSize]windows.Handle
+	var returned uint32
+
+	ok, _, err := procEvtNext.Call(
+		uintptr(s.handle),
+		batchSize,
+		uintptr(unsafe.Pointer(&events[0])),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&returned)),
+	)
+	if ok == 0 {
+		if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
+			windows.ResetEvent(s.signal)
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to fetch events: %w", err)
+	}
+
+	for _, event := range events[:returned] {
+		data, err := render(event)
+		procEvtClose.Call(uintptr(event))
+		if err != nil {
+			logger.Errorf("Failed to render event log record: %v", err)
+			continue
+		}
+
+		record, err := parseRecord(data)
+		if err != nil {
+			logger.Errorf("Failed to parse event log record: %v", err)
+			continue
+		}
+		s.pending = append(s.pending, record)
+	}
+
+	return true, nil
+}
+
+// render renders event as XML.
+func render(event windows.Handle) (string, error) {
+	var used, count uint32
+	buffer := make([]uint16, 4096)
+
+	for {
+		ok, _, err := procEvtRender.Call(
+			0,
+			uintptr(event),
+			evtRenderEventXml,
+			uintptr(len(buffer)*2),
+			uintptr(unsafe.Pointer(&buffer[0])),
+			uintptr(unsafe.Pointer(&used)),
+			uintptr(unsafe.Pointer(&count)),
+		)
+		if ok != 0 {
+			return windows.UTF16ToString(buffer[:used/2]), nil
+		}
+		if !errors.Is(err, windows.ERROR_INSUFFICIENT_BUFFER) {
+			return "", err
+		}
+		buffer = make([]uint16, used/2+1)
+	}
+}
+
+// subscription returns evType's subscription, subscribing on first use.
+func (w *Watcher) subscription(evType string) (*subscription, error) {
+	w.subscriptionsMutex.Lock()
+	defer w.subscriptionsMutex.Unlock()
+
+	if sub, found := w.subscriptions[evType]; found {
+		return sub, nil
+	}
+
+	filter, found := w.filters[evType]
+	if !found {
+		return nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+
+	sub, err := subscribe(filter)
+	if err != nil {
+		return nil, err
+	}
+	w.subscriptions[evType] = sub
+	return sub, nil
+}
+
+// unsubscribe closes and forgets evType's subscription.
+func (w *Watcher) unsubscribe(evType string) {
+	w.subscriptionsMutex.Lock()
+	defer w.subscriptionsMutex.Unlock()
+
+	if sub, found := w.subscriptions[evType]; found {
+		sub.close()
+		delete(w.subscriptions, evType)
+	}
+}
+
+// Run waits for a record selected by evType's filter and report it back, the
+// subscription is kept across runs so records written while the event is being
+// handled aren't lost.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	sub, err := w.subscription(evType)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for {
+		if len(sub.pending) > 0 {
+			record := sub.pending[0]
+			sub.pending = sub.pending[1:]
+			return true, record, nil
+		}
+
+		if ctx.Err() != nil {
+			w.unsubscribe(evType)
+			return false, nil, ctx.Err()
+		}
+
+		fetched, err := sub.fetch()
+		if err != nil {
+			// Subscribe again in the next run.
+			w.unsubscribe(evType)
+			return true, nil, err
+		}
+		if fetched {
+			continue
+		}
+
+		if _, err := windows.WaitForSingleObject(sub.signal, waitTimeout); err != nil {
+			w.unsubscribe(evType)
+			return false, nil, fmt.Errorf("failed to wait for events: %w", err)
+		}
+	}
+}
@@ -18,7 +18,13 @@ package events
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/metadata"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
@@ -46,6 +52,21 @@ type Watcher interface {
 	Run(ctx context.Context, evType string) (bool, interface{}, error)
 }
 
+// Closer is implemented by the watchers holding resources across runs, i.e. a
+// listening socket. Close() is called once all the watcher's event types are done,
+// either removed, given up or because the manager is leaving.
+type Closer interface {
+	Close()
+}
+
+// Dropper is implemented by the watchers whose runs may complete without an event,
+// i.e. a metadata longpoll timing out without changes. The runs Drop() returns true
+// for are renewed without publishing an event, they still count as the watcher's
+// progress for the watchdog.
+type Dropper interface {
+	Drop(evType string, err error) bool
+}
+
 // Manager defines the interface between events management layer and the
 // core guest agent implementation.
 type Manager struct {
@@ -78,6 +99,36 @@ type Manager struct {
 	// control go routines to leave(given we don't have any more job left to
 	// process).
 	queue *watcherQueue
+
+	// watchdog tracks the liveness of watchers and event dispatching.
+	watchdog *watchdog
+
+	// coalescers maps the event types whose bursts are coalesced to their coalescer.
+	coalescers map[string]*coalescer
+
+	// heartbeat is called whenever the event loop makes progress, i.e. a watcher
+	// returned or an event was dispatched.
+	heartbeat func()
+
+	// resumed is closed when a paused manager is resumed, it's nil if the manager
+	// is not paused.
+	resumed chan struct{}
+
+	// pauseMutex protects resumed.
+	pauseMutex sync.Mutex
+
+	// handlerTimeout is the maximum time the dispatching of an event waits for a
+	// subscriber's callback, zero means it waits indefinitely.
+	handlerTimeout time.Duration
+
+	// metrics records the events and callbacks counters.
+	metrics metricsRecorder
+
+	// degradedHandler is called when a watcher keeps failing and when it recovers.
+	degradedHandler DegradedHandler
+
+	// last keeps the last event delivered per event type, for replay.
+	last lastEvents
 }
 
 // watcherQueue wraps the watchers <-> callbacks communication as well as the
@@ -106,8 +157,8 @@ type watcherQueue struct {
 	dataBus chan eventBusData
 
 	// leaving is a flag that indicates no more job should be processed as we are done
-	// with all watchers and callbacks.
-	leaving bool
+	// with all watchers and callbacks, it's read by the watchers' go routines.
+	leaving atomic.Bool
 }
 
 // EventData wraps the data communicated from a Watcher to a Subscriber.
@@ -116,6 +167,8 @@ type EventData struct {
 	Data interface{}
 	// Error is used when a Watcher has failed and wants communicate its subscribers about the error.
 	Error error
+	// CorrelationID identifies the event in the log lines emitted while handling it.
+	CorrelationID string
 }
 
 // WatcherEventType wraps/couples together a Watcher and an event type.
@@ -124,15 +177,24 @@ type WatcherEventType struct {
 	watcher Watcher
 	// evType idenfities the event type this object refences to.
 	evType string
-	// removed is a channel used to communicate with the running watcher go routine
-	// that it shouldn't renew even if the watcher requested a renew (in response of a
-	// RemoveWatcher() call.
+	// removed is closed to communicate with the running watcher go routine that it
+	// shouldn't renew even if the watcher requested a renew (in response of a
+	// RemoveWatcher() call).
 	removed chan bool
 }
 
 type eventSubscriber struct {
 	data interface{}
 	cb   *EventCb
+	// sub is the subscription handle returned to the subscriber.
+	sub *Subscription
+	// priority is the subscriber's priority class.
+	priority Priority
+	// lastMutex protects last.
+	lastMutex sync.Mutex
+	// last is closed once the callback call of the previous event returns, nil if
+	// the callback was never called.
+	last chan struct{}
 }
 
 type eventBusData struct {
@@ -196,6 +258,8 @@ func newManager() *Manager {
 			finishContextHandler:  make(chan bool),
 			watcherDone:           make(chan string),
 		},
+		watchdog:   newWatchdog(),
+		coalescers: make(map[string]*coalescer),
 	}
 }
 
@@ -211,24 +275,64 @@ func Get() *Manager {
 	return instance
 }
 
+// Subscription is the handle of a subscribed callback, it allows the subscriber to
+// cancel the subscription.
+type Subscription struct {
+	mngr       *Manager
+	evType     string
+	subscriber *eventSubscriber
+	// done is closed once the subscription is canceled.
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// Unsubscribe cancels the subscription, the callback is not called for the events
+// dispatched after Unsubscribe() returns. Calling it more than once is a no-op.
+func (sub *Subscription) Unsubscribe() {
+	sub.doneOnce.Do(func() {
+		sub.mngr.subscribersMutex.Lock()
+		defer sub.mngr.subscribersMutex.Unlock()
+		sub.mngr.unsubscribe(sub.evType, func(curr *eventSubscriber) bool {
+			return curr == sub.subscriber
+		})
+		close(sub.done)
+	})
+}
+
+// Done returns a channel closed once the subscription is canceled, either with
+// Unsubscribe() or by the callback returning false.
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
 // Subscribe registers an event consumer/subscriber callback to a given event type, data
 // is a context pointer provided by the caller to be passed down when calling cb when
-// a new event happens.
-func (mngr *Manager) Subscribe(evType string, data interface{}, cb EventCb) {
-	mngr.subscribersMutex.Lock()
-	defer mngr.subscribersMutex.Unlock()
-	mngr.subscribers[evType] = append(mngr.subscribers[evType],
-		&eventSubscriber{
-			data: data,
-			cb:   &cb,
-		},
-	)
+// a new event happens. The returned subscription can be used to unsubscribe cb. The
+// callback is subscribed with PriorityNormal.
+func (mngr *Manager) Subscribe(evType string, data interface{}, cb EventCb) *Subscription {
+	return mngr.SubscribePriority(evType, PriorityNormal, data, cb)
 }
 
-func (mngr *Manager) unsubscribe(evType string, cb *EventCb) {
+// SubscribeContext is like Subscribe() but the subscription is canceled once ctx
+// is done, for transient subscribers that should clean up after themselves.
+func (mngr *Manager) SubscribeContext(ctx context.Context, evType string, data interface{}, cb EventCb) *Subscription {
+	sub := mngr.Subscribe(evType, data, cb)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Unsubscribe()
+		case <-sub.Done():
+		}
+	}()
+	return sub
+}
+
+// unsubscribe removes the subscribers of evType matching match, callers must hold
+// the subscribersMutex.
+func (mngr *Manager) unsubscribe(evType string, match func(*eventSubscriber) bool) {
 	var keepMe []*eventSubscriber
 	for _, curr := range mngr.subscribers[evType] {
-		if curr.cb != cb {
+		if !match(curr) {
 			keepMe = append(keepMe, curr)
 		}
 	}
@@ -242,15 +346,78 @@ func (mngr *Manager) unsubscribe(evType string, cb *EventCb) {
 }
 
 // Unsubscribe removes the subscription of a given callback for a given event type.
+// Callbacks are matched by their function, prefer the Subscription returned by
+// Subscribe() which also works for closures.
 func (mngr *Manager) Unsubscribe(evType string, cb EventCb) {
 	mngr.subscribersMutex.Lock()
 	defer mngr.subscribersMutex.Unlock()
-	mngr.unsubscribe(evType, &cb)
+
+	fn := reflect.ValueOf(cb).Pointer()
+	mngr.unsubscribe(evType, func(curr *eventSubscriber) bool {
+		return reflect.ValueOf(*curr.cb).Pointer() == fn
+	})
+}
+
+// SetHandlerTimeout sets the maximum time the dispatching of an event waits for
+// each priority class of subscriber callbacks, zero (the default) means it waits
+// indefinitely. A callback exceeding its budget is reported and left running in
+// the background, the lower priority classes and the next events are dispatched
+// without waiting for it. It must be called before Run().
+func (mngr *Manager) SetHandlerTimeout(timeout time.Duration) {
+	mngr.handlerTimeout = timeout
+}
+
+// SetHeartbeat sets a function called whenever the event loop makes progress, i.e.
+// a watcher returned or an event was handled by its subscribers. It allows liveness
+// checks (like the service manager's watchdog) to be gated on the actual event loop
+// progress. It must be called before Run().
+func (mngr *Manager) SetHeartbeat(heartbeat func()) {
+	mngr.heartbeat = heartbeat
+}
+
+// Pause suspends the event dispatching, events produced while paused are
+// dispatched once Resume() is called.
+func (mngr *Manager) Pause() {
+	mngr.pauseMutex.Lock()
+	defer mngr.pauseMutex.Unlock()
+
+	if mngr.resumed == nil {
+		mngr.resumed = make(chan struct{})
+		mngr.watchdog.pause()
+	}
 }
 
-// RemoveWatcher removes a watcher from the event manager. Each running watcher has its own
-// context (derived from the one provided in the AddWatcher() call) and will have it canceled
-// after calling this method.
+// Resume resumes the event dispatching of a paused manager.
+func (mngr *Manager) Resume() {
+	mngr.pauseMutex.Lock()
+	defer mngr.pauseMutex.Unlock()
+
+	if mngr.resumed != nil {
+		close(mngr.resumed)
+		mngr.resumed = nil
+		mngr.watchdog.resume()
+	}
+}
+
+// resumedChannel returns the channel closed when the manager is resumed, or nil
+// if the manager is not paused.
+func (mngr *Manager) resumedChannel() <-chan struct{} {
+	mngr.pauseMutex.Lock()
+	defer mngr.pauseMutex.Unlock()
+	return mngr.resumed
+}
+
+// beat calls the heartbeat function, if set.
+func (mngr *Manager) beat() {
+	if mngr.heartbeat != nil {
+		mngr.heartbeat()
+	}
+}
+
+// RemoveWatcher removes a watcher from the event manager, it can be called before or
+// while the manager is running. Each running watcher has its own context (derived from
+// the one provided in the AddWatcher() call) and will have it canceled after calling
+// this method. Once removed the watcher can be added again with AddWatcher().
 func (mngr *Manager) RemoveWatcher(ctx context.Context, watcher Watcher) error {
 	mngr.watchersMutex.Lock()
 	defer mngr.watchersMutex.Unlock()
@@ -261,22 +428,80 @@ func (mngr *Manager) RemoveWatcher(ctx context.Context, watcher Watcher) error {
 		return fmt.Errorf("unknown Watcher(%s)", id)
 	}
 
+	mngr.runningMutex.RLock()
+	running := mngr.running
+	mngr.runningMutex.RUnlock()
+
+	// If we are not running there's no go routine to abort, just forget the watcher.
+	if !running {
+		mngr.watcherEvents = slices.DeleteFunc(mngr.watcherEvents, func(curr *WatcherEventType) bool {
+			return curr.watcher.ID() == id
+		})
+		delete(mngr.watchersMap, id)
+		return nil
+	}
+
+	// The watcher is forgotten by watcherFinished() once its go routines are done.
 	for _, curr := range mngr.watcherEvents {
+		if curr.watcher.ID() != id {
+			continue
+		}
+
 		if _, found := mngr.removingWatcherEvents[curr.evType]; found {
 			logger.Debugf("Watcher(%s) is being removed, skipping removal request: %s", id, curr.evType)
 			continue
 		}
 
-		if curr.watcher.ID() == id {
-			mngr.removingWatcherEvents[curr.evType] = true
-			logger.Debugf("Removing watcher: %s, event type: %s", id, curr.evType)
-			curr.removed <- true
-		}
+		mngr.removingWatcherEvents[curr.evType] = true
+		logger.Debugf("Removing watcher: %s, event type: %s", id, curr.evType)
+		close(curr.removed)
 	}
 
 	return nil
 }
 
+// watcherFinished forgets the event type of a watcher that is done, either removed or
+// given up, so the watcher can be added again.
+func (mngr *Manager) watcherFinished(evType string) {
+	if watcher := mngr.forgetWatcherEvent(evType); watcher != nil {
+		if closer, ok := watcher.(Closer); ok {
+			logger.Debugf("Closing watcher: %s", watcher.ID())
+			closer.Close()
+		}
+	}
+}
+
+// forgetWatcherEvent forgets the event type of a watcher, if it was the watcher's
+// last event type the watcher is forgotten as well and returned.
+func (mngr *Manager) forgetWatcherEvent(evType string) Watcher {
+	mngr.watchersMutex.Lock()
+	defer mngr.watchersMutex.Unlock()
+
+	delete(mngr.removingWatcherEvents, evType)
+
+	var keepMe []*WatcherEventType
+	var watcher Watcher
+	for _, curr := range mngr.watcherEvents {
+		if curr.evType == evType {
+			watcher = curr.watcher
+			continue
+		}
+		keepMe = append(keepMe, curr)
+	}
+	mngr.watcherEvents = keepMe
+
+	if watcher == nil {
+		return nil
+	}
+	for _, curr := range mngr.watcherEvents {
+		if curr.watcher.ID() == watcher.ID() {
+			return nil
+		}
+	}
+	delete(mngr.watchersMap, watcher.ID())
+	return watcher
+}
+
 // AddWatcher adds/enables a new watcher. The watcher will be fired up right away if the
 // event manager is already running, otherwise it's scheduled to run when Run() is called.
 func (mngr *Manager) AddWatcher(ctx context.Context, watcher Watcher) error {
@@ -323,46 +548,128 @@ func (mngr *Manager) AddWatcher(ctx context.Context, watcher Watcher) error {
 
 func (mngr *Manager) runWatcher(ctx context.Context, watcher Watcher, evType string, removed chan bool) {
 	nCtx, cancel := context.WithCancel(ctx)
-	abort := false
+	var aborted atomic.Bool
 	id := watcher.ID()
 
 	go func() {
-		abort = <-removed
-		logger.Debugf("Got a request to abort watcher(%s) for event: %s", id, evType)
-		cancel()
+		select {
+		case <-removed:
+			aborted.Store(true)
+			logger.Debugf("Got a request to abort watcher(%s) for event: %s", id, evType)
+			cancel()
+		case <-nCtx.Done():
+		}
 	}()
 
+	var sup supervisor
 	for renew := true; renew; {
 		var evData interface{}
 		var err error
 
-		renew, evData, err = watcher.Run(nCtx, evType)
+		// Each run gets its own context so the watchdog can cancel (and renew) a
+		// stalled run without aborting the watcher.
+		runCtx, runCancel := context.WithCancel(nCtx)
+		mngr.watchdog.watcherProgress(evType, runCancel)
+		renew, evData, err = runSupervised(runCtx, watcher, evType)
+		runCancel()
+		mngr.beat()
 
 		logger.Debugf("Watcher(%s) returned event: %q, should renew?: %t", id, evType, renew)
 
-		if abort || mngr.queue.leaving {
+		if abort, leaving := aborted.Load(), mngr.queue.leaving.Load(); abort || leaving {
 			logger.Debugf("Watcher(%s), either are aborting(%t) or leaving(%t), breaking renew cycle",
-				id, abort, mngr.queue.leaving)
+				id, abort, leaving)
 			break
 		}
 
-		mngr.queue.dataBus <- eventBusData{
+		if dropper, ok := watcher.(Dropper); ok && renew && dropper.Drop(evType, err) {
+			logger.Debugf("Watcher(%s) dropped event %q, renewing it", id, evType)
+			mngr.recovered(evType, &sup)
+			continue
+		}
+
+		busData := eventBusData{
 			evType: evType,
 			data: &EventData{
-				Data:  evData,
-				Error: err,
+				Data:          evData,
+				Error:         err,
+				CorrelationID: newCorrelationID(),
 			},
 		}
+
+		if c, found := mngr.coalescers[evType]; found {
+			merged := c.post(ctx, mngr.queue.dataBus, busData)
+			mngr.metrics.produced(id, evType, err != nil, merged)
+		} else {
+			mngr.metrics.produced(id, evType, err != nil, false)
+			// The callback handler is gone once ctx is done, and the events of a
+			// watcher removed meanwhile are dropped, don't block on it.
+			select {
+			case mngr.queue.dataBus <- busData:
+			case <-nCtx.Done():
+			}
+		}
+
+		if !shouldRestart(renew, err) || nCtx.Err() != nil {
+			if renew {
+				mngr.recovered(evType, &sup)
+			}
+			continue
+		}
+
+		// The watcher failed, restart it instead of losing the event source.
+		delay := mngr.failed(evType, &sup, err)
+		mngr.metrics.restarted(id)
+		logger.Errorf("Watcher(%s) failed for event %q, restarting it in %s: %v", id, evType, delay, err)
+
+		select {
+		case <-nCtx.Done():
+		case <-time.After(delay):
+			renew = true
+		}
 	}
 
-	logger.Debugf("watcher finishing: %s", evType)
-	if !abort {
-		removed <- true
+	// Don't lose the events still being coalesced.
+	if c, found := mngr.coalescers[evType]; found && !mngr.queue.leaving.Load() {
+		c.drain(ctx, mngr.queue.dataBus)
 	}
 
+	logger.Debugf("watcher finishing: %s", evType)
+	mngr.watchdog.watcherDone(evType)
+	cancel()
+
 	mngr.queue.watcherDone <- evType
 }
 
+// runCallback calls the subscriber's callback, unsubscribing it if it asks not to be
+// renewed. A panicking callback is reported and kept subscribed.
+func (mngr *Manager) runCallback(ctx context.Context, busData eventBusData, curr *eventSubscriber) {
+	start := time.Now()
+	defer func() {
+		r := recover()
+		if r != nil {
+			logger.Errorf("Callback %s for event %q panicked: %v\n%s", callbackName(curr.cb), busData.evType, r, debug.Stack())
+		}
+		mngr.metrics.handled(busData.evType, r != nil, time.Since(start))
+	}()
+
+	logger.Debugf("Running registered callback for event: %s", busData.evType)
+	ctx = WithCorrelationID(ctx, busData.data.CorrelationID)
+	renew := (*curr.cb)(ctx, busData.evType, curr.data, busData.data)
+	logger.Debugf("Returning from event %q subscribed callback, should renew?: %t", busData.evType, renew)
+	if !renew {
+		curr.sub.Unsubscribe()
+	}
+}
+
+// callbackName returns the function name of cb for reporting.
+func callbackName(cb *EventCb) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(*cb).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
+
 // Run runs the event manager, it will block until all watchers have given up/failed.
 // The event manager is meant to be started right after the early initialization code
 // and live until the application ends, the event manager can not be restarted - the Run()
@@ -391,12 +698,12 @@ func (mngr *Manager) Run(ctx context.Context) error {
 			select {
 			case <-done:
 				logger.Debugf("Got context's Done() signal, leaving.")
-				queue.leaving = true
+				queue.leaving.Store(true)
 				finishCallbackHandler <- true
 				return
 			case <-finishContextHandler:
 				logger.Debugf("Got context handler finish signal, leaving.")
-				queue.leaving = true
+				queue.leaving.Store(true)
 				return
 			}
 		}
@@ -413,26 +720,36 @@ func (mngr *Manager) Run(ctx context.Context) error {
 			case <-finishCallbackHandler:
 				return
 			case busData := <-bus:
-				subscribers := mngr.subscribers[busData.evType]
+				// Hold the event while paused.
+				if resumed := mngr.resumedChannel(); resumed != nil {
+					select {
+					case <-resumed:
+					case <-finishCallbackHandler:
+						return
+					}
+				}
+
+				mngr.subscribersMutex.Lock()
+				subscribers := slices.Clone(mngr.subscribers[busData.evType])
+				mngr.subscribersMutex.Unlock()
+				mngr.metrics.dispatched(busData.evType, subscribers == nil)
+
+				// Keep the latest state for the subscribers requesting a replay, failed
+				// events carry no state.
+				if busData.data.Error == nil {
+					mngr.last.set(busData.evType, busData.data)
+				}
 				if subscribers == nil {
 					logger.Debugf("No subscriber found for event: %s, returning.", busData.evType)
 					continue
 				}
 
-				deleteMe := make([]*eventSubscriber, 0)
-				for _, curr := range subscribers {
-					logger.Debugf("Running registered callback for event: %s", busData.evType)
-					renew := (*curr.cb)(ctx, busData.evType, curr.data, busData.data)
-					if !renew {
-						deleteMe = append(deleteMe, curr)
-					}
-					logger.Debugf("Returning from event %q subscribed callback, should renew?: %t", busData.evType, renew)
-				}
+				mngr.watchdog.dispatching(busData.evType)
+				mngr.dispatch(ctx, busData, subscribers)
+				mngr.watchdog.dispatched()
+				mngr.beat()
 
 				mngr.subscribersMutex.Lock()
-				for _, curr := range deleteMe {
-					mngr.unsubscribe(busData.evType, curr.cb)
-				}
 				leave := mngr.subscribers[busData.evType] == nil
 				mngr.subscribersMutex.Unlock()
 
@@ -447,7 +764,11 @@ func (mngr *Manager) Run(ctx context.Context) error {
 
 	// Creates a goroutine for each registered watcher's event and keep handling its
 	// execution until they give up/finishes their job by returning renew = false.
-	for _, curr := range mngr.watcherEvents {
+	mngr.watchersMutex.Lock()
+	watcherEvents := slices.Clone(mngr.watcherEvents)
+	mngr.watchersMutex.Unlock()
+
+	for _, curr := range watcherEvents {
 		queue.add(curr.evType)
 		go func(watcher Watcher, evType string, removed chan bool) {
 			mngr.runWatcher(ctx, watcher, evType, removed)
@@ -463,15 +784,27 @@ func (mngr *Manager) Run(ctx context.Context) error {
 		for len := queue.length(); len > 0; {
 			doneStr := <-queue.watcherDone
 			len = queue.del(doneStr)
-			delete(mngr.removingWatcherEvents, doneStr)
-			if !queue.leaving && len == 0 {
+			mngr.watcherFinished(doneStr)
+			if !queue.leaving.Load() && len == 0 {
 				logger.Debugf("All watchers are finished, signaling to leave.")
-				queue.finishContextHandler <- true
-				queue.finishCallbackHandler <- true
+				// The context handler may have seen ctx done meanwhile, in which case
+				// it's gone and has already signaled the callback handler.
+				select {
+				case queue.finishContextHandler <- true:
+					queue.finishCallbackHandler <- true
+				case <-ctx.Done():
+				}
 			}
 		}
 	}()
 
+	// Watches the liveness of watchers and event dispatching, if enabled.
+	watchdogDone := make(chan struct{})
+	if mngr.watchdog.enabled() {
+		go mngr.watchdog.run(ctx, watchdogDone)
+	}
+
 	wg.Wait()
+	close(watchdogDone)
 	return nil
 }
@@ -16,12 +16,15 @@ package events
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/metadata"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestAddWatcher(t *testing.T) {
@@ -132,6 +135,88 @@ func TestUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	if err := eventManager.AddWatcher(ctx, &testWatcher{watcherID: "test-watcher", maxCount: 10}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var transient, permanent int
+	var sub *Subscription
+	sub = eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		transient++
+		return true
+	})
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		permanent++
+		if permanent == 2 {
+			sub.Unsubscribe()
+			// Unsubscribing twice is a no-op.
+			sub.Unsubscribe()
+		}
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Errorf("Failed to run event managed, expected success, got error: %+v", err)
+	}
+
+	if transient != 2 {
+		t.Errorf("Unsubscribed callback was called %d times, expected: 2", transient)
+	}
+	if permanent != 10 {
+		t.Errorf("Subscribed callback was called %d times, expected: 10", permanent)
+	}
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Errorf("Subscription's Done() channel not closed after Unsubscribe()")
+	}
+}
+
+func TestSubscribeContext(t *testing.T) {
+	eventManager := newManager()
+	subCtx, cancel := context.WithCancel(context.Background())
+
+	sub := eventManager.SubscribeContext(subCtx, "test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		return true
+	})
+
+	cancel()
+	select {
+	case <-sub.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Subscription not canceled with its context")
+	}
+
+	eventManager.subscribersMutex.Lock()
+	defer eventManager.subscribersMutex.Unlock()
+	if len(eventManager.subscribers["test-watcher,test-event"]) != 0 {
+		t.Errorf("Subscriber kept after its context was canceled")
+	}
+}
+
+func noopCallback(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+	return true
+}
+
+func TestUnsubscribeByCallback(t *testing.T) {
+	eventManager := newManager()
+	eventManager.Subscribe("test-watcher,test-event", nil, noopCallback)
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		return false
+	})
+
+	eventManager.Unsubscribe("test-watcher,test-event", noopCallback)
+
+	if got := len(eventManager.subscribers["test-watcher,test-event"]); got != 1 {
+		t.Errorf("Unsubscribe() left %d subscribers, expected: 1", got)
+	}
+}
+
 func TestCancelBeforeCallbacks(t *testing.T) {
 	watcherID := "test-watcher"
 	timeout := (1 * time.Second) / 100
@@ -636,3 +721,292 @@ func TestCrossWatcherRemovalFromCallback(t *testing.T) {
 		t.Errorf("Failed running event manager, expected success, got error: %+v", err)
 	}
 }
+
+func TestPauseResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	eventManager := newManager()
+	if err := eventManager.AddWatcher(ctx, &testWatcher{watcherID: "test-watcher", maxCount: 3}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var mutex sync.Mutex
+	var counter int
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		counter++
+		return true
+	})
+
+	eventManager.Pause()
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		mutex.Lock()
+		if counter != 0 {
+			t.Errorf("Paused event manager dispatched %d events, want: 0", counter)
+		}
+		mutex.Unlock()
+		eventManager.Resume()
+	}()
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if ctx.Err() != nil {
+		t.Fatalf("Event manager didn't leave before the test timeout, it was never resumed")
+	}
+
+	if counter != 3 {
+		t.Errorf("Got %d events after resuming, want: 3", counter)
+	}
+}
+
+func TestToggleWatcherWhileRunning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	eventManager := newManager()
+
+	// anchor keeps the manager running while the toggled watcher is removed.
+	anchor := &genericWatcher{watcherID: "anchor-watcher", shouldRenew: true, wait: 10 * time.Millisecond}
+	if err := eventManager.AddWatcher(ctx, anchor); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	toggled := &genericWatcher{watcherID: "toggled-watcher", shouldRenew: true, wait: 10 * time.Millisecond}
+	events := make(chan bool)
+	eventManager.Subscribe(toggled.eventID(), nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		select {
+		case events <- true:
+		default:
+		}
+		return true
+	})
+	eventManager.Subscribe(anchor.eventID(), nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		return true
+	})
+
+	done := make(chan error)
+	go func() { done <- eventManager.Run(ctx) }()
+
+	for i := 0; i < 2; i++ {
+		// A removed watcher is only forgotten once its go routine is done.
+		var err error
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if err = eventManager.AddWatcher(ctx, toggled); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("AddWatcher() failed on round %d: %+v", i, err)
+		}
+
+		select {
+		case <-events:
+		case <-ctx.Done():
+			t.Fatalf("Toggled watcher didn't produce events on round %d", i)
+		}
+
+		if err := eventManager.RemoveWatcher(ctx, toggled); err != nil {
+			t.Fatalf("RemoveWatcher() failed on round %d: %+v", i, err)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Failed running event manager, expected success, got error: %+v", err)
+	}
+}
+
+func TestRemoveWatcherNotRunning(t *testing.T) {
+	eventManager := newManager()
+	watcher := &genericWatcher{watcherID: "test-watcher"}
+	ctx := context.Background()
+
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+	if err := eventManager.RemoveWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to remove watcher: %+v", err)
+	}
+	if len(eventManager.watcherEvents) != 0 {
+		t.Errorf("RemoveWatcher() kept %d watcher events, want 0", len(eventManager.watcherEvents))
+	}
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Errorf("AddWatcher() of a removed watcher failed: %+v", err)
+	}
+}
+
+func TestSlowCallbackTimeout(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+	eventManager.SetHandlerTimeout(10 * time.Millisecond)
+
+	if err := eventManager.AddWatcher(ctx, &testWatcher{watcherID: "test-watcher", maxCount: 10}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var slow atomic.Int32
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		slow.Add(1)
+		<-release
+		return true
+	})
+
+	var fast int
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		fast++
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Errorf("Failed to run event managed, expected success, got error: %+v", err)
+	}
+
+	if fast != 10 {
+		t.Errorf("Fast callback was called %d times, expected: 10", fast)
+	}
+	// The events are delivered in order, the next calls wait for the blocked one.
+	if got := slow.Load(); got != 1 {
+		t.Errorf("Slow callback was called %d times, expected: 1", got)
+	}
+}
+
+func TestCallbackPanic(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	if err := eventManager.AddWatcher(ctx, &testWatcher{watcherID: "test-watcher", maxCount: 10}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var panicking, other int
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		panicking++
+		panic("test panic")
+	})
+	eventManager.Subscribe("test-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		other++
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Errorf("Failed to run event managed, expected success, got error: %+v", err)
+	}
+
+	if panicking != 10 {
+		t.Errorf("Panicking callback was called %d times, expected: 10", panicking)
+	}
+	if other != 10 {
+		t.Errorf("Other callback was called %d times, expected: 10", other)
+	}
+}
+
+// closingWatcher handles two event types, giving up after their first run, and
+// counts how many times it's closed.
+type closingWatcher struct {
+	closed atomic.Int32
+	// runs counts the finished runs when closed.
+	runs        atomic.Int32
+	runsAtClose int32
+}
+
+func (cw *closingWatcher) ID() string {
+	return "closing-watcher"
+}
+
+func (cw *closingWatcher) Events() []string {
+	return []string{"closing-watcher,first-event", "closing-watcher,second-event"}
+}
+
+func (cw *closingWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	cw.runs.Add(1)
+	return false, nil, nil
+}
+
+func (cw *closingWatcher) Close() {
+	cw.runsAtClose = cw.runs.Load()
+	cw.closed.Add(1)
+}
+
+func TestCloseWatcher(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	watcher := &closingWatcher{}
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Errorf("Failed to run event managed, expected success, got error: %+v", err)
+	}
+
+	if got := watcher.closed.Load(); got != 1 {
+		t.Errorf("Watcher was closed %d times, expected: 1", got)
+	}
+	if watcher.runsAtClose != 2 {
+		t.Errorf("Watcher was closed after %d runs, expected: 2", watcher.runsAtClose)
+	}
+}
+
+var errNothing = errors.New("nothing happened")
+
+// droppingWatcher fails its first runs with errNothing, dropped by Drop(), before
+// producing an event and giving up.
+type droppingWatcher struct {
+	dropped int
+}
+
+func (dw *droppingWatcher) ID() string {
+	return "dropping-watcher"
+}
+
+func (dw *droppingWatcher) Events() []string {
+	return []string{"dropping-watcher,test-event"}
+}
+
+func (dw *droppingWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	if dw.dropped > 0 {
+		dw.dropped--
+		return true, nil, errNothing
+	}
+	return false, "changed", nil
+}
+
+func (dw *droppingWatcher) Drop(evType string, err error) bool {
+	return errors.Is(err, errNothing)
+}
+
+func TestDropWatcherRun(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	if err := eventManager.AddWatcher(ctx, &droppingWatcher{dropped: 3}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var got []*EventData
+	eventManager.Subscribe("dropping-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		got = append(got, evData)
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if len(got) != 1 || got[0].Error != nil || got[0].Data != "changed" {
+		t.Errorf("Callback got %+v, want only the changed event", got)
+	}
+	if diff := cmp.Diff(WatcherMetrics{Produced: 1}, eventManager.Metrics().Watchers["dropping-watcher"]); diff != "" {
+		t.Errorf("Metrics() returned unexpected watcher metrics (-want +got):\n%s", diff)
+	}
+}
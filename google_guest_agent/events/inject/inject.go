@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inject implements the event injection watcher, it serves a local only
+// gRPC service, on a Unix socket (Windows named pipe), where other on-host agents,
+// i.e. the ops agent, inject events in the agent's event bus.
+package inject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/inject/injectpb"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// WatcherID is the event injection watcher's ID.
+	WatcherID = "inject-watcher"
+	// InjectedEvent is the event type of the injected events.
+	InjectedEvent = "inject-watcher,injected"
+)
+
+// Event is the event data of an injected event.
+type Event struct {
+	// Name is the event's name, i.e. config-changed.
+	Name string
+	// Source is the agent that injected the event, as reported by itself.
+	Source string
+	// Attributes are the event's attributes.
+	Attributes map[string]string
+	// Peer identifies the authenticated peer that injected the event, i.e. its
+	// UID on Linux.
+	Peer string
+}
+
+// Watcher is the event injection watcher implementation.
+type Watcher struct {
+	injectpb.UnimplementedEventInjectionServer
+
+	// path is the socket (named pipe) path.
+	path string
+	// allowedUsers are the users, besides root, allowed to inject events. Only
+	// used on Linux, the named pipe is restricted to LocalSystem and the
+	// Administrators.
+	allowedUsers []string
+	// events passes the injected events from the gRPC handlers to Run().
+	events chan *Event
+	// serverMutex protects server.
+	serverMutex sync.Mutex
+	// server is the gRPC server, nil until the first run.
+	server *grpc.Server
+}
+
+// New allocates and initializes a new Watcher serving on path, if path is empty
+// the platform's DefaultPath is used. On Linux allowedUsers are the names (or
+// UIDs) of the users, besides root, allowed to inject events.
+func New(path string, allowedUsers []string) *Watcher {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	return &Watcher{
+		path:         path,
+		allowedUsers: allowedUsers,
+		events:       make(chan *Event),
+	}
+}
+
+// ID returns the event injection watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{InjectedEvent}
+}
+
+// Inject implements the EventInjection service, it passes the event to Run() and
+// returns once it's queued for dispatching.
+func (w *Watcher) Inject(ctx context.Context, req *injectpb.InjectRequest) (*injectpb.InjectResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "the event name is required")
+	}
+
+	ev := &Event{
+		Name:       req.GetName(),
+		Source:     req.GetSource(),
+		Attributes: req.GetAttributes(),
+		Peer:       peerName(ctx),
+	}
+
+	select {
+	case w.events <- ev:
+		logger.Debugf("Event %q injected by %s (%s)", ev.Name, ev.Source, ev.Peer)
+		return &injectpb.InjectResponse{}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// start starts serving on the socket on first use, the server is kept across runs
+// and stopped by Close().
+func (w *Watcher) start(ctx context.Context) error {
+	w.serverMutex.Lock()
+	defer w.serverMutex.Unlock()
+
+	if w.server != nil {
+		return nil
+	}
+
+	creds, err := serverCredentials(w.allowedUsers)
+	if err != nil {
+		return err
+	}
+
+	l, err := listen(ctx, w.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on event injection socket %s: %w", w.path, err)
+	}
+
+	server := grpc.NewServer(grpc.Creds(creds))
+	injectpb.RegisterEventInjectionServer(server, w)
+	w.server = server
+
+	go func() {
+		if err := server.Serve(l); err != nil {
+			logger.Errorf("Event injection server failed: %v", err)
+		}
+
+		// Forget the stopped (or failed) server so the next run serves again.
+		w.serverMutex.Lock()
+		defer w.serverMutex.Unlock()
+		if w.server == server {
+			w.server = nil
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the server, it's called by the event manager once the watcher is
+// removed or the manager is leaving.
+func (w *Watcher) Close() {
+	w.serverMutex.Lock()
+	server := w.server
+	w.server = nil
+	w.serverMutex.Unlock()
+
+	if server != nil {
+		server.Stop()
+	}
+}
+
+// Run waits for an event to be injected and report it back, the server is kept
+// across runs.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	if evType != InjectedEvent {
+		return false, nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+
+	if err := w.start(ctx); err != nil {
+		return false, nil, err
+	}
+
+	select {
+	case ev := <-w.events:
+		return true, ev, nil
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
+}
+
+// Send injects the event req in the agent serving on path, if path is empty the
+// platform's DefaultPath is used.
+func Send(ctx context.Context, path string, req *injectpb.InjectRequest) error {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	conn, err := grpc.DialContext(ctx, "passthrough:///"+path,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(ctx, path)
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to connect to event injection socket %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	if _, err := injectpb.NewEventInjectionClient(conn).Inject(ctx, req); err != nil {
+		return fmt.Errorf("failed to inject event %q: %w", req.GetName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package inject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// DefaultPath is the default event injection socket path for linux.
+const DefaultPath = "/run/google-guest-agent/inject.sock"
+
+// peerInfo is the auth info of a peer authenticated by its credentials.
+type peerInfo struct {
+	credentials.CommonAuthInfo
+	// uid and pid are the peer's process user and process IDs.
+	uid uint32
+	pid int32
+}
+
+// AuthType returns the peer credentials authentication type.
+func (peerInfo) AuthType() string {
+	return "peercred"
+}
+
+// peerCredentials authenticates the peers by their process user (SO_PEERCRED),
+// root and the allowed users are accepted, the other connections are closed
+// during the handshake.
+type peerCredentials struct {
+	// allowed are the allowed UIDs besides root.
+	allowed map[uint32]bool
+}
+
+// ClientHandshake doesn't authenticate the server, its socket is root owned.
+func (c *peerCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, peerInfo{}, nil
+}
+
+// ServerHandshake authenticates the peer of conn by its process user.
+func (c *peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cred, err := peerCred(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !c.isAllowed(cred.Uid) {
+		return nil, nil, fmt.Errorf("peer uid %d (pid %d) is not allowed to inject events", cred.Uid, cred.Pid)
+	}
+
+	info := peerInfo{uid: cred.Uid, pid: cred.Pid}
+	info.SecurityLevel = credentials.PrivacyAndIntegrity
+	return conn, info, nil
+}
+
+// Info returns the peer credentials protocol info.
+func (c *peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+// Clone returns a copy of c.
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	return &peerCredentials{allowed: c.allowed}
+}
+
+// OverrideServerName is a no-op, the server isn't authenticated.
+func (c *peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// isAllowed returns true if uid is allowed to inject events.
+func (c *peerCredentials) isAllowed(uid uint32) bool {
+	return uid == 0 || c.allowed[uid]
+}
+
+// peerCred returns the credentials of the process connected to conn.
+func peerCred(conn net.Conn) (*unix.Ucred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("unexpected connection type %T", conn)
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get raw connection: %w", err)
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to control raw connection: %w", err)
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("failed to get peer credentials: %w", credErr)
+	}
+	return cred, nil
+}
+
+// serverCredentials returns the credentials authenticating the peers by their
+// process user, users are the names (or UIDs) allowed besides root. Unknown users
+// are reported and ignored.
+func serverCredentials(users []string) (credentials.TransportCredentials, error) {
+	creds := &peerCredentials{allowed: make(map[uint32]bool)}
+
+	for _, curr := range users {
+		if uid, err := strconv.ParseUint(curr, 10, 32); err == nil {
+			creds.allowed[uint32(uid)] = true
+			continue
+		}
+
+		u, err := user.Lookup(curr)
+		if err != nil {
+			logger.Errorf("Failed to lookup user %q allowed to inject events: %v", curr, err)
+			continue
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			logger.Errorf("Invalid uid %q of user %q: %v", u.Uid, curr, err)
+			continue
+		}
+		creds.allowed[uint32(uid)] = true
+	}
+
+	return creds, nil
+}
+
+// peerName returns the UID and PID of the authenticated peer of ctx.
+func peerName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	info, ok := p.AuthInfo.(peerInfo)
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("uid=%d,pid=%d", info.uid, info.pid)
+}
+
+// listen listens on the Unix socket path, any user can connect to it but only
+// root and the allowed users pass the handshake. A stale socket left by a
+// previous run is removed.
+func listen(ctx context.Context, path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	// Bind the socket in a private (0700) directory, give it its final permissions
+	// and only then move it to path, it's never reachable with the umask's ones.
+	tmpDir, err := os.MkdirTemp(dir, ".listen-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary socket directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, filepath.Base(path))
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	// The listener would unlink the temporary name, socketListener unlinks path.
+	ul.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, 0666); err != nil {
+		ul.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		ul.Close()
+		return nil, fmt.Errorf("failed to move socket in place: %w", err)
+	}
+
+	return &socketListener{UnixListener: ul, path: path}, nil
+}
+
+// socketListener is a Unix socket listener removing its socket once closed.
+type socketListener struct {
+	*net.UnixListener
+	// path is the socket path.
+	path string
+	// removeOnce guards the socket removal, a new socket may have been created
+	// at path by a later listen().
+	removeOnce sync.Once
+}
+
+// Close closes the listener and removes its socket.
+func (l *socketListener) Close() error {
+	err := l.UnixListener.Close()
+	l.removeOnce.Do(func() {
+		os.Remove(l.path)
+	})
+	return err
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", path)
+}
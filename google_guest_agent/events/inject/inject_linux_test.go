@@ -0,0 +1,200 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package inject
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/inject/injectpb"
+	"github.com/google/go-cmp/cmp"
+)
+
+// waitSocket waits for the socket path to be created.
+func waitSocket(t *testing.T, path string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("socket %s was not created", path)
+}
+
+func TestSend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "run", "inject.sock")
+	w := New(path, []string{strconv.Itoa(os.Getuid())})
+	defer w.Close()
+
+	events := make(chan *Event)
+	go func() {
+		for {
+			renew, data, err := w.Run(ctx, InjectedEvent)
+			if !renew {
+				return
+			}
+			if err != nil {
+				t.Errorf("Run(%s) failed: %v", InjectedEvent, err)
+				return
+			}
+			events <- data.(*Event)
+		}
+	}()
+	waitSocket(t, path)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", path, err)
+	}
+	if got := stat.Mode().Perm(); got != 0666 {
+		t.Errorf("event injection socket mode = %o, want 0666", got)
+	}
+
+	req := &injectpb.InjectRequest{
+		Name:       "config-changed",
+		Source:     "ops-agent",
+		Attributes: map[string]string{"file": "config.yaml"},
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- Send(ctx, path, req)
+	}()
+
+	want := &Event{
+		Name:       "config-changed",
+		Source:     "ops-agent",
+		Attributes: map[string]string{"file": "config.yaml"},
+		Peer:       fmt.Sprintf("uid=%d,pid=%d", os.Getuid(), os.Getpid()),
+	}
+
+	select {
+	case got := <-events:
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Run() returned unexpected event (-want +got):\n%s", diff)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the injected event")
+	}
+
+	if err := <-sent; err != nil {
+		t.Errorf("Send(%+v) failed: %v", req, err)
+	}
+}
+
+func TestManagerRuns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "run", "inject.sock")
+	w := New(path, []string{strconv.Itoa(os.Getuid())})
+
+	eventManager := events.Get()
+	if err := eventManager.AddWatcher(ctx, w); err != nil {
+		t.Fatalf("AddWatcher(%s) failed: %v", w.ID(), err)
+	}
+
+	injected := make(chan string)
+	eventManager.Subscribe(InjectedEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		// The last run fails once the manager is leaving.
+		if evData.Error != nil {
+			if ctx.Err() == nil {
+				t.Errorf("Got event %q with error: %v", evType, evData.Error)
+			}
+			return true
+		}
+		injected <- evData.Data.(*Event).Name
+		return true
+	})
+
+	managerDone := make(chan error)
+	go func() {
+		managerDone <- eventManager.Run(ctx)
+	}()
+	waitSocket(t, path)
+
+	// Each event is reported by its own run, the server must outlive them.
+	for _, name := range []string{"first-event", "second-event", "third-event"} {
+		req := &injectpb.InjectRequest{Name: name, Source: "test"}
+		sent := make(chan error, 1)
+		go func() {
+			sent <- Send(ctx, path, req)
+		}()
+
+		select {
+		case got := <-injected:
+			if got != name {
+				t.Errorf("Got injected event %q, want %q", got, name)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for the injected event %q", name)
+		}
+
+		if err := <-sent; err != nil {
+			t.Errorf("Send(%+v) failed: %v", req, err)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-managerDone:
+		if err != nil {
+			t.Errorf("Run() failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the event manager to leave")
+	}
+
+	// The manager closed the watcher on its way out.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v, want the socket removed once the watcher is closed", path, err)
+	}
+}
+
+func TestServerCredentials(t *testing.T) {
+	creds, err := serverCredentials([]string{"1234", "root", "no-such-user-for-sure"})
+	if err != nil {
+		t.Fatalf("serverCredentials() failed: %v", err)
+	}
+	peerCreds := creds.(*peerCredentials)
+
+	tests := []struct {
+		uid  uint32
+		want bool
+	}{
+		{uid: 0, want: true},
+		{uid: 1234, want: true},
+		{uid: 4321, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(strconv.Itoa(int(tc.uid)), func(t *testing.T) {
+			if got := peerCreds.isAllowed(tc.uid); got != tc.want {
+				t.Errorf("isAllowed(%d) = %t, want %t", tc.uid, got, tc.want)
+			}
+		})
+	}
+}
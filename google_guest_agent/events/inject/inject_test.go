@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/inject/injectpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInjectErrors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *injectpb.InjectRequest
+		want codes.Code
+	}{
+		{
+			name: "missing-name",
+			ctx:  context.Background(),
+			req:  &injectpb.InjectRequest{Source: "test"},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "not-running",
+			ctx:  canceled,
+			req:  &injectpb.InjectRequest{Name: "test-event"},
+			want: codes.Canceled,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := New("", nil)
+			_, err := w.Inject(tc.ctx, tc.req)
+			if got := status.Code(err); got != tc.want {
+				t.Errorf("Inject(%+v) returned code %s, want %s", tc.req, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunUnknownEvent(t *testing.T) {
+	w := New("", nil)
+	renew, _, err := w.Run(context.Background(), "inject-watcher,unknown")
+	if renew {
+		t.Errorf("Run() asked to be renewed for an unknown event type")
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Run() returned error %v, want errors.ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"context"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// DefaultPath is the default named pipe path for windows.
+	DefaultPath = `\\.\pipe\google-guest-agent-inject`
+	// securityDescriptor only grants access to LocalSystem and the Administrators.
+	securityDescriptor = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+)
+
+// serverCredentials returns the server's credentials, the peers are authenticated
+// by the named pipe's security descriptor so the allowed users are ignored.
+func serverCredentials(users []string) (credentials.TransportCredentials, error) {
+	return insecure.NewCredentials(), nil
+}
+
+// peerName returns the authenticated peer of ctx, on Windows it's any process
+// of LocalSystem or the Administrators.
+func peerName(ctx context.Context) string {
+	return "named pipe"
+}
+
+// listen listens on the named pipe path, only accessible by LocalSystem and the
+// Administrators.
+func listen(ctx context.Context, path string) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		InputBufferSize:    4096,
+		OutputBufferSize:   4096,
+		SecurityDescriptor: securityDescriptor,
+	})
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal implements the systemd journal events watcher, it follows the
+// journal and publishes the entries selected by its filters as agent events.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// WatcherID is the journal watcher's ID.
+	WatcherID = "journal-watcher"
+	// eventPrefix prefixes the filter's name in its event type ID.
+	eventPrefix = "journal-watcher,"
+)
+
+// Filter selects the journal entries published as an event.
+type Filter struct {
+	// Name identifies the filter, it's the suffix of the filter's event type.
+	Name string
+	// Matches are journalctl's FIELD=VALUE matches, i.e. _SYSTEMD_UNIT=sshd.service.
+	// Matches of different fields must all match, matches of the same field match
+	// if any of them does.
+	Matches []string
+	// Pattern, if set, must match the entry's message, i.e. "Out of memory: Killed".
+	Pattern *regexp.Regexp
+}
+
+// Entry is the event data of a journal entry.
+type Entry struct {
+	// Time is the time the entry was received by the journal.
+	Time time.Time
+	// Unit is the systemd unit that logged the entry, if any.
+	Unit string
+	// Identifier is the syslog identifier of the entry, i.e. sshd or kernel.
+	Identifier string
+	// PID is the process ID that logged the entry, zero for kernel messages.
+	PID int
+	// Priority is the syslog priority of the entry, i.e. 3 for errors.
+	Priority int
+	// Message is the entry's message.
+	Message string
+	// Fields are all the entry's fields.
+	Fields map[string]string
+}
+
+// Event returns the event type of the entries selected by the filter name.
+func Event(name string) string {
+	return eventPrefix + name
+}
+
+// matches returns true if entry's message matches the filter's pattern, the
+// journal matches are applied by journalctl.
+func (f Filter) matches(entry *Entry) bool {
+	return f.Pattern == nil || f.Pattern.MatchString(entry.Message)
+}
+
+// parseEntry parses a journal entry in journalctl's JSON output format, where
+// binary values are arrays of bytes and fields set more than once are arrays.
+func parseEntry(data []byte) (*Entry, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse journal entry: %w", err)
+	}
+
+	fields := make(map[string]string)
+	for key, value := range raw {
+		var str string
+		if err := json.Unmarshal(value, &str); err == nil {
+			fields[key] = str
+			continue
+		}
+
+		var bytes []byte
+		var ints []int
+		if err := json.Unmarshal(value, &ints); err == nil {
+			for _, curr := range ints {
+				bytes = append(bytes, byte(curr))
+			}
+			fields[key] = string(bytes)
+			continue
+		}
+
+		// Fields set more than once, keep the first value.
+		var strs []string
+		if err := json.Unmarshal(value, &strs); err == nil && len(strs) > 0 {
+			fields[key] = strs[0]
+		}
+	}
+
+	entry := &Entry{
+		Unit:       fields["_SYSTEMD_UNIT"],
+		Identifier: fields["SYSLOG_IDENTIFIER"],
+		Message:    fields["MESSAGE"],
+		Fields:     fields,
+	}
+
+	if usec, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		entry.Time = time.UnixMicro(usec)
+	}
+	if pid, err := strconv.Atoi(fields["_PID"]); err == nil {
+		entry.PID = pid
+	}
+	if priority, err := strconv.Atoi(fields["PRIORITY"]); err == nil {
+		entry.Priority = priority
+	}
+
+	return entry, nil
+}
+
+// Watcher is the journal event watcher implementation.
+type Watcher struct {
+	// filters maps the event types to their filters.
+	filters map[string]Filter
+	// events are the event types in the filters order.
+	events []string
+	// followersMutex protects followers, each event type is run in its own go
+	// routine.
+	followersMutex sync.Mutex
+	// followers maps the event types to their running journal follower.
+	followers map[string]*follower
+}
+
+// New allocates and initializes a new Watcher publishing the entries selected by
+// filters, only the entries logged after the watcher is run are published.
+func New(filters ...Filter) *Watcher {
+	w := &Watcher{
+		filters:   make(map[string]Filter),
+		followers: make(map[string]*follower),
+	}
+
+	for _, curr := range filters {
+		evType := Event(curr.Name)
+		w.filters[evType] = curr
+		w.events = append(w.events, evType)
+	}
+
+	return w
+}
+
+// ID returns the journal event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return w.events
+}
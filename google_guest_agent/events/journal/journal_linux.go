@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package journal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// journalctlCommand is the command following the journal, overridden in unit
+	// tests.
+	journalctlCommand = "journalctl"
+	// restartDelay is how long to wait before following the journal again once
+	// journalctl exited.
+	restartDelay = 10 * time.Second
+)
+
+const (
+	// maxEntrySize is the maximum size of a journal entry in the JSON format.
+	maxEntrySize = 1024 * 1024
+)
+
+// follower follows the journal with journalctl and relays the selected entries.
+type follower struct {
+	// cancel stops journalctl.
+	cancel context.CancelFunc
+	// entries receives the selected entries.
+	entries chan *Entry
+	// done is closed once journalctl exited, err is set before.
+	done chan struct{}
+	err  error
+}
+
+// follow starts following the entries selected by filter.
+func follow(filter Filter) (*follower, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	args := append([]string{"--follow", "--lines=0", "--output=json"}, filter.Matches...)
+	cmd := exec.CommandContext(ctx, journalctlCommand, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to get journalctl's output: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start journalctl: %w", err)
+	}
+
+	f := &follower{
+		cancel:  cancel,
+		entries: make(chan *Entry),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(f.done)
+		f.err = f.read(ctx, stdout, filter)
+		if err := cmd.Wait(); f.err == nil {
+			f.err = fmt.Errorf("journalctl exited: %v", err)
+		}
+	}()
+
+	return f, nil
+}
+
+// read reads journalctl's output relaying the entries selected by filter until
+// ctx is done or the output ends.
+func (f *follower) read(ctx context.Context, r io.Reader, filter Filter) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEntrySize)
+
+	for scanner.Scan() {
+		entry, err := parseEntry(scanner.Bytes())
+		if err != nil {
+			logger.Debugf("Skipping journal entry: %v", err)
+			continue
+		}
+		if !filter.matches(entry) {
+			continue
+		}
+
+		select {
+		case f.entries <- entry:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read journalctl's output: %w", err)
+	}
+	return nil
+}
+
+// stop stops journalctl and waits for it to exit.
+func (f *follower) stop() {
+	f.cancel()
+	<-f.done
+}
+
+// follower returns evType's follower, starting it on first use.
+func (w *Watcher) follower(evType string) (*follower, error) {
+	w.followersMutex.Lock()
+	defer w.followersMutex.Unlock()
+
+	if f, found := w.followers[evType]; found {
+		return f, nil
+	}
+
+	filter, found := w.filters[evType]
+	if !found {
+		return nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+
+	f, err := follow(filter)
+	if err != nil {
+		return nil, err
+	}
+	w.followers[evType] = f
+	return f, nil
+}
+
+// forget stops and forgets evType's follower.
+func (w *Watcher) forget(evType string) {
+	w.followersMutex.Lock()
+	defer w.followersMutex.Unlock()
+
+	if f, found := w.followers[evType]; found {
+		f.stop()
+		delete(w.followers, evType)
+	}
+}
+
+// Run waits for a journal entry selected by evType's filter and report it back,
+// the journal is followed across runs so entries logged while the event is being
+// handled aren't lost.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	f, err := w.follower(evType)
+	if err != nil {
+		return false, nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		w.forget(evType)
+		return false, nil, ctx.Err()
+	case entry := <-f.entries:
+		return true, entry, nil
+	case <-f.done:
+		w.forget(evType)
+		logger.Errorf("Stopped following the journal for %s: %v", evType, f.err)
+
+		// Follow the journal again in the next run, but don't spin if journalctl
+		// keeps exiting.
+		select {
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		case <-time.After(restartDelay):
+			return true, nil, f.err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package journal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// fakeJournalctl installs a journalctl script printing output and then blocking,
+// it records its arguments in the returned file.
+func fakeJournalctl(t *testing.T, output string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	outputFile := filepath.Join(dir, "output")
+	if err := os.WriteFile(outputFile, []byte(output), 0644); err != nil {
+		t.Fatalf("Failed to write fake journalctl output: %v", err)
+	}
+
+	script := filepath.Join(dir, "journalctl")
+	content := "#!/bin/sh\necho \"$@\" > " + argsFile + "\ncat " + outputFile + "\nexec sleep 60\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write fake journalctl: %v", err)
+	}
+
+	oldCommand := journalctlCommand
+	journalctlCommand = script
+	t.Cleanup(func() { journalctlCommand = oldCommand })
+
+	return argsFile
+}
+
+func TestRun(t *testing.T) {
+	argsFile := fakeJournalctl(t, `{"SYSLOG_IDENTIFIER":"kernel","MESSAGE":"eth0: link up"}
+not json
+{"SYSLOG_IDENTIFIER":"kernel","MESSAGE":"Out of memory: Killed process 1234 (java)"}
+`)
+
+	w := New(Filter{Name: "oom", Matches: []string{"SYSLOG_IDENTIFIER=kernel"}, Pattern: regexp.MustCompile("Out of memory")})
+	evType := Event("oom")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	renew, data, err := w.Run(ctx, evType)
+	if err != nil {
+		t.Fatalf("Run() failed unexpectedly: %v", err)
+	}
+	if !renew {
+		t.Errorf("Run() renew = false, want: true")
+	}
+
+	entry, ok := data.(*Entry)
+	if !ok {
+		t.Fatalf("Run() returned %T, want: *Entry", data)
+	}
+	if entry.Message != "Out of memory: Killed process 1234 (java)" {
+		t.Errorf("Run() returned entry with message %q, want the OOM kill", entry.Message)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("Failed to read fake journalctl args: %v", err)
+	}
+	if want := "--follow --lines=0 --output=json SYSLOG_IDENTIFIER=kernel\n"; string(args) != want {
+		t.Errorf("journalctl called with %q, want: %q", args, want)
+	}
+
+	cancel()
+	renew, _, err = w.Run(ctx, evType)
+	if renew || err == nil {
+		t.Errorf("Run() = (%t, %v) after context canceled, want: (false, context error)", renew, err)
+	}
+	if len(w.followers) != 0 {
+		t.Errorf("Run() kept %d followers after context canceled, want: 0", len(w.followers))
+	}
+}
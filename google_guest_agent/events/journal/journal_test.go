@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseEntry(t *testing.T) {
+	data := `{"__REALTIME_TIMESTAMP":"1714644672123456","_SYSTEMD_UNIT":"sshd.service","SYSLOG_IDENTIFIER":"sshd","_PID":"812","PRIORITY":"6","MESSAGE":[83,101,114,118,101,114,32,108,105,115,116,101,110,105,110,103],"_CMDLINE":["sshd -D","sshd -D -R"]}`
+
+	got, err := parseEntry([]byte(data))
+	if err != nil {
+		t.Fatalf("parseEntry() failed unexpectedly: %v", err)
+	}
+
+	want := &Entry{
+		Time:       time.UnixMicro(1714644672123456),
+		Unit:       "sshd.service",
+		Identifier: "sshd",
+		PID:        812,
+		Priority:   6,
+		Message:    "Server listening",
+		Fields: map[string]string{
+			"__REALTIME_TIMESTAMP": "1714644672123456",
+			"_SYSTEMD_UNIT":        "sshd.service",
+			"SYSLOG_IDENTIFIER":    "sshd",
+			"_PID":                 "812",
+			"PRIORITY":             "6",
+			"MESSAGE":              "Server listening",
+			"_CMDLINE":             "sshd -D",
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseEntry() returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseEntry([]byte("{")); err == nil {
+		t.Errorf("parseEntry() succeeded for malformed JSON, want error")
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		message string
+		want    bool
+	}{
+		{
+			name:    "no_pattern",
+			filter:  Filter{Name: "sshd"},
+			message: "Server listening",
+			want:    true,
+		},
+		{
+			name:    "pattern_match",
+			filter:  Filter{Name: "oom", Pattern: regexp.MustCompile("Out of memory: Killed")},
+			message: "Out of memory: Killed process 1234 (java)",
+			want:    true,
+		},
+		{
+			name:    "pattern_mismatch",
+			filter:  Filter{Name: "oom", Pattern: regexp.MustCompile("Out of memory: Killed")},
+			message: "eth0: link up",
+			want:    false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.matches(&Entry{Message: tc.message}); got != tc.want {
+				t.Errorf("matches(%q) = %t, want: %t", tc.message, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	w := New(Filter{Name: "sshd"}, Filter{Name: "oom"})
+
+	want := []string{"journal-watcher,sshd", "journal-watcher,oom"}
+	if diff := cmp.Diff(want, w.Events()); diff != "" {
+		t.Errorf("Events() returned unexpected diff (-want +got):\n%s", diff)
+	}
+	if w.ID() != WatcherID {
+		t.Errorf("ID() = %q, want: %q", w.ID(), WatcherID)
+	}
+}
@@ -17,6 +17,7 @@ package metadata
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/url"
 
@@ -57,6 +58,12 @@ func (mp *Watcher) Events() []string {
 // Run listens to metadata changes and report back the event.
 func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
 	descriptor, err := mp.client.Watch(ctx)
+	if errors.Is(err, metadata.ErrUnchanged) {
+		// Not a failure, the longpoll timed out without changes, see Drop().
+		mp.failedPrevious = false
+		return true, nil, err
+	}
+
 	if err != nil {
 		// Only log error once to avoid transient errors and not to spam the log on network failures.
 		if !mp.failedPrevious {
@@ -74,3 +81,9 @@ func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, e
 
 	return true, descriptor, err
 }
+
+// Drop returns true for the longpolls timing out without changes, they're renewed
+// without notifying the subscribers.
+func (mp *Watcher) Drop(evType string, err error) bool {
+	return errors.Is(err, metadata.ErrUnchanged)
+}
@@ -16,6 +16,7 @@ package metadata
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -29,6 +30,7 @@ var (
 
 type mdsClient struct {
 	disableUnknownFailure bool
+	unchanged             bool
 }
 
 func (mds *mdsClient) Get(ctx context.Context) (*metadata.Descriptor, error) {
@@ -44,6 +46,9 @@ func (mds *mdsClient) GetKeyRecursive(ctx context.Context, key string) (string,
 }
 
 func (mds *mdsClient) Watch(ctx context.Context) (*metadata.Descriptor, error) {
+	if mds.unchanged {
+		return nil, metadata.ErrUnchanged
+	}
 	if !mds.disableUnknownFailure {
 		return nil, errUnknown
 	}
@@ -99,3 +104,33 @@ func TestWatcherUnknownFailure(t *testing.T) {
 		t.Errorf("watcher.Run(%s) returned renew: %t, expected: true.", LongpollEvent, renew)
 	}
 }
+
+func TestWatcherUnchanged(t *testing.T) {
+	watcher := New()
+	watcher.client = &mdsClient{unchanged: true}
+	watcher.failedPrevious = true
+
+	renew, evData, err := watcher.Run(context.Background(), LongpollEvent)
+	if !errors.Is(err, metadata.ErrUnchanged) {
+		t.Errorf("watcher.Run(%s) returned error: %v, expected: %v.", LongpollEvent, err, metadata.ErrUnchanged)
+	}
+
+	if !renew {
+		t.Errorf("watcher.Run(%s) returned renew: %t, expected: true.", LongpollEvent, renew)
+	}
+
+	if evData != nil {
+		t.Errorf("watcher.Run(%s) returned data: %+v, expected: nil.", LongpollEvent, evData)
+	}
+
+	if watcher.failedPrevious {
+		t.Errorf("watcher.Run(%s) kept the previous failure, an unchanged descriptor is not a failure.", LongpollEvent)
+	}
+
+	if !watcher.Drop(LongpollEvent, err) {
+		t.Errorf("watcher.Drop(%s, %v) = false, expected the unchanged longpoll to be dropped.", LongpollEvent, err)
+	}
+	if watcher.Drop(LongpollEvent, errUnknown) {
+		t.Errorf("watcher.Drop(%s, %v) = true, expected the failure to be published.", LongpollEvent, errUnknown)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// SubtreeWatcherID is the metadata subtree watcher's ID.
+	SubtreeWatcherID = "metadata-subtree-watcher"
+	// subtreeEventPrefix prefixes the subtree's path in its event type ID.
+	subtreeEventPrefix = "metadata-watcher,subtree,"
+)
+
+// subtreeClient is the metadata client interface required to watch subtrees.
+type subtreeClient interface {
+	WatchKey(context.Context, string) (string, error)
+}
+
+// Subtree is the event data of a metadata subtree change.
+type Subtree struct {
+	// Key is the subtree's path, i.e. instance/attributes/ssh-keys.
+	Key string
+	// Value is the subtree's JSON value.
+	Value json.RawMessage
+}
+
+// SubtreeEvent returns the event type of the changes to the metadata subtree key,
+// i.e. instance/network-interfaces.
+func SubtreeEvent(key string) string {
+	return subtreeEventPrefix + strings.Trim(key, "/")
+}
+
+// SubtreeWatcher watches a set of metadata subtrees, each one with its own
+// longpoll, so a change to a subtree only wakes up and delivers the subtree's
+// (smaller) value to its subscribers.
+type SubtreeWatcher struct {
+	client subtreeClient
+	keys   []string
+
+	// failedMutex protects failedPrevious.
+	failedMutex sync.Mutex
+	// failedPrevious maps the subtrees whose previous longpoll failed.
+	failedPrevious map[string]bool
+}
+
+// NewSubtreeWatcher allocates and initializes a new SubtreeWatcher watching the
+// subtrees keys, i.e. instance/attributes/ssh-keys.
+func NewSubtreeWatcher(keys ...string) *SubtreeWatcher {
+	var trimmed []string
+	for _, key := range keys {
+		trimmed = append(trimmed, strings.Trim(key, "/"))
+	}
+
+	return &SubtreeWatcher{
+		client:         metadata.New(),
+		keys:           trimmed,
+		failedPrevious: make(map[string]bool),
+	}
+}
+
+// ID returns the metadata subtree watcher id.
+func (sw *SubtreeWatcher) ID() string {
+	return SubtreeWatcherID
+}
+
+// Events returns the event types of the watched subtrees.
+func (sw *SubtreeWatcher) Events() []string {
+	var res []string
+	for _, key := range sw.keys {
+		res = append(res, SubtreeEvent(key))
+	}
+	return res
+}
+
+// Run listens to the changes of the subtree of evType and reports back its new
+// value.
+func (sw *SubtreeWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	key := strings.TrimPrefix(evType, subtreeEventPrefix)
+
+	value, err := sw.client.WatchKey(ctx, key)
+	if errors.Is(err, metadata.ErrUnchanged) {
+		// Not a failure, the longpoll timed out without changes, see Drop().
+		sw.setFailed(key, false)
+		return true, nil, err
+	}
+
+	if err != nil {
+		// Only log error once to avoid spamming the log on network failures.
+		if !sw.setFailed(key, true) {
+			logger.Errorf("Error watching metadata subtree %q: %s", key, err)
+		}
+		return true, nil, err
+	}
+
+	sw.setFailed(key, false)
+	return true, &Subtree{Key: key, Value: json.RawMessage(value)}, nil
+}
+
+// Drop returns true for the longpolls timing out without changes, they're renewed
+// without notifying the subscribers.
+func (sw *SubtreeWatcher) Drop(evType string, err error) bool {
+	return errors.Is(err, metadata.ErrUnchanged)
+}
+
+// setFailed records whether the last longpoll of key failed and returns whether
+// the previous one had failed.
+func (sw *SubtreeWatcher) setFailed(key string, failed bool) bool {
+	sw.failedMutex.Lock()
+	defer sw.failedMutex.Unlock()
+	previous := sw.failedPrevious[key]
+	sw.failedPrevious[key] = failed
+	return previous
+}
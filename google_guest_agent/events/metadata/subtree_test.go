@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+type subtreeMDSClient struct {
+	keys   []string
+	values map[string]string
+}
+
+func (mds *subtreeMDSClient) WatchKey(ctx context.Context, key string) (string, error) {
+	mds.keys = append(mds.keys, key)
+	value, found := mds.values[key]
+	if !found {
+		return "", metadata.ErrUnchanged
+	}
+	return value, nil
+}
+
+func TestSubtreeWatcherEvents(t *testing.T) {
+	watcher := NewSubtreeWatcher("/instance/attributes/ssh-keys", "instance/network-interfaces/")
+
+	want := []string{
+		"metadata-watcher,subtree,instance/attributes/ssh-keys",
+		"metadata-watcher,subtree,instance/network-interfaces",
+	}
+	if diff := cmp.Diff(want, watcher.Events()); diff != "" {
+		t.Errorf("Events() returned unexpected events (-want +got):\n%s", diff)
+	}
+
+	if watcher.ID() != SubtreeWatcherID {
+		t.Errorf("ID() = %q, want %q", watcher.ID(), SubtreeWatcherID)
+	}
+}
+
+func TestSubtreeWatcherRun(t *testing.T) {
+	client := &subtreeMDSClient{values: map[string]string{"instance/attributes/ssh-keys": `"user:key"`}}
+	watcher := NewSubtreeWatcher("instance/attributes/ssh-keys", "instance/network-interfaces")
+	watcher.client = client
+	ctx := context.Background()
+
+	renew, evData, err := watcher.Run(ctx, SubtreeEvent("instance/attributes/ssh-keys"))
+	if err != nil {
+		t.Fatalf("Run() failed unexpectedly with error: %v", err)
+	}
+	if !renew {
+		t.Errorf("Run() returned renew: false, want: true")
+	}
+	want := &Subtree{Key: "instance/attributes/ssh-keys", Value: []byte(`"user:key"`)}
+	if diff := cmp.Diff(want, evData); diff != "" {
+		t.Errorf("Run() returned unexpected event data (-want +got):\n%s", diff)
+	}
+
+	renew, evData, err = watcher.Run(ctx, SubtreeEvent("instance/network-interfaces"))
+	if !errors.Is(err, metadata.ErrUnchanged) {
+		t.Errorf("Run() returned error: %v, want: %v", err, metadata.ErrUnchanged)
+	}
+	if !renew || evData != nil {
+		t.Errorf("Run() returned (%t, %+v), want (true, nil)", renew, evData)
+	}
+	if !watcher.Drop(SubtreeEvent("instance/network-interfaces"), err) {
+		t.Errorf("Drop(%v) = false, want the unchanged longpoll dropped", err)
+	}
+
+	if diff := cmp.Diff([]string{"instance/attributes/ssh-keys", "instance/network-interfaces"}, client.keys); diff != "" {
+		t.Errorf("Run() watched unexpected keys (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+var (
+	// handlerBuckets are the upper bounds of the handler latency histogram buckets.
+	handlerBuckets = []time.Duration{10 * time.Millisecond, 100 * time.Millisecond, time.Second,
+		10 * time.Second, time.Minute, 5 * time.Minute}
+)
+
+// WatcherMetrics are the counters of a watcher, across all its event types.
+type WatcherMetrics struct {
+	// Produced is the number of events produced by the watcher.
+	Produced uint64
+	// Errors is the number of events produced with an error.
+	Errors uint64
+	// Restarts is the number of times the watcher failed and was restarted.
+	Restarts uint64
+}
+
+// EventMetrics are the counters and histograms of an event type.
+type EventMetrics struct {
+	// Produced is the number of events produced by the watcher.
+	Produced uint64
+	// Errors is the number of events produced with an error.
+	Errors uint64
+	// Coalesced is the number of events merged with a pending one.
+	Coalesced uint64
+	// Dispatched is the number of events dispatched to the subscribers.
+	Dispatched uint64
+	// Unhandled is the number of events dispatched with no subscriber.
+	Unhandled uint64
+	// HandlerSuccesses is the number of callbacks that returned.
+	HandlerSuccesses uint64
+	// HandlerFailures is the number of callbacks that panicked.
+	HandlerFailures uint64
+	// HandlerTimeouts is the number of callbacks that didn't return within the
+	// handler timeout, they are counted again once they return.
+	HandlerTimeouts uint64
+	// HandlerLatency is the duration distribution of the callbacks.
+	HandlerLatency metadata.Histogram
+	// Queued is the number of events produced but not dispatched yet, i.e. waiting
+	// for the previous event to be handled or being coalesced.
+	Queued int
+}
+
+// Metrics are the counters of the events manager, by watcher and event type.
+type Metrics struct {
+	// Watchers maps the watcher IDs to their metrics.
+	Watchers map[string]WatcherMetrics
+	// Events maps the event types to their metrics.
+	Events map[string]EventMetrics
+	// QueueDepth is the number of events produced but not dispatched yet, across
+	// all event types.
+	QueueDepth int
+}
+
+// String returns a single line summary of m, by event type.
+func (m Metrics) String() string {
+	parts := []string{fmt.Sprintf("queue_depth=%d", m.QueueDepth)}
+	for _, evType := range slices.Sorted(maps.Keys(m.Events)) {
+		ev := m.Events[evType]
+		parts = append(parts, fmt.Sprintf("%s: produced=%d errors=%d coalesced=%d dispatched=%d successes=%d failures=%d timeouts=%d handler_latency_mean=%s queued=%d",
+			evType, ev.Produced, ev.Errors, ev.Coalesced, ev.Dispatched, ev.HandlerSuccesses, ev.HandlerFailures,
+			ev.HandlerTimeouts, ev.HandlerLatency.Mean(), ev.Queued))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// metricsRecorder records the metrics of a Manager, its zero value is ready to use.
+type metricsRecorder struct {
+	mutex    sync.Mutex
+	watchers map[string]*WatcherMetrics
+	events   map[string]*EventMetrics
+}
+
+// event returns evType's metrics, allocating them on first use. Callers must hold
+// the mutex.
+func (r *metricsRecorder) event(evType string) *EventMetrics {
+	if r.events == nil {
+		r.events = make(map[string]*EventMetrics)
+	}
+	ev, found := r.events[evType]
+	if !found {
+		ev = &EventMetrics{HandlerLatency: metadata.NewHistogram(handlerBuckets)}
+		r.events[evType] = ev
+	}
+	return ev
+}
+
+// watcher returns id's metrics, allocating them on first use. Callers must hold
+// the mutex.
+func (r *metricsRecorder) watcher(id string) *WatcherMetrics {
+	if r.watchers == nil {
+		r.watchers = make(map[string]*WatcherMetrics)
+	}
+	w, found := r.watchers[id]
+	if !found {
+		w = &WatcherMetrics{}
+		r.watchers[id] = w
+	}
+	return w
+}
+
+// produced records an event produced by the watcher id, failed if the watcher
+// reported an error. merged is true if the event was merged with a pending one.
+func (r *metricsRecorder) produced(id string, evType string, failed bool, merged bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	w, ev := r.watcher(id), r.event(evType)
+	w.Produced++
+	ev.Produced++
+	if failed {
+		w.Errors++
+		ev.Errors++
+	}
+	if merged {
+		ev.Coalesced++
+		return
+	}
+	ev.Queued++
+}
+
+// restarted records a restart of the watcher id after a failure.
+func (r *metricsRecorder) restarted(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.watcher(id).Restarts++
+}
+
+// dispatched records an event taken off the queue, unhandled if it had no
+// subscriber.
+func (r *metricsRecorder) dispatched(evType string, unhandled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ev := r.event(evType)
+	ev.Queued--
+	if unhandled {
+		ev.Unhandled++
+		return
+	}
+	ev.Dispatched++
+}
+
+// handled records a callback of evType returning after elapsed, failed if it
+// panicked.
+func (r *metricsRecorder) handled(evType string, failed bool, elapsed time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ev := r.event(evType)
+	if failed {
+		ev.HandlerFailures++
+	} else {
+		ev.HandlerSuccesses++
+	}
+	ev.HandlerLatency.Observe(elapsed)
+}
+
+// timedOut records a callback of evType not returning within the handler timeout.
+func (r *metricsRecorder) timedOut(evType string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.event(evType).HandlerTimeouts++
+}
+
+func (r *metricsRecorder) snapshot() Metrics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := Metrics{
+		Watchers: make(map[string]WatcherMetrics),
+		Events:   make(map[string]EventMetrics),
+	}
+	for id, w := range r.watchers {
+		m.Watchers[id] = *w
+	}
+	for evType, ev := range r.events {
+		curr := *ev
+		curr.HandlerLatency = ev.HandlerLatency.Clone()
+		m.Events[evType] = curr
+		m.QueueDepth += curr.Queued
+	}
+	return m
+}
+
+// Metrics returns a snapshot of the manager's metrics.
+func (mngr *Manager) Metrics() Metrics {
+	return mngr.metrics.snapshot()
+}
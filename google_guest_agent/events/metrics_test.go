@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		coalesce bool
+		want     EventMetrics
+	}{
+		{
+			name: "dispatched",
+			want: EventMetrics{Produced: 3, Dispatched: 3, HandlerSuccesses: 2, HandlerFailures: 1},
+		},
+		{
+			name:     "coalesced",
+			coalesce: true,
+			want:     EventMetrics{Produced: 3, Coalesced: 2, Dispatched: 1, HandlerSuccesses: 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			evType := "burst-watcher,test-event"
+			eventManager := newManager()
+			if tc.coalesce {
+				eventManager.EnableCoalescing(50*time.Millisecond, evType)
+			}
+
+			if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1, 2, 3}, giveUp: true}); err != nil {
+				t.Fatalf("Failed to add watcher to event manager: %+v", err)
+			}
+
+			eventManager.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+				if evData.Data == 2 {
+					panic("handler failed")
+				}
+				return true
+			})
+
+			if err := eventManager.Run(ctx); err != nil {
+				t.Fatalf("Failed to run event manager: %+v", err)
+			}
+
+			got := eventManager.Metrics()
+			if diff := cmp.Diff(WatcherMetrics{Produced: 3}, got.Watchers["burst-watcher"]); diff != "" {
+				t.Errorf("Metrics() returned unexpected watcher metrics (-want +got):\n%s", diff)
+			}
+
+			ev := got.Events[evType]
+			if diff := cmp.Diff(tc.want, ev, cmpopts.IgnoreFields(EventMetrics{}, "HandlerLatency")); diff != "" {
+				t.Errorf("Metrics() returned unexpected event metrics (-want +got):\n%s", diff)
+			}
+			if handled := tc.want.HandlerSuccesses + tc.want.HandlerFailures; ev.HandlerLatency.Count != handled {
+				t.Errorf("Metrics() handler latency count = %d, want %d", ev.HandlerLatency.Count, handled)
+			}
+			if got.QueueDepth != 0 {
+				t.Errorf("Metrics() queue depth = %d, want 0", got.QueueDepth)
+			}
+			if summary := got.String(); !strings.Contains(summary, evType+": produced=3") {
+				t.Errorf("Metrics().String() = %q, want it to contain the %s metrics", summary, evType)
+			}
+		})
+	}
+}
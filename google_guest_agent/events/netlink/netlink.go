@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netlink implements the network configuration events watcher, it
+// reports interfaces appearing or disappearing and address or route changes.
+package netlink
+
+import (
+	"sync"
+)
+
+const (
+	// WatcherID is the netlink watcher's ID.
+	WatcherID = "netlink-watcher"
+	// LinkEvent is the network interface added, removed or changed event type ID.
+	LinkEvent = "netlink-watcher,link"
+	// AddressEvent is the address added or removed event type ID.
+	AddressEvent = "netlink-watcher,address"
+	// RouteEvent is the route added or removed event type ID.
+	RouteEvent = "netlink-watcher,route"
+)
+
+// Change describes a network configuration change.
+type Change struct {
+	// Removed is true if the interface, address or route was removed.
+	Removed bool
+	// Index is the index of the interface the change relates to, zero if unknown.
+	Index int
+}
+
+// Watcher is the netlink event watcher implementation.
+type Watcher struct {
+	// socketsMutex protects sockets, each event type is run in its own go routine.
+	socketsMutex sync.Mutex
+	// sockets maps the event types to their netlink socket.
+	sockets map[string]*socket
+}
+
+// New allocates and initializes a new Watcher.
+func New() *Watcher {
+	return &Watcher{sockets: make(map[string]*socket)}
+}
+
+// ID returns the netlink event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return []string{LinkEvent, AddressEvent, RouteEvent}
+}
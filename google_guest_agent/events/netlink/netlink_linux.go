@@ -0,0 +1,191 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package netlink
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// pollTimeout is how long, in milliseconds, a wait lasts before checking ctx again.
+	pollTimeout = 1000
+)
+
+var (
+	// eventGroups maps the event types to the multicast groups they subscribe to.
+	eventGroups = map[string]uint32{
+		LinkEvent:    unix.RTMGRP_LINK,
+		AddressEvent: unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+		RouteEvent:   unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	}
+)
+
+// socket is a rtnetlink socket subscribed to an event type's multicast groups.
+type socket struct {
+	fd  int
+	buf []byte
+}
+
+// open opens a socket subscribed to groups.
+func open(groups uint32) (*socket, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	return &socket{fd: fd, buf: make([]byte, unix.Getpagesize()*4)}, nil
+}
+
+// close closes the socket.
+func (s *socket) close() {
+	unix.Close(s.fd)
+}
+
+// read waits for the next notifications, it returns nil if none arrived within
+// the poll timeout.
+func (s *socket) read() ([]Change, error) {
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, pollTimeout)
+	if err != nil && err != unix.EINTR {
+		return nil, fmt.Errorf("failed to poll netlink socket: %w", err)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	n, _, err = unix.Recvfrom(s.fd, s.buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netlink socket: %w", err)
+	}
+
+	return parseMessages(s.buf[:n])
+}
+
+// parseMessages parses the rtnetlink notifications in data.
+func parseMessages(data []byte) ([]Change, error) {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse netlink messages: %w", err)
+	}
+
+	var res []Change
+	for _, msg := range msgs {
+		switch msg.Header.Type {
+		case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+			if len(msg.Data) < unix.SizeofIfInfomsg {
+				continue
+			}
+			res = append(res, Change{
+				Removed: msg.Header.Type == unix.RTM_DELLINK,
+				Index:   int(int32(binary.NativeEndian.Uint32(msg.Data[4:8]))),
+			})
+		case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+			if len(msg.Data) < unix.SizeofIfAddrmsg {
+				continue
+			}
+			res = append(res, Change{
+				Removed: msg.Header.Type == unix.RTM_DELADDR,
+				Index:   int(binary.NativeEndian.Uint32(msg.Data[4:8])),
+			})
+		case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+			change := Change{Removed: msg.Header.Type == unix.RTM_DELROUTE}
+			attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+			if err == nil {
+				for _, attr := range attrs {
+					if attr.Attr.Type == unix.RTA_OIF && len(attr.Value) >= 4 {
+						change.Index = int(binary.NativeEndian.Uint32(attr.Value))
+					}
+				}
+			}
+			res = append(res, change)
+		}
+	}
+
+	return res, nil
+}
+
+// socket returns evType's socket, opening it on first use.
+func (w *Watcher) socket(evType string) (*socket, error) {
+	w.socketsMutex.Lock()
+	defer w.socketsMutex.Unlock()
+
+	if s, found := w.sockets[evType]; found {
+		return s, nil
+	}
+
+	groups, found := eventGroups[evType]
+	if !found {
+		return nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+
+	s, err := open(groups)
+	if err != nil {
+		return nil, err
+	}
+	w.sockets[evType] = s
+	return s, nil
+}
+
+// forget closes and forgets evType's socket.
+func (w *Watcher) forget(evType string) {
+	w.socketsMutex.Lock()
+	defer w.socketsMutex.Unlock()
+
+	if s, found := w.sockets[evType]; found {
+		s.close()
+		delete(w.sockets, evType)
+	}
+}
+
+// Run waits for network configuration changes of evType's kind and report them
+// back as a []Change, the socket is kept across runs so changes happening while
+// the event is being handled aren't lost.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	s, err := w.socket(evType)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for {
+		if ctx.Err() != nil {
+			w.forget(evType)
+			return false, nil, ctx.Err()
+		}
+
+		changes, err := s.read()
+		if err != nil {
+			// Open the socket again in the next run, i.e. after the socket buffer
+			// overflowed and notifications were lost.
+			w.forget(evType)
+			return true, nil, err
+		}
+		if len(changes) > 0 {
+			return true, changes, nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+// message builds a netlink message of type typ with payload.
+func message(typ uint16, payload []byte) []byte {
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload)+unix.NLMSG_ALIGNTO)
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], typ)
+	msg = append(msg, payload...)
+	for len(msg)%unix.NLMSG_ALIGNTO != 0 {
+		msg = append(msg, 0)
+	}
+	return msg
+}
+
+func TestParseMessages(t *testing.T) {
+	link := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(link[4:8], 2)
+
+	addr := make([]byte, unix.SizeofIfAddrmsg)
+	binary.NativeEndian.PutUint32(addr[4:8], 3)
+
+	// A route message followed by its RTA_OIF attribute.
+	route := make([]byte, unix.SizeofRtMsg, unix.SizeofRtMsg+8)
+	oif := make([]byte, 8)
+	binary.NativeEndian.PutUint16(oif[0:2], 8)
+	binary.NativeEndian.PutUint16(oif[2:4], unix.RTA_OIF)
+	binary.NativeEndian.PutUint32(oif[4:8], 4)
+	route = append(route, oif...)
+
+	var data []byte
+	data = append(data, message(unix.RTM_NEWLINK, link)...)
+	data = append(data, message(unix.RTM_DELADDR, addr)...)
+	data = append(data, message(unix.RTM_NEWROUTE, route)...)
+	// Messages of other types are ignored.
+	data = append(data, message(unix.RTM_NEWNEIGH, make([]byte, 12))...)
+
+	got, err := parseMessages(data)
+	if err != nil {
+		t.Fatalf("parseMessages() failed unexpectedly: %v", err)
+	}
+
+	want := []Change{
+		{Index: 2},
+		{Removed: true, Index: 3},
+		{Index: 4},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseMessages() returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseMessages(message(unix.RTM_NEWLINK, link)[:unix.NLMSG_HDRLEN+4]); err == nil {
+		t.Errorf("parseMessages() succeeded for a truncated message, want error")
+	}
+}
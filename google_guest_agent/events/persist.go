@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// fileNameEscaper escapes the characters of an event type not allowed in a
+	// file name.
+	fileNameEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C", ":", "%3A")
+)
+
+// DecodeFunc decodes the data of a persisted event from its JSON encoding.
+type DecodeFunc func(data []byte) (interface{}, error)
+
+// lastEvents keeps the last event delivered per event type, so it can be replayed
+// to new subscribers.
+type lastEvents struct {
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// events maps the event types to their last delivered event.
+	events map[string]*EventData
+	// persisted maps the persisted event types to their file.
+	persisted map[string]string
+}
+
+// set records evData as the last event of evType, persisting it if enabled.
+func (l *lastEvents) set(evType string, evData *EventData) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.events == nil {
+		l.events = make(map[string]*EventData)
+	}
+	l.events[evType] = evData
+
+	file, found := l.persisted[evType]
+	if !found {
+		return
+	}
+
+	data, err := json.Marshal(evData.Data)
+	if err != nil {
+		logger.Errorf("Failed to encode event %q for persistence: %v", evType, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		logger.Errorf("Failed to create event state directory: %v", err)
+		return
+	}
+	// The events may hold sensitive data, i.e. ssh keys.
+	if err := utils.SaferWriteFile(data, file, 0600); err != nil {
+		logger.Errorf("Failed to persist event %q: %v", evType, err)
+	}
+}
+
+// get returns the last event of evType, nil if there's none.
+func (l *lastEvents) get(evType string) *EventData {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.events[evType]
+}
+
+// EnablePersistence persists the last event of evType delivered to the subscribers
+// to a file in dir, JSON encoded. The persisted event is loaded back, with decode,
+// so it's replayed to the subscribers requesting it even before the watcher
+// produces its first event after the agent restarts. It must be called before
+// Run().
+func (mngr *Manager) EnablePersistence(dir string, evType string, decode DecodeFunc) error {
+	file := filepath.Join(dir, fileNameEscaper.Replace(evType)+".json")
+
+	mngr.last.mutex.Lock()
+	defer mngr.last.mutex.Unlock()
+
+	if mngr.last.persisted == nil {
+		mngr.last.persisted = make(map[string]string)
+	}
+	mngr.last.persisted[evType] = file
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read persisted event %q: %w", evType, err)
+	}
+
+	evData, err := decode(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode persisted event %q: %w", evType, err)
+	}
+
+	if mngr.last.events == nil {
+		mngr.last.events = make(map[string]*EventData)
+	}
+	mngr.last.events[evType] = &EventData{Data: evData, CorrelationID: newCorrelationID()}
+	return nil
+}
+
+// SubscribeReplay is like Subscribe() but cb is called right away with the last
+// event of evType delivered, or persisted, if any. It lets subscribers registered
+// after startup get the current state instead of waiting for the next change.
+// The replayed event may race with a new event of evType being dispatched.
+func (mngr *Manager) SubscribeReplay(ctx context.Context, evType string, data interface{}, cb EventCb) *Subscription {
+	sub := mngr.Subscribe(evType, data, cb)
+
+	if evData := mngr.last.get(evType); evData != nil {
+		logger.Debugf("Replaying the last %q event to the new subscriber.", evType)
+		mngr.call(ctx, eventBusData{evType: evType, data: evData}, sub.subscriber)
+	}
+
+	return sub
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// decodeInt decodes the persisted burstWatcher events.
+func decodeInt(data []byte) (interface{}, error) {
+	var res int
+	err := json.Unmarshal(data, &res)
+	return res, err
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evType := "burst-watcher,test-event"
+	eventManager := newManager()
+	if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1}, giveUp: true}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	replayed := make(chan interface{}, 1)
+	eventManager.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		// A late subscriber gets the event already delivered.
+		go eventManager.SubscribeReplay(ctx, evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			replayed <- evData.Data
+			return true
+		})
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	select {
+	case got := <-replayed:
+		if got != 1 {
+			t.Errorf("Replayed event data = %v, want 1", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The last event was not replayed to the late subscriber")
+	}
+}
+
+func TestEnablePersistence(t *testing.T) {
+	dir := t.TempDir()
+	evType := "burst-watcher,test-event"
+
+	// First "boot", the delivered event is persisted.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventManager := newManager()
+	if err := eventManager.EnablePersistence(dir, evType, decodeInt); err != nil {
+		t.Fatalf("EnablePersistence() failed: %v", err)
+	}
+	if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{7}, giveUp: true}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+	eventManager.Subscribe(evType, nil, noopCallback)
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	file := filepath.Join(dir, evType+".json")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read persisted event: %v", err)
+	}
+	if string(data) != "7" {
+		t.Errorf("Persisted event = %q, want %q", data, "7")
+	}
+
+	// Second "boot", the persisted event is replayed before any event is produced.
+	eventManager = newManager()
+	if err := eventManager.EnablePersistence(dir, evType, decodeInt); err != nil {
+		t.Fatalf("EnablePersistence() failed: %v", err)
+	}
+
+	replayed := make(chan interface{}, 1)
+	eventManager.SubscribeReplay(ctx, evType, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		replayed <- evData.Data
+		return true
+	})
+
+	select {
+	case got := <-replayed:
+		if got != 7 {
+			t.Errorf("Replayed event data = %v, want 7", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The persisted event was not replayed")
+	}
+}
+
+func TestEnablePersistenceInvalid(t *testing.T) {
+	dir := t.TempDir()
+	evType := "metadata-watcher,subtree,instance/attributes"
+
+	file := filepath.Join(dir, "metadata-watcher,subtree,instance%2Fattributes.json")
+	if err := os.WriteFile(file, []byte("not json"), 0600); err != nil {
+		t.Fatalf("Failed to write persisted event: %v", err)
+	}
+
+	if err := newManager().EnablePersistence(dir, evType, decodeInt); err == nil {
+		t.Errorf("EnablePersistence() succeeded loading an invalid event, want error")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// Priority is the priority class of a subscriber, for a given event the callbacks
+// of a higher priority class are called, and waited for, before the callbacks of
+// a lower priority class.
+type Priority int
+
+const (
+	// PriorityCritical is the priority class of the subscribers other subscribers
+	// may depend on, i.e. the ones setting up the network routes.
+	PriorityCritical Priority = 100
+	// PriorityNormal is the default priority class.
+	PriorityNormal Priority = 0
+	// PriorityBestEffort is the priority class of the subscribers nothing depends
+	// on, i.e. the ones reporting telemetry.
+	PriorityBestEffort Priority = -100
+)
+
+// SubscribePriority is like Subscribe() but cb is subscribed with the provided
+// priority class. The callbacks of the same priority class are called
+// concurrently, in the order they were subscribed.
+func (mngr *Manager) SubscribePriority(evType string, priority Priority, data interface{}, cb EventCb) *Subscription {
+	mngr.subscribersMutex.Lock()
+	defer mngr.subscribersMutex.Unlock()
+
+	sub := &Subscription{
+		mngr:   mngr,
+		evType: evType,
+		done:   make(chan struct{}),
+	}
+	sub.subscriber = &eventSubscriber{
+		data:     data,
+		cb:       &cb,
+		sub:      sub,
+		priority: priority,
+	}
+
+	// Keep the subscribers sorted by priority class, in subscription order within
+	// the class.
+	subscribers := mngr.subscribers[evType]
+	i := slices.IndexFunc(subscribers, func(curr *eventSubscriber) bool {
+		return curr.priority < priority
+	})
+	if i < 0 {
+		i = len(subscribers)
+	}
+	mngr.subscribers[evType] = slices.Insert(subscribers, i, sub.subscriber)
+	return sub
+}
+
+// dispatch calls the subscribers' callbacks one priority class at a time, from the
+// highest to the lowest, subscribers must be sorted by priority class.
+func (mngr *Manager) dispatch(ctx context.Context, busData eventBusData, subscribers []*eventSubscriber) {
+	for len(subscribers) > 0 {
+		end := slices.IndexFunc(subscribers, func(curr *eventSubscriber) bool {
+			return curr.priority != subscribers[0].priority
+		})
+		if end < 0 {
+			end = len(subscribers)
+		}
+		mngr.dispatchClass(ctx, busData, subscribers[:end])
+		subscribers = subscribers[end:]
+	}
+}
+
+// dispatchClass calls the callbacks of a priority class, each in its own go
+// routine, and waits for them to return or for the handler timeout to expire.
+func (mngr *Manager) dispatchClass(ctx context.Context, busData eventBusData, subscribers []*eventSubscriber) {
+	var timeout <-chan time.Time
+	if mngr.handlerTimeout > 0 {
+		timer := time.NewTimer(mngr.handlerTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	pending := make(map[*eventSubscriber]<-chan struct{})
+	for _, curr := range subscribers {
+		// Skip the subscribers canceled while dispatching this event.
+		select {
+		case <-curr.sub.Done():
+			continue
+		default:
+		}
+		pending[curr] = mngr.call(ctx, busData, curr)
+	}
+
+	for curr, done := range pending {
+		select {
+		case <-done:
+		case <-timeout:
+			// The timer fires only once, report all the remaining callbacks.
+			timeout = nil
+			for curr, done := range pending {
+				select {
+				case <-done:
+				default:
+					logger.Errorf("Callback %s for event %q didn't return within %s, not waiting for it",
+						callbackName(curr.cb), busData.evType, mngr.handlerTimeout)
+					mngr.metrics.timedOut(busData.evType)
+				}
+			}
+			return
+		}
+		delete(pending, curr)
+	}
+}
+
+// call calls the subscriber's callback in its own go routine once the call of
+// the previous event returned, so a subscriber gets the events in order even if
+// a timed out call is still running. The returned channel is closed once the call
+// returns.
+func (mngr *Manager) call(ctx context.Context, busData eventBusData, curr *eventSubscriber) <-chan struct{} {
+	done := make(chan struct{})
+
+	curr.lastMutex.Lock()
+	prev := curr.last
+	curr.last = done
+	curr.lastMutex.Unlock()
+
+	// Only wait if the previous call is still running.
+	if prev != nil {
+		select {
+		case <-prev:
+			prev = nil
+		default:
+		}
+	}
+
+	go func() {
+		defer close(done)
+		if prev == nil {
+			mngr.runCallback(ctx, busData, curr)
+			return
+		}
+
+		<-prev
+		// The subscription may have been canceled while waiting.
+		select {
+		case <-curr.sub.Done():
+		default:
+			mngr.runCallback(ctx, busData, curr)
+		}
+	}()
+
+	return done
+}
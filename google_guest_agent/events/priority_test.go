@@ -0,0 +1,132 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSubscribePriorityOrder(t *testing.T) {
+	eventManager := newManager()
+	evType := "test-watcher,test-event"
+
+	subscribe := func(priority Priority) *Subscription {
+		return eventManager.SubscribePriority(evType, priority, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			return true
+		})
+	}
+
+	normal1 := subscribe(PriorityNormal)
+	bestEffort := subscribe(PriorityBestEffort)
+	critical := subscribe(PriorityCritical)
+	normal2 := subscribe(PriorityNormal)
+
+	want := []*eventSubscriber{critical.subscriber, normal1.subscriber, normal2.subscriber, bestEffort.subscriber}
+	got := eventManager.subscribers[evType]
+	if len(got) != len(want) {
+		t.Fatalf("SubscribePriority() registered %d subscribers, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Subscriber %d has priority %d, want subscriber with priority %d", i, got[i].priority, want[i].priority)
+		}
+	}
+}
+
+func TestDispatchPriority(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+
+	if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1, 2}, giveUp: true}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var mutex sync.Mutex
+	var got []string
+	subscribe := func(name string, priority Priority) {
+		eventManager.SubscribePriority("burst-watcher,test-event", priority, nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+			// Give the lower priority callbacks a chance to run if they weren't waiting.
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			defer mutex.Unlock()
+			got = append(got, name)
+			return true
+		})
+	}
+
+	subscribe("best-effort", PriorityBestEffort)
+	subscribe("normal", PriorityNormal)
+	subscribe("critical", PriorityCritical)
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	want := []string{"critical", "normal", "best-effort", "critical", "normal", "best-effort"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Callbacks called in unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestInOrderDeliveryAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	eventManager := newManager()
+	eventManager.SetHandlerTimeout(10 * time.Millisecond)
+
+	if err := eventManager.AddWatcher(ctx, &burstWatcher{events: []int{1, 2, 3}, giveUp: true}); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	var got []int
+	eventManager.Subscribe("burst-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		value := evData.Data.(int)
+		// Block the first event past the handler timeout.
+		if value == 1 {
+			<-release
+		}
+		got = append(got, value)
+		if value == 3 {
+			close(finished)
+		}
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("Callback handled %v while the first event was blocked, want nothing", got)
+	}
+
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the queued events to be handled")
+	}
+
+	if diff := cmp.Diff([]int{1, 2, 3}, got); diff != "" {
+		t.Errorf("Events delivered out of order (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reload implements the configuration reload request events watcher.
+package reload
+
+import (
+	"os"
+)
+
+const (
+	// WatcherID is the reload watcher's ID.
+	WatcherID = "config-reload-watcher"
+	// ReloadEvent is the reload's request event type ID.
+	ReloadEvent = "config-reload-watcher,reload"
+)
+
+// Watcher is the reload event watcher implementation.
+type Watcher struct {
+	// signals receives the reload request signals, it's kept across runs so
+	// requests received while the event is being handled aren't lost.
+	signals chan os.Signal
+}
+
+// New allocates and initializes a new Watcher, it starts relaying the reload
+// request signals right away.
+func New() *Watcher {
+	signals := make(chan os.Signal, 1)
+	notify(signals)
+	return &Watcher{signals: signals}
+}
+
+// ID returns the reload event watcher id.
+func (mp *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (mp *Watcher) Events() []string {
+	return []string{ReloadEvent}
+}
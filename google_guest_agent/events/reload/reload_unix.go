@@ -0,0 +1,43 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// notify relays SIGHUP to signals.
+func notify(signals chan os.Signal) {
+	signal.Notify(signals, syscall.SIGHUP)
+}
+
+// Run waits for a SIGHUP and report back the event.
+func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	select {
+	case <-ctx.Done():
+		signal.Stop(mp.signals)
+		return false, nil, ctx.Err()
+	case sig := <-mp.signals:
+		logger.Infof("Got %s, requesting configuration reload.", sig)
+		return true, nil, nil
+	}
+}
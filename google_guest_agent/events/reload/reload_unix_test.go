@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package reload
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watcher := New()
+	result := make(chan bool)
+
+	go func() {
+		renew, _, err := watcher.Run(ctx, ReloadEvent)
+		if err != nil {
+			t.Errorf("Run() returned error: %v", err)
+		}
+		result <- renew
+	}()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("Failed to send SIGHUP: %v", err)
+	}
+
+	select {
+	case renew := <-result:
+		if !renew {
+			t.Errorf("Run() returned renew: false, want: true")
+		}
+	case <-ctx.Done():
+		t.Fatalf("Run() didn't return after SIGHUP")
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	renew, _, err := New().Run(ctx, ReloadEvent)
+	if renew || err == nil {
+		t.Errorf("Run() with canceled context = (%t, %v), want: (false, non-nil error)", renew, err)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// notify is a no-op implementation for windows.
+func notify(signals chan os.Signal) {}
+
+// Run is a no-op implementation for windows.
+func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	return false, nil, fmt.Errorf("configuration reload Watcher is not yet implemented for windows: %w", errors.ErrUnsupported)
+}
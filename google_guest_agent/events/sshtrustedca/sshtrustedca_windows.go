@@ -16,10 +16,11 @@ package sshtrustedca
 
 import (
 	"context"
+	"errors"
 	"fmt"
 )
 
 // Run is a no-op implementation for windows.
 func (mp *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
-	return false, nil, fmt.Errorf("SSH Trusted CA cert pipe Watcher is not yet implemented for windows.")
+	return false, nil, fmt.Errorf("SSH Trusted CA cert pipe Watcher is not yet implemented for windows: %w", errors.ErrUnsupported)
 }
@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// restartBackoff is the delay before restarting a failed watcher, it's doubled
+	// after each consecutive failure up to maxRestartBackoff.
+	restartBackoff = time.Second
+	// maxRestartBackoff caps the delay before restarting a failed watcher.
+	maxRestartBackoff = 5 * time.Minute
+	// degradedThreshold is the number of consecutive failures after which a
+	// watcher is reported as degraded.
+	degradedThreshold = 5
+)
+
+// DegradedHandler is called when a watcher's event type is degraded, it failed
+// degradedThreshold consecutive times, and again once it recovers. err is the last
+// failure, nil when recovered.
+type DegradedHandler func(evType string, degraded bool, err error)
+
+// supervisor tracks the consecutive failures of a watcher's event type.
+type supervisor struct {
+	// failures is the number of consecutive failures.
+	failures int
+	// degraded is set once failures reaches degradedThreshold.
+	degraded bool
+}
+
+// SetDegradedHandler sets the function called when a watcher keeps failing and
+// when it recovers, i.e. to report the agent as degraded to the service manager.
+// It must be called before Run().
+func (mngr *Manager) SetDegradedHandler(handler DegradedHandler) {
+	mngr.degradedHandler = handler
+}
+
+// runSupervised calls the watcher's Run(), a panic is recovered and reported as a
+// failure so the watcher is restarted.
+func runSupervised(ctx context.Context, watcher Watcher, evType string) (renew bool, data interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Watcher(%s) panicked for event %q: %v\n%s", watcher.ID(), evType, r, debug.Stack())
+			renew, data, err = false, nil, fmt.Errorf("watcher %s panicked: %v", watcher.ID(), r)
+		}
+	}()
+	return watcher.Run(ctx, evType)
+}
+
+// shouldRestart returns true if a watcher returning renew and err failed and must
+// be restarted. Watchers giving up without an error, or because the event type is
+// not supported (errors.ErrUnsupported), are done for good.
+func shouldRestart(renew bool, err error) bool {
+	return !renew && err != nil && !errors.Is(err, errors.ErrUnsupported)
+}
+
+// failed records a failure of evType, reporting it as degraded once it failed
+// degradedThreshold consecutive times, and returns the delay before restarting it.
+func (mngr *Manager) failed(evType string, sup *supervisor, err error) time.Duration {
+	sup.failures++
+
+	if !sup.degraded && sup.failures >= degradedThreshold {
+		sup.degraded = true
+		logger.Errorf("Watcher for event %q failed %d consecutive times, marking it degraded: %v", evType, sup.failures, err)
+		if mngr.degradedHandler != nil {
+			mngr.degradedHandler(evType, true, err)
+		}
+	}
+
+	delay := restartBackoff
+	for i := 1; i < sup.failures && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRestartBackoff)
+}
+
+// recovered resets the failures of evType once it runs successfully, reporting its
+// recovery if it was degraded.
+func (mngr *Manager) recovered(evType string, sup *supervisor) {
+	sup.failures = 0
+	if !sup.degraded {
+		return
+	}
+
+	sup.degraded = false
+	logger.Infof("Watcher for event %q recovered.", evType)
+	if mngr.degradedHandler != nil {
+		mngr.degradedHandler(evType, false, nil)
+	}
+}
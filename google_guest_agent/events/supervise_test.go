@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// failingWatcher fails its first runs, by panicking or returning an error, before
+// producing its events.
+type failingWatcher struct {
+	// failures are the failures of the first runs, a nil error panics.
+	failures []error
+	// runs is the number of runs so far.
+	runs int
+}
+
+func (fw *failingWatcher) ID() string {
+	return "failing-watcher"
+}
+
+func (fw *failingWatcher) Events() []string {
+	return []string{"failing-watcher,test-event"}
+}
+
+func (fw *failingWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	fw.runs++
+	if len(fw.failures) > 0 {
+		err := fw.failures[0]
+		fw.failures = fw.failures[1:]
+		if err == nil {
+			panic("watcher failed")
+		}
+		return false, nil, err
+	}
+	return true, fw.runs, nil
+}
+
+func TestWatcherRestart(t *testing.T) {
+	oldBackoff, oldThreshold := restartBackoff, degradedThreshold
+	restartBackoff, degradedThreshold = time.Millisecond, 2
+	t.Cleanup(func() { restartBackoff, degradedThreshold = oldBackoff, oldThreshold })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventManager := newManager()
+	watcher := &failingWatcher{failures: []error{nil, fmt.Errorf("watcher failed")}}
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	type report struct {
+		degraded bool
+		failed   bool
+	}
+	var reports []report
+	eventManager.SetDegradedHandler(func(evType string, degraded bool, err error) {
+		reports = append(reports, report{degraded: degraded, failed: err != nil})
+	})
+
+	var got interface{}
+	eventManager.Subscribe("failing-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		if evData.Error != nil || got != nil {
+			return true
+		}
+		got = evData.Data
+		cancel()
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if got != 3 {
+		t.Errorf("Subscriber got event %v, want the event of the 3rd run", got)
+	}
+
+	want := []report{{degraded: true, failed: true}, {degraded: false}}
+	if diff := cmp.Diff(want, reports, cmp.AllowUnexported(report{})); diff != "" {
+		t.Errorf("Degraded handler got unexpected reports (-want +got):\n%s", diff)
+	}
+
+	if restarts := eventManager.Metrics().Watchers["failing-watcher"].Restarts; restarts != 2 {
+		t.Errorf("Metrics() watcher restarts = %d, want 2", restarts)
+	}
+}
+
+func TestWatcherUnsupportedNotRestarted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventManager := newManager()
+	watcher := &failingWatcher{failures: []error{fmt.Errorf("not supported: %w", errors.ErrUnsupported)}}
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+	eventManager.Subscribe("failing-watcher,test-event", nil, noopCallback)
+
+	// The manager leaves once its only watcher gave up.
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if watcher.runs != 1 {
+		t.Errorf("Watcher ran %d times, want 1", watcher.runs)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	mngr := newManager()
+	var sup supervisor
+
+	var got []time.Duration
+	for i := 0; i < 12; i++ {
+		got = append(got, mngr.failed("test-event", &sup, errors.New("failed")))
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		32 * time.Second, 64 * time.Second, 128 * time.Second, 256 * time.Second, maxRestartBackoff,
+		maxRestartBackoff, maxRestartBackoff}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("failed() returned unexpected backoffs (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package systemdunit implements the systemd unit state events watcher, it
+// reports the state changes, including restarts, of a set of units.
+package systemdunit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// WatcherID is the systemd unit watcher's ID.
+	WatcherID = "systemd-unit-watcher"
+	// eventPrefix prefixes the unit's name in its event type ID.
+	eventPrefix = "systemd-unit-watcher,"
+	// unitObjectPath is the D-Bus object path prefix of the systemd units.
+	unitObjectPath = "/org/freedesktop/systemd1/unit/"
+)
+
+var (
+	// busctlCommand is the command monitoring the D-Bus signals, overridden in
+	// unit tests.
+	busctlCommand = "busctl"
+	// pollInterval is how often the units state is checked when the D-Bus signals
+	// can't be monitored, or no signal was received.
+	pollInterval = time.Minute
+)
+
+// State is the event data of a unit state change.
+type State struct {
+	// Unit is the unit's name, i.e. sshd.service.
+	Unit string
+	// ActiveState is the unit's active state, i.e. active or failed.
+	ActiveState string
+	// SubState is the unit's type specific state, i.e. running or dead.
+	SubState string
+	// InvocationID identifies the unit's run, it changes whenever the unit is
+	// (re)started.
+	InvocationID string
+	// MainPID is the unit's main process ID, zero if not running.
+	MainPID int
+	// Restarted is true if the unit was started again since the previous state.
+	Restarted bool
+}
+
+// Event returns the event type of the unit's state changes.
+func Event(unit string) string {
+	return eventPrefix + unitName(unit)
+}
+
+// unitName returns the unit's full name, units without a type are services.
+func unitName(unit string) string {
+	if !strings.Contains(unit, ".") {
+		return unit + ".service"
+	}
+	return unit
+}
+
+// objectPath returns the unit's D-Bus object path, systemd escapes all the
+// characters but ASCII letters and digits as _XX.
+func objectPath(unit string) string {
+	var res strings.Builder
+	res.WriteString(unitObjectPath)
+	for _, c := range []byte(unit) {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			res.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&res, "_%02x", c)
+	}
+	return res.String()
+}
+
+// queryState returns the unit's current state, units not loaded are reported as
+// inactive.
+func queryState(ctx context.Context, unit string) (*State, error) {
+	res := run.WithOutput(ctx, "systemctl", "show", "--property=ActiveState,SubState,InvocationID,MainPID", unit)
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("failed to query unit %s state: %w", unit, res)
+	}
+	return parseState(unit, res.StdOut), nil
+}
+
+// parseState parses the output of systemctl show.
+func parseState(unit string, output string) *State {
+	state := &State{Unit: unit}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "ActiveState":
+			state.ActiveState = value
+		case "SubState":
+			state.SubState = value
+		case "InvocationID":
+			state.InvocationID = value
+		case "MainPID":
+			state.MainPID, _ = strconv.Atoi(value)
+		}
+	}
+	return state
+}
+
+// monitor relays the unit's PropertiesChanged D-Bus signals.
+type monitor struct {
+	// cancel stops busctl.
+	cancel context.CancelFunc
+	// signals receives a value whenever the unit's properties changed, it's
+	// buffered so bursts of signals are merged.
+	signals chan struct{}
+	// done is closed once busctl exited.
+	done chan struct{}
+}
+
+// startMonitor starts monitoring the unit's PropertiesChanged signals.
+func startMonitor(unit string) (*monitor, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	match := fmt.Sprintf("type='signal',sender='org.freedesktop.systemd1',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='%s'", objectPath(unit))
+	cmd := exec.CommandContext(ctx, busctlCommand, "--system", "monitor", "--match", match)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to get busctl's output: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start busctl: %w", err)
+	}
+
+	m := &monitor{
+		cancel:  cancel,
+		signals: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(m.done)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if !strings.Contains(scanner.Text(), "PropertiesChanged") {
+				continue
+			}
+			select {
+			case m.signals <- struct{}{}:
+			default:
+			}
+		}
+		cmd.Wait()
+	}()
+
+	return m, nil
+}
+
+// stop stops busctl and waits for it to exit.
+func (m *monitor) stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Watcher is the systemd unit event watcher implementation.
+type Watcher struct {
+	// units maps the event types to their unit.
+	units map[string]string
+	// events are the event types in the units order.
+	events []string
+	// mutex protects monitors and last, each event type is run in its own go routine.
+	mutex sync.Mutex
+	// monitors maps the event types to their D-Bus signals monitor, nil if the
+	// signals can't be monitored.
+	monitors map[string]*monitor
+	// last maps the event types to their last reported state.
+	last map[string]*State
+}
+
+// New allocates and initializes a new Watcher reporting the state changes of
+// units, i.e. sshd or chronyd.service.
+func New(units ...string) *Watcher {
+	w := &Watcher{
+		units:    make(map[string]string),
+		monitors: make(map[string]*monitor),
+		last:     make(map[string]*State),
+	}
+
+	for _, curr := range units {
+		evType := Event(curr)
+		w.units[evType] = unitName(curr)
+		w.events = append(w.events, evType)
+	}
+
+	return w
+}
+
+// ID returns the systemd unit event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return w.events
+}
+
+// monitor returns evType's monitor, starting it on first use. It returns nil if
+// the signals can't be monitored, the state is polled then.
+func (w *Watcher) monitor(evType string) *monitor {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	m, found := w.monitors[evType]
+	if found {
+		select {
+		case <-m.done:
+			// busctl exited, start it again.
+		default:
+			return m
+		}
+	}
+
+	m, err := startMonitor(w.units[evType])
+	if err != nil {
+		logger.Debugf("Failed to monitor unit %s signals, polling instead: %v", w.units[evType], err)
+		m = nil
+	}
+	w.monitors[evType] = m
+	return m
+}
+
+// forget stops and forgets evType's monitor.
+func (w *Watcher) forget(evType string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if m := w.monitors[evType]; m != nil {
+		m.stop()
+	}
+	delete(w.monitors, evType)
+}
+
+// changed records curr as evType's state and returns true if it differs from
+// the previously recorded one, the first state is only recorded.
+func (w *Watcher) changed(evType string, curr *State) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	last, found := w.last[evType]
+	w.last[evType] = curr
+	if !found {
+		return false
+	}
+
+	curr.Restarted = curr.InvocationID != "" && curr.InvocationID != last.InvocationID
+	return curr.Restarted || curr.ActiveState != last.ActiveState || curr.SubState != last.SubState
+}
+
+// Run waits for a state change of evType's unit and report back the new state,
+// the state is checked whenever the unit's D-Bus properties change and, as a
+// fallback, every pollInterval.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	unit, found := w.units[evType]
+	if !found {
+		return false, nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+
+	// Monitor the signals before querying the state so changes in between aren't
+	// missed.
+	m := w.monitor(evType)
+
+	for {
+		state, err := queryState(ctx, unit)
+		if err != nil && ctx.Err() == nil {
+			logger.Debugf("Failed to query unit %s state: %v", unit, err)
+		}
+		if err == nil && w.changed(evType, state) {
+			return true, state, nil
+		}
+
+		var signals <-chan struct{}
+		if m != nil {
+			signals = m.signals
+		}
+
+		select {
+		case <-ctx.Done():
+			w.forget(evType)
+			return false, nil, ctx.Err()
+		case <-signals:
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemdunit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/google/go-cmp/cmp"
+)
+
+// systemctlMockRunner replies to systemctl show with the current state.
+type systemctlMockRunner struct {
+	mutex sync.Mutex
+	state string
+}
+
+func (r *systemctlMockRunner) setState(state string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state = state
+}
+
+func (r *systemctlMockRunner) Quiet(ctx context.Context, name string, args ...string) error {
+	return nil
+}
+
+func (r *systemctlMockRunner) WithOutput(ctx context.Context, name string, args ...string) *run.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if name != "systemctl" || len(args) == 0 || args[0] != "show" {
+		return &run.Result{ExitCode: 1, StdErr: "unexpected command"}
+	}
+	return &run.Result{StdOut: r.state}
+}
+
+func (r *systemctlMockRunner) WithOutputTimeout(ctx context.Context, timeout time.Duration, name string, args ...string) *run.Result {
+	return r.WithOutput(ctx, name, args...)
+}
+
+func (r *systemctlMockRunner) WithCombinedOutput(ctx context.Context, name string, args ...string) *run.Result {
+	return r.WithOutput(ctx, name, args...)
+}
+
+func TestEvent(t *testing.T) {
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{unit: "sshd", want: "systemd-unit-watcher,sshd.service"},
+		{unit: "chronyd.service", want: "systemd-unit-watcher,chronyd.service"},
+		{unit: "google-guest-agent.socket", want: "systemd-unit-watcher,google-guest-agent.socket"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.unit, func(t *testing.T) {
+			if got := Event(tc.unit); got != tc.want {
+				t.Errorf("Event(%q) = %q, want: %q", tc.unit, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	if got, want := objectPath("systemd-networkd.service"), "/org/freedesktop/systemd1/unit/systemd_2dnetworkd_2eservice"; got != want {
+		t.Errorf("objectPath() = %q, want: %q", got, want)
+	}
+}
+
+func TestParseState(t *testing.T) {
+	output := "ActiveState=active\nSubState=running\nInvocationID=0123abcd\nMainPID=812\n"
+
+	want := &State{
+		Unit:         "sshd.service",
+		ActiveState:  "active",
+		SubState:     "running",
+		InvocationID: "0123abcd",
+		MainPID:      812,
+	}
+	if diff := cmp.Diff(want, parseState("sshd.service", output)); diff != "" {
+		t.Errorf("parseState() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRun(t *testing.T) {
+	runner := &systemctlMockRunner{state: "ActiveState=active\nSubState=running\nInvocationID=aaaa\nMainPID=812\n"}
+	run.Client = runner
+	t.Cleanup(func() { run.Client = &run.Runner{} })
+
+	// busctl reports a single signal and exits, the state is polled afterwards.
+	dir := t.TempDir()
+	script := filepath.Join(dir, "busctl")
+	content := "#!/bin/sh\nexec echo 'Type=signal Member=PropertiesChanged'\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write fake busctl: %v", err)
+	}
+	oldCommand, oldPollInterval := busctlCommand, pollInterval
+	t.Cleanup(func() { busctlCommand, pollInterval = oldCommand, oldPollInterval })
+	busctlCommand, pollInterval = script, 50*time.Millisecond
+
+	w := New("sshd")
+	evType := Event("sshd")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		renew bool
+		data  interface{}
+		err   error
+	}
+	results := make(chan result)
+	go func() {
+		renew, data, err := w.Run(ctx, evType)
+		results <- result{renew, data, err}
+	}()
+
+	// Let the first state be recorded before sshd is restarted.
+	time.Sleep(100 * time.Millisecond)
+	runner.setState("ActiveState=active\nSubState=running\nInvocationID=bbbb\nMainPID=900\n")
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Run() failed unexpectedly: %v", res.err)
+	}
+	if !res.renew {
+		t.Errorf("Run() returned renew: false, want: true")
+	}
+
+	want := &State{
+		Unit:         "sshd.service",
+		ActiveState:  "active",
+		SubState:     "running",
+		InvocationID: "bbbb",
+		MainPID:      900,
+		Restarted:    true,
+	}
+	if diff := cmp.Diff(want, res.data); diff != "" {
+		t.Errorf("Run() returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	cancel()
+	if renew, _, err := w.Run(ctx, evType); renew || err == nil {
+		t.Errorf("Run() = (%t, %v) after context canceled, want: (false, context error)", renew, err)
+	}
+}
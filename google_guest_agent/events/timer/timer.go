@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package timer implements the timer events watcher, it emits periodic tick
+// events so the periodic work is subscribed to like any other agent event.
+package timer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// WatcherID is the timer watcher's ID.
+	WatcherID = "timer-watcher"
+	// eventPrefix prefixes the timer's name in its event type ID.
+	eventPrefix = "timer-watcher,"
+)
+
+// Timer defines a periodic tick event.
+type Timer struct {
+	// Name identifies the timer, it's the suffix of the timer's event type.
+	Name string
+	// Interval is the time between two ticks, it must be positive.
+	Interval time.Duration
+}
+
+// Tick is the event data of a timer tick.
+type Tick struct {
+	// Name is the name of the timer that ticked.
+	Name string
+	// Time is the time the tick was scheduled for.
+	Time time.Time
+	// Count is the number of ticks of the timer so far, starting at 1.
+	Count uint64
+}
+
+// Event returns the event type of the timer name's ticks.
+func Event(name string) string {
+	return eventPrefix + name
+}
+
+// schedule is the state of a timer across runs.
+type schedule struct {
+	// next is the time of the next tick.
+	next time.Time
+	// count is the number of ticks so far.
+	count uint64
+}
+
+// Watcher is the timer event watcher implementation.
+type Watcher struct {
+	// timers maps the event types to their timers.
+	timers map[string]Timer
+	// events are the event types in the timers order.
+	events []string
+	// schedulesMutex protects schedules, each event type is run in its own go
+	// routine.
+	schedulesMutex sync.Mutex
+	// schedules maps the event types to their schedule.
+	schedules map[string]*schedule
+}
+
+// New allocates and initializes a new Watcher emitting the timers ticks, the first
+// tick of a timer is emitted one interval after the watcher is run.
+func New(timers ...Timer) *Watcher {
+	w := &Watcher{
+		timers:    make(map[string]Timer),
+		schedules: make(map[string]*schedule),
+	}
+
+	for _, curr := range timers {
+		evType := Event(curr.Name)
+		w.timers[evType] = curr
+		w.events = append(w.events, evType)
+	}
+
+	return w
+}
+
+// ID returns the timer event watcher id.
+func (w *Watcher) ID() string {
+	return WatcherID
+}
+
+// Events returns an slice with all implemented events.
+func (w *Watcher) Events() []string {
+	return w.events
+}
+
+// schedule returns evType's schedule, scheduling its first tick on first use.
+func (w *Watcher) schedule(evType string, timer Timer) *schedule {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+
+	if sched, found := w.schedules[evType]; found {
+		return sched
+	}
+
+	sched := &schedule{next: time.Now().Add(timer.Interval)}
+	w.schedules[evType] = sched
+	return sched
+}
+
+// Run waits for evType's timer next tick and report it back. Ticks are scheduled
+// relative to the previous one so they don't drift with the time spent handling
+// them, ticks missed while the previous one was handled are skipped.
+func (w *Watcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	timer, found := w.timers[evType]
+	if !found {
+		return false, nil, fmt.Errorf("unknown event type %q: %w", evType, errors.ErrUnsupported)
+	}
+	if timer.Interval <= 0 {
+		return false, nil, fmt.Errorf("timer %q has invalid interval %s: %w", timer.Name, timer.Interval, errors.ErrUnsupported)
+	}
+
+	sched := w.schedule(evType, timer)
+
+	wait := time.NewTimer(time.Until(sched.next))
+	defer wait.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	case <-wait.C:
+	}
+
+	sched.count++
+	tick := &Tick{Name: timer.Name, Time: sched.next, Count: sched.count}
+
+	sched.next = sched.next.Add(timer.Interval)
+	if now := time.Now(); sched.next.Before(now) {
+		missed := now.Sub(sched.next)/timer.Interval + 1
+		sched.next = sched.next.Add(missed * timer.Interval)
+	}
+
+	return true, tick, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interval := 20 * time.Millisecond
+	w := New(Timer{Name: "verify", Interval: interval})
+
+	if got := w.Events(); len(got) != 1 || got[0] != Event("verify") {
+		t.Fatalf("Events() = %v, want [%s]", got, Event("verify"))
+	}
+
+	start := time.Now()
+	var last time.Time
+	for i := uint64(1); i <= 3; i++ {
+		renew, data, err := w.Run(ctx, Event("verify"))
+		if err != nil || !renew {
+			t.Fatalf("Run() = (%t, %v, %v), want (true, tick, nil)", renew, data, err)
+		}
+
+		tick := data.(*Tick)
+		if tick.Name != "verify" || tick.Count != i {
+			t.Errorf("Run() = %+v, want tick %d of verify", tick, i)
+		}
+		if !tick.Time.After(last) {
+			t.Errorf("Run() tick time %s is not after the previous tick %s", tick.Time, last)
+		}
+		last = tick.Time
+	}
+
+	if elapsed := time.Since(start); elapsed < 3*interval {
+		t.Errorf("3 ticks took %s, want at least %s", elapsed, 3*interval)
+	}
+}
+
+func TestRunSkipsMissedTicks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interval := 10 * time.Millisecond
+	w := New(Timer{Name: "verify", Interval: interval})
+
+	if _, _, err := w.Run(ctx, Event("verify")); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	// Handling the tick takes several intervals.
+	time.Sleep(5 * interval)
+
+	_, data, err := w.Run(ctx, Event("verify"))
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if tick := data.(*Tick); tick.Count != 2 {
+		t.Errorf("Run() = %+v, want count 2", tick)
+	}
+
+	sched := w.schedule(Event("verify"), Timer{})
+	if !sched.next.After(time.Now()) {
+		t.Errorf("next tick %s is in the past, want the missed ticks skipped", sched.next)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := New(Timer{Name: "invalid"}, Timer{Name: "valid", Interval: time.Hour})
+
+	tests := []struct {
+		name   string
+		evType string
+	}{
+		{name: "unknown-event", evType: Event("unknown")},
+		{name: "invalid-interval", evType: Event("invalid")},
+		{name: "context-canceled", evType: Event("valid")},
+	}
+
+	cancel()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			renew, _, err := w.Run(ctx, tc.evType)
+			if err == nil || renew {
+				t.Errorf("Run(%s) = (%t, %v), want (false, error)", tc.evType, renew, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// watchdogFatalf is called when the event dispatching is stalled, it's expected
+	// to exit the process so the service manager restarts the agent. Overridden in
+	// unit tests.
+	watchdogFatalf = logger.Fatalf
+)
+
+// watchdog detects watchers and event dispatching that have made no progress for
+// longer than the configured timeout.
+type watchdog struct {
+	// mutex protects all the watchdog's members.
+	mutex sync.Mutex
+
+	// timeout is the maximum period without progress, zero means the watchdog is disabled.
+	timeout time.Duration
+
+	// evTypes is the set of watched event types, only watchers expected to return
+	// periodically (i.e. longpolling the metadata server) should be watched.
+	evTypes map[string]bool
+
+	// watchers maps the watched event types to their liveness.
+	watchers map[string]*watchdogEntry
+
+	// dispatchStart is the time the event currently being dispatched started, it's
+	// zero if no event is being dispatched.
+	dispatchStart time.Time
+
+	// dispatchEvType is the event type currently being dispatched.
+	dispatchEvType string
+
+	// paused is set while the event manager is paused, no progress is expected.
+	paused bool
+}
+
+// watchdogEntry tracks the liveness of a watcher's event type.
+type watchdogEntry struct {
+	// lastProgress is the last time the watcher started or returned from a Run() call.
+	lastProgress time.Time
+	// cancel cancels the watcher's current Run() call, forcing it to be renewed.
+	cancel context.CancelFunc
+}
+
+// EnableWatchdog enables the watchdog for the provided event types and for event
+// dispatching. A watcher making no progress for longer than timeout has its current
+// run canceled and renewed, a stalled event dispatch causes the agent to exit so
+// the service manager can restart it. It must be called before Run().
+func (mngr *Manager) EnableWatchdog(timeout time.Duration, evTypes ...string) {
+	wd := mngr.watchdog
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	wd.timeout = timeout
+	for _, curr := range evTypes {
+		wd.evTypes[curr] = true
+	}
+}
+
+// newWatchdog allocates and initializes a disabled watchdog.
+func newWatchdog() *watchdog {
+	return &watchdog{
+		evTypes:  make(map[string]bool),
+		watchers: make(map[string]*watchdogEntry),
+	}
+}
+
+// enabled returns true if the watchdog was enabled.
+func (wd *watchdog) enabled() bool {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	return wd.timeout > 0
+}
+
+// watcherProgress records the progress of evType's watcher, cancel cancels
+// the watcher's current run.
+func (wd *watchdog) watcherProgress(evType string, cancel context.CancelFunc) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	if !wd.evTypes[evType] {
+		return
+	}
+	wd.watchers[evType] = &watchdogEntry{lastProgress: time.Now(), cancel: cancel}
+}
+
+// watcherDone stops tracking evType's watcher.
+func (wd *watchdog) watcherDone(evType string) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	delete(wd.watchers, evType)
+}
+
+// dispatching records that evType is being dispatched to its subscribers.
+func (wd *watchdog) dispatching(evType string) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	wd.dispatchStart = time.Now()
+	wd.dispatchEvType = evType
+}
+
+// dispatched records that the event being dispatched was handled by all its subscribers.
+func (wd *watchdog) dispatched() {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	wd.dispatchStart = time.Time{}
+	wd.dispatchEvType = ""
+}
+
+// pause stops the liveness checks while the event manager is paused.
+func (wd *watchdog) pause() {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	wd.paused = true
+}
+
+// resume resumes the liveness checks, the watchers get a full period to make
+// progress since they were held while paused.
+func (wd *watchdog) resume() {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	wd.paused = false
+	now := time.Now()
+	for _, curr := range wd.watchers {
+		curr.lastProgress = now
+	}
+}
+
+// check verifies the watchers and event dispatching liveness, it renews stalled
+// watchers and returns true if the event dispatching is stalled.
+func (wd *watchdog) check(now time.Time) bool {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	if wd.paused {
+		return false
+	}
+
+	for evType, curr := range wd.watchers {
+		if now.Sub(curr.lastProgress) < wd.timeout {
+			continue
+		}
+
+		logger.Errorf("Watchdog: watcher for event %q made no progress for %s, restarting it.", evType, now.Sub(curr.lastProgress))
+		dumpDiagnostics()
+		curr.cancel()
+		// Give the watcher another full period to recover.
+		curr.lastProgress = now
+	}
+
+	if wd.dispatchStart.IsZero() || now.Sub(wd.dispatchStart) < wd.timeout {
+		return false
+	}
+
+	logger.Errorf("Watchdog: dispatching of event %q made no progress for %s.", wd.dispatchEvType, now.Sub(wd.dispatchStart))
+	dumpDiagnostics()
+	return true
+}
+
+// run checks the liveness periodically until ctx is done or the manager is leaving.
+func (wd *watchdog) run(ctx context.Context, done <-chan struct{}) {
+	wd.mutex.Lock()
+	interval := wd.timeout / 4
+	wd.mutex.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if wd.check(now) {
+				// Event callbacks can't be interrupted, the only way out is restarting
+				// the whole agent.
+				watchdogFatalf("Watchdog: event dispatching is stalled, exiting so the agent is restarted.")
+			}
+		}
+	}
+}
+
+// dumpDiagnostics logs the stack traces of all running go routines.
+func dumpDiagnostics() {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		logger.Errorf("Watchdog: failed to dump go routines: %v", err)
+		return
+	}
+	logger.Errorf("Watchdog: go routines dump:\n%s", buf.String())
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stallingWatcher is a watcher whose first run blocks until its context is canceled.
+type stallingWatcher struct {
+	counter  int
+	maxCount int
+}
+
+func (sw *stallingWatcher) ID() string {
+	return "stalling-watcher"
+}
+
+func (sw *stallingWatcher) Events() []string {
+	return []string{"stalling-watcher,test-event"}
+}
+
+func (sw *stallingWatcher) Run(ctx context.Context, evType string) (bool, interface{}, error) {
+	sw.counter++
+	if sw.counter == 1 {
+		<-ctx.Done()
+		return true, nil, ctx.Err()
+	}
+	return sw.counter < sw.maxCount, nil, nil
+}
+
+func TestWatchdogRenewsStalledWatcher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	eventManager := newManager()
+	eventManager.EnableWatchdog(100*time.Millisecond, "stalling-watcher,test-event")
+
+	watcher := &stallingWatcher{maxCount: 3}
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		t.Fatalf("Failed to add watcher to event manager: %+v", err)
+	}
+
+	var events int
+	eventManager.Subscribe("stalling-watcher,test-event", nil, func(ctx context.Context, evType string, data interface{}, evData *EventData) bool {
+		events++
+		return true
+	})
+
+	if err := eventManager.Run(ctx); err != nil {
+		t.Fatalf("Failed to run event manager: %+v", err)
+	}
+
+	if ctx.Err() != nil {
+		t.Fatalf("Event manager only left after the test timeout, the stalled watcher wasn't renewed")
+	}
+
+	if events != watcher.maxCount {
+		t.Errorf("Got %d events, expected: %d", events, watcher.maxCount)
+	}
+}
+
+func TestWatchdogCheck(t *testing.T) {
+	timeout := time.Minute
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		lastProgress  time.Time
+		dispatchStart time.Time
+		wantCanceled  bool
+		wantStalled   bool
+	}{
+		{"all healthy", now, now, false, false},
+		{"not dispatching", now, time.Time{}, false, false},
+		{"watcher stalled", now.Add(-2 * timeout), time.Time{}, true, false},
+		{"dispatch stalled", now, now.Add(-2 * timeout), false, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wd := newWatchdog()
+			wd.timeout = timeout
+
+			var canceled bool
+			wd.watchers["test-event"] = &watchdogEntry{
+				lastProgress: tc.lastProgress,
+				cancel:       func() { canceled = true },
+			}
+			wd.dispatchStart = tc.dispatchStart
+
+			if got := wd.check(now); got != tc.wantStalled {
+				t.Errorf("check() = %t, want: %t", got, tc.wantStalled)
+			}
+
+			if canceled != tc.wantCanceled {
+				t.Errorf("check() canceled watcher: %t, want: %t", canceled, tc.wantCanceled)
+			}
+		})
+	}
+}
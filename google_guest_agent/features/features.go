@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package features implements the guest agent's feature flags. A feature flag
+// gates a new (and potentially risky) agent behavior so it can be gradually
+// rolled out across a fleet and turned off again without a new release.
+//
+// A flag's value is resolved with the following precedence:
+//   - The [Features] section of the instance configuration file.
+//   - The instance's guest-agent-features metadata attribute.
+//   - The project's guest-agent-features metadata attribute.
+//   - The flag's registered default value.
+//
+// Values are either a boolean (true, false, 1, 0 etc) or a rollout percentage
+// such as "25%". A percentage enables the flag for a stable subset of the
+// instances, selected by hashing the flag name together with the instance ID.
+package features
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// defaults maps the registered flags to their default values.
+	defaults = make(map[string]bool)
+
+	// defaultsMutex protects the defaults map.
+	defaultsMutex sync.RWMutex
+)
+
+// Register registers a feature flag and its default value, the default value is
+// used when neither the configuration file nor metadata define the flag.
+func Register(name string, defaultValue bool) {
+	defaultsMutex.Lock()
+	defer defaultsMutex.Unlock()
+	defaults[strings.ToLower(name)] = defaultValue
+}
+
+// parseAttribute parses the guest-agent-features metadata attribute. The attribute
+// is a comma or new line separated list of name=value pairs, i.e.:
+//
+//	network-netlink=true,dns-manager=10%
+func parseAttribute(attr string) map[string]string {
+	res := make(map[string]string)
+
+	fields := strings.FieldsFunc(attr, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+
+	for _, curr := range fields {
+		name, value, found := strings.Cut(curr, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !found || name == "" {
+			logger.Debugf("Ignoring malformed feature flag entry: %q", curr)
+			continue
+		}
+		res[name] = strings.TrimSpace(value)
+	}
+
+	return res
+}
+
+// parseValue parses a flag value, it returns whether the flag is enabled for the
+// instance identified by instanceID.
+func parseValue(name, value, instanceID string) (bool, error) {
+	if pct, found := strings.CutSuffix(value, "%"); found {
+		percentage, err := strconv.Atoi(strings.TrimSpace(pct))
+		if err != nil || percentage < 0 || percentage > 100 {
+			return false, fmt.Errorf("invalid rollout percentage: %q", value)
+		}
+		return inRollout(name, instanceID, percentage), nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid flag value: %q", value)
+	}
+	return enabled, nil
+}
+
+// inRollout determines if the instance falls within the percentage of the fleet
+// the flag name is rolled out to. The bucket is stable for a given pair of flag
+// and instance, and different flags select different subsets of the fleet.
+func inRollout(name, instanceID string, percentage int) bool {
+	if percentage <= 0 {
+		return false
+	}
+	if percentage >= 100 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + instanceID))
+	return int(h.Sum32()%100) < percentage
+}
+
+// lookup returns the value of the flag name, following the precedence described
+// in the package documentation. The returned bool is false if the flag is not
+// defined by any source.
+func lookup(config *cfg.Sections, md *metadata.Descriptor, name string) (string, bool) {
+	if value, found := config.Features[name]; found {
+		return value, true
+	}
+
+	if md == nil {
+		return "", false
+	}
+
+	for _, attr := range []string{md.Instance.Attributes.GuestAgentFeatures, md.Project.Attributes.GuestAgentFeatures} {
+		if value, found := parseAttribute(attr)[name]; found {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// Enabled returns true if the feature flag name is enabled for the instance
+// described by md, md may be nil in which case only local configuration and
+// defaults are considered.
+func Enabled(md *metadata.Descriptor, name string) bool {
+	name = strings.ToLower(name)
+
+	defaultsMutex.RLock()
+	defaultValue := defaults[name]
+	defaultsMutex.RUnlock()
+
+	value, found := lookup(cfg.Get(), md, name)
+	if !found {
+		return defaultValue
+	}
+
+	var instanceID string
+	if md != nil {
+		instanceID = md.Instance.ID.String()
+	}
+
+	enabled, err := parseValue(name, value, instanceID)
+	if err != nil {
+		logger.Errorf("Feature flag %q: %v, falling back to default: %t", name, err, defaultValue)
+		return defaultValue
+	}
+
+	return enabled
+}
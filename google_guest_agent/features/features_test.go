@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package features
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAttribute(t *testing.T) {
+	tests := []struct {
+		name string
+		attr string
+		want map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"single", "foo=true", map[string]string{"foo": "true"}},
+		{"comma separated", "foo=true, Bar=10%", map[string]string{"foo": "true", "bar": "10%"}},
+		{"new line separated", "foo=true\nbar=false", map[string]string{"foo": "true", "bar": "false"}},
+		{"malformed", "foo,=true,bar=1", map[string]string{"bar": "1"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, parseAttribute(tc.attr)); diff != "" {
+				t.Errorf("parseAttribute(%q) returned unexpected diff (-want +got):\n%s", tc.attr, diff)
+			}
+		})
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"true", true, false},
+		{"1", true, false},
+		{"false", false, false},
+		{"0%", false, false},
+		{"100%", true, false},
+		{"bogus", false, true},
+		{"101%", false, true},
+		{"-1%", false, true},
+		{"a%", false, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseValue("flag", tc.value, "1234")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseValue(%q) returned error: %v, want error: %t", tc.value, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseValue(%q) = %t, want: %t", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInRollout(t *testing.T) {
+	var enabled int
+	total := 10000
+
+	for i := 0; i < total; i++ {
+		id := fmt.Sprintf("%d", i)
+		got := inRollout("flag", id, 25)
+		if got != inRollout("flag", id, 25) {
+			t.Fatalf("inRollout(flag, %s, 25) is not stable", id)
+		}
+		if got {
+			enabled++
+		}
+	}
+
+	// Allow some slack, we only care that the distribution is roughly right.
+	if enabled < total*20/100 || enabled > total*30/100 {
+		t.Errorf("inRollout(flag, *, 25) enabled %d of %d instances, want roughly 25%%", enabled, total)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	mkmd := func(instance, project string) *metadata.Descriptor {
+		md := &metadata.Descriptor{}
+		md.Instance.ID = json.Number("1234")
+		md.Instance.Attributes.GuestAgentFeatures = instance
+		md.Project.Attributes.GuestAgentFeatures = project
+		return md
+	}
+
+	Register("default-on", true)
+
+	tests := []struct {
+		name   string
+		config string
+		md     *metadata.Descriptor
+		flag   string
+		want   bool
+	}{
+		{"unknown flag", "", mkmd("", ""), "unknown", false},
+		{"registered default", "", nil, "default-on", true},
+		{"config only", "[Features]\nfoo = true", nil, "foo", true},
+		{"config overrides metadata", "[Features]\nfoo = false", mkmd("foo=true", "foo=true"), "foo", false},
+		{"instance overrides project", "", mkmd("foo=true", "foo=false"), "foo", true},
+		{"project only", "", mkmd("", "foo=true"), "foo", true},
+		{"metadata overrides default", "", mkmd("default-on=false", ""), "default-on", false},
+		{"invalid value falls back to default", "", mkmd("default-on=bogus", ""), "default-on", true},
+		{"case insensitive", "", mkmd("FOO=true", ""), "Foo", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := cfg.Load([]byte(tc.config)); err != nil {
+				t.Fatalf("cfg.Load() failed: %v", err)
+			}
+
+			if got := Enabled(tc.md, tc.flag); got != tc.want {
+				t.Errorf("Enabled(%q) = %t, want: %t", tc.flag, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// firewallMu protects firewallIPs and firewallSet.
+	firewallMu sync.Mutex
+	// firewallIPs are the forwarded IPs the firewall rules were last set up for.
+	firewallIPs []string
+	// firewallSet is set once the firewall rules were set up, the rules left by a
+	// previous run are removed the first time if the feature was disabled since.
+	firewallSet bool
+)
+
+// applyForwardedIPsFirewall allows the traffic to the forwarded IPs of md in the host
+// firewall if enabled, the rules are removed otherwise. The rules are only updated
+// when the forwarded IPs change.
+func (a *addressMgr) applyForwardedIPsFirewall(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) {
+	var ips []string
+	if config.NetworkInterfaces.IPForwarding && config.IPForwarding.FirewallRules {
+		ips = firewallForwardedIPs(config, a.forwardedInterfaces(config, md))
+	}
+
+	firewallMu.Lock()
+	defer firewallMu.Unlock()
+
+	if firewallSet && slices.Equal(firewallIPs, ips) {
+		return
+	}
+	// The firewall isn't touched unless the agent set up rules before.
+	if len(ips) == 0 && !owned.hasFirewallRules() {
+		firewallIPs, firewallSet = nil, true
+		return
+	}
+
+	if len(ips) > 0 {
+		logger.Infof("Allowing the traffic to the forwarded IPs %q in the firewall.", ips)
+	} else {
+		logger.Infof("Removing the forwarded IPs firewall rules.")
+	}
+	if err := setFirewallRules(ctx, ips); err != nil {
+		logger.Errorf("Failed to set up the forwarded IPs firewall rules: %v", err)
+		return
+	}
+	owned.recordFirewallRules(len(ips) > 0)
+	firewallIPs, firewallSet = ips, true
+}
+
+// firewallForwardedIPs returns the sorted forwarded IPs of nics, in CIDR notation.
+func firewallForwardedIPs(config *cfg.Sections, nics []metadata.NetworkInterfaces) []string {
+	var ips []string
+	for _, ni := range nics {
+		for _, ip := range wantedForwardedIPs(config, ni) {
+			ip = hostPrefix(ip)
+			if !slices.Contains(ips, ip) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	slices.Sort(ips)
+	return ips
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+)
+
+// firewallChain is the iptables chain allowing the traffic to the forwarded IPs,
+// jumped to from the INPUT chain.
+const firewallChain = "GCE-FORWARDED-IPS"
+
+// setFirewallRules allows the traffic to ips, IPv4 with iptables and IPv6 with
+// ip6tables, the chain is removed if ips is empty.
+func setFirewallRules(ctx context.Context, ips []string) error {
+	var ipv4s, ipv6s []string
+	for _, ip := range ips {
+		if strings.Contains(ip, ":") {
+			ipv6s = append(ipv6s, ip)
+		} else {
+			ipv4s = append(ipv4s, ip)
+		}
+	}
+
+	if err := setFirewallChain(ctx, "iptables", ipv4s); err != nil {
+		return err
+	}
+	return setFirewallChain(ctx, "ip6tables", ipv6s)
+}
+
+// setFirewallChain sets the rules of the firewall chain of cmd, either iptables or
+// ip6tables, to accept the traffic to ips.
+func setFirewallChain(ctx context.Context, cmd string, ips []string) error {
+	if _, err := exec.LookPath(cmd); err != nil {
+		if len(ips) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%s not found: %w", cmd, err)
+	}
+
+	res := run.WithOutput(ctx, cmd, "-w", "-S", firewallChain)
+	exists := res.ExitCode == 0
+
+	if len(ips) == 0 {
+		if !exists {
+			return nil
+		}
+		// The jump is missing if removed by an administrator.
+		run.Quiet(ctx, cmd, "-w", "-D", "INPUT", "-j", firewallChain)
+		if err := run.Quiet(ctx, cmd, "-w", "-F", firewallChain); err != nil {
+			return fmt.Errorf("failed to flush %s chain: %w", firewallChain, err)
+		}
+		if err := run.Quiet(ctx, cmd, "-w", "-X", firewallChain); err != nil {
+			return fmt.Errorf("failed to remove %s chain: %w", firewallChain, err)
+		}
+		return nil
+	}
+
+	if !exists {
+		if err := run.Quiet(ctx, cmd, "-w", "-N", firewallChain); err != nil {
+			return fmt.Errorf("failed to create %s chain: %w", firewallChain, err)
+		}
+	}
+	if err := run.Quiet(ctx, cmd, "-w", "-C", "INPUT", "-j", firewallChain); err != nil {
+		if err := run.Quiet(ctx, cmd, "-w", "-I", "INPUT", "1", "-j", firewallChain); err != nil {
+			return fmt.Errorf("failed to jump to %s chain: %w", firewallChain, err)
+		}
+	}
+
+	if exists && slices.Equal(parseFirewallChain(res.StdOut), ips) {
+		return nil
+	}
+	if err := run.Quiet(ctx, cmd, "-w", "-F", firewallChain); err != nil {
+		return fmt.Errorf("failed to flush %s chain: %w", firewallChain, err)
+	}
+	for _, ip := range ips {
+		if err := run.Quiet(ctx, cmd, "-w", "-A", firewallChain, "-d", ip, "-j", "ACCEPT"); err != nil {
+			return fmt.Errorf("failed to allow traffic to %s: %w", ip, err)
+		}
+	}
+	return nil
+}
+
+// parseFirewallChain parses the destinations accepted by the firewall chain of the
+// "iptables -S" output, i.e. "-A GCE-FORWARDED-IPS -d 10.0.0.1/32 -j ACCEPT".
+func parseFirewallChain(out string) []string {
+	var ips []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 6 || fields[0] != "-A" || fields[1] != firewallChain || fields[2] != "-d" || fields[5] != "ACCEPT" {
+			continue
+		}
+		ips = append(ips, fields[3])
+	}
+	return ips
+}
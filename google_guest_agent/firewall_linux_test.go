@@ -0,0 +1,32 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFirewallChain(t *testing.T) {
+	out := "-N GCE-FORWARDED-IPS\n-A GCE-FORWARDED-IPS -d 10.0.0.1/32 -j ACCEPT\n-A GCE-FORWARDED-IPS -d 10.1.0.0/24 -j ACCEPT\n-A GCE-FORWARDED-IPS -s 10.2.0.1/32 -j ACCEPT\n"
+
+	want := []string{"10.0.0.1/32", "10.1.0.0/24"}
+	if diff := cmp.Diff(want, parseFirewallChain(out)); diff != "" {
+		t.Errorf("parseFirewallChain(%q) returned unexpected diff (-want +got):\n%s", out, diff)
+	}
+}
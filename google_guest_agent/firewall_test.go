@@ -0,0 +1,40 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFirewallForwardedIPs(t *testing.T) {
+	if err := cfg.Load(nil); err != nil {
+		t.Fatalf("cfg.Load(nil) = %v, want nil", err)
+	}
+
+	nics := []metadata.NetworkInterfaces{
+		{ForwardedIps: []string{"10.0.0.2", "10.0.0.1"}, IPAliases: []string{"10.1.0.0/24"}},
+		{ForwardedIps: []string{"10.0.0.1"}, ForwardedIpv6s: []string{"fd00::/96"}},
+	}
+
+	want := []string{"10.0.0.1/32", "10.0.0.2/32", "10.1.0.0/24", "fd00::/96"}
+	got := firewallForwardedIPs(cfg.Get(), nics)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("firewallForwardedIPs() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
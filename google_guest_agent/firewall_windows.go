@@ -0,0 +1,37 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+)
+
+// firewallRule is the Windows Firewall rule allowing the traffic to the forwarded
+// IPs.
+const firewallRule = "GCE-Forwarded-IPs"
+
+// setFirewallRules allows the traffic to ips with a Windows Firewall rule, replaced
+// with each change, the rule is removed if ips is empty.
+func setFirewallRules(ctx context.Context, ips []string) error {
+	// A missing rule fails the removal, it's not an error.
+	run.Quiet(ctx, "netsh", "advfirewall", "firewall", "delete", "rule", "name="+firewallRule)
+	if len(ips) == 0 {
+		return nil
+	}
+	return run.Quiet(ctx, "netsh", "advfirewall", "firewall", "add", "rule", "name="+firewallRule, "dir=in", "action=allow", "localip="+strings.Join(ips, ","))
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// hostsFileComment marks the hosts file entry managed by the agent.
+	hostsFileComment = "# Added by Google"
+)
+
+var (
+	// hostsFile is the hosts file the hostname's entry is kept in.
+	hostsFile = "/etc/hosts"
+
+	// pendingHostname is the hostname set but only applied after a reboot, Windows
+	// only, so it's not set again on every run.
+	pendingHostname string
+)
+
+// hostnameMgr sets the system's hostname from the metadata hostname and keeps
+// its hosts file entry consistent with the primary NIC's address.
+type hostnameMgr struct{}
+
+// desiredHostname returns the hostname to set and the FQDN from md's hostname,
+// both empty if md has none.
+func desiredHostname(config *cfg.Sections, md *metadata.Descriptor) (hostname, fqdn string) {
+	fqdn = strings.TrimSuffix(md.Instance.Hostname, ".")
+	if config.Hostname.UseFQDN {
+		return fqdn, fqdn
+	}
+	short, _, _ := strings.Cut(fqdn, ".")
+	return short, fqdn
+}
+
+// primaryIP returns the primary NIC's address of md, empty if unknown.
+func primaryIP(md *metadata.Descriptor) string {
+	if len(md.Instance.NetworkInterfaces) == 0 {
+		return ""
+	}
+	return md.Instance.NetworkInterfaces[0].IP
+}
+
+// updateHostsFile returns the hosts file contents with the agent's entry mapping
+// ip to the hostname and the FQDN, the entry is removed if ip is empty.
+func updateHostsFile(contents, ip, hostname, fqdn string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(contents, "\n"), "\n") {
+		if !strings.HasSuffix(line, hostsFileComment) {
+			lines = append(lines, line)
+		}
+	}
+
+	if ip != "" {
+		names := []string{fqdn}
+		if hostname != fqdn {
+			names = append(names, hostname)
+		}
+		// 10.128.0.2 instance.c.project.internal instance  # Added by Google
+		lines = append(lines, fmt.Sprintf("%s %s  %s", ip, strings.Join(names, " "), hostsFileComment))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// hostnameChanges returns the changes Set() would make to apply md.
+func hostnameChanges(config *cfg.Sections, md *metadata.Descriptor) ([]string, error) {
+	hostname, fqdn := desiredHostname(config, md)
+	if hostname == "" {
+		return nil, nil
+	}
+
+	var changes []string
+	current, err := currentHostname(config.Hostname.UseFQDN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the current hostname: %w", err)
+	}
+	if !strings.EqualFold(current, hostname) && pendingHostname != hostname {
+		changes = append(changes, fmt.Sprintf("set hostname %s", hostname))
+	}
+
+	if runtime.GOOS != "windows" && config.Hostname.HostsFile {
+		contents, err := os.ReadFile(hostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", hostsFile, err)
+		}
+		if string(contents) != updateHostsFile(string(contents), primaryIP(md), hostname, fqdn) {
+			changes = append(changes, fmt.Sprintf("update %s entry of %s", hostsFile, hostname))
+		}
+	}
+	return changes, nil
+}
+
+// Diff returns true on the first run or if the metadata hostname or the primary
+// NIC's address changed.
+func (h *hostnameMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return oldMd.Project.ProjectID == "" ||
+		oldMd.Instance.Hostname != newMd.Instance.Hostname ||
+		primaryIP(oldMd) != primaryIP(newMd), nil
+}
+
+// Timeout returns false, the hostname manager doesn't run periodically.
+func (h *hostnameMgr) Timeout(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// Disabled returns true unless enabled in the [Hostname] configuration section.
+func (h *hostnameMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	return !cfg.Get().Hostname.Enabled, nil
+}
+
+// Set sets the hostname and updates its hosts file entry. On Windows the new
+// hostname is only applied after a reboot, which is reported in the agent's status.
+func (h *hostnameMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	config := cfg.Get()
+	changes, err := hostnameChanges(config, newMd)
+	if err != nil {
+		return err
+	}
+	hostname, fqdn := desiredHostname(config, newMd)
+
+	if slices.Contains(changes, fmt.Sprintf("set hostname %s", hostname)) {
+		logger.Infof("Setting hostname to %s.", hostname)
+		rebootRequired, err := setHostname(ctx, hostname)
+		if err != nil {
+			return fmt.Errorf("failed to set hostname %s: %w", hostname, err)
+		}
+		if rebootRequired {
+			pendingHostname = hostname
+			logger.Warningf("Hostname set to %s, a reboot is required to apply it.", hostname)
+			reportSubsystemStatus(ctx, "hostname", "reboot required to apply %s", hostname)
+		}
+	}
+
+	if slices.Contains(changes, fmt.Sprintf("update %s entry of %s", hostsFile, hostname)) {
+		contents, err := os.ReadFile(hostsFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", hostsFile, err)
+		}
+		logger.Infof("Updating %s entry of %s.", hostsFile, hostname)
+		if err := writeConfigFile(hostsFile, updateHostsFile(string(contents), primaryIP(newMd), hostname, fqdn)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", hostsFile, err)
+		}
+	}
+	return nil
+}
+
+// DryRun returns the hostname and the hosts file entry Set() would change.
+func (h *hostnameMgr) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	return hostnameChanges(cfg.Get(), newMd)
+}
+
+// reapplyHostname re-applies the hostname after a DHCP lease renewal, the DHCP
+// client hooks of some distributions reset it.
+func reapplyHostname(ctx context.Context, evType string, evData *events.EventData) {
+	if evData.Error != nil {
+		logger.Debugf("DHCP lease watcher %q failed: %+v", evType, evData.Error)
+		return
+	}
+
+	m := managerByName("hostname")
+	_, md := metadataSnapshot()
+	if m == nil || md == nil {
+		return
+	}
+	m.execute(ctx, md, md, applyOnDrift)
+}
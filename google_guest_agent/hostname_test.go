@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDesiredHostname(t *testing.T) {
+	md := &metadata.Descriptor{Instance: metadata.Instance{Hostname: "instance.c.project.internal."}}
+
+	tests := []struct {
+		useFQDN      bool
+		wantHostname string
+	}{
+		{useFQDN: false, wantHostname: "instance"},
+		{useFQDN: true, wantHostname: "instance.c.project.internal"},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("use-fqdn-%t", tc.useFQDN), func(t *testing.T) {
+			config := &cfg.Sections{Hostname: &cfg.Hostname{UseFQDN: tc.useFQDN}}
+			hostname, fqdn := desiredHostname(config, md)
+			if hostname != tc.wantHostname || fqdn != "instance.c.project.internal" {
+				t.Errorf("desiredHostname() = (%q, %q), want (%q, %q)", hostname, fqdn, tc.wantHostname, "instance.c.project.internal")
+			}
+		})
+	}
+
+	if hostname, fqdn := desiredHostname(&cfg.Sections{Hostname: &cfg.Hostname{}}, &metadata.Descriptor{}); hostname != "" || fqdn != "" {
+		t.Errorf("desiredHostname() without metadata hostname = (%q, %q), want empty", hostname, fqdn)
+	}
+}
+
+func TestUpdateHostsFile(t *testing.T) {
+	base := "127.0.0.1 localhost\n::1 localhost ip6-localhost\n"
+
+	tests := []struct {
+		name     string
+		contents string
+		ip       string
+		hostname string
+		want     string
+	}{
+		{
+			name:     "add-entry",
+			contents: base,
+			ip:       "10.128.0.2",
+			hostname: "instance",
+			want:     base + "10.128.0.2 instance.c.project.internal instance  # Added by Google\n",
+		},
+		{
+			name:     "replace-entry",
+			contents: base + "10.128.0.1 old.c.project.internal old  # Added by Google\n",
+			ip:       "10.128.0.2",
+			hostname: "instance",
+			want:     base + "10.128.0.2 instance.c.project.internal instance  # Added by Google\n",
+		},
+		{
+			name:     "fqdn-hostname",
+			contents: base,
+			ip:       "10.128.0.2",
+			hostname: "instance.c.project.internal",
+			want:     base + "10.128.0.2 instance.c.project.internal  # Added by Google\n",
+		},
+		{
+			name:     "remove-entry",
+			contents: base + "10.128.0.2 instance.c.project.internal instance  # Added by Google\n",
+			hostname: "instance",
+			want:     base,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := updateHostsFile(tc.contents, tc.ip, tc.hostname, "instance.c.project.internal")
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("updateHostsFile() returned unexpected contents (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHostnameManager(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The hosts file isn't managed on Windows")
+	}
+	reloadConfig(t, []byte("[Hostname]\nenabled = true"))
+	defer reloadConfig(t, nil)
+
+	oldHostsFile := hostsFile
+	t.Cleanup(func() { hostsFile = oldHostsFile })
+	hostsFile = filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", hostsFile, err)
+	}
+
+	// Use the current hostname so only the hosts file is changed.
+	current, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("os.Hostname() failed: %v", err)
+	}
+	md := &metadata.Descriptor{Instance: metadata.Instance{
+		Hostname:          current + ".c.project.internal",
+		NetworkInterfaces: []metadata.NetworkInterfaces{{IP: "10.128.0.2"}},
+	}}
+
+	mgr := &hostnameMgr{}
+	ctx := context.Background()
+	changes, err := mgr.DryRun(ctx, md, md)
+	if err != nil {
+		t.Fatalf("DryRun() failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{fmt.Sprintf("update %s entry of %s", hostsFile, current)}, changes); diff != "" {
+		t.Errorf("DryRun() returned unexpected changes (-want +got):\n%s", diff)
+	}
+
+	if err := mgr.Set(ctx, md, md); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	got, err := os.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) failed: %v", hostsFile, err)
+	}
+	want := fmt.Sprintf("127.0.0.1 localhost\n10.128.0.2 %s.c.project.internal %s  # Added by Google\n", current, current)
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Set() wrote unexpected hosts file (-want +got):\n%s", diff)
+	}
+
+	if changes, err := mgr.DryRun(ctx, md, md); err != nil || len(changes) != 0 {
+		t.Errorf("DryRun() after Set() = (%v, %v), want no changes", changes, err)
+	}
+
+	oldMd := &metadata.Descriptor{Project: metadata.Project{ProjectID: "project"}, Instance: md.Instance}
+	if diff, _ := mgr.Diff(ctx, oldMd, md); diff {
+		t.Errorf("Diff() = true with the same hostname and address, want false")
+	}
+}
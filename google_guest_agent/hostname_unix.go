@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+)
+
+// hostnameFile is the file the hostname is persisted to if hostnamectl isn't
+// available.
+const hostnameFile = "/etc/hostname"
+
+// currentHostname returns the system's hostname.
+func currentHostname(bool) (string, error) {
+	return os.Hostname()
+}
+
+// setHostname sets and persists the system's hostname, with hostnamectl if
+// available. It's applied right away, no reboot is required.
+func setHostname(ctx context.Context, hostname string) (bool, error) {
+	if _, err := exec.LookPath("hostnamectl"); err == nil {
+		return false, run.Quiet(ctx, "hostnamectl", "set-hostname", hostname)
+	}
+
+	if err := run.Quiet(ctx, "hostname", hostname); err != nil {
+		return false, err
+	}
+	return false, os.WriteFile(hostnameFile, []byte(hostname+"\n"), 0644)
+}
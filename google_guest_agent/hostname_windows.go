@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32               = windows.NewLazySystemDLL("kernel32.dll")
+	procGetComputerNameExW = kernel32.NewProc("GetComputerNameExW")
+	procSetComputerNameExW = kernel32.NewProc("SetComputerNameExW")
+)
+
+// COMPUTER_NAME_FORMAT values, see
+// https://learn.microsoft.com/en-us/windows/win32/api/sysinfoapi/ne-sysinfoapi-computer_name_format
+const (
+	computerNameDNSHostname         = 1
+	computerNameDNSFullyQualified   = 3
+	computerNamePhysicalDNSHostname = 5
+	computerNamePhysicalDNSDomain   = 6
+)
+
+// currentHostname returns the computer's active DNS hostname, fully qualified if
+// fqdn is set.
+func currentHostname(fqdn bool) (string, error) {
+	format := computerNameDNSHostname
+	if fqdn {
+		format = computerNameDNSFullyQualified
+	}
+
+	size := uint32(256)
+	buf := make([]uint16, size)
+	if ret, _, err := procGetComputerNameExW.Call(uintptr(format), uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size))); ret == 0 {
+		return "", fmt.Errorf("GetComputerNameExW failed: %v", err)
+	}
+	return syscall.UTF16ToString(buf[:size]), nil
+}
+
+// setComputerName sets the computer name of the given format.
+func setComputerName(format int, name string) error {
+	ptr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("error encoding %q to UTF16: %v", name, err)
+	}
+	if ret, _, err := procSetComputerNameExW.Call(uintptr(format), uintptr(unsafe.Pointer(ptr))); ret == 0 {
+		return fmt.Errorf("SetComputerNameExW failed: %v", err)
+	}
+	return nil
+}
+
+// setHostname sets the computer's DNS hostname, and its primary DNS suffix if
+// hostname is fully qualified. The NetBIOS name is derived from it. It's only
+// applied after a reboot.
+func setHostname(_ context.Context, hostname string) (bool, error) {
+	short, domain, _ := strings.Cut(hostname, ".")
+	if err := setComputerName(computerNamePhysicalDNSHostname, short); err != nil {
+		return false, err
+	}
+	if domain != "" {
+		if err := setComputerName(computerNamePhysicalDNSDomain, domain); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// networkInterfacesKey is the metadata subtree refreshed when a NIC is hot-attached.
+	networkInterfacesKey = "instance/network-interfaces"
+)
+
+var (
+	// sysClassNet is where the network interfaces are described, the physical
+	// ones have a device link.
+	sysClassNet = "/sys/class/net"
+
+	// refreshNetworkInterfaces fetches the network interfaces from the metadata
+	// server, tests replace it.
+	refreshNetworkInterfaces = fetchNetworkInterfaces
+
+	// hotplugMutex protects hotpluggedNICs.
+	hotplugMutex sync.Mutex
+	// hotpluggedNICs are the MAC addresses of the hot-attached NICs already set up,
+	// until the metadata watcher delivers them.
+	hotpluggedNICs = make(map[string]bool)
+)
+
+// nicHotplugged sets up the NICs hot-attached since the last metadata change, their
+// configuration is refreshed from the metadata server as the metadata watcher may
+// not have delivered it yet. The NICs are configured by the network manager, their
+// MTU set and their forwarded IPs routes added without waiting for a reboot.
+func (a *addressMgr) nicHotplugged(ctx context.Context, evType string, evData *events.EventData) {
+	if evData.Error != nil {
+		logger.Debugf("Network change watcher %q failed: %+v", evType, evData.Error)
+		return
+	}
+
+	md := a.reapplyMetadata(ctx)
+	if md == nil {
+		return
+	}
+
+	config := cfg.Get()
+	if !config.NetworkInterfaces.Hotplug {
+		return
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Errorf("Failed to list network interfaces: %v", err)
+		return
+	}
+
+	hotplugMutex.Lock()
+	defer hotplugMutex.Unlock()
+
+	added := unknownNICs(ifaces, md, hotpluggedNICs)
+	if len(added) == 0 {
+		return
+	}
+
+	nics, err := refreshNetworkInterfaces(ctx)
+	if err != nil {
+		logger.Errorf("Failed to refresh %s: %v", networkInterfacesKey, err)
+		return
+	}
+
+	var found []net.Interface
+	for _, iface := range added {
+		if slices.ContainsFunc(nics, func(ni metadata.NetworkInterfaces) bool { return ni.Mac == iface.HardwareAddr.String() }) {
+			found = append(found, iface)
+		} else {
+			logger.Infof("NIC %s (%s) attached but not in the metadata yet.", iface.Name, iface.HardwareAddr)
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	hotMd := withNetworkInterfaces(md, nics)
+	if err := network.SetupInterfaces(ctx, config, hotMd); err != nil {
+		logger.Errorf("Failed to set up hot-attached NICs: %v", err)
+		return
+	}
+
+	for _, iface := range found {
+		mac := iface.HardwareAddr.String()
+		logger.Infof("Set up hot-attached NIC %s (%s).", iface.Name, mac)
+		hotpluggedNICs[mac] = true
+	}
+
+	tuneNICs(ctx, config, hotMd)
+	applyMTU(ctx, config, hotMd)
+	a.applyForwardedIPs(ctx, config, hotMd)
+}
+
+// unknownNICs returns the physical NICs of ifaces missing from md's network
+// interfaces and not already set up (handled).
+func unknownNICs(ifaces []net.Interface, md *metadata.Descriptor, handled map[string]bool) []net.Interface {
+	var res []net.Interface
+	for _, iface := range ifaces {
+		mac := iface.HardwareAddr.String()
+		if mac == "" || iface.Flags&net.FlagLoopback != 0 || handled[mac] {
+			continue
+		}
+		if slices.ContainsFunc(md.Instance.NetworkInterfaces, func(ni metadata.NetworkInterfaces) bool { return ni.Mac == mac }) {
+			continue
+		}
+		// Skip the virtual interfaces, i.e. bridges and veths created by containers.
+		if _, err := os.Stat(filepath.Join(sysClassNet, iface.Name, "device")); err != nil {
+			continue
+		}
+		res = append(res, iface)
+	}
+	return res
+}
+
+// withNetworkInterfaces returns a copy of md with its network interfaces replaced
+// by nics, md is shared with the managers and is not modified.
+func withNetworkInterfaces(md *metadata.Descriptor, nics []metadata.NetworkInterfaces) *metadata.Descriptor {
+	res := *md
+	res.Instance.NetworkInterfaces = nics
+	return &res
+}
+
+// fetchNetworkInterfaces fetches the network interfaces from the metadata server.
+func fetchNetworkInterfaces(ctx context.Context) ([]metadata.NetworkInterfaces, error) {
+	data, err := mdsClient.GetKeyRecursive(ctx, networkInterfacesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var nics []metadata.NetworkInterfaces
+	if err := json.Unmarshal([]byte(data), &nics); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", networkInterfacesKey, err)
+	}
+	return nics, nil
+}
+
+// resetHotpluggedNICs forgets the hot-attached NICs set up, once the metadata is
+// applied they are either part of it or detached.
+func resetHotpluggedNICs() {
+	hotplugMutex.Lock()
+	defer hotplugMutex.Unlock()
+	clear(hotpluggedNICs)
+}
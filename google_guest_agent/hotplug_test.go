@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnknownNICs(t *testing.T) {
+	sysDir := t.TempDir()
+	orig := sysClassNet
+	sysClassNet = sysDir
+	t.Cleanup(func() { sysClassNet = orig })
+
+	mac := func(s string) net.HardwareAddr {
+		t.Helper()
+		hw, err := net.ParseMAC(s)
+		if err != nil {
+			t.Fatalf("net.ParseMAC(%q) = %v, want nil", s, err)
+		}
+		return hw
+	}
+
+	ifaces := []net.Interface{
+		{Name: "lo", Flags: net.FlagLoopback},
+		{Name: "eth0", HardwareAddr: mac("42:01:0a:00:00:02")},
+		{Name: "eth1", HardwareAddr: mac("42:01:0a:00:01:02")},
+		{Name: "eth2", HardwareAddr: mac("42:01:0a:00:02:02")},
+		{Name: "eth3", HardwareAddr: mac("42:01:0a:00:03:02")},
+		{Name: "docker0", HardwareAddr: mac("02:42:ac:11:00:01")},
+	}
+	for _, name := range []string{"eth0", "eth1", "eth2", "eth3"} {
+		if err := os.MkdirAll(filepath.Join(sysDir, name, "device"), 0755); err != nil {
+			t.Fatalf("os.MkdirAll(%s) = %v, want nil", name, err)
+		}
+	}
+
+	md := &metadata.Descriptor{}
+	md.Instance.NetworkInterfaces = []metadata.NetworkInterfaces{{Mac: "42:01:0a:00:00:02"}}
+	handled := map[string]bool{"42:01:0a:00:03:02": true}
+
+	var got []string
+	for _, iface := range unknownNICs(ifaces, md, handled) {
+		got = append(got, iface.Name)
+	}
+	if diff := cmp.Diff([]string{"eth1", "eth2"}, got); diff != "" {
+		t.Errorf("unknownNICs() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithNetworkInterfaces(t *testing.T) {
+	md := &metadata.Descriptor{}
+	md.Instance.ID = "1"
+	md.Instance.NetworkInterfaces = []metadata.NetworkInterfaces{{Mac: "42:01:0a:00:00:02"}}
+
+	nics := []metadata.NetworkInterfaces{{Mac: "42:01:0a:00:00:02"}, {Mac: "42:01:0a:00:01:02", MTU: 8896}}
+	got := withNetworkInterfaces(md, nics)
+
+	if diff := cmp.Diff(nics, got.Instance.NetworkInterfaces); diff != "" {
+		t.Errorf("withNetworkInterfaces() returned unexpected diff (-want +got):\n%s", diff)
+	}
+	if got.Instance.ID != md.Instance.ID {
+		t.Errorf("withNetworkInterfaces() instance ID = %v, want %v", got.Instance.ID, md.Instance.ID)
+	}
+	if len(md.Instance.NetworkInterfaces) != 1 {
+		t.Errorf("withNetworkInterfaces() modified md, got %d NICs, want 1", len(md.Instance.NetworkInterfaces))
+	}
+}
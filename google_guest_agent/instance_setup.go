@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/agentcrypto"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/attrsources"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
@@ -105,8 +106,12 @@ func agentInit(ctx context.Context) {
 		}
 	} else {
 		// Linux instance setup.
-		defer run.Quiet(ctx, "systemd-notify", "--ready")
-		defer logger.Debugf("notify systemd")
+		defer func() {
+			logger.Debugf("notify service manager")
+			if err := notifyReady(); err != nil {
+				logger.Errorf("Failed to notify service manager: %v", err)
+			}
+		}()
 
 		if config.Snapshots.Enabled {
 			logger.Infof("Snapshot listener enabled")
@@ -157,6 +162,9 @@ func agentInit(ctx context.Context) {
 			newMetadata, err = mdsClient.Get(ctx)
 			if err != nil {
 				logger.Errorf("Failed to reach MDS(all retries exhausted): %+v", err)
+				newMetadata = cachedMetadata()
+			}
+			if newMetadata == nil {
 				logger.Infof("Falling to OS default network configuration to attempt to recover.")
 				if err := network.FallbackToDefault(ctx); err != nil {
 					// Just log error and attempt to continue anyway, if we can't reach MDS
@@ -170,9 +178,11 @@ func agentInit(ctx context.Context) {
 				}
 			}
 		}
+		attrsources.Merge(ctx, newMetadata)
 
-		// Early setup the network configurations before we notify systemd we are done.
-		runManager(ctx, addressManager)
+		// Early setup the network configurations before we notify systemd we are done,
+		// nothing was applied yet.
+		managerByName("network").run(ctx, nil, newMetadata)
 
 		// Disable overcommit accounting; e2 instances only.
 		parts := strings.Split(newMetadata.Instance.MachineType, "/")
@@ -292,7 +302,7 @@ func generateSSHKeys(ctx context.Context) error {
 			continue
 		}
 		if vals := strings.Split(string(pubKey), " "); len(vals) >= 2 {
-			if err := mdsClient.WriteGuestAttributes(ctx, "hostkeys/"+vals[0], vals[1]); err != nil {
+			if err := mdsClient.PutGuestAttribute(ctx, "hostkeys", vals[0], vals[1]); err != nil {
 				logger.Errorf("Failed to upload %s key to guest attributes: %v", keytype, err)
 			}
 		} else {
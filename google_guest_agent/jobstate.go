@@ -0,0 +1,46 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// schedulerStateFile is the file the time of the jobs' last successful run is
+	// persisted to, so the jobs with a long interval, i.e. the telemetry, don't run
+	// again on every agent restart, nor the once per boot jobs in the same boot.
+	schedulerStateFile = defaultSchedulerStateFile()
+)
+
+func defaultSchedulerStateFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "Google", "Compute Engine", "guest-agent-jobs.json")
+	}
+	return "/var/lib/google/guest-agent-jobs.json"
+}
+
+// initSchedulerState loads the jobs' last successful run persisted by a previous
+// agent run, the jobs resume their schedule from it.
+func initSchedulerState() {
+	if err := scheduler.Get().SetStateFile(schedulerStateFile); err != nil {
+		logger.Errorf("Failed to load the scheduler state, the jobs start from scratch: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// The key source policies, how the instance and the project SSH keys of a user are
+// combined.
+const (
+	// keySourceMerge uses the instance and the project keys, the project keys are
+	// skipped if block-project-ssh-keys is set.
+	keySourceMerge = "merge"
+	// keySourceMergeAll uses the instance and the project keys, ignoring
+	// block-project-ssh-keys.
+	keySourceMergeAll = "merge-all"
+	// keySourceInstance only uses the instance keys.
+	keySourceInstance = "instance"
+	// keySourceInstanceFirst uses the instance keys, the project keys if the user has
+	// none and block-project-ssh-keys isn't set.
+	keySourceInstanceFirst = "instance-first"
+	// keySourceProjectFirst uses the project keys unless block-project-ssh-keys is
+	// set, the instance keys if the user has none.
+	keySourceProjectFirst = "project-first"
+)
+
+// keySourcePolicyNames are the valid key source policies.
+var keySourcePolicyNames = []string{keySourceMerge, keySourceMergeAll, keySourceInstance, keySourceInstanceFirst, keySourceProjectFirst}
+
+var (
+	// lookupUserGroups returns the names of the local groups of the user, replaceable
+	// by unit tests.
+	lookupUserGroups = defaultLookupUserGroups
+
+	// keySourceErrorMutex protects lastKeySourceError.
+	keySourceErrorMutex sync.Mutex
+	// lastKeySourceError is the last logged key source configuration error, it's
+	// logged once, the keys are also combined by the authorized keys command handler.
+	lastKeySourceError string
+)
+
+// groupKeySource is the key source policy of the members of a group.
+type groupKeySource struct {
+	// group is the group's name.
+	group string
+	// policy is the key source policy.
+	policy string
+}
+
+// keySources are the configured key source policies.
+type keySources struct {
+	// policy is the policy of the users without an override.
+	policy string
+	// users are the policies of the users by name.
+	users map[string]string
+	// groups are the policies of the groups' members, the first group of a user
+	// listed applies.
+	groups []groupKeySource
+}
+
+// parseKeySources parses the key_source_policy and key_source_overrides options of
+// config. The overrides are a comma separated list of user:policy and
+// %group:policy entries. The invalid entries are skipped and reported with the
+// returned error, an invalid default policy is replaced by merge.
+func parseKeySources(config *cfg.Accounts) (*keySources, error) {
+	res := &keySources{policy: keySourceMerge, users: make(map[string]string)}
+	var errs []error
+
+	if policy := strings.TrimSpace(config.KeySourcePolicy); policy != "" {
+		if slices.Contains(keySourcePolicyNames, policy) {
+			res.policy = policy
+		} else {
+			errs = append(errs, fmt.Errorf("invalid key source policy %q, want one of %s", policy, strings.Join(keySourcePolicyNames, ", ")))
+		}
+	}
+
+	for _, entry := range strings.Split(config.KeySourceOverrides, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, policy, ok := strings.Cut(entry, ":")
+		name, policy = strings.TrimSpace(name), strings.TrimSpace(policy)
+		if !ok || name == "" || name == "%" || !slices.Contains(keySourcePolicyNames, policy) {
+			errs = append(errs, fmt.Errorf("invalid key source override %q, want user:policy or %%group:policy", entry))
+			continue
+		}
+		if group, isGroup := strings.CutPrefix(name, "%"); isGroup {
+			res.groups = append(res.groups, groupKeySource{group: group, policy: policy})
+		} else {
+			res.users[name] = policy
+		}
+	}
+
+	return res, errors.Join(errs...)
+}
+
+// configuredKeySources returns the configured key source policies, logging the
+// configuration errors once.
+func configuredKeySources(config *cfg.Sections) *keySources {
+	sources, err := parseKeySources(config.Accounts)
+
+	keySourceErrorMutex.Lock()
+	defer keySourceErrorMutex.Unlock()
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+	if msg != "" && msg != lastKeySourceError {
+		logger.Errorf("Invalid key source configuration: %v", err)
+	}
+	lastKeySourceError = msg
+	return sources
+}
+
+// policyFor returns the key source policy of user: its own override, the one of
+// the first of its local groups listed, or the default policy. The group overrides
+// don't apply to the users not created yet.
+func (k *keySources) policyFor(name string) string {
+	if policy, found := k.users[name]; found {
+		return policy
+	}
+	if len(k.groups) == 0 {
+		return k.policy
+	}
+
+	groups, err := lookupUserGroups(name)
+	if err != nil {
+		logger.Debugf("Failed to look up the groups of user %s: %v", name, err)
+		return k.policy
+	}
+	for _, override := range k.groups {
+		if slices.Contains(groups, override.group) {
+			return override.policy
+		}
+	}
+	return k.policy
+}
+
+// ignoresBlock returns true if any policy uses the project keys even though
+// block-project-ssh-keys is set.
+func (k *keySources) ignoresBlock() bool {
+	if k.policy == keySourceMergeAll {
+		return true
+	}
+	for _, policy := range k.users {
+		if policy == keySourceMergeAll {
+			return true
+		}
+	}
+	for _, override := range k.groups {
+		if override.policy == keySourceMergeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// combineKeys returns the keys of a user with policy given its instance and
+// project keys, blocked is the block-project-ssh-keys setting.
+func combineKeys(policy string, blocked bool, instance, project []string) []string {
+	if blocked && policy != keySourceMergeAll {
+		project = nil
+	}
+
+	switch policy {
+	case keySourceInstance:
+		return slices.Clone(instance)
+	case keySourceInstanceFirst:
+		if len(instance) > 0 {
+			return slices.Clone(instance)
+		}
+		return slices.Clone(project)
+	case keySourceProjectFirst:
+		if len(project) > 0 {
+			return slices.Clone(project)
+		}
+		return slices.Clone(instance)
+	default:
+		return slices.Concat(instance, project)
+	}
+}
+
+// defaultLookupUserGroups returns the names of the local groups of the user.
+func defaultLookupUserGroups(name string) ([]string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, gid := range gids {
+		group, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		res = append(res, group.Name)
+	}
+	return res, nil
+}
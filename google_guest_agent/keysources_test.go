@@ -0,0 +1,135 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseKeySources(t *testing.T) {
+	config := &cfg.Accounts{
+		KeySourcePolicy:    "instance-first",
+		KeySourceOverrides: "alice:instance, %admins:merge-all,bob,carol:unknown,%:merge",
+	}
+	got, err := parseKeySources(config)
+	if err == nil {
+		t.Errorf("parseKeySources(%+v) = nil error, want the invalid overrides reported", config)
+	}
+
+	want := &keySources{
+		policy: keySourceInstanceFirst,
+		users:  map[string]string{"alice": keySourceInstance},
+		groups: []groupKeySource{{group: "admins", policy: keySourceMergeAll}},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(keySources{}, groupKeySource{})); diff != "" {
+		t.Errorf("parseKeySources(%+v) returned unexpected diff (-want +got):\n%s", config, diff)
+	}
+
+	got, err = parseKeySources(&cfg.Accounts{KeySourcePolicy: "first"})
+	if err == nil || got.policy != keySourceMerge {
+		t.Errorf("parseKeySources(first) = %q, %v, want %q and an error", got.policy, err, keySourceMerge)
+	}
+}
+
+func TestCombineKeys(t *testing.T) {
+	instance, project := []string{"instance-key"}, []string{"project-key"}
+	tests := []struct {
+		policy  string
+		blocked bool
+		noInst  bool
+		want    []string
+	}{
+		{policy: keySourceMerge, want: []string{"instance-key", "project-key"}},
+		{policy: keySourceMerge, blocked: true, want: []string{"instance-key"}},
+		{policy: keySourceMergeAll, blocked: true, want: []string{"instance-key", "project-key"}},
+		{policy: keySourceInstance, want: []string{"instance-key"}},
+		{policy: keySourceInstanceFirst, want: []string{"instance-key"}},
+		{policy: keySourceInstanceFirst, noInst: true, want: []string{"project-key"}},
+		{policy: keySourceInstanceFirst, noInst: true, blocked: true, want: nil},
+		{policy: keySourceProjectFirst, want: []string{"project-key"}},
+		{policy: keySourceProjectFirst, blocked: true, want: []string{"instance-key"}},
+	}
+
+	for _, tc := range tests {
+		inst := instance
+		if tc.noInst {
+			inst = nil
+		}
+		got := combineKeys(tc.policy, tc.blocked, inst, project)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("combineKeys(%q, %t, %v, %v) returned unexpected diff (-want +got):\n%s", tc.policy, tc.blocked, inst, project, diff)
+		}
+	}
+}
+
+func TestKeySourcesPolicyFor(t *testing.T) {
+	oldLookup := lookupUserGroups
+	t.Cleanup(func() { lookupUserGroups = oldLookup })
+	lookupUserGroups = func(name string) ([]string, error) {
+		switch name {
+		case "alice", "bob":
+			return []string{"users", "admins"}, nil
+		case "carol":
+			return []string{"users"}, nil
+		}
+		return nil, errors.New("unknown user")
+	}
+
+	sources := &keySources{
+		policy: keySourceMerge,
+		users:  map[string]string{"alice": keySourceInstance},
+		groups: []groupKeySource{{group: "admins", policy: keySourceMergeAll}, {group: "users", policy: keySourceProjectFirst}},
+	}
+	want := map[string]string{
+		"alice": keySourceInstance,
+		"bob":   keySourceMergeAll,
+		"carol": keySourceProjectFirst,
+		"dave":  keySourceMerge,
+	}
+	for name, policy := range want {
+		if got := sources.policyFor(name); got != policy {
+			t.Errorf("policyFor(%s) = %q, want %q", name, got, policy)
+		}
+	}
+}
+
+func TestParseMetadataUserKeysSources(t *testing.T) {
+	if err := cfg.Load([]byte("[Accounts]\nkey_source_overrides = alice:merge-all,bob:instance")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+	t.Cleanup(func() { cfg.Load(nil) })
+
+	instanceKey := "ssh-rsa " + utils.MakeRandRSAPubKey(t)
+	projectKey := "ssh-rsa " + utils.MakeRandRSAPubKey(t)
+	md := &metadata.Descriptor{}
+	md.Instance.Attributes.BlockProjectKeys = true
+	md.Instance.Attributes.SSHKeys = []string{"alice:" + instanceKey, "bob:" + instanceKey}
+	md.Project.Attributes.SSHKeys = []string{"alice:" + projectKey, "bob:" + projectKey, "carol:" + projectKey}
+
+	want := map[string][]string{
+		"alice": {instanceKey, projectKey},
+		"bob":   {instanceKey},
+	}
+	got, _ := parseMetadataUserKeys(md)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseMetadataUserKeys() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
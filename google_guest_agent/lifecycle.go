@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// lifecycleKey is the guest attribute the last lifecycle event is reported to.
+	lifecycleKey = "guest-agent/lifecycle"
+	// lifecycleReportTimeout is the timeout for reporting a lifecycle event, it must fit
+	// in the service manager's stop timeout.
+	lifecycleReportTimeout = 5 * time.Second
+
+	// lifecycleAgentStop is reported when the agent service is stopped.
+	lifecycleAgentStop = "agent-stop"
+	// lifecycleOSShutdown is reported when the agent is stopped as part of an OS shutdown.
+	lifecycleOSShutdown = "os-shutdown"
+	// lifecyclePreemption is reported when the instance is preempted.
+	lifecyclePreemption = "preemption"
+	// lifecycleCrash is reported when the agent finds out its previous run didn't stop cleanly.
+	lifecycleCrash = "crash"
+)
+
+var (
+	// systemShutdown is set when the service manager notifies the agent that
+	// the system is shutting down.
+	systemShutdown atomic.Bool
+
+	// lifecycleMarker is the file marking the agent as running, it's removed when the
+	// agent stops cleanly so its presence at startup means the previous run crashed.
+	lifecycleMarker = defaultLifecycleMarker()
+)
+
+// lifecycleEvent is the structured record of a lifecycle event.
+type lifecycleEvent struct {
+	// Reason is one of agent-stop, os-shutdown, preemption or crash.
+	Reason string `json:"reason"`
+	// Initiator is who initiated the event, if known.
+	Initiator string `json:"initiator,omitempty"`
+	// Timestamp is the time the event was recorded in RFC3339 format.
+	Timestamp string `json:"timestamp"`
+	// Version is the guest agent version recording the event.
+	Version string `json:"version"`
+}
+
+func defaultLifecycleMarker() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "Google", "Compute Engine", "guest-agent-running")
+	}
+	return "/var/lib/google/guest-agent-running"
+}
+
+// recordLifecycleEvent logs the event and writes it to the lifecycle guest attribute.
+func recordLifecycleEvent(ctx context.Context, reason, initiator string) {
+	event := lifecycleEvent{
+		Reason:    reason,
+		Initiator: initiator,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Version:   version,
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("Failed to marshal lifecycle event: %v", err)
+		return
+	}
+
+	logger.Infof("Lifecycle event: %s", data)
+
+	if mdsClient == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lifecycleReportTimeout)
+	defer cancel()
+
+	if err := mdsClient.WriteGuestAttributes(ctx, lifecycleKey, string(data)); err != nil {
+		logger.Errorf("Failed to report lifecycle event to guest attributes: %v", err)
+	}
+}
+
+// lifecycleStart marks the agent as running and reports a crash if the previous
+// run didn't stop cleanly.
+func lifecycleStart(ctx context.Context) {
+	if utils.FileExists(lifecycleMarker, utils.TypeFile) {
+		recordLifecycleEvent(ctx, lifecycleCrash, "")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(lifecycleMarker), 0755); err != nil {
+		logger.Errorf("Failed to create lifecycle marker directory: %v", err)
+		return
+	}
+
+	if err := utils.WriteFile([]byte(version), lifecycleMarker, 0644); err != nil {
+		logger.Errorf("Failed to write lifecycle marker: %v", err)
+	}
+}
+
+// lifecycleStop reports the agent is stopping and removes the running marker. The
+// context passed down to the agent is already canceled at this point so it
+// uses its own.
+func lifecycleStop() {
+	ctx := context.Background()
+
+	if osShuttingDown(ctx) {
+		recordLifecycleEvent(ctx, lifecycleOSShutdown, "os")
+	} else {
+		recordLifecycleEvent(ctx, lifecycleAgentStop, "service-manager")
+	}
+
+	if err := os.Remove(lifecycleMarker); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("Failed to remove lifecycle marker: %v", err)
+	}
+}
+
+// osShuttingDown returns true if the agent is stopping as part of an OS shutdown.
+func osShuttingDown(ctx context.Context) bool {
+	if systemShutdown.Load() {
+		return true
+	}
+
+	if serviceManager != serviceManagerSystemd {
+		return false
+	}
+
+	// Stopping means the system manager is in the process of shutting the system down.
+	res := run.WithOutputTimeout(ctx, lifecycleReportTimeout, "systemctl", "is-system-running")
+	return strings.TrimSpace(res.StdOut) == "stopping"
+}
+
+// preempted returns true if the instance went from not preempted in oldMd to
+// preempted in newMd.
+func preempted(oldMd, newMd *metadata.Descriptor) bool {
+	if newMd == nil || !strings.EqualFold(newMd.Instance.Preempted, "true") {
+		return false
+	}
+	return oldMd == nil || !strings.EqualFold(oldMd.Instance.Preempted, "true")
+}
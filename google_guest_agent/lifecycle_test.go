@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+func TestPreempted(t *testing.T) {
+	mkmd := func(preempted string) *metadata.Descriptor {
+		return &metadata.Descriptor{Instance: metadata.Instance{Preempted: preempted}}
+	}
+
+	var tests = []struct {
+		name     string
+		old, new *metadata.Descriptor
+		want     bool
+	}{
+		{"not preempted", mkmd("FALSE"), mkmd("FALSE"), false},
+		{"preemption started", mkmd("FALSE"), mkmd("TRUE"), true},
+		{"preemption already reported", mkmd("TRUE"), mkmd("TRUE"), false},
+		{"no previous metadata", nil, mkmd("TRUE"), true},
+		{"no metadata", mkmd("FALSE"), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preempted(tt.old, tt.new); got != tt.want {
+				t.Errorf("preempted() = %t, want: %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLifecycleMarker(t *testing.T) {
+	ctx := context.Background()
+	oldMarker := lifecycleMarker
+	t.Cleanup(func() { lifecycleMarker = oldMarker })
+	lifecycleMarker = filepath.Join(t.TempDir(), "state", "guest-agent-running")
+
+	lifecycleStart(ctx)
+	if !utils.FileExists(lifecycleMarker, utils.TypeFile) {
+		t.Fatalf("lifecycleStart() didn't create the marker file %q", lifecycleMarker)
+	}
+
+	lifecycleStop()
+	if utils.FileExists(lifecycleMarker, utils.TypeFile) {
+		t.Errorf("lifecycleStop() didn't remove the marker file %q", lifecycleMarker)
+	}
+}
@@ -17,6 +17,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -25,12 +26,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/attrsources"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/command"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/configchange"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/configfile"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/control"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/dhcplease"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/inject"
 	mdsEvent "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/netlink"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/reload"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/systemdunit"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events/timer"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/osinfo"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/sdnotify"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/secrets"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/telemetry"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-agent/utils"
@@ -48,22 +61,56 @@ type TrustedCert struct {
 }
 
 var (
-	programName              = "GCEGuestAgent"
-	version                  string
-	oldMetadata, newMetadata *metadata.Descriptor
-	osInfo                   osinfo.OSInfo
-	mdsClient                *metadata.Client
-	addressManager           = &addressMgr{}
+	programName    = "GCEGuestAgent"
+	version        string
+	osInfo         osinfo.OSInfo
+	mdsClient      *metadata.Client
+	addressManager = &addressMgr{}
 )
 
 const (
 	regKeyBase = `SOFTWARE\Google\ComputeEngine`
+	// secretResolveTimeout is the timeout for resolving a configuration secret reference.
+	secretResolveTimeout = 30 * time.Second
+)
+
+var (
+	// metadataMutex protects oldMetadata and newMetadata, replaced by the metadata
+	// handler while the control socket commands and the managers' retries read them.
+	metadataMutex sync.Mutex
+	// oldMetadata is the last metadata the managers applied and newMetadata the last
+	// fetched one. The descriptors aren't modified once set, see metadataSnapshot().
+	oldMetadata, newMetadata *metadata.Descriptor
 )
 
+// metadataSnapshot returns the last applied and the last fetched metadata, the
+// managers get them as an immutable pair instead of reading the globals.
+func metadataSnapshot() (oldMd, newMd *metadata.Descriptor) {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	return oldMetadata, newMetadata
+}
+
+// setNewMetadata records md as the last fetched metadata.
+func setNewMetadata(md *metadata.Descriptor) {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	newMetadata = md
+}
+
+// setOldMetadata records md as the last metadata the managers applied.
+func setOldMetadata(md *metadata.Descriptor) {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	oldMetadata = md
+}
+
+// manager applies a part of the configuration described by the metadata. The
+// descriptors are shared with the other managers and must not be modified.
 type manager interface {
-	Diff(ctx context.Context) (bool, error)
-	Disabled(ctx context.Context) (bool, error)
-	Set(ctx context.Context) error
+	Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error)
+	Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error)
+	Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error
 	Timeout(ctx context.Context) (bool, error)
 }
 
@@ -85,71 +132,23 @@ func closeFile(c io.Closer) {
 	}
 }
 
-func availableManagers() []manager {
-	managers := []manager{
-		addressManager,
-	}
-
-	if runtime.GOOS == "windows" {
-		return append(managers,
-			newWsfcManager(),
-			&winAccountsMgr{},
-			&diagnosticsMgr{},
-		)
-	}
-
-	return append(managers,
-		&clockskewMgr{},
-		&osloginMgr{},
-		&accountsMgr{},
-	)
-}
-
-func runManager(ctx context.Context, mgr manager) {
-	disabled, err := mgr.Disabled(ctx)
-	if err != nil {
-		logger.Errorf("Failed to run manager's Disabled() call: %+v", err)
-		return
-	}
-
-	if disabled {
-		logger.Debugf("manager %#v disabled, skipping", mgr)
-		return
-	}
-
-	timeout, err := mgr.Timeout(ctx)
-	if err != nil {
-		logger.Errorf("[%#v] Failed to run manager Timeout() call: %+v", mgr, err)
-		return
-	}
-
-	diff, err := mgr.Diff(ctx)
-	if err != nil {
-		logger.Errorf("[%#v] Failed to run manager Diff() call: %+v", mgr, err)
-		return
-	}
-
-	if !timeout && !diff {
-		logger.Debugf("[%#v] Manager reports no diff", mgr)
+// runUpdate runs all the registered managers with a snapshot of the last applied
+// and the last fetched metadata, i.e. after a metadata change, the fetched metadata
+// is then recorded as applied. With managers_dry_run set the managers only log the
+// changes they would make and the metadata isn't recorded as applied, so the changes
+// are applied once the dry-run is disabled.
+func runUpdate(ctx context.Context) {
+	oldMd, newMd := metadataSnapshot()
+	if cfg.Get().Core.ManagersDryRun {
+		events.Logf(ctx, logger.Info, "Managers dry-run enabled, logging the changes instead of applying them.")
+		dryRunManagers(ctx, managerRegistry, oldMd, newMd)
+		reportStatus(ctx, "managers dry-run, configuration not applied, watching metadata for changes")
 		return
 	}
 
-	logger.Debugf("running %#v manager", mgr)
-	if err := mgr.Set(ctx); err != nil {
-		logger.Errorf("[%#v] Failed to run manager Set() call: %s", mgr, err)
-	}
-}
-
-func runUpdate(ctx context.Context) {
-	var wg sync.WaitGroup
-	for _, mgr := range availableManagers() {
-		wg.Add(1)
-		go func(mgr manager) {
-			defer wg.Done()
-			runManager(ctx, mgr)
-		}(mgr)
-	}
-	wg.Wait()
+	runManagers(ctx, managerRegistry, oldMd, newMd, applyOnDiff)
+	setOldMetadata(newMd)
+	reportStatus(ctx, "configuration applied, watching metadata for changes")
 }
 
 func runAgent(ctx context.Context) {
@@ -182,11 +181,27 @@ func runAgent(ctx context.Context) {
 	defer logger.Close()
 
 	logger.Infof("GCE Agent Started (version %s)", version)
+	logConfigWarnings()
+	initServiceManager()
+	if err := notifyStarted(); err != nil {
+		logger.Errorf("Failed to notify service manager we started: %v", err)
+	}
+
+	if err := configureServiceRecovery(); err != nil {
+		logger.Errorf("Failed to configure service recovery actions: %v", err)
+	}
 
 	osInfo = osinfo.Get()
+	metadata.SetDefaultURL(cfg.Get().MDS.URL)
+	metadata.SetDefaultRetryPolicy(cfg.Get().MDS.RetryPolicy())
+	configureMetadataTransport()
+	initMetadataCache()
 	mdsClient = metadata.New()
 
+	reportStatus(ctx, "initializing instance")
+	initDeprovisionState(ctx)
 	agentInit(ctx)
+	enableSecretResolution(ctx)
 
 	if cfg.Get().Unstable.CommandMonitorEnabled {
 		command.Init(ctx)
@@ -200,7 +215,17 @@ func runAgent(ctx context.Context) {
 		newMetadata, err = mdsClient.Get(ctx)
 		if err != nil {
 			logger.Debugf("Error getting metdata: %v", err)
+			newMetadata = cachedMetadata()
 		}
+		attrsources.Merge(ctx, newMetadata)
+	}
+	applyConfigOverrides(nil, newMetadata)
+
+	lifecycleStart(ctx)
+
+	// Publish the running version so it can be queried without accessing the guest.
+	if err := mdsClient.PutGuestAttribute(ctx, "guest-agent", "version", version); err != nil {
+		logger.Debugf("Failed to publish agent version to guest attributes: %v", err)
 	}
 
 	// Try to re-initialize logger now, we know after agentInit() is more likely to have metadata available.
@@ -212,8 +237,37 @@ func runAgent(ctx context.Context) {
 		}
 	}
 
+	// Surface the scheduled jobs' last run to the service manager.
+	scheduler.Get().SetRunCallback(func(jobID string, err error) {
+		if err != nil {
+			reportSubsystemStatus(ctx, jobID, "last run at %s failed", time.Now().Format(time.TimeOnly))
+			return
+		}
+		reportSubsystemStatus(ctx, jobID, "last run at %s", time.Now().Format(time.TimeOnly))
+	})
+
+	if splay, err := time.ParseDuration(cfg.Get().Core.SchedulerSplay); err != nil {
+		logger.Errorf("Invalid scheduler splay %q, ignoring: %v", cfg.Get().Core.SchedulerSplay, err)
+	} else {
+		scheduler.Get().SetSplay(splay)
+	}
+
+	if timeout, err := time.ParseDuration(cfg.Get().Core.SchedulerJobTimeout); err != nil {
+		logger.Errorf("Invalid scheduler job timeout %q, ignoring: %v", cfg.Get().Core.SchedulerJobTimeout, err)
+	} else {
+		scheduler.Get().SetJobTimeout(timeout)
+	}
+	scheduler.Get().SetMaxParallelJobs(cfg.Get().Core.SchedulerMaxParallelJobs)
+
+	initSchedulerState()
+	startPlugins(ctx)
+
 	// knownJobs is list of default jobs that run on a pre-defined schedule.
-	knownJobs := []scheduler.Job{telemetry.New(mdsClient, programName, version)}
+	telemetryJob := telemetry.New(mdsClient, programName, version)
+	telemetryJob.AddMetrics("Event manager", func() fmt.Stringer { return events.Get().Metrics() })
+	telemetryJob.AddMetrics("Managers", func() fmt.Stringer { return managersStatus(managerRegistry) })
+	telemetryJob.AddMetrics("NIC drivers", nicDriversMetrics)
+	knownJobs := []scheduler.Job{telemetryJob}
 	scheduler.ScheduleJobs(ctx, knownJobs, false)
 
 	eventManager := events.Get()
@@ -222,36 +276,243 @@ func runAgent(ctx context.Context) {
 		return
 	}
 
+	if runtime.GOOS != "windows" {
+		if err := eventManager.AddWatcher(ctx, reload.New()); err != nil {
+			logger.Errorf("Error adding configuration reload watcher: %v", err)
+		}
+	}
+
+	if cfg.Get().Core.ConfigWatcherEnabled {
+		if err := eventManager.AddWatcher(ctx, configfile.New(cfg.Files, cfg.DropInDir())); err != nil {
+			logger.Errorf("Error adding configuration file watcher: %v", err)
+		}
+	}
+
+	reloadHandler := func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if !inflight.begin() {
+			return true
+		}
+		defer inflight.end()
+
+		if evData.Error != nil {
+			events.Logf(ctx, logger.Error, "Configuration reload watcher %q failed: %+v", evType, evData.Error)
+			return true
+		}
+		reloadConfiguration(ctx, evType)
+		return true
+	}
+	eventManager.Subscribe(reload.ReloadEvent, nil, reloadHandler)
+	eventManager.Subscribe(configfile.ChangedEvent, nil, reloadHandler)
+
+	if err := eventManager.AddWatcher(ctx, configchange.New()); err != nil {
+		logger.Errorf("Error adding configuration change watcher: %v", err)
+	}
+
+	// Let the components not driven by the managers pick up configuration changes.
+	eventManager.Subscribe(configchange.ChangedEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if !inflight.begin() {
+			return true
+		}
+		defer inflight.end()
+
+		events.Logf(ctx, logger.Debug, "Configuration changed by %v, reconfiguring.", evData.Data)
+
+		initMetadataCache()
+		if err := enableDisableOSLoginCertAuth(ctx); err != nil {
+			events.Logf(ctx, logger.Error, "Failed to enable/disable sshtrustedca watcher: %+v", err)
+		}
+		scheduler.Get().ReconcileJobs(ctx, knownJobs)
+		return true
+	})
+
+	watchNetworkChanges := runtime.GOOS == "linux" && cfg.Get().IPForwarding.WatchNetworkChanges
+	setupHotplugNICs := runtime.GOOS == "linux" && cfg.Get().NetworkInterfaces.Hotplug
+	reapplyMTU := runtime.GOOS == "linux" && cfg.Get().NetworkInterfaces.ManageMTU
+	reapplyHostnameOnDHCP := runtime.GOOS == "linux" && cfg.Get().Hostname.Enabled && cfg.Get().Hostname.ReapplyOnDHCP
+	reapplyDNSOnDHCP := runtime.GOOS == "linux" && cfg.Get().DNS.Enabled && cfg.Get().DNS.ReapplyOnDHCP
+
+	if watchNetworkChanges || reapplyHostnameOnDHCP || reapplyDNSOnDHCP {
+		if err := eventManager.AddWatcher(ctx, dhcplease.New()); err != nil {
+			logger.Errorf("Error adding DHCP lease watcher: %v", err)
+		}
+	}
+
+	if reapplyHostnameOnDHCP {
+		eventManager.Subscribe(dhcplease.LeaseEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			if !inflight.begin() {
+				return true
+			}
+			defer inflight.end()
+
+			reapplyHostname(ctx, evType, evData)
+			return true
+		})
+	}
+
+	if reapplyDNSOnDHCP {
+		eventManager.Subscribe(dhcplease.LeaseEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			if !inflight.begin() {
+				return true
+			}
+			defer inflight.end()
+
+			reapplyDNS(ctx, evType, evData)
+			return true
+		})
+	}
+
+	if watchNetworkChanges || setupHotplugNICs || reapplyMTU {
+		if err := eventManager.AddWatcher(ctx, netlink.New()); err != nil {
+			logger.Errorf("Error adding network change watcher: %v", err)
+		}
+
+		// A NIC hotplug or a DHCP renewal changes links, addresses, routes and lease
+		// files in a burst, handle it once it settles.
+		eventManager.EnableCoalescing(time.Second, netlink.LinkEvent, netlink.AddressEvent, netlink.RouteEvent, dhcplease.LeaseEvent)
+	}
+
+	if setupHotplugNICs {
+		eventManager.Subscribe(netlink.LinkEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			if !inflight.begin() {
+				return true
+			}
+			defer inflight.end()
+
+			addressManager.nicHotplugged(ctx, evType, evData)
+			return true
+		})
+	}
+
+	if reapplyMTU {
+		eventManager.Subscribe(netlink.LinkEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			if !inflight.begin() {
+				return true
+			}
+			defer inflight.end()
+
+			addressManager.linkChanged(ctx, evType, evData)
+			return true
+		})
+	}
+
+	if watchNetworkChanges {
+		// Re-apply the forwarded IPs wiped by the network changes.
+		networkEvents := []string{netlink.LinkEvent, netlink.AddressEvent, netlink.RouteEvent, dhcplease.LeaseEvent}
+		for _, evType := range networkEvents {
+			eventManager.Subscribe(evType, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+				if !inflight.begin() {
+					return true
+				}
+				defer inflight.end()
+
+				addressManager.networkChanged(ctx, evType, evData)
+				return true
+			})
+		}
+	}
+
+	addTimerWatcher(ctx, eventManager)
+
+	if runtime.GOOS == "linux" && cfg.Get().Core.UnitWatcherEnabled {
+		addUnitWatcher(ctx, eventManager)
+	}
+
+	// The authorized_keys_command mode queries the keys over the control socket.
+	if cfg.Get().Core.ControlWatcherEnabled || cfg.Get().Accounts.AuthorizedKeysCommand {
+		addControlWatcher(ctx, eventManager)
+	}
+
+	if cfg.Get().Core.InjectWatcherEnabled {
+		addInjectWatcher(ctx, eventManager)
+	}
+
+	var watchdogTimeout time.Duration
+	if config := cfg.Get().Watchdog; config.Enabled {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil || timeout <= 0 {
+			logger.Errorf("Invalid watchdog timeout %q, watchdog disabled: %v", config.Timeout, err)
+		} else {
+			watchdogTimeout = timeout
+			eventManager.EnableWatchdog(timeout, mdsEvent.LongpollEvent)
+		}
+	}
+
+	if debounce := cfg.Get().MDS.ChangeDebounce; debounce != "" {
+		window, err := time.ParseDuration(debounce)
+		if err != nil || window < 0 {
+			logger.Errorf("Invalid metadata change debounce %q, ignoring: %v", debounce, err)
+		} else if window > 0 {
+			eventManager.EnableCoalescing(window, mdsEvent.LongpollEvent)
+		}
+	}
+
+	// Watchers failing are restarted, report the agent degraded while they keep failing.
+	eventManager.SetDegradedHandler(func(evType string, degraded bool, err error) {
+		reportWatcherDegraded(ctx, evType, degraded)
+	})
+
+	if config := cfg.Get().Watchdog; config.HandlerTimeout != "" {
+		timeout, err := time.ParseDuration(config.HandlerTimeout)
+		if err != nil || timeout < 0 {
+			logger.Errorf("Invalid event handler timeout %q, ignoring: %v", config.HandlerTimeout, err)
+		} else {
+			eventManager.SetHandlerTimeout(timeout)
+		}
+	}
+
+	// If the agent's watchdog is enabled only keep the service manager's watchdog
+	// happy while the event loop makes progress.
+	if keepalive, enabled := sdnotify.NewKeepalive(watchdogTimeout); enabled {
+		eventManager.SetHeartbeat(keepalive.Heartbeat)
+		go keepalive.Run(ctx)
+	}
+
 	if err := enableDisableOSLoginCertAuth(ctx); err != nil {
 		logger.Errorf("Failed to enable sshtrustedca watcher: %+v", err)
 		return
 	}
 
-	oldMetadata = &metadata.Descriptor{}
-	eventManager.Subscribe(mdsEvent.LongpollEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
-		logger.Debugf("Handling metadata %q event.", evType)
+	setOldMetadata(&metadata.Descriptor{})
+	// The metadata handler sets up the network the other metadata subscribers may
+	// depend on, run it first.
+	eventManager.SubscribePriority(mdsEvent.LongpollEvent, events.PriorityCritical, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		events.Logf(ctx, logger.Debug, "Handling metadata %q event.", evType)
+
+		// The agent is stopping, don't start applying changes it may not finish.
+		if !inflight.begin() {
+			return true
+		}
+		defer inflight.end()
 
 		// If metadata watcher failed there isn't much we can do, just ignore the event and
 		// allow the watcher to get it corrected.
 		if evData.Error != nil {
-			logger.Infof("Metadata event watcher failed, ignoring: %+v", evData.Error)
+			events.Logf(ctx, logger.Info, "Metadata event watcher failed, ignoring: %+v", evData.Error)
 			return true
 		}
 
 		if evData.Data == nil {
-			logger.Infof("Metadata event watcher didn't pass in the metadata, ignoring.")
+			events.Logf(ctx, logger.Info, "Metadata event watcher didn't pass in the metadata, ignoring.")
 			return true
 		}
 
-		newMetadata = evData.Data.(*metadata.Descriptor)
+		// The descriptor is completed before it's published to the managers.
+		_, prevMd := metadataSnapshot()
+		md := evData.Data.(*metadata.Descriptor)
+		attrsources.Merge(ctx, md)
+		applyConfigOverrides(prevMd, md)
+		setNewMetadata(md)
+		reportSubsystemStatus(ctx, "metadata", "last update at %s", time.Now().Format(time.TimeOnly))
+
+		if preempted(prevMd, md) {
+			recordLifecycleEvent(ctx, lifecyclePreemption, "compute-engine")
+		}
 
 		if err := enableDisableOSLoginCertAuth(ctx); err != nil {
-			logger.Errorf("Failed to enable/disable sshtrustedca watcher: %+v", err)
+			events.Logf(ctx, logger.Error, "Failed to enable/disable sshtrustedca watcher: %+v", err)
 		}
 
 		runUpdate(ctx)
-		oldMetadata = newMetadata
-
 		return true
 	})
 
@@ -259,23 +520,142 @@ func runAgent(ctx context.Context) {
 		logger.Fatalf("Failed to run event manager: %+v", err)
 	}
 
+	lifecycleStop()
+
 	logger.Infof("GCE Agent Stopped")
 }
 
+// applyConfigOverrides reloads the configuration with the instance-configs
+// metadata overrides if they changed from oldMd to newMd. Instance overrides take
+// precedence over project ones and both over the configuration files.
+func applyConfigOverrides(oldMd, newMd *metadata.Descriptor) {
+	if newMd == nil {
+		return
+	}
+
+	if oldMd != nil && oldMd.Project.Attributes.InstanceConfigs == newMd.Project.Attributes.InstanceConfigs &&
+		oldMd.Instance.Attributes.InstanceConfigs == newMd.Instance.Attributes.InstanceConfigs {
+		return
+	}
+
+	if err := cfg.SetOverrides(newMd.Project.Attributes.InstanceConfigs, newMd.Instance.Attributes.InstanceConfigs); err != nil {
+		logger.Errorf("Invalid instance-configs metadata, keeping the current configuration: %v", err)
+		return
+	}
+
+	if err := cfg.Load(nil); err != nil {
+		logger.Errorf("Failed to load configuration with instance-configs overrides: %+v", err)
+		return
+	}
+	logger.Infof("Configuration reloaded with instance-configs metadata overrides.")
+	logConfigWarnings()
+	configchange.Publish("instance-configs metadata")
+}
+
+// enableSecretResolution sets the resolver of the configuration's secret references
+// and, if the configuration has any, loads it again so the references are resolved.
+// It depends on the metadata server being reachable so it's only done after
+// agentInit().
+func enableSecretResolution(ctx context.Context) {
+	resolver := secrets.NewResolver(mdsClient)
+	cfg.SetSecretResolver(func(ref string) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, secretResolveTimeout)
+		defer cancel()
+		return resolver.Resolve(ctx, ref)
+	})
+
+	if !cfg.UnresolvedSecrets() {
+		return
+	}
+
+	if err := cfg.Load(nil); err != nil {
+		logger.Errorf("Failed to load configuration with resolved secrets: %+v", err)
+		return
+	}
+	logConfigWarnings()
+	configchange.Publish("secret resolution")
+}
+
+// configureMetadataTransport sets the proxy and source interface of the metadata
+// clients allocated from now on as configured. If the transport can't be built
+// the clients fall back to the default transport.
+func configureMetadataTransport() {
+	config := metadata.TransportConfig{
+		ProxyURL:        cfg.Get().MDS.Proxy,
+		SourceInterface: cfg.Get().MDS.SourceInterface,
+	}
+
+	transport, err := metadata.NewTransport(config)
+	if err != nil {
+		logger.Errorf("Failed to configure metadata server transport, using the default: %v", err)
+	}
+	metadata.SetDefaultTransport(transport)
+}
+
+// logConfigWarnings logs the problems found when loading the configuration, i.e.
+// typos in section or key names.
+func logConfigWarnings() {
+	for _, warning := range cfg.Warnings() {
+		logger.Warningf("Configuration: %s", warning)
+	}
+}
+
+// reloadConfiguration loads the configuration again and re-runs the managers so
+// the new configuration gets applied. Work already in progress keeps using the
+// configuration it started with. source is what requested the reload, i.e. the
+// reload event type. An explicit reload resolves the secret references again, the
+// configuration file changes use the cached secrets.
+func reloadConfiguration(ctx context.Context, source string) {
+	if err := notifyReloading(); err != nil {
+		logger.Debugf("Failed to notify service manager we are reloading: %v", err)
+	}
+	defer func() {
+		if err := notifyReady(); err != nil {
+			logger.Debugf("Failed to notify service manager we are ready: %v", err)
+		}
+	}()
+
+	if source == reload.ReloadEvent {
+		cfg.RefreshSecrets()
+	}
+	if err := cfg.Load(nil); err != nil {
+		logger.Errorf("Failed to reload configuration, keeping the current one: %+v", err)
+		return
+	}
+	logger.Infof("Configuration reloaded.")
+	logConfigWarnings()
+	configchange.Publish(source)
+
+	if _, newMd := metadataSnapshot(); newMd != nil {
+		runUpdate(ctx)
+	}
+}
+
+// correlated prefixes e's message with the correlation ID of the event it was
+// logged for, if any, so the log lines of a single event can be traced across the
+// managers.
+func correlated(e logger.LogEntry) string {
+	if id := e.Labels[events.CorrelationLabel]; id != "" {
+		// [1a2b3c4d] This is a log message.
+		return fmt.Sprintf("[%s] %s", id, e.Message)
+	}
+	return e.Message
+}
+
 func logFormatWindows(e logger.LogEntry) string {
 	now := time.Now().Format("2006/01/02 15:04:05")
 	// 2006/01/02 15:04:05 GCEGuestAgent This is a log message.
-	return fmt.Sprintf("%s %s: %s", now, programName, e.Message)
+	return fmt.Sprintf("%s %s: %s", now, programName, correlated(e))
 }
 
 func logFormat(e logger.LogEntry) string {
 	switch e.Severity {
 	case logger.Error, logger.Critical, logger.Debug:
 		// ERROR file.go:82 This is a log message.
-		return fmt.Sprintf("%s %s:%d %s", strings.ToUpper(e.Severity.String()), e.Source.File, e.Source.Line, e.Message)
+		return fmt.Sprintf("%s %s:%d %s", strings.ToUpper(e.Severity.String()), e.Source.File, e.Source.Line, correlated(e))
 	default:
 		// This is a log message.
-		return e.Message
+		return correlated(e)
 	}
 }
 
@@ -301,6 +681,42 @@ func main() {
 		action = os.Args[1]
 	}
 
+	if action == "dump-config" || action == "--dump-config" {
+		if err := dumpConfig(ctx, metadata.New(), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to dump configuration: %+v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if action == "authorized-keys" {
+		// Run by sshd as the AuthorizedKeysCommand, only the keys are printed.
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s authorized-keys <user>\n", os.Args[0])
+			os.Exit(1)
+		}
+		if err := printAuthorizedKeys(ctx, os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to query the authorized keys of %s: %+v\n", os.Args[2], err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if action == "cleanup" {
+		// Log the reverted changes to the console, i.e. the package manager's output.
+		opts := logger.LogOpts{LoggerName: programName, DisableCloudLogging: true, DisableLocalLogging: true, Writers: []io.Writer{os.Stdout}}
+		if err := logger.Init(ctx, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing logger: %v\n", err)
+		}
+		// The users' home directories are kept unless explicitly asked otherwise.
+		removeHomes := len(os.Args) > 2 && os.Args[2] == "--remove-homes"
+		if err := cleanupOwned(ctx, ownedStateFile, removeHomes); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revert the agent's changes: %+v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if action == "noservice" {
 		runAgent(ctx)
 		os.Exit(0)
@@ -310,3 +726,303 @@ func main() {
 		logger.Fatalf("error registering service: %s", err)
 	}
 }
+
+const (
+	// verifyForwardedIPsTimer is the name of the timer verifying the forwarded IPs.
+	verifyForwardedIPsTimer = "verify-forwarded-ips"
+	// reconcileManagersTimer is the name of the timer reconciling the managers' drifted
+	// configuration.
+	reconcileManagersTimer = "reconcile-managers"
+)
+
+// addTimerWatcher adds the timer watcher emitting the agent's periodic events, i.e.
+// the forwarded IPs verification and the managers' reconciliation.
+func addTimerWatcher(ctx context.Context, eventManager *events.Manager) {
+	var timers []timer.Timer
+
+	if config := cfg.Get().IPForwarding; config != nil && config.VerifyInterval != "" {
+		d, err := time.ParseDuration(config.VerifyInterval)
+		if err != nil || d < 0 {
+			logger.Errorf("Invalid forwarded IPs verify interval %q, ignoring: %v", config.VerifyInterval, err)
+		} else if d > 0 {
+			timers = append(timers, timer.Timer{Name: verifyForwardedIPsTimer, Interval: d})
+		}
+	}
+
+	if interval := cfg.Get().Core.ManagersReconcileInterval; interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < 0 {
+			logger.Errorf("Invalid managers reconcile interval %q, ignoring: %v", interval, err)
+		} else if d > 0 {
+			timers = append(timers, timer.Timer{Name: reconcileManagersTimer, Interval: d})
+		}
+	}
+
+	if len(timers) == 0 {
+		return
+	}
+
+	if err := eventManager.AddWatcher(ctx, timer.New(timers...)); err != nil {
+		logger.Errorf("Error adding timer watcher: %v", err)
+		return
+	}
+
+	eventManager.Subscribe(timer.Event(verifyForwardedIPsTimer), nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if !inflight.begin() {
+			return true
+		}
+		defer inflight.end()
+
+		addressManager.verifyForwardedIPs(ctx, evType, evData)
+		return true
+	})
+
+	eventManager.Subscribe(timer.Event(reconcileManagersTimer), nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if !inflight.begin() {
+			return true
+		}
+		defer inflight.end()
+
+		reconcileManagers(ctx, evData)
+		return true
+	})
+}
+
+// reconcileManagers re-applies the last applied metadata with the managers whose
+// system configuration drifted from it, i.e. a forwarded IP route or a user's
+// authorized keys removed by hand. The pending metadata changes are left to runUpdate.
+func reconcileManagers(ctx context.Context, evData *events.EventData) {
+	if evData.Error != nil {
+		events.Logf(ctx, logger.Error, "Managers reconcile timer failed: %v", evData.Error)
+		return
+	}
+
+	if cfg.Get().Core.ManagersDryRun {
+		events.Logf(ctx, logger.Debug, "Managers dry-run enabled, skipping the reconciliation.")
+		return
+	}
+
+	appliedMd, _ := metadataSnapshot()
+	if appliedMd == nil {
+		events.Logf(ctx, logger.Debug, "No metadata applied yet, skipping the reconciliation.")
+		return
+	}
+
+	runManagers(ctx, managerRegistry, appliedMd, appliedMd, applyOnDrift)
+	reportStatus(ctx, "configuration applied, watching metadata for changes")
+}
+
+// addUnitWatcher watches the sshd and chronyd units, the OS Login sshd configuration
+// is re-applied after sshd restarts and the clock synced after chronyd restarts.
+func addUnitWatcher(ctx context.Context, eventManager *events.Manager) {
+	sshdUnits := []string{"ssh", "sshd"}
+	timeSyncUnits := []string{"chrony", "chronyd"}
+
+	if err := eventManager.AddWatcher(ctx, systemdunit.New(append(sshdUnits, timeSyncUnits...)...)); err != nil {
+		logger.Errorf("Error adding systemd unit watcher: %v", err)
+		return
+	}
+
+	// restarted returns the unit's state if it was restarted and is running.
+	restarted := func(evData *events.EventData) (*systemdunit.State, bool) {
+		state, ok := evData.Data.(*systemdunit.State)
+		if evData.Error != nil || !ok {
+			return nil, false
+		}
+		return state, state.Restarted && state.ActiveState == "active"
+	}
+
+	for _, unit := range sshdUnits {
+		eventManager.Subscribe(systemdunit.Event(unit), nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			if _, ok := restarted(evData); !ok || !inflight.begin() {
+				return true
+			}
+			defer inflight.end()
+
+			if err := reapplySSHConfig(ctx); err != nil {
+				events.Logf(ctx, logger.Error, "Failed to re-apply the sshd configuration: %v", err)
+			}
+			return true
+		})
+	}
+
+	for _, unit := range timeSyncUnits {
+		eventManager.Subscribe(systemdunit.Event(unit), nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+			state, ok := restarted(evData)
+			if !ok || !inflight.begin() {
+				return true
+			}
+			defer inflight.end()
+
+			(&clockskewMgr{}).timeSyncRestarted(ctx, state.Unit)
+			return true
+		})
+	}
+}
+
+// addControlWatcher listens on the control socket, the commands sent by other
+// on-host tools re-run or dry-run the managers, list their status, dump the agent's
+// state, list the scheduled jobs' status, list a user's metadata SSH keys and set
+// the log level.
+func addControlWatcher(ctx context.Context, eventManager *events.Manager) {
+	watcher := control.New(cfg.Get().Core.ControlSocketPath)
+	// sshd waits on the authorized keys queries, they're answered right away rather
+	// than behind the events being dispatched.
+	watcher.Handle(control.AuthorizedKeysCommand, func(_ context.Context, req control.Request) (string, error) {
+		return authorizedKeysForUser(req.Args["user"])
+	})
+	if err := eventManager.AddWatcher(ctx, watcher); err != nil {
+		logger.Errorf("Error adding control socket watcher: %v", err)
+		return
+	}
+
+	// controlCommand returns the event's command, nil if the watcher failed.
+	controlCommand := func(evType string, evData *events.EventData) *control.Command {
+		if evData.Error != nil {
+			logger.Errorf("Control socket watcher %q failed: %+v", evType, evData.Error)
+			return nil
+		}
+		cmd, _ := evData.Data.(*control.Command)
+		return cmd
+	}
+
+	eventManager.Subscribe(control.RerunEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		cmd := controlCommand(evType, evData)
+		if cmd == nil {
+			return true
+		}
+
+		if !inflight.begin() {
+			cmd.Reply("", errors.New("the agent is stopping"))
+			return true
+		}
+		defer inflight.end()
+
+		oldMd, newMd := metadataSnapshot()
+		if newMd == nil {
+			cmd.Reply("", errors.New("no metadata was fetched yet"))
+			return true
+		}
+
+		// The managers to re-run are optionally passed as a comma separated list.
+		var names []string
+		for _, curr := range strings.Split(cmd.Args["managers"], ",") {
+			if curr = strings.TrimSpace(curr); curr != "" {
+				names = append(names, curr)
+			}
+		}
+		managers, err := lookupManagers(names...)
+		if err != nil {
+			cmd.Reply("", err)
+			return true
+		}
+
+		events.Logf(ctx, logger.Info, "Re-running the managers (%s) as requested by the control socket.", managerNames(managers))
+		runManagers(ctx, managers, oldMd, newMd, applyOnDiff)
+		reportStatus(ctx, "configuration applied, watching metadata for changes")
+		cmd.Reply("", nil)
+		return true
+	})
+
+	eventManager.Subscribe(control.DryRunEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		cmd := controlCommand(evType, evData)
+		if cmd == nil {
+			return true
+		}
+
+		oldMd, newMd := metadataSnapshot()
+		if newMd == nil {
+			cmd.Reply("", errors.New("no metadata was fetched yet"))
+			return true
+		}
+
+		// The managers to dry-run are optionally passed as a comma separated list.
+		var names []string
+		for _, curr := range strings.Split(cmd.Args["managers"], ",") {
+			if curr = strings.TrimSpace(curr); curr != "" {
+				names = append(names, curr)
+			}
+		}
+		managers, err := lookupManagers(names...)
+		if err != nil {
+			cmd.Reply("", err)
+			return true
+		}
+
+		events.Logf(ctx, logger.Info, "Dry-running the managers (%s) as requested by the control socket.", managerNames(managers))
+		cmd.Reply(dryRunManagers(ctx, managers, oldMd, newMd), nil)
+		return true
+	})
+
+	eventManager.Subscribe(control.ManagerStatusEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if cmd := controlCommand(evType, evData); cmd != nil {
+			var res strings.Builder
+			for _, m := range managerRegistry {
+				fmt.Fprintf(&res, "%s: %s\n", m.name, m.status())
+			}
+			cmd.Reply(res.String(), nil)
+		}
+		return true
+	})
+
+	eventManager.Subscribe(control.DumpStateEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if cmd := controlCommand(evType, evData); cmd != nil {
+			cmd.Reply(dumpState(ctx), nil)
+		}
+		return true
+	})
+
+	eventManager.Subscribe(control.JobStatusEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if cmd := controlCommand(evType, evData); cmd != nil {
+			var res strings.Builder
+			for _, st := range scheduler.Get().JobStatuses() {
+				fmt.Fprintln(&res, st)
+			}
+			cmd.Reply(res.String(), nil)
+		}
+		return true
+	})
+
+	eventManager.Subscribe(control.LogLevelEvent, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		cmd := controlCommand(evType, evData)
+		if cmd == nil {
+			return true
+		}
+
+		switch level := cmd.Args["level"]; level {
+		case "debug", "info":
+			logger.SetDebugLogging(level == "debug")
+			events.Logf(ctx, logger.Info, "Log level set to %s as requested by the control socket.", level)
+			cmd.Reply("", nil)
+		default:
+			cmd.Reply("", fmt.Errorf("invalid log level %q, expected debug or info", level))
+		}
+		return true
+	})
+}
+
+// addInjectWatcher adds the event injection watcher, the injected events are logged,
+// the subsystems interested in them subscribe to inject.InjectedEvent.
+func addInjectWatcher(ctx context.Context, eventManager *events.Manager) {
+	var allowedUsers []string
+	for _, curr := range strings.Split(cfg.Get().Core.InjectAllowedUsers, ",") {
+		if curr = strings.TrimSpace(curr); curr != "" {
+			allowedUsers = append(allowedUsers, curr)
+		}
+	}
+
+	if err := eventManager.AddWatcher(ctx, inject.New(cfg.Get().Core.InjectSocketPath, allowedUsers)); err != nil {
+		logger.Errorf("Error adding event injection watcher: %v", err)
+		return
+	}
+
+	eventManager.SubscribePriority(inject.InjectedEvent, events.PriorityBestEffort, nil, func(ctx context.Context, evType string, data interface{}, evData *events.EventData) bool {
+		if evData.Error != nil {
+			events.Logf(ctx, logger.Error, "Event injection watcher failed: %+v", evData.Error)
+			return true
+		}
+		ev := evData.Data.(*inject.Event)
+		events.Logf(ctx, logger.Info, "Event %q injected by %s (%s)", ev.Name, ev.Source, ev.Peer)
+		return true
+	})
+}
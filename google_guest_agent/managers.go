@@ -0,0 +1,674 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// managerPriorityNetwork is the priority of the network manager, the other
+	// managers run once the network is set up.
+	managerPriorityNetwork = 100
+	// managerPriorityDefault is the priority of the other managers.
+	managerPriorityDefault = 0
+	// managerPriorityPlugins is the priority of the manager plugins, they run once
+	// the agent's own managers are done.
+	managerPriorityPlugins = -100
+	// defaultManagerTimeout is the manager timeout used if manager_timeout is invalid.
+	defaultManagerTimeout = 5 * time.Minute
+	// defaultManagerRetryMaxDelay is the maximum delay between the retries of a failed
+	// manager used if manager_retry_max_delay is invalid.
+	defaultManagerRetryMaxDelay = 30 * time.Minute
+)
+
+var (
+	// managerRetryInitialDelay is the delay before the first retry of a failed manager,
+	// doubled for each consecutive failure.
+	managerRetryInitialDelay = 30 * time.Second
+)
+
+// applyMode defines when a manager's configuration is applied, see apply().
+type applyMode int
+
+const (
+	// applyOnDiff applies the configuration if the manager reports a difference
+	// between the metadata, or a timeout.
+	applyOnDiff applyMode = iota
+	// applyForced applies the configuration even if the metadata didn't change,
+	// i.e. when retrying a failed manager.
+	applyForced
+	// applyOnDrift applies the configuration if the system drifted from it, the
+	// manager's DryRun() reports changes, i.e. routes removed by another software.
+	// The managers not implementing dryRunner aren't applied.
+	applyOnDrift
+)
+
+// dryRunner is implemented by the managers able to compute the changes they would
+// make, see dryRun().
+type dryRunner interface {
+	// DryRun returns the changes Set() would make, without applying them.
+	DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error)
+}
+
+// registeredManager is a manager registered by its stable name, see managerRegistry.
+type registeredManager struct {
+	// name is the manager's stable name, used by the [Managers] configuration
+	// section, the logs and the control socket's rerun-managers command.
+	name string
+	// newManager returns the manager to run, some managers take the metadata
+	// into account when created.
+	newManager func(md *metadata.Descriptor) manager
+	// priority orders the managers, all the managers of a higher priority
+	// complete before the lower priority ones start.
+	priority int
+	// after lists the names of the managers of the same priority this one runs
+	// after, when run together.
+	after []string
+	// timeout optionally overrides the configured manager_timeout.
+	timeout time.Duration
+
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// running is set while the manager runs, including a run given up after its
+	// timeout.
+	running bool
+	// lastRun is when the manager was last run, zero if never.
+	lastRun time.Time
+	// lastResult is the outcome of the manager's last run.
+	lastResult string
+	// lastDuration is how long the manager's last run took.
+	lastDuration time.Duration
+	// lastChanges summarizes the changes of the manager's last applied run, only
+	// known for the managers implementing dryRunner.
+	lastChanges string
+	// lastError is the manager's last failure, kept across the next runs.
+	lastError string
+	// lastErrorTime is when lastError happened.
+	lastErrorTime time.Time
+	// failures is the number of consecutive failed runs.
+	failures int
+	// retryCancel cancels the pending retry of the failed manager, nil if none.
+	retryCancel context.CancelFunc
+}
+
+// managerRegistry lists the managers of the current platform.
+var managerRegistry = registerManagers()
+
+// registerManagers returns the managers of the current platform.
+func registerManagers() []*registeredManager {
+	managers := []*registeredManager{
+		{name: "network", newManager: func(*metadata.Descriptor) manager { return addressManager }, priority: managerPriorityNetwork},
+		{name: "hostname", newManager: func(*metadata.Descriptor) manager { return &hostnameMgr{} }},
+		{name: "dns", newManager: func(*metadata.Descriptor) manager { return &dnsMgr{} }},
+	}
+
+	if runtime.GOOS == "windows" {
+		return append(managers,
+			&registeredManager{name: "wsfc", newManager: func(md *metadata.Descriptor) manager { return newWsfcManager(md) }},
+			&registeredManager{name: "windows-accounts", newManager: func(*metadata.Descriptor) manager { return &winAccountsMgr{} }},
+			&registeredManager{name: "diagnostics", newManager: func(*metadata.Descriptor) manager { return &diagnosticsMgr{} }},
+			&registeredManager{name: "dsc", newManager: func(*metadata.Descriptor) manager { return &dscMgr{} }},
+			&registeredManager{name: "scheduled-tasks", newManager: func(*metadata.Descriptor) manager { return &scheduledTasksMgr{} }},
+		)
+	}
+
+	return append(managers,
+		&registeredManager{name: "clockskew", newManager: func(*metadata.Descriptor) manager { return &clockskewMgr{} }},
+		&registeredManager{name: "oslogin", newManager: func(*metadata.Descriptor) manager { return &osloginMgr{} }},
+		// The accounts manager skips the users managed by OS Login.
+		&registeredManager{name: "accounts", newManager: func(*metadata.Descriptor) manager { return &accountsMgr{} }, after: []string{"oslogin"}},
+		&registeredManager{name: "scheduled-tasks", newManager: func(*metadata.Descriptor) manager { return &scheduledTasksMgr{} }},
+	)
+}
+
+// managerByName returns the registered manager named name, nil if not found.
+func managerByName(name string) *registeredManager {
+	idx := slices.IndexFunc(managerRegistry, func(m *registeredManager) bool { return m.name == name })
+	if idx < 0 {
+		return nil
+	}
+	return managerRegistry[idx]
+}
+
+// lookupManagers returns the registered managers named names, all of them if
+// names is empty.
+func lookupManagers(names ...string) ([]*registeredManager, error) {
+	if len(names) == 0 {
+		return managerRegistry, nil
+	}
+
+	var res []*registeredManager
+	for _, name := range names {
+		m := managerByName(name)
+		if m == nil {
+			return nil, fmt.Errorf("unknown manager %q", name)
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// configEnabled returns false if the manager is disabled in the [Managers]
+// configuration section.
+func (m *registeredManager) configEnabled() bool {
+	value, found := cfg.Get().Managers[m.name]
+	if !found {
+		return true
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Errorf("Invalid value %q for manager %q in the [Managers] configuration section, ignoring: %v", value, m.name, err)
+		return true
+	}
+	return enabled
+}
+
+// setResult records the outcome of a run of the manager.
+func (m *registeredManager) setResult(format string, args ...any) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastRun = time.Now()
+	m.lastResult = fmt.Sprintf(format, args...)
+}
+
+// setFailure records a failed run of the manager, the failure is kept as the last
+// error.
+func (m *registeredManager) setFailure(format string, args ...any) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastRun = time.Now()
+	m.lastResult = fmt.Sprintf(format, args...)
+	m.lastError = m.lastResult
+	m.lastErrorTime = m.lastRun
+}
+
+// setDuration records how long the manager's last run took.
+func (m *registeredManager) setDuration(duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastDuration = duration
+}
+
+// setChanges records the summary of the changes the manager is applying.
+func (m *registeredManager) setChanges(changes string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastChanges = changes
+}
+
+// status returns a human readable status of the manager's last run, i.e. "applied
+// at 10:02:03 in 1.2s, changes: create user foo, last error at 09:58:01: failed:
+// ...".
+func (m *registeredManager) status() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.lastRun.IsZero() {
+		return "never run"
+	}
+
+	res := fmt.Sprintf("%s at %s", m.lastResult, m.lastRun.Format(time.TimeOnly))
+	if m.lastDuration > 0 {
+		res += fmt.Sprintf(" in %s", m.lastDuration.Round(time.Millisecond))
+	}
+	if m.lastChanges != "" {
+		res += ", changes: " + m.lastChanges
+	}
+	if m.lastError != "" && m.lastErrorTime != m.lastRun {
+		res += fmt.Sprintf(", last error at %s: %s", m.lastErrorTime.Format(time.TimeOnly), m.lastError)
+	}
+	return res
+}
+
+// maxSummarizedChanges is the number of changes listed by summarizeChanges.
+const maxSummarizedChanges = 3
+
+// summarizeChanges returns a short summary of a manager's changes, i.e. "create user
+// foo, remove user bar and 3 more".
+func summarizeChanges(changes []string) string {
+	if len(changes) == 0 {
+		return "none"
+	}
+	if len(changes) <= maxSummarizedChanges {
+		return strings.Join(changes, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(changes[:maxSummarizedChanges], ", "), len(changes)-maxSummarizedChanges)
+}
+
+// managersStatus is the status of the managers, see registeredManager.status().
+type managersStatus []*registeredManager
+
+// String returns the managers' status on a single line, i.e. for the telemetry.
+func (ms managersStatus) String() string {
+	var res []string
+	for _, m := range ms {
+		res = append(res, fmt.Sprintf("%s: %s", m.name, m.status()))
+	}
+	return strings.Join(res, "; ")
+}
+
+// budget returns how long a run of the manager may take.
+func (m *registeredManager) budget() time.Duration {
+	if m.timeout > 0 {
+		return m.timeout
+	}
+
+	timeout, err := time.ParseDuration(cfg.Get().Core.ManagerTimeout)
+	if err != nil {
+		logger.Errorf("Invalid manager timeout %q, using %s: %v", cfg.Get().Core.ManagerTimeout, defaultManagerTimeout, err)
+		return defaultManagerTimeout
+	}
+	return timeout
+}
+
+// run runs the manager if enabled with the last applied and the last fetched
+// metadata, see execute().
+func (m *registeredManager) run(ctx context.Context, oldMd, newMd *metadata.Descriptor) {
+	m.execute(ctx, oldMd, newMd, applyOnDiff)
+}
+
+// execute runs the manager if enabled, its configuration is applied according to
+// mode, see apply(). The run is given up after the
+// manager's budget so a hung manager doesn't stall the other managers and the
+// next updates, the manager isn't run again until the hung run returns. A panic
+// is recovered and reported as a failure. A failed run is retried, see
+// scheduleRetry(), a newer run supersedes the pending retry.
+func (m *registeredManager) execute(ctx context.Context, oldMd, newMd *metadata.Descriptor, mode applyMode) {
+	m.cancelRetry()
+
+	if !m.configEnabled() {
+		events.Logf(ctx, logger.Debug, "Manager %q disabled in the configuration, skipping", m.name)
+		m.setResult("disabled in the configuration")
+		m.setDuration(0)
+		return
+	}
+
+	m.mutex.Lock()
+	if m.running {
+		m.mutex.Unlock()
+		events.Logf(ctx, logger.Error, "[%s] Manager's previous run is still running, skipping", m.name)
+		return
+	}
+	m.running = true
+	m.mutex.Unlock()
+
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	budget := m.budget()
+	if budget > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, budget)
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		defer func() { m.setDuration(time.Since(start)) }()
+		defer func() {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.running = false
+		}()
+		failed := true
+		defer func() { m.scheduleRetry(ctx, failed) }()
+		defer func() {
+			if r := recover(); r != nil {
+				events.Logf(ctx, logger.Error, "[%s] Manager panicked: %v\n%s", m.name, r, debug.Stack())
+				m.setFailure("panicked: %v", r)
+			}
+		}()
+
+		failed = !m.apply(runCtx, oldMd, newMd, mode)
+	}()
+
+	if budget <= 0 {
+		<-done
+		return
+	}
+
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		events.Logf(ctx, logger.Error, "[%s] Manager didn't complete within %s, giving up on it", m.name, budget)
+		m.setFailure("timed out after %s", budget)
+		m.setDuration(budget)
+		reportManagerStuck(ctx, m.name, true)
+		go func() {
+			<-done
+			events.Logf(ctx, logger.Info, "[%s] Manager's timed out run completed", m.name)
+			reportManagerStuck(ctx, m.name, false)
+		}()
+	}
+}
+
+// apply applies the manager's configuration if it's enabled, according to mode. It
+// returns false if the manager failed.
+func (m *registeredManager) apply(ctx context.Context, oldMd, newMd *metadata.Descriptor, mode applyMode) bool {
+	mgr := m.newManager(newMd)
+	disabled, err := mgr.Disabled(ctx, newMd)
+	if err != nil {
+		events.Logf(ctx, logger.Error, "[%s] Failed to run manager's Disabled() call: %+v", m.name, err)
+		m.setFailure("failed: %v", err)
+		return false
+	}
+
+	if disabled {
+		events.Logf(ctx, logger.Debug, "Manager %q disabled, skipping", m.name)
+		m.setResult("disabled")
+		return true
+	}
+
+	timeout, err := mgr.Timeout(ctx)
+	if err != nil {
+		events.Logf(ctx, logger.Error, "[%s] Failed to run manager Timeout() call: %+v", m.name, err)
+		m.setFailure("failed: %v", err)
+		return false
+	}
+
+	diff, err := mgr.Diff(ctx, oldMd, newMd)
+	if err != nil {
+		events.Logf(ctx, logger.Error, "[%s] Failed to run manager Diff() call: %+v", m.name, err)
+		m.setFailure("failed: %v", err)
+		return false
+	}
+
+	dr, canDryRun := mgr.(dryRunner)
+	var changes []string
+	switch mode {
+	case applyOnDiff:
+		if !timeout && !diff {
+			events.Logf(ctx, logger.Debug, "[%s] Manager reports no diff", m.name)
+			m.setResult("no changes")
+			return true
+		}
+	case applyOnDrift:
+		if !canDryRun {
+			events.Logf(ctx, logger.Debug, "[%s] Manager can't detect drift, not reconciling it", m.name)
+			return true
+		}
+		changes, err = dr.DryRun(ctx, oldMd, newMd)
+		if err != nil {
+			events.Logf(ctx, logger.Error, "[%s] Failed to run manager DryRun() call: %+v", m.name, err)
+			m.setFailure("failed: %v", err)
+			return false
+		}
+		if len(changes) == 0 {
+			events.Logf(ctx, logger.Debug, "[%s] Manager reports no drift", m.name)
+			m.setResult("no changes")
+			return true
+		}
+		events.Logf(ctx, logger.Info, "[%s] Configuration drifted, reconciling: %s", m.name, summarizeChanges(changes))
+	}
+
+	// Summarize the changes being applied, for the manager's status, a drift's
+	// changes are already known.
+	m.setChanges("")
+	if canDryRun {
+		if mode != applyOnDrift {
+			changes, err = dr.DryRun(ctx, oldMd, newMd)
+		}
+		if err != nil {
+			events.Logf(ctx, logger.Debug, "[%s] Failed to summarize the manager's changes: %v", m.name, err)
+		} else {
+			m.setChanges(summarizeChanges(changes))
+		}
+	}
+
+	events.Logf(ctx, logger.Debug, "Running manager %q", m.name)
+	if err := mgr.Set(ctx, oldMd, newMd); err != nil {
+		events.Logf(ctx, logger.Error, "[%s] Failed to run manager Set() call: %s", m.name, err)
+		m.setFailure("failed: %v", err)
+		return false
+	}
+	m.setResult("applied")
+	return true
+}
+
+// retryMaxDelay returns the maximum delay between the retries of a failed manager,
+// zero if the failed managers aren't retried.
+func retryMaxDelay() time.Duration {
+	delay, err := time.ParseDuration(cfg.Get().Core.ManagerRetryMaxDelay)
+	if err != nil {
+		logger.Errorf("Invalid manager retry max delay %q, using %s: %v", cfg.Get().Core.ManagerRetryMaxDelay, defaultManagerRetryMaxDelay, err)
+		return defaultManagerRetryMaxDelay
+	}
+	return delay
+}
+
+// cancelRetry cancels the manager's pending retry, if any.
+func (m *registeredManager) cancelRetry() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.retryCancel != nil {
+		m.retryCancel()
+		m.retryCancel = nil
+	}
+}
+
+// scheduleRetry retries the manager after a failed run so a transient failure, i.e.
+// a locked passwd file, doesn't wait for the next metadata change. The delay doubles
+// with each consecutive failure up to manager_retry_max_delay.
+func (m *registeredManager) scheduleRetry(ctx context.Context, failed bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !failed {
+		m.failures = 0
+		return
+	}
+	m.failures++
+
+	maxDelay := retryMaxDelay()
+	if maxDelay <= 0 || ctx.Err() != nil {
+		return
+	}
+	delay := maxDelay
+	// Avoid overflowing the shifted delay.
+	if m.failures < 32 {
+		delay = min(managerRetryInitialDelay<<(m.failures-1), maxDelay)
+	}
+
+	retryCtx, cancel := context.WithCancel(ctx)
+	m.retryCancel = cancel
+	events.Logf(ctx, logger.Info, "[%s] Manager failed %d time(s) in a row, retrying in %s", m.name, m.failures, delay)
+
+	go func() {
+		defer cancel()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-retryCtx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// The agent is stopping, don't start applying changes it may not finish.
+		if !inflight.begin() {
+			return
+		}
+		defer inflight.end()
+
+		if cfg.Get().Core.ManagersDryRun {
+			events.Logf(ctx, logger.Info, "[%s] Managers dry-run enabled, not retrying the manager", m.name)
+			return
+		}
+
+		// A newer run superseded the retry while it was waiting.
+		if retryCtx.Err() != nil {
+			return
+		}
+		events.Logf(ctx, logger.Info, "[%s] Retrying the failed manager", m.name)
+		oldMd, newMd := metadataSnapshot()
+		m.execute(ctx, oldMd, newMd, applyForced)
+	}()
+}
+
+// dryRun returns the changes the manager would make if enabled, without applying
+// them, the changes are logged. The managers not implementing dryRunner only report
+// whether they would apply their configuration. The manager's last result isn't
+// updated.
+func (m *registeredManager) dryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) (changes []string) {
+	if !m.configEnabled() {
+		return []string{"disabled in the configuration"}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			events.Logf(ctx, logger.Error, "[%s] Manager panicked during dry-run: %v\n%s", m.name, r, debug.Stack())
+			changes = []string{fmt.Sprintf("panicked: %v", r)}
+		}
+	}()
+
+	mgr := m.newManager(newMd)
+	disabled, err := mgr.Disabled(ctx, newMd)
+	if err != nil {
+		return []string{fmt.Sprintf("failed: %v", err)}
+	}
+	if disabled {
+		return []string{"disabled"}
+	}
+
+	if budget := m.budget(); budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	if dr, ok := mgr.(dryRunner); ok {
+		changes, err = dr.DryRun(ctx, oldMd, newMd)
+		if err != nil {
+			return []string{fmt.Sprintf("failed: %v", err)}
+		}
+	} else {
+		timeout, err := mgr.Timeout(ctx)
+		if err != nil {
+			return []string{fmt.Sprintf("failed: %v", err)}
+		}
+		diff, err := mgr.Diff(ctx, oldMd, newMd)
+		if err != nil {
+			return []string{fmt.Sprintf("failed: %v", err)}
+		}
+		if timeout || diff {
+			changes = []string{"would apply its configuration"}
+		}
+	}
+
+	if len(changes) == 0 {
+		return []string{"no changes"}
+	}
+	for _, change := range changes {
+		events.Logf(ctx, logger.Info, "[%s] Dry-run: %s", m.name, change)
+	}
+	return changes
+}
+
+// dryRunManagers returns a report of the changes managers would make from oldMd to
+// newMd, one manager at a time in their priority order, nothing is applied.
+func dryRunManagers(ctx context.Context, managers []*registeredManager, oldMd, newMd *metadata.Descriptor) string {
+	sorted := slices.Clone(managers)
+	slices.SortStableFunc(sorted, func(a, b *registeredManager) int { return b.priority - a.priority })
+
+	var res strings.Builder
+	for _, m := range sorted {
+		fmt.Fprintf(&res, "%s:\n", m.name)
+		for _, change := range m.dryRun(ctx, oldMd, newMd) {
+			fmt.Fprintf(&res, "  %s\n", change)
+		}
+	}
+	return res.String()
+}
+
+// runManagers runs managers with the oldMd and newMd metadata pair, their
+// configuration is applied according to mode. The managers run one priority class
+// at a time from the highest, the managers of a class run concurrently once the
+// managers they run after are done. The progress is reported to the service
+// manager.
+func runManagers(ctx context.Context, managers []*registeredManager, oldMd, newMd *metadata.Descriptor, mode applyMode) {
+	var doneMutex sync.Mutex
+	var done int
+	reportStatus(ctx, "applying configuration (0/%d managers done)", len(managers))
+
+	var priorities []int
+	for _, m := range managers {
+		if !slices.Contains(priorities, m.priority) {
+			priorities = append(priorities, m.priority)
+		}
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+
+	for _, priority := range priorities {
+		var wg sync.WaitGroup
+		finished := make(map[string]chan struct{})
+		for _, m := range managers {
+			if m.priority == priority {
+				finished[m.name] = make(chan struct{})
+			}
+		}
+
+		for _, m := range managers {
+			if m.priority != priority {
+				continue
+			}
+			wg.Add(1)
+			go func(m *registeredManager) {
+				defer wg.Done()
+				defer close(finished[m.name])
+
+				// The managers not run together aren't waited for.
+				for _, dep := range m.after {
+					if ch, found := finished[dep]; found {
+						<-ch
+					}
+				}
+				m.execute(ctx, oldMd, newMd, mode)
+
+				doneMutex.Lock()
+				defer doneMutex.Unlock()
+				done++
+				reportStatus(ctx, "applying configuration (%d/%d managers done)", done, len(managers))
+			}(m)
+		}
+		wg.Wait()
+	}
+}
+
+// managerNames returns the names of managers, i.e. for logging.
+func managerNames(managers []*registeredManager) string {
+	var names []string
+	for _, m := range managers {
+		names = append(names, m.name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,520 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestManagerRegistry(t *testing.T) {
+	names := make(map[string]*registeredManager)
+	for _, m := range managerRegistry {
+		if _, found := names[m.name]; found {
+			t.Errorf("Manager %q is registered more than once", m.name)
+		}
+		names[m.name] = m
+	}
+
+	for _, m := range managerRegistry {
+		for _, dep := range m.after {
+			other, found := names[dep]
+			if !found {
+				t.Errorf("Manager %q runs after unknown manager %q", m.name, dep)
+				continue
+			}
+			if other.priority != m.priority {
+				t.Errorf("Manager %q runs after manager %q of a different priority", m.name, dep)
+			}
+			if slices.Contains(other.after, m.name) {
+				t.Errorf("Managers %q and %q run after each other", m.name, dep)
+			}
+		}
+	}
+}
+
+// fakeManager records its Set() calls in the shared order.
+type fakeManager struct {
+	name  string
+	mutex *sync.Mutex
+	order *[]string
+}
+
+func (m *fakeManager) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return true, nil
+}
+func (m *fakeManager) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	return false, nil
+}
+func (m *fakeManager) Timeout(ctx context.Context) (bool, error) { return false, nil }
+
+func (m *fakeManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	// Let the managers not waiting for this one go first if they would.
+	time.Sleep(10 * time.Millisecond)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	*m.order = append(*m.order, m.name)
+	return nil
+}
+
+func TestRunManagersOrder(t *testing.T) {
+	reloadConfig(t, nil)
+	var mutex sync.Mutex
+	var order []string
+	mkmgr := func(name string, priority int, after ...string) *registeredManager {
+		return &registeredManager{
+			name:       name,
+			newManager: func(*metadata.Descriptor) manager { return &fakeManager{name: name, mutex: &mutex, order: &order} },
+			priority:   priority,
+			after:      after,
+		}
+	}
+
+	managers := []*registeredManager{
+		mkmgr("accounts", managerPriorityDefault, "oslogin"),
+		mkmgr("oslogin", managerPriorityDefault),
+		mkmgr("network", managerPriorityNetwork),
+	}
+	runManagers(context.Background(), managers, &metadata.Descriptor{}, &metadata.Descriptor{}, applyOnDiff)
+
+	if diff := cmp.Diff([]string{"network", "oslogin", "accounts"}, order); diff != "" {
+		t.Errorf("runManagers() ran the managers in unexpected order (-want +got):\n%s", diff)
+	}
+	for _, m := range managers {
+		if m.lastRun.IsZero() || m.lastResult != "applied" {
+			t.Errorf("Manager %q last run = %s %q, want applied", m.name, m.lastRun, m.lastResult)
+		}
+	}
+}
+
+func TestManagerConfigEnabled(t *testing.T) {
+	var tests = []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"not listed", []byte(""), true},
+		{"disabled", []byte("[Managers]\noslogin = false"), false},
+		{"enabled", []byte("[Managers]\noslogin = true"), true},
+		{"invalid value", []byte("[Managers]\noslogin = maybe"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloadConfig(t, tt.data)
+			m := &registeredManager{name: "oslogin"}
+			if got := m.configEnabled(); got != tt.want {
+				t.Errorf("configEnabled() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+	reloadConfig(t, nil)
+}
+
+func TestLookupManagers(t *testing.T) {
+	all, err := lookupManagers()
+	if err != nil {
+		t.Fatalf("lookupManagers() failed: %v", err)
+	}
+	if len(all) != len(managerRegistry) {
+		t.Errorf("lookupManagers() returned %d managers, want all %d", len(all), len(managerRegistry))
+	}
+
+	network, err := lookupManagers("network")
+	if err != nil {
+		t.Fatalf("lookupManagers(network) failed: %v", err)
+	}
+	if len(network) != 1 || network[0].name != "network" {
+		t.Errorf("lookupManagers(network) = %v, want the network manager", managerNames(network))
+	}
+
+	if _, err := lookupManagers("unknown"); err == nil {
+		t.Errorf("lookupManagers(unknown) succeeded, want error")
+	}
+}
+
+// hangingManager blocks its Set() calls until release is closed, or panics if
+// panics is set.
+type hangingManager struct {
+	release chan struct{}
+	panics  bool
+}
+
+func (m *hangingManager) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return true, nil
+}
+func (m *hangingManager) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	return false, nil
+}
+func (m *hangingManager) Timeout(ctx context.Context) (bool, error) { return false, nil }
+
+func (m *hangingManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	if m.panics {
+		panic("test panic")
+	}
+	<-m.release
+	return nil
+}
+
+func TestManagerTimeout(t *testing.T) {
+	reloadConfig(t, nil)
+	fakeServiceStatus(t, defaultSetServiceStatus)
+
+	hanging := &hangingManager{release: make(chan struct{})}
+	m := &registeredManager{name: "hanging", newManager: func(*metadata.Descriptor) manager { return hanging }, timeout: 10 * time.Millisecond}
+
+	// The hung run is given up and reported.
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+	if got := m.lastResult; got != "timed out after 10ms" {
+		t.Errorf("Hung manager's last result = %q, want %q", got, "timed out after 10ms")
+	}
+	statusMutex.Lock()
+	got := subsystemStatus["managers"]
+	statusMutex.Unlock()
+	if got != "stuck: hanging" {
+		t.Errorf("Reported managers status = %q, want %q", got, "stuck: hanging")
+	}
+
+	// The manager isn't run again while the hung run is still running.
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+	if got := m.lastResult; got != "timed out after 10ms" {
+		t.Errorf("Hung manager's last result = %q after a run while hung, want it unchanged", got)
+	}
+
+	// Wait for the abandoned run to return and report it's no longer stuck, the
+	// status is reset once the test is done.
+	close(hanging.release)
+	for i := 0; i < 100; i++ {
+		m.mutex.Lock()
+		running := m.running
+		m.mutex.Unlock()
+		statusMutex.Lock()
+		reported := subsystemStatus["managers"] == "ok"
+		statusMutex.Unlock()
+		if !running && reported {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lastResult != "applied" {
+		t.Errorf("Manager's last result = %q after the hung run returned, want %q", m.lastResult, "applied")
+	}
+}
+
+func TestManagerPanic(t *testing.T) {
+	reloadConfig(t, nil)
+	m := &registeredManager{name: "panicking", newManager: func(*metadata.Descriptor) manager { return &hangingManager{panics: true} }}
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+	if got := m.lastResult; got != "panicked: test panic" {
+		t.Errorf("Panicking manager's last result = %q, want %q", got, "panicked: test panic")
+	}
+}
+
+// dryRunManager reports changes in its dry-runs and fails if applied.
+type dryRunManager struct {
+	fakeManager
+	changes []string
+	t       *testing.T
+}
+
+func (m *dryRunManager) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	return m.changes, nil
+}
+
+func (m *dryRunManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	m.t.Errorf("Manager %q applied during a dry-run", m.name)
+	return nil
+}
+
+func TestDryRunManagers(t *testing.T) {
+	reloadConfig(t, []byte("[Managers]\nclockskew = false"))
+	defer reloadConfig(t, nil)
+
+	managers := []*registeredManager{
+		{
+			name: "accounts",
+			newManager: func(*metadata.Descriptor) manager {
+				return &dryRunManager{fakeManager: fakeManager{name: "accounts"}, changes: []string{"create user foo", "remove user bar"}, t: t}
+			},
+		},
+		{
+			name: "oslogin",
+			newManager: func(*metadata.Descriptor) manager {
+				return &dryRunManager{fakeManager: fakeManager{name: "oslogin"}, t: t}
+			},
+		},
+		{
+			name: "clockskew",
+			newManager: func(*metadata.Descriptor) manager {
+				return &dryRunManager{fakeManager: fakeManager{name: "clockskew"}, t: t}
+			},
+		},
+		{
+			name:       "network",
+			newManager: func(*metadata.Descriptor) manager { return &hangingManager{} },
+			priority:   managerPriorityNetwork,
+		},
+	}
+
+	want := "network:\n  would apply its configuration\n" +
+		"accounts:\n  create user foo\n  remove user bar\n" +
+		"oslogin:\n  no changes\n" +
+		"clockskew:\n  disabled in the configuration\n"
+	if diff := cmp.Diff(want, dryRunManagers(context.Background(), managers, &metadata.Descriptor{}, &metadata.Descriptor{})); diff != "" {
+		t.Errorf("dryRunManagers() returned unexpected report (-want +got):\n%s", diff)
+	}
+
+	for _, m := range managers {
+		if !m.lastRun.IsZero() {
+			t.Errorf("Manager %q last run recorded by a dry-run", m.name)
+		}
+	}
+}
+
+// failingManager fails its Set() calls while fail is set, reporting changes.
+type failingManager struct {
+	fakeManager
+	fail bool
+}
+
+func (m *failingManager) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	return []string{"create user a", "create user b", "create user c", "remove user d", "remove user e"}, nil
+}
+
+func (m *failingManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	if m.fail {
+		return errors.New("useradd failed")
+	}
+	return nil
+}
+
+func TestManagerStatus(t *testing.T) {
+	reloadConfig(t, nil)
+	mgr := &failingManager{fail: true}
+	m := &registeredManager{name: "accounts", newManager: func(*metadata.Descriptor) manager { return mgr }}
+
+	if got := m.status(); got != "never run" {
+		t.Errorf("status() = %q, want never run", got)
+	}
+
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+	if m.lastResult != "failed: useradd failed" || m.lastError != m.lastResult {
+		t.Errorf("Manager last result = %q, last error = %q, want the Set() failure", m.lastResult, m.lastError)
+	}
+
+	// Make sure the failed and the successful runs are recorded at different times.
+	time.Sleep(time.Millisecond)
+	mgr.fail = false
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+
+	if m.lastResult != "applied" {
+		t.Errorf("Manager last result = %q, want applied", m.lastResult)
+	}
+	if want := "create user a, create user b, create user c and 2 more"; m.lastChanges != want {
+		t.Errorf("Manager last changes = %q, want %q", m.lastChanges, want)
+	}
+	for _, want := range []string{"applied at ", ", changes: create user a", ", last error at ", ": failed: useradd failed"} {
+		if got := m.status(); !strings.Contains(got, want) {
+			t.Errorf("status() = %q, want it to contain %q", got, want)
+		}
+	}
+
+	if got := managersStatus([]*registeredManager{m, m}).String(); strings.Count(got, "accounts: applied at ") != 2 || strings.Contains(got, "\n") {
+		t.Errorf("managersStatus.String() = %q, want the status of both managers on a single line", got)
+	}
+}
+
+func TestSummarizeChanges(t *testing.T) {
+	tests := []struct {
+		changes []string
+		want    string
+	}{
+		{nil, "none"},
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b", "c"}, "a, b, c"},
+		{[]string{"a", "b", "c", "d"}, "a, b, c and 1 more"},
+	}
+
+	for _, tc := range tests {
+		if got := summarizeChanges(tc.changes); got != tc.want {
+			t.Errorf("summarizeChanges(%v) = %q, want %q", tc.changes, got, tc.want)
+		}
+	}
+}
+
+// flakyManager fails its first failures Set() calls.
+type flakyManager struct {
+	fakeManager
+	mutex    sync.Mutex
+	failures int
+	calls    int
+}
+
+func (m *flakyManager) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return false, nil
+}
+
+func (m *flakyManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.calls++
+	if m.calls <= m.failures {
+		return errors.New("passwd file locked")
+	}
+	return nil
+}
+
+func TestManagerRetry(t *testing.T) {
+	reloadConfig(t, nil)
+	oldDelay := managerRetryInitialDelay
+	managerRetryInitialDelay = time.Millisecond
+	t.Cleanup(func() { managerRetryInitialDelay = oldDelay })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &flakyManager{failures: 2}
+	m := &registeredManager{name: "accounts", newManager: func(*metadata.Descriptor) manager { return mgr }}
+	// The manager reports no diff, the retries apply it anyway.
+	m.execute(ctx, &metadata.Descriptor{}, &metadata.Descriptor{}, applyForced)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mutex.Lock()
+		result, failures := m.lastResult, m.failures
+		m.mutex.Unlock()
+		if result == "applied" && failures == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if mgr.calls != 3 {
+		t.Errorf("Manager's Set() called %d times, want 3", mgr.calls)
+	}
+	if m.lastResult != "applied" {
+		t.Errorf("Manager last result = %q, want applied", m.lastResult)
+	}
+}
+
+func TestManagerRetrySuperseded(t *testing.T) {
+	reloadConfig(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &failingManager{fail: true}
+	m := &registeredManager{name: "accounts", newManager: func(*metadata.Descriptor) manager { return mgr }}
+	m.run(ctx, &metadata.Descriptor{}, &metadata.Descriptor{})
+
+	m.mutex.Lock()
+	pending := m.retryCancel != nil
+	m.mutex.Unlock()
+	if !pending {
+		t.Fatalf("No retry pending after the manager failed")
+	}
+
+	mgr.fail = false
+	m.run(ctx, &metadata.Descriptor{}, &metadata.Descriptor{})
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.retryCancel != nil || m.failures != 0 {
+		t.Errorf("Retry still pending after a successful run, failures = %d", m.failures)
+	}
+}
+
+func TestManagerRetryDisabled(t *testing.T) {
+	reloadConfig(t, []byte("[Core]\nmanager_retry_max_delay = 0s"))
+	defer reloadConfig(t, nil)
+
+	m := &registeredManager{name: "accounts", newManager: func(*metadata.Descriptor) manager { return &failingManager{fail: true} }}
+	m.run(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.retryCancel != nil {
+		t.Errorf("Retry pending with manager_retry_max_delay = 0s")
+	}
+	if m.failures != 1 {
+		t.Errorf("Manager failures = %d, want 1", m.failures)
+	}
+}
+
+// driftingManager records its Set() calls, reporting changes if the system drifted.
+type driftingManager struct {
+	fakeManager
+	changes []string
+}
+
+func (m *driftingManager) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	return m.changes, nil
+}
+
+func TestManagerReconcile(t *testing.T) {
+	reloadConfig(t, nil)
+	var mutex sync.Mutex
+	var order []string
+
+	managers := []*registeredManager{
+		{
+			name: "network",
+			newManager: func(*metadata.Descriptor) manager {
+				return &driftingManager{fakeManager: fakeManager{name: "network", mutex: &mutex, order: &order}, changes: []string{"add route 10.0.0.1"}}
+			},
+		},
+		{
+			name: "accounts",
+			newManager: func(*metadata.Descriptor) manager {
+				return &driftingManager{fakeManager: fakeManager{name: "accounts", mutex: &mutex, order: &order}}
+			},
+		},
+		{
+			name: "clockskew",
+			newManager: func(*metadata.Descriptor) manager {
+				return &fakeManager{name: "clockskew", mutex: &mutex, order: &order}
+			},
+		},
+	}
+
+	md := &metadata.Descriptor{}
+	runManagers(context.Background(), managers, md, md, applyOnDrift)
+
+	if diff := cmp.Diff([]string{"network"}, order); diff != "" {
+		t.Errorf("runManagers(applyOnDrift) applied unexpected managers (-want +got):\n%s", diff)
+	}
+
+	wantResults := map[string]string{"network": "applied", "accounts": "no changes", "clockskew": ""}
+	for _, m := range managers {
+		if m.lastResult != wantResults[m.name] {
+			t.Errorf("Manager %q last result = %q, want %q", m.name, m.lastResult, wantResults[m.name])
+		}
+	}
+	if want := "add route 10.0.0.1"; managers[0].lastChanges != want {
+		t.Errorf("Manager last changes = %q, want %q", managers[0].lastChanges, want)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// metadataCacheFile is the file the last fetched metadata descriptor is cached
+	// to, it's used at startup if the metadata server is unreachable.
+	metadataCacheFile = defaultMetadataCacheFile()
+)
+
+func defaultMetadataCacheFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "Google", "Compute Engine", "guest-agent-metadata.json")
+	}
+	return "/var/lib/google/guest-agent-metadata.json"
+}
+
+// initMetadataCache enables or disables the metadata descriptor cache as
+// configured, a disabled cache is also removed from disk.
+func initMetadataCache() {
+	if cfg.Get().Core.MetadataCacheEnabled {
+		metadata.SetCacheFile(metadataCacheFile)
+		return
+	}
+
+	metadata.SetCacheFile("")
+	if err := os.Remove(metadataCacheFile); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("Failed to remove metadata cache: %v", err)
+	}
+}
+
+// cachedMetadata returns the last-known-good metadata descriptor, used when the
+// metadata server is unreachable at startup. It returns nil if there's none.
+func cachedMetadata() *metadata.Descriptor {
+	md, err := metadata.CachedDescriptor()
+	if err != nil {
+		logger.Debugf("No cached metadata available: %v", err)
+		return nil
+	}
+
+	logger.Warningf("Metadata server unreachable, applying the cached metadata until it's reachable again.")
+	return md
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+func TestMetadataCache(t *testing.T) {
+	oldFile := metadataCacheFile
+	t.Cleanup(func() {
+		metadataCacheFile = oldFile
+		metadata.SetCacheFile("")
+		reloadConfig(t, nil)
+	})
+	metadataCacheFile = filepath.Join(t.TempDir(), "guest-agent-metadata.json")
+
+	if err := utils.WriteFile([]byte(`{"instance":{"id":1234}}`), metadataCacheFile, 0600); err != nil {
+		t.Fatalf("Failed to write metadata cache: %v", err)
+	}
+
+	reloadConfig(t, nil)
+	initMetadataCache()
+
+	md := cachedMetadata()
+	if md == nil || md.Instance.ID.String() != "1234" {
+		t.Fatalf("cachedMetadata() = %+v, want the cached descriptor", md)
+	}
+
+	reloadConfig(t, []byte("[Core]\nmetadata_cache_enabled = false"))
+	initMetadataCache()
+
+	if md := cachedMetadata(); md != nil {
+		t.Errorf("cachedMetadata() = %+v with the cache disabled, want nil", md)
+	}
+	if utils.FileExists(metadataCacheFile, utils.TypeFile) {
+		t.Errorf("initMetadataCache() didn't remove the disabled cache %q", metadataCacheFile)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// applyMTU sets the MTU of md's NICs to their metadata MTU, the NICs not found
+// are skipped.
+func applyMTU(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) {
+	if !config.NetworkInterfaces.ManageMTU {
+		return
+	}
+
+	for _, ni := range md.Instance.NetworkInterfaces {
+		iface, err := network.GetInterfaceByMAC(ni.Mac)
+		if err != nil {
+			logger.Debugf("Skipping the MTU of %s: %v", ni.Mac, err)
+			continue
+		}
+		if err := setInterfaceMTU(ctx, iface, ni.MTU); err != nil {
+			logger.Errorf("Failed to set the MTU of %s: %v", iface.Name, err)
+		}
+	}
+}
+
+// setInterfaceMTU sets the MTU of iface to mtu, unless it's unset in the metadata
+// or already set.
+func setInterfaceMTU(ctx context.Context, iface net.Interface, mtu int) error {
+	if mtu <= 0 || iface.MTU == mtu {
+		return nil
+	}
+	logger.Infof("Setting the MTU of %s from %d to %d.", iface.Name, iface.MTU, mtu)
+	return setLinkMTU(ctx, iface, mtu)
+}
+
+// linkChanged re-applies the NICs' MTU after a link change, i.e. a link flap
+// resetting it.
+func (a *addressMgr) linkChanged(ctx context.Context, evType string, evData *events.EventData) {
+	if evData.Error != nil {
+		logger.Debugf("Network change watcher %q failed: %+v", evType, evData.Error)
+		return
+	}
+
+	md := a.reapplyMetadata(ctx)
+	if md == nil {
+		return
+	}
+	applyMTU(ctx, cfg.Get(), md)
+}
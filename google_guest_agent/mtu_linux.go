@@ -0,0 +1,40 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setLinkMTU sets the MTU of iface with a RTM_NEWLINK request, as "ip link set"
+// does.
+func setLinkMTU(_ context.Context, iface net.Interface, mtu int) error {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:8], uint32(iface.Index))
+
+	value := make([]byte, 4)
+	binary.NativeEndian.PutUint32(value, uint32(mtu))
+	msg = append(msg, rtAttr(unix.IFLA_MTU, value)...)
+
+	_, err := rtnetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK, msg)
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetInterfaceMTU(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Setting the MTU requires root")
+	}
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+	ctx := context.Background()
+
+	want := lo.MTU - 1
+	if err := setInterfaceMTU(ctx, *lo, want); err != nil {
+		if errors.Is(err, unix.EPERM) {
+			t.Skipf("Not allowed to set the MTU: %v", err)
+		}
+		t.Fatalf("setInterfaceMTU(lo, %d) failed: %v", want, err)
+	}
+	defer setLinkMTU(ctx, *lo, lo.MTU)
+
+	got, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatalf("net.InterfaceByName(lo) failed: %v", err)
+	}
+	if got.MTU != want {
+		t.Errorf("setInterfaceMTU(lo, %d) set the MTU to %d", want, got.MTU)
+	}
+
+	// Unset or already set MTUs are skipped.
+	for _, mtu := range []int{0, got.MTU} {
+		if err := setInterfaceMTU(ctx, net.Interface{Name: "nonexistent", Index: -1, MTU: got.MTU}, mtu); err != nil {
+			t.Errorf("setInterfaceMTU(%d) = %v, want nil", mtu, err)
+		}
+	}
+}
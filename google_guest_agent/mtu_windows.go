@@ -0,0 +1,38 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// setLinkMTU sets the IPv4 and IPv6 MTU of iface with netsh, the change persists
+// across reboots.
+func setLinkMTU(ctx context.Context, iface net.Interface, mtu int) error {
+	mtuArg := fmt.Sprintf("mtu=%d", mtu)
+	if err := run.Quiet(ctx, "netsh", "interface", "ipv4", "set", "subinterface", iface.Name, mtuArg, "store=persistent"); err != nil {
+		return fmt.Errorf("failed to set the IPv4 MTU: %w", err)
+	}
+	// IPv6 may be disabled on the interface.
+	if err := run.Quiet(ctx, "netsh", "interface", "ipv6", "set", "subinterface", iface.Name, mtuArg, "store=persistent"); err != nil {
+		logger.Debugf("Failed to set the IPv6 MTU of %s: %v", iface.Name, err)
+	}
+	return nil
+}
@@ -232,6 +232,10 @@ func SetupInterfaces(ctx context.Context, config *cfg.Sections, mds *metadata.De
 		return fmt.Errorf("manager(%s): error setting up ethernet interfaces: %v", activeService.manager.Name(), err)
 	}
 
+	if err := setupPolicyRouting(ctx, config, nics, interfaces); err != nil {
+		logger.Errorf("Failed to set up policy routing: %v", err)
+	}
+
 	if config.NetworkInterfaces.VlanSetupEnabled {
 		logger.Infof("VLAN setup is enabled via config file, setting up interfaces")
 		if err := reformatVlanNics(mds, nics, interfaces); err != nil {
@@ -601,6 +601,8 @@ func (n *netplan) rollbackVlanNics(ctx context.Context, nics *Interfaces) (bool,
 		}
 	}
 
+	// The VLAN NICs are a map, delete them in a stable order.
+	slices.Sort(deleteNics)
 	logger.Infof("Deleting VLAN NICs: %v", deleteNics)
 	// Simply removing configs on disk and reloading netplan/networkctl doesn't remove
 	// existing vlan nics, it requires instance reboot or systemd-networkd restart. Instead,
@@ -0,0 +1,272 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// policyRoutingTablesFile names the routing tables set up by the agent, it also
+	// records them to clean them up once no longer wanted.
+	policyRoutingTablesFile = "/etc/iproute2/rt_tables.d/google-guest-agent.conf"
+)
+
+// policyRoute describes the routing table of a secondary NIC.
+type policyRoute struct {
+	// iface is the NIC's interface name.
+	iface string
+	// table is the NIC's routing table.
+	table int
+	// source is the NIC's subnet, the traffic from it looks up the table.
+	source string
+	// gateway is the NIC's gateway, the table's default route.
+	gateway string
+	// proto is the protocol identifying the routes added by the agent, the table's
+	// routes added by an administrator are left alone.
+	proto string
+}
+
+// policyRoutes returns the routing tables of the secondary NICs of nics, interfaces
+// are their interface names. The NICs missing their address or gateway are skipped.
+func policyRoutes(config *cfg.Sections, nics *Interfaces, interfaces []string) []policyRoute {
+	var res []policyRoute
+	for i, ni := range nics.EthernetInterfaces {
+		// The primary NIC uses the main table.
+		if i == 0 || i >= len(interfaces) || strings.HasPrefix(interfaces[i], "invalid-") {
+			continue
+		}
+
+		ip := net.ParseIP(ni.IP).To4()
+		gateway := net.ParseIP(ni.Gateway).To4()
+		if ip == nil || gateway == nil {
+			logger.Debugf("Skipping policy routing of %s, missing address (%q) or gateway (%q)", interfaces[i], ni.IP, ni.Gateway)
+			continue
+		}
+
+		source := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+		if mask := net.ParseIP(ni.Subnetmask).To4(); mask != nil {
+			source = &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+		}
+
+		res = append(res, policyRoute{
+			iface:   interfaces[i],
+			table:   config.NetworkInterfaces.PolicyRoutingTableBase + i,
+			source:  source.String(),
+			gateway: gateway.String(),
+			proto:   config.IPForwarding.EthernetProtoID,
+		})
+	}
+	return res
+}
+
+// setupPolicyRouting sets up the routing tables and rules of the secondary NICs if
+// enabled, and removes the ones previously set up no longer wanted.
+func setupPolicyRouting(ctx context.Context, config *cfg.Sections, nics *Interfaces, interfaces []string) error {
+	var routes []policyRoute
+	if config.NetworkInterfaces.PolicyRouting {
+		routes = policyRoutes(config, nics, interfaces)
+	}
+
+	var tables []int
+	for _, r := range routes {
+		tables = append(tables, r.table)
+	}
+
+	for _, table := range readPolicyRoutingTables() {
+		if slices.Contains(tables, table) {
+			continue
+		}
+		logger.Infof("Removing policy routing table %d", table)
+		if err := flushPolicyRoutingTable(ctx, table, config.IPForwarding.EthernetProtoID); err != nil {
+			logger.Errorf("Failed to remove policy routing table %d: %v", table, err)
+		}
+	}
+
+	var errs []string
+	for _, r := range routes {
+		if err := applyPolicyRoute(ctx, r); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", r.iface, err))
+		}
+	}
+
+	if err := writePolicyRoutingTables(routes); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to set up policy routing: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// applyPolicyRoute sets up the routing table of r and its source rule, the table's
+// rules of other sources are removed.
+func applyPolicyRoute(ctx context.Context, r policyRoute) error {
+	table := strconv.Itoa(r.table)
+
+	// The gateway is not in the NIC's (usually /32) subnet, route it on link first.
+	if err := run.Quiet(ctx, "ip", "-4", "route", "replace", r.gateway, "dev", r.iface, "scope", "link", "table", table, "proto", r.proto); err != nil {
+		return fmt.Errorf("failed to route gateway %s: %w", r.gateway, err)
+	}
+	if err := run.Quiet(ctx, "ip", "-4", "route", "replace", "default", "via", r.gateway, "dev", r.iface, "table", table, "proto", r.proto); err != nil {
+		return fmt.Errorf("failed to set up default route: %w", err)
+	}
+
+	kept, err := flushPolicyRoutingRules(ctx, r.table, []string{r.source})
+	if err != nil {
+		return err
+	}
+	if len(kept) > 0 {
+		return nil
+	}
+
+	logger.Infof("Adding policy routing rule from %s to table %d (%s)", r.source, r.table, r.iface)
+	if err := run.Quiet(ctx, "ip", "-4", "rule", "add", "from", r.source, "table", table); err != nil {
+		return fmt.Errorf("failed to add rule from %s: %w", r.source, err)
+	}
+	return nil
+}
+
+// flushPolicyRoutingTable removes the rules of table and its routes added with
+// proto.
+func flushPolicyRoutingTable(ctx context.Context, table int, proto string) error {
+	if _, err := flushPolicyRoutingRules(ctx, table, nil); err != nil {
+		return err
+	}
+	if err := run.Quiet(ctx, "ip", "-4", "route", "flush", "table", strconv.Itoa(table), "proto", proto); err != nil {
+		return fmt.Errorf("failed to flush routes: %w", err)
+	}
+	return nil
+}
+
+// flushPolicyRoutingRules removes the rules looking up table whose source is not
+// in keep, it returns the sources of the rules kept.
+func flushPolicyRoutingRules(ctx context.Context, table int, keep []string) ([]string, error) {
+	sources, err := policyRoutingRules(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	for _, source := range sources {
+		if slices.Contains(keep, source) {
+			kept = append(kept, source)
+			continue
+		}
+		if err := run.Quiet(ctx, "ip", "-4", "rule", "del", "from", source, "table", strconv.Itoa(table)); err != nil {
+			return nil, fmt.Errorf("failed to remove rule from %s: %w", source, err)
+		}
+	}
+	return kept, nil
+}
+
+// policyRoutingRules returns the sources of the rules looking up table, in CIDR
+// notation.
+func policyRoutingRules(ctx context.Context, table int) ([]string, error) {
+	res := run.WithOutput(ctx, "ip", "-4", "rule", "show", "table", strconv.Itoa(table))
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("failed to list rules of table %d: %s", table, res.Error())
+	}
+	return parsePolicyRoutingRules(res.StdOut), nil
+}
+
+// parsePolicyRoutingRules parses the sources of the "ip rule show" output, i.e.
+// "32765:	from 10.0.1.0/24 lookup 101".
+func parsePolicyRoutingRules(out string) []string {
+	var sources []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		i := slices.Index(fields, "from")
+		if i < 0 || i+1 >= len(fields) || fields[i+1] == "all" {
+			continue
+		}
+
+		source := fields[i+1]
+		if !strings.Contains(source, "/") {
+			source += "/32"
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// readPolicyRoutingTables returns the routing tables recorded in the tables file.
+func readPolicyRoutingTables() []int {
+	data, err := os.ReadFile(policyRoutingTablesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warningf("Failed to read %s: %v", policyRoutingTablesFile, err)
+		}
+		return nil
+	}
+
+	var tables []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if table, err := strconv.Atoi(fields[0]); err == nil {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// writePolicyRoutingTables names the routing tables of routes "gce-<iface>" in the
+// tables file, it's removed if routes is empty.
+func writePolicyRoutingTables(routes []policyRoute) error {
+	if len(routes) == 0 {
+		if err := os.Remove(policyRoutingTablesFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", policyRoutingTablesFile, err)
+		}
+		return nil
+	}
+
+	// Older iproute2 versions don't read the tables directory, the tables are still
+	// usable by number.
+	if _, err := os.Stat(filepath.Dir(policyRoutingTablesFile)); err != nil {
+		return nil
+	}
+
+	var buf strings.Builder
+	fmt.Fprintln(&buf, googleComment)
+	for _, r := range routes {
+		fmt.Fprintf(&buf, "%d\tgce-%s\n", r.table, r.iface)
+	}
+
+	if err := utils.WriteFile([]byte(buf.String()), policyRoutingTablesFile, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", policyRoutingTablesFile, err)
+	}
+	return nil
+}
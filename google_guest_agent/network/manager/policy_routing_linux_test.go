@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockPolicyRoutingRunner struct {
+	// rules are the "ip rule show" outputs by table.
+	rules            map[string]string
+	executedCommands []string
+}
+
+func (m *mockPolicyRoutingRunner) Quiet(ctx context.Context, name string, args ...string) error {
+	m.executedCommands = append(m.executedCommands, name+" "+strings.Join(args, " "))
+	return nil
+}
+
+func (m *mockPolicyRoutingRunner) WithOutput(ctx context.Context, name string, args ...string) *run.Result {
+	return &run.Result{StdOut: m.rules[args[len(args)-1]]}
+}
+
+func (m *mockPolicyRoutingRunner) WithOutputTimeout(ctx context.Context, timeout time.Duration, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func (m *mockPolicyRoutingRunner) WithCombinedOutput(ctx context.Context, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func TestPolicyRoutes(t *testing.T) {
+	if err := cfg.Load(nil); err != nil {
+		t.Fatalf("cfg.Load(nil) = %v, want nil", err)
+	}
+
+	nics := &Interfaces{
+		EthernetInterfaces: []metadata.NetworkInterfaces{
+			{IP: "10.0.0.2", Gateway: "10.0.0.1", Subnetmask: "255.255.255.0"},
+			{IP: "10.0.1.2", Gateway: "10.0.1.1", Subnetmask: "255.255.255.0"},
+			{IP: "10.0.2.2", Gateway: "10.0.2.1"},
+			{IP: "10.0.3.2"},
+			{IP: "10.0.4.2", Gateway: "10.0.4.1"},
+		},
+	}
+	interfaces := []string{"eth0", "eth1", "eth2", "eth3", "invalid-mac"}
+
+	want := []policyRoute{
+		{iface: "eth1", table: 101, source: "10.0.1.0/24", gateway: "10.0.1.1", proto: "66"},
+		{iface: "eth2", table: 102, source: "10.0.2.2/32", gateway: "10.0.2.1", proto: "66"},
+	}
+	got := policyRoutes(cfg.Get(), nics, interfaces)
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(policyRoute{})); diff != "" {
+		t.Errorf("policyRoutes() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestParsePolicyRoutingRules(t *testing.T) {
+	out := "0:\tfrom all lookup local\n32764:\tfrom 10.0.1.0/24 lookup gce-eth1\n32765:\tfrom 10.0.1.9 lookup 101\n\n"
+
+	want := []string{"10.0.1.0/24", "10.0.1.9/32"}
+	if diff := cmp.Diff(want, parsePolicyRoutingRules(out)); diff != "" {
+		t.Errorf("parsePolicyRoutingRules(%q) returned unexpected diff (-want +got):\n%s", out, diff)
+	}
+}
+
+func TestSetupPolicyRouting(t *testing.T) {
+	tablesFile := filepath.Join(t.TempDir(), "google-guest-agent.conf")
+	orig := policyRoutingTablesFile
+	policyRoutingTablesFile = tablesFile
+	t.Cleanup(func() { policyRoutingTablesFile = orig })
+
+	if err := os.WriteFile(tablesFile, []byte(googleComment+"\n101\tgce-eth1\n102\tgce-eth2\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%s) = %v, want nil", tablesFile, err)
+	}
+
+	origRunner := run.Client
+	t.Cleanup(func() { run.Client = origRunner })
+	runner := &mockPolicyRoutingRunner{
+		rules: map[string]string{
+			"101": "32764:\tfrom 10.0.1.0/24 lookup 101\n32765:\tfrom 10.0.9.9 lookup 101\n",
+			"102": "32763:\tfrom 10.0.2.0/24 lookup 102\n",
+		},
+	}
+	run.Client = runner
+
+	if err := cfg.Load([]byte("[NetworkInterfaces]\npolicy_routing = true")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+
+	nics := &Interfaces{
+		EthernetInterfaces: []metadata.NetworkInterfaces{
+			{IP: "10.0.0.2", Gateway: "10.0.0.1", Subnetmask: "255.255.255.0"},
+			{IP: "10.0.1.2", Gateway: "10.0.1.1", Subnetmask: "255.255.255.0"},
+		},
+	}
+	if err := setupPolicyRouting(context.Background(), cfg.Get(), nics, []string{"eth0", "eth1"}); err != nil {
+		t.Fatalf("setupPolicyRouting() = %v, want nil", err)
+	}
+
+	wantCommands := []string{
+		"ip -4 rule del from 10.0.2.0/24 table 102",
+		"ip -4 route flush table 102 proto 66",
+		"ip -4 route replace 10.0.1.1 dev eth1 scope link table 101 proto 66",
+		"ip -4 route replace default via 10.0.1.1 dev eth1 table 101 proto 66",
+		"ip -4 rule del from 10.0.9.9/32 table 101",
+	}
+	if diff := cmp.Diff(wantCommands, runner.executedCommands); diff != "" {
+		t.Errorf("setupPolicyRouting() ran unexpected commands (-want +got):\n%s", diff)
+	}
+
+	data, err := os.ReadFile(tablesFile)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%s) = %v, want nil", tablesFile, err)
+	}
+	if diff := cmp.Diff(googleComment+"\n101\tgce-eth1\n", string(data)); diff != "" {
+		t.Errorf("setupPolicyRouting() wrote unexpected tables (-want +got):\n%s", diff)
+	}
+
+	// Disabling it removes the tables.
+	if err := cfg.Load(nil); err != nil {
+		t.Fatalf("cfg.Load(nil) = %v, want nil", err)
+	}
+	runner.executedCommands = nil
+	runner.rules = map[string]string{"101": "32764:\tfrom 10.0.1.0/24 lookup gce-eth1\n"}
+	if err := setupPolicyRouting(context.Background(), cfg.Get(), nics, []string{"eth0", "eth1"}); err != nil {
+		t.Fatalf("setupPolicyRouting() = %v, want nil", err)
+	}
+
+	wantCommands = []string{
+		"ip -4 rule del from 10.0.1.0/24 table 101",
+		"ip -4 route flush table 101 proto 66",
+	}
+	if diff := cmp.Diff(wantCommands, runner.executedCommands); diff != "" {
+		t.Errorf("setupPolicyRouting() ran unexpected commands (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(tablesFile); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v, want not exist", tablesFile, err)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+const (
+	// RoutesModeImperative programs the forwarded IPs local routes directly, the
+	// default mode.
+	RoutesModeImperative = "imperative"
+	// RoutesModeNetworkd renders the forwarded IPs local routes as systemd-networkd
+	// drop-ins of the interfaces' network files.
+	RoutesModeNetworkd = "networkd"
+	// RoutesModeNetplan renders the forwarded IPs local routes as a netplan drop-in.
+	RoutesModeNetplan = "netplan"
+	// RoutesModeNetworkManager sets the forwarded IPs local routes in the NetworkManager
+	// connections.
+	RoutesModeNetworkManager = "networkmanager"
+)
+
+// RendersRoutes returns true if the forwarded IPs local routes are rendered as
+// configuration files in mode instead of programmed directly.
+func RendersRoutes(mode string) bool {
+	return mode == RoutesModeNetworkd || mode == RoutesModeNetplan || mode == RoutesModeNetworkManager
+}
@@ -0,0 +1,313 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// routesDropinName is the name of the routes drop-ins.
+	routesDropinName = "google-guest-agent-routes"
+	// localRouteTable is the kernel's local routing table, netplan doesn't add the
+	// local routes to it by default.
+	localRouteTable = 255
+)
+
+var (
+	// networkdRuntimeDir is where the systemd-networkd routes drop-ins are written.
+	networkdRuntimeDir = "/run/systemd/network"
+	// netplanRuntimeDir is where the netplan routes drop-in is written.
+	netplanRuntimeDir = "/run/netplan"
+	// networkFileOf returns the network file systemd-networkd configured an
+	// interface with, tests replace it.
+	networkFileOf = networkdNetworkFile
+)
+
+// netplanRoutesDropin maps the netplan routes drop-in.
+type netplanRoutesDropin struct {
+	Network netplanRoutesNetwork `yaml:"network"`
+}
+
+// netplanRoutesNetwork is the netplan routes drop-in's network section.
+type netplanRoutesNetwork struct {
+	// Version is the netplan's drop-in format version.
+	Version int `yaml:"version"`
+
+	// Ethernets are the ethernet interfaces' routes entries map.
+	Ethernets map[string]netplanRoutesEthernet `yaml:"ethernets"`
+}
+
+// netplanRoutesEthernet describes an ethernet interface's routes, it's merged with
+// the interface's other configurations sharing its ID.
+type netplanRoutesEthernet struct {
+	// Match is the interface's matching rule.
+	Match netplanMatch `yaml:"match"`
+
+	// Routes are the interface's local routes.
+	Routes []netplanRoute `yaml:"routes"`
+}
+
+// netplanRoute describes a netplan route. Refer
+// https://netplan.readthedocs.io/en/stable/netplan-yaml/#routing for more details.
+type netplanRoute struct {
+	// To is the route's destination.
+	To string `yaml:"to"`
+	// Type is the route's type, i.e. local.
+	Type string `yaml:"type"`
+	// Scope is the route's scope, i.e. host.
+	Scope string `yaml:"scope"`
+	// Table is the routing table the route is added to.
+	Table int `yaml:"table"`
+}
+
+// RenderLocalRoutes renders the forwarded IPs local routes as mode's configuration
+// files, or NetworkManager connections, and reloads them if they changed, routes
+// maps the interface names to their IPs in CIDR notation and protoID identifies the
+// agent's routes. The files previously rendered and no longer wanted, i.e. all of
+// them in the imperative mode, are removed. It returns the files rendered.
+func RenderLocalRoutes(ctx context.Context, mode, protoID string, routes map[string][]string) ([]string, error) {
+	var files []string
+	var changed []string
+	var errs []error
+
+	switch mode {
+	case RoutesModeNetworkd:
+		for _, iface := range slices.Sorted(maps.Keys(routes)) {
+			if len(routes[iface]) == 0 {
+				continue
+			}
+			file, wrote, err := writeNetworkdRoutes(ctx, protoID, iface, routes[iface])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", iface, err))
+				continue
+			}
+			files = append(files, file)
+			if wrote {
+				changed = append(changed, iface)
+			}
+		}
+	case RoutesModeNetplan:
+		file, wrote, err := writeNetplanRoutes(routes)
+		if err != nil {
+			errs = append(errs, err)
+		} else if file != "" {
+			files = append(files, file)
+			if wrote {
+				changed = slices.Sorted(maps.Keys(routes))
+			}
+		}
+	case RoutesModeNetworkManager:
+		// The connections are reapplied as they are modified.
+		for _, iface := range slices.Sorted(maps.Keys(routes)) {
+			if _, err := writeNMLocalRoutes(ctx, iface, routes[iface]); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", iface, err))
+			}
+		}
+	}
+
+	if mode != RoutesModeNetworkManager {
+		if err := clearNMLocalRoutes(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to clear the NetworkManager local routes: %w", err))
+		}
+	}
+
+	removed, err := removeRenderedRoutes(files)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(changed) > 0 || removed {
+		if err := reloadRenderedRoutes(ctx, mode, changed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return files, errors.Join(errs...)
+}
+
+// writeNetworkdRoutes writes the drop-in of iface's network file with the local
+// routes of ips, it returns the drop-in file and whether it was written.
+func writeNetworkdRoutes(ctx context.Context, protoID, iface string, ips []string) (string, bool, error) {
+	networkFile, err := networkFileOf(ctx, iface)
+	if err != nil {
+		return "", false, err
+	}
+	if networkFile == "" {
+		return "", false, fmt.Errorf("interface not configured by systemd-networkd")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, googleComment)
+	for _, ip := range ips {
+		fmt.Fprintf(&buf, "\n[Route]\nDestination=%s\nType=local\nScope=host\nProtocol=%s\n", ip, protoID)
+	}
+
+	file := filepath.Join(networkdRuntimeDir, filepath.Base(networkFile)+".d", routesDropinName+".conf")
+	wrote, err := writeIfChanged(file, buf.Bytes(), 0644)
+	return file, wrote, err
+}
+
+// writeNetplanRoutes writes the netplan drop-in with the local routes, it returns
+// the drop-in file, empty if there are no routes, and whether it was written.
+func writeNetplanRoutes(routes map[string][]string) (string, bool, error) {
+	dropin := netplanRoutesDropin{
+		Network: netplanRoutesNetwork{
+			Version:   netplanConfigVersion,
+			Ethernets: make(map[string]netplanRoutesEthernet),
+		},
+	}
+
+	for iface, ips := range routes {
+		if len(ips) == 0 {
+			continue
+		}
+		ethernet := netplanRoutesEthernet{Match: netplanMatch{Name: iface}}
+		for _, ip := range ips {
+			ethernet.Routes = append(ethernet.Routes, netplanRoute{To: ip, Type: "local", Scope: "host", Table: localRouteTable})
+		}
+		dropin.Network.Ethernets[netplanID(iface)] = ethernet
+	}
+
+	if len(dropin.Network.Ethernets) == 0 {
+		return "", false, nil
+	}
+
+	data, err := yaml.Marshal(&dropin)
+	if err != nil {
+		return "", false, fmt.Errorf("error marshalling netplan routes: %w", err)
+	}
+
+	file := netplanRoutesFile()
+	wrote, err := writeIfChanged(file, data, 0600)
+	return file, wrote, err
+}
+
+// removeRenderedRoutes removes the rendered routes files not in keep, it returns
+// true if any was removed.
+func removeRenderedRoutes(keep []string) (bool, error) {
+	files, err := filepath.Glob(filepath.Join(networkdRuntimeDir, "*.network.d", routesDropinName+".conf"))
+	if err != nil {
+		return false, err
+	}
+	files = append(files, netplanRoutesFile())
+
+	var removed bool
+	var errs []error
+	for _, file := range files {
+		if slices.Contains(keep, file) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		logger.Infof("Removed rendered routes %s", file)
+		removed = true
+	}
+	return removed, errors.Join(errs...)
+}
+
+// reloadRenderedRoutes reloads the network configuration after the rendered routes
+// changed, ifaces are the interfaces whose routes changed.
+func reloadRenderedRoutes(ctx context.Context, mode string, ifaces []string) error {
+	if mode == RoutesModeNetplan {
+		if err := run.Quiet(ctx, "netplan", "generate"); err != nil {
+			return fmt.Errorf("error generating netplan based config: %w", err)
+		}
+	}
+
+	if err := run.Quiet(ctx, "networkctl", "reload"); err != nil {
+		return fmt.Errorf("error reloading systemd-networkd network configs: %w", err)
+	}
+
+	for _, iface := range ifaces {
+		if err := run.Quiet(ctx, "networkctl", "reconfigure", iface); err != nil {
+			return fmt.Errorf("error reconfiguring %s: %w", iface, err)
+		}
+	}
+	return nil
+}
+
+// networkdNetworkFile returns the network file systemd-networkd configured iface
+// with, empty if it's not configured by systemd-networkd.
+func networkdNetworkFile(ctx context.Context, iface string) (string, error) {
+	res := run.WithOutput(ctx, "networkctl", "status", iface, "--json=short")
+	if res.ExitCode != 0 {
+		return "", fmt.Errorf("failed to check systemd-networkd network status: %v", res.StdErr)
+	}
+
+	var status struct {
+		NetworkFile string
+	}
+	if err := json.Unmarshal([]byte(res.StdOut), &status); err != nil {
+		return "", fmt.Errorf("failed to unmarshal interface status: %w", err)
+	}
+	return status.NetworkFile, nil
+}
+
+// netplanRoutesFile returns the netplan routes drop-in file, it's ordered after
+// the ethernet drop-in.
+func netplanRoutesFile() string {
+	priority := 20
+	for _, svc := range knownNetworkManagers {
+		if n, ok := svc.(*netplan); ok {
+			priority = n.priority
+		}
+	}
+	return filepath.Join(netplanRuntimeDir, fmt.Sprintf("%d-%s.yaml", priority, routesDropinName))
+}
+
+// netplanID returns the netplan ID of iface, shared with its ethernet drop-in entry
+// so they are merged.
+func netplanID(iface string) string {
+	for _, svc := range knownNetworkManagers {
+		if n, ok := svc.(*netplan); ok {
+			return n.ID(iface)
+		}
+	}
+	return iface
+}
+
+// writeIfChanged writes data to file unless it already has it, it returns true if
+// the file was written.
+func writeIfChanged(file string, data []byte, perm os.FileMode) (bool, error) {
+	if current, err := os.ReadFile(file); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return false, err
+	}
+	if err := utils.WriteFile(data, file, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockRoutesRunner struct {
+	executedCommands []string
+}
+
+func (m *mockRoutesRunner) Quiet(ctx context.Context, name string, args ...string) error {
+	m.executedCommands = append(m.executedCommands, name+" "+strings.Join(args, " "))
+	return nil
+}
+
+func (m *mockRoutesRunner) WithOutput(ctx context.Context, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func (m *mockRoutesRunner) WithOutputTimeout(ctx context.Context, timeout time.Duration, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func (m *mockRoutesRunner) WithCombinedOutput(ctx context.Context, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func setupRoutesTest(t *testing.T) *mockRoutesRunner {
+	t.Helper()
+
+	origNetworkd, origNetplan, origNetworkFileOf, origRunner := networkdRuntimeDir, netplanRuntimeDir, networkFileOf, run.Client
+	t.Cleanup(func() {
+		networkdRuntimeDir, netplanRuntimeDir, networkFileOf, run.Client = origNetworkd, origNetplan, origNetworkFileOf, origRunner
+	})
+
+	networkdRuntimeDir = t.TempDir()
+	netplanRuntimeDir = t.TempDir()
+	networkFileOf = func(_ context.Context, iface string) (string, error) {
+		return "/run/systemd/network/10-netplan-" + iface + ".network", nil
+	}
+
+	runner := &mockRoutesRunner{}
+	run.Client = runner
+	return runner
+}
+
+func readRoutesFile(t *testing.T, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%s) = %v, want nil", file, err)
+	}
+	return string(data)
+}
+
+func TestRenderLocalRoutesNetworkd(t *testing.T) {
+	runner := setupRoutesTest(t)
+	ctx := context.Background()
+	routes := map[string][]string{"ens4": {"10.0.0.5/32", "2001:db8::/96"}, "ens5": nil}
+
+	files, err := RenderLocalRoutes(ctx, RoutesModeNetworkd, "66", routes)
+	if err != nil {
+		t.Fatalf("RenderLocalRoutes() = %v, want nil", err)
+	}
+
+	wantFile := filepath.Join(networkdRuntimeDir, "10-netplan-ens4.network.d", "google-guest-agent-routes.conf")
+	if diff := cmp.Diff([]string{wantFile}, files); diff != "" {
+		t.Errorf("RenderLocalRoutes() returned unexpected files (-want +got):\n%s", diff)
+	}
+
+	want := googleComment + "\n" +
+		"\n[Route]\nDestination=10.0.0.5/32\nType=local\nScope=host\nProtocol=66\n" +
+		"\n[Route]\nDestination=2001:db8::/96\nType=local\nScope=host\nProtocol=66\n"
+	if diff := cmp.Diff(want, readRoutesFile(t, wantFile)); diff != "" {
+		t.Errorf("RenderLocalRoutes() rendered unexpected drop-in (-want +got):\n%s", diff)
+	}
+
+	wantCommands := []string{"networkctl reload", "networkctl reconfigure ens4"}
+	if diff := cmp.Diff(wantCommands, runner.executedCommands); diff != "" {
+		t.Errorf("RenderLocalRoutes() ran unexpected commands (-want +got):\n%s", diff)
+	}
+
+	// Rendering the same routes doesn't reload.
+	runner.executedCommands = nil
+	if _, err := RenderLocalRoutes(ctx, RoutesModeNetworkd, "66", routes); err != nil {
+		t.Fatalf("RenderLocalRoutes() = %v, want nil", err)
+	}
+	if len(runner.executedCommands) != 0 {
+		t.Errorf("RenderLocalRoutes() of unchanged routes ran %v, want none", runner.executedCommands)
+	}
+
+	// Switching to the imperative mode removes the drop-in.
+	if _, err := RenderLocalRoutes(ctx, RoutesModeImperative, "", nil); err != nil {
+		t.Fatalf("RenderLocalRoutes() = %v, want nil", err)
+	}
+	if _, err := os.Stat(wantFile); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v, want not exist", wantFile, err)
+	}
+	if diff := cmp.Diff([]string{"networkctl reload"}, runner.executedCommands); diff != "" {
+		t.Errorf("RenderLocalRoutes() ran unexpected commands (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenderLocalRoutesNetplan(t *testing.T) {
+	runner := setupRoutesTest(t)
+	ctx := context.Background()
+	routes := map[string][]string{"ens4": {"10.0.0.5/32"}}
+
+	files, err := RenderLocalRoutes(ctx, RoutesModeNetplan, "66", routes)
+	if err != nil {
+		t.Fatalf("RenderLocalRoutes() = %v, want nil", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("RenderLocalRoutes() returned %v, want one file", files)
+	}
+
+	var got netplanRoutesDropin
+	if err := readYamlFile(files[0], &got); err != nil {
+		t.Fatalf("readYamlFile(%s) = %v, want nil", files[0], err)
+	}
+	want := netplanRoutesDropin{
+		Network: netplanRoutesNetwork{
+			Version: netplanConfigVersion,
+			Ethernets: map[string]netplanRoutesEthernet{
+				netplanID("ens4"): {
+					Match:  netplanMatch{Name: "ens4"},
+					Routes: []netplanRoute{{To: "10.0.0.5/32", Type: "local", Scope: "host", Table: 255}},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RenderLocalRoutes() rendered unexpected drop-in (-want +got):\n%s", diff)
+	}
+
+	wantCommands := []string{"netplan generate", "networkctl reload", "networkctl reconfigure ens4"}
+	if diff := cmp.Diff(wantCommands, runner.executedCommands); diff != "" {
+		t.Errorf("RenderLocalRoutes() ran unexpected commands (-want +got):\n%s", diff)
+	}
+
+	// No routes, no drop-in.
+	if _, err := RenderLocalRoutes(ctx, RoutesModeNetplan, "66", nil); err != nil {
+		t.Fatalf("RenderLocalRoutes() = %v, want nil", err)
+	}
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v, want not exist", files[0], err)
+	}
+}
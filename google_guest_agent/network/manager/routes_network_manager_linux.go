@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// nmRoutesCleared is set once the NetworkManager connections were cleared of the
+	// local routes after starting in another mode.
+	nmRoutesCleared atomic.Bool
+)
+
+// writeNMLocalRoutes sets the local routes of the connection active on iface to the
+// routes of ips and reapplies it, the connection survives NetworkManager's
+// re-activations unlike the routes programmed directly. The connection's local
+// routes are the agent's, the other routes are kept. It returns true if the
+// connection changed.
+func writeNMLocalRoutes(ctx context.Context, iface string, ips []string) (bool, error) {
+	conn, err := nmConnection(ctx, iface)
+	if err != nil {
+		return false, err
+	}
+
+	var args []string
+	for _, family := range []string{"ipv4", "ipv6"} {
+		current, err := nmLocalRoutes(ctx, conn, family)
+		if err != nil {
+			return false, err
+		}
+
+		var want []string
+		for _, ip := range ips {
+			if (family == "ipv6") == strings.Contains(ip, ":") {
+				want = append(want, ip)
+			}
+		}
+
+		for dst, route := range current {
+			if !slices.Contains(want, dst) {
+				args = append(args, "-"+family+".routes", route)
+			}
+		}
+		for _, dst := range want {
+			if _, found := current[dst]; !found {
+				args = append(args, "+"+family+".routes", fmt.Sprintf("%s table=%d type=local", dst, localRouteTable))
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	logger.Infof("Updating the local routes of NetworkManager connection %q (%s)", conn, iface)
+	if err := run.Quiet(ctx, "nmcli", append([]string{"connection", "modify", conn}, args...)...); err != nil {
+		return false, fmt.Errorf("failed to modify connection %q: %w", conn, err)
+	}
+	if err := run.Quiet(ctx, "nmcli", "device", "reapply", iface); err != nil {
+		return true, fmt.Errorf("failed to reapply connection %q: %w", conn, err)
+	}
+	return true, nil
+}
+
+// clearNMLocalRoutes removes the local routes of the NetworkManager connections of
+// the ethernet devices, once per run, the routes rendered in the networkmanager
+// mode are otherwise kept after switching to another mode.
+func clearNMLocalRoutes(ctx context.Context) error {
+	if nmRoutesCleared.Swap(true) {
+		return nil
+	}
+
+	if exists, err := cliExists("nmcli"); !exists {
+		return err
+	}
+	res := run.WithOutput(ctx, "nmcli", "-t", "-f", "DEVICE,TYPE,STATE", "device", "status")
+	if res.ExitCode != 0 {
+		// NetworkManager is not running.
+		return nil
+	}
+
+	var errs []error
+	for _, line := range strings.Split(res.StdOut, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[1] != "ethernet" || fields[2] != "connected" {
+			continue
+		}
+		if _, err := writeNMLocalRoutes(ctx, fields[0], nil); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", fields[0], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// nmConnection returns the NetworkManager connection active on iface.
+func nmConnection(ctx context.Context, iface string) (string, error) {
+	res := run.WithOutput(ctx, "nmcli", "-g", "GENERAL.CONNECTION", "device", "show", iface)
+	if res.ExitCode != 0 {
+		return "", fmt.Errorf("failed to get the NetworkManager connection: %s", res.Error())
+	}
+	conn := nmUnescape(strings.TrimSpace(res.StdOut))
+	if conn == "" {
+		return "", fmt.Errorf("no NetworkManager connection active")
+	}
+	return conn, nil
+}
+
+// nmLocalRoutes returns the local routes of the connection conn for family, either
+// ipv4 or ipv6, mapped by destination.
+func nmLocalRoutes(ctx context.Context, conn, family string) (map[string]string, error) {
+	res := run.WithOutput(ctx, "nmcli", "-g", family+".routes", "connection", "show", conn)
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("failed to get the %s routes of connection %q: %s", family, conn, res.Error())
+	}
+	return parseNMLocalRoutes(res.StdOut), nil
+}
+
+// parseNMLocalRoutes parses the local routes of nmcli's routes output, i.e.
+// "10.0.0.5/32 table=255 type=local, 10.1.0.0/16 10.0.0.254 table=4", mapped by
+// destination.
+func parseNMLocalRoutes(out string) map[string]string {
+	res := make(map[string]string)
+	for _, route := range strings.Split(nmUnescape(strings.TrimSpace(out)), ", ") {
+		fields := strings.Fields(route)
+		if len(fields) == 0 || !slices.Contains(fields[1:], "type=local") {
+			continue
+		}
+		res[fields[0]] = route
+	}
+	return res
+}
+
+// nmUnescape unescapes the nmcli's terse output values, i.e. IPv6 addresses' colons.
+func nmUnescape(s string) string {
+	return strings.NewReplacer(`\:`, ":", `\\`, `\`).Replace(s)
+}
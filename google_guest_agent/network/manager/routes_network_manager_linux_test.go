@@ -0,0 +1,116 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockNMRoutesRunner struct {
+	// outputs are the commands' outputs by command line.
+	outputs          map[string]string
+	executedCommands []string
+}
+
+func (m *mockNMRoutesRunner) Quiet(ctx context.Context, name string, args ...string) error {
+	m.executedCommands = append(m.executedCommands, name+" "+strings.Join(args, " "))
+	return nil
+}
+
+func (m *mockNMRoutesRunner) WithOutput(ctx context.Context, name string, args ...string) *run.Result {
+	out, found := m.outputs[name+" "+strings.Join(args, " ")]
+	if !found {
+		return &run.Result{ExitCode: 1, StdErr: "unexpected command"}
+	}
+	return &run.Result{StdOut: out}
+}
+
+func (m *mockNMRoutesRunner) WithOutputTimeout(ctx context.Context, timeout time.Duration, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func (m *mockNMRoutesRunner) WithCombinedOutput(ctx context.Context, name string, args ...string) *run.Result {
+	return &run.Result{StdErr: "unimplemented"}
+}
+
+func TestParseNMLocalRoutes(t *testing.T) {
+	out := `10.0.0.5/32 table=255 type=local, 10.1.0.0/16 10.0.0.254 table=4, 2001\:db8\:\:/96 table=255 type=local` + "\n"
+
+	want := map[string]string{
+		"10.0.0.5/32":   "10.0.0.5/32 table=255 type=local",
+		"2001:db8::/96": "2001:db8::/96 table=255 type=local",
+	}
+	if diff := cmp.Diff(want, parseNMLocalRoutes(out)); diff != "" {
+		t.Errorf("parseNMLocalRoutes(%q) returned unexpected diff (-want +got):\n%s", out, diff)
+	}
+
+	if got := parseNMLocalRoutes(""); len(got) != 0 {
+		t.Errorf("parseNMLocalRoutes(\"\") = %v, want empty", got)
+	}
+}
+
+func TestWriteNMLocalRoutes(t *testing.T) {
+	orig := run.Client
+	t.Cleanup(func() { run.Client = orig })
+
+	runner := &mockNMRoutesRunner{
+		outputs: map[string]string{
+			"nmcli -g GENERAL.CONNECTION device show ens4":            "Wired connection 1\n",
+			"nmcli -g ipv4.routes connection show Wired connection 1": "10.0.0.5/32 table=255 type=local, 10.0.0.9/32 table=255 type=local, 10.1.0.0/16 10.0.0.254 table=4\n",
+			"nmcli -g ipv6.routes connection show Wired connection 1": `2001\:db8\:\:/96 table=255 type=local` + "\n",
+		},
+	}
+	run.Client = runner
+
+	changed, err := writeNMLocalRoutes(context.Background(), "ens4", []string{"10.0.0.5/32", "10.0.0.6/32"})
+	if err != nil {
+		t.Fatalf("writeNMLocalRoutes() = %v, want nil", err)
+	}
+	if !changed {
+		t.Errorf("writeNMLocalRoutes() = false, want true")
+	}
+
+	wantCommands := []string{
+		"nmcli connection modify Wired connection 1 -ipv4.routes 10.0.0.9/32 table=255 type=local +ipv4.routes 10.0.0.6/32 table=255 type=local -ipv6.routes 2001:db8::/96 table=255 type=local",
+		"nmcli device reapply ens4",
+	}
+	if diff := cmp.Diff(wantCommands, runner.executedCommands); diff != "" {
+		t.Errorf("writeNMLocalRoutes() ran unexpected commands (-want +got):\n%s", diff)
+	}
+
+	// The routes are already set.
+	runner.executedCommands = nil
+	runner.outputs["nmcli -g ipv6.routes connection show Wired connection 1"] = ""
+	changed, err = writeNMLocalRoutes(context.Background(), "ens4", []string{"10.0.0.5/32", "10.0.0.9/32"})
+	if err != nil {
+		t.Fatalf("writeNMLocalRoutes() = %v, want nil", err)
+	}
+	if changed || len(runner.executedCommands) != 0 {
+		t.Errorf("writeNMLocalRoutes() = %t and ran %v, want no change", changed, runner.executedCommands)
+	}
+
+	// Not managed by NetworkManager.
+	if _, err := writeNMLocalRoutes(context.Background(), "ens5", nil); err == nil {
+		t.Errorf("writeNMLocalRoutes(ens5) succeeded, want error")
+	}
+}
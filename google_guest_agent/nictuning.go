@@ -0,0 +1,153 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/osinfo"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// tunedNICsMu protects tunedNICs.
+	tunedNICsMu sync.Mutex
+	// tunedNICs are the MAC addresses of the NICs whose drivers' tunables were
+	// applied.
+	tunedNICs = make(map[string]bool)
+	// nicDrivers returns the NICs' drivers by interface name, it's a variable to be
+	// replaced in tests.
+	nicDrivers = osinfo.NICDrivers
+)
+
+// tuneNICs applies the drivers' tunables to md's NICs not tuned yet, once per agent
+// run: at startup and as the NICs are hot-attached.
+func tuneNICs(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) {
+	// Nothing to tune, the drivers aren't even detected.
+	if config.NICDrivers == nil || *config.NICDrivers == (cfg.NICDrivers{}) {
+		return
+	}
+
+	tunedNICsMu.Lock()
+	defer tunedNICsMu.Unlock()
+
+	var drivers map[string]osinfo.NICDriver
+	for _, ni := range md.Instance.NetworkInterfaces {
+		if tunedNICs[ni.Mac] {
+			continue
+		}
+		iface, err := network.GetInterfaceByMAC(ni.Mac)
+		if err != nil {
+			continue
+		}
+
+		if drivers == nil {
+			if drivers, err = nicDrivers(); err != nil {
+				logger.Debugf("Failed to detect the NICs drivers: %v", err)
+				return
+			}
+		}
+		tunedNICs[ni.Mac] = true
+
+		driver := drivers[iface.Name]
+		queues, offloads, err := driverTunables(config.NICDrivers, driver)
+		if err != nil {
+			logger.Errorf("Invalid %s NICs tunables: %v", driver, err)
+			continue
+		}
+		if queues == 0 && len(offloads) == 0 {
+			continue
+		}
+
+		logger.Infof("Tuning %s NIC %s, queues: %d, offloads: %q.", driver, iface.Name, queues, offloads)
+		if err := tuneNIC(ctx, iface.Name, queues, offloads); err != nil {
+			logger.Errorf("Failed to tune NIC %s: %v", iface.Name, err)
+		}
+	}
+}
+
+// driverTunables returns the queues count and the ethtool offload settings, as
+// feature and state pairs, configured for driver's NICs.
+func driverTunables(config *cfg.NICDrivers, driver osinfo.NICDriver) (int, []string, error) {
+	var queues int
+	var offloads string
+	switch driver {
+	case osinfo.NICDriverGVNIC:
+		queues, offloads = config.GVNICQueues, config.GVNICOffloads
+	case osinfo.NICDriverIDPF:
+		queues, offloads = config.IDPFQueues, config.IDPFOffloads
+	case osinfo.NICDriverVirtio:
+		queues, offloads = config.VirtioQueues, config.VirtioOffloads
+	default:
+		return 0, nil, nil
+	}
+
+	if queues < 0 {
+		return 0, nil, fmt.Errorf("negative queues count %d", queues)
+	}
+	args, err := parseOffloads(offloads)
+	if err != nil {
+		return 0, nil, err
+	}
+	return queues, args, nil
+}
+
+// parseOffloads parses the comma separated offload settings, i.e. "tso=off,gro=on",
+// as ethtool feature and state pairs.
+func parseOffloads(offloads string) ([]string, error) {
+	var res []string
+	for _, setting := range strings.Split(offloads, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		feature, state, ok := strings.Cut(setting, "=")
+		feature, state = strings.TrimSpace(feature), strings.TrimSpace(state)
+		if !ok || feature == "" || (state != "on" && state != "off") {
+			return nil, fmt.Errorf("invalid offload setting %q, want <feature>=on|off", setting)
+		}
+		res = append(res, feature, state)
+	}
+	return res, nil
+}
+
+// nicDriversSummary lists the NICs' drivers, logged with the telemetry.
+type nicDriversSummary map[string]osinfo.NICDriver
+
+// String returns the sorted NICs' drivers, i.e. "eth0=gvnic eth1=virtio".
+func (s nicDriversSummary) String() string {
+	var res []string
+	for iface, driver := range s {
+		res = append(res, fmt.Sprintf("%s=%s", iface, driver))
+	}
+	slices.Sort(res)
+	return strings.Join(res, " ")
+}
+
+// nicDriversMetrics returns the NICs' drivers summary.
+func nicDriversMetrics() fmt.Stringer {
+	drivers, err := nicDrivers()
+	if err != nil {
+		logger.Debugf("Failed to detect the NICs drivers: %v", err)
+	}
+	return nicDriversSummary(drivers)
+}
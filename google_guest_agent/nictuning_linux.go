@@ -0,0 +1,42 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+)
+
+// tuneNIC sets the combined queues count, unless zero, and the offload settings of
+// iface with ethtool.
+func tuneNIC(ctx context.Context, iface string, queues int, offloads []string) error {
+	if queues > 0 {
+		if err := run.Quiet(ctx, "ethtool", "-L", iface, "combined", strconv.Itoa(queues)); err != nil {
+			return fmt.Errorf("failed to set the queues count: %w", err)
+		}
+	}
+	if len(offloads) > 0 {
+		args := append([]string{"-K", iface}, offloads...)
+		if err := run.Quiet(ctx, "ethtool", args...); err != nil {
+			return fmt.Errorf("failed to set the offloads: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/osinfo"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOffloads(t *testing.T) {
+	tests := []struct {
+		offloads string
+		want     []string
+		wantErr  bool
+	}{
+		{offloads: "", want: nil},
+		{offloads: "tso=off, gro=on", want: []string{"tso", "off", "gro", "on"}},
+		{offloads: "tso=off,", want: []string{"tso", "off"}},
+		{offloads: "tso", wantErr: true},
+		{offloads: "tso=disabled", wantErr: true},
+		{offloads: "=on", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseOffloads(tc.offloads)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseOffloads(%q) returned error %v, want error: %t", tc.offloads, err, tc.wantErr)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("parseOffloads(%q) returned unexpected diff (-want +got):\n%s", tc.offloads, diff)
+		}
+	}
+}
+
+func TestDriverTunables(t *testing.T) {
+	config := &cfg.NICDrivers{
+		GVNICQueues:    8,
+		GVNICOffloads:  "lro=off",
+		IDPFQueues:     16,
+		VirtioOffloads: "gro=on",
+	}
+
+	tests := []struct {
+		driver       osinfo.NICDriver
+		wantQueues   int
+		wantOffloads []string
+	}{
+		{driver: osinfo.NICDriverGVNIC, wantQueues: 8, wantOffloads: []string{"lro", "off"}},
+		{driver: osinfo.NICDriverIDPF, wantQueues: 16},
+		{driver: osinfo.NICDriverVirtio, wantOffloads: []string{"gro", "on"}},
+		{driver: osinfo.NICDriverOther},
+	}
+
+	for _, tc := range tests {
+		queues, offloads, err := driverTunables(config, tc.driver)
+		if err != nil {
+			t.Errorf("driverTunables(%s) failed: %v", tc.driver, err)
+		}
+		if queues != tc.wantQueues {
+			t.Errorf("driverTunables(%s) = %d queues, want %d", tc.driver, queues, tc.wantQueues)
+		}
+		if diff := cmp.Diff(tc.wantOffloads, offloads); diff != "" {
+			t.Errorf("driverTunables(%s) returned unexpected offloads (-want +got):\n%s", tc.driver, diff)
+		}
+	}
+
+	if _, _, err := driverTunables(&cfg.NICDrivers{GVNICQueues: -1}, osinfo.NICDriverGVNIC); err == nil {
+		t.Errorf("driverTunables() with a negative queues count = nil, want error")
+	}
+}
+
+func TestNICDriversSummary(t *testing.T) {
+	s := nicDriversSummary{"eth1": osinfo.NICDriverVirtio, "eth0": osinfo.NICDriverGVNIC}
+	if got, want := s.String(), "eth0=gvnic eth1=virtio"; got != want {
+		t.Errorf("nicDriversSummary.String() = %q, want %q", got, want)
+	}
+}
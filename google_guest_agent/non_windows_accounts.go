@@ -18,7 +18,9 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path"
@@ -27,11 +29,15 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-agent/utils"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/crypto/ssh"
 )
 
 var (
@@ -39,6 +45,25 @@ var (
 	// keys file. Avoids necessity of re-reading all files on every change.
 	sshKeys         map[string][]string
 	googleUsersFile = "/var/lib/google/google_users"
+
+	// deprovisionPending records when the keys of the Google users removed from the
+	// metadata were revoked, they're removed once the grace period elapsed.
+	deprovisionPending = make(map[string]time.Time)
+	// persistedDeprovision is the content of deprovisionStateFile.
+	persistedDeprovision = make(map[string]time.Time)
+	// deprovisionStateFile persists deprovisionPending, so the grace period of the
+	// users whose keys were revoked isn't restarted by an agent restart.
+	deprovisionStateFile = "/var/lib/google/google_users_deprovision.json"
+	// deprovisionTimer re-runs the manager once the next grace period elapses.
+	deprovisionTimer *time.Timer
+	// deprovisionCtx is the agent's context deprovisionTimer re-runs the manager
+	// with, see initDeprovisionState().
+	deprovisionCtx = context.Background()
+)
+
+const (
+	// deprovisionNever is the deprovision mode only revoking the users' keys.
+	deprovisionNever = "never"
 )
 
 // compareStringSlice returns true if two string slices are equal, false
@@ -72,15 +97,15 @@ func removeExpiredKeys(keys []string) []string {
 
 type accountsMgr struct{}
 
-func (a *accountsMgr) Diff(ctx context.Context) (bool, error) {
+func (a *accountsMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
 	// If any keys have changed.
-	if !compareStringSlice(newMetadata.Instance.Attributes.SSHKeys, oldMetadata.Instance.Attributes.SSHKeys) {
+	if !compareStringSlice(newMd.Instance.Attributes.SSHKeys, oldMd.Instance.Attributes.SSHKeys) {
 		return true, nil
 	}
-	if !compareStringSlice(newMetadata.Project.Attributes.SSHKeys, oldMetadata.Project.Attributes.SSHKeys) {
+	if !compareStringSlice(newMd.Project.Attributes.SSHKeys, oldMd.Project.Attributes.SSHKeys) {
 		return true, nil
 	}
-	if newMetadata.Instance.Attributes.BlockProjectKeys != oldMetadata.Instance.Attributes.BlockProjectKeys {
+	if newMd.Instance.Attributes.BlockProjectKeys != oldMd.Instance.Attributes.BlockProjectKeys {
 		return true, nil
 	}
 
@@ -91,8 +116,8 @@ func (a *accountsMgr) Diff(ctx context.Context) (bool, error) {
 		}
 	}
 	// If we've just disabled OS Login.
-	oldOslogin, _, _, _ := getOSLoginEnabled(oldMetadata)
-	newOslogin, _, _, _ := getOSLoginEnabled(newMetadata)
+	oldOslogin, _, _, _ := getOSLoginEnabled(oldMd)
+	newOslogin, _, _, _ := getOSLoginEnabled(newMd)
 	if oldOslogin && !newOslogin {
 		return true, nil
 	}
@@ -104,13 +129,13 @@ func (a *accountsMgr) Timeout(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (a *accountsMgr) Disabled(ctx context.Context) (bool, error) {
+func (a *accountsMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	config := cfg.Get()
-	oslogin, _, _, _ := getOSLoginEnabled(newMetadata)
+	oslogin, _, _, _ := getOSLoginEnabled(newMd)
 	return false || runtime.GOOS == "windows" || oslogin || !config.Daemons.AccountsDaemon, nil
 }
 
-func (a *accountsMgr) Set(ctx context.Context) error {
+func (a *accountsMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
 	config := cfg.Get()
 
 	if sshKeys == nil {
@@ -119,20 +144,16 @@ func (a *accountsMgr) Set(ctx context.Context) error {
 	}
 
 	logger.Debugf("create sudoers file if needed")
-	if err := createSudoersFile(); err != nil {
-		logger.Errorf("Error creating google-sudoers file: %v.", err)
+	if err := createSudoersFile(config); err != nil {
+		logger.Errorf("Error creating sudoers file: %v.", err)
 	}
 	logger.Debugf("create sudoers group if needed")
 	if err := createSudoersGroup(ctx, config); err != nil {
-		logger.Errorf("Error creating google-sudoers group: %v.", err)
+		logger.Errorf("Error creating sudoers group: %v.", err)
 	}
 
-	mdkeys := newMetadata.Instance.Attributes.SSHKeys
-	if !newMetadata.Instance.Attributes.BlockProjectKeys {
-		mdkeys = append(mdkeys, newMetadata.Project.Attributes.SSHKeys...)
-	}
-
-	mdKeyMap := getUserKeys(mdkeys)
+	mdKeyMap, rejected := parseMetadataUserKeys(newMd)
+	reportRejectedKeys(ctx, rejected)
 
 	logger.Debugf("read google users file")
 	gUsers, err := readGoogleUsersFile()
@@ -141,43 +162,58 @@ func (a *accountsMgr) Set(ctx context.Context) error {
 		logger.Errorf("Couldn't read google_users file: %v.", err)
 	}
 
+	// The users failed to be created or updated, the manager is retried for them.
+	var failed []string
+
 	// Update SSH keys, creating Google users as needed.
 	for user, userKeys := range mdKeyMap {
 		if _, err := getPasswd(user); err != nil {
 			logger.Infof("Creating user %s.", user)
 			if err := createGoogleUser(ctx, config, user); err != nil {
 				logger.Errorf("Error creating user: %s.", err)
+				failed = append(failed, user)
 				continue
 			}
 			gUsers[user] = ""
 		}
 		if _, ok := gUsers[user]; !ok {
-			logger.Infof("Adding existing user %s to google-sudoers group.", user)
-			if err := addUserToGroup(ctx, user, "google-sudoers"); err != nil {
+			logger.Infof("Adding existing user %s to %s group.", user, sudoersGroup(config))
+			if err := addUserToGroup(ctx, user, sudoersGroup(config)); err != nil {
 				logger.Errorf("%v.", err)
 			}
 		}
-		if !compareStringSlice(userKeys, sshKeys[user]) {
+		if config.Accounts.AuthorizedKeysCommand {
+			// The keys are served by the agent, the ones it wrote are removed.
+			if err := removeGoogleKeys(ctx, user); err != nil {
+				logger.Errorf("Error removing the written SSH keys of %s: %v.", user, err)
+				failed = append(failed, user)
+				continue
+			}
+			sshKeys[user] = userKeys
+		} else if !compareStringSlice(userKeys, sshKeys[user]) || authorizedKeysDrifted(user, userKeys) {
 			logger.Infof("Updating keys for user %s.", user)
 			if err := updateAuthorizedKeysFile(ctx, user, userKeys); err != nil {
 				logger.Errorf("Error updating SSH keys for %s: %v.", user, err)
+				failed = append(failed, user)
 				continue
 			}
 			sshKeys[user] = userKeys
 		}
 	}
 
-	// Remove Google users not found in metadata.
+	// Deprovision Google users not found in metadata.
 	for user := range gUsers {
-		if _, ok := mdKeyMap[user]; !ok && user != "" {
-			logger.Infof("Removing user %s.", user)
-			err = removeGoogleUser(ctx, config, user)
-			if err != nil {
-				logger.Errorf("Error removing user: %v.", err)
-			}
-			delete(sshKeys, user)
+		if _, ok := mdKeyMap[user]; ok || user == "" {
+			delete(deprovisionPending, user)
+			continue
+		}
+		if err := deprovisionGoogleUser(ctx, config, user); err != nil {
+			logger.Errorf("Error deprovisioning user %s: %v.", user, err)
+			failed = append(failed, user)
 		}
 	}
+	scheduleDeprovision(config)
+	persistDeprovisionState()
 
 	// Update the google_users file if we've added or removed any users.
 	logger.Debugf("write google_users file")
@@ -185,6 +221,10 @@ func (a *accountsMgr) Set(ctx context.Context) error {
 		logger.Errorf("Error writing google_users file: %v.", err)
 	}
 
+	if err := writeKeysCommandConfig(ctx, config, newMd); err != nil {
+		logger.Errorf("Error updating the sshd AuthorizedKeysCommand: %v.", err)
+	}
+
 	// Start SSHD if not started. We do this in agent instead of adding a
 	// Wants= directive, and here instead of instance setup, so that this
 	// can be disabled by the instance configs file.
@@ -193,10 +233,287 @@ func (a *accountsMgr) Set(ctx context.Context) error {
 		systemctlStart(ctx, svc)
 	}
 
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return fmt.Errorf("failed to configure users: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// DryRun returns the users Set() would create, remove or add to the sudoers group
+// and the users whose keys it would update.
+func (a *accountsMgr) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	config := cfg.Get()
+	mdKeyMap := metadataUserKeys(newMd)
+	gUsers, err := readGoogleUsersFile()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read google_users file: %w", err)
+	}
+
+	var changes []string
+	for _, user := range slices.Sorted(maps.Keys(mdKeyMap)) {
+		if _, err := getPasswd(user); err != nil {
+			changes = append(changes, fmt.Sprintf("create user %s", user))
+		} else if _, ok := gUsers[user]; !ok {
+			changes = append(changes, fmt.Sprintf("add existing user %s to %s group", user, sudoersGroup(config)))
+		}
+		if config.Accounts.AuthorizedKeysCommand {
+			if googleKeysWritten(user) {
+				changes = append(changes, fmt.Sprintf("remove the written keys of user %s", user))
+			}
+		} else if !compareStringSlice(mdKeyMap[user], sshKeys[user]) {
+			changes = append(changes, fmt.Sprintf("update keys of user %s (%d keys)", user, len(mdKeyMap[user])))
+		} else if authorizedKeysDrifted(user, mdKeyMap[user]) {
+			changes = append(changes, fmt.Sprintf("restore keys of user %s (%d keys)", user, len(mdKeyMap[user])))
+		}
+	}
+
+	if keysCommandConfigChanged(newMd) {
+		changes = append(changes, "rewrite /etc/ssh/sshd_config")
+	}
+
+	gracePeriod := deprovisionGracePeriod(config)
+	for _, user := range slices.Sorted(maps.Keys(gUsers)) {
+		if _, ok := mdKeyMap[user]; ok || user == "" {
+			continue
+		}
+		revokedAt, pending := deprovisionPending[user]
+		if !pending {
+			changes = append(changes, fmt.Sprintf("revoke keys of user %s", user))
+			revokedAt = time.Now()
+		}
+		switch remaining := gracePeriod - time.Since(revokedAt); {
+		case config.Accounts.DeprovisionMode == deprovisionNever:
+		case remaining > 0:
+			changes = append(changes, fmt.Sprintf("remove user %s in %s", user, remaining.Round(time.Second)))
+		default:
+			changes = append(changes, fmt.Sprintf("remove user %s", user))
+		}
+	}
+	return changes, nil
+}
+
+// deprovisionGracePeriod returns the configured deprovisioning grace period, none
+// if it's invalid.
+func deprovisionGracePeriod(config *cfg.Sections) time.Duration {
+	if config.Accounts.DeprovisionGracePeriod == "" {
+		return 0
+	}
+	gracePeriod, err := time.ParseDuration(config.Accounts.DeprovisionGracePeriod)
+	if err != nil {
+		logger.Errorf("Invalid deprovision grace period %q, ignoring: %v", config.Accounts.DeprovisionGracePeriod, err)
+		return 0
+	}
+	return gracePeriod
+}
+
+// deprovisionGoogleUser deprovisions the Google managed user removed from the
+// metadata: its keys are revoked right away and, unless the deprovision mode is
+// never, it's removed with removeGoogleUser() once the grace period elapsed. The
+// users with revoked keys are kept in the google_users file until removed, the
+// revocation times are persisted, see persistDeprovisionState().
+func deprovisionGoogleUser(ctx context.Context, config *cfg.Sections, user string) error {
+	if _, err := getPasswd(user); err != nil {
+		// Removed by an administrator, nothing left to deprovision.
+		delete(deprovisionPending, user)
+		delete(sshKeys, user)
+		return nil
+	}
+
+	revokedAt, pending := deprovisionPending[user]
+	if !pending {
+		logger.Infof("Revoking keys of user %s.", user)
+		if err := updateAuthorizedKeysFile(ctx, user, nil); err != nil {
+			return fmt.Errorf("failed to revoke keys: %w", err)
+		}
+		recordDeprovisionEvent(user, "keys revoked")
+		revokedAt = time.Now()
+		deprovisionPending[user] = revokedAt
+		sshKeys[user] = nil
+	}
+
+	if config.Accounts.DeprovisionMode == deprovisionNever {
+		// The user is no longer managed, its account and groups are kept.
+		delete(deprovisionPending, user)
+		delete(sshKeys, user)
+		return nil
+	}
+	if time.Since(revokedAt) < deprovisionGracePeriod(config) {
+		return nil
+	}
+
+	logger.Infof("Removing user %s.", user)
+	if err := removeGoogleUser(ctx, config, user); err != nil {
+		return err
+	}
+	if config.Accounts.DeprovisionRemove {
+		recordDeprovisionEvent(user, "account removed")
+	} else {
+		recordDeprovisionEvent(user, "removed from "+sudoersGroup(config))
+	}
+	delete(deprovisionPending, user)
+	delete(sshKeys, user)
 	return nil
 }
 
-var badSSHKeys []string
+// deprovisionEvent is the log entry of a user deprovisioning step.
+type deprovisionEvent struct {
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Timestamp string `json:"timestamp"`
+}
+
+// recordDeprovisionEvent logs the deprovisioning action taken on user, one entry
+// per action, so the removals can be audited.
+func recordDeprovisionEvent(user, action string) {
+	data, err := json.Marshal(deprovisionEvent{User: user, Action: action, Timestamp: time.Now().UTC().Format(time.RFC3339)})
+	if err != nil {
+		logger.Errorf("Failed to marshal deprovision event: %v", err)
+		return
+	}
+	logger.Infof("Deprovision event: %s", data)
+}
+
+// scheduleDeprovision re-runs the accounts manager when the next pending user's
+// grace period elapses, replacing the previously scheduled run.
+func scheduleDeprovision(config *cfg.Sections) {
+	if deprovisionTimer != nil {
+		deprovisionTimer.Stop()
+		deprovisionTimer = nil
+	}
+	if len(deprovisionPending) == 0 || config.Accounts.DeprovisionMode == deprovisionNever {
+		return
+	}
+
+	gracePeriod := deprovisionGracePeriod(config)
+	next := gracePeriod
+	for _, revokedAt := range deprovisionPending {
+		next = min(next, gracePeriod-time.Since(revokedAt))
+	}
+	ctx := deprovisionCtx
+	deprovisionTimer = time.AfterFunc(max(next, time.Second), func() {
+		if ctx.Err() != nil || !inflight.begin() {
+			return
+		}
+		defer inflight.end()
+
+		m := managerByName("accounts")
+		_, md := metadataSnapshot()
+		if m == nil || md == nil {
+			return
+		}
+		m.execute(ctx, md, md, applyForced)
+	})
+}
+
+// initDeprovisionState loads the revocation times persisted by a previous agent run,
+// the users' grace period resumes from them. ctx is the agent's context, the
+// deprovision timer re-runs the manager with it.
+func initDeprovisionState(ctx context.Context) {
+	deprovisionCtx = ctx
+
+	data, err := os.ReadFile(deprovisionStateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("Failed to read the users deprovision state: %v", err)
+		}
+		return
+	}
+
+	pending := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &pending); err != nil {
+		logger.Errorf("Failed to decode the users deprovision state, the grace periods start over: %v", err)
+		return
+	}
+	deprovisionPending, persistedDeprovision = pending, maps.Clone(pending)
+}
+
+// persistDeprovisionState writes deprovisionPending to deprovisionStateFile if it
+// changed, the file is removed once no user is pending.
+func persistDeprovisionState() {
+	if maps.EqualFunc(deprovisionPending, persistedDeprovision, time.Time.Equal) {
+		return
+	}
+
+	if len(deprovisionPending) == 0 {
+		if err := os.Remove(deprovisionStateFile); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Failed to remove the users deprovision state: %v", err)
+			return
+		}
+		persistedDeprovision = make(map[string]time.Time)
+		return
+	}
+
+	data, err := json.Marshal(deprovisionPending)
+	if err != nil {
+		logger.Errorf("Failed to encode the users deprovision state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(path.Dir(deprovisionStateFile), 0755); err != nil {
+		logger.Errorf("Failed to create the users deprovision state directory: %v", err)
+		return
+	}
+	if err := utils.SaferWriteFile(data, deprovisionStateFile, 0600); err != nil {
+		logger.Errorf("Failed to write the users deprovision state: %v", err)
+		return
+	}
+	owned.recordFile(deprovisionStateFile)
+	persistedDeprovision = maps.Clone(deprovisionPending)
+}
+
+// metadataUserKeys returns the valid SSH keys of md by user, the instance and the
+// project keys are combined according to the users' key source policies.
+func metadataUserKeys(md *metadata.Descriptor) map[string][]string {
+	keys, _ := parseMetadataUserKeys(md)
+	return keys
+}
+
+// parseMetadataUserKeys returns the valid SSH keys of md by user and the rejected
+// ones, see metadataUserKeys(). The project keys are only parsed, and their
+// rejections reported, if they may be used.
+func parseMetadataUserKeys(md *metadata.Descriptor) (map[string][]string, []rejectedKey) {
+	sources := configuredKeySources(cfg.Get())
+	blocked := md.Instance.Attributes.BlockProjectKeys
+
+	instanceKeys, rejected := parseUserKeys(md.Instance.Attributes.SSHKeys)
+	var projectKeys map[string][]string
+	if !blocked || sources.ignoresBlock() {
+		var projectRejected []rejectedKey
+		projectKeys, projectRejected = parseUserKeys(md.Project.Attributes.SSHKeys)
+		rejected = append(rejected, projectRejected...)
+	}
+
+	res := make(map[string][]string)
+	for _, users := range []map[string][]string{instanceKeys, projectKeys} {
+		for user := range users {
+			if _, found := res[user]; found {
+				continue
+			}
+			if keys := combineKeys(sources.policyFor(user), blocked, instanceKeys[user], projectKeys[user]); len(keys) > 0 {
+				res[user] = keys
+			}
+		}
+	}
+	return res, rejected
+}
+
+var (
+	// badSSHKeysMutex protects badSSHKeys, the keys are also parsed by the
+	// authorized keys command handler.
+	badSSHKeysMutex sync.Mutex
+	// badSSHKeys are the rejected keys already logged.
+	badSSHKeys []string
+)
+
+// rejectedKey is a metadata SSH key entry rejected by parseUserKeys().
+type rejectedKey struct {
+	// User is the entry's user, empty if the entry is malformed.
+	User string `json:"user,omitempty"`
+	// Key identifies the key, its SHA256 fingerprint if it could be parsed.
+	Key string `json:"key"`
+	// Reason is the reason the key was rejected.
+	Reason string `json:"reason"`
+}
 
 // getUserKeys returns the keys which are not expired and non-expiring key.
 // valid formats are:
@@ -205,7 +522,17 @@ var badSSHKeys []string
 // user:ssh-rsa [KEY_VALUE] google-ssh {"userName":"[USERNAME]","expireOn":"[EXPIRE_TIME]"}
 // user:[KEY_OPTIONS] ssh-rsa [KEY_VALUE]
 func getUserKeys(mdkeys []string) map[string][]string {
+	keys, _ := parseUserKeys(mdkeys)
+	return keys
+}
+
+// parseUserKeys returns the valid keys of mdkeys by user, see getUserKeys(), and
+// the rejected ones: the malformed entries, the expired keys and the keys not
+// accepted by the configured key policy. The rejected keys are logged once.
+func parseUserKeys(mdkeys []string) (map[string][]string, []rejectedKey) {
+	policy := sshKeyPolicy(cfg.Get())
 	mdKeyMap := make(map[string][]string)
+	var rejected []rejectedKey
 	for i := 0; i < len(mdkeys); i++ {
 		trimmedKey := strings.Trim(mdkeys[i], " ")
 		if trimmedKey != "" {
@@ -213,12 +540,18 @@ func getUserKeys(mdkeys []string) map[string][]string {
 			if err == nil {
 				err = utils.ValidateUserKey(user, keyVal)
 			}
+			if err == nil {
+				err = utils.CheckKeyPolicy(keyVal, policy)
+			}
 
 			if err != nil {
+				rejected = append(rejected, rejectedKey{User: user, Key: keyFingerprint(keyVal, trimmedKey), Reason: err.Error()})
+				badSSHKeysMutex.Lock()
 				if !slices.Contains(badSSHKeys, trimmedKey) {
 					logger.Errorf("%s: %s", err.Error(), trimmedKey)
 					badSSHKeys = append(badSSHKeys, trimmedKey)
 				}
+				badSSHKeysMutex.Unlock()
 				continue
 			}
 
@@ -228,7 +561,64 @@ func getUserKeys(mdkeys []string) map[string][]string {
 			mdKeyMap[user] = userKeys
 		}
 	}
-	return mdKeyMap
+	return mdKeyMap, rejected
+}
+
+// sshKeyPolicy returns the SSH key policy of the [Accounts] configuration.
+func sshKeyPolicy(config *cfg.Sections) utils.KeyPolicy {
+	policy := utils.KeyPolicy{RejectWeak: config.Accounts.RejectWeakSSHKeys}
+	for _, keyType := range strings.Split(config.Accounts.SSHKeyTypes, ",") {
+		if keyType = strings.TrimSpace(keyType); keyType != "" {
+			policy.Types = append(policy.Types, keyType)
+		}
+	}
+	return policy
+}
+
+// keyFingerprint returns the SHA256 fingerprint of key, the beginning of entry if
+// the key can't be parsed.
+func keyFingerprint(key, entry string) string {
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil {
+		return ssh.FingerprintSHA256(pub)
+	}
+	const maxLen = 40
+	if len(entry) > maxLen {
+		return entry[:maxLen] + "..."
+	}
+	return entry
+}
+
+// rejectedKeysKey is the guest attribute the rejected metadata SSH keys are
+// reported to.
+const rejectedKeysKey = "guest-agent/rejected-ssh-keys"
+
+// lastRejectedKeys is the last rejected keys report, only the changes are reported.
+var lastRejectedKeys string
+
+// reportRejectedKeys writes the rejected metadata SSH keys to the guest attribute,
+// if they changed since the last report.
+func reportRejectedKeys(ctx context.Context, rejected []rejectedKey) {
+	data, err := json.Marshal(rejected)
+	if err != nil {
+		logger.Errorf("Failed to marshal the rejected SSH keys: %v", err)
+		return
+	}
+	report := string(data)
+	if len(rejected) == 0 {
+		if lastRejectedKeys == "" {
+			return
+		}
+		report = "[]"
+	}
+	if report == lastRejectedKeys || mdsClient == nil {
+		return
+	}
+
+	if err := mdsClient.WriteGuestAttributes(ctx, rejectedKeysKey, report); err != nil {
+		logger.Errorf("Failed to report the rejected SSH keys to guest attributes: %v", err)
+		return
+	}
+	lastRejectedKeys = report
 }
 
 // passwdEntry is a user.User with omitted passwd fields restored.
@@ -312,6 +702,7 @@ func writeGoogleUsersFile() error {
 		for user := range sshKeys {
 			fmt.Fprintf(gfile, "%s\n", user)
 		}
+		owned.recordFile(googleUsersFile)
 	}
 	return err
 }
@@ -346,7 +737,7 @@ func createUserGroupCmd(cmd, user, group string) (string, []string) {
 func createGoogleUser(ctx context.Context, config *cfg.Sections, user string) error {
 	var uid, gid string
 	if config.Accounts.ReuseHomedir {
-		uid, gid = getUIDAndGID(fmt.Sprintf("/home/%s", user))
+		uid, gid = getUIDAndGID(homeDir(config.Accounts, user))
 	}
 
 	if err := createUser(ctx, user, uid, gid); err != nil {
@@ -356,7 +747,24 @@ func createGoogleUser(ctx context.Context, config *cfg.Sections, user string) er
 	for _, group := range strings.Split(groups, ",") {
 		addUserToGroup(ctx, user, group)
 	}
-	return addUserToGroup(ctx, user, "google-sudoers")
+	return addUserToGroup(ctx, user, sudoersGroup(config))
+}
+
+// homeDir returns the home directory of user created by the agent, from the
+// configured template.
+func homeDir(config *cfg.Accounts, user string) string {
+	if config.HomeDirTemplate == "" {
+		return path.Join("/home", user)
+	}
+	return strings.ReplaceAll(config.HomeDirTemplate, "{user}", user)
+}
+
+// sudoersGroup returns the configured group granted sudo permissions.
+func sudoersGroup(config *cfg.Sections) string {
+	if config.Accounts.SudoersGroup == "" {
+		return "google-sudoers"
+	}
+	return config.Accounts.SudoersGroup
 }
 
 // removeGoogleUser removes Google managed users. If deprovision_remove is true, the
@@ -367,20 +775,24 @@ func removeGoogleUser(ctx context.Context, config *cfg.Sections, user string) er
 	if config.Accounts.DeprovisionRemove {
 		userdel := config.Accounts.UserDelCmd
 		name, args := createUserGroupCmd(userdel, user, "")
-		return run.Quiet(ctx, name, args...)
+		if err := run.Quiet(ctx, name, args...); err != nil {
+			return err
+		}
+		owned.forgetUser(user)
+		return nil
 	}
 	if err := updateAuthorizedKeysFile(ctx, user, []string{}); err != nil {
 		return err
 	}
 	gpasswddel := config.Accounts.GPasswdRemoveCmd
-	name, args := createUserGroupCmd(gpasswddel, user, "google-sudoers")
+	name, args := createUserGroupCmd(gpasswddel, user, sudoersGroup(config))
 	return run.Quiet(ctx, name, args...)
 }
 
 // createSudoersFile creates the google_sudoers configuration file if it does
-// not exist and specifies the group 'google-sudoers' should have all
-// permissions.
-func createSudoersFile() error {
+// not exist and specifies the sudoers group should have all permissions. The
+// existing file isn't updated, it may have been edited by an administrator.
+func createSudoersFile(config *cfg.Sections) error {
 	sudoFile, err := os.OpenFile("/etc/sudoers.d/google_sudoers", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0440)
 	if err != nil {
 		if os.IsExist(err) {
@@ -389,14 +801,15 @@ func createSudoersFile() error {
 		return err
 	}
 	defer sudoFile.Close()
-	fmt.Fprintf(sudoFile, "%%google-sudoers ALL=(ALL:ALL) NOPASSWD:ALL\n")
+	owned.recordFile(sudoFile.Name())
+	fmt.Fprintf(sudoFile, "%%%s ALL=(ALL:ALL) NOPASSWD:ALL\n", sudoersGroup(config))
 	return nil
 }
 
-// createSudoersGroup creates the google-sudoers group if it does not exist.
+// createSudoersGroup creates the sudoers group if it does not exist.
 func createSudoersGroup(ctx context.Context, config *cfg.Sections) error {
 	groupadd := config.Accounts.GroupAddCmd
-	name, args := createUserGroupCmd(groupadd, "", "google-sudoers")
+	name, args := createUserGroupCmd(groupadd, "", sudoersGroup(config))
 	ret := run.WithOutput(ctx, name, args...)
 	if ret.ExitCode == 9 {
 		// 9 means group already exists.
@@ -405,17 +818,95 @@ func createSudoersGroup(ctx context.Context, config *cfg.Sections) error {
 	if ret.ExitCode != 0 {
 		return error(ret)
 	}
-	logger.Infof("Created google sudoers file")
+	owned.recordGroup(sudoersGroup(config))
+	logger.Infof("Created %s group", sudoersGroup(config))
 	return nil
 }
 
+// googleKeyComment precedes the keys added by the agent in the authorized_keys files.
+const googleKeyComment = "# Added by Google"
+
+// splitAuthorizedKeys splits the authorized_keys file contents in the keys added
+// by the user and the keys added by the agent.
+func splitAuthorizedKeys(contents string) (userKeys, googleKeys []string) {
+	var isgoogle bool
+	for _, key := range strings.Split(contents, "\n") {
+		if key == "" {
+			continue
+		}
+		if isgoogle {
+			isgoogle = false
+			googleKeys = append(googleKeys, key)
+			continue
+		}
+		if key == googleKeyComment {
+			isgoogle = true
+			continue
+		}
+		userKeys = append(userKeys, key)
+	}
+	return userKeys, googleKeys
+}
+
+// authorizedKeysDrifted returns true if the keys added by the agent to user's
+// authorized_keys file don't match keys, i.e. the file was edited by hand or removed.
+func authorizedKeysDrifted(user string, keys []string) bool {
+	passwd, err := getPasswd(user)
+	if err != nil || passwd.HomeDir == "" || passwd.Shell == "/sbin/nologin" {
+		return false
+	}
+
+	contents, err := os.ReadFile(path.Join(passwd.HomeDir, ".ssh", "authorized_keys"))
+	if err != nil {
+		return os.IsNotExist(err) && len(keys) > 0
+	}
+	_, googleKeys := splitAuthorizedKeys(string(contents))
+	return !compareStringSlice(keys, googleKeys)
+}
+
+// googleKeysWritten returns true if user's authorized_keys file has keys added by
+// the agent.
+func googleKeysWritten(user string) bool {
+	passwd, err := getPasswd(user)
+	if err != nil || passwd.HomeDir == "" {
+		return false
+	}
+	contents, err := os.ReadFile(path.Join(passwd.HomeDir, ".ssh", "authorized_keys"))
+	if err != nil {
+		return false
+	}
+	_, googleKeys := splitAuthorizedKeys(string(contents))
+	return len(googleKeys) > 0
+}
+
+// removeGoogleKeys removes the keys added by the agent from user's authorized_keys
+// file, the user's own keys are kept. The file is removed if no key is left.
+func removeGoogleKeys(ctx context.Context, user string) error {
+	if !googleKeysWritten(user) {
+		return nil
+	}
+	passwd, err := getPasswd(user)
+	if err != nil {
+		return err
+	}
+
+	akpath := path.Join(passwd.HomeDir, ".ssh", "authorized_keys")
+	contents, err := os.ReadFile(akpath)
+	if err != nil {
+		return err
+	}
+	userKeys, _ := splitAuthorizedKeys(string(contents))
+	if len(userKeys) == 0 {
+		return os.Remove(akpath)
+	}
+	return writeAuthorizedKeysFile(ctx, akpath, []byte(strings.Join(userKeys, "\n")+"\n"), passwd.UID, passwd.GID)
+}
+
 // updateAuthorizedKeysFile adds provided keys to the user's SSH
 // AuthorizedKeys file. The file and containing directory are created if it
-// does not exist. Uses a temporary file to avoid partial updates in case of
-// errors. If no keys are provided, the authorized keys file is removed.
+// does not exist, see writeAuthorizedKeysFile(). If no keys are provided, the
+// authorized keys file is removed.
 func updateAuthorizedKeysFile(ctx context.Context, user string, keys []string) error {
-	gcomment := "# Added by Google"
-
 	passwd, err := getPasswd(user)
 	if err != nil {
 		return err
@@ -437,6 +928,9 @@ func updateAuthorizedKeysFile(ctx context.Context, user string, keys []string) e
 			if err = os.Chown(sshpath, passwd.UID, passwd.GID); err != nil {
 				return err
 			}
+			if err = restoreSELinuxContext(ctx, sshpath); err != nil {
+				return err
+			}
 		} else {
 			return err
 		}
@@ -448,56 +942,79 @@ func updateAuthorizedKeysFile(ctx context.Context, user string, keys []string) e
 		return nil
 	}
 
-	tempPath := akpath + ".google"
 	akcontents, err := os.ReadFile(akpath)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	var isgoogle bool
-	var userKeys []string
-	for _, key := range strings.Split(string(akcontents), "\n") {
-		if key == "" {
-			continue
-		}
-		if isgoogle {
-			isgoogle = false
-			continue
-		}
-		if key == gcomment {
-			isgoogle = true
-			continue
-		}
-		userKeys = append(userKeys, key)
+	userKeys, _ := splitAuthorizedKeys(string(akcontents))
+
+	var buf bytes.Buffer
+	for _, key := range userKeys {
+		fmt.Fprintf(&buf, "%s\n", key)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s\n%s\n", googleKeyComment, key)
 	}
+	return writeAuthorizedKeysFile(ctx, akpath, buf.Bytes(), passwd.UID, passwd.GID)
+}
 
-	newfile, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE, 0600)
+// writeAuthorizedKeysFile atomically replaces the authorized keys file akpath with
+// contents owned by uid and gid: a temporary file is written, synced and renamed
+// over it, so sshd never reads a partially written file. The temporary file is
+// created exclusively with a random name, the directory is owned by the user. The
+// SELinux context is restored once renamed, the temporary file's context doesn't
+// match the authorized keys file's on all policies.
+func writeAuthorizedKeysFile(ctx context.Context, akpath string, contents []byte, uid, gid int) (err error) {
+	tmp, err := os.CreateTemp(path.Dir(akpath), ".authorized_keys.google-*")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create the temporary keys file: %w", err)
 	}
-	defer newfile.Close()
+	defer func() {
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
 
-	for _, key := range userKeys {
-		fmt.Fprintf(newfile, "%s\n", key)
+	if _, err = tmp.Write(contents); err != nil {
+		return fmt.Errorf("failed to write the temporary keys file: %w", err)
 	}
-	for _, key := range keys {
-		fmt.Fprintf(newfile, "%s\n%s\n", gcomment, key)
+	if err = tmp.Chmod(0600); err != nil {
+		return fmt.Errorf("error setting permissions of new keys file: %w", err)
 	}
-	err = os.Chown(tempPath, passwd.UID, passwd.GID)
-	if err != nil {
-		// Existence of temp file will block further updates for this user.
-		// Don't catch remove error, nothing we can do. Return the
-		// chown error which caused the issue.
-		os.Remove(tempPath)
-		return fmt.Errorf("error setting ownership of new keys file: %v", err)
+	if err = tmp.Chown(uid, gid); err != nil {
+		return fmt.Errorf("error setting ownership of new keys file: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the temporary keys file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close the temporary keys file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), akpath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", akpath, err)
 	}
 
-	_, err = exec.LookPath("restorecon")
-	if err == nil {
-		if err := run.Quiet(ctx, "restorecon", tempPath); err != nil {
-			return fmt.Errorf("error setting selinux context: %+v", err)
+	// The rename is durable once the directory is synced.
+	if dir, derr := os.Open(path.Dir(akpath)); derr == nil {
+		if derr = dir.Sync(); derr != nil {
+			logger.Debugf("Failed to sync %s: %v", path.Dir(akpath), derr)
 		}
+		dir.Close()
 	}
 
-	return os.Rename(tempPath, akpath)
+	return restoreSELinuxContext(ctx, akpath)
+}
+
+// restoreSELinuxContext restores the default SELinux context of fpath if restorecon
+// is installed, i.e. on RHEL family distributions.
+func restoreSELinuxContext(ctx context.Context, fpath string) error {
+	if _, err := exec.LookPath("restorecon"); err != nil {
+		return nil
+	}
+	if err := run.Quiet(ctx, "restorecon", "-F", fpath); err != nil {
+		return fmt.Errorf("error setting selinux context of %s: %w", fpath, err)
+	}
+	return nil
 }
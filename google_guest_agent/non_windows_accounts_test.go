@@ -0,0 +1,288 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteAuthorizedKeysFile(t *testing.T) {
+	dir := t.TempDir()
+	akpath := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(akpath, []byte("ssh-rsa OLD user@host\nssh-ed25519 LONGER-OLD-CONTENTS user@host\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%s) = %v, want nil", akpath, err)
+	}
+
+	contents := "ssh-rsa USER user@host\n" + googleKeyComment + "\nssh-ed25519 GOOGLE user@host\n"
+	if err := writeAuthorizedKeysFile(context.Background(), akpath, []byte(contents), os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("writeAuthorizedKeysFile(%s) = %v, want nil", akpath, err)
+	}
+
+	got, err := os.ReadFile(akpath)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%s) = %v, want nil", akpath, err)
+	}
+	if diff := cmp.Diff(contents, string(got)); diff != "" {
+		t.Errorf("writeAuthorizedKeysFile(%s) wrote unexpected contents (-want +got):\n%s", akpath, diff)
+	}
+
+	info, err := os.Stat(akpath)
+	if err != nil {
+		t.Fatalf("os.Stat(%s) = %v, want nil", akpath, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("writeAuthorizedKeysFile(%s) set mode %v, want 0600", akpath, info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir(%s) = %v, want nil", dir, err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("writeAuthorizedKeysFile(%s) left %d files, want only the authorized keys file", akpath, len(entries))
+	}
+}
+
+func TestWriteAuthorizedKeysFileFailure(t *testing.T) {
+	akpath := filepath.Join(t.TempDir(), "missing", "authorized_keys")
+	if err := writeAuthorizedKeysFile(context.Background(), akpath, []byte("ssh-rsa KEY\n"), os.Getuid(), os.Getgid()); err == nil {
+		t.Errorf("writeAuthorizedKeysFile(%s) = nil, want error", akpath)
+	}
+}
+
+func TestUserAddArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  cfg.Accounts
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:   "all options",
+			config: cfg.Accounts{Shell: "/bin/zsh", HomeDirTemplate: "/srv/home/{user}", PrimaryGroup: "users", UIDRange: "2000-2999"},
+			want:   []string{"-s", "/bin/zsh", "-d", "/srv/home/alice", "-g", "users", "-K", "UID_MIN=2000", "-K", "UID_MAX=2999"},
+		},
+		{
+			name:    "invalid uid range",
+			config:  cfg.Accounts{UIDRange: "2999-2000"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed uid range",
+			config:  cfg.Accounts{UIDRange: "2000"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := userAddArgs(&tc.config, "alice")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("userAddArgs(%+v) = %v, want error: %t", tc.config, err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("userAddArgs(%+v) returned unexpected diff (-want +got):\n%s", tc.config, diff)
+			}
+		})
+	}
+}
+
+func TestUserDelCmd(t *testing.T) {
+	tests := []struct {
+		name       string
+		userdel    string
+		removeHome bool
+		want       []string
+	}{
+		{
+			name:    "keep home",
+			userdel: "userdel -r {user}",
+			want:    []string{"userdel", "alice"},
+		},
+		{
+			name:    "keep home long flag",
+			userdel: "/usr/sbin/userdel --remove --force {user}",
+			want:    []string{"/usr/sbin/userdel", "--force", "alice"},
+		},
+		{
+			name:       "remove home",
+			userdel:    "userdel -r {user}",
+			removeHome: true,
+			want:       []string{"userdel", "-r", "alice"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, args := userDelCmd(tc.userdel, "alice", tc.removeHome)
+			if diff := cmp.Diff(tc.want, append([]string{cmd}, args...)); diff != "" {
+				t.Errorf("userDelCmd(%q, alice, %t) returned unexpected diff (-want +got):\n%s", tc.userdel, tc.removeHome, diff)
+			}
+		})
+	}
+}
+
+func TestHomeDirAndSudoersGroup(t *testing.T) {
+	config := &cfg.Sections{Accounts: &cfg.Accounts{}}
+	if got := homeDir(config.Accounts, "alice"); got != "/home/alice" {
+		t.Errorf("homeDir() = %q, want /home/alice", got)
+	}
+	if got := sudoersGroup(config); got != "google-sudoers" {
+		t.Errorf("sudoersGroup() = %q, want google-sudoers", got)
+	}
+
+	config.Accounts.HomeDirTemplate = "/users/{user}/home"
+	config.Accounts.SudoersGroup = "wheel"
+	if got := homeDir(config.Accounts, "alice"); got != "/users/alice/home" {
+		t.Errorf("homeDir() = %q, want /users/alice/home", got)
+	}
+	if got := sudoersGroup(config); got != "wheel" {
+		t.Errorf("sudoersGroup() = %q, want wheel", got)
+	}
+}
+
+func TestDeprovisionDryRun(t *testing.T) {
+	if err := cfg.Load([]byte("[Accounts]\ndeprovision_grace_period = 1h")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+	t.Cleanup(func() { cfg.Load(nil) })
+
+	oldFile, oldPending := googleUsersFile, deprovisionPending
+	t.Cleanup(func() { googleUsersFile, deprovisionPending = oldFile, oldPending })
+	googleUsersFile = filepath.Join(t.TempDir(), "google_users")
+	if err := os.WriteFile(googleUsersFile, []byte("removed-user\npending-user\nexpired-user\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile(%s) = %v, want nil", googleUsersFile, err)
+	}
+	deprovisionPending = map[string]time.Time{
+		"pending-user": time.Now().Add(-30 * time.Minute),
+		"expired-user": time.Now().Add(-2 * time.Hour),
+	}
+
+	got, err := (&accountsMgr{}).DryRun(context.Background(), &metadata.Descriptor{}, &metadata.Descriptor{})
+	if err != nil {
+		t.Fatalf("DryRun() = %v, want nil", err)
+	}
+	want := []string{
+		"remove user expired-user",
+		"remove user pending-user in 30m0s",
+		"revoke keys of user removed-user",
+		"remove user removed-user in 1h0m0s",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DryRun() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeprovisionGoogleUserMissing(t *testing.T) {
+	oldKeys, oldPending := sshKeys, deprovisionPending
+	t.Cleanup(func() { sshKeys, deprovisionPending = oldKeys, oldPending })
+	sshKeys = map[string][]string{"missing-user": nil}
+	deprovisionPending = map[string]time.Time{"missing-user": time.Now()}
+
+	config := &cfg.Sections{Accounts: &cfg.Accounts{}}
+	if err := deprovisionGoogleUser(context.Background(), config, "missing-user"); err != nil {
+		t.Fatalf("deprovisionGoogleUser(missing-user) = %v, want nil", err)
+	}
+	if _, ok := sshKeys["missing-user"]; ok {
+		t.Errorf("deprovisionGoogleUser(missing-user) kept the user in the managed users")
+	}
+	if _, ok := deprovisionPending["missing-user"]; ok {
+		t.Errorf("deprovisionGoogleUser(missing-user) kept the user pending")
+	}
+}
+
+func TestDeprovisionState(t *testing.T) {
+	setOwnedStateFile(t)
+	oldFile, oldPending, oldPersisted, oldCtx := deprovisionStateFile, deprovisionPending, persistedDeprovision, deprovisionCtx
+	t.Cleanup(func() {
+		deprovisionStateFile, deprovisionPending, persistedDeprovision, deprovisionCtx = oldFile, oldPending, oldPersisted, oldCtx
+	})
+	deprovisionStateFile = filepath.Join(t.TempDir(), "google_users_deprovision.json")
+	persistedDeprovision = make(map[string]time.Time)
+
+	revokedAt := time.Now().Add(-30 * time.Minute).Round(0)
+	deprovisionPending = map[string]time.Time{"pending-user": revokedAt}
+	persistDeprovisionState()
+
+	// The revocation times are loaded by the next agent run.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deprovisionPending = make(map[string]time.Time)
+	initDeprovisionState(ctx)
+	if diff := cmp.Diff(map[string]time.Time{"pending-user": revokedAt}, deprovisionPending); diff != "" {
+		t.Errorf("initDeprovisionState() loaded unexpected state (-want +got):\n%s", diff)
+	}
+	if deprovisionCtx != ctx {
+		t.Errorf("initDeprovisionState() didn't record the agent's context")
+	}
+
+	// The file is removed once no user is pending.
+	delete(deprovisionPending, "pending-user")
+	persistDeprovisionState()
+	if _, err := os.Stat(deprovisionStateFile); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v after the last user was deprovisioned, want not exist", deprovisionStateFile, err)
+	}
+}
+
+func TestParseUserKeysPolicy(t *testing.T) {
+	if err := cfg.Load([]byte("[Accounts]\nreject_weak_ssh_keys = true\nssh_key_types = ssh-ed25519, ssh-rsa")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+	t.Cleanup(func() { cfg.Load(nil) })
+
+	edKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOh54lWcJ60jwWy3NeKFPfMKLuhdk2guqc0Osy6tcxad alice"
+	ecdsaKey := "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBPMYRT9XCgmrHdqg6DXk3VyQB0IpvITlIBmnM2Xs9kK6biy8FS1vK2911GXMvBHgPBQ8K3GyUh/PcVkkCUOq6J8= bob"
+	weakKey := "ssh-rsa " + utils.MakeRandRSAPubKey(t) + " carol"
+	mdkeys := []string{
+		"alice:" + edKey,
+		"bob:" + ecdsaKey,
+		"carol:" + weakKey,
+		"malformed",
+		`alice:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOh54lWcJ60jwWy3NeKFPfMKLuhdk2guqc0Osy6tcxad google-ssh {"userName":"alice@example.com","expireOn":"2020-01-01T00:00:00+00:00"}`,
+	}
+
+	keys, rejected := parseUserKeys(mdkeys)
+	if diff := cmp.Diff(map[string][]string{"alice": {edKey}}, keys); diff != "" {
+		t.Errorf("parseUserKeys() returned unexpected keys diff (-want +got):\n%s", diff)
+	}
+
+	if want := "rejected ssh key - key type ecdsa-sha2-nistp256 not accepted"; len(rejected) == 0 || rejected[0].Reason != want {
+		t.Errorf("parseUserKeys() rejected %+v, want the first rejection reason %q", rejected, want)
+	}
+	var gotUsers []string
+	for _, r := range rejected {
+		gotUsers = append(gotUsers, r.User)
+		if r.Reason == "" || r.Key == "" {
+			t.Errorf("parseUserKeys() rejected %+v without a reason or key", r)
+		}
+	}
+	if diff := cmp.Diff([]string{"bob", "carol", "", "alice"}, gotUsers); diff != "" {
+		t.Errorf("parseUserKeys() returned unexpected rejected users diff (-want +got):\n%s", diff)
+	}
+}
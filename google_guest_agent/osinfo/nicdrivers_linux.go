@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package osinfo
+
+import (
+	"os"
+	"path/filepath"
+)
+
+var (
+	// sysClassNet is the sysfs directory of the network interfaces.
+	sysClassNet = "/sys/class/net"
+)
+
+// NICDrivers returns the driver of the physical network interfaces by interface
+// name, read from sysfs.
+func NICDrivers() (map[string]NICDriver, error) {
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]NICDriver)
+	for _, entry := range entries {
+		// Virtual interfaces have no device.
+		driver, err := os.Readlink(filepath.Join(sysClassNet, entry.Name(), "device", "driver"))
+		if err != nil {
+			continue
+		}
+		res[entry.Name()] = nicDriverKind(filepath.Base(driver))
+	}
+	return res, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package osinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNICDrivers(t *testing.T) {
+	dir := t.TempDir()
+	orig := sysClassNet
+	sysClassNet = dir
+	t.Cleanup(func() { sysClassNet = orig })
+
+	drivers := map[string]string{
+		"eth0": "gve",
+		"eth1": "idpf",
+		"eth2": "virtio_net",
+		"eth3": "e1000",
+	}
+	for iface, driver := range drivers {
+		device := filepath.Join(dir, iface, "device")
+		if err := os.MkdirAll(device, 0755); err != nil {
+			t.Fatalf("os.MkdirAll(%s) failed: %v", device, err)
+		}
+		if err := os.Symlink(filepath.Join("../../../bus/pci/drivers", driver), filepath.Join(device, "driver")); err != nil {
+			t.Fatalf("os.Symlink() failed: %v", err)
+		}
+	}
+	// A virtual interface, without device.
+	if err := os.MkdirAll(filepath.Join(dir, "lo"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll() failed: %v", err)
+	}
+
+	want := map[string]NICDriver{
+		"eth0": NICDriverGVNIC,
+		"eth1": NICDriverIDPF,
+		"eth2": NICDriverVirtio,
+		"eth3": NICDriverOther,
+	}
+	got, err := NICDrivers()
+	if err != nil {
+		t.Fatalf("NICDrivers() failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NICDrivers() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
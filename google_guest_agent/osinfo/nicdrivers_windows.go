@@ -0,0 +1,46 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osinfo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+)
+
+// NICDrivers returns the driver of the physical network adapters by adapter name.
+func NICDrivers() (map[string]NICDriver, error) {
+	psCmd := `Get-NetAdapter -Physical | ForEach-Object { $_.Name + "|" + $_.DriverFileName }`
+	res := run.WithOutput(context.Background(), "powershell", "-NoProfile", "-NonInteractive", "-c", psCmd)
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("failed to list the network adapters: %s", res.Error())
+	}
+	return parseNICDrivers(res.StdOut), nil
+}
+
+// parseNICDrivers parses the "name|driver file" lines listing the adapters.
+func parseNICDrivers(out string) map[string]NICDriver {
+	res := make(map[string]NICDriver)
+	for _, line := range strings.Split(out, "\n") {
+		name, driver, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok || name == "" {
+			continue
+		}
+		res[name] = nicDriverKind(driver)
+	}
+	return res
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osinfo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseNICDrivers(t *testing.T) {
+	out := "Ethernet|gvnic.sys\r\nEthernet 2|netkvm.sys\r\nEthernet 3|\r\n\r\n"
+
+	want := map[string]NICDriver{
+		"Ethernet":   NICDriverGVNIC,
+		"Ethernet 2": NICDriverVirtio,
+		"Ethernet 3": NICDriverOther,
+	}
+	if diff := cmp.Diff(want, parseNICDrivers(out)); diff != "" {
+		t.Errorf("parseNICDrivers(%q) returned unexpected diff (-want +got):\n%s", out, diff)
+	}
+}
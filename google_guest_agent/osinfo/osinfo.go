@@ -16,6 +16,7 @@ package osinfo
 
 import (
 	"fmt"
+	"strings"
 )
 
 // OSInfo contains OS information about the system.
@@ -53,3 +54,33 @@ func (v Ver) String() string {
 	}
 	return ret
 }
+
+// NICDriver is the kind of driver of a network interface.
+type NICDriver string
+
+const (
+	// NICDriverVirtio is the virtio-net driver, virtio_net on Linux and netkvm on
+	// Windows.
+	NICDriverVirtio NICDriver = "virtio"
+	// NICDriverGVNIC is the Google Virtual NIC driver, gve on Linux and gvnic on
+	// Windows.
+	NICDriverGVNIC NICDriver = "gvnic"
+	// NICDriverIDPF is the Intel Infrastructure Data Path Function driver.
+	NICDriverIDPF NICDriver = "idpf"
+	// NICDriverOther is any other driver.
+	NICDriverOther NICDriver = "other"
+)
+
+// nicDriverKind returns the kind of the driver name, the Linux module or the Windows
+// driver file name.
+func nicDriverKind(name string) NICDriver {
+	switch strings.TrimSuffix(strings.ToLower(name), ".sys") {
+	case "virtio_net", "netkvm":
+		return NICDriverVirtio
+	case "gve", "gvnic":
+		return NICDriverGVNIC
+	case "idpf":
+		return NICDriverIDPF
+	}
+	return NICDriverOther
+}
@@ -16,7 +16,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"runtime"
@@ -84,30 +86,38 @@ func getOSLoginEnabled(md *metadata.Descriptor) (bool, bool, bool, bool) {
 }
 
 func enableDisableOSLoginCertAuth(ctx context.Context) error {
-	if newMetadata == nil {
+	_, newMd := metadataSnapshot()
+	if newMd == nil {
 		logger.Infof("Could not enable/disable OSLogin Cert Auth, metadata is not initialized.")
 		return nil
 	}
 
 	eventManager := events.Get()
-	osLoginEnabled, _, _, _ := getOSLoginEnabled(newMetadata)
+	osLoginEnabled, _, _, _ := getOSLoginEnabled(newMd)
 	if osLoginEnabled {
 		if trustedCAWatcher == nil {
 			trustedCAWatcher = sshtrustedca.New(sshtrustedca.DefaultPipePath)
 			if err := eventManager.AddWatcher(ctx, trustedCAWatcher); err != nil {
+				trustedCAWatcher = nil
 				return err
 			}
 			sshca.Init()
 		}
+	} else if trustedCAWatcher != nil {
+		sshca.Close()
+		if err := eventManager.RemoveWatcher(ctx, trustedCAWatcher); err != nil {
+			return err
+		}
+		trustedCAWatcher = nil
 	}
 
 	return nil
 }
 
-func (o *osloginMgr) Diff(ctx context.Context) (bool, error) {
-	oldEnable, oldTwoFactor, oldSkey, oldReqCerts := getOSLoginEnabled(oldMetadata)
-	enable, twofactor, skey, reqCerts := getOSLoginEnabled(newMetadata)
-	return oldMetadata.Project.ProjectID == "" ||
+func (o *osloginMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	oldEnable, oldTwoFactor, oldSkey, oldReqCerts := getOSLoginEnabled(oldMd)
+	enable, twofactor, skey, reqCerts := getOSLoginEnabled(newMd)
+	return oldMd.Project.ProjectID == "" ||
 		// True on first run or if any value has changed.
 		(oldTwoFactor != twofactor) ||
 		(oldEnable != enable) ||
@@ -119,23 +129,26 @@ func (o *osloginMgr) Timeout(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (o *osloginMgr) Disabled(ctx context.Context) (bool, error) {
+func (o *osloginMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	return runtime.GOOS == "windows", nil
 }
 
-func (o *osloginMgr) Set(ctx context.Context) error {
+func (o *osloginMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
 	// We need to know if it was previously enabled for the clearing of
 	// metadata-based SSH keys.
-	oldEnable, _, _, _ := getOSLoginEnabled(oldMetadata)
-	enable, twofactor, skey, reqCerts := getOSLoginEnabled(newMetadata)
+	oldEnable, _, _, _ := getOSLoginEnabled(oldMd)
+	enable, twofactor, skey, reqCerts := getOSLoginEnabled(newMd)
 
 	cleanupDeprecatedDirectives()
 
 	if enable && !oldEnable {
 		logger.Infof("Enabling OS Login")
-		newMetadata.Instance.Attributes.SSHKeys = nil
-		newMetadata.Project.Attributes.SSHKeys = nil
-		(&accountsMgr{}).Set(ctx)
+		// Remove the users of the metadata SSH keys, the shared descriptor is
+		// left untouched.
+		noKeys := *newMd
+		noKeys.Instance.Attributes.SSHKeys = nil
+		noKeys.Project.Attributes.SSHKeys = nil
+		(&accountsMgr{}).Set(ctx, oldMd, &noKeys)
 	}
 
 	if !enable && oldEnable {
@@ -198,6 +211,50 @@ func (o *osloginMgr) Set(ctx context.Context) error {
 	return nil
 }
 
+// DryRun returns the OS Login state change and the configuration files Set() would
+// rewrite.
+func (o *osloginMgr) DryRun(ctx context.Context, oldMd, newMd *metadata.Descriptor) ([]string, error) {
+	oldEnable, _, _, _ := getOSLoginEnabled(oldMd)
+	enable, twofactor, skey, reqCerts := getOSLoginEnabled(newMd)
+
+	var changes []string
+	if enable && !oldEnable {
+		changes = append(changes, "enable OS Login, removing the users of the metadata SSH keys")
+	}
+	if !enable && oldEnable {
+		changes = append(changes, "disable OS Login")
+	}
+
+	files := []struct {
+		path   string
+		update func(contents string) string
+	}{
+		{"/etc/ssh/sshd_config", func(c string) string { return updateSSHConfig(c, enable, twofactor, skey, reqCerts) }},
+		{"/etc/nsswitch.conf", func(c string) string { return updateNSSwitchConfig(c, enable) }},
+		{"/etc/pam.d/sshd", func(c string) string { return updatePAMsshdPamless(c, enable, twofactor) }},
+		{"/etc/security/group.conf", func(c string) string { return updateGroupConf(c, enable) }},
+	}
+	for _, f := range files {
+		contents, err := os.ReadFile(f.path)
+		// The missing files aren't created.
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			changes = append(changes, fmt.Sprintf("can't update %s: %v", f.path, err))
+			continue
+		}
+		if _, ok := restorableBackup(f.path, string(contents)); ok && !enable && cfg.Get().OSLogin.RestoreOnDisable {
+			changes = append(changes, fmt.Sprintf("restore %s from the OS Login backup", f.path))
+			continue
+		}
+		if f.update(string(contents)) != string(contents) {
+			changes = append(changes, fmt.Sprintf("rewrite %s", f.path))
+		}
+	}
+	return changes, nil
+}
+
 func cleanupDeprecatedLines(fpath string, directives []string) error {
 	// If the file doesn't exist don't even try updating it.
 	stat, err := os.Stat(fpath)
@@ -275,8 +332,8 @@ func writeConfigFile(path, contents string) error {
 		return err
 	}
 	defer closeFile(file)
-	file.WriteString(contents)
-	return nil
+	_, err = file.WriteString(contents)
+	return err
 }
 
 func updateSSHConfig(sshConfig string, enable, twofactor, skey, reqCerts bool) string {
@@ -329,21 +386,57 @@ func updateSSHConfig(sshConfig string, enable, twofactor, skey, reqCerts bool) s
 		if twofactor {
 			filtered = append(filtered, googleBlockStart, matchblock1, matchblock2, googleBlockEnd)
 		}
+	} else if cfg.Get().Accounts.AuthorizedKeysCommand {
+		// The metadata SSH keys are served by the agent, see authorizedKeysForUser().
+		filtered = append([]string{googleBlockStart, agentAuthorizedKeysCommand, authorizedKeysUser, googleBlockEnd}, filtered...)
 	}
 
 	return strings.Join(filtered, "\n")
 }
 
 func writeSSHConfig(enable, twofactor, skey, reqCerts bool) error {
+	return writeOSLoginFile("/etc/ssh/sshd_config", enable,
+		func(c string) string { return updateSSHConfig(c, enable, twofactor, skey, reqCerts) },
+		func(c string) string { return updateSSHConfig(c, false, false, false, false) })
+}
+
+// reapplySSHConfig re-applies the OS Login sshd configuration, or the agent's
+// AuthorizedKeysCommand, after sshd was restarted, i.e. a package upgrade replaced
+// sshd_config. sshd is only reloaded if its configuration lost the settings.
+func reapplySSHConfig(ctx context.Context) error {
+	_, newMd := metadataSnapshot()
+	if newMd == nil || runtime.GOOS == "windows" {
+		return nil
+	}
+
+	if err := enableDisableOSLoginCertAuth(ctx); err != nil {
+		return err
+	}
+
+	enable, twofactor, skey, reqCerts := getOSLoginEnabled(newMd)
+	if !enable && !cfg.Get().Accounts.AuthorizedKeysCommand {
+		return nil
+	}
+
 	sshConfig, err := os.ReadFile("/etc/ssh/sshd_config")
 	if err != nil {
 		return err
 	}
-	proposed := updateSSHConfig(string(sshConfig), enable, twofactor, skey, reqCerts)
-	if proposed == string(sshConfig) {
+	if updateSSHConfig(string(sshConfig), enable, twofactor, skey, reqCerts) == string(sshConfig) {
 		return nil
 	}
-	return writeConfigFile("/etc/ssh/sshd_config", proposed)
+
+	logger.Infof("sshd configuration lost the agent's settings, re-applying them.")
+	if err := writeSSHConfig(enable, twofactor, skey, reqCerts); err != nil {
+		return err
+	}
+
+	for _, svc := range []string{"ssh", "sshd"} {
+		if err := systemctlReloadOrRestart(ctx, svc); err != nil {
+			logger.Errorf("Error reloading service: %v.", err)
+		}
+	}
+	return nil
 }
 
 func updateNSSwitchConfig(nsswitch string, enable bool) string {
@@ -369,15 +462,9 @@ func updateNSSwitchConfig(nsswitch string, enable bool) string {
 }
 
 func writeNSSwitchConfig(enable bool) error {
-	nsswitch, err := os.ReadFile("/etc/nsswitch.conf")
-	if err != nil {
-		return err
-	}
-	proposed := updateNSSwitchConfig(string(nsswitch), enable)
-	if proposed == string(nsswitch) {
-		return nil
-	}
-	return writeConfigFile("/etc/nsswitch.conf", proposed)
+	return writeOSLoginFile("/etc/nsswitch.conf", enable,
+		func(c string) string { return updateNSSwitchConfig(c, enable) },
+		func(c string) string { return updateNSSwitchConfig(c, false) })
 }
 
 func updatePAMsshdPamless(pamsshd string, enable, twofactor bool) string {
@@ -406,19 +493,9 @@ func updatePAMsshdPamless(pamsshd string, enable, twofactor bool) string {
 }
 
 func writePAMConfig(enable, twofactor bool) error {
-	pamsshd, err := os.ReadFile("/etc/pam.d/sshd")
-	if err != nil {
-		return err
-	}
-
-	proposed := updatePAMsshdPamless(string(pamsshd), enable, twofactor)
-	if proposed != string(pamsshd) {
-		if err := writeConfigFile("/etc/pam.d/sshd", proposed); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return writeOSLoginFile("/etc/pam.d/sshd", enable,
+		func(c string) string { return updatePAMsshdPamless(c, enable, twofactor) },
+		func(c string) string { return updatePAMsshdPamless(c, false, false) })
 }
 
 func updateGroupConf(groupconf string, enable bool) string {
@@ -433,17 +510,9 @@ func updateGroupConf(groupconf string, enable bool) string {
 }
 
 func writeGroupConf(enable bool) error {
-	groupconf, err := os.ReadFile("/etc/security/group.conf")
-	if err != nil {
-		return err
-	}
-	proposed := updateGroupConf(string(groupconf), enable)
-	if proposed != string(groupconf) {
-		if err := writeConfigFile("/etc/security/group.conf", proposed); err != nil {
-			return err
-		}
-	}
-	return nil
+	return writeOSLoginFile("/etc/security/group.conf", enable,
+		func(c string) string { return updateGroupConf(c, enable) },
+		func(c string) string { return updateGroupConf(c, false) })
 }
 
 // Creates necessary OS Login directories if they don't exist.
@@ -474,6 +543,7 @@ func createOSLoginSudoersFile() error {
 		}
 		return err
 	}
+	owned.recordFile(osloginSudoers)
 	fmt.Fprintf(sudoFile, "#includedir /var/google-sudoers.d\n")
 	return sudoFile.Close()
 }
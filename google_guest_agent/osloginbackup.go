@@ -0,0 +1,174 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// osloginBackupDir is where the configuration files are backed up before OS Login
+	// changes them, replaceable by unit tests.
+	osloginBackupDir = "/var/lib/google/oslogin-backup"
+
+	// writeOSLoginConfig writes the OS Login configuration files, replaceable by unit
+	// tests.
+	writeOSLoginConfig = writeConfigFile
+)
+
+// osloginBackupPath returns the backup path of the configuration file path, i.e.
+// etc_ssh_sshd_config for /etc/ssh/sshd_config.
+func osloginBackupPath(path string) string {
+	return filepath.Join(osloginBackupDir, strings.ReplaceAll(strings.TrimPrefix(filepath.Clean(path), "/"), "/", "_"))
+}
+
+// osloginWrittenPath returns the path of the checksum of the last contents of path
+// written with OS Login enabled.
+func osloginWrittenPath(path string) string {
+	return osloginBackupPath(path) + ".written"
+}
+
+// contentsChecksum returns the SHA256 checksum of contents.
+func contentsChecksum(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+// backupOSLoginFile backs up the contents of path, unless already backed up, before
+// OS Login changes it. The contents are backed up as disable() leaves them, they
+// may already have been changed by OS Login, i.e. by an agent without backups.
+func backupOSLoginFile(path, contents string, disable func(string) string) error {
+	backup := osloginBackupPath(path)
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(osloginBackupDir, 0700); err != nil {
+		return err
+	}
+
+	if cleaned := disable(contents); cleaned != contents {
+		logger.Infof("%s already has OS Login settings, backing up its contents without them.", path)
+		contents = cleaned
+	}
+	if err := os.WriteFile(backup, []byte(contents), 0600); err != nil {
+		return err
+	}
+	owned.recordFile(backup)
+	return nil
+}
+
+// recordOSLoginWrite records the contents of path written with OS Login enabled, the
+// backup is only restored if the file wasn't changed since.
+func recordOSLoginWrite(path, contents string) error {
+	written := osloginWrittenPath(path)
+	if err := os.WriteFile(written, []byte(contentsChecksum(contents)), 0600); err != nil {
+		return err
+	}
+	owned.recordFile(written)
+	return nil
+}
+
+// restorableBackup returns the backup of path if it can be restored, the file's
+// contents are the last ones written with OS Login enabled.
+func restorableBackup(path, contents string) (string, bool) {
+	backup, err := os.ReadFile(osloginBackupPath(path))
+	if err != nil {
+		return "", false
+	}
+	written, err := os.ReadFile(osloginWrittenPath(path))
+	if err != nil || string(written) != contentsChecksum(contents) {
+		return "", false
+	}
+	return string(backup), true
+}
+
+// restoreOSLoginFile returns the backup of path to restore, when OS Login is disabled,
+// if restore_on_disable is set and the file wasn't changed since OS Login last wrote
+// it. The changed files are cleaned up by removing the OS Login settings instead. The
+// returned func removes the backup, it's called once path was written so a failed
+// write is retried with the backup.
+func restoreOSLoginFile(path, contents string) (string, bool, func()) {
+	backup := osloginBackupPath(path)
+	if _, err := os.Stat(backup); errors.Is(err, fs.ErrNotExist) {
+		return "", false, func() {}
+	}
+
+	restored, ok := restorableBackup(path, contents)
+	if !cfg.Get().OSLogin.RestoreOnDisable {
+		ok = false
+	} else if !ok {
+		logger.Warningf("%s was changed since OS Login was enabled, removing the OS Login settings instead of restoring its backup.", path)
+	}
+	if ok {
+		logger.Infof("Restoring %s from the backup taken when OS Login was enabled.", path)
+	}
+
+	removeBackup := func() {
+		for _, p := range []string{backup, osloginWrittenPath(path)} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logger.Errorf("Failed to remove the OS Login backup %s: %v", p, err)
+			}
+		}
+	}
+	return restored, ok, removeBackup
+}
+
+// writeOSLoginFile updates the configuration file path with update, the file is
+// backed up before OS Login changes it and restored when it's disabled, see
+// restoreOSLoginFile(). disable removes the OS Login settings from the contents.
+func writeOSLoginFile(path string, enable bool, update, disable func(string) string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	backup := enable && cfg.Get().OSLogin.RestoreOnDisable
+	current := string(contents)
+	removeBackup := func() {}
+	if backup {
+		// OS Login is enabled regardless, the file just won't be restored.
+		if err := backupOSLoginFile(path, current, disable); err != nil {
+			logger.Errorf("Failed to back up %s, it won't be restored when OS Login is disabled: %v", path, err)
+			backup = false
+		}
+	} else if !enable {
+		var restored string
+		var ok bool
+		if restored, ok, removeBackup = restoreOSLoginFile(path, current); ok {
+			current = restored
+		}
+	}
+
+	proposed := update(current)
+	if proposed != string(contents) {
+		if err := writeOSLoginConfig(path, proposed); err != nil {
+			return err
+		}
+	}
+	removeBackup()
+	if backup {
+		return recordOSLoginWrite(path, proposed)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+)
+
+// setOSLoginBackupDir points the OS Login backups to a temporary directory for the
+// test's duration and returns the path of a nsswitch.conf with contents.
+func setOSLoginBackupDir(t *testing.T, contents string) string {
+	t.Helper()
+	setOwnedStateFile(t)
+	oldDir := osloginBackupDir
+	t.Cleanup(func() { osloginBackupDir = oldDir })
+	osloginBackupDir = filepath.Join(t.TempDir(), "oslogin-backup")
+
+	if err := cfg.Load(nil); err != nil {
+		t.Fatalf("cfg.Load(nil) = %v, want nil", err)
+	}
+	path := filepath.Join(t.TempDir(), "nsswitch.conf")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%s) = %v, want nil", path, err)
+	}
+	return path
+}
+
+// writeNSSwitch updates the nsswitch.conf at path as writeNSSwitchConfig() does.
+func writeNSSwitch(t *testing.T, path string, enable bool) string {
+	t.Helper()
+	err := writeOSLoginFile(path, enable,
+		func(c string) string { return updateNSSwitchConfig(c, enable) },
+		func(c string) string { return updateNSSwitchConfig(c, false) })
+	if err != nil {
+		t.Fatalf("writeOSLoginFile(%s, %t) = %v, want nil", path, enable, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%s) = %v, want nil", path, err)
+	}
+	return string(data)
+}
+
+func TestOSLoginRestoreOnDisable(t *testing.T) {
+	original := "passwd: files systemd\ngroup: files systemd\n"
+	path := setOSLoginBackupDir(t, original)
+
+	if got := writeNSSwitch(t, path, true); got == original {
+		t.Fatalf("enabling OS Login left %s unchanged", path)
+	}
+	if _, err := os.Stat(osloginBackupPath(path)); err != nil {
+		t.Errorf("enabling OS Login didn't back up %s: %v", path, err)
+	}
+	// Re-enabling keeps the original backup.
+	writeNSSwitch(t, path, true)
+
+	if got := writeNSSwitch(t, path, false); got != original {
+		t.Errorf("disabling OS Login restored %q, want %q", got, original)
+	}
+	for _, p := range []string{osloginBackupPath(path), osloginWrittenPath(path)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("disabling OS Login left the backup %s: %v", p, err)
+		}
+	}
+}
+
+func TestOSLoginRestoreOnDisableChanged(t *testing.T) {
+	path := setOSLoginBackupDir(t, "passwd: files\ngroup: files\n")
+
+	enabled := writeNSSwitch(t, path, true)
+	// An administrator changes the file while OS Login is enabled.
+	changed := enabled + "hosts: files dns\n"
+	if err := os.WriteFile(path, []byte(changed), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%s) = %v, want nil", path, err)
+	}
+
+	want := updateNSSwitchConfig(changed, false)
+	if got := writeNSSwitch(t, path, false); got != want {
+		t.Errorf("disabling OS Login wrote %q, want the OS Login settings removed %q", got, want)
+	}
+	if _, err := os.Stat(osloginBackupPath(path)); !os.IsNotExist(err) {
+		t.Errorf("disabling OS Login left the backup of %s: %v", path, err)
+	}
+}
+
+func TestOSLoginRestoreOnDisableOff(t *testing.T) {
+	path := setOSLoginBackupDir(t, "passwd: files\ngroup: files\n")
+	cfg.Get().OSLogin.RestoreOnDisable = false
+	t.Cleanup(func() { cfg.Get().OSLogin.RestoreOnDisable = true })
+
+	writeNSSwitch(t, path, true)
+	if _, err := os.Stat(osloginBackupPath(path)); !os.IsNotExist(err) {
+		t.Errorf("enabling OS Login with restore_on_disable off backed up %s: %v", path, err)
+	}
+}
+
+func TestOSLoginRestoreOnDisableWriteFailure(t *testing.T) {
+	original := "passwd: files systemd\ngroup: files systemd\n"
+	path := setOSLoginBackupDir(t, original)
+	writeNSSwitch(t, path, true)
+
+	oldWrite := writeOSLoginConfig
+	t.Cleanup(func() { writeOSLoginConfig = oldWrite })
+	writeOSLoginConfig = func(string, string) error { return errors.New("disk full") }
+
+	err := writeOSLoginFile(path, false,
+		func(c string) string { return updateNSSwitchConfig(c, false) },
+		func(c string) string { return updateNSSwitchConfig(c, false) })
+	if err == nil {
+		t.Fatalf("writeOSLoginFile(%s, false) = nil, want the write error", path)
+	}
+	for _, p := range []string{osloginBackupPath(path), osloginWrittenPath(path)} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("the failed write removed the backup %s: %v", p, err)
+		}
+	}
+
+	// The next attempt still restores the backup.
+	writeOSLoginConfig = oldWrite
+	if got := writeNSSwitch(t, path, false); got != original {
+		t.Errorf("disabling OS Login after a failed write restored %q, want %q", got, original)
+	}
+}
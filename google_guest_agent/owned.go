@@ -0,0 +1,303 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// ownedStateFile is the file recording the changes made by the agent, i.e. the
+	// users it created, so they're reverted when the agent is uninstalled.
+	ownedStateFile = defaultOwnedStateFile()
+
+	// owned is the agent's record of the changes it made.
+	owned = &ownedRegistry{}
+)
+
+func defaultOwnedStateFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "Google", "Compute Engine", "guest-agent-owned.json")
+	}
+	return "/var/lib/google/guest-agent-owned.json"
+}
+
+// ownedRoute is a forwarded IP route added by the agent.
+type ownedRoute struct {
+	// Interface is the name of the network interface the route was added on.
+	Interface string `json:"interface"`
+	// Address is the route's address or range, i.e. "10.0.0.1" or "10.0.0.0/24".
+	Address string `json:"address"`
+}
+
+// ownedState is the content of the owned state file.
+type ownedState struct {
+	// Users are the user accounts created by the agent.
+	Users []string `json:"users,omitempty"`
+	// Groups are the groups created by the agent.
+	Groups []string `json:"groups,omitempty"`
+	// Routes are the forwarded IP routes added by the agent.
+	Routes []ownedRoute `json:"routes,omitempty"`
+	// Files are the files created by the agent, other than its own state.
+	Files []string `json:"files,omitempty"`
+	// FirewallRules is set if the agent set up the forwarded IPs firewall rules.
+	FirewallRules bool `json:"firewall_rules,omitempty"`
+}
+
+// empty returns true if no change is recorded.
+func (s ownedState) empty() bool {
+	return len(s.Users) == 0 && len(s.Groups) == 0 && len(s.Routes) == 0 && len(s.Files) == 0 && !s.FirewallRules
+}
+
+// ownedRegistry records the changes made by the agent, persisting them to
+// ownedStateFile with each change.
+type ownedRegistry struct {
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// loaded is set once the state was read from ownedStateFile.
+	loaded bool
+	// state is the recorded changes.
+	state ownedState
+}
+
+// readOwnedState reads the owned state file, an empty state is returned if it
+// doesn't exist.
+func readOwnedState(path string) (ownedState, error) {
+	var state ownedState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeOwnedState writes the owned state file atomically, the file is removed
+// if the state is empty.
+func writeOwnedState(path string, state ownedState) error {
+	if state.empty() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// update applies fn to the recorded state and persists it if fn changed it.
+func (r *ownedRegistry) update(fn func(state *ownedState) bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.loaded {
+		state, err := readOwnedState(ownedStateFile)
+		if err != nil {
+			// Keep the unreadable file's records from being overwritten.
+			logger.Errorf("Failed to read the owned state, not recording the agent's changes: %v", err)
+			return
+		}
+		r.state, r.loaded = state, true
+	}
+
+	if !fn(&r.state) {
+		return
+	}
+	if err := writeOwnedState(ownedStateFile, r.state); err != nil {
+		logger.Errorf("Failed to write the owned state: %v", err)
+	}
+}
+
+// addOwned adds value to list, returning false if it's already there.
+func addOwned[T comparable](list *[]T, value T) bool {
+	if slices.Contains(*list, value) {
+		return false
+	}
+	*list = append(*list, value)
+	return true
+}
+
+// removeOwned removes value from list, returning false if it isn't there.
+func removeOwned[T comparable](list *[]T, value T) bool {
+	i := slices.Index(*list, value)
+	if i < 0 {
+		return false
+	}
+	*list = slices.Delete(*list, i, i+1)
+	return true
+}
+
+// recordUser records the user account created by the agent.
+func (r *ownedRegistry) recordUser(user string) {
+	r.update(func(s *ownedState) bool { return addOwned(&s.Users, user) })
+}
+
+// forgetUser forgets the user account removed by the agent.
+func (r *ownedRegistry) forgetUser(user string) {
+	r.update(func(s *ownedState) bool { return removeOwned(&s.Users, user) })
+}
+
+// recordGroup records the group created by the agent.
+func (r *ownedRegistry) recordGroup(group string) {
+	r.update(func(s *ownedState) bool { return addOwned(&s.Groups, group) })
+}
+
+// recordRoute records the forwarded IP route added by the agent.
+func (r *ownedRegistry) recordRoute(iface, address string) {
+	r.update(func(s *ownedState) bool { return addOwned(&s.Routes, ownedRoute{iface, address}) })
+}
+
+// forgetRoute forgets the forwarded IP route removed by the agent.
+func (r *ownedRegistry) forgetRoute(iface, address string) {
+	r.update(func(s *ownedState) bool { return removeOwned(&s.Routes, ownedRoute{iface, address}) })
+}
+
+// recordFile records the file created by the agent.
+func (r *ownedRegistry) recordFile(path string) {
+	r.update(func(s *ownedState) bool { return addOwned(&s.Files, path) })
+}
+
+// recordFirewallRules records whether the agent set up the forwarded IPs firewall
+// rules.
+func (r *ownedRegistry) recordFirewallRules(set bool) {
+	r.update(func(s *ownedState) bool {
+		changed := s.FirewallRules != set
+		s.FirewallRules = set
+		return changed
+	})
+}
+
+// hasFirewallRules returns true if the agent set up the forwarded IPs firewall
+// rules.
+func (r *ownedRegistry) hasFirewallRules() bool {
+	var set bool
+	r.update(func(s *ownedState) bool {
+		set = s.FirewallRules
+		return false
+	})
+	return set
+}
+
+// removeOwnedRoute removes the forwarded IP route added by the agent.
+func removeOwnedRoute(ctx context.Context, config *cfg.Sections, route ownedRoute) error {
+	if runtime.GOOS != "windows" {
+		return removeLocalRoute(ctx, config, route.Address, route.Interface)
+	}
+
+	iface, err := net.InterfaceByName(route.Interface)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(route.Address); ip != nil && !isIPv6(ip) {
+		return removeAddress(ip, net.IPv4Mask(255, 255, 255, 255), uint32(iface.Index))
+	}
+	return removeIpv6Address(route.Address, uint32(iface.Index))
+}
+
+// cleanupOwned reverts the changes recorded in the owned state file, i.e. when
+// the agent is uninstalled. The routes and firewall rules are removed first, then
+// the users, the groups and the files. The users' home directories are kept, they
+// hold the users' data, unless removeHomes is set. The changes failing to be reverted
+// are kept in the file and reported, the file is removed once all of them are reverted.
+func cleanupOwned(ctx context.Context, path string, removeHomes bool) error {
+	state, err := readOwnedState(path)
+	if err != nil {
+		return err
+	}
+	config := cfg.Get()
+	var errs []error
+	var left ownedState
+
+	for _, route := range state.Routes {
+		logger.Infof("Removing route %s on %s.", route.Address, route.Interface)
+		if err := removeOwnedRoute(ctx, config, route); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove route %s on %s: %w", route.Address, route.Interface, err))
+			left.Routes = append(left.Routes, route)
+		}
+	}
+
+	if state.FirewallRules {
+		logger.Infof("Removing the forwarded IPs firewall rules.")
+		if err := setFirewallRules(ctx, nil); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove the forwarded IPs firewall rules: %w", err))
+			left.FirewallRules = true
+		}
+	}
+
+	for _, name := range state.Users {
+		if _, err := userExists(name); err != nil {
+			continue
+		}
+		logger.Infof("Removing user %s.", name)
+		if err := deleteUser(ctx, name, removeHomes); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove user %s: %w", name, err))
+			left.Users = append(left.Users, name)
+		}
+	}
+
+	for _, group := range state.Groups {
+		// The users' own groups are removed along with them.
+		if _, err := user.LookupGroup(group); err != nil {
+			continue
+		}
+		logger.Infof("Removing group %s.", group)
+		if err := deleteGroup(ctx, group); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove group %s: %w", group, err))
+			left.Groups = append(left.Groups, group)
+		}
+	}
+
+	for _, file := range state.Files {
+		logger.Infof("Removing file %s.", file)
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove file %s: %w", file, err))
+			left.Files = append(left.Files, file)
+		}
+	}
+
+	if err := writeOwnedState(path, left); err != nil {
+		errs = append(errs, fmt.Errorf("failed to write the owned state: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// setOwnedStateFile points the owned state to a file in a temporary directory
+// for the test's duration.
+func setOwnedStateFile(t *testing.T) string {
+	t.Helper()
+	oldFile, oldOwned := ownedStateFile, owned
+	t.Cleanup(func() { ownedStateFile, owned = oldFile, oldOwned })
+
+	ownedStateFile = filepath.Join(t.TempDir(), "guest-agent-owned.json")
+	owned = &ownedRegistry{}
+	return ownedStateFile
+}
+
+func TestOwnedRegistry(t *testing.T) {
+	path := setOwnedStateFile(t)
+
+	owned.recordUser("foo")
+	owned.recordUser("bar")
+	owned.recordUser("foo")
+	owned.recordGroup("google-sudoers")
+	owned.recordRoute("eth0", "10.0.0.1")
+	owned.recordRoute("eth0", "10.0.0.2")
+	owned.recordFile("/etc/sudoers.d/google_sudoers")
+	owned.forgetUser("foo")
+	owned.forgetRoute("eth0", "10.0.0.1")
+	if owned.hasFirewallRules() {
+		t.Errorf("hasFirewallRules() = true before recording them, want false")
+	}
+	owned.recordFirewallRules(true)
+	if !owned.hasFirewallRules() {
+		t.Errorf("hasFirewallRules() = false after recording them, want true")
+	}
+
+	want := ownedState{
+		Users:         []string{"bar"},
+		Groups:        []string{"google-sudoers"},
+		Routes:        []ownedRoute{{Interface: "eth0", Address: "10.0.0.2"}},
+		Files:         []string{"/etc/sudoers.d/google_sudoers"},
+		FirewallRules: true,
+	}
+	got, err := readOwnedState(path)
+	if err != nil {
+		t.Fatalf("readOwnedState(%q) failed: %v", path, err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readOwnedState(%q) returned unexpected state (-want +got):\n%s", path, diff)
+	}
+
+	// A new agent run picks up the recorded changes.
+	owned = &ownedRegistry{}
+	owned.recordFile("/etc/sudoers.d/google-oslogin")
+	want.Files = append(want.Files, "/etc/sudoers.d/google-oslogin")
+	if got, _ = readOwnedState(path); !cmp.Equal(want, got) {
+		t.Errorf("readOwnedState(%q) = %+v, want %+v", path, got, want)
+	}
+}
+
+func TestCleanupOwned(t *testing.T) {
+	reloadConfig(t, nil)
+	path := setOwnedStateFile(t)
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "google_sudoers")
+	if err := os.WriteFile(file, []byte("%google-sudoers ALL=(ALL:ALL) NOPASSWD:ALL\n"), 0440); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", file, err)
+	}
+	// A non-empty directory can't be removed.
+	busy := filepath.Join(dir, "busy")
+	if err := os.MkdirAll(filepath.Join(busy, "file"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll(%q) failed: %v", busy, err)
+	}
+
+	for _, f := range []string{file, busy, filepath.Join(dir, "removed")} {
+		owned.recordFile(f)
+	}
+	owned.recordUser("google-guest-agent-test-missing-user")
+	owned.recordGroup("google-guest-agent-test-missing-group")
+
+	if err := cleanupOwned(context.Background(), path, false); err == nil {
+		t.Errorf("cleanupOwned(%q) succeeded with a non-empty directory recorded, want error", path)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("cleanupOwned(%q) didn't remove %q", path, file)
+	}
+
+	got, err := readOwnedState(path)
+	if err != nil {
+		t.Fatalf("readOwnedState(%q) failed: %v", path, err)
+	}
+	if diff := cmp.Diff(ownedState{Files: []string{busy}}, got); diff != "" {
+		t.Errorf("cleanupOwned(%q) left unexpected state (-want +got):\n%s", path, diff)
+	}
+
+	if err := os.RemoveAll(filepath.Join(busy, "file")); err != nil {
+		t.Fatalf("os.RemoveAll() failed: %v", err)
+	}
+	if err := cleanupOwned(context.Background(), path, false); err != nil {
+		t.Errorf("cleanupOwned(%q) failed: %v", path, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cleanupOwned(%q) didn't remove the reverted state file", path)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// pauseAgent suspends the event handling and the scheduled jobs, i.e. during
+// maintenance. Metadata changes are applied once the agent is resumed.
+func pauseAgent(ctx context.Context) {
+	logger.Infof("Pausing the agent.")
+	events.Get().Pause()
+	scheduler.Get().Pause()
+	reportStatus(ctx, "paused")
+}
+
+// resumeAgent resumes a previously paused agent.
+func resumeAgent(ctx context.Context) {
+	logger.Infof("Resuming the agent.")
+	scheduler.Get().Resume()
+	events.Get().Resume()
+	reportStatus(ctx, "resumed, watching metadata for changes")
+}
@@ -0,0 +1,402 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugin implements the out-of-process manager plugins. A plugin is an
+// executable in the plugins directory that the agent starts and supervises, it
+// serves the ManagerPlugin gRPC service on a local Unix socket (Windows named
+// pipe) and is fed the metadata changes as the agent's own managers are.
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/plugin/pluginpb"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AddressEnv is the environment variable holding the socket (named pipe) path
+	// the plugin serves on, set by the agent when starting the plugin.
+	AddressEnv = "GUEST_AGENT_PLUGIN_ADDRESS"
+	// minRestartDelay is the delay before restarting a plugin that exited, doubled
+	// for each consecutive early exit.
+	minRestartDelay = time.Second
+	// maxRestartDelay caps the restart delay, a plugin that ran for longer is
+	// restarted after minRestartDelay.
+	maxRestartDelay = time.Minute
+	// describeTimeout is how long a started plugin may take to serve, it's
+	// restarted otherwise.
+	describeTimeout = 30 * time.Second
+)
+
+// Manager is implemented by the plugins, see Serve(). The descriptors are
+// decoded from the agent's request and may be nil, i.e. the old metadata on the
+// agent's first run.
+type Manager interface {
+	// Diff returns true if the plugin has changes to apply from oldMd to newMd.
+	Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error)
+	// Set applies the plugin's configuration from newMd.
+	Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error
+}
+
+// server implements the ManagerPlugin service on top of a Manager.
+type server struct {
+	pluginpb.UnimplementedManagerPluginServer
+
+	// version is the plugin's version.
+	version string
+	// mgr is the plugin's manager.
+	mgr Manager
+}
+
+// Describe returns the plugin's version.
+func (s *server) Describe(ctx context.Context, req *pluginpb.DescribeRequest) (*pluginpb.DescribeResponse, error) {
+	return &pluginpb.DescribeResponse{Version: s.version}, nil
+}
+
+// Diff decodes the request's metadata and calls the manager's Diff().
+func (s *server) Diff(ctx context.Context, req *pluginpb.MetadataRequest) (*pluginpb.DiffResponse, error) {
+	oldMd, newMd, err := decodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := s.mgr.Diff(ctx, oldMd, newMd)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.DiffResponse{Changed: changed}, nil
+}
+
+// Set decodes the request's metadata and calls the manager's Set().
+func (s *server) Set(ctx context.Context, req *pluginpb.MetadataRequest) (*pluginpb.SetResponse, error) {
+	oldMd, newMd, err := decodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mgr.Set(ctx, oldMd, newMd); err != nil {
+		return nil, err
+	}
+	return &pluginpb.SetResponse{}, nil
+}
+
+// decodeRequest returns the old and new metadata of req, nil if empty.
+func decodeRequest(req *pluginpb.MetadataRequest) (oldMd, newMd *metadata.Descriptor, err error) {
+	if oldMd, err = decodeMetadata(req.GetOldMetadata()); err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid old metadata: %v", err)
+	}
+	if newMd, err = decodeMetadata(req.GetNewMetadata()); err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid new metadata: %v", err)
+	}
+	return oldMd, newMd, nil
+}
+
+// decodeMetadata returns the JSON encoded descriptor data, nil if empty.
+func decodeMetadata(data []byte) (*metadata.Descriptor, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var md metadata.Descriptor
+	if err := json.Unmarshal(data, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// encodeMetadata returns md JSON encoded, empty if nil.
+func encodeMetadata(md *metadata.Descriptor) ([]byte, error) {
+	if md == nil {
+		return nil, nil
+	}
+	return json.Marshal(md)
+}
+
+// Serve serves mgr, reporting version, on the address the agent started the
+// plugin with until ctx is done. It's the plugin's main loop.
+func Serve(ctx context.Context, version string, mgr Manager) error {
+	address := os.Getenv(AddressEnv)
+	if address == "" {
+		return fmt.Errorf("%s is not set, the plugin must be started by the guest agent", AddressEnv)
+	}
+
+	l, err := listen(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	srv := grpc.NewServer()
+	pluginpb.RegisterManagerPluginServer(srv, &server{version: version, mgr: mgr})
+
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+
+	return srv.Serve(l)
+}
+
+// Plugin is a manager plugin discovered by Discover(), it's run by Start().
+type Plugin struct {
+	// Name is the plugin's name, its executable's name without the extension.
+	Name string
+	// Path is the plugin's executable path.
+	Path string
+	// address is the socket (named pipe) path the plugin serves on.
+	address string
+	// agentVersion is the agent's version, reported to the plugin.
+	agentVersion string
+
+	// mutex protects conn.
+	mutex sync.Mutex
+	// conn is the connection to the plugin, nil until first used.
+	conn *grpc.ClientConn
+}
+
+// Discover returns the plugins in dir, the executables it contains. The
+// executables writable by other users than root (Linux only) are ignored, so are
+// the ones sharing a name. A missing dir has no plugins.
+func Discover(dir, agentVersion string) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugins directory %s: %w", dir, err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat plugins directory %s: %w", dir, err)
+	}
+	if err := checkPermissions(info); err != nil {
+		return nil, fmt.Errorf("unsafe plugins directory %s: %w", dir, err)
+	}
+
+	var res []*Plugin
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Errorf("Failed to stat plugin %s, ignoring: %v", path, err)
+			continue
+		}
+		if info.IsDir() || !executable(info) {
+			logger.Debugf("Ignoring %s in the plugins directory, not an executable", path)
+			continue
+		}
+		if err := checkPermissions(info); err != nil {
+			logger.Errorf("Ignoring unsafe plugin %s: %v", path, err)
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if slices.ContainsFunc(res, func(p *Plugin) bool { return p.Name == name }) {
+			logger.Errorf("Ignoring plugin %s, a plugin named %q already exists", path, name)
+			continue
+		}
+
+		res = append(res, &Plugin{
+			Name:         name,
+			Path:         path,
+			address:      address(name),
+			agentVersion: agentVersion,
+		})
+	}
+	return res, nil
+}
+
+// Start starts the plugin and supervises it until ctx is done, the plugin is
+// restarted if it exits or doesn't serve within describeTimeout.
+func (p *Plugin) Start(ctx context.Context) {
+	go p.supervise(ctx)
+}
+
+// supervise runs the plugin until ctx is done, the restarts are delayed by
+// minRestartDelay doubled for each consecutive early exit.
+func (p *Plugin) supervise(ctx context.Context) {
+	defer p.close()
+
+	delay := minRestartDelay
+	for {
+		start := time.Now()
+		err := p.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(start) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		logger.Errorf("Plugin %q exited: %v, restarting it in %s", p.Name, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+// run runs the plugin's executable until it exits, its output is logged.
+func (p *Plugin) run(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Env = append(os.Environ(), AddressEnv+"="+p.address)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get the plugin's output: %w", err)
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the plugin: %w", err)
+	}
+	logger.Infof("Started plugin %q (pid %d)", p.Name, cmd.Process.Pid)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go p.describe(runCtx, cmd.Process)
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		logger.Infof("[plugin %s] %s", p.Name, scanner.Text())
+	}
+	return cmd.Wait()
+}
+
+// describe logs the started plugin's version, the plugin's process is killed if
+// it doesn't serve within describeTimeout.
+func (p *Plugin) describe(ctx context.Context, process *os.Process) {
+	client, err := p.client()
+	if err != nil {
+		logger.Errorf("Failed to connect to plugin %q: %v", p.Name, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+
+	resp, err := client.Describe(ctx, &pluginpb.DescribeRequest{AgentVersion: p.agentVersion}, grpc.WaitForReady(true))
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logger.Errorf("Plugin %q isn't serving after %s, killing it: %v", p.Name, describeTimeout, err)
+			process.Kill()
+		}
+		return
+	}
+	logger.Infof("Plugin %q (version %s) is serving", p.Name, resp.GetVersion())
+}
+
+// client returns the plugin's client, the connection is established on first
+// use and re-established as the plugin restarts.
+func (p *Plugin) client() (pluginpb.ManagerPluginClient, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn == nil {
+		conn, err := grpc.Dial("passthrough:///"+p.address,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dial(ctx, p.address)
+			}))
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+	}
+	return pluginpb.NewManagerPluginClient(p.conn), nil
+}
+
+// close closes the connection to the plugin, if any.
+func (p *Plugin) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// metadataRequest returns the request carrying oldMd and newMd.
+func metadataRequest(oldMd, newMd *metadata.Descriptor) (*pluginpb.MetadataRequest, error) {
+	oldData, err := encodeMetadata(oldMd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode old metadata: %w", err)
+	}
+	newData, err := encodeMetadata(newMd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode new metadata: %w", err)
+	}
+	return &pluginpb.MetadataRequest{OldMetadata: oldData, NewMetadata: newData}, nil
+}
+
+// Diff returns true if the plugin has changes to apply from oldMd to newMd. The
+// call waits for a starting plugin to serve, up to ctx's deadline.
+func (p *Plugin) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	req, err := metadataRequest(oldMd, newMd)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := p.client()
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to plugin %q: %w", p.Name, err)
+	}
+
+	resp, err := client.Diff(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return false, fmt.Errorf("plugin %q Diff() failed: %w", p.Name, err)
+	}
+	return resp.GetChanged(), nil
+}
+
+// Set applies the plugin's configuration from newMd. The call waits for a
+// starting plugin to serve, up to ctx's deadline.
+func (p *Plugin) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	req, err := metadataRequest(oldMd, newMd)
+	if err != nil {
+		return err
+	}
+
+	client, err := p.client()
+	if err != nil {
+		return fmt.Errorf("failed to connect to plugin %q: %w", p.Name, err)
+	}
+
+	if _, err := client.Set(ctx, req, grpc.WaitForReady(true)); err != nil {
+		return fmt.Errorf("plugin %q Set() failed: %w", p.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// DefaultDir is the default plugins directory for linux.
+	DefaultDir = "/etc/google/guest-agent/plugins"
+	// socketDir is the directory of the plugins' sockets.
+	socketDir = "/run/google-guest-agent/plugins"
+)
+
+// address returns the socket path of the plugin named name.
+func address(name string) string {
+	return filepath.Join(socketDir, name+".sock")
+}
+
+// executable returns true if info is executable by its owner.
+func executable(info fs.FileInfo) bool {
+	return info.Mode()&0100 != 0
+}
+
+// checkPermissions returns an error if info isn't owned by root or is writable by
+// the group or the other users, the plugins are run as root.
+func checkPermissions(info fs.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+		return fmt.Errorf("owned by uid %d instead of root", stat.Uid)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("writable by the group or the other users (mode %s)", info.Mode().Perm())
+	}
+	return nil
+}
+
+// listen listens on the Unix socket path, only accessible by root. A stale socket
+// left by a previous run is removed.
+func listen(ctx context.Context, path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// The socket is only reachable through its private directory, until it's given
+	// its final permissions, make sure a pre-existing directory is private too.
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to set socket directory permissions: %w", err)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return l, nil
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", path)
+}
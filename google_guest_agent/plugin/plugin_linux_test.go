@@ -0,0 +1,173 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeManager records the metadata it's called with.
+type fakeManager struct {
+	diff   bool
+	setErr error
+	calls  []string
+	newMd  *metadata.Descriptor
+}
+
+func (m *fakeManager) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	m.calls = append(m.calls, "diff")
+	m.newMd = newMd
+	return m.diff, nil
+}
+
+func (m *fakeManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	m.calls = append(m.calls, "set")
+	m.newMd = newMd
+	return m.setErr
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "run", "test.sock")
+	t.Setenv(AddressEnv, path)
+
+	mgr := &fakeManager{diff: true, setErr: errors.New("set failed")}
+	served := make(chan error)
+	go func() { served <- Serve(ctx, "1.0", mgr) }()
+
+	p := &Plugin{Name: "test", address: path}
+	defer p.close()
+
+	callCtx, callCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer callCancel()
+
+	newMd := &metadata.Descriptor{}
+	newMd.Instance.Attributes.SSHKeys = []string{"user:ssh-ed25519 AAAA user"}
+
+	diff, err := p.Diff(callCtx, nil, newMd)
+	if err != nil {
+		t.Fatalf("Diff(ctx, nil, %+v) failed: %v", newMd, err)
+	}
+	if !diff {
+		t.Errorf("Diff(ctx, nil, %+v) = false, want true", newMd)
+	}
+	if diff := cmp.Diff(newMd, mgr.newMd); diff != "" {
+		t.Errorf("plugin's Diff() got unexpected metadata (-want +got):\n%s", diff)
+	}
+
+	if err := p.Set(callCtx, nil, newMd); err == nil {
+		t.Errorf("Set(ctx, nil, %+v) succeeded, want error", newMd)
+	}
+
+	if diff := cmp.Diff([]string{"diff", "set"}, mgr.calls); diff != "" {
+		t.Errorf("plugin got unexpected calls (-want +got):\n%s", diff)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", path, err)
+	}
+	if got := stat.Mode().Perm(); got != 0600 {
+		t.Errorf("plugin socket mode = %o, want 0600", got)
+	}
+	stat, err = os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", filepath.Dir(path), err)
+	}
+	if got := stat.Mode().Perm(); got != 0700 {
+		t.Errorf("plugin socket directory mode = %o, want 0700", got)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve() failed: %v", err)
+	}
+}
+
+func TestServeNoAddress(t *testing.T) {
+	t.Setenv(AddressEnv, "")
+	if err := Serve(context.Background(), "1.0", &fakeManager{}); err == nil {
+		t.Errorf("Serve() without %s succeeded, want error", AddressEnv)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("the plugins must be owned by root")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("os.Chmod(%s, 0755) failed: %v", dir, err)
+	}
+
+	files := map[string]os.FileMode{
+		"backup":         0755,
+		"backup.sh":      0755,
+		"firewall.sh":    0700,
+		"README":         0644,
+		"world-writable": 0777,
+	}
+	for name, mode := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatalf("os.WriteFile(%s) failed: %v", path, err)
+		}
+		// Not subject to the umask.
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("os.Chmod(%s, %o) failed: %v", path, mode, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatalf("os.Mkdir(subdir) failed: %v", err)
+	}
+
+	plugins, err := Discover(dir, "1.0")
+	if err != nil {
+		t.Fatalf("Discover(%s) failed: %v", dir, err)
+	}
+
+	var got []string
+	for _, p := range plugins {
+		got = append(got, p.Name+":"+filepath.Base(p.Path))
+	}
+	want := []string{"backup:backup", "firewall:firewall.sh"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Discover(%s) returned unexpected plugins (-want +got):\n%s", dir, diff)
+	}
+
+	if plugins, err := Discover(filepath.Join(dir, "missing"), "1.0"); err != nil || len(plugins) != 0 {
+		t.Errorf("Discover(missing) = %v, %v, want no plugins", plugins, err)
+	}
+
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("os.Chmod(%s, 0777) failed: %v", dir, err)
+	}
+	if _, err := Discover(dir, "1.0"); err == nil {
+		t.Errorf("Discover(%s) of a world writable directory succeeded, want error", dir)
+	}
+}
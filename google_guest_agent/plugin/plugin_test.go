@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetadataRequest(t *testing.T) {
+	newMd := &metadata.Descriptor{}
+	newMd.Instance.Attributes.SSHKeys = []string{"user:ssh-ed25519 AAAA user"}
+	newMd.Instance.Tags = []string{"web"}
+	newMd.Project.ProjectID = "my-project"
+
+	req, err := metadataRequest(nil, newMd)
+	if err != nil {
+		t.Fatalf("metadataRequest(nil, %+v) failed: %v", newMd, err)
+	}
+	if len(req.GetOldMetadata()) != 0 {
+		t.Errorf("metadataRequest(nil, %+v) old metadata = %q, want empty", newMd, req.GetOldMetadata())
+	}
+
+	gotOld, gotNew, err := decodeRequest(req)
+	if err != nil {
+		t.Fatalf("decodeRequest(%+v) failed: %v", req, err)
+	}
+	if gotOld != nil {
+		t.Errorf("decodeRequest(%+v) old metadata = %+v, want nil", req, gotOld)
+	}
+	if diff := cmp.Diff(newMd, gotNew); diff != "" {
+		t.Errorf("decodeRequest(%+v) returned unexpected new metadata (-want +got):\n%s", req, diff)
+	}
+}
+
+func TestDecodeRequestInvalid(t *testing.T) {
+	req, err := metadataRequest(nil, &metadata.Descriptor{})
+	if err != nil {
+		t.Fatalf("metadataRequest(nil, {}) failed: %v", err)
+	}
+	req.OldMetadata = []byte("{invalid")
+
+	if _, _, err := decodeRequest(req); err == nil {
+		t.Errorf("decodeRequest(%+v) succeeded, want error", req)
+	}
+}
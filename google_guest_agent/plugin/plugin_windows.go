@@ -0,0 +1,62 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+const (
+	// DefaultDir is the default plugins directory for windows.
+	DefaultDir = `C:\Program Files\Google\Compute Engine\agent\plugins`
+	// securityDescriptor only grants access to LocalSystem and the Administrators.
+	securityDescriptor = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+)
+
+// address returns the named pipe path of the plugin named name.
+func address(name string) string {
+	return `\\.\pipe\google-guest-agent-plugin-` + name
+}
+
+// executable returns true if info is an .exe file.
+func executable(info fs.FileInfo) bool {
+	return strings.EqualFold(filepath.Ext(info.Name()), ".exe")
+}
+
+// checkPermissions is a no-op, the plugins directory is protected by the
+// Program Files ACLs.
+func checkPermissions(info fs.FileInfo) error {
+	return nil
+}
+
+// listen listens on the named pipe path, only accessible by LocalSystem and the
+// Administrators.
+func listen(ctx context.Context, path string) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		InputBufferSize:    4096,
+		OutputBufferSize:   4096,
+		SecurityDescriptor: securityDescriptor,
+	})
+}
+
+func dial(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
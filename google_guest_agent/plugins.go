@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/plugin"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// pluginManagerPrefix prefixes the plugins' manager names, so they don't clash
+// with the agent's own managers.
+const pluginManagerPrefix = "plugin-"
+
+// pluginMgr runs an out-of-process manager plugin, see the plugin package. The
+// plugin is disabled with its manager name in the [Managers] configuration section.
+type pluginMgr struct {
+	plugin *plugin.Plugin
+}
+
+func (m *pluginMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return m.plugin.Diff(ctx, oldMd, newMd)
+}
+
+func (m *pluginMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	return false, nil
+}
+
+func (m *pluginMgr) Timeout(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func (m *pluginMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	return m.plugin.Set(ctx, oldMd, newMd)
+}
+
+// startPlugins starts the manager plugins found in the plugins directory, if
+// enabled, and registers them as managers named plugin-<name>. The plugins run
+// once the agent's own managers are done, they are supervised until ctx is done.
+// It must be called before the managers are first run.
+func startPlugins(ctx context.Context) {
+	if !cfg.Get().Plugins.Enabled {
+		return
+	}
+
+	dir := cfg.Get().Plugins.Dir
+	if dir == "" {
+		dir = plugin.DefaultDir
+	}
+
+	plugins, err := plugin.Discover(dir, version)
+	if err != nil {
+		logger.Errorf("Failed to discover the manager plugins: %v", err)
+		return
+	}
+
+	for _, p := range plugins {
+		name := pluginManagerPrefix + p.Name
+		p.Start(ctx)
+		managerRegistry = append(managerRegistry, &registeredManager{
+			name:       name,
+			newManager: func(*metadata.Descriptor) manager { return &pluginMgr{plugin: p} },
+			priority:   managerPriorityPlugins,
+		})
+		logger.Infof("Registered manager plugin %q (%s)", name, p.Path)
+	}
+}
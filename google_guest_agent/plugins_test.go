@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestStartPlugins(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Getuid() != 0 {
+		t.Skip("the plugins must be owned by root")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("os.Chmod(%s, 0755) failed: %v", dir, err)
+	}
+	path := filepath.Join(dir, "hello.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatalf("os.WriteFile(%s) failed: %v", path, err)
+	}
+
+	registry := managerRegistry
+	t.Cleanup(func() { managerRegistry = registry })
+	managerRegistry = slices.Clone(registry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloadConfig(t, []byte("[Plugins]\nenabled = false\n"))
+	startPlugins(ctx)
+	if m := managerByName("plugin-hello"); m != nil {
+		t.Errorf("startPlugins() registered plugin %q with plugins disabled", m.name)
+	}
+
+	reloadConfig(t, []byte(fmt.Sprintf("[Plugins]\nenabled = true\ndir = %s\n", dir)))
+	startPlugins(ctx)
+	m := managerByName("plugin-hello")
+	if m == nil {
+		t.Fatalf("startPlugins() didn't register plugin %s", path)
+	}
+	if m.priority != managerPriorityPlugins {
+		t.Errorf("plugin manager priority = %d, want %d", m.priority, managerPriorityPlugins)
+	}
+	if _, ok := m.newManager(nil).(*pluginMgr); !ok {
+		t.Errorf("plugin manager is a %T, want *pluginMgr", m.newManager(nil))
+	}
+}
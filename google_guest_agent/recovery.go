@@ -0,0 +1,51 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+)
+
+// recoveryPolicy is the parsed service recovery configuration.
+type recoveryPolicy struct {
+	// delays are the restart delays for the first, second and subsequent failures.
+	delays []time.Duration
+	// resetPeriod is the period without failures after which the failure count is reset.
+	resetPeriod time.Duration
+}
+
+// parseRecoveryPolicy parses the ServiceRecovery configuration section.
+func parseRecoveryPolicy(config *cfg.ServiceRecovery) (*recoveryPolicy, error) {
+	var policy recoveryPolicy
+
+	for _, curr := range []string{config.FirstFailureDelay, config.SecondFailureDelay, config.SubsequentFailureDelay} {
+		delay, err := time.ParseDuration(curr)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid failure delay: %q", curr)
+		}
+		policy.delays = append(policy.delays, delay)
+	}
+
+	resetPeriod, err := time.ParseDuration(config.ResetPeriod)
+	if err != nil || resetPeriod < 0 {
+		return nil, fmt.Errorf("invalid reset period: %q", config.ResetPeriod)
+	}
+	policy.resetPeriod = resetPeriod
+
+	return &policy, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRecoveryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  cfg.ServiceRecovery
+		want    *recoveryPolicy
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			config: cfg.ServiceRecovery{FirstFailureDelay: "1s", SecondFailureDelay: "10s", SubsequentFailureDelay: "1m", ResetPeriod: "24h"},
+			want:   &recoveryPolicy{delays: []time.Duration{time.Second, 10 * time.Second, time.Minute}, resetPeriod: 24 * time.Hour},
+		},
+		{
+			name:    "invalid delay",
+			config:  cfg.ServiceRecovery{FirstFailureDelay: "bogus", SecondFailureDelay: "10s", SubsequentFailureDelay: "1m", ResetPeriod: "24h"},
+			wantErr: true,
+		},
+		{
+			name:    "negative reset period",
+			config:  cfg.ServiceRecovery{FirstFailureDelay: "1s", SecondFailureDelay: "10s", SubsequentFailureDelay: "1m", ResetPeriod: "-1h"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRecoveryPolicy(&tc.config)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseRecoveryPolicy() returned error: %v, want error: %t", err, tc.wantErr)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(recoveryPolicy{})); diff != "" {
+				t.Errorf("parseRecoveryPolicy() returned unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// configureServiceRecovery configures the agent's service control manager failure
+// actions so a crashing agent is restarted regardless of how it was installed.
+func configureServiceRecovery() error {
+	config := cfg.Get().ServiceRecovery
+	if config == nil || !config.Enabled {
+		return nil
+	}
+
+	policy, err := parseRecoveryPolicy(config)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	var actions []mgr.RecoveryAction
+	for _, delay := range policy.delays {
+		actions = append(actions, mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: delay})
+	}
+
+	if err := s.SetRecoveryActions(actions, uint32(policy.resetPeriod.Seconds())); err != nil {
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+
+	// Also recover when the agent exits with an error instead of crashing, i.e. logger.Fatalf().
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		return fmt.Errorf("failed to enable recovery actions on non crash failures: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/run"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// scheduledTaskJobPrefix prefixes the task's name in its scheduler job ID.
+	scheduledTaskJobPrefix = "scheduled-task-"
+)
+
+var (
+	scheduledTasksDisabled = true
+	// currentScheduledTasks maps the name of the currently scheduled tasks to
+	// their definition.
+	currentScheduledTasks = make(map[string]scheduledTask)
+	// scheduledTaskNameRegex matches the valid task names.
+	scheduledTaskNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	// scheduledTaskUserRegex matches the valid user names.
+	scheduledTaskUserRegex = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*\$?$`)
+)
+
+// scheduledTask is a command job defined in the scheduled-tasks metadata key.
+type scheduledTask struct {
+	// Name is the task's unique name.
+	Name string `json:"name"`
+	// Schedule is the cron expression of the task's run times, i.e. "0 3 * * *" or
+	// "@every 1h".
+	Schedule string `json:"schedule"`
+	// Command is the shell (PowerShell on Windows) command run.
+	Command string `json:"command"`
+	// User is the user the command is run as, the agent's user (root) if empty.
+	// Linux only.
+	User string `json:"user,omitempty"`
+}
+
+// validate returns an error if the task's definition is invalid.
+func (t scheduledTask) validate() error {
+	if !scheduledTaskNameRegex.MatchString(t.Name) {
+		return fmt.Errorf("invalid task name %q", t.Name)
+	}
+	if err := scheduler.ValidateSchedule(t.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", t.Schedule, err)
+	}
+	if t.Command == "" {
+		return fmt.Errorf("no command defined")
+	}
+	if t.User == "" {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("running as user %q is not supported on Windows", t.User)
+	}
+	if !scheduledTaskUserRegex.MatchString(t.User) {
+		return fmt.Errorf("invalid user name %q", t.User)
+	}
+	return nil
+}
+
+// scheduledTaskJob is the scheduler job running a scheduled task.
+type scheduledTaskJob struct {
+	task scheduledTask
+}
+
+// ID returns the job's ID.
+func (j *scheduledTaskJob) ID() string {
+	return scheduledTaskJobPrefix + j.task.Name
+}
+
+// Interval is unused, the task is run at its Schedule().
+func (j *scheduledTaskJob) Interval() (time.Duration, bool) {
+	return 0, false
+}
+
+// Schedule returns the task's cron expression.
+func (j *scheduledTaskJob) Schedule() string {
+	return j.task.Schedule
+}
+
+// ShouldEnable returns true, the tasks are only scheduled while enabled.
+func (j *scheduledTaskJob) ShouldEnable(ctx context.Context) bool {
+	return true
+}
+
+// Run runs the task's command and logs its output, the task is kept scheduled.
+func (j *scheduledTaskJob) Run(ctx context.Context) (bool, error) {
+	var name string
+	var args []string
+	switch {
+	case runtime.GOOS == "windows":
+		name, args = "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", j.task.Command}
+	case j.task.User != "":
+		name, args = "runuser", []string{"-u", j.task.User, "--", "/bin/sh", "-c", j.task.Command}
+	default:
+		name, args = "/bin/sh", []string{"-c", j.task.Command}
+	}
+
+	res := run.WithCombinedOutput(ctx, name, args...)
+	logger.Infof("Scheduled task %q exited with code %d, output: %s", j.task.Name, res.ExitCode, res.Combined)
+	if res.ExitCode != 0 {
+		return true, fmt.Errorf("scheduled task %q failed: %s", j.task.Name, res.Error())
+	}
+	return true, nil
+}
+
+// scheduledTasks returns the valid tasks defined in the scheduled-tasks metadata key
+// mapped by their name, instance metadata takes precedence over project metadata.
+// The invalid tasks are logged and ignored.
+func scheduledTasks(md *metadata.Descriptor) map[string]scheduledTask {
+	attr := md.Instance.Attributes.ScheduledTasks
+	if attr == "" {
+		attr = md.Project.Attributes.ScheduledTasks
+	}
+
+	res := make(map[string]scheduledTask)
+	if attr == "" {
+		return res
+	}
+
+	var tasks []scheduledTask
+	if err := json.Unmarshal([]byte(attr), &tasks); err != nil {
+		logger.Errorf("Invalid scheduled-tasks metadata, ignoring: %v", err)
+		return res
+	}
+
+	for _, task := range tasks {
+		if err := task.validate(); err != nil {
+			logger.Errorf("Invalid scheduled task %q, ignoring: %v", task.Name, err)
+			continue
+		}
+		if _, found := res[task.Name]; found {
+			logger.Errorf("Scheduled task %q is defined more than once, ignoring the duplicates", task.Name)
+			continue
+		}
+		res[task.Name] = task
+	}
+	return res
+}
+
+// unscheduleTasks unschedules the currently scheduled tasks.
+func unscheduleTasks() {
+	for name := range currentScheduledTasks {
+		scheduler.Get().UnscheduleJob(scheduledTaskJobPrefix + name)
+	}
+	clear(currentScheduledTasks)
+}
+
+type scheduledTasksMgr struct{}
+
+func (m *scheduledTasksMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	return !maps.Equal(scheduledTasks(newMd), currentScheduledTasks), nil
+}
+
+func (m *scheduledTasksMgr) Timeout(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func (m *scheduledTasksMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
+	var disabled bool
+	config := cfg.Get()
+
+	defer func() {
+		if disabled != scheduledTasksDisabled {
+			scheduledTasksDisabled = disabled
+			logStatus("scheduled-tasks", disabled)
+			// The tasks are only run while enabled.
+			if disabled {
+				unscheduleTasks()
+			}
+		}
+	}()
+
+	// Scheduled tasks are opt-in and disabled by default.
+	if config.ScheduledTasks != nil {
+		disabled = !config.ScheduledTasks.Enable
+		return disabled, nil
+	}
+
+	if newMd.Instance.Attributes.EnableScheduledTasks != nil {
+		disabled = !*newMd.Instance.Attributes.EnableScheduledTasks
+		return disabled, nil
+	}
+	if newMd.Project.Attributes.EnableScheduledTasks != nil {
+		disabled = !*newMd.Project.Attributes.EnableScheduledTasks
+		return disabled, nil
+	}
+
+	disabled = true
+	return disabled, nil
+}
+
+// Set schedules the tasks added to the metadata, reschedules the changed ones and
+// unschedules the removed ones.
+func (m *scheduledTasksMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	tasks := scheduledTasks(newMd)
+
+	for _, name := range slices.Sorted(maps.Keys(currentScheduledTasks)) {
+		if task, found := tasks[name]; found && task == currentScheduledTasks[name] {
+			continue
+		}
+		logger.Infof("Unscheduling scheduled task %q", name)
+		scheduler.Get().UnscheduleJob(scheduledTaskJobPrefix + name)
+		delete(currentScheduledTasks, name)
+	}
+
+	// The tasks outlive this run, ctx is canceled once Set() returns (see the manager's
+	// budget), they're only stopped when unscheduled.
+	jobCtx := context.WithoutCancel(ctx)
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(tasks)) {
+		if _, found := currentScheduledTasks[name]; found {
+			continue
+		}
+		job := &scheduledTaskJob{task: tasks[name]}
+		if err := scheduler.Get().ScheduleJob(jobCtx, job, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to schedule task %q: %w", name, err))
+			continue
+		}
+		logger.Infof("Scheduled task %q to run at %q", name, job.task.Schedule)
+		currentScheduledTasks[name] = tasks[name]
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScheduledTaskValidate(t *testing.T) {
+	var tests = []struct {
+		name    string
+		task    scheduledTask
+		wantErr bool
+	}{
+		{"valid", scheduledTask{Name: "cleanup", Schedule: "0 3 * * *", Command: "rm -rf /tmp/cache"}, false},
+		{"valid descriptor", scheduledTask{Name: "cleanup", Schedule: "@every 1h", Command: "true"}, false},
+		{"invalid name", scheduledTask{Name: "clean up", Schedule: "@every 1h", Command: "true"}, true},
+		{"no name", scheduledTask{Schedule: "@every 1h", Command: "true"}, true},
+		{"invalid schedule", scheduledTask{Name: "cleanup", Schedule: "every hour", Command: "true"}, true},
+		{"no command", scheduledTask{Name: "cleanup", Schedule: "@every 1h"}, true},
+		{"invalid user", scheduledTask{Name: "cleanup", Schedule: "@every 1h", Command: "true", User: "root; reboot"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.task.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduledTasks(t *testing.T) {
+	mkmd := func(instance, project string) *metadata.Descriptor {
+		return &metadata.Descriptor{
+			Instance: metadata.Instance{Attributes: metadata.Attributes{ScheduledTasks: instance}},
+			Project:  metadata.Project{Attributes: metadata.Attributes{ScheduledTasks: project}},
+		}
+	}
+	cleanup := scheduledTask{Name: "cleanup", Schedule: "@daily", Command: "true"}
+
+	var tests = []struct {
+		name string
+		md   *metadata.Descriptor
+		want map[string]scheduledTask
+	}{
+		{"no tasks", mkmd("", ""), map[string]scheduledTask{}},
+		{"project tasks", mkmd("", `[{"name":"cleanup","schedule":"@daily","command":"true"}]`), map[string]scheduledTask{"cleanup": cleanup}},
+		{"instance overrides project", mkmd(`[]`, `[{"name":"cleanup","schedule":"@daily","command":"true"}]`), map[string]scheduledTask{}},
+		{"invalid json", mkmd(`not json`, ""), map[string]scheduledTask{}},
+		{"invalid task ignored", mkmd(`[{"name":"cleanup","schedule":"@daily","command":"true"},{"name":"bad"}]`, ""), map[string]scheduledTask{"cleanup": cleanup}},
+		{"duplicate ignored", mkmd(`[{"name":"cleanup","schedule":"@daily","command":"true"},{"name":"cleanup","schedule":"@hourly","command":"false"}]`, ""), map[string]scheduledTask{"cleanup": cleanup}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, scheduledTasks(tt.md)); diff != "" {
+				t.Errorf("scheduledTasks() returned unexpected tasks (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScheduledTasksDisabled(t *testing.T) {
+	var tests = []struct {
+		name string
+		data []byte
+		md   *metadata.Descriptor
+		want bool
+	}{
+		{"not explicitly enabled", []byte(""), &metadata.Descriptor{}, true},
+		{"enabled in cfg only", []byte("[ScheduledTasks]\nenable=true"), &metadata.Descriptor{}, false},
+		{"disabled in cfg, enabled in instance metadata", []byte("[ScheduledTasks]\nenable=false"), &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{EnableScheduledTasks: mkptr(true)}}}, true},
+		{"enabled in instance metadata only", []byte(""), &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{EnableScheduledTasks: mkptr(true)}}}, false},
+		{"enabled in project metadata only", []byte(""), &metadata.Descriptor{Project: metadata.Project{Attributes: metadata.Attributes{EnableScheduledTasks: mkptr(true)}}}, false},
+		{"disabled in instance metadata, enabled in project metadata", []byte(""), &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{EnableScheduledTasks: mkptr(false)}}, Project: metadata.Project{Attributes: metadata.Attributes{EnableScheduledTasks: mkptr(true)}}}, true},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloadConfig(t, tt.data)
+
+			got, err := (&scheduledTasksMgr{}).Disabled(ctx, tt.md)
+			if err != nil {
+				t.Errorf("Failed to run scheduledTasksMgr's Disabled() call: %+v", err)
+			}
+			if got != tt.want {
+				t.Errorf("test case %q, scheduledTasksMgr.Disabled() got: %t, want: %t", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduledTasksSet(t *testing.T) {
+	ctx := context.Background()
+	mgr := &scheduledTasksMgr{}
+	t.Cleanup(unscheduleTasks)
+
+	setTasks := func(tasks string) {
+		t.Helper()
+		md := &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{ScheduledTasks: tasks}}}
+		if err := mgr.Set(ctx, nil, md); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if diff, _ := mgr.Diff(ctx, nil, md); diff {
+			t.Errorf("Diff() = true after Set(), want false")
+		}
+	}
+
+	setTasks(`[{"name":"a","schedule":"@every 1h","command":"true"},{"name":"b","schedule":"@every 1h","command":"true"}]`)
+	for _, name := range []string{"a", "b"} {
+		if !scheduler.Get().IsScheduled(scheduledTaskJobPrefix + name) {
+			t.Errorf("Task %q wasn't scheduled", name)
+		}
+	}
+
+	setTasks(`[{"name":"a","schedule":"@every 2h","command":"true"}]`)
+	if got := currentScheduledTasks["a"].Schedule; got != "@every 2h" {
+		t.Errorf("Task %q schedule = %q after change, want %q", "a", got, "@every 2h")
+	}
+	if scheduler.Get().IsScheduled(scheduledTaskJobPrefix + "b") {
+		t.Errorf("Removed task %q is still scheduled", "b")
+	}
+}
+
+func TestScheduledTasksRunThroughManager(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test task is a shell command")
+	}
+	reloadConfig(t, nil)
+	t.Cleanup(unscheduleTasks)
+
+	enabled := true
+	marker := filepath.Join(t.TempDir(), "ran")
+	tasks := fmt.Sprintf(`[{"name":"marker","schedule":"@every 1s","command":"touch %s"}]`, marker)
+	md := &metadata.Descriptor{Instance: metadata.Instance{Attributes: metadata.Attributes{
+		EnableScheduledTasks: &enabled,
+		ScheduledTasks:       tasks,
+	}}}
+
+	// The manager's run context is canceled once Set() returns, the task must keep
+	// running regardless.
+	m := &registeredManager{name: "scheduled-tasks", newManager: func(*metadata.Descriptor) manager { return &scheduledTasksMgr{} }}
+	m.run(context.Background(), &metadata.Descriptor{}, md)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Scheduled task never ran, last result: %q", m.status())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// BackoffPolicy is how the runs of a failing job are backed off, the backoff is
+// reset once a run succeeds. The runs scheduled while the job is backing off are
+// skipped, so a delay shorter than the job's interval has no effect.
+type BackoffPolicy struct {
+	// Initial is the delay after the first failure before the job is run again.
+	Initial time.Duration
+	// Factor multiplies the delay after each consecutive failure, 1 or less keeps
+	// it constant.
+	Factor float64
+	// Max is optional and caps the delay.
+	Max time.Duration
+	// UntilReset skips the job's runs after a failure until ResetBackoff() is called,
+	// i.e. on an event signaling the cause of the failure is gone, instead of for a
+	// delay.
+	UntilReset bool
+}
+
+// delay returns the delay after failures consecutive failures.
+func (p BackoffPolicy) delay(failures int) time.Duration {
+	delay := float64(p.Initial) * math.Pow(max(p.Factor, 1), float64(failures-1))
+	if p.Max > 0 && delay > float64(p.Max) {
+		return p.Max
+	}
+	if delay > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// BackoffJob is a Job whose runs are backed off while it keeps failing, rather
+// than run at its normal schedule.
+type BackoffJob interface {
+	Job
+	// Backoff returns the backoff policy of the job.
+	Backoff() BackoffPolicy
+}
+
+// backoff is the backoff of a failing job.
+type backoff struct {
+	// failures is the number of consecutive failures.
+	failures int
+	// until is when the job may run again, zero if it waits for ResetBackoff().
+	until time.Time
+}
+
+// backoffs keeps the backoff of the failing jobs.
+type backoffs struct {
+	// mutex protects jobs.
+	mutex sync.Mutex
+	// jobs maps the failing jobs' IDs to their backoff.
+	jobs map[string]*backoff
+}
+
+// skip returns true and the number of consecutive failures if the job is backing
+// off at now.
+func (b *backoffs) skip(jobID string, now time.Time) (bool, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	bo, found := b.jobs[jobID]
+	if !found {
+		return false, 0
+	}
+	return bo.until.IsZero() || now.Before(bo.until), bo.failures
+}
+
+// failed records a failed run of the job at now and returns when it may run
+// again, zero if it waits for ResetBackoff().
+func (b *backoffs) failed(jobID string, policy BackoffPolicy, now time.Time) time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.jobs == nil {
+		b.jobs = make(map[string]*backoff)
+	}
+	bo, found := b.jobs[jobID]
+	if !found {
+		bo = &backoff{}
+		b.jobs[jobID] = bo
+	}
+
+	bo.failures++
+	bo.until = time.Time{}
+	if !policy.UntilReset {
+		bo.until = now.Add(policy.delay(bo.failures))
+	}
+	return bo.until
+}
+
+// reset resets the backoff of the job.
+func (b *backoffs) reset(jobID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.jobs, jobID)
+}
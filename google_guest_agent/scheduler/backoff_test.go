@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   BackoffPolicy
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "first-failure",
+			policy:   BackoffPolicy{Initial: time.Minute, Factor: 2},
+			failures: 1,
+			want:     time.Minute,
+		},
+		{
+			name:     "exponential",
+			policy:   BackoffPolicy{Initial: time.Minute, Factor: 2},
+			failures: 4,
+			want:     8 * time.Minute,
+		},
+		{
+			name:     "capped",
+			policy:   BackoffPolicy{Initial: time.Minute, Factor: 2, Max: 5 * time.Minute},
+			failures: 4,
+			want:     5 * time.Minute,
+		},
+		{
+			name:     "constant",
+			policy:   BackoffPolicy{Initial: time.Minute},
+			failures: 4,
+			want:     time.Minute,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.delay(tc.failures); got != tc.want {
+				t.Errorf("delay(%d) = %s, want %s", tc.failures, got, tc.want)
+			}
+		})
+	}
+}
+
+// testBackoffJob fails its runs while fail is set.
+type testBackoffJob struct {
+	testJob
+	policy BackoffPolicy
+	fail   bool
+}
+
+func (j *testBackoffJob) Run(ctx context.Context) (bool, error) {
+	j.testJob.Run(ctx)
+	if j.fail {
+		return true, fmt.Errorf("test failure")
+	}
+	return true, nil
+}
+
+func (j *testBackoffJob) Backoff() BackoffPolicy {
+	return j.policy
+}
+
+func TestBackoffJob(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BackoffPolicy
+	}{
+		{
+			name:   "exponential",
+			policy: BackoffPolicy{Initial: time.Hour, Factor: 2},
+		},
+		{
+			name:   "until-reset",
+			policy: BackoffPolicy{UntilReset: true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			job := &testBackoffJob{
+				testJob: testJob{interval: time.Hour, id: "test_backoff_job_" + tc.name, shouldEnable: true},
+				policy:  tc.policy,
+				fail:    true,
+			}
+			s := Get()
+			defer s.ResetBackoff(job.ID())
+			run := s.getFunc(context.Background(), job)
+
+			// The run following a failure is skipped.
+			run()
+			run()
+			if job.ctr != 1 {
+				t.Errorf("Failing job ran %d times, want 1", job.ctr)
+			}
+
+			// Resetting the backoff lets the next run go.
+			s.ResetBackoff(job.ID())
+			job.fail = false
+			run()
+			if job.ctr != 2 {
+				t.Errorf("Job ran %d times after resetting its backoff, want 2", job.ctr)
+			}
+
+			// A successful run resets the backoff.
+			run()
+			if job.ctr != 3 {
+				t.Errorf("Job ran %d times after a successful run, want 3", job.ctr)
+			}
+		})
+	}
+}
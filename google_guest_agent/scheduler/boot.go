@@ -0,0 +1,51 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// BootJob is a Job possibly run once per boot, i.e. a first boot task such as
+// regenerating the machine specific identifiers of a cloned image. A once per boot
+// job is run right away when scheduled, unless it already succeeded in the current
+// boot, and unscheduled once it succeeds. A failed run is retried at the job's
+// Interval(). The boot the job succeeded in is persisted along with the state, see
+// SetStateFile().
+type BootJob interface {
+	Job
+	// OncePerBoot returns true if the job is run once per boot.
+	OncePerBoot() bool
+}
+
+var (
+	// bootID returns the ID of the current boot, overridden in tests.
+	bootID = sync.OnceValue(func() string {
+		id, err := readBootID()
+		if err != nil {
+			logger.Errorf("Failed to read the boot ID, the once per boot jobs are run on every agent start: %v", err)
+			return ""
+		}
+		return id
+	})
+)
+
+// oncePerBoot returns true if job is run once per boot.
+func oncePerBoot(job Job) bool {
+	bootJob, ok := job.(BootJob)
+	return ok && bootJob.OncePerBoot()
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// bootIDFile is the kernel's random ID of the current boot.
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// readBootID reads the ID of the current boot.
+func readBootID() (string, error) {
+	data, err := os.ReadFile(bootIDFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", bootIDFile, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testBootJob is a once per boot job failing its runs while fail is set.
+type testBootJob struct {
+	testJob
+	fail bool
+}
+
+func (j *testBootJob) Run(ctx context.Context) (bool, error) {
+	j.testJob.Run(ctx)
+	if j.fail {
+		return true, fmt.Errorf("test failure")
+	}
+	return true, nil
+}
+
+func (j *testBootJob) OncePerBoot() bool {
+	return true
+}
+
+func TestBootJob(t *testing.T) {
+	currBoot := "boot-1"
+	oldBootID := bootID
+	bootID = func() string { return currBoot }
+	t.Cleanup(func() { bootID = oldBootID })
+
+	s := Get()
+	ctx := context.Background()
+	job := &testBootJob{testJob: testJob{interval: time.Hour, id: "test_boot_job", shouldEnable: true}, fail: true}
+	defer s.UnscheduleJob(job.ID())
+
+	// A failed run is retried at the job's interval.
+	if err := s.ScheduleJob(ctx, job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	if job.ctr != 1 {
+		t.Errorf("Once per boot job ran %d times when scheduled, want 1", job.ctr)
+	}
+	if !s.IsScheduled(job.ID()) {
+		t.Errorf("Once per boot job %q was unscheduled after a failed run", job.ID())
+	}
+	s.UnscheduleJob(job.ID())
+
+	// A successful run unschedules the job.
+	job.fail = false
+	if err := s.ScheduleJob(ctx, job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	if job.ctr != 2 {
+		t.Errorf("Once per boot job ran %d times, want 2", job.ctr)
+	}
+	if s.IsScheduled(job.ID()) {
+		t.Errorf("Once per boot job %q is still scheduled after a successful run", job.ID())
+	}
+
+	// The job isn't run again in the same boot.
+	if err := s.ScheduleJob(ctx, job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	if job.ctr != 2 {
+		t.Errorf("Once per boot job ran %d times in the same boot, want 2", job.ctr)
+	}
+
+	// The job is run again in the next boot.
+	currBoot = "boot-2"
+	if err := s.ScheduleJob(ctx, job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	if job.ctr != 3 {
+		t.Errorf("Once per boot job ran %d times after a reboot, want 3", job.ctr)
+	}
+}
+
+func TestReadBootID(t *testing.T) {
+	id, err := readBootID()
+	if err != nil {
+		t.Skipf("Boot ID not available: %v", err)
+	}
+	if id == "" {
+		t.Errorf("readBootID() returned an empty boot ID")
+	}
+}
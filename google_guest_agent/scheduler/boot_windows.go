@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	// bootIDKey is the registry key of the boot counter.
+	bootIDKey = `SYSTEM\CurrentControlSet\Control\Session Manager\Memory Management\PrefetchParameters`
+	// bootIDValue is the registry value of the boot counter, incremented on every boot.
+	bootIDValue = "BootId"
+)
+
+// readBootID reads the ID of the current boot, the boot counter.
+func readBootID() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, bootIDKey, registry.QUERY_VALUE)
+	if err != nil {
+		return "", fmt.Errorf("failed to open registry key %s: %w", bootIDKey, err)
+	}
+	defer key.Close()
+
+	id, _, err := key.GetIntegerValue(bootIDValue)
+	if err != nil {
+		return "", fmt.Errorf("failed to read registry value %s: %w", bootIDValue, err)
+	}
+	return strconv.FormatUint(id, 10), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// GroupJob is a Job whose runs never overlap with the runs of the other jobs of
+// its concurrency group, i.e. the jobs editing sshd_config.
+type GroupJob interface {
+	Job
+	// Group returns the job's concurrency group, empty if none.
+	Group() string
+}
+
+// slots limits the concurrent runs of the jobs, a run holds its group's slot
+// and a parallel run slot until it returns, even if given up after its timeout.
+type slots struct {
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// groups maps the concurrency groups to their single slot.
+	groups map[string]chan struct{}
+	// parallel are the parallel run slots, nil if unlimited.
+	parallel chan struct{}
+}
+
+// setMaxParallel limits the parallel runs to limit, zero means no limit. The runs
+// already holding a slot aren't accounted for the new limit.
+func (sl *slots) setMaxParallel(limit int) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.parallel = nil
+	if limit > 0 {
+		sl.parallel = make(chan struct{}, limit)
+	}
+}
+
+// acquire waits for group's slot, if group isn't empty, and a parallel run slot
+// and returns the function releasing them. It fails if ctx is done meanwhile.
+func (sl *slots) acquire(ctx context.Context, group string) (func(), error) {
+	sl.mutex.Lock()
+	var groupSlot chan struct{}
+	if group != "" {
+		if sl.groups == nil {
+			sl.groups = make(map[string]chan struct{})
+		}
+		groupSlot = sl.groups[group]
+		if groupSlot == nil {
+			groupSlot = make(chan struct{}, 1)
+			sl.groups[group] = groupSlot
+		}
+	}
+	parallel := sl.parallel
+	sl.mutex.Unlock()
+
+	var held []chan struct{}
+	release := func() {
+		for _, slot := range held {
+			<-slot
+		}
+	}
+
+	// The group's slot is acquired first so a run waiting for its group doesn't
+	// hold a parallel run slot.
+	for _, slot := range []chan struct{}{groupSlot, parallel} {
+		if slot == nil {
+			continue
+		}
+		select {
+		case slot <- struct{}{}:
+			held = append(held, slot)
+		default:
+			logger.Debugf("Waiting for a free slot to run a job (group %q)", group)
+			select {
+			case slot <- struct{}{}:
+				held = append(held, slot)
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return release, nil
+}
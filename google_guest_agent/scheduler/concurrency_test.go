@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConcurrentJob tracks the maximum number of its runs, and of the other
+// testConcurrentJob sharing concurrent, running at the same time.
+type testConcurrentJob struct {
+	testJob
+	group      string
+	concurrent *atomic.Int32
+	maxSeen    *atomic.Int32
+}
+
+func (j *testConcurrentJob) Run(_ context.Context) (bool, error) {
+	curr := j.concurrent.Add(1)
+	defer j.concurrent.Add(-1)
+	for seen := j.maxSeen.Load(); curr > seen && !j.maxSeen.CompareAndSwap(seen, curr); seen = j.maxSeen.Load() {
+	}
+	time.Sleep(50 * time.Millisecond)
+	return true, nil
+}
+
+func (j *testConcurrentJob) Group() string {
+	return j.group
+}
+
+func TestJobConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		group       string
+		maxParallel int
+		want        int32
+	}{
+		{
+			name:  "same-group",
+			group: "sshd_config",
+			want:  1,
+		},
+		{
+			name:        "max-parallel",
+			maxParallel: 2,
+			want:        2,
+		},
+		{
+			name: "unlimited",
+			want: 4,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := Get()
+			s.SetMaxParallelJobs(tc.maxParallel)
+			t.Cleanup(func() { s.SetMaxParallelJobs(0) })
+
+			var concurrent, maxSeen atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				job := &testConcurrentJob{
+					testJob:    testJob{interval: time.Hour, id: "test_concurrent_job", shouldEnable: true},
+					group:      tc.group,
+					concurrent: &concurrent,
+					maxSeen:    &maxSeen,
+				}
+				run := s.getFunc(context.Background(), job)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					run()
+				}()
+			}
+			wg.Wait()
+
+			if got := maxSeen.Load(); got != tc.want {
+				t.Errorf("%d jobs ran at the same time, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSlotsAcquireCanceled(t *testing.T) {
+	var sl slots
+	release, err := sl.acquire(context.Background(), "group")
+	if err != nil {
+		t.Fatalf("acquire(ctx, group) failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sl.acquire(ctx, "group"); err == nil {
+		t.Errorf("acquire(ctx, group) succeeded while the group's slot is held and ctx is canceled, want error")
+	}
+
+	release()
+	if _, err := sl.acquire(ctx, "group"); err != nil {
+		t.Errorf("acquire(ctx, group) failed after the group's slot was released: %v", err)
+	}
+}
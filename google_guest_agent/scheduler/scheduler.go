@@ -18,7 +18,12 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"maps"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
@@ -40,17 +45,87 @@ type Job interface {
 	Run(context.Context) (bool, error)
 }
 
+// CronJob is a Job run at the times matching a cron expression rather than at a
+// fixed interval since it was scheduled, i.e. nightly.
+type CronJob interface {
+	Job
+	// Schedule returns the cron expression of the job's run times, i.e. "0 3 * * *"
+	// for 3am every day, or "30 0 3 * * *" with the optional seconds field. The times
+	// are in the local time zone unless prefixed with "CRON_TZ=<zone>". If empty the
+	// job is run at its Interval().
+	Schedule() string
+}
+
+// SplayJob is a Job whose runs are delayed by a random duration up to its splay,
+// overriding the scheduler's default splay (see SetSplay()).
+type SplayJob interface {
+	Job
+	// Splay returns the maximum random delay of the job's runs, zero disables it.
+	Splay() time.Duration
+}
+
+// TimeoutJob is a Job whose runs are given up after its timeout, overriding the
+// scheduler's default job timeout (see SetJobTimeout()).
+type TimeoutJob interface {
+	Job
+	// Timeout returns how long a run of the job may take, zero disables it.
+	Timeout() time.Duration
+}
+
+var (
+	// cronParser parses the cron expressions, with an optional seconds field.
+	cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+)
+
+// ValidateSchedule returns an error if schedule isn't a valid CronJob schedule.
+func ValidateSchedule(schedule string) error {
+	_, err := cronParser.Parse(schedule)
+	return err
+}
+
+// scheduledJob is the registration of a scheduled job.
+type scheduledJob struct {
+	// entry is the job's cron entry.
+	entry cron.EntryID
+	// ctx is the context of the job's runs, canceled once it's unscheduled.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
 // Scheduler implements job schedule manager and offers a way to schedule/unschedule new jobs.
+// Jobs can be scheduled and unscheduled at any time, i.e. when a feature is enabled or
+// disabled in the metadata.
 type Scheduler struct {
 	cron *cron.Cron
-	jobs map[string]cron.EntryID
+	jobs map[string]*scheduledJob
 	mu   sync.RWMutex
+	// paused is set while the scheduler is paused, scheduled runs are skipped.
+	paused atomic.Bool
+	// runCallback is called after each job run, see SetRunCallback().
+	runCallback atomic.Pointer[RunCallback]
+	// splay is the default maximum random delay of the jobs' runs, see SetSplay().
+	splay atomic.Int64
+	// jobTimeout is the default timeout of the jobs' runs, see SetJobTimeout().
+	jobTimeout atomic.Int64
+	// state keeps the jobs' last successful run, see SetStateFile().
+	state runState
+	// backoffs keeps the backoff of the failing jobs implementing BackoffJob.
+	backoffs backoffs
+	// history keeps the outcome of the jobs' last run, see JobStatuses().
+	history runHistory
+	// slots limits the concurrent runs of the jobs, see GroupJob and
+	// SetMaxParallelJobs().
+	slots slots
 }
 
+// RunCallback is called after a job run with the job id and the error
+// returned by the run, if any.
+type RunCallback func(jobID string, err error)
+
 var scheduler *Scheduler
 
 func init() {
-	taskIDs := make(map[string]cron.EntryID)
+	taskIDs := make(map[string]*scheduledJob)
 	cron := cron.New(cron.WithLogger(&cronLogger{}))
 
 	scheduler = &Scheduler{
@@ -66,22 +141,152 @@ func Get() *Scheduler {
 	return scheduler
 }
 
-// getFunc generates a wrapper function for cron scheduler.
+// getFunc generates a wrapper function for cron scheduler, ctx is the context of the
+// job's registration. A run still going on (i.e. given up after its timeout) makes
+// the next runs be skipped until it returns.
 func (s *Scheduler) getFunc(ctx context.Context, job Job) func() {
+	timeout := time.Duration(s.jobTimeout.Load())
+	if timeoutJob, ok := job.(TimeoutJob); ok {
+		timeout = timeoutJob.Timeout()
+	}
+	backoffJob, withBackoff := job.(BackoffJob)
+	var group string
+	if groupJob, ok := job.(GroupJob); ok {
+		group = groupJob.Group()
+	}
+	boot := oncePerBoot(job)
+
+	var running atomic.Bool
+
 	f := func() {
+		// The job was unscheduled while this run was pending.
+		if ctx.Err() != nil {
+			return
+		}
+
+		if s.paused.Load() {
+			logger.Infof("Scheduler is paused, skipping job %q", job.ID())
+			return
+		}
+
+		if withBackoff {
+			if skip, failures := s.backoffs.skip(job.ID(), time.Now()); skip {
+				logger.Infof("Job %q is backing off after %d consecutive failures, skipping", job.ID(), failures)
+				return
+			}
+		}
+
+		if !running.CompareAndSwap(false, true) {
+			logger.Infof("Previous run of job %q is still running, skipping", job.ID())
+			return
+		}
+
+		// Waiting for the job's concurrency group or a parallel run slot, the
+		// next runs are skipped meanwhile.
+		release, err := s.slots.acquire(ctx, group)
+		if err != nil {
+			running.Store(false)
+			return
+		}
+
 		logger.Infof("Invoking job %q", job.ID())
-		schedule, err := job.Run(ctx)
+		start := time.Now()
+		schedule, err := s.runJob(ctx, job, timeout, func() {
+			release()
+			running.Store(false)
+		})
+		// A run outliving its registration isn't recorded.
+		if ctx.Err() == nil {
+			s.history.record(job.ID(), start, time.Since(start), err)
+		}
+		// A once per boot job is done for this boot.
+		if boot && err == nil {
+			schedule = false
+		}
 		if !schedule {
-			s.UnscheduleJob(job.ID())
+			s.unschedule(job.ID(), ctx)
 		}
 		if err != nil {
 			logger.Errorf("Failed to execute job %s: %v", job.ID(), err)
+			if withBackoff {
+				s.backOff(job.ID(), backoffJob.Backoff())
+			}
+		} else {
+			if !boot {
+				s.state.record(job.ID(), time.Now())
+			} else if id := bootID(); id != "" {
+				s.state.recordBoot(job.ID(), id)
+			}
+			s.backoffs.reset(job.ID())
+		}
+		if cb := s.runCallback.Load(); cb != nil {
+			(*cb)(job.ID(), err)
 		}
 	}
 	return f
 }
 
-// ScheduleJob adds a job to schedule at defined interval.
+// backOff backs off the runs of the failed job according to policy.
+func (s *Scheduler) backOff(jobID string, policy BackoffPolicy) {
+	until := s.backoffs.failed(jobID, policy, time.Now())
+	if until.IsZero() {
+		logger.Infof("Backing off job %q until its backoff is reset", jobID)
+		return
+	}
+	logger.Infof("Backing off job %q until %s", jobID, until.Format(time.RFC3339))
+}
+
+// jobResult is the outcome of a job run.
+type jobResult struct {
+	schedule bool
+	err      error
+}
+
+// runJob runs job in its own goroutine with a context canceled after timeout (zero
+// means no timeout), finished is called once the run returns. A run not returning in
+// time is given up, and a panicking run is recovered, both are reported as errors
+// keeping the job scheduled.
+func (s *Scheduler) runJob(ctx context.Context, job Job, timeout time.Duration, finished func()) (bool, error) {
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	done := make(chan jobResult, 1)
+
+	go func() {
+		defer cancel()
+		defer finished()
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("Job %s panicked: %v\n%s", job.ID(), r, debug.Stack())
+				done <- jobResult{schedule: true, err: fmt.Errorf("job panicked: %v", r)}
+			}
+		}()
+
+		schedule, err := job.Run(runCtx)
+		done <- jobResult{schedule: schedule, err: err}
+	}()
+
+	if timeout <= 0 {
+		res := <-done
+		return res.schedule, res.err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.schedule, res.err
+	case <-timer.C:
+		return true, fmt.Errorf("job didn't return within %s, giving up", timeout)
+	}
+}
+
+// ScheduleJob adds a job to schedule at defined interval, a job already scheduled
+// is left as is. The job's runs get a context derived from ctx, canceled once the
+// job is unscheduled.
 func (s *Scheduler) ScheduleJob(ctx context.Context, job Job, synchronous bool) error {
 	if !job.ShouldEnable(ctx) {
 		return fmt.Errorf("ShouldEnable() returned false, cannot schedule job %s", job.ID())
@@ -90,64 +295,131 @@ func (s *Scheduler) ScheduleJob(ctx context.Context, job Job, synchronous bool)
 	logger.Infof("Scheduling job: %s", job.ID())
 
 	interval, startNow := job.Interval()
-	if err := s.jobInit(job.ID(), interval, s.getFunc(ctx, job), startNow, synchronous); err != nil {
+	if oncePerBoot(job) {
+		if id := bootID(); id != "" && s.state.bootRun(job.ID()) == id {
+			logger.Infof("Job %q already ran in this boot, skipping", job.ID())
+			return nil
+		}
+		startNow = true
+	}
+
+	spec := fmt.Sprintf("@every %ds", int(interval.Seconds()))
+	if cronJob, ok := job.(CronJob); ok && cronJob.Schedule() != "" {
+		spec = cronJob.Schedule()
+	}
+
+	splay := time.Duration(s.splay.Load())
+	if splayJob, ok := job.(SplayJob); ok {
+		splay = splayJob.Splay()
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	sj := &scheduledJob{ctx: jobCtx, cancel: cancel}
+	run := s.getFunc(jobCtx, job)
+	if err := s.jobInit(job.ID(), spec, sj, run, s.splayed(jobCtx, job.ID(), splay, run), startNow, synchronous); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Scheduler) setEntryID(jobID string, entryID cron.EntryID) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.jobs[jobID] = entryID
+// splayed wraps run delaying it by a random duration up to splay, so the VMs
+// booted at the same time don't run their jobs at the exact same time. The delay
+// is abandoned if ctx is done.
+func (s *Scheduler) splayed(ctx context.Context, jobID string, splay time.Duration, run func()) func() {
+	if splay <= 0 {
+		return run
+	}
+
+	return func() {
+		delay := rand.N(splay)
+		logger.Debugf("Delaying job %q run by %s", jobID, delay)
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			run()
+		case <-ctx.Done():
+		}
+	}
 }
 
-// jobInit adds job to the schedule to run at specified interval.
+// jobInit adds job to the schedule to run at the times of the cron expression spec,
+// i.e. "@every 60s".
 // Setting startImmediately to true executes first run immediately, otherwise
-// first run will be after interval (at now+interval).
+// first run will be at the next time of the schedule.
 // If startImmediately and synchronous both are true, init method will block
-// until job is completed.
-func (s *Scheduler) jobInit(jobID string, interval time.Duration, job func(), startImmediately, synchronous bool) error {
-	logger.Infof("Scheduling job %q to run at %f hr interval", jobID, interval.Hours())
+// until job is completed. The runs call splayedJob, job delayed by its splay,
+// except the synchronous first run which isn't delayed. sj is the job's
+// registration, its context is canceled if the job isn't scheduled.
+func (s *Scheduler) jobInit(jobID string, spec string, sj *scheduledJob, job, splayedJob func(), startImmediately, synchronous bool) error {
+	logger.Infof("Scheduling job %q to run at %q", jobID, spec)
 
-	_, found := s.jobs[jobID]
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		sj.cancel()
+		return fmt.Errorf("invalid schedule %q of job %q: %w", spec, jobID, err)
+	}
+
+	// Resume the schedule from the last successful run of a previous agent run,
+	// unless a run was due since.
+	if last := s.state.lastRun(jobID); !last.IsZero() && !synchronous {
+		if next := schedule.Next(last); next.After(time.Now()) {
+			logger.Infof("Job %q last ran at %s, resuming its schedule at %s", jobID, last.Format(time.RFC3339), next.Format(time.RFC3339))
+			schedule = &resumedSchedule{first: next, schedule: schedule}
+			startImmediately = false
+		}
+	}
+
+	s.mu.Lock()
 	// If found, job is already running, return.
-	if found {
+	if _, found := s.jobs[jobID]; found {
+		s.mu.Unlock()
+		sj.cancel()
 		logger.Infof("Skipping, job %q is already scheduled", jobID)
 		return nil
 	}
-
-	entry, err := s.cron.AddFunc(fmt.Sprintf("@every %ds", int(interval.Seconds())), job)
-	if err != nil {
-		return fmt.Errorf("unable to schedule %q: %w", jobID, err)
-	}
-	s.setEntryID(jobID, entry)
+	sj.entry = s.cron.Schedule(schedule, cron.FuncJob(splayedJob))
+	s.jobs[jobID] = sj
+	s.mu.Unlock()
 
 	if startImmediately {
 		if synchronous {
 			job()
 		} else {
 			// Start job in a go routine to not block the caller.
-			go job()
+			go splayedJob()
 		}
 	}
 
 	return nil
 }
 
-// UnscheduleJob removes the job from schedule.
+// UnscheduleJob removes the job from schedule, its pending and running runs get
+// their context canceled. The job can be scheduled again afterwards.
 func (s *Scheduler) UnscheduleJob(jobID string) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.unschedule(jobID, nil)
+}
+
+// unschedule removes the job from schedule, if ctx is not nil only the registration
+// with the context ctx is removed, not one scheduled again since.
+func (s *Scheduler) unschedule(jobID string, ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	logger.Infof("Unscheduling job %q", jobID)
 
-	entry, found := s.jobs[jobID]
-	if found {
-		s.cron.Remove(entry)
-		delete(s.jobs, jobID)
+	sj, found := s.jobs[jobID]
+	if !found || (ctx != nil && sj.ctx != ctx) {
+		return
 	}
+	s.cron.Remove(sj.entry)
+	sj.cancel()
+	delete(s.jobs, jobID)
+	s.backoffs.reset(jobID)
+	s.history.forget(jobID)
 }
 
 // start begins executing each job at defined interval.
@@ -162,6 +434,60 @@ func (s *Scheduler) Stop() {
 	s.cron.Stop()
 }
 
+// Pause pauses the scheduler, jobs remain scheduled but their runs are skipped
+// until Resume() is called.
+func (s *Scheduler) Pause() {
+	logger.Infof("Pausing the scheduler")
+	s.paused.Store(true)
+}
+
+// Resume resumes a previously paused scheduler.
+func (s *Scheduler) Resume() {
+	logger.Infof("Resuming the scheduler")
+	s.paused.Store(false)
+}
+
+// SetRunCallback sets the callback called after each job run, i.e. to report
+// the job's status. Passing nil removes a previously set callback.
+func (s *Scheduler) SetRunCallback(cb RunCallback) {
+	if cb == nil {
+		s.runCallback.Store(nil)
+		return
+	}
+	s.runCallback.Store(&cb)
+}
+
+// SetSplay sets the default maximum random delay of the jobs' runs, zero (the
+// default) disables it. Only the jobs scheduled afterwards are affected, the jobs
+// implementing SplayJob use their own splay.
+func (s *Scheduler) SetSplay(splay time.Duration) {
+	s.splay.Store(int64(splay))
+}
+
+// ResetBackoff resets the backoff of a failing job, its next scheduled run isn't
+// skipped. It's meant to be called on an event signaling the cause of the job's
+// failures is gone, i.e. the network coming back, in particular for the jobs
+// backing off until reset.
+func (s *Scheduler) ResetBackoff(jobID string) {
+	logger.Infof("Resetting the backoff of job %q", jobID)
+	s.backoffs.reset(jobID)
+}
+
+// SetMaxParallelJobs limits the number of jobs running at the same time, i.e. on
+// small footprint VMs, zero (the default) means no limit. The runs exceeding it
+// wait for a running job to return.
+func (s *Scheduler) SetMaxParallelJobs(limit int) {
+	s.slots.setMaxParallel(limit)
+}
+
+// SetJobTimeout sets the default timeout of the jobs' runs, zero (the default)
+// disables it. A run not returning in time is given up and counted as failed, its
+// next runs are skipped until it returns. Only the jobs scheduled afterwards are
+// affected, the jobs implementing TimeoutJob use their own timeout.
+func (s *Scheduler) SetJobTimeout(timeout time.Duration) {
+	s.jobTimeout.Store(int64(timeout))
+}
+
 // ScheduleJobs schedules required jobs and waits for it to finish if synchronous is true.
 func ScheduleJobs(ctx context.Context, jobs []Job, synchronous bool) {
 	wg := sync.WaitGroup{}
@@ -187,6 +513,30 @@ func ScheduleJobs(ctx context.Context, jobs []Job, synchronous bool) {
 	}
 }
 
+// ReconcileJobs schedules the jobs that should now be enabled and unschedules the
+// ones that should not, i.e. after a configuration change.
+func (s *Scheduler) ReconcileJobs(ctx context.Context, jobs []Job) {
+	for _, job := range jobs {
+		if job.ShouldEnable(ctx) {
+			if err := s.ScheduleJob(ctx, job, false); err != nil {
+				logger.Errorf("Failed to schedule job %s with error: %v", job.ID(), err)
+			}
+			continue
+		}
+
+		if s.IsScheduled(job.ID()) {
+			s.UnscheduleJob(job.ID())
+		}
+	}
+}
+
+// ScheduledJobs returns the IDs of the scheduled jobs, sorted.
+func (s *Scheduler) ScheduledJobs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Sorted(maps.Keys(s.jobs))
+}
+
 // IsScheduled returns true if job was scheduled.
 func (s *Scheduler) IsScheduled(jobID string) bool {
 	s.mu.RLock()
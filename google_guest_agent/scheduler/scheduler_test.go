@@ -16,6 +16,8 @@ package scheduler
 
 import (
 	"context"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -209,3 +211,394 @@ func TestScheduleJobsNoWait(t *testing.T) {
 		t.Errorf("ScheduleJobs(ctx, job1, true) returned after %f seconds, expected no wait", got.Seconds())
 	}
 }
+
+func TestPauseResume(t *testing.T) {
+	job := &testJob{
+		interval:     time.Second,
+		id:           "test_pause_job",
+		shouldEnable: true,
+	}
+
+	s := Get()
+	s.Pause()
+	defer s.UnscheduleJob(job.ID())
+
+	if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+		t.Fatalf("ScheduleJob(%s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+
+	// Run the scheduled function directly, cron's @every granularity makes
+	// relying on its timing flaky.
+	f := s.getFunc(context.Background(), job)
+
+	f()
+	if job.ctr != 0 {
+		t.Errorf("Paused scheduler ran job %d times, want: 0", job.ctr)
+	}
+
+	s.Resume()
+	f()
+	if job.ctr != 1 {
+		t.Errorf("Resumed scheduler ran job %d times, want: 1", job.ctr)
+	}
+}
+
+func TestRunCallback(t *testing.T) {
+	job := &testJob{
+		interval:     time.Second,
+		id:           "test_callback_job",
+		shouldEnable: true,
+	}
+
+	s := Get()
+	var ran []string
+	s.SetRunCallback(func(jobID string, err error) {
+		ran = append(ran, jobID)
+	})
+	defer s.SetRunCallback(nil)
+
+	f := s.getFunc(context.Background(), job)
+	f()
+
+	if len(ran) != 1 || ran[0] != job.ID() {
+		t.Errorf("Run callback called for %v, want: [%s]", ran, job.ID())
+	}
+}
+
+func TestReconcileJobs(t *testing.T) {
+	ctx := context.Background()
+	job := &testJob{
+		interval:     time.Hour,
+		id:           "test_reconcile_job",
+		shouldEnable: true,
+	}
+
+	s := Get()
+	defer s.UnscheduleJob(job.ID())
+
+	s.ReconcileJobs(ctx, []Job{job})
+	if !s.IsScheduled(job.ID()) {
+		t.Errorf("ReconcileJobs() didn't schedule enabled job %q", job.ID())
+	}
+
+	job.shouldEnable = false
+	s.ReconcileJobs(ctx, []Job{job})
+	if s.IsScheduled(job.ID()) {
+		t.Errorf("ReconcileJobs() didn't unschedule disabled job %q", job.ID())
+	}
+}
+
+type testCronJob struct {
+	testJob
+	schedule string
+}
+
+func (j *testCronJob) Schedule() string {
+	return j.schedule
+}
+
+func TestCronSchedule(t *testing.T) {
+	job := &testCronJob{
+		testJob: testJob{
+			interval:     time.Hour,
+			id:           "test_cron_job",
+			shouldEnable: true,
+		},
+		schedule: "* * * * * *",
+	}
+
+	s := Get()
+	if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	// The job runs every second rather than at its one hour interval.
+	time.Sleep(2500 * time.Millisecond)
+	if job.ctr < 2 {
+		t.Errorf("Cron job ran %d times, want at least 2", job.ctr)
+	}
+}
+
+func TestCronScheduleNextRun(t *testing.T) {
+	job := &testCronJob{
+		testJob: testJob{
+			interval:     time.Minute,
+			id:           "test_nightly_job",
+			shouldEnable: true,
+		},
+		schedule: "0 3 * * *",
+	}
+
+	s := Get()
+	if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	s.mu.RLock()
+	entry := s.cron.Entry(s.jobs[job.ID()].entry)
+	s.mu.RUnlock()
+
+	next := entry.Schedule.Next(time.Now())
+	if next.Hour() != 3 || next.Minute() != 0 || next.Second() != 0 {
+		t.Errorf("Nightly job's next run is at %s, want 03:00:00", next.Format(time.TimeOnly))
+	}
+}
+
+func TestCronScheduleInvalid(t *testing.T) {
+	job := &testCronJob{
+		testJob: testJob{
+			interval:     time.Minute,
+			id:           "test_invalid_cron_job",
+			shouldEnable: true,
+		},
+		schedule: "not a cron expression",
+	}
+
+	s := Get()
+	if err := s.ScheduleJob(context.Background(), job, false); err == nil {
+		s.UnscheduleJob(job.ID())
+		t.Fatalf("ScheduleJob(ctx, %s) succeeded with an invalid schedule, want error", job.ID())
+	}
+	if s.IsScheduled(job.ID()) {
+		t.Errorf("Job %q with an invalid schedule is scheduled", job.ID())
+	}
+}
+
+type testSplayJob struct {
+	testJob
+	splay time.Duration
+}
+
+func (j *testSplayJob) Splay() time.Duration {
+	return j.splay
+}
+
+func TestSplayed(t *testing.T) {
+	s := Get()
+	splay := 50 * time.Millisecond
+
+	ran := make(chan time.Time, 1)
+	start := time.Now()
+	s.splayed(context.Background(), "test_splayed_job", splay, func() { ran <- time.Now() })()
+
+	select {
+	case at := <-ran:
+		if delay := at.Sub(start); delay > splay+time.Second {
+			t.Errorf("Splayed run was delayed by %s, want at most %s", delay, splay)
+		}
+	default:
+		t.Fatalf("Splayed run didn't run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.splayed(ctx, "test_splayed_job", time.Hour, func() { ran <- time.Now() })()
+	select {
+	case <-ran:
+		t.Errorf("Splayed run ran after its context was canceled")
+	default:
+	}
+}
+
+func TestSplayJobSynchronousFirstRun(t *testing.T) {
+	job := &testSplayJob{
+		testJob: testJob{
+			interval:     time.Hour,
+			id:           "test_splay_job",
+			shouldEnable: true,
+			startingNow:  true,
+		},
+		splay: time.Hour,
+	}
+
+	s := Get()
+	if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	// The synchronous first run isn't delayed by the job's splay.
+	if job.ctr != 1 {
+		t.Errorf("Job ran %d times after a synchronous schedule, want 1", job.ctr)
+	}
+}
+
+// testBlockingJob blocks its runs until their context is canceled.
+type testBlockingJob struct {
+	testJob
+	started  chan struct{}
+	canceled chan struct{}
+}
+
+func (j *testBlockingJob) Run(ctx context.Context) (bool, error) {
+	close(j.started)
+	<-ctx.Done()
+	close(j.canceled)
+	return false, ctx.Err()
+}
+
+func TestRuntimeScheduleUnschedule(t *testing.T) {
+	ctx := context.Background()
+	job := &testBlockingJob{
+		testJob: testJob{
+			interval:     time.Hour,
+			id:           "test_runtime_job",
+			shouldEnable: true,
+			startingNow:  true,
+		},
+		started:  make(chan struct{}),
+		canceled: make(chan struct{}),
+	}
+
+	s := Get()
+	if err := s.ScheduleJob(ctx, job, false); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	if !slices.Contains(s.ScheduledJobs(), job.ID()) {
+		t.Errorf("ScheduledJobs() = %v, want it to contain %q", s.ScheduledJobs(), job.ID())
+	}
+
+	select {
+	case <-job.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Job %q didn't start", job.ID())
+	}
+
+	s.UnscheduleJob(job.ID())
+	select {
+	case <-job.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Running job %q wasn't canceled when unscheduled", job.ID())
+	}
+	if s.IsScheduled(job.ID()) {
+		t.Errorf("Job %q is still scheduled after UnscheduleJob()", job.ID())
+	}
+
+	// The job can be scheduled again.
+	again := &testJob{interval: time.Hour, id: job.ID(), shouldEnable: true}
+	if err := s.ScheduleJob(ctx, again, false); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", again.ID(), err)
+	}
+	defer s.UnscheduleJob(again.ID())
+	if !s.IsScheduled(again.ID()) {
+		t.Errorf("Job %q wasn't scheduled again", again.ID())
+	}
+}
+
+func TestStaleRunKeepsNewSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := Get()
+	old := &testJob{interval: time.Hour, id: "test_stale_job", shouldEnable: true, stopAfter: 1}
+	run := s.getFunc(ctx, old)
+
+	job := &testJob{interval: time.Hour, id: old.ID(), shouldEnable: true}
+	if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	// A run of a previous registration asking not to be rescheduled doesn't
+	// unschedule the current one.
+	run()
+	if !s.IsScheduled(job.ID()) {
+		t.Errorf("A stale run unscheduled job %q", job.ID())
+	}
+}
+
+// testStuckJob ignores its context and blocks its runs until release is closed.
+type testStuckJob struct {
+	testJob
+	timeout time.Duration
+	release chan struct{}
+	runs    atomic.Int32
+}
+
+func (j *testStuckJob) Run(_ context.Context) (bool, error) {
+	j.runs.Add(1)
+	<-j.release
+	return true, nil
+}
+
+func (j *testStuckJob) Timeout() time.Duration {
+	return j.timeout
+}
+
+func TestJobTimeout(t *testing.T) {
+	job := &testStuckJob{
+		testJob: testJob{interval: time.Hour, id: "test_stuck_job", shouldEnable: true},
+		timeout: 10 * time.Millisecond,
+		release: make(chan struct{}),
+	}
+
+	var errs []error
+	s := Get()
+	s.SetRunCallback(func(jobID string, err error) {
+		if jobID == job.ID() {
+			errs = append(errs, err)
+		}
+	})
+	defer s.SetRunCallback(nil)
+
+	run := s.getFunc(context.Background(), job)
+
+	// The stuck run is given up after the job's timeout.
+	run()
+	if len(errs) != 1 || errs[0] == nil {
+		t.Fatalf("Stuck run reported errors %v, want a timeout error", errs)
+	}
+
+	// The next run is skipped while the stuck one is still running.
+	run()
+	if got := job.runs.Load(); got != 1 {
+		t.Errorf("Job ran %d times while its previous run was stuck, want 1", got)
+	}
+
+	close(job.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for job.runs.Load() == 1 && time.Now().Before(deadline) {
+		run()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := job.runs.Load(); got < 2 {
+		t.Errorf("Job ran %d times after its stuck run returned, want at least 2", got)
+	}
+}
+
+// testPanicJob panics on its runs.
+type testPanicJob struct {
+	testJob
+}
+
+func (j *testPanicJob) Run(_ context.Context) (bool, error) {
+	panic("test panic")
+}
+
+func TestJobPanic(t *testing.T) {
+	job := &testPanicJob{testJob{interval: time.Hour, id: "test_panic_job", shouldEnable: true, startingNow: true}}
+
+	var got error
+	s := Get()
+	s.SetRunCallback(func(jobID string, err error) {
+		if jobID == job.ID() {
+			got = err
+		}
+	})
+	defer s.SetRunCallback(nil)
+
+	if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	// The panic is reported as a failed run and the job is kept scheduled.
+	if got == nil {
+		t.Errorf("Panicking run reported no error")
+	}
+	if !s.IsScheduled(job.ID()) {
+		t.Errorf("Job %q was unscheduled after panicking", job.ID())
+	}
+}
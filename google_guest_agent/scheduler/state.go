@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"github.com/robfig/cron/v3"
+)
+
+// runState keeps the time of the jobs' last successful run, and the boot of the
+// once per boot jobs' successful run, persisted to a state file so the schedule is
+// resumed across agent restarts. Its zero value keeps the state in memory only.
+type runState struct {
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// file is the state file, empty if the state is not persisted.
+	file string
+	// lastRuns maps the job IDs to the time of their last successful run.
+	lastRuns map[string]time.Time
+	// bootRuns maps the once per boot job IDs to the ID of the boot they
+	// successfully ran in.
+	bootRuns map[string]string
+}
+
+// persistedState is the content of the state file.
+type persistedState struct {
+	// LastRuns maps the job IDs to the time of their last successful run.
+	LastRuns map[string]time.Time `json:"last_runs"`
+	// BootRuns maps the once per boot job IDs to the ID of the boot they
+	// successfully ran in.
+	BootRuns map[string]string `json:"boot_runs,omitempty"`
+}
+
+// load loads the state from file and persists the state to it from now on.
+func (r *runState) load(file string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.file = file
+	r.lastRuns = make(map[string]time.Time)
+	r.bootRuns = make(map[string]string)
+	if file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read scheduler state: %w", err)
+	}
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		// Start from scratch, the state is overwritten by the next run.
+		return fmt.Errorf("failed to decode scheduler state: %w", err)
+	}
+	if state.LastRuns == nil {
+		// The state file of older agents only has the last runs.
+		if err := json.Unmarshal(data, &state.LastRuns); err != nil {
+			return fmt.Errorf("failed to decode scheduler state: %w", err)
+		}
+	}
+	if state.LastRuns != nil {
+		r.lastRuns = state.LastRuns
+	}
+	if state.BootRuns != nil {
+		r.bootRuns = state.BootRuns
+	}
+	return nil
+}
+
+// lastRun returns the time of the last successful run of jobID, zero if unknown.
+func (r *runState) lastRun(jobID string) time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lastRuns[jobID]
+}
+
+// bootRun returns the ID of the boot jobID successfully ran in, empty if unknown.
+func (r *runState) bootRun(jobID string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.bootRuns[jobID]
+}
+
+// record records a successful run of jobID at t, persisting the state if enabled.
+func (r *runState) record(jobID string, t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.lastRuns == nil {
+		r.lastRuns = make(map[string]time.Time)
+	}
+	r.lastRuns[jobID] = t
+	r.persist()
+}
+
+// recordBoot records a successful run of the once per boot jobID in the boot
+// bootID, persisting the state if enabled.
+func (r *runState) recordBoot(jobID string, bootID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.bootRuns == nil {
+		r.bootRuns = make(map[string]string)
+	}
+	r.bootRuns[jobID] = bootID
+	r.persist()
+}
+
+// persist writes the state to the state file if enabled, the caller must hold
+// the mutex.
+func (r *runState) persist() {
+	if r.file == "" {
+		return
+	}
+
+	data, err := json.Marshal(persistedState{LastRuns: r.lastRuns, BootRuns: r.bootRuns})
+	if err != nil {
+		logger.Errorf("Failed to encode scheduler state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.file), 0755); err != nil {
+		logger.Errorf("Failed to create scheduler state directory: %v", err)
+		return
+	}
+	if err := utils.SaferWriteFile(data, r.file, 0644); err != nil {
+		logger.Errorf("Failed to write scheduler state: %v", err)
+	}
+}
+
+// resumedSchedule is a schedule resumed from a previous agent run, its first
+// activation is the one following the last successful run.
+type resumedSchedule struct {
+	// first is the first activation.
+	first time.Time
+	// schedule is the job's schedule.
+	schedule cron.Schedule
+}
+
+// Next returns the first activation until it's reached, then the schedule's.
+func (s *resumedSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.first) {
+		return s.first
+	}
+	return s.schedule.Next(t)
+}
+
+// SetStateFile persists the time of the jobs' last successful run to file, and
+// loads the times persisted by a previous agent run. The jobs scheduled afterwards
+// resume their schedule from their last successful run, a job starting immediately
+// is only run right away if a run was due since. The jobs scheduled synchronously
+// are not affected. An empty file disables the persistence.
+func (s *Scheduler) SetStateFile(file string) error {
+	return s.state.load(file)
+}
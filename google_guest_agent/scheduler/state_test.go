@@ -0,0 +1,149 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunStatePersisted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "jobs.json")
+
+	var state runState
+	if err := state.load(file); err != nil {
+		t.Fatalf("load(%s) failed: %v", file, err)
+	}
+	last := time.Now()
+	state.record("test_job", last)
+	state.recordBoot("test_boot_job", "boot-1")
+
+	var loaded runState
+	if err := loaded.load(file); err != nil {
+		t.Fatalf("load(%s) failed: %v", file, err)
+	}
+	if got := loaded.lastRun("test_job"); !got.Equal(last) {
+		t.Errorf("lastRun(test_job) = %s, want %s", got, last)
+	}
+	if got := loaded.lastRun("unknown_job"); !got.IsZero() {
+		t.Errorf("lastRun(unknown_job) = %s, want zero time", got)
+	}
+	if got := loaded.bootRun("test_boot_job"); got != "boot-1" {
+		t.Errorf("bootRun(test_boot_job) = %q, want %q", got, "boot-1")
+	}
+}
+
+func TestRunStateLegacy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jobs.json")
+	last := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := json.Marshal(map[string]time.Time{"test_job": last})
+	if err != nil {
+		t.Fatalf("Failed to encode state: %v", err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	var state runState
+	if err := state.load(file); err != nil {
+		t.Fatalf("load(%s) failed: %v", file, err)
+	}
+	if got := state.lastRun("test_job"); !got.Equal(last) {
+		t.Errorf("lastRun(test_job) = %s, want %s", got, last)
+	}
+}
+
+func TestRunStateInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jobs.json")
+	if err := os.WriteFile(file, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	var state runState
+	if err := state.load(file); err == nil {
+		t.Errorf("load(%s) succeeded with an invalid state file, want error", file)
+	}
+}
+
+func TestResumeSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		lastRun time.Duration
+		wantRun bool
+	}{
+		{
+			name:    "not-due",
+			lastRun: 30 * time.Minute,
+			wantRun: false,
+		},
+		{
+			name:    "due",
+			lastRun: 2 * time.Hour,
+			wantRun: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			job := &testJob{
+				interval:     time.Hour,
+				id:           "test_resumed_job_" + tc.name,
+				shouldEnable: true,
+				startingNow:  true,
+			}
+			last := time.Now().Add(-tc.lastRun)
+
+			file := filepath.Join(t.TempDir(), "jobs.json")
+			data, err := json.Marshal(persistedState{LastRuns: map[string]time.Time{job.ID(): last}})
+			if err != nil {
+				t.Fatalf("Failed to encode state: %v", err)
+			}
+			if err := os.WriteFile(file, data, 0644); err != nil {
+				t.Fatalf("Failed to write state file: %v", err)
+			}
+
+			s := Get()
+			if err := s.SetStateFile(file); err != nil {
+				t.Fatalf("SetStateFile(%s) failed: %v", file, err)
+			}
+			t.Cleanup(func() { s.SetStateFile("") })
+
+			if err := s.ScheduleJob(context.Background(), job, false); err != nil {
+				t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+			}
+			defer s.UnscheduleJob(job.ID())
+
+			time.Sleep(200 * time.Millisecond)
+			if got := job.ctr > 0; got != tc.wantRun {
+				t.Errorf("Job ran right away: %t, want %t", got, tc.wantRun)
+			}
+
+			if tc.wantRun {
+				return
+			}
+			s.mu.RLock()
+			entry := s.cron.Entry(s.jobs[job.ID()].entry)
+			s.mu.RUnlock()
+			want := last.Add(time.Hour)
+			if next := entry.Schedule.Next(time.Now()); next.Sub(want).Abs() > time.Second {
+				t.Errorf("Resumed job's next run is at %s, want %s", next, want)
+			}
+		})
+	}
+}
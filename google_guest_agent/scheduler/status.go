@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobStatus is the status of a scheduled job, see JobStatuses().
+type JobStatus struct {
+	// ID is the job's ID.
+	ID string
+	// NextRun is the time of the job's next run, zero if the scheduler isn't
+	// started.
+	NextRun time.Time
+	// LastRun is when the job's last run started, zero if it hasn't run yet.
+	LastRun time.Time
+	// LastDuration is how long the job's last run took.
+	LastDuration time.Duration
+	// LastError is the error of the job's last run, nil if it succeeded.
+	LastError error
+	// ConsecutiveFailures is the number of the job's consecutive failed runs.
+	ConsecutiveFailures int
+}
+
+// String returns a human readable representation of the job's status.
+func (st JobStatus) String() string {
+	var res strings.Builder
+	fmt.Fprintf(&res, "%s: ", st.ID)
+	if st.NextRun.IsZero() {
+		res.WriteString("next run unknown")
+	} else {
+		fmt.Fprintf(&res, "next run at %s", st.NextRun.Format(time.RFC3339))
+	}
+
+	if st.LastRun.IsZero() {
+		res.WriteString(", never run")
+		return res.String()
+	}
+	fmt.Fprintf(&res, ", last run at %s took %s", st.LastRun.Format(time.RFC3339), st.LastDuration.Round(time.Millisecond))
+	if st.LastError == nil {
+		res.WriteString(", succeeded")
+		return res.String()
+	}
+	fmt.Fprintf(&res, ", failed %d consecutive times: %v", st.ConsecutiveFailures, st.LastError)
+	return res.String()
+}
+
+// runHistory keeps the outcome of the jobs' last run.
+type runHistory struct {
+	// mutex protects runs.
+	mutex sync.Mutex
+	// runs maps the job IDs to the status of their last run.
+	runs map[string]JobStatus
+}
+
+// record records a run of the job started at start, taking duration and failing
+// with err, if not nil.
+func (h *runHistory) record(jobID string, start time.Time, duration time.Duration, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.runs == nil {
+		h.runs = make(map[string]JobStatus)
+	}
+
+	st := h.runs[jobID]
+	st.LastRun, st.LastDuration, st.LastError = start, duration, err
+	if err != nil {
+		st.ConsecutiveFailures++
+	} else {
+		st.ConsecutiveFailures = 0
+	}
+	h.runs[jobID] = st
+}
+
+// status returns the status of the job's last run.
+func (h *runHistory) status(jobID string) JobStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	st := h.runs[jobID]
+	st.ID = jobID
+	return st
+}
+
+// forget forgets the job's runs.
+func (h *runHistory) forget(jobID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.runs, jobID)
+}
+
+// JobStatuses returns the status of the scheduled jobs sorted by their ID, i.e.
+// to verify the jobs are actually running.
+func (s *Scheduler) JobStatuses() []JobStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var res []JobStatus
+	for id, sj := range s.jobs {
+		st := s.history.status(id)
+		st.NextRun = s.cron.Entry(sj.entry).Next
+		res = append(res, st)
+	}
+	slices.SortFunc(res, func(a, b JobStatus) int { return strings.Compare(a.ID, b.ID) })
+	return res
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJobStatusString(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status JobStatus
+		want   string
+	}{
+		{
+			name:   "never-run",
+			status: JobStatus{ID: "job", NextRun: at},
+			want:   "job: next run at 2024-01-02T03:04:05Z, never run",
+		},
+		{
+			name:   "succeeded",
+			status: JobStatus{ID: "job", NextRun: at, LastRun: at, LastDuration: 1500 * time.Millisecond},
+			want:   "job: next run at 2024-01-02T03:04:05Z, last run at 2024-01-02T03:04:05Z took 1.5s, succeeded",
+		},
+		{
+			name:   "failed",
+			status: JobStatus{ID: "job", LastRun: at, LastDuration: time.Second, LastError: errors.New("unreachable"), ConsecutiveFailures: 2},
+			want:   "job: next run unknown, last run at 2024-01-02T03:04:05Z took 1s, failed 2 consecutive times: unreachable",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.status.String()); diff != "" {
+				t.Errorf("String() returned unexpected status (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJobStatuses(t *testing.T) {
+	job := &testBackoffJob{
+		testJob: testJob{interval: time.Hour, id: "test_status_job", shouldEnable: true, startingNow: true},
+		fail:    true,
+	}
+
+	s := Get()
+	if err := s.ScheduleJob(context.Background(), job, true); err != nil {
+		t.Fatalf("ScheduleJob(ctx, %s) failed unexpectedly with error: %v", job.ID(), err)
+	}
+	defer s.UnscheduleJob(job.ID())
+
+	var got *JobStatus
+	for _, curr := range s.JobStatuses() {
+		if curr.ID == job.ID() {
+			got = &curr
+		}
+	}
+	if got == nil {
+		t.Fatalf("JobStatuses() = %v, want it to contain %q", s.JobStatuses(), job.ID())
+	}
+	if got.LastRun.IsZero() || got.LastError == nil || got.ConsecutiveFailures != 1 {
+		t.Errorf("JobStatuses() returned %+v for %q, want a failed run", got, job.ID())
+	}
+
+	s.UnscheduleJob(job.ID())
+	for _, curr := range s.JobStatuses() {
+		if curr.ID == job.ID() {
+			t.Errorf("JobStatuses() returned unscheduled job %q", job.ID())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnotify
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// watchdogUsecEnv is the environment variable holding the watchdog timeout in microseconds.
+	watchdogUsecEnv = "WATCHDOG_USEC"
+	// watchdogPIDEnv is the environment variable holding the pid expected to send keepalives.
+	watchdogPIDEnv = "WATCHDOG_PID"
+)
+
+// Keepalive sends the WATCHDOG=1 keepalive to the service manager while the
+// service is alive. The service reports it's alive calling Heartbeat(), if no
+// heartbeat is reported within the liveness period the keepalives stop and the
+// service manager restarts the service once its watchdog timeout expires.
+type Keepalive struct {
+	// mutex protects lastHeartbeat.
+	mutex sync.Mutex
+	// lastHeartbeat is the last time Heartbeat() was called.
+	lastHeartbeat time.Time
+	// liveness is the maximum period between heartbeats, zero disables the heartbeat check.
+	liveness time.Duration
+	// interval is the service manager's watchdog timeout.
+	interval time.Duration
+}
+
+// WatchdogInterval returns the service manager's watchdog timeout, the returned bool
+// is false if the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv(watchdogUsecEnv), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	if pid := os.Getenv(watchdogPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// NewKeepalive allocates a Keepalive considering the service alive for liveness
+// after each heartbeat, if liveness is zero keepalives are sent unconditionally.
+// The returned bool is false if the watchdog is not enabled for this process.
+func NewKeepalive(liveness time.Duration) (*Keepalive, bool) {
+	interval, enabled := WatchdogInterval()
+	if !enabled {
+		return nil, false
+	}
+
+	return &Keepalive{
+		lastHeartbeat: time.Now(),
+		liveness:      liveness,
+		interval:      interval,
+	}, true
+}
+
+// Heartbeat reports the service is alive.
+func (k *Keepalive) Heartbeat() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.lastHeartbeat = time.Now()
+}
+
+// alive returns true if a heartbeat was reported within the liveness period.
+func (k *Keepalive) alive(now time.Time) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.liveness <= 0 || now.Sub(k.lastHeartbeat) < k.liveness
+}
+
+// Run sends keepalives at half the watchdog timeout, as recommended by sd_watchdog_enabled(3),
+// until ctx is done.
+func (k *Keepalive) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !k.alive(now) {
+				logger.Errorf("No heartbeat for more than %s, skipping service manager keepalive.", k.liveness)
+				continue
+			}
+			if err := Notify(Watchdog); err != nil {
+				logger.Errorf("Failed to send service manager keepalive: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sdnotify implements the systemd's sd_notify protocol, it notifies the
+// service manager about state changes writing to the datagram socket defined in
+// the NOTIFY_SOCKET environment variable. Messages are sent from the agent's own
+// process so they are accepted with systemd's default NotifyAccess=main.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// Ready tells the service manager the service startup is finished.
+	Ready = "READY=1"
+	// Stopping tells the service manager the service is beginning its shutdown.
+	Stopping = "STOPPING=1"
+	// Reloading tells the service manager the service is reloading its configuration.
+	Reloading = "RELOADING=1"
+	// Watchdog tells the service manager to update the watchdog timestamp.
+	Watchdog = "WATCHDOG=1"
+
+	// socketEnv is the environment variable holding the notification socket's address.
+	socketEnv = "NOTIFY_SOCKET"
+)
+
+// Status returns the state describing the service's status to the service manager,
+// i.e. the text shown by systemctl status.
+func Status(text string) string {
+	// The protocol is new line separated, a status text must fit in a single line.
+	return "STATUS=" + strings.ReplaceAll(text, "\n", " ")
+}
+
+// Enabled returns true if the process was started by a service manager expecting
+// notifications.
+func Enabled() bool {
+	return os.Getenv(socketEnv) != ""
+}
+
+// Disable stops notifying the service manager, i.e. when it's known not to be
+// systemd. The notification socket is also hidden from the child processes.
+func Disable() {
+	os.Unsetenv(socketEnv)
+}
+
+// Notify sends states to the service manager. It's a no-op if the process was not
+// started by a service manager expecting notifications.
+func Notify(states ...string) error {
+	addr := os.Getenv(socketEnv)
+	if addr == "" {
+		return nil
+	}
+
+	// Addresses starting with @ are in the abstract namespace.
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return fmt.Errorf("failed to write to notify socket: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on %q: %v", addr, err)
+	}
+	defer conn.Close()
+
+	t.Setenv(socketEnv, addr)
+
+	if !Enabled() {
+		t.Errorf("Enabled() = false, want: true")
+	}
+
+	if err := Notify(Ready, Status("multi\nline")); err != nil {
+		t.Fatalf("Notify() failed: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+
+	want := "READY=1\nSTATUS=multi line"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("Notify() sent %q, want: %q", got, want)
+	}
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	t.Setenv(socketEnv, "")
+
+	if Enabled() {
+		t.Errorf("Enabled() = true, want: false")
+	}
+
+	if err := Notify(Ready); err != nil {
+		t.Errorf("Notify() without a notify socket returned error: %v", err)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	t.Setenv(socketEnv, "@test-socket")
+
+	Disable()
+	if Enabled() {
+		t.Errorf("Enabled() = true after Disable(), want: false")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		usec        string
+		pid         string
+		want        time.Duration
+		wantEnabled bool
+	}{
+		{"not set", "", "", 0, false},
+		{"invalid", "bogus", "", 0, false},
+		{"enabled", "30000000", "", 30 * time.Second, true},
+		{"enabled for this pid", "30000000", strconv.Itoa(os.Getpid()), 30 * time.Second, true},
+		{"enabled for other pid", "30000000", "1", 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(watchdogUsecEnv, tc.usec)
+			t.Setenv(watchdogPIDEnv, tc.pid)
+
+			got, enabled := WatchdogInterval()
+			if got != tc.want || enabled != tc.wantEnabled {
+				t.Errorf("WatchdogInterval() = (%s, %t), want: (%s, %t)", got, enabled, tc.want, tc.wantEnabled)
+			}
+		})
+	}
+}
+
+func TestKeepaliveAlive(t *testing.T) {
+	t.Setenv(watchdogUsecEnv, "30000000")
+	t.Setenv(watchdogPIDEnv, "")
+
+	k, enabled := NewKeepalive(time.Minute)
+	if !enabled {
+		t.Fatalf("NewKeepalive() returned disabled, want enabled")
+	}
+
+	now := time.Now()
+	if !k.alive(now) {
+		t.Errorf("alive() = false right after creation, want: true")
+	}
+
+	if k.alive(now.Add(2 * time.Minute)) {
+		t.Errorf("alive() = true without heartbeats for 2m, want: false")
+	}
+
+	k.Heartbeat()
+	if !k.alive(time.Now()) {
+		t.Errorf("alive() = false right after Heartbeat(), want: true")
+	}
+
+	k, _ = NewKeepalive(0)
+	if !k.alive(now.Add(time.Hour)) {
+		t.Errorf("alive() = false with the heartbeat check disabled, want: true")
+	}
+}
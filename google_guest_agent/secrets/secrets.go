@@ -0,0 +1,147 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secrets resolves Secret Manager secret references using the
+// credentials of the instance's default service account.
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+const (
+	// tokenKey is the metadata key of the default service account's access token.
+	tokenKey = "instance/service-accounts/default/token"
+)
+
+var (
+	// apiEndpoint is the Secret Manager API endpoint.
+	apiEndpoint = "https://secretmanager.googleapis.com/v1"
+
+	// defaultRetryPolicy is the policy used when accessing a secret version.
+	defaultRetryPolicy = retry.Policy{MaxAttempts: 3, BackoffFactor: 1, Jitter: time.Second}
+)
+
+// token is the access token returned by the metadata server.
+type token struct {
+	AccessToken string `json:"access_token"`
+}
+
+// accessResponse is the response of the Secret Manager access version method.
+type accessResponse struct {
+	Payload struct {
+		Data string `json:"data"`
+	} `json:"payload"`
+}
+
+// Resolver resolves secret references.
+type Resolver struct {
+	client metadata.MDSClientInterface
+}
+
+// NewResolver returns a Resolver fetching the access tokens from client.
+func NewResolver(client metadata.MDSClientInterface) *Resolver {
+	return &Resolver{client: client}
+}
+
+// versionName returns the secret version resource name referenced by ref, ref
+// must be in the form sm://projects/<project>/secrets/<secret>, optionally
+// followed by /versions/<version>. If no version is referenced the latest is used.
+func versionName(ref string) (string, error) {
+	name, found := strings.CutPrefix(ref, cfg.SecretPrefix)
+	if !found {
+		return "", fmt.Errorf("invalid secret reference %q, missing %s prefix", ref, cfg.SecretPrefix)
+	}
+
+	parts := strings.Split(name, "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		parts = append(parts, "versions", "latest")
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+	default:
+		return "", fmt.Errorf("invalid secret reference %q, want: %sprojects/<project>/secrets/<secret>[/versions/<version>]", ref, cfg.SecretPrefix)
+	}
+
+	for _, curr := range parts {
+		if curr == "" {
+			return "", fmt.Errorf("invalid secret reference %q, empty resource name segment", ref)
+		}
+	}
+
+	return strings.Join(parts, "/"), nil
+}
+
+// Resolve returns the value of the secret version referenced by ref.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	name, err := versionName(ref)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := r.client.GetKey(ctx, tokenKey, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get service account access token: %w", err)
+	}
+
+	var tok token
+	if err := json.Unmarshal([]byte(resp), &tok); err != nil {
+		return "", fmt.Errorf("failed to parse service account access token: %w", err)
+	}
+
+	data, err := retry.RunWithResponse(ctx, defaultRetryPolicy, func() ([]byte, error) {
+		url := fmt.Sprintf("%s/%s:access", apiEndpoint, name)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %q, bad status: %s", url, res.Status)
+		}
+		return io.ReadAll(res.Body)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret version %q: %w", name, err)
+	}
+
+	var access accessResponse
+	if err := json.Unmarshal(data, &access); err != nil {
+		return "", fmt.Errorf("failed to parse secret version %q: %w", name, err)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(access.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret version %q: %w", name, err)
+	}
+
+	return string(value), nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+)
+
+// tokenMDS is a fake metadata client returning a fixed access token.
+type tokenMDS struct {
+	metadata.MDSClientInterface
+}
+
+func (tokenMDS) GetKey(ctx context.Context, key string, headers map[string]string) (string, error) {
+	if key != tokenKey {
+		return "", fmt.Errorf("unexpected key %q", key)
+	}
+	return `{"access_token": "token", "expires_in": 3600, "token_type": "Bearer"}`, nil
+}
+
+func TestVersionName(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"sm://projects/p/secrets/s", "projects/p/secrets/s/versions/latest", false},
+		{"sm://projects/p/secrets/s/versions/3", "projects/p/secrets/s/versions/3", false},
+		{"projects/p/secrets/s", "", true},
+		{"sm://projects/p/secrets", "", true},
+		{"sm://projects//secrets/s", "", true},
+		{"sm://projects/p/topics/s", "", true},
+		{"sm://projects/p/secrets/s/aliases/3", "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			got, err := versionName(tc.ref)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("versionName(%q) returned error: %v, want error: %t", tc.ref, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("versionName(%q) = %q, want: %q", tc.ref, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	defaultRetryPolicy.MaxAttempts = 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/projects/p/secrets/s/versions/latest:access":
+			fmt.Fprintf(w, `{"name": "projects/p/secrets/s/versions/1", "payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte("secret value")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	oldEndpoint := apiEndpoint
+	t.Cleanup(func() { apiEndpoint = oldEndpoint })
+	apiEndpoint = srv.URL
+
+	resolver := NewResolver(tokenMDS{})
+
+	got, err := resolver.Resolve(ctx, "sm://projects/p/secrets/s")
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+	if got != "secret value" {
+		t.Errorf("Resolve() = %q, want: %q", got, "secret value")
+	}
+
+	if _, err := resolver.Resolve(ctx, "sm://projects/p/secrets/missing"); err == nil {
+		t.Errorf("Resolve() succeeded for a missing secret, want error")
+	}
+}
@@ -21,6 +21,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/kardianos/service"
 )
 
@@ -41,7 +43,21 @@ func (p *program) Start(s service.Service) error {
 }
 
 func (p *program) Stop(s service.Service) error {
+	if err := notifyStopping(); err != nil {
+		logger.Debugf("Failed to notify service manager we are stopping: %v", err)
+	}
+
+	// Let in-flight handlers finish (i.e. a manager half way through writing a
+	// config file) before canceling the context.
+	drainTimeout, err := time.ParseDuration(cfg.Get().Core.ShutdownDrainTimeout)
+	if err != nil {
+		logger.Errorf("Invalid shutdown drain timeout %q, not draining: %v", cfg.Get().Core.ShutdownDrainTimeout, err)
+	} else if !inflight.drain(drainTimeout) {
+		logger.Warningf("In-flight work didn't complete within %s, canceling it.", drainTimeout)
+	}
+
 	p.cancel()
+	defer restoreStatus(context.Background())
 	select {
 	case <-p.done:
 		return nil
@@ -50,13 +66,21 @@ func (p *program) Stop(s service.Service) error {
 	}
 }
 
+// Shutdown is called instead of Stop when the system is shutting down, only
+// supported on Windows.
+func (p *program) Shutdown(s service.Service) error {
+	systemShutdown.Store(true)
+	return p.Stop(s)
+}
+
 func usage(name string) {
 	fmt.Printf(
 		"Usage:\n"+
 			"  %[1]s install: install the %[2]s service\n"+
 			"  %[1]s remove: remove the %[2]s service\n"+
 			"  %[1]s start: start the %[2]s service\n"+
-			"  %[1]s stop: stop the %[2]s service\n", filepath.Base(os.Args[0]), name)
+			"  %[1]s stop: stop the %[2]s service\n"+
+			"  %[1]s dump-config: print the effective configuration and where each key was set\n", filepath.Base(os.Args[0]), name)
 }
 
 func register(ctx context.Context, name, displayName, desc string, run func(context.Context), action string) error {
@@ -83,7 +107,7 @@ func register(ctx context.Context, name, displayName, desc string, run func(cont
 
 	switch action {
 	case "run":
-		return svc.Run()
+		return runService(name, prg, svc)
 	case "install":
 		if err := svc.Install(); err != nil {
 			return fmt.Errorf("failed to install service %s: %s", name, err)
@@ -0,0 +1,83 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"github.com/kardianos/service"
+	"golang.org/x/sys/windows/svc"
+)
+
+// winHandler implements the service control handler, unlike kardianos/service's
+// handler it also accepts pause and continue requests.
+type winHandler struct {
+	prg *program
+}
+
+// runService runs the agent under the service control manager, if not running
+// as a service (i.e. interactively) it's handled by kardianos/service.
+func runService(name string, prg *program, s service.Service) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return s.Run()
+	}
+	return svc.Run(name, &winHandler{prg: prg})
+}
+
+// Execute handles the service control manager's requests.
+func (h *winHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+
+	changes <- svc.Status{State: svc.StartPending}
+	if err := h.prg.Start(nil); err != nil {
+		logger.Errorf("Failed to start service: %v", err)
+		return true, 1
+	}
+	changes <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			changes <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			changes <- svc.Status{State: svc.StopPending}
+
+			var err error
+			if req.Cmd == svc.Shutdown {
+				err = h.prg.Shutdown(nil)
+			} else {
+				err = h.prg.Stop(nil)
+			}
+
+			if err != nil {
+				logger.Errorf("Failed to stop service: %v", err)
+				return true, 2
+			}
+			return false, 0
+		case svc.Pause:
+			changes <- svc.Status{State: svc.PausePending, Accepts: accepted}
+			pauseAgent(h.prg.ctx)
+			changes <- svc.Status{State: svc.Paused, Accepts: accepted}
+		case svc.Continue:
+			changes <- svc.Status{State: svc.ContinuePending, Accepts: accepted}
+			resumeAgent(h.prg.ctx)
+			changes <- svc.Status{State: svc.Running, Accepts: accepted}
+		default:
+			logger.Errorf("Unexpected service control request: %d", req.Cmd)
+		}
+	}
+
+	return false, 0
+}
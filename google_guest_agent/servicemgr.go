@@ -0,0 +1,134 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/sdnotify"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// serviceManagerAuto means the service manager is detected by probing the environment.
+	serviceManagerAuto = "auto"
+	// serviceManagerSystemd is systemd, notified with the sd_notify protocol.
+	serviceManagerSystemd = "systemd"
+	// serviceManagerOpenRC is OpenRC, notified with a pid file and a ready file.
+	serviceManagerOpenRC = "openrc"
+	// serviceManagerSysV is SysV init, notified with a pid file and a ready file.
+	serviceManagerSysV = "sysv"
+	// serviceManagerSCM is the Windows Service Control Manager.
+	serviceManagerSCM = "scm"
+	// serviceManagerNone means the agent is not run by a known service manager,
+	// i.e. started manually.
+	serviceManagerNone = "none"
+)
+
+// serviceManager is the service manager running the agent, set by
+// initServiceManager().
+var serviceManager = serviceManagerNone
+
+// initServiceManager sets serviceManager from the configuration or, if set to
+// auto, by probing the environment.
+func initServiceManager() {
+	configured := serviceManagerAuto
+	if config := cfg.Get().Service; config != nil && config.Manager != "" {
+		configured = strings.ToLower(strings.TrimSpace(config.Manager))
+	}
+
+	switch configured {
+	case serviceManagerSystemd, serviceManagerOpenRC, serviceManagerSysV, serviceManagerSCM, serviceManagerNone:
+		serviceManager = configured
+	default:
+		if configured != serviceManagerAuto {
+			logger.Errorf("Unknown service manager %q configured, detecting it instead.", configured)
+		}
+		serviceManager = detectServiceManager()
+	}
+
+	logger.Infof("Running under service manager: %s", serviceManager)
+
+	// Don't talk sd_notify to anything but systemd, it also keeps the notification
+	// socket from leaking to the processes the agent runs.
+	if serviceManager != serviceManagerSystemd {
+		sdnotify.Disable()
+	}
+}
+
+// usesInitScript returns true if the agent is run by an init script based service
+// manager, these track the agent through its pid file and ready file.
+func usesInitScript() bool {
+	return serviceManager == serviceManagerOpenRC || serviceManager == serviceManagerSysV
+}
+
+// notifyStarted tells the service manager the agent process started.
+func notifyStarted() error {
+	if !usesInitScript() {
+		return nil
+	}
+
+	pidFile := cfg.Get().Service.PIDFile
+	if err := utils.WriteFile([]byte(strconv.Itoa(os.Getpid())+"\n"), pidFile, 0644); err != nil {
+		return fmt.Errorf("failed to write pid file %q: %w", pidFile, err)
+	}
+	return nil
+}
+
+// notifyReady tells the service manager the agent finished its startup or
+// configuration reload.
+func notifyReady() error {
+	if usesInitScript() {
+		readyFile := cfg.Get().Service.ReadyFile
+		if err := utils.WriteFile(nil, readyFile, 0644); err != nil {
+			return fmt.Errorf("failed to write ready file %q: %w", readyFile, err)
+		}
+		return nil
+	}
+	return sdnotify.Notify(sdnotify.Ready)
+}
+
+// notifyReloading tells the service manager the agent is reloading its configuration.
+func notifyReloading() error {
+	if usesInitScript() {
+		return removeServiceFile(cfg.Get().Service.ReadyFile)
+	}
+	return sdnotify.Notify(sdnotify.Reloading)
+}
+
+// notifyStopping tells the service manager the agent is beginning its shutdown.
+func notifyStopping() error {
+	if usesInitScript() {
+		if err := removeServiceFile(cfg.Get().Service.ReadyFile); err != nil {
+			return err
+		}
+		return removeServiceFile(cfg.Get().Service.PIDFile)
+	}
+	return sdnotify.Notify(sdnotify.Stopping)
+}
+
+// removeServiceFile removes a pid or ready file, a file that doesn't exist is
+// not an error.
+func removeServiceFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/sdnotify"
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+var (
+	// procRoot is the procfs mount point, replaceable by unit tests.
+	procRoot = "/proc"
+	// openrcRunDir is created by OpenRC when it boots the system, replaceable by
+	// unit tests.
+	openrcRunDir = "/run/openrc"
+)
+
+// detectServiceManager probes the environment for the service manager running
+// the agent. The notification socket is only set for Type=notify units, so
+// systemd is also detected by the agent's cgroup and its parent process.
+func detectServiceManager() string {
+	if sdnotify.Enabled() {
+		return serviceManagerSystemd
+	}
+
+	// systemd runs each service in its own <unit>.service cgroup.
+	if data, err := os.ReadFile(filepath.Join(procRoot, "self", "cgroup")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.SplitN(line, ":", 3)
+			if len(fields) == 3 && strings.HasSuffix(strings.TrimSpace(fields[2]), ".service") {
+				return serviceManagerSystemd
+			}
+		}
+	}
+
+	// Services are children of the init process, check which one it is.
+	if parentPID() == "1" {
+		if comm, err := os.ReadFile(filepath.Join(procRoot, "1", "comm")); err == nil && strings.TrimSpace(string(comm)) == "systemd" {
+			return serviceManagerSystemd
+		}
+	}
+
+	if utils.FileExists(openrcRunDir, utils.TypeDir) {
+		return serviceManagerOpenRC
+	}
+
+	// Init scripts daemonize the agent, reparenting it to init.
+	if parentPID() == "1" {
+		return serviceManagerSysV
+	}
+
+	return serviceManagerNone
+}
+
+// parentPID returns the parent process id read from procfs, or an empty string
+// if it can't be read.
+func parentPID() string {
+	data, err := os.ReadFile(filepath.Join(procRoot, "self", "stat"))
+	if err != nil {
+		return ""
+	}
+
+	// The command name is in parenthesis and may contain spaces, the parent pid is
+	// the second field after it.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+func TestDetectServiceManager(t *testing.T) {
+	var tests = []struct {
+		name   string
+		cgroup string
+		stat   string
+		init   string
+		openrc bool
+		want   string
+	}{
+		{"systemd cgroup v2", "0::/system.slice/google-guest-agent.service\n", "100 (google_guest_agent) S 1 100", "systemd", false, serviceManagerSystemd},
+		{"systemd cgroup v1", "12:pids:/system.slice/google-guest-agent.service\n1:name=systemd:/system.slice/google-guest-agent.service\n", "100 (agent) S 1 100", "systemd", false, serviceManagerSystemd},
+		{"systemd parent", "0::/\n", "100 (google guest agent) S 1 100", "systemd", false, serviceManagerSystemd},
+		{"started from a shell", "0::/user.slice/session-1.scope\n", "100 (agent) S 4242 100", "systemd", false, serviceManagerNone},
+		{"openrc", "0::/google-guest-agent\n", "100 (agent) S 1 100", "init", true, serviceManagerOpenRC},
+		{"sysvinit", "0::/\n", "100 (agent) S 1 100", "init", false, serviceManagerSysV},
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			oldProcRoot, oldOpenrcRunDir := procRoot, openrcRunDir
+			t.Cleanup(func() { procRoot, openrcRunDir = oldProcRoot, oldOpenrcRunDir })
+			procRoot = filepath.Join(root, "proc")
+			openrcRunDir = filepath.Join(root, "run", "openrc")
+
+			files := map[string]string{
+				filepath.Join(procRoot, "self", "cgroup"): tt.cgroup,
+				filepath.Join(procRoot, "self", "stat"):   tt.stat,
+				filepath.Join(procRoot, "1", "comm"):      tt.init + "\n",
+			}
+			for path, content := range files {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("os.MkdirAll(%q) failed: %v", filepath.Dir(path), err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatalf("os.WriteFile(%q) failed: %v", path, err)
+				}
+			}
+
+			if tt.openrc {
+				if err := os.MkdirAll(openrcRunDir, 0755); err != nil {
+					t.Fatalf("os.MkdirAll(%q) failed: %v", openrcRunDir, err)
+				}
+			}
+
+			if got := detectServiceManager(); got != tt.want {
+				t.Errorf("detectServiceManager() = %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectServiceManagerNotifySocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "@test-socket")
+
+	oldProcRoot := procRoot
+	t.Cleanup(func() { procRoot = oldProcRoot })
+	procRoot = t.TempDir()
+
+	if got := detectServiceManager(); got != serviceManagerSystemd {
+		t.Errorf("detectServiceManager() = %q, want: %q", got, serviceManagerSystemd)
+	}
+}
+
+func TestInitScriptNotify(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "agent.pid")
+	readyFile := filepath.Join(dir, "agent.ready")
+	reloadConfig(t, []byte(fmt.Sprintf("[Service]\npid_file = %s\nready_file = %s\n", pidFile, readyFile)))
+
+	oldServiceManager := serviceManager
+	t.Cleanup(func() { serviceManager = oldServiceManager })
+	serviceManager = serviceManagerOpenRC
+
+	if err := notifyStarted(); err != nil {
+		t.Fatalf("notifyStarted() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to read pid file: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Pid file contains %q, want: %d", got, os.Getpid())
+	}
+
+	if err := notifyReady(); err != nil {
+		t.Fatalf("notifyReady() failed: %v", err)
+	}
+	if !utils.FileExists(readyFile, utils.TypeFile) {
+		t.Errorf("notifyReady() didn't create the ready file %q", readyFile)
+	}
+
+	if err := notifyReloading(); err != nil {
+		t.Fatalf("notifyReloading() failed: %v", err)
+	}
+	if utils.FileExists(readyFile, utils.TypeFile) {
+		t.Errorf("notifyReloading() didn't remove the ready file %q", readyFile)
+	}
+
+	if err := notifyReady(); err != nil {
+		t.Fatalf("notifyReady() failed: %v", err)
+	}
+	if err := notifyStopping(); err != nil {
+		t.Fatalf("notifyStopping() failed: %v", err)
+	}
+	for _, f := range []string{pidFile, readyFile} {
+		if utils.FileExists(f, utils.TypeFile) {
+			t.Errorf("notifyStopping() didn't remove %q", f)
+		}
+	}
+}
@@ -39,17 +39,22 @@ type TrustedCert struct {
 var (
 	// mdsClient is the metadata's client, used to query oslogin certificates.
 	mdsClient *metadata.Client
+	// subscription is the subscription of the event handler callback.
+	subscription *events.Subscription
 )
 
 // Init initializes the sshca's event handler callback.
 func Init() {
 	mdsClient = metadata.New()
-	events.Get().Subscribe(sshtrustedca.ReadEvent, nil, writeFile)
+	subscription = events.Get().Subscribe(sshtrustedca.ReadEvent, nil, writeFile)
 }
 
 // Close finishes the sshca module, deallocating everything allocated with Init().
 func Close() {
-	events.Get().Unsubscribe(sshtrustedca.ReadEvent, writeFile)
+	if subscription != nil {
+		subscription.Unsubscribe()
+		subscription = nil
+	}
 	mdsClient = nil
 }
 
@@ -0,0 +1,210 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// lastStatus is the last status text reported to the service manager.
+	lastStatus string
+
+	// agentStatus is the agent wide status text, i.e. what the agent is doing.
+	agentStatus string
+
+	// subsystemStatus maps subsystem names to their last reported status text.
+	subsystemStatus = make(map[string]string)
+
+	// statusMutex protects the status texts and serializes the status reports.
+	statusMutex sync.Mutex
+
+	// degradedWatchers is the set of event types whose watcher keeps failing.
+	degradedWatchers = make(map[string]bool)
+
+	// stuckManagers is the set of managers whose run didn't complete in time.
+	stuckManagers = make(map[string]bool)
+
+	// statusStopped is true once the agent stopped, the status is no longer reported.
+	statusStopped bool
+
+	// setServiceStatus is the OS specific status reporting implementation,
+	// replaceable by unit tests. It's called, and must be replaced, while
+	// holding statusMutex.
+	setServiceStatus = defaultSetServiceStatus
+
+	// restoreServiceStatus is the OS specific implementation restoring the status
+	// reported before the agent started, see setServiceStatus.
+	restoreServiceStatus = defaultRestoreServiceStatus
+)
+
+// reportStatus reports a human readable text describing what the agent is
+// currently doing to the service manager, i.e. systemd's STATUS= shown by
+// systemctl status or the Windows service description.
+func reportStatus(ctx context.Context, format string, args ...any) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	agentStatus = fmt.Sprintf(format, args...)
+	flushStatus(ctx)
+}
+
+// reportSubsystemStatus reports a short status text of a subsystem (i.e. the
+// metadata watcher or a scheduled job), it's shown to the service manager along
+// with the agent wide status.
+func reportSubsystemStatus(ctx context.Context, subsystem string, format string, args ...any) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	subsystemStatus[subsystem] = fmt.Sprintf(format, args...)
+	flushStatus(ctx)
+}
+
+// reportWatcherDegraded reports the watchers that keep failing, the agent is
+// degraded while any of them is, and back to ok once they all recover.
+func reportWatcherDegraded(ctx context.Context, evType string, degraded bool) {
+	statusMutex.Lock()
+	if degraded {
+		degradedWatchers[evType] = true
+	} else {
+		delete(degradedWatchers, evType)
+	}
+	evTypes := slices.Sorted(maps.Keys(degradedWatchers))
+	statusMutex.Unlock()
+
+	if len(evTypes) == 0 {
+		reportSubsystemStatus(ctx, "watchers", "ok")
+		return
+	}
+	reportSubsystemStatus(ctx, "watchers", "degraded, failing: %s", strings.Join(evTypes, ", "))
+}
+
+// reportManagerStuck reports the managers whose run didn't complete in time, and
+// back to ok once their runs complete.
+func reportManagerStuck(ctx context.Context, name string, stuck bool) {
+	statusMutex.Lock()
+	if stuck {
+		stuckManagers[name] = true
+	} else {
+		delete(stuckManagers, name)
+	}
+	names := slices.Sorted(maps.Keys(stuckManagers))
+	statusMutex.Unlock()
+
+	if len(names) == 0 {
+		reportSubsystemStatus(ctx, "managers", "ok")
+		return
+	}
+	reportSubsystemStatus(ctx, "managers", "stuck: %s", strings.Join(names, ", "))
+}
+
+// composeStatus joins the agent wide status and the subsystems status, sorted by
+// subsystem name, in a single line. statusMutex must be held by the caller.
+func composeStatus() string {
+	var parts []string
+	if agentStatus != "" {
+		parts = append(parts, agentStatus)
+	}
+
+	subsystems := make([]string, 0, len(subsystemStatus))
+	for name := range subsystemStatus {
+		subsystems = append(subsystems, name)
+	}
+	sort.Strings(subsystems)
+
+	for _, name := range subsystems {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, subsystemStatus[name]))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// flushStatus reports the composed status to the service manager if it changed
+// since the last report. statusMutex must be held by the caller.
+func flushStatus(ctx context.Context) {
+	status := composeStatus()
+	if statusStopped || status == lastStatus {
+		return
+	}
+
+	if err := setServiceStatus(ctx, status); err != nil {
+		logger.Debugf("Failed to report status %q to the service manager: %v", status, err)
+		return
+	}
+	lastStatus = status
+}
+
+// restoreStatus restores the status reported before the agent started, i.e. the
+// static Windows service description, once the agent stopped. The later reports are
+// ignored.
+func restoreStatus(ctx context.Context) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	statusStopped = true
+	if err := restoreServiceStatus(ctx); err != nil {
+		logger.Debugf("Failed to restore the service manager's status: %v", err)
+	}
+}
+
+// dumpState returns a human readable dump of the agent's state, i.e. its status,
+// the managers' status and the metadata client metrics.
+func dumpState(ctx context.Context) string {
+	var res strings.Builder
+	fmt.Fprintf(&res, "version: %s\n", version)
+
+	statusMutex.Lock()
+	fmt.Fprintf(&res, "status: %s\n", composeStatus())
+	statusMutex.Unlock()
+
+	// The managers status depends on the metadata.
+	_, newMd := metadataSnapshot()
+	for _, m := range managerRegistry {
+		if newMd == nil {
+			break
+		}
+		if !m.configEnabled() {
+			fmt.Fprintf(&res, "manager %s: disabled in the configuration\n", m.name)
+			continue
+		}
+		disabled, err := m.newManager(newMd).Disabled(ctx, newMd)
+		if err != nil {
+			fmt.Fprintf(&res, "manager %s: failed to query status: %v\n", m.name, err)
+			continue
+		}
+		status := "enabled"
+		if disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(&res, "manager %s: %s, last run: %s\n", m.name, status, m.status())
+	}
+
+	if mdsClient != nil {
+		fmt.Fprintf(&res, "metadata: %s\n", mdsClient.Metrics())
+	}
+	fmt.Fprintf(&res, "events: %s\n", events.Get().Metrics())
+	fmt.Fprintf(&res, "jobs: %s\n", strings.Join(scheduler.Get().ScheduledJobs(), ", "))
+	return res.String()
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/events"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/scheduler"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeServiceStatus resets the reported status and replaces the status reporting
+// implementation with report, the default one is restored once the test is done.
+// The status is reset while holding statusMutex, go routines of managers given up
+// by a previous test may still report.
+func fakeServiceStatus(t *testing.T, report func(ctx context.Context, status string) error) {
+	t.Helper()
+	reset := func(report func(ctx context.Context, status string) error) {
+		statusMutex.Lock()
+		defer statusMutex.Unlock()
+		setServiceStatus = report
+		statusStopped = false
+		lastStatus, agentStatus = "", ""
+		subsystemStatus = make(map[string]string)
+		degradedWatchers = make(map[string]bool)
+		stuckManagers = make(map[string]bool)
+	}
+	reset(report)
+	t.Cleanup(func() { reset(defaultSetServiceStatus) })
+}
+
+func TestReportStatus(t *testing.T) {
+	var reported []string
+	fail := false
+
+	fakeServiceStatus(t, func(ctx context.Context, status string) error {
+		if fail {
+			return fmt.Errorf("failed to set status")
+		}
+		reported = append(reported, status)
+		return nil
+	})
+
+	ctx := context.Background()
+	reportStatus(ctx, "applying configuration (%d/%d managers done)", 1, 2)
+	reportStatus(ctx, "applying configuration (%d/%d managers done)", 1, 2)
+	fail = true
+	reportStatus(ctx, "applying configuration (%d/%d managers done)", 2, 2)
+	fail = false
+	reportStatus(ctx, "applying configuration (%d/%d managers done)", 2, 2)
+	reportSubsystemStatus(ctx, "telemetry", "last run at %s", "10:00:00")
+	reportSubsystemStatus(ctx, "metadata", "last update at %s", "10:00:01")
+	reportStatus(ctx, "watching metadata for changes")
+
+	want := []string{
+		"applying configuration (1/2 managers done)",
+		"applying configuration (2/2 managers done)",
+		"applying configuration (2/2 managers done); telemetry: last run at 10:00:00",
+		"applying configuration (2/2 managers done); metadata: last update at 10:00:01; telemetry: last run at 10:00:00",
+		"watching metadata for changes; metadata: last update at 10:00:01; telemetry: last run at 10:00:00",
+	}
+
+	if diff := cmp.Diff(want, reported); diff != "" {
+		t.Errorf("reportStatus() reported unexpected status (-want +got):\n%s", diff)
+	}
+}
+
+func TestDumpState(t *testing.T) {
+	_, oldNewMd := metadataSnapshot()
+	oldClient := mdsClient
+	setNewMetadata(nil)
+	mdsClient = nil
+	t.Cleanup(func() {
+		setNewMetadata(oldNewMd)
+		mdsClient = oldClient
+	})
+	fakeServiceStatus(t, defaultSetServiceStatus)
+	statusMutex.Lock()
+	agentStatus, subsystemStatus = "watching metadata for changes", map[string]string{"metadata": "last update at 10:00:01"}
+	statusMutex.Unlock()
+
+	want := fmt.Sprintf("version: %s\nstatus: watching metadata for changes; metadata: last update at 10:00:01\nevents: %s\njobs: %s\n",
+		version, events.Get().Metrics(), strings.Join(scheduler.Get().ScheduledJobs(), ", "))
+	if diff := cmp.Diff(want, dumpState(context.Background())); diff != "" {
+		t.Errorf("dumpState() returned unexpected state (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportWatcherDegraded(t *testing.T) {
+	var reported []string
+	fakeServiceStatus(t, func(ctx context.Context, status string) error {
+		reported = append(reported, status)
+		return nil
+	})
+
+	ctx := context.Background()
+	reportWatcherDegraded(ctx, "netlink-watcher,link", true)
+	reportWatcherDegraded(ctx, "journal-watcher,oom-kill", true)
+	reportWatcherDegraded(ctx, "netlink-watcher,link", false)
+	reportWatcherDegraded(ctx, "journal-watcher,oom-kill", false)
+
+	want := []string{
+		"watchers: degraded, failing: netlink-watcher,link",
+		"watchers: degraded, failing: journal-watcher,oom-kill, netlink-watcher,link",
+		"watchers: degraded, failing: journal-watcher,oom-kill",
+		"watchers: ok",
+	}
+	if diff := cmp.Diff(want, reported); diff != "" {
+		t.Errorf("reportWatcherDegraded() reported unexpected status (-want +got):\n%s", diff)
+	}
+}
+
+func TestRestoreStatus(t *testing.T) {
+	var reported []string
+	fakeServiceStatus(t, func(ctx context.Context, status string) error {
+		reported = append(reported, status)
+		return nil
+	})
+	restored := false
+	restoreServiceStatus = func(context.Context) error {
+		restored = true
+		return nil
+	}
+	t.Cleanup(func() { restoreServiceStatus = defaultRestoreServiceStatus })
+
+	ctx := context.Background()
+	reportStatus(ctx, "configuration applied, watching metadata for changes")
+	restoreStatus(ctx)
+	reportStatus(ctx, "paused")
+
+	if !restored {
+		t.Errorf("restoreStatus() didn't restore the service manager's status")
+	}
+	want := []string{"configuration applied, watching metadata for changes"}
+	if diff := cmp.Diff(want, reported); diff != "" {
+		t.Errorf("reportStatus() reported unexpected status after restoreStatus() (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/sdnotify"
+)
+
+// defaultSetServiceStatus sets systemd's STATUS= of the agent's unit. It's a no-op
+// if the agent was not started by systemd.
+func defaultSetServiceStatus(ctx context.Context, status string) error {
+	return sdnotify.Notify(sdnotify.Status(status))
+}
+
+// defaultRestoreServiceStatus is a no-op, systemd drops the STATUS= of a stopped unit.
+func defaultRestoreServiceStatus(ctx context.Context) error {
+	return nil
+}
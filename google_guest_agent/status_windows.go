@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	// serviceName is the agent's Windows service name.
+	serviceName = "GCEAgent"
+	// serviceDescription is the static part of the agent's service description.
+	serviceDescription = "Google Compute Engine Guest Agent"
+	// serviceDescriptionInterval is the minimum interval between two writes of the
+	// service description, it's stored in the registry.
+	serviceDescriptionInterval = 30 * time.Second
+)
+
+var (
+	// lastDescriptionUpdate is when the service description was last written.
+	lastDescriptionUpdate time.Time
+	// pendingStatus is the status waiting for descriptionTimer to be written.
+	pendingStatus string
+	// descriptionTimer writes pendingStatus once serviceDescriptionInterval elapsed,
+	// nil if no write is pending.
+	descriptionTimer *time.Timer
+
+	// updateServiceDescription writes the agent's service description, it's a
+	// variable to be replaced in tests.
+	updateServiceDescription = defaultUpdateServiceDescription
+)
+
+// defaultSetServiceStatus sets the agent's Windows service description to
+// include status, the description is shown by the services console and sc qdescription.
+// The writes are rate limited, a status reported less than serviceDescriptionInterval
+// after the last write is written once the interval elapsed, unless a newer status
+// replaced it. It's called while holding statusMutex, which also protects the
+// variables above.
+func defaultSetServiceStatus(ctx context.Context, status string) error {
+	if wait := serviceDescriptionInterval - time.Since(lastDescriptionUpdate); wait > 0 {
+		pendingStatus = status
+		if descriptionTimer == nil {
+			descriptionTimer = time.AfterFunc(wait, writePendingStatus)
+		}
+		return nil
+	}
+
+	lastDescriptionUpdate = time.Now()
+	return updateServiceDescription(fmt.Sprintf("%s: %s", serviceDescription, status))
+}
+
+// writePendingStatus writes the status left pending by defaultSetServiceStatus.
+func writePendingStatus() {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	// The status was restored meanwhile.
+	if descriptionTimer == nil {
+		return
+	}
+	descriptionTimer = nil
+
+	lastDescriptionUpdate = time.Now()
+	if err := updateServiceDescription(fmt.Sprintf("%s: %s", serviceDescription, pendingStatus)); err != nil {
+		logger.Debugf("Failed to report status %q to the service manager: %v", pendingStatus, err)
+	}
+}
+
+// defaultRestoreServiceStatus restores the agent's static Windows service
+// description, the pending status is dropped.
+func defaultRestoreServiceStatus(ctx context.Context) error {
+	if descriptionTimer != nil {
+		descriptionTimer.Stop()
+		descriptionTimer = nil
+	}
+	return updateServiceDescription(serviceDescription)
+}
+
+// defaultUpdateServiceDescription sets the agent's Windows service description to
+// description, unless it's already set.
+func defaultUpdateServiceDescription(description string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	config, err := s.Config()
+	if err != nil {
+		return fmt.Errorf("failed to query service %s config: %w", serviceName, err)
+	}
+
+	if config.Description == description {
+		return nil
+	}
+	config.Description = description
+	return s.UpdateConfig(config)
+}
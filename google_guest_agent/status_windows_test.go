@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServiceDescriptionRateLimit(t *testing.T) {
+	var written []string
+	statusMutex.Lock()
+	updateServiceDescription = func(description string) error {
+		written = append(written, description)
+		return nil
+	}
+	lastDescriptionUpdate = time.Time{}
+	statusMutex.Unlock()
+	t.Cleanup(func() {
+		statusMutex.Lock()
+		defer statusMutex.Unlock()
+		if descriptionTimer != nil {
+			descriptionTimer.Stop()
+			descriptionTimer = nil
+		}
+		updateServiceDescription = defaultUpdateServiceDescription
+	})
+
+	ctx := context.Background()
+	statusMutex.Lock()
+	for _, status := range []string{"initializing instance", "applying configuration (1/2 managers done)", "applying configuration (2/2 managers done)"} {
+		if err := defaultSetServiceStatus(ctx, status); err != nil {
+			t.Errorf("defaultSetServiceStatus(%q) = %v, want nil", status, err)
+		}
+	}
+	if descriptionTimer == nil {
+		t.Errorf("defaultSetServiceStatus() didn't schedule the pending status write")
+	} else {
+		descriptionTimer.Stop()
+	}
+	statusMutex.Unlock()
+
+	// The interval elapsed, the last status is written.
+	writePendingStatus()
+
+	statusMutex.Lock()
+	if err := defaultRestoreServiceStatus(ctx); err != nil {
+		t.Errorf("defaultRestoreServiceStatus() = %v, want nil", err)
+	}
+	statusMutex.Unlock()
+
+	want := []string{
+		serviceDescription + ": initializing instance",
+		serviceDescription + ": applying configuration (2/2 managers done)",
+		serviceDescription,
+	}
+	if diff := cmp.Diff(want, written); diff != "" {
+		t.Errorf("service description writes differ (-want +got):\n%s", diff)
+	}
+}
@@ -28,6 +28,18 @@ func resetPwd(username, pwd string) error {
 	return nil
 }
 
+func getPasswordPolicy(ctx context.Context) (winPasswordPolicy, error) {
+	return winPasswordPolicy{}, nil
+}
+
+func isDomainJoined() (bool, error) {
+	return false, nil
+}
+
+func writeAuditEvent(msg string, failed bool) error {
+	return nil
+}
+
 func readRegMultiString(key, name string) ([]string, error) {
 	return nil, nil
 }
@@ -17,6 +17,7 @@ package telemetry
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"runtime"
 	"time"
 
@@ -95,11 +96,23 @@ func Record(ctx context.Context, client metadata.MDSClientInterface, d Data) err
 	return err
 }
 
+// metricsClient is implemented by the metadata clients recording request metrics.
+type metricsClient interface {
+	Metrics() metadata.Metrics
+}
+
+// metricsSource is a subsystem whose metrics are logged along with the telemetry.
+type metricsSource struct {
+	name    string
+	metrics func() fmt.Stringer
+}
+
 // Job implements job scheduler interface for recording telemetry.
 type Job struct {
 	client       metadata.MDSClientInterface
 	programName  string
 	agentVersion string
+	sources      []metricsSource
 }
 
 // New initializes a new TelemetryJob.
@@ -111,6 +124,12 @@ func New(client metadata.MDSClientInterface, programName, agentVersion string) *
 	}
 }
 
+// AddMetrics adds a subsystem whose metrics summary, returned by metrics, is logged
+// whenever the telemetry is recorded, i.e. the events manager metrics.
+func (j *Job) AddMetrics(name string, metrics func() fmt.Stringer) {
+	j.sources = append(j.sources, metricsSource{name: name, metrics: metrics})
+}
+
 // ID returns the ID for this job.
 func (j *Job) ID() string {
 	return telemetryJobID
@@ -135,6 +154,14 @@ func (j *Job) Run(ctx context.Context) (bool, error) {
 		logger.Debugf("Error recording telemetry: %v", err)
 	}
 
+	if client, ok := j.client.(metricsClient); ok {
+		logger.Infof("Metadata client metrics: %s", client.Metrics())
+	}
+
+	for _, source := range j.sources {
+		logger.Infof("%s metrics: %s", source.name, source.metrics())
+	}
+
 	return j.ShouldEnable(ctx), nil
 }
 
@@ -0,0 +1,228 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/features"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// mdsConnectivityTimeout is how long the metadata server is given to answer each
+	// connectivity check after a network change set was applied.
+	mdsConnectivityTimeout = 10 * time.Second
+	// rollbackInitialBackoff is how long the rolled back changes wait before being
+	// applied again, it doubles with each consecutive rollback of the same changes.
+	rollbackInitialBackoff = time.Minute
+	// rollbackMaxBackoff caps the wait of the rolled back changes.
+	rollbackMaxBackoff = 30 * time.Minute
+	// rollbackFeature is the feature flag turning the rollback off across a fleet
+	// regardless of rollback_changes.
+	rollbackFeature = "network-rollback"
+)
+
+var (
+	// rollbackMu protects lastRollback.
+	rollbackMu sync.Mutex
+	// lastRollback is the last rolled back change set, the zero value if the last
+	// changes were verified.
+	lastRollback rollbackState
+
+	// mdsConnectivityPolicy is the retry policy of the connectivity check, a single
+	// failed request doesn't roll the changes back.
+	mdsConnectivityPolicy = retry.Policy{MaxAttempts: 3, BackoffFactor: 2, Jitter: 5 * time.Second}
+
+	// checkMDSConnectivity returns an error if the metadata server can't be reached,
+	// it's a variable to be replaced in tests.
+	checkMDSConnectivity = func(ctx context.Context) error {
+		// Not initialized, i.e. when testing.
+		if mdsClient == nil {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, mdsConnectivityTimeout)
+		defer cancel()
+		_, err := mdsClient.GetKey(ctx, "instance/id", nil)
+		return err
+	}
+)
+
+func init() {
+	// The rollback is opted in with rollback_changes, the flag only allows turning it
+	// off without a new release.
+	features.Register(rollbackFeature, true)
+}
+
+// rollbackState is a rolled back change set.
+type rollbackState struct {
+	// nics are the network interfaces whose changes were rolled back.
+	nics []metadata.NetworkInterfaces
+	// count is the number of consecutive rollbacks of nics.
+	count int
+	// until is when the changes of nics may be applied again.
+	until time.Time
+}
+
+// rollbackEnabled returns true if the network changes of md are verified and rolled
+// back, see rollback_changes.
+func rollbackEnabled(config *cfg.Sections, md *metadata.Descriptor) bool {
+	return config.NetworkInterfaces.RollbackChanges && features.Enabled(md, rollbackFeature)
+}
+
+// mdsReachable returns an error if the metadata server still can't be reached after
+// retrying the connectivity check.
+func mdsReachable(ctx context.Context) error {
+	return retry.Run(ctx, mdsConnectivityPolicy, func() error { return checkMDSConnectivity(ctx) })
+}
+
+// networkState is the network state changed by the addressMgr, snapshot before a
+// change set is applied to roll it back.
+type networkState struct {
+	// mtus are the interfaces' MTU by name.
+	mtus map[string]int
+	// forwardedIPs are the IPs forwarded by the agent by NIC MAC address.
+	forwardedIPs map[string][]string
+}
+
+// snapshotNetworkState returns the interfaces' MTU and the IPs forwarded by the agent
+// on md's NICs.
+func (a *addressMgr) snapshotNetworkState(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) networkState {
+	state := networkState{mtus: make(map[string]int), forwardedIPs: make(map[string][]string)}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Errorf("Failed to list the network interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		state.mtus[iface.Name] = iface.MTU
+	}
+
+	if !config.NetworkInterfaces.IPForwarding {
+		return state
+	}
+	for _, ni := range a.forwardedInterfaces(config, md) {
+		r, err := nicForwardedIPs(ctx, config, ni)
+		if err != nil {
+			continue
+		}
+		state.forwardedIPs[ni.Mac] = slices.Sorted(slices.Values(r.forwardedIPs))
+	}
+	return state
+}
+
+// equal returns true if s and other are the same state.
+func (s networkState) equal(other networkState) bool {
+	return maps.Equal(s.mtus, other.mtus) && maps.EqualFunc(s.forwardedIPs, other.forwardedIPs, slices.Equal[[]string])
+}
+
+// restoreNetworkState restores the MTUs and the forwarded IPs of before, md is the
+// metadata whose changes are rolled back.
+func (a *addressMgr) restoreNetworkState(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor, before networkState) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Errorf("Failed to list the network interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if mtu, ok := before.mtus[iface.Name]; ok && mtu != iface.MTU {
+			if err := setInterfaceMTU(ctx, iface, mtu); err != nil {
+				logger.Errorf("Failed to restore the MTU of %s: %v", iface.Name, err)
+			}
+		}
+	}
+
+	if !config.NetworkInterfaces.IPForwarding {
+		return
+	}
+	// The forwarded IPs are re-applied as if they were the metadata ones.
+	var nics []metadata.NetworkInterfaces
+	for _, ni := range md.Instance.NetworkInterfaces {
+		nics = append(nics, metadata.NetworkInterfaces{Mac: ni.Mac, ForwardedIps: before.forwardedIPs[ni.Mac]})
+	}
+	a.applyForwardedIPs(ctx, config, withNetworkInterfaces(md, nics))
+}
+
+// verifyNetworkChanges checks the metadata server can still be reached after md's
+// changes were applied, they are rolled back otherwise. before is the state before
+// the changes were applied. The rolled back changes are applied again once their
+// backoff expires, by the failed manager's retry or the drift reconciliation.
+func (a *addressMgr) verifyNetworkChanges(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor, before networkState) error {
+	if a.snapshotNetworkState(ctx, config, md).equal(before) {
+		return nil
+	}
+
+	err := mdsReachable(ctx)
+	if err == nil {
+		clearRollback()
+		return nil
+	}
+
+	delay := recordRollback(md.Instance.NetworkInterfaces)
+	logger.Errorf("Lost the metadata server connectivity after applying the network changes, rolling them back and retrying in %s: %v", delay, err)
+	a.restoreNetworkState(ctx, config, md, before)
+
+	if err := mdsReachable(ctx); err != nil {
+		logger.Errorf("The metadata server still can't be reached after the rollback: %v", err)
+	}
+	return fmt.Errorf("rolled back the network changes cutting off the metadata server: %w", err)
+}
+
+// recordRollback records the rollback of nics' changes, it returns how long they
+// wait before being applied again.
+func recordRollback(nics []metadata.NetworkInterfaces) time.Duration {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+
+	count := 1
+	if reflect.DeepEqual(lastRollback.nics, nics) {
+		count = lastRollback.count + 1
+	}
+	delay := rollbackMaxBackoff
+	// Avoid overflowing the shifted delay.
+	if count < 32 {
+		delay = min(rollbackInitialBackoff<<(count-1), rollbackMaxBackoff)
+	}
+	lastRollback = rollbackState{nics: nics, count: count, until: time.Now().Add(delay)}
+	return delay
+}
+
+// clearRollback forgets the last rollback, the changes were verified.
+func clearRollback() {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+	lastRollback = rollbackState{}
+}
+
+// rollbackBackoff returns how long the rolled back changes of md's network interfaces
+// still wait before being applied again, zero if they weren't rolled back or their
+// backoff expired.
+func rollbackBackoff(md *metadata.Descriptor) time.Duration {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+	if lastRollback.nics == nil || !reflect.DeepEqual(lastRollback.nics, md.Instance.NetworkInterfaces) {
+		return 0
+	}
+	return max(time.Until(lastRollback.until), 0)
+}
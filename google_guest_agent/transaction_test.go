@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+func TestNetworkStateEqual(t *testing.T) {
+	base := networkState{
+		mtus:         map[string]int{"eth0": 1460},
+		forwardedIPs: map[string][]string{"00:00:5e:00:53:01": {"10.0.0.1"}},
+	}
+
+	tests := []struct {
+		name  string
+		other networkState
+		want  bool
+	}{
+		{
+			name:  "same",
+			other: networkState{mtus: map[string]int{"eth0": 1460}, forwardedIPs: map[string][]string{"00:00:5e:00:53:01": {"10.0.0.1"}}},
+			want:  true,
+		},
+		{
+			name:  "mtu_changed",
+			other: networkState{mtus: map[string]int{"eth0": 8896}, forwardedIPs: map[string][]string{"00:00:5e:00:53:01": {"10.0.0.1"}}},
+		},
+		{
+			name:  "forwarded_ip_added",
+			other: networkState{mtus: map[string]int{"eth0": 1460}, forwardedIPs: map[string][]string{"00:00:5e:00:53:01": {"10.0.0.1", "10.0.0.2"}}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := base.equal(tc.other); got != tc.want {
+				t.Errorf("equal(%+v) = %t, want %t", tc.other, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerifyNetworkChanges(t *testing.T) {
+	if err := cfg.Load([]byte("[NetworkInterfaces]\nip_forwarding = false\nrollback_changes = true")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+	t.Cleanup(clearRollback)
+
+	origCheck, origPolicy := checkMDSConnectivity, mdsConnectivityPolicy
+	t.Cleanup(func() { checkMDSConnectivity, mdsConnectivityPolicy = origCheck, origPolicy })
+	mdsConnectivityPolicy = retry.Policy{MaxAttempts: 3, BackoffFactor: 1, Jitter: time.Millisecond}
+
+	ctx := context.Background()
+	a := &addressMgr{}
+	md := &metadata.Descriptor{}
+	md.Instance.NetworkInterfaces = []metadata.NetworkInterfaces{{Mac: "00:00:5e:00:53:01", MTU: 1460}}
+
+	// No change, the connectivity isn't checked.
+	checkMDSConnectivity = func(context.Context) error {
+		t.Errorf("checkMDSConnectivity() called without changes")
+		return nil
+	}
+	if err := a.verifyNetworkChanges(ctx, cfg.Get(), md, a.snapshotNetworkState(ctx, cfg.Get(), md)); err != nil {
+		t.Errorf("verifyNetworkChanges() without changes = %v, want nil", err)
+	}
+
+	// A state differing from the current one, with an interface gone.
+	before := networkState{mtus: map[string]int{"gce-test0": 1460}}
+
+	// A transient failure doesn't roll the changes back.
+	var checks int
+	checkMDSConnectivity = func(context.Context) error {
+		checks++
+		if checks == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	}
+	if err := a.verifyNetworkChanges(ctx, cfg.Get(), md, before); err != nil {
+		t.Errorf("verifyNetworkChanges() with a transient failure = %v, want nil", err)
+	}
+	if got := rollbackBackoff(md); got != 0 {
+		t.Errorf("rollbackBackoff() = %s with connectivity, want 0", got)
+	}
+
+	checkMDSConnectivity = func(context.Context) error { return errors.New("unreachable") }
+	if err := a.verifyNetworkChanges(ctx, cfg.Get(), md, before); err == nil {
+		t.Errorf("verifyNetworkChanges() without connectivity = nil, want error")
+	}
+	if got := rollbackBackoff(md); got <= 0 || got > rollbackInitialBackoff {
+		t.Errorf("rollbackBackoff() = %s after the rollback, want (0, %s]", got, rollbackInitialBackoff)
+	}
+	_, origMd := metadataSnapshot()
+	t.Cleanup(func() { setNewMetadata(origMd) })
+	setNewMetadata(md)
+	if got := a.reapplyMetadata(ctx); got != nil {
+		t.Errorf("reapplyMetadata() = %+v after the rollback, want nil", got)
+	}
+	if err := a.Set(ctx, nil, md); err == nil {
+		t.Errorf("Set() = nil during the rollback backoff, want error")
+	}
+
+	// The next consecutive rollback waits longer.
+	if err := a.verifyNetworkChanges(ctx, cfg.Get(), md, before); err == nil {
+		t.Errorf("verifyNetworkChanges() without connectivity = nil, want error")
+	}
+	if got := rollbackBackoff(md); got <= rollbackInitialBackoff {
+		t.Errorf("rollbackBackoff() = %s after a second rollback, want > %s", got, rollbackInitialBackoff)
+	}
+
+	// Changed network interfaces are applied right away.
+	changed := &metadata.Descriptor{}
+	changed.Instance.NetworkInterfaces = []metadata.NetworkInterfaces{{Mac: "00:00:5e:00:53:01", MTU: 1500}}
+	if got := rollbackBackoff(changed); got != 0 {
+		t.Errorf("rollbackBackoff() = %s for changed network interfaces, want 0", got)
+	}
+	setNewMetadata(changed)
+	if got := a.reapplyMetadata(ctx); got != changed {
+		t.Errorf("reapplyMetadata() = %+v for changed network interfaces, want %+v", got, changed)
+	}
+
+	// The rolled back changes are applied again once the backoff expired.
+	rollbackMu.Lock()
+	lastRollback.until = time.Now().Add(-time.Second)
+	rollbackMu.Unlock()
+	setNewMetadata(md)
+	if got := a.reapplyMetadata(ctx); got != md {
+		t.Errorf("reapplyMetadata() = %+v after the backoff, want %+v", got, md)
+	}
+
+	// The rollback can be turned off with its feature flag.
+	recordRollback(md.Instance.NetworkInterfaces)
+	if err := cfg.Load([]byte("[NetworkInterfaces]\nip_forwarding = false\nrollback_changes = true\n[Features]\nnetwork-rollback = false")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+	if rollbackEnabled(cfg.Get(), md) {
+		t.Errorf("rollbackEnabled() = true with the feature flag off, want false")
+	}
+	if got := a.reapplyMetadata(ctx); got != md {
+		t.Errorf("reapplyMetadata() = %+v with the feature flag off, want %+v", got, md)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	network "github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/network/manager"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// unsupportedVlans are the MAC addresses of the vlans without an adapter already
+	// logged.
+	unsupportedVlans []string
+)
+
+// setupVlanAdapters configures the vlan adapters of md's vlan interfaces on Windows,
+// their MTU, addresses and the route to their gateway. Windows has no native 802.1Q
+// sub-interfaces, the vlans are only configured if the NIC's driver exposes them as
+// adapters with the vlan's MAC address. On Linux the vlan interfaces are created by
+// the network manager, see network.SetupInterfaces().
+func setupVlanAdapters(ctx context.Context, config *cfg.Sections, md *metadata.Descriptor) {
+	if !config.NetworkInterfaces.VlanSetupEnabled {
+		return
+	}
+
+	parents := slices.Sorted(maps.Keys(md.Instance.VlanNetworkInterfaces))
+	for _, parent := range parents {
+		if parent >= len(md.Instance.NetworkInterfaces) {
+			logger.Errorf("Invalid vlan parent index %d, known interfaces count: %d", parent, len(md.Instance.NetworkInterfaces))
+			continue
+		}
+		parentMac := md.Instance.NetworkInterfaces[parent].Mac
+
+		vlans := md.Instance.VlanNetworkInterfaces[parent]
+		ids := slices.Sorted(maps.Keys(vlans))
+		for _, id := range ids {
+			vlan := vlans[id]
+			iface, err := network.GetInterfaceByMAC(vlan.Mac)
+			if err != nil || vlan.Mac == parentMac {
+				if !slices.Contains(unsupportedVlans, vlan.Mac) {
+					logger.Warningf("No adapter for vlan %d of NIC %d (%s), its driver doesn't expose vlan sub-interfaces.", vlan.Vlan, parent, vlan.Mac)
+					unsupportedVlans = append(unsupportedVlans, vlan.Mac)
+				}
+				continue
+			}
+
+			if err := setupVlanAdapter(ctx, iface, vlan); err != nil {
+				logger.Errorf("Failed to set up vlan %d adapter %s: %v", vlan.Vlan, iface.Name, err)
+			}
+		}
+	}
+}
+
+// setupVlanAdapter sets the MTU, the addresses missing and the route to the gateway
+// of vlan's adapter iface.
+func setupVlanAdapter(ctx context.Context, iface net.Interface, vlan metadata.VlanInterface) error {
+	if err := setInterfaceMTU(ctx, iface, vlan.MTU); err != nil {
+		return fmt.Errorf("failed to set MTU: %w", err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return fmt.Errorf("failed to get addresses: %w", err)
+	}
+	var configured []string
+	for _, addr := range addrs {
+		configured = append(configured, addr.String())
+	}
+
+	for _, addr := range missingVlanAddresses(vlan, configured) {
+		logger.Infof("Adding address %s to vlan %d adapter %s.", addr, vlan.Vlan, iface.Name)
+		ip, ipnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return err
+		}
+		if !isIPv6(ip) {
+			err = addAddress(ip, ipnet.Mask, uint32(iface.Index))
+		} else {
+			err = addIpv6Address(addr, uint32(iface.Index))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add address %s: %w", addr, err)
+		}
+	}
+
+	gateway := net.ParseIP(vlan.Gateway).To4()
+	fes, err := getIPForwardEntries()
+	if err != nil {
+		return fmt.Errorf("failed to get routes: %w", err)
+	}
+
+	var routed bool
+	for _, fe := range fes {
+		if fe.ipForwardIfIndex != int32(iface.Index) {
+			continue
+		}
+		if gateway != nil && fe.ipForwardDest.Equal(gateway) {
+			routed = true
+			continue
+		}
+		// Only the previous gateways' routes added by the agent are removed.
+		if isStaleVlanGatewayRoute(fe) {
+			logger.Infof("Removing route to former gateway %s on vlan %d adapter %s.", fe.ipForwardDest, vlan.Vlan, iface.Name)
+			if err := deleteIPForwardEntry(fe); err != nil {
+				return fmt.Errorf("failed to remove route to %s: %w", fe.ipForwardDest, err)
+			}
+		}
+	}
+	if gateway == nil || routed {
+		return nil
+	}
+
+	logger.Infof("Adding route to gateway %s on vlan %d adapter %s.", gateway, vlan.Vlan, iface.Name)
+	return addIPForwardEntry(vlanGatewayRoute(gateway, iface.Index))
+}
+
+// vlanGatewayRoute returns the on-link route to gateway on the adapter ifindex.
+func vlanGatewayRoute(gateway net.IP, ifindex int) ipForwardEntry {
+	return ipForwardEntry{
+		ipForwardDest:    gateway,
+		ipForwardMask:    net.IPv4Mask(255, 255, 255, 255),
+		ipForwardNextHop: net.ParseIP("0.0.0.0"),
+		ipForwardIfIndex: int32(ifindex),
+		ipForwardMetric1: agentRouteMetric,
+	}
+}
+
+// isStaleVlanGatewayRoute returns true if fe is a gateway route added by the agent,
+// an on-link host route with the agent's metric.
+func isStaleVlanGatewayRoute(fe ipForwardEntry) bool {
+	ones, bits := fe.ipForwardMask.Size()
+	return ones == 32 && bits == 32 && fe.ipForwardNextHop.IsUnspecified() && fe.ipForwardMetric1 == agentRouteMetric
+}
+
+// missingVlanAddresses returns the IPv4 and IPv6 addresses of vlan, in CIDR
+// notation, not in configured.
+func missingVlanAddresses(vlan metadata.VlanInterface, configured []string) []string {
+	var configuredIPs []string
+	for _, addr := range configured {
+		configuredIPs = append(configuredIPs, strings.Split(addr, "/")[0])
+	}
+
+	var res []string
+	for _, addr := range append([]string{vlan.IP}, vlan.IPv6...) {
+		if addr == "" || slices.Contains(configuredIPs, strings.Split(addr, "/")[0]) {
+			continue
+		}
+		res = append(res, hostPrefix(addr))
+	}
+	sort.Strings(res)
+	return res
+}
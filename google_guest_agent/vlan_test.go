@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMissingVlanAddresses(t *testing.T) {
+	tests := []struct {
+		name       string
+		vlan       metadata.VlanInterface
+		configured []string
+		want       []string
+	}{
+		{
+			name: "none_configured",
+			vlan: metadata.VlanInterface{IP: "10.0.0.2", IPv6: []string{"fd00::2/96"}},
+			want: []string{"10.0.0.2/32", "fd00::2/96"},
+		},
+		{
+			name:       "all_configured",
+			vlan:       metadata.VlanInterface{IP: "10.0.0.2", IPv6: []string{"fd00::2"}},
+			configured: []string{"10.0.0.2/24", "fd00::2/64", "fe80::1/64"},
+		},
+		{
+			name:       "ipv6_missing",
+			vlan:       metadata.VlanInterface{IP: "10.0.0.2", IPv6: []string{"fd00::2"}},
+			configured: []string{"10.0.0.2/32"},
+			want:       []string{"fd00::2/128"},
+		},
+		{
+			name: "no_addresses",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := missingVlanAddresses(tc.vlan, tc.configured)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("missingVlanAddresses(%+v, %v) returned unexpected diff (-want +got):\n%s", tc.vlan, tc.configured, diff)
+			}
+		})
+	}
+}
+
+func TestIsStaleVlanGatewayRoute(t *testing.T) {
+	gateway := vlanGatewayRoute(net.ParseIP("10.0.0.1"), 5)
+
+	admin := gateway
+	admin.ipForwardMetric1 = 0
+
+	network := gateway
+	network.ipForwardMask = net.IPv4Mask(255, 255, 255, 0)
+
+	tests := []struct {
+		name string
+		fe   ipForwardEntry
+		want bool
+	}{
+		{name: "agent_gateway", fe: gateway, want: true},
+		{name: "admin_gateway", fe: admin, want: false},
+		{name: "network_route", fe: network, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isStaleVlanGatewayRoute(tc.fe); got != tc.want {
+				t.Errorf("isStaleVlanGatewayRoute(%+v) = %t, want %t", tc.fe, got, tc.want)
+			}
+		})
+	}
+}
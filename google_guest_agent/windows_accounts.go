@@ -17,6 +17,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -26,17 +29,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash"
+	"io"
 	"math/big"
 	"reflect"
 	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf16"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-agent/utils"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/crypto/hkdf"
 )
 
 var (
@@ -46,26 +52,104 @@ var (
 	sshdRegKey    = `SYSTEM\CurrentControlSet\Services\sshd`
 )
 
+const (
+	// minPwdLength and maxPwdLength are the generated passwords' length bounds.
+	minPwdLength = 15
+	maxPwdLength = 255
+	// pwdClasses are the character classes of the generated passwords: lower and
+	// upper case letters, numbers and special characters.
+	pwdClasses = 4
+	// policyComplexityClasses are the character classes required by the Windows
+	// password complexity policy.
+	policyComplexityClasses = 3
+)
+
+// pwdLength returns the length of the generated passwords given the requested
+// one, within minPwdLength and maxPwdLength.
+func pwdLength(userPwLgth int) int {
+	return min(max(userPwLgth, minPwdLength), maxPwdLength)
+}
+
+// pwdComplexity returns the number of character classes the generated passwords
+// contain given the configured one, the Windows complexity requirements are the
+// default.
+func pwdComplexity(classes int) int {
+	if classes <= 0 {
+		return policyComplexityClasses
+	}
+	return min(classes, pwdClasses)
+}
+
+// winPasswordPolicy is the machine's effective password policy.
+type winPasswordPolicy struct {
+	// minLength is the minimum password length.
+	minLength int
+	// complexity is set if the passwords must meet the complexity requirements.
+	complexity bool
+}
+
+// check returns an error if the passwords generated with length and classes don't
+// satisfy the policy.
+func (p winPasswordPolicy) check(length, classes int) error {
+	if length < p.minLength {
+		return fmt.Errorf("the %d characters password is shorter than the password policy's minimum length of %d, set a longer passwordLength in the windows-keys entry or password_length in the [WindowsAccounts] section", length, p.minLength)
+	}
+	if p.complexity && classes < policyComplexityClasses {
+		return fmt.Errorf("the password with %d character classes doesn't meet the password policy's complexity requirements of %d, set password_complexity in the [WindowsAccounts] section to at least %d", classes, policyComplexityClasses, policyComplexityClasses)
+	}
+	return nil
+}
+
+// parsePasswordComplexity returns the PasswordComplexity setting of the security
+// policy exported by secedit, UTF-16 encoded.
+func parsePasswordComplexity(data []byte) bool {
+	if len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe {
+		units := make([]uint16, (len(data)-2)/2)
+		for i := range units {
+			units[i] = uint16(data[2+2*i]) | uint16(data[3+2*i])<<8
+		}
+		data = []byte(string(utf16.Decode(units)))
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "PasswordComplexity") {
+			return strings.TrimSpace(value) == "1"
+		}
+	}
+	return false
+}
+
+// newUserPwd generates a password of at least userPwLgth characters with the
+// configured password settings, checked against the machine's password policy so
+// the policy failures are reported clearly instead of as account errors.
+func newUserPwd(ctx context.Context, userPwLgth int) (string, error) {
+	config := cfg.Get().WindowsAccounts
+	length := pwdLength(max(userPwLgth, config.PasswordLength))
+	classes := pwdComplexity(config.PasswordComplexity)
+
+	policy, err := getPasswordPolicy(ctx)
+	if err != nil {
+		logger.Warningf("Failed to read the password policy, not checking the password settings against it: %v", err)
+	} else if err := policy.check(length, classes); err != nil {
+		return "", err
+	}
+	return newPwd(length, classes)
+}
+
 // newPwd will generate a random password that meets Windows complexity
-// requirements: https://technet.microsoft.com/en-us/library/cc786468.
+// requirements: https://technet.microsoft.com/en-us/library/cc786468, with
+// characters of at least classes of the character classes.
 // Characters that are difficult for users to type on a command line (quotes,
 // non english characters) are not used.
-func newPwd(userPwLgth int) (string, error) {
-	var pwLgth int
-	minPwLgth := 15
-	maxPwLgth := 255
+func newPwd(userPwLgth, classes int) (string, error) {
+	pwLgth := pwdLength(userPwLgth)
+	classes = pwdComplexity(classes)
 	lower := []byte("abcdefghijklmnopqrstuvwxyz")
 	upper := []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	numbers := []byte("0123456789")
 	special := []byte(`~!@#$%^&*_-+=|\(){}[]:;<>,.?/`)
 	chars := bytes.Join([][]byte{lower, upper, numbers, special}, nil)
-	pwLgth = minPwLgth
-	if userPwLgth > minPwLgth {
-		pwLgth = userPwLgth
-	}
-	if userPwLgth > maxPwLgth {
-		pwLgth = maxPwLgth
-	}
 
 	for {
 		b := make([]byte, pwLgth)
@@ -90,9 +174,9 @@ func newPwd(userPwLgth int) (string, error) {
 		if bytes.ContainsAny(special, string(b)) {
 			s = 1
 		}
-		// If the password does not meet Windows complexity requirements, try again.
+		// If the password does not meet the complexity requirements, try again.
 		// https://technet.microsoft.com/en-us/library/cc786468
-		if l+u+n+s >= 3 {
+		if l+u+n+s >= classes {
 			return string(b), nil
 		}
 	}
@@ -106,6 +190,12 @@ type credsJSON struct {
 	Exponent          string `json:"exponent,omitempty"`
 	Modulus           string `json:"modulus,omitempty"`
 	HashFunction      string `json:"hashFunction,omitempty"`
+	// PublicKey and EphemeralPublicKey are the recipient's and the agent's public
+	// keys of the ECIES scheme.
+	PublicKey          string `json:"publicKey,omitempty"`
+	EphemeralPublicKey string `json:"ephemeralPublicKey,omitempty"`
+	// Version is the windows-keys version the password was encrypted with.
+	Version int `json:"version,omitempty"`
 }
 
 func printCreds(creds *credsJSON) error {
@@ -118,55 +208,210 @@ func printCreds(creds *credsJSON) error {
 }
 
 func createOrResetPwd(ctx context.Context, k metadata.WindowsKey) (*credsJSON, error) {
-	pwd, err := newPwd(k.PasswordLength)
+	pwd, err := newUserPwd(ctx, k.PasswordLength)
 	if err != nil {
 		return nil, fmt.Errorf("error creating password: %v", err)
 	}
-	if _, err := userExists(k.UserName); err == nil {
+
+	record := accountAudit{User: k.UserName, Requester: k.Email, Source: auditSourceWindowsKeys, ExpireOn: k.ExpireOn}
+	err = setUserPwd(ctx, k, pwd, &record)
+	auditAccountChange(ctx, record, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return createcredsJSON(k, pwd)
+}
+
+// setUserPwd resets the password of k's user to pwd, creating the user if it
+// doesn't exist, record is updated with the changes made.
+func setUserPwd(ctx context.Context, k metadata.WindowsKey, pwd string, record *accountAudit) error {
+	_, err := userExists(k.UserName)
+	exists := err == nil
+	record.Action = auditActionCreateAccount
+	if exists {
+		record.Action = auditActionResetPassword
+	}
+	config := cfg.Get().WindowsAccounts
+	if err := checkDomainMode(config, domainJoined(), k.UserName); err != nil {
+		return err
+	}
+	groups, err := windowsKeyGroups(config, k, exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
 		logger.Infof("Resetting password for user %s", k.UserName)
 		if err := resetPwd(k.UserName, pwd); err != nil {
-			return nil, fmt.Errorf("error running resetPwd: %v", err)
-		}
-		if k.AddToAdministrators != nil && *k.AddToAdministrators {
-			if err := addUserToGroup(ctx, k.UserName, "Administrators"); err != nil {
-				return nil, fmt.Errorf("error running addUserToGroup: %v", err)
-			}
+			return fmt.Errorf("error running resetPwd: %v", err)
 		}
 	} else {
 		logger.Infof("Creating user %s", k.UserName)
 		if err := createUser(ctx, k.UserName, pwd, ""); err != nil {
-			return nil, fmt.Errorf("error running createUser: %v", err)
+			return fmt.Errorf("error running createUser: %v", err)
 		}
-		if k.AddToAdministrators == nil || *k.AddToAdministrators {
-			if err := addUserToGroup(ctx, k.UserName, "Administrators"); err != nil {
-				return nil, fmt.Errorf("error running addUserToGroup: %v", err)
-			}
+	}
+
+	return addUserToGroups(ctx, k.UserName, groups, record)
+}
+
+// addUserToGroups adds user to groups, record is updated with the groups added to.
+func addUserToGroups(ctx context.Context, user string, groups []string, record *accountAudit) error {
+	for _, group := range groups {
+		if err := addUserToGroup(ctx, user, group); err != nil {
+			return fmt.Errorf("error running addUserToGroup: %v", err)
 		}
+		record.Groups = append(record.Groups, group)
 	}
+	return nil
+}
 
-	return createcredsJSON(k, pwd)
+// splitList splits the comma separated list, skipping the empty entries.
+func splitList(list string) []string {
+	var res []string
+	for _, group := range strings.Split(list, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			res = append(res, group)
+		}
+	}
+	return res
 }
 
-func createSSHUser(ctx context.Context, user string) error {
-	pwd, err := newPwd(20)
-	if err != nil {
-		return fmt.Errorf("error creating password: %v", err)
+// windowsKeyGroups returns the local groups k's user is added to, exists is set if
+// the user already exists. The groups named by k, and Administrators if requested
+// with addToAdministrators, must be in config's allowed groups. The created users
+// are otherwise added to config's default groups.
+func windowsKeyGroups(config *cfg.WindowsAccounts, k metadata.WindowsKey, exists bool) ([]string, error) {
+	requested := slices.Clone(k.Groups)
+	if k.AddToAdministrators != nil && *k.AddToAdministrators {
+		requested = append(requested, "Administrators")
+	}
+
+	if len(requested) == 0 {
+		if exists || k.AddToAdministrators != nil {
+			return nil, nil
+		}
+		return splitList(config.DefaultGroups), nil
 	}
+
+	allowed := splitList(config.AllowedGroups)
+	var res []string
+	for _, group := range requested {
+		group = strings.TrimSpace(group)
+		if !slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(a, group) }) {
+			return nil, fmt.Errorf("group %q is not allowed, the allowed groups are %q", group, allowed)
+		}
+		if !slices.ContainsFunc(res, func(r string) bool { return strings.EqualFold(r, group) }) {
+			res = append(res, group)
+		}
+	}
+	return res, nil
+}
+
+func createSSHUser(ctx context.Context, user string) error {
 	if _, err := userExists(user); err == nil {
 		return nil
 	}
+	record := accountAudit{Action: auditActionCreateAccount, User: user, Source: auditSourceSSHKeys}
+	if err := checkDomainMode(cfg.Get().WindowsAccounts, domainJoined(), user); err != nil {
+		auditAccountChange(ctx, record, err)
+		return err
+	}
+	pwd, err := newUserPwd(ctx, 20)
+	if err != nil {
+		return fmt.Errorf("error creating password: %v", err)
+	}
 	logger.Infof("Creating user %s", user)
 	if err := createUser(ctx, user, pwd, ""); err != nil {
-		return fmt.Errorf("error running createUser: %v", err)
+		err = fmt.Errorf("error running createUser: %v", err)
+		auditAccountChange(ctx, record, err)
+		return err
 	}
 
-	if err := addUserToGroup(ctx, user, "Administrators"); err != nil {
-		return fmt.Errorf("error running addUserToGroup: %v", err)
+	err = addUserToGroups(ctx, user, splitList(cfg.Get().WindowsAccounts.DefaultGroups), &record)
+	auditAccountChange(ctx, record, err)
+	return err
+}
+
+// The windows-keys versions, selecting how the password is encrypted.
+const (
+	// winKeyVersionRSA encrypts the password with RSA OAEP, the key's exponent and
+	// modulus are given. It's the version of the keys without one.
+	winKeyVersionRSA = 1
+	// winKeyVersionECIES encrypts the password with ECIES to the key's P-256 public
+	// key: ECDH with an ephemeral key, HKDF-SHA256 and AES-256-GCM.
+	winKeyVersionECIES = 2
+)
+
+const (
+	// minWinKeyRSABits and maxWinKeyRSABits are the supported RSA key sizes.
+	minWinKeyRSABits = 2048
+	maxWinKeyRSABits = 4096
+	// eciesInfo is the HKDF info prefix of the ECIES scheme, followed by the
+	// ephemeral and the recipient public keys.
+	eciesInfo = "google-guest-agent windows-keys ecies-p256-hkdf-sha256-aes256gcm"
+)
+
+// winKeySchemesKey is the guest attribute the supported windows-keys versions are
+// advertised to.
+const winKeySchemesKey = "guest-agent/windows-keys-schemes"
+
+// winKeyScheme describes a supported windows-keys version.
+type winKeyScheme struct {
+	// Version is the windows-keys version.
+	Version int `json:"version"`
+	// Scheme is the password encryption scheme.
+	Scheme string `json:"scheme"`
+	// HashFunctions are the supported hashFunction values, RSA OAEP only.
+	HashFunctions []string `json:"hashFunctions,omitempty"`
+	// MinKeyBits and MaxKeyBits are the supported RSA key sizes.
+	MinKeyBits int `json:"minKeyBits,omitempty"`
+	MaxKeyBits int `json:"maxKeyBits,omitempty"`
+}
+
+// winKeySchemes are the supported windows-keys versions.
+var winKeySchemes = []winKeyScheme{
+	{Version: winKeyVersionRSA, Scheme: "rsa-oaep", HashFunctions: []string{"sha1", "sha256", "sha512"}, MinKeyBits: minWinKeyRSABits, MaxKeyBits: maxWinKeyRSABits},
+	{Version: winKeyVersionECIES, Scheme: "ecies-p256-hkdf-sha256-aes256gcm"},
+}
+
+// winKeySchemesAdvertised is set once the supported versions were written to the
+// guest attributes.
+var winKeySchemesAdvertised bool
+
+// advertiseWinKeySchemes writes the supported windows-keys versions to the guest
+// attributes, once.
+func advertiseWinKeySchemes(ctx context.Context) {
+	if winKeySchemesAdvertised || mdsClient == nil {
+		return
 	}
-	return nil
+	data, err := json.Marshal(winKeySchemes)
+	if err != nil {
+		logger.Errorf("Failed to marshal the windows-keys schemes: %v", err)
+		return
+	}
+	if err := mdsClient.WriteGuestAttributes(ctx, winKeySchemesKey, string(data)); err != nil {
+		logger.Errorf("Failed to advertise the windows-keys schemes to guest attributes: %v", err)
+		return
+	}
+	winKeySchemesAdvertised = true
 }
 
 func createcredsJSON(k metadata.WindowsKey, pwd string) (*credsJSON, error) {
+	switch k.Version {
+	case 0, winKeyVersionRSA:
+		return createRSACredsJSON(k, pwd)
+	case winKeyVersionECIES:
+		return createECIESCredsJSON(k, pwd)
+	default:
+		return nil, fmt.Errorf("unsupported windows key version %d", k.Version)
+	}
+}
+
+// createRSACredsJSON returns the credentials of k with the password encrypted with
+// RSA OAEP.
+func createRSACredsJSON(k metadata.WindowsKey, pwd string) (*credsJSON, error) {
 	mod, err := base64.StdEncoding.DecodeString(k.Modulus)
 	if err != nil {
 		return nil, fmt.Errorf("error decoding modulus: %v", err)
@@ -180,6 +425,9 @@ func createcredsJSON(k metadata.WindowsKey, pwd string) (*credsJSON, error) {
 		N: new(big.Int).SetBytes(mod),
 		E: int(new(big.Int).SetBytes(exp).Int64()),
 	}
+	if bits := key.N.BitLen(); bits < minWinKeyRSABits || bits > maxWinKeyRSABits {
+		return nil, fmt.Errorf("unsupported %d bits RSA key, want %d to %d bits", bits, minWinKeyRSABits, maxWinKeyRSABits)
+	}
 
 	if k.HashFunction == "" {
 		k.HashFunction = "sha1"
@@ -208,10 +456,59 @@ func createcredsJSON(k metadata.WindowsKey, pwd string) (*credsJSON, error) {
 		Modulus:           k.Modulus,
 		UserName:          k.UserName,
 		HashFunction:      k.HashFunction,
+		Version:           k.Version,
 		EncryptedPassword: base64.StdEncoding.EncodeToString(encPwd),
 	}, nil
 }
 
+// createECIESCredsJSON returns the credentials of k with the password encrypted
+// with ECIES. The AES key and the GCM nonce are derived from the shared secret
+// with HKDF-SHA256, the ephemeral key is only used once.
+func createECIESCredsJSON(k metadata.WindowsKey, pwd string) (*credsJSON, error) {
+	raw, err := base64.StdEncoding.DecodeString(k.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %v", err)
+	}
+	pub, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid P-256 public key: %v", err)
+	}
+
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating ephemeral key: %v", err)
+	}
+	secret, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("error computing shared secret: %v", err)
+	}
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	info := slices.Concat([]byte(eciesInfo), ephemeralPub, raw)
+	material := make([]byte, 32+12)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), material); err != nil {
+		return nil, fmt.Errorf("error deriving key: %v", err)
+	}
+	block, err := aes.NewCipher(material[:32])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	encPwd := gcm.Seal(nil, material[32:], []byte(pwd), nil)
+
+	return &credsJSON{
+		PasswordFound:      true,
+		PublicKey:          k.PublicKey,
+		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeralPub),
+		UserName:           k.UserName,
+		Version:            k.Version,
+		EncryptedPassword:  base64.StdEncoding.EncodeToString(encPwd),
+	}, nil
+}
+
 func getWinSSHEnabled(md *metadata.Descriptor) bool {
 	var enable bool
 	if md.Project.Attributes.EnableWindowsSSH != nil {
@@ -229,23 +526,23 @@ type winAccountsMgr struct {
 	fakeWindows bool
 }
 
-func (a *winAccountsMgr) Diff(ctx context.Context) (bool, error) {
-	oldSSHEnable := getWinSSHEnabled(oldMetadata)
+func (a *winAccountsMgr) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
+	oldSSHEnable := getWinSSHEnabled(oldMd)
 
-	sshEnable := getWinSSHEnabled(newMetadata)
+	sshEnable := getWinSSHEnabled(newMd)
 	if sshEnable != oldSSHEnable {
 		return true, nil
 	}
-	if !reflect.DeepEqual(newMetadata.Instance.Attributes.WindowsKeys, oldMetadata.Instance.Attributes.WindowsKeys) {
+	if !reflect.DeepEqual(newMd.Instance.Attributes.WindowsKeys, oldMd.Instance.Attributes.WindowsKeys) {
 		return true, nil
 	}
-	if !compareStringSlice(newMetadata.Instance.Attributes.SSHKeys, oldMetadata.Instance.Attributes.SSHKeys) {
+	if !compareStringSlice(newMd.Instance.Attributes.SSHKeys, oldMd.Instance.Attributes.SSHKeys) {
 		return true, nil
 	}
-	if !compareStringSlice(newMetadata.Project.Attributes.SSHKeys, oldMetadata.Project.Attributes.SSHKeys) {
+	if !compareStringSlice(newMd.Project.Attributes.SSHKeys, oldMd.Project.Attributes.SSHKeys) {
 		return true, nil
 	}
-	if newMetadata.Instance.Attributes.BlockProjectKeys != oldMetadata.Instance.Attributes.BlockProjectKeys {
+	if newMd.Instance.Attributes.BlockProjectKeys != oldMd.Instance.Attributes.BlockProjectKeys {
 		return true, nil
 	}
 
@@ -256,21 +553,24 @@ func (a *winAccountsMgr) Timeout(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (a *winAccountsMgr) Disabled(ctx context.Context) (bool, error) {
+func (a *winAccountsMgr) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	if !a.fakeWindows && runtime.GOOS != "windows" {
 		return true, nil
 	}
 
 	config := cfg.Get()
+	if domainMode(config.WindowsAccounts) == domainModeSkip && domainJoined() {
+		return true, nil
+	}
 	if config.AccountManager != nil {
 		return config.AccountManager.Disable, nil
 	}
 
-	if newMetadata.Instance.Attributes.DisableAccountManager != nil {
-		return *newMetadata.Instance.Attributes.DisableAccountManager, nil
+	if newMd.Instance.Attributes.DisableAccountManager != nil {
+		return *newMd.Instance.Attributes.DisableAccountManager, nil
 	}
-	if newMetadata.Project.Attributes.DisableAccountManager != nil {
-		return *newMetadata.Project.Attributes.DisableAccountManager, nil
+	if newMd.Project.Attributes.DisableAccountManager != nil {
+		return *newMd.Project.Attributes.DisableAccountManager, nil
 	}
 	return false, nil
 }
@@ -336,9 +636,9 @@ func verifyWinSSHVersion(ctx context.Context) error {
 	return versionOk(sshdVersion, minSSHVersion)
 }
 
-func (a *winAccountsMgr) Set(ctx context.Context) error {
-	oldSSHEnable := getWinSSHEnabled(oldMetadata)
-	sshEnable := getWinSSHEnabled(newMetadata)
+func (a *winAccountsMgr) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
+	oldSSHEnable := getWinSSHEnabled(oldMd)
+	sshEnable := getWinSSHEnabled(newMd)
 
 	if sshEnable {
 		if sshEnable != oldSSHEnable {
@@ -357,12 +657,7 @@ func (a *winAccountsMgr) Set(ctx context.Context) error {
 			logger.Debugf("initialize sshKeys map")
 			sshKeys = make(map[string][]string)
 		}
-		mdkeys := newMetadata.Instance.Attributes.SSHKeys
-		if !newMetadata.Instance.Attributes.BlockProjectKeys {
-			mdkeys = append(mdkeys, newMetadata.Project.Attributes.SSHKeys...)
-		}
-
-		mdKeyMap := getUserKeys(mdkeys)
+		mdKeyMap := metadataUserKeys(newMd)
 
 		for user := range mdKeyMap {
 			if err := createSSHUser(ctx, user); err != nil {
@@ -371,7 +666,9 @@ func (a *winAccountsMgr) Set(ctx context.Context) error {
 		}
 	}
 
-	newKeys := newMetadata.Instance.Attributes.WindowsKeys
+	advertiseWinKeySchemes(ctx)
+
+	newKeys := newMd.Instance.Attributes.WindowsKeys
 	regKeys, err := readRegMultiString(regKeyBase, accountRegKey)
 	if err != nil && err != errRegNotExist {
 		return err
@@ -390,7 +687,9 @@ func (a *winAccountsMgr) Set(ctx context.Context) error {
 			PasswordFound: false,
 			Exponent:      key.Exponent,
 			Modulus:       key.Modulus,
+			PublicKey:     key.PublicKey,
 			UserName:      key.UserName,
+			Version:       key.Version,
 			ErrorMessage:  err.Error(),
 		}
 		printCreds(creds)
@@ -438,6 +737,7 @@ func compareAccounts(newKeys metadata.WindowsKeys, oldStrKeys []string) metadata
 			for _, oldKey := range oldKeys {
 				if oldKey.UserName == key.UserName &&
 					oldKey.Modulus == key.Modulus &&
+					oldKey.PublicKey == key.PublicKey &&
 					oldKey.ExpireOn == key.ExpireOn {
 					return false
 				}
@@ -16,6 +16,9 @@ package main
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -24,15 +27,21 @@ import (
 	"encoding/base64"
 	"fmt"
 	"hash"
+	"io"
 	"math/big"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 	"unicode"
+	"unicode/utf16"
 
+	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
 	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-agent/utils"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/hkdf"
 )
 
 func mkptr(b bool) *bool {
@@ -84,12 +93,11 @@ func TestAccountsDisabled(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			reloadConfig(t, tt.data)
 
-			newMetadata = tt.md
 			mgr := &winAccountsMgr{
 				fakeWindows: true,
 			}
 
-			got, err := mgr.Disabled(ctx)
+			got, err := mgr.Disabled(ctx, tt.md)
 			if err != nil {
 				t.Errorf("Failed to run winAccountsMgr's Disabled() call: %+v", err)
 			}
@@ -102,7 +110,7 @@ func TestAccountsDisabled(t *testing.T) {
 
 	reloadConfig(t, nil)
 
-	got, err := (&winAccountsMgr{}).Disabled(ctx)
+	got, err := (&winAccountsMgr{}).Disabled(ctx, &metadata.Descriptor{})
 	if err != nil {
 		t.Errorf("Failed to run winAccountsMgr's Disabled() call: %+v", err)
 	}
@@ -133,7 +141,7 @@ func TestNewPwd(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			for i := 0; i < 100000; i++ {
-				pwd, err := newPwd(tt.passwordLength)
+				pwd, err := newPwd(tt.passwordLength, 0)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -161,6 +169,81 @@ func TestNewPwd(t *testing.T) {
 	}
 }
 
+func TestNewPwdComplexity(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		pwd, err := newPwd(0, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lower := strings.IndexFunc(pwd, unicode.IsLower) >= 0
+		upper := strings.IndexFunc(pwd, unicode.IsUpper) >= 0
+		digit := strings.IndexFunc(pwd, unicode.IsDigit) >= 0
+		special := strings.IndexFunc(pwd, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }) >= 0
+		if !lower || !upper || !digit || !special {
+			t.Fatalf("Password does not have at least one character from the 4 categories: '%v'", pwd)
+		}
+	}
+}
+
+func TestNewUserPwd(t *testing.T) {
+	if err := cfg.Load([]byte("[WindowsAccounts]\npassword_length = 40")); err != nil {
+		t.Fatalf("cfg.Load() = %v, want nil", err)
+	}
+	t.Cleanup(func() { cfg.Load(nil) })
+
+	for requested, want := range map[int]int{0: 40, 60: 60} {
+		pwd, err := newUserPwd(context.Background(), requested)
+		if err != nil {
+			t.Fatalf("newUserPwd(%d) = %v, want nil", requested, err)
+		}
+		if len(pwd) != want {
+			t.Errorf("newUserPwd(%d) returned a %d characters password, want %d", requested, len(pwd), want)
+		}
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	tests := []struct {
+		policy  winPasswordPolicy
+		length  int
+		classes int
+		wantErr bool
+	}{
+		{policy: winPasswordPolicy{minLength: 14, complexity: true}, length: 15, classes: 3},
+		{policy: winPasswordPolicy{minLength: 20}, length: 15, classes: 3, wantErr: true},
+		{policy: winPasswordPolicy{minLength: 20}, length: 20, classes: 1},
+		{policy: winPasswordPolicy{complexity: true}, length: 15, classes: 2, wantErr: true},
+	}
+	for _, tc := range tests {
+		if err := tc.policy.check(tc.length, tc.classes); (err != nil) != tc.wantErr {
+			t.Errorf("%+v.check(%d, %d) = %v, want error: %t", tc.policy, tc.length, tc.classes, err, tc.wantErr)
+		}
+	}
+}
+
+func TestParsePasswordComplexity(t *testing.T) {
+	policy := "[Unicode]\r\nUnicode=yes\r\n[System Access]\r\nMinimumPasswordLength = 14\r\nPasswordComplexity = 1\r\n"
+	utf16le := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(policy)) {
+		utf16le = append(utf16le, byte(u), byte(u>>8))
+	}
+
+	tests := map[string]struct {
+		data []byte
+		want bool
+	}{
+		"utf-16":   {data: utf16le, want: true},
+		"ascii":    {data: []byte(policy), want: true},
+		"disabled": {data: []byte("[System Access]\r\nPasswordComplexity = 0\r\n"), want: false},
+		"missing":  {data: []byte("[System Access]\r\n"), want: false},
+	}
+	for name, tc := range tests {
+		if got := parsePasswordComplexity(tc.data); got != tc.want {
+			t.Errorf("parsePasswordComplexity(%s) = %t, want %t", name, got, tc.want)
+		}
+	}
+}
+
 func TestCreatecredsJSON(t *testing.T) {
 	pwd := "password"
 	prv, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -207,6 +290,142 @@ func TestCreatecredsJSON(t *testing.T) {
 	}
 }
 
+func TestCreatecredsJSONKeySizes(t *testing.T) {
+	for _, bits := range []int{1024, 4096} {
+		prv, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatalf("error generating key: %v", err)
+		}
+		k := metadata.WindowsKey{
+			Exponent:     base64.StdEncoding.EncodeToString(new(big.Int).SetInt64(int64(prv.PublicKey.E)).Bytes()),
+			Modulus:      base64.StdEncoding.EncodeToString(prv.PublicKey.N.Bytes()),
+			UserName:     "username",
+			HashFunction: "sha256",
+			Version:      winKeyVersionRSA,
+		}
+		c, err := createcredsJSON(k, "password")
+		if bits < minWinKeyRSABits {
+			if err == nil {
+				t.Errorf("createcredsJSON() with a %d bits key = nil error, want an error", bits)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("createcredsJSON() with a %d bits key = %v, want nil", bits, err)
+		}
+		bPwd, err := base64.StdEncoding.DecodeString(c.EncryptedPassword)
+		if err != nil {
+			t.Fatalf("error base64 decoding encoded pwd: %v", err)
+		}
+		decPwd, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, prv, bPwd, nil)
+		if err != nil || string(decPwd) != "password" {
+			t.Errorf("decrypting the password of a %d bits key = %q, %v, want %q, nil", bits, decPwd, err, "password")
+		}
+	}
+}
+
+func TestCreatecredsJSONECIES(t *testing.T) {
+	prv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	k := metadata.WindowsKey{
+		PublicKey: base64.StdEncoding.EncodeToString(prv.PublicKey().Bytes()),
+		UserName:  "username",
+		Version:   winKeyVersionECIES,
+	}
+	c, err := createcredsJSON(k, "password")
+	if err != nil {
+		t.Fatalf("createcredsJSON() = %v, want nil", err)
+	}
+	if c.Version != winKeyVersionECIES || c.PublicKey != k.PublicKey || !c.PasswordFound {
+		t.Errorf("createcredsJSON() = %+v, want version %d, the key's public key and the password found", c, winKeyVersionECIES)
+	}
+
+	// Decrypt the password as the windows-keys client does.
+	ephemeralPub, err := base64.StdEncoding.DecodeString(c.EphemeralPublicKey)
+	if err != nil {
+		t.Fatalf("error base64 decoding the ephemeral public key: %v", err)
+	}
+	ephemeral, err := ecdh.P256().NewPublicKey(ephemeralPub)
+	if err != nil {
+		t.Fatalf("invalid ephemeral public key: %v", err)
+	}
+	secret, err := prv.ECDH(ephemeral)
+	if err != nil {
+		t.Fatalf("error computing shared secret: %v", err)
+	}
+	material := make([]byte, 44)
+	info := slices.Concat([]byte(eciesInfo), ephemeralPub, prv.PublicKey().Bytes())
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), material); err != nil {
+		t.Fatalf("error deriving key: %v", err)
+	}
+	block, err := aes.NewCipher(material[:32])
+	if err != nil {
+		t.Fatalf("aes.NewCipher() = %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("cipher.NewGCM() = %v", err)
+	}
+	bPwd, err := base64.StdEncoding.DecodeString(c.EncryptedPassword)
+	if err != nil {
+		t.Fatalf("error base64 decoding encoded pwd: %v", err)
+	}
+	decPwd, err := gcm.Open(nil, material[32:], bPwd, nil)
+	if err != nil || string(decPwd) != "password" {
+		t.Errorf("decrypting the password = %q, %v, want %q, nil", decPwd, err, "password")
+	}
+}
+
+func TestCreatecredsJSONInvalid(t *testing.T) {
+	tests := []metadata.WindowsKey{
+		{UserName: "username", PublicKey: "cHVibGlj", Version: winKeyVersionECIES},
+		{UserName: "username", PublicKey: "not base64", Version: winKeyVersionECIES},
+		{UserName: "username", PublicKey: "cHVibGlj", Version: 3},
+	}
+	for _, k := range tests {
+		if _, err := createcredsJSON(k, "password"); err == nil {
+			t.Errorf("createcredsJSON(%+v) = nil error, want an error", k)
+		}
+	}
+}
+
+func TestWindowsKeyGroups(t *testing.T) {
+	config := &cfg.WindowsAccounts{AllowedGroups: "Administrators, Remote Desktop Users,Users", DefaultGroups: "Users,Remote Desktop Users"}
+	tests := []struct {
+		name    string
+		key     metadata.WindowsKey
+		exists  bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "new user defaults", want: []string{"Users", "Remote Desktop Users"}},
+		{name: "existing user defaults", exists: true},
+		{name: "not administrator", key: metadata.WindowsKey{AddToAdministrators: mkptr(false)}},
+		{name: "administrator", key: metadata.WindowsKey{AddToAdministrators: mkptr(true)}, exists: true, want: []string{"Administrators"}},
+		{name: "requested groups", key: metadata.WindowsKey{Groups: []string{"users", "Remote Desktop Users", "Users"}}, want: []string{"users", "Remote Desktop Users"}},
+		{name: "requested groups and administrator", key: metadata.WindowsKey{Groups: []string{"Users"}, AddToAdministrators: mkptr(true)}, want: []string{"Users", "Administrators"}},
+		{name: "not allowed group", key: metadata.WindowsKey{Groups: []string{"Users", "Backup Operators"}}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := windowsKeyGroups(config, tc.key, tc.exists)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("windowsKeyGroups(%+v, %t) = %v, want error: %t", tc.key, tc.exists, err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("windowsKeyGroups(%+v, %t) returned unexpected diff (-want +got):\n%s", tc.key, tc.exists, diff)
+			}
+		})
+	}
+
+	if _, err := windowsKeyGroups(&cfg.WindowsAccounts{}, metadata.WindowsKey{AddToAdministrators: mkptr(true)}, false); err == nil {
+		t.Errorf("windowsKeyGroups() with no allowed groups = nil error, want Administrators rejected")
+	}
+}
+
 func TestCompareAccounts(t *testing.T) {
 	var tests = []struct {
 		newKeys    metadata.WindowsKeys
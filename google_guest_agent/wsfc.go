@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/google_guest_agent/cfg"
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
@@ -50,7 +51,7 @@ type wsfcManager struct {
 // running if one of the following is true:
 // - EnableWSFC is set
 // - WSFCAddresses is set (As an advanced setting, it will always override EnableWSFC flag)
-func newWsfcManager() *wsfcManager {
+func newWsfcManager(md *metadata.Descriptor) *wsfcManager {
 	newState := stopped
 	config := cfg.Get()
 
@@ -58,16 +59,16 @@ func newWsfcManager() *wsfcManager {
 		if config.WSFC != nil && config.WSFC.Enable && config.WSFC.Addresses != "" {
 			return config.WSFC.Enable
 		}
-		if newMetadata.Instance.Attributes.EnableWSFC != nil {
-			return *newMetadata.Instance.Attributes.EnableWSFC
+		if md.Instance.Attributes.EnableWSFC != nil {
+			return *md.Instance.Attributes.EnableWSFC
 		}
-		if newMetadata.Instance.Attributes.WSFCAddresses != "" {
+		if md.Instance.Attributes.WSFCAddresses != "" {
 			return true
 		}
-		if newMetadata.Project.Attributes.EnableWSFC != nil {
-			return *newMetadata.Project.Attributes.EnableWSFC
+		if md.Project.Attributes.EnableWSFC != nil {
+			return *md.Project.Attributes.EnableWSFC
 		}
-		if newMetadata.Project.Attributes.WSFCAddresses != "" {
+		if md.Project.Attributes.WSFCAddresses != "" {
 			return true
 		}
 		return false
@@ -78,24 +79,24 @@ func newWsfcManager() *wsfcManager {
 	newPort := wsfcDefaultAgentPort
 	if config.WSFC != nil && config.WSFC.Port != "" {
 		newPort = config.WSFC.Port
-	} else if newMetadata.Instance.Attributes.WSFCAgentPort != "" {
-		newPort = newMetadata.Instance.Attributes.WSFCAgentPort
-	} else if newMetadata.Project.Attributes.WSFCAgentPort != "" {
-		newPort = newMetadata.Instance.Attributes.WSFCAgentPort
+	} else if md.Instance.Attributes.WSFCAgentPort != "" {
+		newPort = md.Instance.Attributes.WSFCAgentPort
+	} else if md.Project.Attributes.WSFCAgentPort != "" {
+		newPort = md.Instance.Attributes.WSFCAgentPort
 	}
 
 	return &wsfcManager{agentNewState: newState, agentNewPort: newPort, agent: getWsfcAgentInstance()}
 }
 
 // Implement manager.diff()
-func (m *wsfcManager) Diff(ctx context.Context) (bool, error) {
+func (m *wsfcManager) Diff(ctx context.Context, oldMd, newMd *metadata.Descriptor) (bool, error) {
 	return m.agentNewState != m.agent.getState() || m.agentNewPort != m.agent.getPort(), nil
 }
 
 // Implement manager.disabled().
 // wsfc manager is always enabled. The manager is just a broker which manages the state of wsfcAgent. User
 // can disable the wsfc feature by setting the metadata. If the manager is disabled, the agent will stop.
-func (m *wsfcManager) Disabled(ctx context.Context) (bool, error) {
+func (m *wsfcManager) Disabled(ctx context.Context, newMd *metadata.Descriptor) (bool, error) {
 	return false, nil
 }
 
@@ -106,7 +107,7 @@ func (m *wsfcManager) Timeout(ctx context.Context) (bool, error) {
 // Diff will always be called before set. So in set, only two cases are possible:
 // - state changed: start or stop the wsfc agent accordingly
 // - port changed: restart the agent if it is running
-func (m *wsfcManager) Set(ctx context.Context) error {
+func (m *wsfcManager) Set(ctx context.Context, oldMd, newMd *metadata.Descriptor) error {
 	m.agent.setPort(m.agentNewPort)
 
 	// if state changes
@@ -64,8 +64,7 @@ func TestNewWsfcManager(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			newMetadata = tt.args.newMetadata
-			if got := newWsfcManager(); !reflect.DeepEqual(got, tt.want) {
+			if got := newWsfcManager(tt.args.newMetadata); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("newWsfcManager() = %v, want %v", got, tt.want)
 			}
 		})
@@ -88,7 +87,7 @@ func TestWsfcManagerDiff(t *testing.T) {
 	ctx := context.Background()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.m.Diff(ctx)
+			got, err := tt.m.Diff(ctx, nil, nil)
 			if err != nil {
 				t.Errorf("Failed to run wsfcManager's Diff() call: %+v", err)
 			}
@@ -164,7 +163,7 @@ func TestWsfcManagerSet(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.m.Set(ctx); (err != nil) != tt.wantErr {
+			if err := tt.m.Set(ctx, nil, nil); (err != nil) != tt.wantErr {
 				t.Errorf("wsfcManager.set() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -205,7 +204,7 @@ func TestWsfcRunAgentE2E(t *testing.T) {
 		agent:         getWsfcAgentInstance(),
 	}
 
-	if err := wsfcMgr.Set(ctx); err != nil {
+	if err := wsfcMgr.Set(ctx, nil, nil); err != nil {
 		t.Errorf("Failed to run wsfcManager's Set() call: %+v", err)
 	}
 
@@ -249,7 +248,7 @@ func TestWsfcRunAgentE2E(t *testing.T) {
 		agent:         getWsfcAgentInstance(),
 	}
 
-	if err := wsfcMgrStop.Set(ctx); err != nil {
+	if err := wsfcMgrStop.Set(ctx, nil, nil); err != nil {
 		t.Errorf("Failed to run wsfcMgr's Set() call: %+v", err)
 	}
 
@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-agent/utils"
+)
+
+var (
+	// cacheMutex protects cacheFile and serializes the cache writes.
+	cacheMutex sync.Mutex
+	// cacheFile is the file the last fetched descriptor is persisted to, empty if
+	// the cache is disabled.
+	cacheFile string
+)
+
+// SetCacheFile sets the file the clients persist the last fetched descriptor to,
+// so it can be loaded with CachedDescriptor() when the metadata server is
+// unreachable. An empty path disables the cache.
+func SetCacheFile(path string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cacheFile = path
+}
+
+// writeCache persists body, the raw descriptor returned by the metadata server,
+// to the cache file. The descriptor holds ssh keys and other attributes so the
+// file is only readable by its owner.
+func writeCache(body string) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	if cacheFile == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return utils.SaferWriteFile([]byte(body), cacheFile, 0600)
+}
+
+// CachedDescriptor returns the descriptor last persisted to the cache file.
+func CachedDescriptor() (*Descriptor, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	if cacheFile == "" {
+		return nil, fmt.Errorf("metadata cache is disabled")
+	}
+
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata cache: %w", err)
+	}
+
+	var ret Descriptor
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata cache %q: %w", cacheFile, err)
+	}
+
+	return &ret, nil
+}
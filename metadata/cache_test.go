@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDescriptorCache(t *testing.T) {
+	t.Cleanup(func() { SetCacheFile("") })
+
+	if _, err := CachedDescriptor(); err == nil {
+		t.Errorf("CachedDescriptor() succeeded with the cache disabled, want error")
+	}
+
+	file := filepath.Join(t.TempDir(), "state", "metadata.json")
+	SetCacheFile(file)
+
+	if _, err := CachedDescriptor(); err == nil {
+		t.Errorf("CachedDescriptor() succeeded before any descriptor was fetched, want error")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"instance":{"id":1234,"attributes":{"ssh-keys":"name:ssh-rsa [KEY] hostname"}}}`)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	want, err := client.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() failed unexpectedly with error: %v", err)
+	}
+
+	got, err := CachedDescriptor()
+	if err != nil {
+		t.Fatalf("CachedDescriptor() failed unexpectedly with error: %v", err)
+	}
+
+	if got.Instance.ID != want.Instance.ID || len(got.Instance.Attributes.SSHKeys) != 1 {
+		t.Errorf("CachedDescriptor() = %+v, want %+v", got.Instance, want.Instance)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) failed unexpectedly with error: %v", file, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Metadata cache permissions = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
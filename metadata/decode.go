@@ -0,0 +1,127 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	// bufferPool holds the buffers the response bodies are read into, so the
+	// longpolls don't allocate (and grow) a new buffer on every wakeup.
+	bufferPool = sync.Pool{
+		New: func() any { return new(bytes.Buffer) },
+	}
+)
+
+// readBody reads r into a pooled buffer and returns its content.
+func readBody(r io.Reader) (string, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// descriptorDecoder decodes descriptors section by section, the sections whose
+// raw bytes didn't change since the previous decode are not unmarshalled again
+// but reused. The descriptors returned share the unchanged sections' slices and
+// maps, callers must not modify them in place.
+type descriptorDecoder struct {
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// instanceRaw and projectRaw are the raw sections of the previous decode.
+	instanceRaw []byte
+	projectRaw  []byte
+	// instance and project are the sections of the previous decode.
+	instance Instance
+	project  Project
+}
+
+// decode decodes body, the JSON descriptor, streaming its top level keys.
+func (d *descriptorDecoder) decode(body string) (*Descriptor, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ret Descriptor
+	if err := d.decodeSections(body, &ret); err != nil {
+		// Unmarshal the whole descriptor to get a descriptive syntax error.
+		if jsonErr := json.Unmarshal([]byte(body), &ret); jsonErr != nil {
+			return nil, jsonErr
+		}
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// decodeSections decodes the instance and project sections of body into ret,
+// callers must hold the mutex.
+func (d *descriptorDecoder) decodeSections(body string, ret *Descriptor) error {
+	dec := json.NewDecoder(strings.NewReader(body))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("descriptor is not a JSON object")
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+
+		// Like encoding/json, match the keys case insensitively.
+		switch strings.ToLower(key) {
+		case "instance":
+			if d.instanceRaw == nil || !bytes.Equal(raw, d.instanceRaw) {
+				var instance Instance
+				if err := json.Unmarshal(raw, &instance); err != nil {
+					return err
+				}
+				d.instance, d.instanceRaw = instance, raw
+			}
+			ret.Instance = d.instance
+		case "project":
+			if d.projectRaw == nil || !bytes.Equal(raw, d.projectRaw) {
+				var project Project
+				if err := json.Unmarshal(raw, &project); err != nil {
+					return err
+				}
+				d.project, d.projectRaw = project, raw
+			}
+			ret.Project = d.project
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDescriptorDecoder(t *testing.T) {
+	var d descriptorDecoder
+
+	first := `{"instance":{"id":1,"tags":["a"]},"project":{"projectId":"p1","attributes":{"ssh-keys":"user:key1"}}}`
+	got, err := d.decode(first)
+	if err != nil {
+		t.Fatalf("decode() failed unexpectedly with error: %v", err)
+	}
+
+	var want Descriptor
+	if err := json.Unmarshal([]byte(first), &want); err != nil {
+		t.Fatalf("json.Unmarshal() failed unexpectedly with error: %v", err)
+	}
+	if diff := cmp.Diff(&want, got); diff != "" {
+		t.Errorf("decode() returned unexpected descriptor (-want +got):\n%s", diff)
+	}
+
+	// Only the project changed, the instance section must be reused.
+	second := `{"instance":{"id":1,"tags":["a"]},"project":{"projectId":"p1","attributes":{"ssh-keys":"user:key2"}}}`
+	got2, err := d.decode(second)
+	if err != nil {
+		t.Fatalf("decode() failed unexpectedly with error: %v", err)
+	}
+
+	if &got.Instance.Tags[0] != &got2.Instance.Tags[0] {
+		t.Errorf("decode() unmarshalled the unchanged instance section again")
+	}
+	if diff := cmp.Diff([]string{"user:key2"}, got2.Project.Attributes.SSHKeys); diff != "" {
+		t.Errorf("decode() returned unexpected ssh keys (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"user:key1"}, got.Project.Attributes.SSHKeys); diff != "" {
+		t.Errorf("decode() modified the previous descriptor's ssh keys (-want +got):\n%s", diff)
+	}
+}
+
+func TestDescriptorDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "syntax_error",
+			body: "{\"instance\":{\"id\":1,}}",
+			want: "invalid character",
+		},
+		{
+			name: "not_an_object",
+			body: `["instance"]`,
+			want: "cannot unmarshal array",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var d descriptorDecoder
+			_, err := d.decode(tc.body)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("decode(%q) returned error: %v, want error containing %q", tc.body, err, tc.want)
+			}
+		})
+	}
+}
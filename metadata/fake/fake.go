@@ -0,0 +1,355 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fake implements an in-process metadata server for tests and local
+// development. It serves the metadata HTTP surface used by the guest agent,
+// including recursive JSON fetches, wait_for_change longpolls and guest
+// attributes, from an in-memory JSON tree.
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// guestAttributesPath is the path of the guest attributes.
+	guestAttributesPath = "instance/guest-attributes"
+	// defaultHangTimeout is the longpoll timeout if the request sets none.
+	defaultHangTimeout = 60 * time.Second
+)
+
+// Server is a fake metadata server, it implements http.Handler and is meant to
+// be served with httptest.NewServer() or http.ListenAndServe().
+type Server struct {
+	// mutex protects root, guestAttributes and changed.
+	mutex sync.Mutex
+	// root is the metadata tree in its JSON form, i.e. keys are named as in the
+	// recursive JSON output (networkInterfaces and not network-interfaces).
+	root map[string]any
+	// guestAttributes maps the guest attributes' namespace/key to their values.
+	guestAttributes map[string]string
+	// changed is closed, and replaced, whenever the metadata changes so pending
+	// longpolls are woken up.
+	changed chan struct{}
+}
+
+// New returns a fake metadata server serving descriptor, the JSON document a
+// recursive alt=json fetch of the metadata root returns.
+func New(descriptor string) (*Server, error) {
+	root := make(map[string]any)
+	if descriptor != "" {
+		if err := json.Unmarshal([]byte(descriptor), &root); err != nil {
+			return nil, fmt.Errorf("failed to parse descriptor: %w", err)
+		}
+	}
+
+	return &Server{
+		root:            root,
+		guestAttributes: make(map[string]string),
+		changed:         make(chan struct{}),
+	}, nil
+}
+
+// splitPath splits a metadata path in its non empty segments.
+func splitPath(path string) []string {
+	return slices.DeleteFunc(strings.Split(path, "/"), func(s string) bool { return s == "" })
+}
+
+// jsonKey returns the key of node matching the URL path segment, URL paths are
+// hyphenated while the JSON keys, but the attributes', are camel cased.
+func jsonKey(node map[string]any, segment string) (string, bool) {
+	if _, found := node[segment]; found {
+		return segment, true
+	}
+
+	var camel strings.Builder
+	upper := false
+	for _, r := range segment {
+		if r == '-' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		camel.WriteRune(r)
+	}
+
+	if _, found := node[camel.String()]; found {
+		return camel.String(), true
+	}
+	return "", false
+}
+
+// lookup returns the value at path, arrays are indexed by the path segments
+// like the metadata server does with the network interfaces. Callers must hold
+// the mutex.
+func (s *Server) lookup(path string) (any, bool) {
+	var node any = s.root
+	for _, segment := range splitPath(path) {
+		switch dir := node.(type) {
+		case map[string]any:
+			key, found := jsonKey(dir, segment)
+			if !found {
+				return nil, false
+			}
+			node = dir[key]
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(dir) {
+				return nil, false
+			}
+			node = dir[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// notify wakes up the pending longpolls, callers must hold the mutex.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Set sets the value at path, creating the missing directories, and wakes up the
+// pending longpolls. value is marshalled as JSON, i.e. a string, a number or a
+// map[string]any directory.
+func (s *Server) Set(path string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value: %w", err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("failed to unmarshal value: %w", err)
+	}
+
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return fmt.Errorf("can't set the metadata root")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := s.root
+	for _, segment := range segments[:len(segments)-1] {
+		key, found := jsonKey(dir, segment)
+		if !found {
+			key = segment
+			dir[key] = make(map[string]any)
+		}
+		next, ok := dir[key].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%q is not a directory", segment)
+		}
+		dir = next
+	}
+
+	last := segments[len(segments)-1]
+	if key, found := jsonKey(dir, last); found {
+		last = key
+	}
+	dir[last] = v
+	s.notify()
+	return nil
+}
+
+// Delete deletes the value at path and wakes up the pending longpolls, deleting
+// a path that's not set is a no-op.
+func (s *Server) Delete(path string) {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	parent, found := s.lookup(strings.Join(segments[:len(segments)-1], "/"))
+	if !found {
+		return
+	}
+	dir, ok := parent.(map[string]any)
+	if !ok {
+		return
+	}
+	key, found := jsonKey(dir, segments[len(segments)-1])
+	if !found {
+		return
+	}
+	delete(dir, key)
+	s.notify()
+}
+
+// GuestAttribute returns the value of the guest attribute key in namespace and
+// whether it's set.
+func (s *Server) GuestAttribute(namespace, key string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, found := s.guestAttributes[namespace+"/"+key]
+	return value, found
+}
+
+// etag returns the etag of body, like the metadata server it only changes when
+// the content changes.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:8])
+}
+
+// render returns the body served for path and whether path exists, callers must
+// hold the mutex.
+func (s *Server) render(path string, recursive, jsonOutput bool) ([]byte, bool) {
+	value, found := s.lookup(path)
+	if !found {
+		return nil, false
+	}
+
+	dir, isDir := value.(map[string]any)
+	if isDir && !recursive {
+		var keys []string
+		for key, child := range dir {
+			if _, ok := child.(map[string]any); ok {
+				key += "/"
+			}
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		if jsonOutput {
+			data, _ := json.Marshal(keys)
+			return data, true
+		}
+		return []byte(strings.Join(keys, "\n") + "\n"), true
+	}
+
+	if str, ok := value.(string); ok && !jsonOutput {
+		return []byte(str), true
+	}
+
+	data, _ := json.Marshal(value)
+	return data, true
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		http.Error(w, "missing Metadata-Flavor:Google header", http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1")
+	if strings.HasPrefix(strings.Trim(path, "/"), guestAttributesPath) {
+		s.serveGuestAttribute(w, r, strings.TrimPrefix(strings.Trim(path, "/"), guestAttributesPath))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	recursive := query.Get("recursive") == "true"
+	jsonOutput := query.Get("alt") == "json"
+
+	timeout := defaultHangTimeout
+	if secs, err := strconv.Atoi(query.Get("timeout_sec")); err == nil && secs > 0 {
+		timeout = time.Duration(secs) * time.Second
+	}
+	deadline := time.After(timeout)
+
+	for {
+		s.mutex.Lock()
+		body, found := s.render(path, recursive, jsonOutput)
+		changed := s.changed
+		s.mutex.Unlock()
+
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		tag := etag(body)
+		if query.Get("wait_for_change") != "true" || query.Get("last_etag") != tag {
+			w.Header().Set("ETag", tag)
+			w.Header().Set("Metadata-Flavor", "Google")
+			w.Write(body)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline:
+			w.Header().Set("ETag", tag)
+			w.Header().Set("Metadata-Flavor", "Google")
+			w.Write(body)
+			return
+		case <-changed:
+		}
+	}
+}
+
+// serveGuestAttribute serves the guest attribute requests, key is the guest
+// attribute's namespace/key path.
+func (s *Server) serveGuestAttribute(w http.ResponseWriter, r *http.Request, key string) {
+	key = strings.Trim(key, "/")
+	if len(splitPath(key)) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		value, found := s.guestAttributes[key]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, value)
+	case http.MethodPut:
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.guestAttributes[key] = string(value)
+	case http.MethodDelete:
+		if _, found := s.guestAttributes[key]; !found {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.guestAttributes, key)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
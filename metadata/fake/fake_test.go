@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/metadata"
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+const descriptor = `{
+	"instance": {
+		"id": 1234,
+		"attributes": {"enable-oslogin": "true"},
+		"networkInterfaces": [{"mac": "00:00:00:00:00:01"}]
+	},
+	"project": {"projectId": "test-project"}
+}`
+
+func newClient(t *testing.T) (*Server, *metadata.Client) {
+	t.Helper()
+
+	srv, err := New(descriptor)
+	if err != nil {
+		t.Fatalf("New() failed unexpectedly with error: %v", err)
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	metadata.SetDefaultURL(ts.URL + "/computeMetadata/v1/")
+	t.Cleanup(func() { metadata.SetDefaultURL("") })
+
+	return srv, metadata.NewWithRetryPolicy(retry.Policy{MaxAttempts: 1})
+}
+
+func TestGet(t *testing.T) {
+	_, client := newClient(t)
+	ctx := context.Background()
+
+	md, err := client.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get() failed unexpectedly with error: %v", err)
+	}
+	if md.Instance.ID != "1234" || md.Project.ProjectID != "test-project" {
+		t.Errorf("Get() returned instance %q of project %q, want 1234 of test-project", md.Instance.ID, md.Project.ProjectID)
+	}
+	if len(md.Instance.NetworkInterfaces) != 1 || md.Instance.NetworkInterfaces[0].Mac != "00:00:00:00:00:01" {
+		t.Errorf("Get() returned network interfaces %+v, want one with mac 00:00:00:00:00:01", md.Instance.NetworkInterfaces)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "instance/attributes/enable-oslogin", want: "true"},
+		{key: "instance/network-interfaces/0/mac", want: "00:00:00:00:00:01"},
+		{key: "project/", want: "projectId\n"},
+	}
+	for _, tc := range tests {
+		got, err := client.GetKey(ctx, tc.key, nil)
+		if err != nil {
+			t.Errorf("GetKey(%q) failed unexpectedly with error: %v", tc.key, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("GetKey(%q) = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+
+	if _, err := client.GetKey(ctx, "instance/missing", nil); err == nil {
+		t.Errorf("GetKey(instance/missing) succeeded, want error")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv, client := newClient(t)
+	ctx := context.Background()
+
+	if _, err := client.Get(ctx); err != nil {
+		t.Fatalf("Get() failed unexpectedly with error: %v", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		srv.Set("instance/attributes/ssh-keys", "user:ssh-ed25519 AAAA user")
+	}()
+
+	md, err := client.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() failed unexpectedly with error: %v", err)
+	}
+	if len(md.Instance.Attributes.SSHKeys) != 1 {
+		t.Errorf("Watch() returned ssh keys %v, want the key set", md.Instance.Attributes.SSHKeys)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		srv.Delete("instance/attributes/ssh-keys")
+	}()
+
+	md, err = client.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() failed unexpectedly with error: %v", err)
+	}
+	if len(md.Instance.Attributes.SSHKeys) != 0 {
+		t.Errorf("Watch() returned ssh keys %v, want none", md.Instance.Attributes.SSHKeys)
+	}
+}
+
+func TestGuestAttributes(t *testing.T) {
+	srv, client := newClient(t)
+	ctx := context.Background()
+
+	if err := client.PutGuestAttribute(ctx, "hostkeys", "ssh-rsa", "AAAA"); err != nil {
+		t.Fatalf("PutGuestAttribute() failed unexpectedly with error: %v", err)
+	}
+	if got, found := srv.GuestAttribute("hostkeys", "ssh-rsa"); !found || got != "AAAA" {
+		t.Errorf("GuestAttribute() = (%q, %t), want (%q, true)", got, found, "AAAA")
+	}
+
+	if err := client.DeleteGuestAttribute(ctx, "hostkeys", "ssh-rsa"); err != nil {
+		t.Fatalf("DeleteGuestAttribute() failed unexpectedly with error: %v", err)
+	}
+	if _, found := srv.GuestAttribute("hostkeys", "ssh-rsa"); found {
+		t.Errorf("GuestAttribute() found a deleted guest attribute")
+	}
+}
+
+func TestMetadataFlavor(t *testing.T) {
+	srv, err := New(descriptor)
+	if err != nil {
+		t.Fatalf("New() failed unexpectedly with error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/computeMetadata/v1/instance/id", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("ServeHTTP() without Metadata-Flavor returned status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+const (
+	// guestAttributesPath is the path of the guest attributes relative to the metadata URL.
+	guestAttributesPath = "instance/guest-attributes/"
+	// guestAttributeAttempts is the number of attempts of a guest attribute request.
+	guestAttributeAttempts = 10
+)
+
+var (
+	// guestAttributeName matches the valid guest attribute namespace and key names.
+	guestAttributeName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// GuestAttributeKey returns the guest attribute path of key in namespace, i.e.
+// hostkeys/ssh-rsa. Namespaces and keys may only contain letters, digits,
+// underscores and hyphens.
+func GuestAttributeKey(namespace, key string) (string, error) {
+	if !guestAttributeName.MatchString(namespace) {
+		return "", fmt.Errorf("invalid guest attribute namespace %q", namespace)
+	}
+	if !guestAttributeName.MatchString(key) {
+		return "", fmt.Errorf("invalid guest attribute key %q", key)
+	}
+	return namespace + "/" + key, nil
+}
+
+// PutGuestAttribute sets the value of the guest attribute key in namespace.
+func (c *Client) PutGuestAttribute(ctx context.Context, namespace, key, value string) error {
+	path, err := GuestAttributeKey(namespace, key)
+	if err != nil {
+		return err
+	}
+	return c.WriteGuestAttributes(ctx, path, value)
+}
+
+// DeleteGuestAttribute deletes the guest attribute key in namespace, deleting a
+// guest attribute that's not set is not an error.
+func (c *Client) DeleteGuestAttribute(ctx context.Context, namespace, key string) error {
+	path, err := GuestAttributeKey(namespace, key)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("delete guest attribute %q", path)
+	return c.guestAttributeRequest(ctx, http.MethodDelete, path, "")
+}
+
+// guestAttributeRequest sends a method request for the guest attribute key, with
+// value as the request body.
+func (c *Client) guestAttributeRequest(ctx context.Context, method, key, value string) error {
+	finalURL, err := url.JoinPath(c.metadataURL, guestAttributesPath, key)
+	if err != nil {
+		return fmt.Errorf("failed to form metadata url: %+v", err)
+	}
+
+	logger.Debugf("Requesting(%s) MDS URL: %s", method, finalURL)
+
+	// This is a arbitrary retry number.
+	policy := retry.Policy{MaxAttempts: guestAttributeAttempts, Jitter: backoffDuration, BackoffFactor: 1}
+
+	call := func() error {
+		req, err := http.NewRequestWithContext(ctx, method, finalURL, strings.NewReader(value))
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Metadata-Flavor", "Google")
+
+		start := time.Now()
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			c.metrics.record(c.metricsPath(finalURL), false, -1, true, time.Since(start))
+			return err
+		}
+		defer resp.Body.Close()
+
+		failed := resp.StatusCode != http.StatusOK && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound)
+		c.metrics.record(c.metricsPath(finalURL), false, resp.StatusCode, failed, time.Since(start))
+
+		if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			// Ignore read error as we are returning original error and wrapping MDS error code.
+			r, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("invalid response from metadata server, status code: %d, reason: %s", resp.StatusCode, string(r))
+		}
+
+		return nil
+	}
+
+	return retry.Run(ctx, policy, call)
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGuestAttributeKey(t *testing.T) {
+	tests := []struct {
+		namespace, key string
+		want           string
+		wantErr        bool
+	}{
+		{"hostkeys", "ssh-rsa", "hostkeys/ssh-rsa", false},
+		{"guest-agent", "version_2", "guest-agent/version_2", false},
+		{"", "key", "", true},
+		{"namespace", "", "", true},
+		{"name/space", "key", "", true},
+		{"namespace", "../key", "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.namespace+"/"+tc.key, func(t *testing.T) {
+			got, err := GuestAttributeKey(tc.namespace, tc.key)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("GuestAttributeKey(%q, %q) returned error: %v, want error: %t", tc.namespace, tc.key, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("GuestAttributeKey(%q, %q) = %q, want %q", tc.namespace, tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPutDeleteGuestAttribute(t *testing.T) {
+	var mutex sync.Mutex
+	attributes := make(map[string]string)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			attributes[r.URL.Path] = string(body)
+		case http.MethodDelete:
+			if _, found := attributes[r.URL.Path]; !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(attributes, r.URL.Path)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	ctx := context.Background()
+	path := "/" + guestAttributesPath + "guest-agent/version"
+
+	if err := client.PutGuestAttribute(ctx, "guest-agent", "version", "1.0"); err != nil {
+		t.Fatalf("PutGuestAttribute() failed unexpectedly with error: %v", err)
+	}
+	if got := attributes[path]; got != "1.0" {
+		t.Errorf("PutGuestAttribute() wrote %q to %q, want %q", got, path, "1.0")
+	}
+
+	if err := client.DeleteGuestAttribute(ctx, "guest-agent", "version"); err != nil {
+		t.Fatalf("DeleteGuestAttribute() failed unexpectedly with error: %v", err)
+	}
+	if _, found := attributes[path]; found {
+		t.Errorf("DeleteGuestAttribute() didn't delete %q", path)
+	}
+
+	// Deleting an attribute that's not set is not an error.
+	if err := client.DeleteGuestAttribute(ctx, "guest-agent", "version"); err != nil {
+		t.Errorf("DeleteGuestAttribute() of a missing attribute failed with error: %v", err)
+	}
+
+	if err := client.PutGuestAttribute(ctx, "guest agent", "version", "1.0"); err == nil {
+		t.Errorf("PutGuestAttribute() with an invalid namespace succeeded, want error")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// identityPath is the path of the default service account's identity token.
+	identityPath = "instance/service-accounts/default/identity"
+)
+
+var (
+	// identityRefreshMargin is how long before its expiry a cached identity token
+	// is refreshed, so callers never get a token about to expire.
+	identityRefreshMargin = 5 * time.Minute
+)
+
+// identityToken is a cached identity token.
+type identityToken struct {
+	token  string
+	expiry time.Time
+}
+
+// identityCache caches the identity tokens by audience.
+type identityCache struct {
+	mutex  sync.Mutex
+	tokens map[string]identityToken
+}
+
+// IdentityToken returns an identity token, a JWT signed by Google, of the instance's
+// default service account for audience. Tokens are cached and refreshed once they
+// are about to expire, so callers should call IdentityToken() for every use instead
+// of keeping the token around.
+func (c *Client) IdentityToken(ctx context.Context, audience string) (string, error) {
+	if audience == "" {
+		return "", fmt.Errorf("identity token audience must not be empty")
+	}
+
+	c.identity.mutex.Lock()
+	defer c.identity.mutex.Unlock()
+
+	if cached, found := c.identity.tokens[audience]; found && time.Until(cached.expiry) > identityRefreshMargin {
+		return cached.token, nil
+	}
+
+	reqURL, err := url.JoinPath(c.metadataURL, identityPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to form metadata url: %+v", err)
+	}
+	values := url.Values{}
+	values.Set("audience", audience)
+	values.Set("format", "full")
+
+	token, err := c.retry(ctx, requestConfig{baseURL: reqURL + "?" + values.Encode()})
+	if err != nil {
+		return "", fmt.Errorf("failed to get identity token: %w", err)
+	}
+	token = strings.TrimSpace(token)
+
+	expiry, err := tokenExpiry(token)
+	if err != nil {
+		return "", err
+	}
+
+	if c.identity.tokens == nil {
+		c.identity.tokens = make(map[string]identityToken)
+	}
+	c.identity.tokens[audience] = identityToken{token: token, expiry: expiry}
+	return token, nil
+}
+
+// tokenExpiry returns the expiry, the exp claim, of the JWT token. The token's
+// signature is not verified, it's verified by its audience.
+func tokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("identity token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode identity token payload: %w", err)
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse identity token claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("identity token has no expiry")
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
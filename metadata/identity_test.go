@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+func fakeJWT(exp time.Time, id int) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d,"jti":"%d"}`, exp.Unix(), id)))
+	return header + "." + payload + ".signature"
+}
+
+func TestIdentityToken(t *testing.T) {
+	var requests int
+	lifetime := time.Hour
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+identityPath || r.URL.Query().Get("audience") != "https://example.com" || r.URL.Query().Get("format") != "full" {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		fmt.Fprint(w, fakeJWT(time.Now().Add(lifetime), requests))
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+		retryPolicy: retry.Policy{MaxAttempts: 1},
+	}
+	ctx := context.Background()
+
+	first, err := client.IdentityToken(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("IdentityToken() failed unexpectedly with error: %v", err)
+	}
+
+	second, err := client.IdentityToken(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("IdentityToken() failed unexpectedly with error: %v", err)
+	}
+	if first != second || requests != 1 {
+		t.Errorf("IdentityToken() didn't return the cached token, made %d requests", requests)
+	}
+
+	// A token about to expire is refreshed.
+	client.identity.tokens["https://example.com"] = identityToken{token: first, expiry: time.Now().Add(identityRefreshMargin / 2)}
+	third, err := client.IdentityToken(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("IdentityToken() failed unexpectedly with error: %v", err)
+	}
+	if third == first || requests != 2 {
+		t.Errorf("IdentityToken() didn't refresh the token about to expire, made %d requests", requests)
+	}
+
+	if _, err := client.IdentityToken(ctx, "https://other.example.com"); err == nil {
+		t.Errorf("IdentityToken() succeeded for an unknown audience, want error")
+	}
+	if _, err := client.IdentityToken(ctx, ""); err == nil {
+		t.Errorf("IdentityToken() succeeded for an empty audience, want error")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Unix(1700000000, 0)
+	got, err := tokenExpiry(fakeJWT(exp, 1))
+	if err != nil {
+		t.Fatalf("tokenExpiry() failed unexpectedly with error: %v", err)
+	}
+	if !got.Equal(exp) {
+		t.Errorf("tokenExpiry() = %s, want %s", got, exp)
+	}
+
+	for _, token := range []string{"", "a.b", "a.!!!.c", "a." + base64.RawURLEncoding.EncodeToString([]byte(`{}`)) + ".c"} {
+		if _, err := tokenExpiry(token); err == nil {
+			t.Errorf("tokenExpiry(%q) succeeded, want error", token)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"net"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+var (
+	// interfaceAddrs lists the addresses of the instance's interfaces, replaceable
+	// by unit tests.
+	interfaceAddrs = net.InterfaceAddrs
+)
+
+// ipv6Only returns true if the instance has IPv6 addresses but no IPv4 address,
+// other than the loopback's, in which case the metadata server is only reachable
+// over its IPv6 address.
+func ipv6Only() bool {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		logger.Debugf("Failed to list interface addresses, assuming IPv4 is available: %v", err)
+		return false
+	}
+
+	var hasIPv6 bool
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return false
+		}
+		if !ipnet.IP.IsLinkLocalUnicast() {
+			hasIPv6 = true
+		}
+	}
+	return hasIPv6
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestIPv6Only(t *testing.T) {
+	cidr := func(s string) net.Addr {
+		ip, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatalf("net.ParseCIDR(%q) failed unexpectedly with error: %v", s, err)
+		}
+		ipnet.IP = ip
+		return ipnet
+	}
+
+	tests := []struct {
+		name  string
+		addrs []net.Addr
+		err   error
+		want  bool
+		url   string
+	}{
+		{
+			name:  "dual_stack",
+			addrs: []net.Addr{cidr("127.0.0.1/8"), cidr("10.128.0.2/32"), cidr("2600:1900::2/128")},
+			url:   defaultMetadataURL,
+		},
+		{
+			name:  "ipv6_only",
+			addrs: []net.Addr{cidr("127.0.0.1/8"), cidr("::1/128"), cidr("fe80::1/64"), cidr("2600:1900::2/128")},
+			want:  true,
+			url:   defaultMetadataURLv6,
+		},
+		{
+			name:  "link_local_only",
+			addrs: []net.Addr{cidr("127.0.0.1/8"), cidr("fe80::1/64")},
+			url:   defaultMetadataURL,
+		},
+		{
+			name: "error",
+			err:  fmt.Errorf("no interfaces"),
+			url:  defaultMetadataURL,
+		},
+	}
+
+	orig := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = orig })
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			interfaceAddrs = func() ([]net.Addr, error) { return tc.addrs, tc.err }
+
+			if got := ipv6Only(); got != tc.want {
+				t.Errorf("ipv6Only() = %t, want %t", got, tc.want)
+			}
+			if got := New().metadataURL; got != tc.url {
+				t.Errorf("New() allocated a client for %q, want %q", got, tc.url)
+			}
+		})
+	}
+}
@@ -18,13 +18,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-agent/retry"
@@ -35,6 +38,9 @@ const (
 	defaultMetadataURL = "http://169.254.169.254/computeMetadata/v1/"
 	defaultEtag        = "NONE"
 
+	// defaultMetadataURLv6 is the metadata server URL used by IPv6-only instances.
+	defaultMetadataURLv6 = "http://[fd20:ce::254]/computeMetadata/v1/"
+
 	// defaultHangtimeout is the timeout parameter passed to metadata as the hang timeout.
 	defaultHangTimeout = 60
 
@@ -48,6 +54,23 @@ var (
 	// we backoff until 10s
 	backoffDuration = 100 * time.Millisecond
 	backoffAttempts = 100
+	// backoffRandomization randomizes the backoff so clients don't retry in lockstep.
+	backoffRandomization = 0.2
+
+	// defaultURL is the metadata server URL of the clients allocated with New(),
+	// if empty defaultMetadataURL is used.
+	defaultURL string
+
+	// defaultRetryPolicy is the retry policy of the clients allocated with New(), if
+	// not set the policy is derived from the backoff variables.
+	defaultRetryPolicy *retry.Policy
+
+	// streamReconnectDelay is the delay before Stream() reconnects a failed longpoll.
+	streamReconnectDelay = 5 * time.Second
+
+	// ErrUnchanged is returned by Watch() when the longpoll returned the same
+	// descriptor, byte by byte, as the client's previous Get() or Watch().
+	ErrUnchanged = errors.New("metadata unchanged")
 )
 
 // MDSClientInterface is the minimum required Metadata Server interface for Guest Agent.
@@ -67,24 +90,104 @@ type requestConfig struct {
 	jsonOutput bool
 	timeout    int
 	headers    map[string]string
+	// trackEtag records the response's etag so the following longpoll only
+	// returns once the metadata changes.
+	trackEtag bool
+	// etagKey is the subtree whose etag is tracked, empty for the descriptor.
+	etagKey string
 }
 
 // Client defines the public interface between the core guest agent and
 // the metadata layer.
 type Client struct {
 	metadataURL string
-	etag        string
 	httpClient  *http.Client
+	retryPolicy retry.Policy
+
+	// mutex protects etag, lastDescriptor and subtrees.
+	mutex sync.Mutex
+	// etag is the etag of the last descriptor fetched.
+	etag string
+	// lastDescriptor is the body of the last descriptor fetched.
+	lastDescriptor string
+	// subtrees maps the subtrees watched with WatchKey() to their last fetch.
+	subtrees map[string]subtree
+
+	// decoder decodes the descriptors, reusing the sections that didn't change.
+	decoder descriptorDecoder
+
+	// identity caches the identity tokens returned by IdentityToken().
+	identity identityCache
+
+	// metrics records the client's request metrics.
+	metrics metricsRecorder
 }
 
 // New allocates and configures a new Client instance.
 func New() *Client {
+	return NewWithRetryPolicy(DefaultRetryPolicy())
+}
+
+// NewWithRetryPolicy allocates and configures a new Client instance retrying the
+// failed requests with policy. The policy's ShouldRetry and RetryAfter are set by
+// the client if not provided.
+func NewWithRetryPolicy(policy retry.Policy) *Client {
+	return NewWithTransport(policy, defaultTransport)
+}
+
+// NewWithTransport allocates and configures a new Client instance retrying the
+// failed requests with policy and sending them through transport, a nil transport
+// means http.DefaultTransport.
+func NewWithTransport(policy retry.Policy, transport http.RoundTripper) *Client {
+	metadataURL := defaultURL
+	if metadataURL == "" {
+		metadataURL = defaultMetadataURL
+		if ipv6Only() {
+			metadataURL = defaultMetadataURLv6
+		}
+	}
+
 	return &Client{
-		metadataURL: defaultMetadataURL,
+		metadataURL: metadataURL,
 		etag:        defaultEtag,
 		httpClient: &http.Client{
-			Timeout: defaultClientTimeout * time.Second,
+			Timeout:   defaultClientTimeout * time.Second,
+			Transport: transport,
 		},
+		retryPolicy: policy,
+	}
+}
+
+// SetDefaultURL sets the metadata server URL of the clients allocated with New()
+// from now on, i.e. to point them to a fake metadata server. An empty url restores
+// the default metadata server URL.
+func SetDefaultURL(url string) {
+	if url != "" && !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	defaultURL = url
+}
+
+// SetDefaultRetryPolicy sets the retry policy of the clients allocated with New()
+// from now on, a policy without attempts restores the built-in policy.
+func SetDefaultRetryPolicy(policy retry.Policy) {
+	if policy.MaxAttempts <= 0 {
+		defaultRetryPolicy = nil
+		return
+	}
+	defaultRetryPolicy = &policy
+}
+
+// DefaultRetryPolicy returns the retry policy of the clients allocated with New().
+func DefaultRetryPolicy() retry.Policy {
+	if defaultRetryPolicy != nil {
+		return *defaultRetryPolicy
+	}
+	return retry.Policy{
+		MaxAttempts:   backoffAttempts,
+		Jitter:        backoffDuration,
+		BackoffFactor: 1,
+		Randomization: backoffRandomization,
 	}
 }
 
@@ -129,6 +232,79 @@ func (m *Descriptor) UnmarshalJSON(b []byte) error {
 	return fmt.Errorf("JSON syntax error: %s \n%s\n%s^", err, b[start:end], strings.Repeat(" ", pos))
 }
 
+// HasTag returns true if the instance has the network tag tag.
+func (m *Descriptor) HasTag(tag string) bool {
+	return slices.Contains(m.Instance.Tags, tag)
+}
+
+// Label returns the value of the instance label key and whether it's set. Labels
+// are only available if served by the metadata server.
+func (m *Descriptor) Label(key string) (string, bool) {
+	value, found := m.Instance.Labels[key]
+	return value, found
+}
+
+// Scopes returns the OAuth scopes of the instance's default service account, nil
+// if the instance has no service account.
+func (m *Descriptor) Scopes() []string {
+	return m.Instance.ServiceAccounts["default"].Scopes
+}
+
+// unknownFields returns the top level fields of the JSON object b that are not
+// mapped to a field of the struct v, so they are not silently dropped.
+func unknownFields(b []byte, v any) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	// Like encoding/json, match the field names case insensitively.
+	known := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+
+	for key := range fields {
+		if known[strings.ToLower(key)] {
+			delete(fields, key)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// marshalWithUnknown marshals v, a struct, adding the unknown fields not mapped to
+// any of its fields, so they survive a marshaling round trip.
+func marshalWithUnknown(v any, unknown map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(unknown) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range unknown {
+		if _, found := fields[key]; !found {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
 type virtualClock struct {
 	DriftToken int `json:"drift-token"`
 }
@@ -136,11 +312,15 @@ type virtualClock struct {
 // Instance describes the metadata's instance attributes/keys.
 type Instance struct {
 	// ID is the instance ID.
-	ID json.Number
+	ID json.Number `json:",omitempty"`
 
 	// MachineType represents the instance's machine type.
 	MachineType string
 
+	// Hostname is the instance's fully qualified domain name, i.e.
+	// instance.c.project.internal.
+	Hostname string
+
 	// Attributes are the instance's attributes.
 	Attributes Attributes
 
@@ -152,6 +332,56 @@ type Instance struct {
 
 	// VirtualClock contains the drift-token attribute.
 	VirtualClock virtualClock
+
+	// Preempted is TRUE if the instance is being preempted, FALSE otherwise.
+	Preempted string
+
+	// Tags are the instance's network tags.
+	Tags []string
+
+	// Labels are the instance's labels, if served by the metadata server.
+	Labels map[string]string
+
+	// ServiceAccounts maps the instance's service accounts, by email and the "default"
+	// alias, to their details.
+	ServiceAccounts map[string]ServiceAccount
+
+	// Unknown holds the instance keys not mapped to any of the fields above.
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON unmarshals b into Instance, retaining the unknown keys.
+func (i *Instance) UnmarshalJSON(b []byte) error {
+	type temp Instance
+	var t temp
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+
+	unknown, err := unknownFields(b, t)
+	if err != nil {
+		return err
+	}
+
+	*i = Instance(t)
+	i.Unknown = unknown
+	return nil
+}
+
+// MarshalJSON marshals i, including the unknown keys.
+func (i Instance) MarshalJSON() ([]byte, error) {
+	type temp Instance
+	return marshalWithUnknown(temp(i), i.Unknown)
+}
+
+// ServiceAccount describes a service account attached to the instance.
+type ServiceAccount struct {
+	// Aliases are the service account's aliases, i.e. default.
+	Aliases []string
+	// Email is the service account's email.
+	Email string
+	// Scopes are the OAuth scopes granted to the service account.
+	Scopes []string
 }
 
 // NetworkInterfaces describes the instances network interfaces configurations.
@@ -160,6 +390,10 @@ type NetworkInterfaces struct {
 	ForwardedIpv6s    []string
 	TargetInstanceIps []string
 	IPAliases         []string
+	IPv6Aliases       []string
+	IP                string
+	Gateway           string
+	Subnetmask        string
 	Mac               string
 	DHCPv6Refresh     string
 	MTU               int
@@ -200,7 +434,34 @@ type VlanInterface struct {
 type Project struct {
 	Attributes       Attributes
 	ProjectID        string
-	NumericProjectID json.Number
+	NumericProjectID json.Number `json:",omitempty"`
+
+	// Unknown holds the project keys not mapped to any of the fields above.
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON unmarshals b into Project, retaining the unknown keys.
+func (p *Project) UnmarshalJSON(b []byte) error {
+	type temp Project
+	var t temp
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+
+	unknown, err := unknownFields(b, t)
+	if err != nil {
+		return err
+	}
+
+	*p = Project(t)
+	p.Unknown = unknown
+	return nil
+}
+
+// MarshalJSON marshals p, including the unknown keys.
+func (p Project) MarshalJSON() ([]byte, error) {
+	type temp Project
+	return marshalWithUnknown(temp(p), p.Unknown)
 }
 
 // Attributes describes the project's attributes keys.
@@ -223,6 +484,50 @@ type Attributes struct {
 	WSFCAddresses             string
 	WSFCAgentPort             string
 	DisableTelemetry          bool
+	GuestAgentFeatures        string
+	EnableWindowsDSC          *bool
+	WindowsDSCConfig          string
+	InstanceConfigs           string
+	EnableScheduledTasks      *bool
+	ScheduledTasks            string
+	// DNSNameservers are the comma or space separated nameservers the DNS manager
+	// sets.
+	DNSNameservers string
+	// DNSSearchDomains are the comma or space separated search domains the DNS
+	// manager sets.
+	DNSSearchDomains string
+}
+
+// attributesJSON is the metadata server's representation of Attributes, the
+// booleans and the ssh keys are strings.
+type attributesJSON struct {
+	BlockProjectKeys          string      `json:"block-project-ssh-keys,omitempty"`
+	Diagnostics               string      `json:"diagnostics,omitempty"`
+	DisableAccountManager     string      `json:"disable-account-manager,omitempty"`
+	DisableAddressManager     string      `json:"disable-address-manager,omitempty"`
+	EnableDiagnostics         string      `json:"enable-diagnostics,omitempty"`
+	EnableOSLogin             string      `json:"enable-oslogin,omitempty"`
+	EnableWindowsSSH          string      `json:"enable-windows-ssh,omitempty"`
+	EnableWSFC                string      `json:"enable-wsfc,omitempty"`
+	OldSSHKeys                string      `json:"sshKeys,omitempty"`
+	SSHKeys                   string      `json:"ssh-keys,omitempty"`
+	TwoFactor                 string      `json:"enable-oslogin-2fa,omitempty"`
+	SecurityKey               string      `json:"enable-oslogin-sk,omitempty"`
+	RequireCerts              string      `json:"enable-oslogin-certificates,omitempty"`
+	WindowsKeys               WindowsKeys `json:"windows-keys,omitempty"`
+	WSFCAddresses             string      `json:"wsfc-addrs,omitempty"`
+	WSFCAgentPort             string      `json:"wsfc-agent-port,omitempty"`
+	DisableTelemetry          string      `json:"disable-guest-telemetry,omitempty"`
+	DisableHTTPSMdsSetup      string      `json:"disable-https-mds-setup,omitempty"`
+	HTTPSMDSEnableNativeStore string      `json:"enable-https-mds-native-cert-store,omitempty"`
+	GuestAgentFeatures        string      `json:"guest-agent-features,omitempty"`
+	EnableWindowsDSC          string      `json:"enable-windows-dsc,omitempty"`
+	WindowsDSCConfig          string      `json:"windows-dsc-config,omitempty"`
+	InstanceConfigs           string      `json:"instance-configs,omitempty"`
+	EnableScheduledTasks      string      `json:"enable-scheduled-tasks,omitempty"`
+	ScheduledTasks            string      `json:"scheduled-tasks,omitempty"`
+	DNSNameservers            string      `json:"dns-nameservers,omitempty"`
+	DNSSearchDomains          string      `json:"dns-search-domains,omitempty"`
 }
 
 // UnmarshalJSON unmarshals b into Attribute.
@@ -233,28 +538,7 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 		return res
 	}
 	// Unmarshal to literal JSON types before doing anything else.
-	type inner struct {
-		BlockProjectKeys          string      `json:"block-project-ssh-keys"`
-		Diagnostics               string      `json:"diagnostics"`
-		DisableAccountManager     string      `json:"disable-account-manager"`
-		DisableAddressManager     string      `json:"disable-address-manager"`
-		EnableDiagnostics         string      `json:"enable-diagnostics"`
-		EnableOSLogin             string      `json:"enable-oslogin"`
-		EnableWindowsSSH          string      `json:"enable-windows-ssh"`
-		EnableWSFC                string      `json:"enable-wsfc"`
-		OldSSHKeys                string      `json:"sshKeys"`
-		SSHKeys                   string      `json:"ssh-keys"`
-		TwoFactor                 string      `json:"enable-oslogin-2fa"`
-		SecurityKey               string      `json:"enable-oslogin-sk"`
-		RequireCerts              string      `json:"enable-oslogin-certificates"`
-		WindowsKeys               WindowsKeys `json:"windows-keys"`
-		WSFCAddresses             string      `json:"wsfc-addrs"`
-		WSFCAgentPort             string      `json:"wsfc-agent-port"`
-		DisableTelemetry          string      `json:"disable-guest-telemetry"`
-		DisableHTTPSMdsSetup      string      `json:"disable-https-mds-setup"`
-		HTTPSMDSEnableNativeStore string      `json:"enable-https-mds-native-cert-store"`
-	}
-	var temp inner
+	var temp attributesJSON
 	if err := json.Unmarshal(b, &temp); err != nil {
 		return err
 	}
@@ -262,6 +546,12 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 	a.WSFCAddresses = temp.WSFCAddresses
 	a.WSFCAgentPort = temp.WSFCAgentPort
 	a.WindowsKeys = temp.WindowsKeys
+	a.GuestAgentFeatures = temp.GuestAgentFeatures
+	a.WindowsDSCConfig = temp.WindowsDSCConfig
+	a.InstanceConfigs = temp.InstanceConfigs
+	a.ScheduledTasks = temp.ScheduledTasks
+	a.DNSNameservers = temp.DNSNameservers
+	a.DNSSearchDomains = temp.DNSSearchDomains
 
 	value, err := strconv.ParseBool(temp.DisableHTTPSMdsSetup)
 	if err == nil {
@@ -295,6 +585,14 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 	if err == nil {
 		a.EnableWindowsSSH = mkbool(value)
 	}
+	value, err = strconv.ParseBool(temp.EnableWindowsDSC)
+	if err == nil {
+		a.EnableWindowsDSC = mkbool(value)
+	}
+	value, err = strconv.ParseBool(temp.EnableScheduledTasks)
+	if err == nil {
+		a.EnableScheduledTasks = mkbool(value)
+	}
 	value, err = strconv.ParseBool(temp.EnableWSFC)
 	if err == nil {
 		a.EnableWSFC = mkbool(value)
@@ -326,13 +624,111 @@ func (a *Attributes) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (c *Client) updateEtag(resp *http.Response) bool {
-	oldEtag := c.etag
-	c.etag = resp.Header.Get("etag")
-	if c.etag == "" {
-		c.etag = defaultEtag
+// MarshalJSON marshals a in the metadata server's representation, so it's
+// unmarshaled back by UnmarshalJSON().
+func (a Attributes) MarshalJSON() ([]byte, error) {
+	var formatBool = func(value *bool) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatBool(*value)
+	}
+
+	temp := attributesJSON{
+		Diagnostics:               a.Diagnostics,
+		DisableAccountManager:     formatBool(a.DisableAccountManager),
+		DisableAddressManager:     formatBool(a.DisableAddressManager),
+		EnableDiagnostics:         formatBool(a.EnableDiagnostics),
+		EnableOSLogin:             formatBool(a.EnableOSLogin),
+		EnableWindowsSSH:          formatBool(a.EnableWindowsSSH),
+		EnableWSFC:                formatBool(a.EnableWSFC),
+		SSHKeys:                   strings.Join(a.SSHKeys, "\n"),
+		TwoFactor:                 formatBool(a.TwoFactor),
+		SecurityKey:               formatBool(a.SecurityKey),
+		RequireCerts:              formatBool(a.RequireCerts),
+		WindowsKeys:               a.WindowsKeys,
+		WSFCAddresses:             a.WSFCAddresses,
+		WSFCAgentPort:             a.WSFCAgentPort,
+		DisableHTTPSMdsSetup:      formatBool(a.DisableHTTPSMdsSetup),
+		HTTPSMDSEnableNativeStore: formatBool(a.HTTPSMDSEnableNativeStore),
+		GuestAgentFeatures:        a.GuestAgentFeatures,
+		EnableWindowsDSC:          formatBool(a.EnableWindowsDSC),
+		WindowsDSCConfig:          a.WindowsDSCConfig,
+		InstanceConfigs:           a.InstanceConfigs,
+		EnableScheduledTasks:      formatBool(a.EnableScheduledTasks),
+		ScheduledTasks:            a.ScheduledTasks,
+		DNSNameservers:            a.DNSNameservers,
+		DNSSearchDomains:          a.DNSSearchDomains,
+	}
+	if a.BlockProjectKeys {
+		temp.BlockProjectKeys = "true"
+	}
+	if a.DisableTelemetry {
+		temp.DisableTelemetry = "true"
+	}
+	return json.Marshal(temp)
+}
+
+// Merge fills the attributes not set in a with the values set in other, attributes
+// already set in a always take precedence. Note that non pointer booleans are
+// considered unset when false.
+func (a *Attributes) Merge(other Attributes) {
+	dst := reflect.ValueOf(a).Elem()
+	src := reflect.ValueOf(other)
+
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// subtree is the last fetch of a subtree watched with WatchKey().
+type subtree struct {
+	etag string
+	body string
+}
+
+// lastEtag returns the etag of the last fetch of the subtree key, or of the last
+// descriptor fetched if key is empty.
+func (c *Client) lastEtag(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	etag := c.etag
+	if key != "" {
+		etag = c.subtrees[key].etag
+	}
+
+	if etag == "" {
+		return defaultEtag
+	}
+	return etag
+}
+
+// updateEtag records the etag of resp as the last etag of the subtree key, or of
+// the descriptor if key is empty. It returns true if the etag changed.
+func (c *Client) updateEtag(key string, resp *http.Response) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	etag := resp.Header.Get("etag")
+	if etag == "" {
+		etag = defaultEtag
+	}
+
+	if key == "" {
+		oldEtag := c.etag
+		c.etag = etag
+		return etag != oldEtag
+	}
+
+	if c.subtrees == nil {
+		c.subtrees = make(map[string]subtree)
 	}
-	return c.etag != oldEtag
+	last := c.subtrees[key]
+	c.subtrees[key] = subtree{etag: etag, body: last.body}
+	return etag != last.etag
 }
 
 // MDSReqError represents custom error produced by HTTP requests made on MDS. It captures
@@ -340,6 +736,9 @@ func (c *Client) updateEtag(resp *http.Response) bool {
 type MDSReqError struct {
 	status int
 	err    error
+	// retryAfter is the interval requested by the Retry-After header of a 429 or
+	// 503 response, zero if not requested.
+	retryAfter time.Duration
 }
 
 // Error implements method defined on error interface to transform custom type into error.
@@ -361,26 +760,69 @@ func shouldRetry(err error) bool {
 	return !slices.Contains(codes, e.status)
 }
 
+// retryAfter returns the interval requested by err, if err is a MDSReqError
+// carrying a Retry-After header.
+func retryAfter(err error) time.Duration {
+	var e *MDSReqError
+	if !errors.As(err, &e) {
+		return 0
+	}
+	return e.retryAfter
+}
+
+// parseRetryAfter parses the Retry-After header of a throttled (429) or unavailable
+// (503) response, the header holds either a number of seconds or a HTTP date.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
 func (c *Client) retry(ctx context.Context, cfg requestConfig) (string, error) {
-	policy := retry.Policy{MaxAttempts: backoffAttempts, Jitter: backoffDuration, BackoffFactor: 1, ShouldRetry: shouldRetry}
+	policy := c.retryPolicy
+	if policy.MaxAttempts == 0 {
+		policy = DefaultRetryPolicy()
+	}
+	if policy.ShouldRetry == nil {
+		policy.ShouldRetry = shouldRetry
+	}
+	if policy.RetryAfter == nil {
+		policy.RetryAfter = retryAfter
+	}
 
 	fn := func() (string, error) {
 		resp, err := c.do(ctx, cfg)
 		if err != nil {
-			statusCode := -1
+			reqErr := &MDSReqError{status: -1, err: err}
 			if resp != nil {
-				statusCode = resp.StatusCode
+				reqErr.status = resp.StatusCode
+				reqErr.retryAfter = parseRetryAfter(resp)
 			}
-			return "", &MDSReqError{statusCode, err}
+			return "", reqErr
 		}
 		defer resp.Body.Close()
 
-		md, err := io.ReadAll(resp.Body)
+		md, err := readBody(resp.Body)
 		if err != nil {
 			return "", fmt.Errorf("failed to read metadata server response bytes: %+v", err)
 		}
 
-		return string(md), nil
+		return md, nil
 	}
 
 	return retry.RunWithResponse(ctx, policy, fn)
@@ -420,17 +862,103 @@ func (c *Client) Watch(ctx context.Context) (*Descriptor, error) {
 	return c.get(ctx, true)
 }
 
+// WatchKey runs a longpoll on the metadata subtree key, i.e. instance/attributes/ssh-keys,
+// and returns its JSON value. Each subtree tracks its own etag so a change to some
+// other key doesn't wake the longpoll up. A longpoll returning the same value as the
+// previous WatchKey() of key returns ErrUnchanged.
+func (c *Client) WatchKey(ctx context.Context, key string) (string, error) {
+	key = strings.Trim(key, "/")
+	reqURL, err := url.JoinPath(c.metadataURL, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to form metadata url: %+v", err)
+	}
+
+	cfg := requestConfig{
+		baseURL:    reqURL,
+		hang:       true,
+		timeout:    defaultHangTimeout,
+		recursive:  true,
+		jsonOutput: true,
+		trackEtag:  true,
+		etagKey:    key,
+	}
+
+	resp, err := c.retry(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	if c.subtrees == nil {
+		c.subtrees = make(map[string]subtree)
+	}
+	last := c.subtrees[key]
+	changed := resp != last.body
+	c.subtrees[key] = subtree{etag: last.etag, body: resp}
+	c.mutex.Unlock()
+
+	if !changed {
+		return "", ErrUnchanged
+	}
+	return resp, nil
+}
+
+// Stream runs longpolls on the metadata server until ctx is canceled, sending
+// each new descriptor to the returned channel, the first one being the current
+// descriptor. Unchanged descriptors are not sent and failed longpolls, after
+// their retries are exhausted, are reconnected after streamReconnectDelay. The
+// channel is closed once ctx is canceled.
+func (c *Client) Stream(ctx context.Context) <-chan *Descriptor {
+	descriptors := make(chan *Descriptor)
+
+	go func() {
+		defer close(descriptors)
+
+		for ctx.Err() == nil {
+			md, err := c.Watch(ctx)
+			if errors.Is(err, ErrUnchanged) {
+				continue
+			}
+
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Debugf("Metadata longpoll failed, reconnecting in %s: %v", streamReconnectDelay, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(streamReconnectDelay):
+				}
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case descriptors <- md:
+			}
+		}
+	}()
+
+	return descriptors
+}
+
 // Get does a metadata call, if hang is set to true then it will do a longpoll.
 func (c *Client) Get(ctx context.Context) (*Descriptor, error) {
 	return c.get(ctx, false)
 }
 
+// get fetches the descriptor, both Get() and Watch() record the descriptor's etag
+// so a longpoll following either waits for the metadata to change. A longpoll
+// returning the same descriptor, i.e. when it times out, returns ErrUnchanged.
 func (c *Client) get(ctx context.Context, hang bool) (*Descriptor, error) {
 	cfg := requestConfig{
 		baseURL:    c.metadataURL,
 		timeout:    defaultHangTimeout,
 		recursive:  true,
 		jsonOutput: true,
+		trackEtag:  true,
 	}
 
 	if hang {
@@ -442,41 +970,33 @@ func (c *Client) get(ctx context.Context, hang bool) (*Descriptor, error) {
 		return nil, err
 	}
 
-	var ret Descriptor
-	if err = json.Unmarshal([]byte(resp), &ret); err != nil {
-		return nil, err
-	}
-
-	return &ret, nil
-}
+	c.mutex.Lock()
+	changed := resp != c.lastDescriptor
+	c.lastDescriptor = resp
+	c.mutex.Unlock()
 
-// WriteGuestAttributes does a put call to mds changing a guest attribute value.
-func (c *Client) WriteGuestAttributes(ctx context.Context, key, value string) error {
-	logger.Debugf("write guest attribute %q", key)
+	if !changed && hang {
+		return nil, ErrUnchanged
+	}
 
-	finalURL, err := url.JoinPath(c.metadataURL, "instance/guest-attributes/", key)
+	ret, err := c.decoder.decode(resp)
 	if err != nil {
-		return fmt.Errorf("failed to form metadata url: %+v", err)
+		return nil, err
 	}
 
-	logger.Debugf("Requesting(PUT) MDS URL: %s", finalURL)
-
-	// This is a arbitrary retry number.
-	policy := retry.Policy{MaxAttempts: 10, Jitter: backoffDuration, BackoffFactor: 1}
-
-	putCall := func() error {
-		req, err := http.NewRequest("PUT", finalURL, strings.NewReader(value))
-		if err != nil {
-			return err
+	if changed {
+		if err := writeCache(resp); err != nil {
+			logger.Debugf("Failed to write metadata cache: %v", err)
 		}
-		req.Header.Add("Metadata-Flavor", "Google")
-		req = req.WithContext(ctx)
-		_, err = c.httpClient.Do(req)
-
-		return err
 	}
 
-	return retry.Run(ctx, policy, putCall)
+	return ret, nil
+}
+
+// WriteGuestAttributes does a put call to mds changing a guest attribute value.
+func (c *Client) WriteGuestAttributes(ctx context.Context, key, value string) error {
+	logger.Debugf("write guest attribute %q", key)
+	return c.guestAttributeRequest(ctx, http.MethodPut, key, value)
 }
 
 func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, error) {
@@ -489,7 +1009,7 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 
 	if cfg.hang {
 		values.Add("wait_for_change", "true")
-		values.Add("last_etag", c.etag)
+		values.Add("last_etag", c.lastEtag(cfg.etagKey))
 	}
 
 	if cfg.timeout > 0 {
@@ -516,8 +1036,17 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 	for k, v := range cfg.headers {
 		req.Header.Add(k, v)
 	}
+
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 
+	status := -1
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	failed := err != nil || status != http.StatusOK
+	c.metrics.record(c.metricsPath(cfg.baseURL), cfg.hang, status, failed, time.Since(start))
+
 	// If we are canceling httpClient will also wrap the context's error so
 	// check first the context.
 	if ctx.Err() != nil {
@@ -539,8 +1068,8 @@ func (c *Client) do(ctx context.Context, cfg requestConfig) (*http.Response, err
 		return resp, fmt.Errorf("invalid response from metadata server, status code: %d, reason: %s", resp.StatusCode, string(r))
 	}
 
-	if cfg.hang {
-		c.updateEtag(resp)
+	if cfg.trackEtag && c.updateEtag(cfg.etagKey, resp) {
+		c.metrics.recordEtagChange()
 	}
 
 	return resp, nil
@@ -17,15 +17,18 @@ package metadata
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -39,7 +42,7 @@ func TestWatchMetadata(t *testing.T) {
 		} else {
 			w.Header().Set("etag", etag2)
 		}
-		fmt.Fprintf(w, `{"instance":{"attributes":{"enable-oslogin":"true","ssh-keys":"name:ssh-rsa [KEY] hostname\nname:ssh-rsa [KEY] hostname","windows-keys":"{}\n{\"expireOn\":\"%[1]s\",\"exponent\":\"exponent\",\"modulus\":\"modulus\",\"username\":\"username\"}\n{\"expireOn\":\"%[1]s\",\"exponent\":\"exponent\",\"modulus\":\"modulus\",\"username\":\"username\",\"addToAdministrators\":true}","wsfc-addrs":"foo"}}}`, et)
+		fmt.Fprintf(w, `{"instance":{"id":%[2]d,"attributes":{"enable-oslogin":"true","ssh-keys":"name:ssh-rsa [KEY] hostname\nname:ssh-rsa [KEY] hostname","windows-keys":"{}\n{\"expireOn\":\"%[1]s\",\"exponent\":\"exponent\",\"modulus\":\"modulus\",\"username\":\"username\"}\n{\"expireOn\":\"%[1]s\",\"exponent\":\"exponent\",\"modulus\":\"modulus\",\"username\":\"username\",\"addToAdministrators\":true}\n{\"expireOn\":\"%[1]s\",\"publicKey\":\"publickey\",\"username\":\"username\",\"version\":2}","wsfc-addrs":"foo"}}}`, et, req)
 		req++
 	}))
 	defer ts.Close()
@@ -59,6 +62,7 @@ func TestWatchMetadata(t *testing.T) {
 		WindowsKeys: WindowsKeys{
 			WindowsKey{Exponent: "exponent", UserName: "username", Modulus: "modulus", ExpireOn: et, AddToAdministrators: nil},
 			WindowsKey{Exponent: "exponent", UserName: "username", Modulus: "modulus", ExpireOn: et, AddToAdministrators: func() *bool { ret := true; return &ret }()},
+			WindowsKey{PublicKey: "publickey", UserName: "username", ExpireOn: et, Version: 2},
 		},
 		SSHKeys:          []string{"name:ssh-rsa [KEY] hostname", "name:ssh-rsa [KEY] hostname"},
 		DisableTelemetry: false,
@@ -80,6 +84,101 @@ func TestWatchMetadata(t *testing.T) {
 	}
 }
 
+func TestWatchUnchanged(t *testing.T) {
+	var lastEtags []string
+	body := `{"instance":{"id":1}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait_for_change") == "true" {
+			lastEtags = append(lastEtags, r.URL.Query().Get("last_etag"))
+		}
+		w.Header().Set("etag", "etag1")
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	ctx := context.Background()
+	if _, err := client.Get(ctx); err != nil {
+		t.Fatalf("Get() failed unexpectedly with error: %v", err)
+	}
+
+	// The longpoll timed out returning the same descriptor.
+	if _, err := client.Watch(ctx); !errors.Is(err, ErrUnchanged) {
+		t.Errorf("Watch() returned error: %v, want: %v", err, ErrUnchanged)
+	}
+
+	body = `{"instance":{"id":2}}`
+	got, err := client.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() failed unexpectedly with error: %v", err)
+	}
+	if got.Instance.ID != "2" {
+		t.Errorf("Watch() returned instance ID %q, want %q", got.Instance.ID, "2")
+	}
+
+	// Both longpolls must wait for changes from the descriptor fetched by Get().
+	if diff := cmp.Diff([]string{"etag1", "etag1"}, lastEtags); diff != "" {
+		t.Errorf("Watch() sent unexpected last_etag (-want +got):\n%s", diff)
+	}
+}
+
+func TestStream(t *testing.T) {
+	oldDelay := streamReconnectDelay
+	t.Cleanup(func() { streamReconnectDelay = oldDelay })
+	streamReconnectDelay = time.Millisecond
+
+	// The server fails once, then times out a longpoll returning the same
+	// descriptor before the descriptor changes.
+	responses := []string{"", `{"instance":{"id":1}}`, `{"instance":{"id":1}}`, `{"instance":{"id":2}}`}
+	var mutex sync.Mutex
+	var req int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		curr := responses[min(req, len(responses)-1)]
+		req++
+		if curr == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, curr)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	descriptors := client.Stream(ctx)
+
+	for _, want := range []string{"1", "2"} {
+		select {
+		case md := <-descriptors:
+			if md.Instance.ID.String() != want {
+				t.Errorf("Stream() sent instance ID %q, want %q", md.Instance.ID, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Stream() didn't send the descriptor with instance ID %q", want)
+		}
+	}
+
+	cancel()
+	for range descriptors {
+		// Drain the descriptors sent before the cancelation.
+	}
+}
+
 func TestBlockProjectKeys(t *testing.T) {
 	tests := []struct {
 		json string
@@ -205,7 +304,7 @@ func TestShouldRetry(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			err := &MDSReqError{test.status, test.err}
+			err := &MDSReqError{status: test.status, err: test.err}
 			if got := shouldRetry(err); got != test.want {
 				t.Errorf("shouldRetry(%+v) = %t, want %t", err, got, test.want)
 			}
@@ -254,6 +353,84 @@ func TestRetry(t *testing.T) {
 	}
 }
 
+func TestRetryAfter(t *testing.T) {
+	ctr := 0
+	var requested []time.Duration
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctr++
+		if ctr == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "some-metadata")
+	}))
+	defer ts.Close()
+
+	policy := retry.Policy{MaxAttempts: 2, BackoffFactor: 1, Jitter: time.Millisecond}
+	policy.RetryAfter = func(err error) time.Duration {
+		d := retryAfter(err)
+		requested = append(requested, d)
+		// Don't actually wait in the test.
+		return 0
+	}
+
+	client := NewWithRetryPolicy(policy)
+	client.metadataURL = ts.URL
+
+	if _, err := client.GetKey(context.Background(), "key", nil); err != nil {
+		t.Fatalf("GetKey() failed unexpectedly with error: %v", err)
+	}
+
+	want := []time.Duration{time.Second}
+	if !reflect.DeepEqual(requested, want) {
+		t.Errorf("GetKey() requested retry intervals %v, want %v", requested, want)
+	}
+}
+
+func TestSetDefaultRetryPolicy(t *testing.T) {
+	t.Cleanup(func() { SetDefaultRetryPolicy(retry.Policy{}) })
+
+	policy := retry.Policy{MaxAttempts: 3, BackoffFactor: 2, Jitter: time.Millisecond}
+	SetDefaultRetryPolicy(policy)
+	if got := New().retryPolicy; !reflect.DeepEqual(got, policy) {
+		t.Errorf("New() allocated a client with retry policy %+v, want %+v", got, policy)
+	}
+
+	SetDefaultRetryPolicy(retry.Policy{})
+	if got := New().retryPolicy; got.MaxAttempts != backoffAttempts {
+		t.Errorf("New() allocated a client with %d attempts after the default policy was reset, want %d", got.MaxAttempts, backoffAttempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		desc   string
+		status int
+		header string
+		want   time.Duration
+	}{
+		{"seconds", http.StatusTooManyRequests, "5", 5 * time.Second},
+		{"unavailable", http.StatusServiceUnavailable, "2", 2 * time.Second},
+		{"not_throttled", http.StatusInternalServerError, "5", 0},
+		{"no_header", http.StatusTooManyRequests, "", 0},
+		{"invalid", http.StatusTooManyRequests, "soon", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+			if test.header != "" {
+				resp.Header.Set("Retry-After", test.header)
+			}
+			if got := parseRetryAfter(resp); got != test.want {
+				t.Errorf("parseRetryAfter(%d, %q) = %s, want %s", test.status, test.header, got, test.want)
+			}
+		})
+	}
+}
+
 func TestRetryError(t *testing.T) {
 	ctx := context.Background()
 	ctr := make(map[string]int)
@@ -353,3 +530,189 @@ func TestVlanInterfaces(t *testing.T) {
 		t.Errorf("json.Unmarshal(%s, &md) returned unexpected diff (-want,+got):\n %s", cfg, diff)
 	}
 }
+
+func TestAttributesMerge(t *testing.T) {
+	truebool, falsebool := true, false
+
+	attrs := Attributes{
+		EnableOSLogin: &falsebool,
+		SSHKeys:       []string{"name:ssh-rsa [KEY] project"},
+	}
+
+	other := Attributes{
+		EnableOSLogin:    &truebool,
+		EnableWSFC:       &truebool,
+		SSHKeys:          []string{"name:ssh-rsa [KEY] other"},
+		WSFCAgentPort:    "1234",
+		DisableTelemetry: true,
+	}
+
+	want := Attributes{
+		EnableOSLogin:    &falsebool,
+		EnableWSFC:       &truebool,
+		SSHKeys:          []string{"name:ssh-rsa [KEY] project"},
+		WSFCAgentPort:    "1234",
+		DisableTelemetry: true,
+	}
+
+	attrs.Merge(other)
+	if diff := cmp.Diff(want, attrs); diff != "" {
+		t.Errorf("Merge() returned unexpected diff (-want,+got):\n %s", diff)
+	}
+}
+
+func TestDescriptorAccessors(t *testing.T) {
+	data := `{
+  "instance": {
+    "id": 1234,
+    "tags": ["http-server", "ssh"],
+    "labels": {"env": "prod"},
+    "serviceAccounts": {
+      "default": {"aliases": ["default"], "email": "sa@project.iam.gserviceaccount.com", "scopes": ["https://www.googleapis.com/auth/cloud-platform"]}
+    },
+    "hostname": "instance.c.project.internal",
+    "cpuPlatform": "Intel Broadwell",
+    "zone": "projects/1234/zones/us-central1-a"
+  },
+  "project": {
+    "projectId": "project",
+    "numericProjectId": 1234,
+    "newField": true
+  }
+}`
+
+	var md Descriptor
+	if err := json.Unmarshal([]byte(data), &md); err != nil {
+		t.Fatalf("json.Unmarshal() failed unexpectedly with error: %v", err)
+	}
+
+	if !md.HasTag("ssh") || md.HasTag("https-server") {
+		t.Errorf("HasTag() doesn't match the instance tags %v", md.Instance.Tags)
+	}
+
+	if value, found := md.Label("env"); !found || value != "prod" {
+		t.Errorf("Label(%q) = (%q, %t), want (%q, true)", "env", value, found, "prod")
+	}
+	if _, found := md.Label("team"); found {
+		t.Errorf("Label(%q) found a label that's not set", "team")
+	}
+
+	if diff := cmp.Diff([]string{"https://www.googleapis.com/auth/cloud-platform"}, md.Scopes()); diff != "" {
+		t.Errorf("Scopes() returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if got := (&Descriptor{}).Scopes(); got != nil {
+		t.Errorf("Scopes() without service accounts = %v, want nil", got)
+	}
+
+	if want := "instance.c.project.internal"; md.Instance.Hostname != want {
+		t.Errorf("Instance.Hostname = %q, want %q", md.Instance.Hostname, want)
+	}
+
+	wantInstance := map[string]json.RawMessage{
+		"cpuPlatform": json.RawMessage(`"Intel Broadwell"`),
+		"zone":        json.RawMessage(`"projects/1234/zones/us-central1-a"`),
+	}
+	if diff := cmp.Diff(wantInstance, md.Instance.Unknown); diff != "" {
+		t.Errorf("Instance.Unknown returned unexpected diff (-want +got):\n%s", diff)
+	}
+
+	wantProject := map[string]json.RawMessage{"newField": json.RawMessage(`true`)}
+	if diff := cmp.Diff(wantProject, md.Project.Unknown); diff != "" {
+		t.Errorf("Project.Unknown returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDescriptorMarshalRoundTrip(t *testing.T) {
+	data := `{
+  "instance": {
+    "id": 1234,
+    "attributes": {
+      "enable-oslogin": "true",
+      "ssh-keys": "user1:ssh-ed25519 AAAA user1\nuser2:ssh-rsa BBBB user2",
+      "windows-keys": "{\"userName\":\"admin\",\"modulus\":\"abc\",\"exponent\":\"AQAB\",\"email\":\"admin@example.com\",\"expireOn\":\"2099-01-01T00:00:00Z\"}",
+      "disable-guest-telemetry": "true",
+      "dns-nameservers": "169.254.169.254 10.0.0.53",
+      "dns-search-domains": "example.internal"
+    },
+    "networkInterfaces": [{"mac": "42:01:0a:00:00:02", "forwardedIps": ["10.0.0.10"], "mtu": 1460}],
+    "virtualClock": {"drift-token": 5},
+    "hostname": "instance.c.project.internal"
+  },
+  "project": {
+    "projectId": "project",
+    "attributes": {"block-project-ssh-keys": "true", "enable-oslogin-2fa": "false"},
+    "newField": true
+  }
+}`
+
+	var md Descriptor
+	if err := json.Unmarshal([]byte(data), &md); err != nil {
+		t.Fatalf("json.Unmarshal() failed unexpectedly with error: %v", err)
+	}
+
+	if len(md.Instance.Attributes.WindowsKeys) != 1 {
+		t.Fatalf("json.Unmarshal() returned %d windows keys, want 1", len(md.Instance.Attributes.WindowsKeys))
+	}
+	if got, want := md.Instance.Attributes.DNSNameservers, "169.254.169.254 10.0.0.53"; got != want {
+		t.Errorf("json.Unmarshal() returned DNS nameservers %q, want %q", got, want)
+	}
+
+	encoded, err := json.Marshal(&md)
+	if err != nil {
+		t.Fatalf("json.Marshal(%+v) failed unexpectedly with error: %v", md, err)
+	}
+
+	var got Descriptor
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed unexpectedly with error: %v", encoded, err)
+	}
+
+	if diff := cmp.Diff(md, got); diff != "" {
+		t.Errorf("Descriptor marshaling round trip returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchKey(t *testing.T) {
+	var lastEtags []string
+	body := `"key1"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/instance/attributes/ssh-keys" {
+			http.NotFound(w, r)
+			return
+		}
+		lastEtags = append(lastEtags, r.URL.Query().Get("last_etag"))
+		w.Header().Set("etag", body)
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		etag:        "descriptor",
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	ctx := context.Background()
+	got, err := client.WatchKey(ctx, "/instance/attributes/ssh-keys/")
+	if err != nil {
+		t.Fatalf("WatchKey() failed unexpectedly with error: %v", err)
+	}
+	if got != body {
+		t.Errorf("WatchKey() = %q, want %q", got, body)
+	}
+
+	if _, err := client.WatchKey(ctx, "instance/attributes/ssh-keys"); !errors.Is(err, ErrUnchanged) {
+		t.Errorf("WatchKey() returned error: %v, want: %v", err, ErrUnchanged)
+	}
+
+	// The subtree's etag is tracked apart from the descriptor's.
+	if diff := cmp.Diff([]string{defaultEtag, `"key1"`}, lastEtags); diff != "" {
+		t.Errorf("WatchKey() sent unexpected last_etag (-want +got):\n%s", diff)
+	}
+	if client.etag != "descriptor" {
+		t.Errorf("WatchKey() changed the descriptor etag to %q", client.etag)
+	}
+}
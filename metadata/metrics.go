@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// requestBuckets are the upper bounds of the request latency histogram buckets.
+	requestBuckets = []time.Duration{10 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond,
+		time.Second, 5 * time.Second, 30 * time.Second}
+	// longpollBuckets are the upper bounds of the hanging GET duration histogram
+	// buckets, a longpoll lasts up to defaultHangTimeout when nothing changes.
+	longpollBuckets = []time.Duration{time.Second, 10 * time.Second, 30 * time.Second,
+		defaultHangTimeout * time.Second, defaultClientTimeout * time.Second}
+)
+
+// Histogram is a cumulative distribution of durations.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets.
+	Buckets []time.Duration
+	// Counts are the number of observations per bucket, the last count holds the
+	// observations greater than the last bucket.
+	Counts []uint64
+	// Count is the total number of observations.
+	Count uint64
+	// Sum is the sum of all the observations.
+	Sum time.Duration
+}
+
+// NewHistogram returns an empty histogram with the provided bucket upper bounds,
+// buckets must be sorted.
+func NewHistogram(buckets []time.Duration) Histogram {
+	return Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe records an observation of d.
+func (h *Histogram) Observe(d time.Duration) {
+	i, _ := slices.BinarySearch(h.Buckets, d)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// Mean returns the mean of the observations, zero if nothing was observed.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Clone returns a deep copy of h.
+func (h Histogram) Clone() Histogram {
+	h.Counts = slices.Clone(h.Counts)
+	return h
+}
+
+// Metrics are the counters and histograms of the requests made by a Client.
+type Metrics struct {
+	// Requests are the number of requests by metadata path.
+	Requests map[string]uint64
+	// StatusCodes are the number of responses by HTTP status code, requests that
+	// didn't get a response are counted with status code -1.
+	StatusCodes map[int]uint64
+	// Errors is the number of failed requests.
+	Errors uint64
+	// EtagChanges is the number of times a descriptor fetch returned a new etag.
+	EtagChanges uint64
+	// RequestLatency is the latency distribution of the non hanging requests.
+	RequestLatency Histogram
+	// LongpollDuration is the duration distribution of the hanging GETs.
+	LongpollDuration Histogram
+}
+
+// String returns a single line summary of m.
+func (m Metrics) String() string {
+	var codes []string
+	for _, code := range slices.Sorted(maps.Keys(m.StatusCodes)) {
+		codes = append(codes, fmt.Sprintf("%d=%d", code, m.StatusCodes[code]))
+	}
+
+	var requests uint64
+	for _, count := range m.Requests {
+		requests += count
+	}
+
+	return fmt.Sprintf("requests=%d errors=%d status=[%s] etag_changes=%d request_latency_mean=%s longpolls=%d longpoll_mean=%s",
+		requests, m.Errors, strings.Join(codes, " "), m.EtagChanges, m.RequestLatency.Mean(),
+		m.LongpollDuration.Count, m.LongpollDuration.Mean())
+}
+
+// metricsRecorder records the metrics of a Client, its zero value is ready to use.
+type metricsRecorder struct {
+	mutex   sync.Mutex
+	metrics Metrics
+}
+
+// init allocates the metrics on first use, callers must hold the mutex.
+func (r *metricsRecorder) init() {
+	if r.metrics.Requests != nil {
+		return
+	}
+	r.metrics = Metrics{
+		Requests:         make(map[string]uint64),
+		StatusCodes:      make(map[int]uint64),
+		RequestLatency:   NewHistogram(requestBuckets),
+		LongpollDuration: NewHistogram(longpollBuckets),
+	}
+}
+
+// record records a request to path, status is -1 if no response was received.
+func (r *metricsRecorder) record(path string, hang bool, status int, failed bool, elapsed time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.init()
+
+	r.metrics.Requests[path]++
+	r.metrics.StatusCodes[status]++
+	if failed {
+		r.metrics.Errors++
+	}
+	if hang {
+		r.metrics.LongpollDuration.Observe(elapsed)
+	} else {
+		r.metrics.RequestLatency.Observe(elapsed)
+	}
+}
+
+func (r *metricsRecorder) recordEtagChange() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics.EtagChanges++
+}
+
+func (r *metricsRecorder) snapshot() Metrics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.init()
+
+	m := r.metrics
+	m.Requests = maps.Clone(m.Requests)
+	m.StatusCodes = maps.Clone(m.StatusCodes)
+	m.RequestLatency = m.RequestLatency.Clone()
+	m.LongpollDuration = m.LongpollDuration.Clone()
+	return m
+}
+
+// Metrics returns a snapshot of the client's request metrics.
+func (c *Client) Metrics() Metrics {
+	return c.metrics.snapshot()
+}
+
+// metricsPath returns the metadata path of reqURL used to label its metrics.
+func (c *Client) metricsPath(reqURL string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(reqURL, c.metadataURL), "?")
+	return "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetrics(t *testing.T) {
+	var req int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		req++
+		w.Header().Set("etag", fmt.Sprintf("etag%d", req))
+		fmt.Fprintf(w, `{"instance":{"id":%d}}`, req)
+	}))
+	defer ts.Close()
+
+	client := &Client{
+		metadataURL: ts.URL,
+		httpClient: &http.Client{
+			Timeout: 1 * time.Second,
+		},
+		retryPolicy: retry.Policy{MaxAttempts: 1},
+	}
+
+	ctx := context.Background()
+	if _, err := client.Get(ctx); err != nil {
+		t.Fatalf("Get() failed unexpectedly with error: %v", err)
+	}
+	if _, err := client.Watch(ctx); err != nil {
+		t.Fatalf("Watch() failed unexpectedly with error: %v", err)
+	}
+	if _, err := client.GetKey(ctx, "missing", nil); err == nil {
+		t.Fatalf("GetKey(missing) succeeded, want error")
+	}
+
+	got := client.Metrics()
+
+	wantRequests := map[string]uint64{"/": 2, "/missing": 1}
+	if diff := cmp.Diff(wantRequests, got.Requests); diff != "" {
+		t.Errorf("Metrics() returned unexpected requests (-want +got):\n%s", diff)
+	}
+
+	wantCodes := map[int]uint64{http.StatusOK: 2, http.StatusNotFound: 1}
+	if diff := cmp.Diff(wantCodes, got.StatusCodes); diff != "" {
+		t.Errorf("Metrics() returned unexpected status codes (-want +got):\n%s", diff)
+	}
+
+	if got.Errors != 1 {
+		t.Errorf("Metrics() returned %d errors, want 1", got.Errors)
+	}
+	if got.EtagChanges != 2 {
+		t.Errorf("Metrics() returned %d etag changes, want 2", got.EtagChanges)
+	}
+	if got.RequestLatency.Count != 2 {
+		t.Errorf("Metrics() returned %d request latency observations, want 2", got.RequestLatency.Count)
+	}
+	if got.LongpollDuration.Count != 1 {
+		t.Errorf("Metrics() returned %d longpoll observations, want 1", got.LongpollDuration.Count)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Second, 10 * time.Second})
+	for _, d := range []time.Duration{time.Second / 2, time.Second, 5 * time.Second, time.Minute} {
+		h.Observe(d)
+	}
+
+	if diff := cmp.Diff([]uint64{2, 1, 1}, h.Counts); diff != "" {
+		t.Errorf("observe() produced unexpected counts (-want +got):\n%s", diff)
+	}
+
+	if want := (time.Second/2 + time.Second + 5*time.Second + time.Minute) / 4; h.Mean() != want {
+		t.Errorf("Mean() = %s, want %s", h.Mean(), want)
+	}
+}
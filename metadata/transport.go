@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	// defaultTransport is the transport of the clients allocated with New(), if
+	// nil the clients use http.DefaultTransport.
+	defaultTransport http.RoundTripper
+)
+
+// TransportConfig configures the transport built by NewTransport().
+type TransportConfig struct {
+	// ProxyURL is the URL of the proxy the requests are sent through, if empty
+	// the proxy is taken from the HTTP_PROXY environment variables.
+	ProxyURL string
+	// SourceInterface is the name of the network interface the connections are
+	// bound to, if empty the OS picks the interface from its routes.
+	SourceInterface string
+}
+
+// SetDefaultTransport sets the transport of the clients allocated with New()
+// from now on, a nil transport restores http.DefaultTransport.
+func SetDefaultTransport(transport http.RoundTripper) {
+	defaultTransport = transport
+}
+
+// NewTransport builds a transport sending the requests through the configured
+// proxy and binding the connections to the configured source interface. If
+// config is empty nil is returned, meaning http.DefaultTransport.
+func NewTransport(config TransportConfig) (http.RoundTripper, error) {
+	if config == (TransportConfig{}) {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.ProxyURL != "" {
+		proxy, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy url %q: %w", config.ProxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if config.SourceInterface != "" {
+		addr, err := interfaceAddr(config.SourceInterface)
+		if err != nil {
+			return nil, err
+		}
+
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			LocalAddr: &net.TCPAddr{IP: addr},
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	return transport, nil
+}
+
+// interfaceAddr returns the address connections bound to the interface name
+// originate from, IPv4 addresses are preferred as the metadata server's
+// link-local address is IPv4.
+func interfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find source interface %q: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of source interface %q: %w", name, err)
+	}
+
+	var res net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if res == nil {
+			res = ipnet.IP
+		}
+	}
+
+	if res == nil {
+		return nil, fmt.Errorf("source interface %q has no addresses", name)
+	}
+	return res, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-agent/retry"
+)
+
+func TestNewTransportEmpty(t *testing.T) {
+	transport, err := NewTransport(TransportConfig{})
+	if err != nil {
+		t.Fatalf("NewTransport() failed unexpectedly with error: %v", err)
+	}
+	if transport != nil {
+		t.Errorf("NewTransport() = %v, want nil", transport)
+	}
+}
+
+func TestNewTransportProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		fmt.Fprint(w, "value")
+	}))
+	defer proxy.Close()
+
+	transport, err := NewTransport(TransportConfig{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewTransport() failed unexpectedly with error: %v", err)
+	}
+
+	client := NewWithTransport(retry.Policy{MaxAttempts: 1}, transport)
+	got, err := client.GetKey(context.Background(), "instance/id", nil)
+	if err != nil {
+		t.Fatalf("GetKey() failed unexpectedly with error: %v", err)
+	}
+	if got != "value" {
+		t.Errorf("GetKey() = %q, want %q", got, "value")
+	}
+
+	if want := defaultMetadataURL + "instance/id"; proxied != want {
+		t.Errorf("proxy got request for %q, want %q", proxied, want)
+	}
+}
+
+func TestNewTransportSourceInterface(t *testing.T) {
+	if _, err := NewTransport(TransportConfig{SourceInterface: "nonexistent0"}); err == nil {
+		t.Errorf("NewTransport() succeeded for a nonexistent interface, want error")
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("Failed to list interfaces: %v", err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+
+		addr, err := interfaceAddr(iface.Name)
+		if err != nil {
+			t.Skipf("Loopback interface %q has no usable address: %v", iface.Name, err)
+		}
+		if !addr.IsLoopback() {
+			t.Errorf("interfaceAddr(%q) = %s, want a loopback address", iface.Name, addr)
+		}
+		return
+	}
+	t.Skip("No loopback interface found")
+}
@@ -32,6 +32,19 @@ type WindowsKey struct {
 	HashFunction        string
 	AddToAdministrators *bool
 	PasswordLength      int
+	// PublicKey is the base64 encoded uncompressed P-256 point the password is
+	// encrypted to with the ECIES scheme, instead of Exponent and Modulus.
+	PublicKey string
+	// Version selects the password encryption scheme, 0 and 1 are RSA OAEP and 2 is
+	// ECIES.
+	Version int
+	// Groups are the local groups the user is added to, instead of the default ones.
+	Groups []string
+}
+
+// hasPublicKey returns true if wk has an RSA or an ECIES public key.
+func (wk WindowsKey) hasPublicKey() bool {
+	return (wk.Exponent != "" && wk.Modulus != "") || wk.PublicKey != ""
 }
 
 // WindowsKeys is a slice of WindowKey.
@@ -52,10 +65,24 @@ func (k *WindowsKeys) UnmarshalJSON(b []byte) error {
 		}
 
 		expired, _ := utils.CheckExpired(wk.ExpireOn)
-		if wk.Exponent != "" && wk.Modulus != "" && wk.UserName != "" && !expired {
+		if wk.hasPublicKey() && wk.UserName != "" && !expired {
 			*k = append(*k, wk)
 		}
 	}
 
 	return nil
 }
+
+// MarshalJSON marshals k in the metadata server's representation, a string of
+// newline separated JSON keys, so it's unmarshaled back by UnmarshalJSON().
+func (k WindowsKeys) MarshalJSON() ([]byte, error) {
+	var keys []string
+	for _, wk := range k {
+		data, err := json.Marshal(wk)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, string(data))
+	}
+	return json.Marshal(strings.Join(keys, "\n"))
+}
@@ -13,6 +13,8 @@
 #  limitations under the License.
 
 Stop-Service GCEAgent -Verbose
+# Revert the users and forwarded IPs created by the agent.
+& "C:\Program Files\Google\Compute Engine\agent\GCEWindowsAgent.exe" cleanup
 & sc.exe delete GCEAgent
 
 $compat_manager = 'GCEWindowsCompatManager'
@@ -217,6 +217,8 @@ if [ $1 -eq 0 ]; then
       ggactl_plugin_cleanup all >/dev/null 2>&1 || :
     %endif
   fi
+  # Revert the users, routes and files created by the agent.
+  %{_bindir}/google_guest_agent cleanup || :
 fi
 
 %postun
@@ -253,6 +255,7 @@ fi
 if [ $1 -eq 0 ]; then
   # Package removal, not upgrade
   initctl stop google-guest-agent >/dev/null 2>&1 || :
+  %{_bindir}/google_guest_agent cleanup || :
 fi
 
 %postun
@@ -19,6 +19,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"math/rand"
 	"time"
 
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
@@ -36,6 +37,16 @@ type Policy struct {
 	BackoffFactor float64
 	// Jitter is the interval before the first retry.
 	Jitter time.Duration
+	// MaxBackoff is optional and caps the interval between retries, if zero the
+	// interval grows unbounded.
+	MaxBackoff time.Duration
+	// Randomization is optional and randomizes each interval by up to this fraction
+	// of it, i.e. 0.2 waits between 80% and 120% of the interval. It keeps a fleet of
+	// clients failing at the same time from retrying in lockstep.
+	Randomization float64
+	// RetryAfter is optional and returns the interval requested by err, i.e. by a
+	// Retry-After response header. A positive interval is used instead of the backoff.
+	RetryAfter func(error) time.Duration
 	// ShouldRetry is optional and the way to override default retry logic of retry every error.
 	// If ShouldRetry is not provided/implemented every error will be retried until all attempts are exhausted.
 	ShouldRetry IsRetriable
@@ -43,11 +54,29 @@ type Policy struct {
 
 // backoff computes interval between retries. Interval is jitter*(backoffFactor^attempt).
 // For e.g. if jitter was set to 10 and factor was 3, backoff between attempts would be [10, 30, 90, 270...].
+// The interval is then capped to MaxBackoff and randomized by Randomization, if set.
 func backoff(attempt int, policy Policy) time.Duration {
 	b := float64(policy.Jitter) * math.Pow(policy.BackoffFactor, float64(attempt))
+	if policy.MaxBackoff > 0 && b > float64(policy.MaxBackoff) {
+		b = float64(policy.MaxBackoff)
+	}
+	if policy.Randomization > 0 {
+		b += b * policy.Randomization * (2*rand.Float64() - 1)
+	}
 	return time.Duration(b)
 }
 
+// delay returns the interval to wait after the failed attempt, the interval
+// requested by err takes precedence over the computed backoff.
+func delay(attempt int, policy Policy, err error) time.Duration {
+	if policy.RetryAfter != nil {
+		if d := policy.RetryAfter(err); d > 0 {
+			return d
+		}
+	}
+	return backoff(attempt, policy)
+}
+
 // isRetriable checks if error is retriable. If ShouldRetry is unimplemented it always returns
 // true, otherwise overriden method's logic determines the retry behavior.
 func isRetriable(policy Policy, err error) bool {
@@ -87,7 +116,7 @@ func RunWithResponse[T any](ctx context.Context, policy Policy, f func() (T, err
 		select {
 		case <-ctx.Done():
 			return res, ctx.Err()
-		case <-time.After(backoff(attempt, policy)):
+		case <-time.After(delay(attempt, policy, err)):
 		}
 	}
 	return res, fmt.Errorf("num of retries set to 0, made no attempts to run")
@@ -154,6 +154,40 @@ func TestBackoff(t *testing.T) {
 	}
 }
 
+func TestBackoffBounds(t *testing.T) {
+	policy := Policy{MaxAttempts: 10, BackoffFactor: 2, Jitter: 100, MaxBackoff: 400, Randomization: 0.5}
+
+	for i := 0; i < policy.MaxAttempts; i++ {
+		want := min(100<<i, 400)
+		got := backoff(i, policy)
+		if got < time.Duration(want/2) || got > time.Duration(want+want/2) {
+			t.Errorf("backoff(%d, %+v) = %d, want between %d and %d", i, policy, got, want/2, want+want/2)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	errThrottled := errors.New("throttled")
+	policy := Policy{
+		MaxAttempts:   2,
+		BackoffFactor: 1,
+		Jitter:        10,
+		RetryAfter: func(err error) time.Duration {
+			if err == errThrottled {
+				return time.Second
+			}
+			return 0
+		},
+	}
+
+	if got := delay(0, policy, errThrottled); got != time.Second {
+		t.Errorf("delay(0, %+v, %v) = %s, want the requested interval: %s", policy, errThrottled, got, time.Second)
+	}
+	if got := delay(0, policy, fmt.Errorf("fake error")); got != 10 {
+		t.Errorf("delay(0, %+v, fake error) = %d, want the backoff: %d", policy, got, 10)
+	}
+}
+
 func TestIsRetriable(t *testing.T) {
 	// Fake ShouldRetry() override.
 	f := func(err error) bool {
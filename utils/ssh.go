@@ -17,9 +17,13 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -66,18 +70,79 @@ func CheckExpiredKey(key string) error {
 	return nil
 }
 
+// expireOnLayouts are the accepted expireOn time layouts, the first one is
+// reported in the parse errors. The times without a timezone are UTC.
+var expireOnLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999Z07",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// ParseExpireOn parses the expireOn time of an expiring key, with its timezone
+// offset in the RFC3339 (+07:00), ISO 8601 basic (+0700) or hours only (+07)
+// format, or UTC without it.
+func ParseExpireOn(expireOn string) (time.Time, error) {
+	var firstErr error
+	for _, layout := range expireOnLayouts {
+		t, err := time.Parse(layout, strings.TrimSpace(expireOn))
+		if err == nil {
+			return t, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
+
 // CheckExpired takes a time string and determines if it represents a time in the past.
 func CheckExpired(expireOn string) (bool, error) {
-	t, err := time.Parse(time.RFC3339, expireOn)
+	t, err := ParseExpireOn(expireOn)
 	if err != nil {
-		t2, err2 := time.Parse("2006-01-02T15:04:05-0700", expireOn)
-		if err2 != nil {
-			return true, err //Return RFC3339 error
-		}
-		t = t2
+		return true, err
 	}
 	return t.Before(time.Now()), nil
+}
+
+// KeyPolicy restricts the SSH keys accepted by CheckKeyPolicy.
+type KeyPolicy struct {
+	// Types are the accepted key types, i.e. "ssh-ed25519", all of them if empty.
+	Types []string
+	// RejectWeak rejects the DSA keys and the RSA keys smaller than MinRSABits.
+	RejectWeak bool
+}
+
+// MinRSABits is the minimum size of the RSA keys accepted by a KeyPolicy
+// rejecting the weak keys.
+const MinRSABits = 2048
 
+// CheckKeyPolicy validates that key is a single well formed authorized key,
+// options allowed, accepted by policy.
+func CheckKeyPolicy(key string, policy KeyPolicy) error {
+	pub, _, _, rest, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(key)))
+	if err != nil {
+		return fmt.Errorf("invalid ssh key entry - %w", err)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return errors.New("invalid ssh key entry - unexpected data after the key")
+	}
+
+	if len(policy.Types) > 0 && !slices.Contains(policy.Types, pub.Type()) {
+		return fmt.Errorf("rejected ssh key - key type %s not accepted", pub.Type())
+	}
+	if !policy.RejectWeak {
+		return nil
+	}
+	if pub.Type() == ssh.KeyAlgoDSA {
+		return errors.New("rejected ssh key - weak key type ssh-dss")
+	}
+	if cpk, ok := pub.(ssh.CryptoPublicKey); ok {
+		if rsaKey, ok := cpk.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < MinRSABits {
+			return fmt.Errorf("rejected ssh key - weak %d bits RSA key, at least %d bits required", rsaKey.N.BitLen(), MinRSABits)
+		}
+	}
+	return nil
 }
 
 // ValidateUser checks for the presence of a characters which should not be
@@ -15,8 +15,16 @@
 package utils
 
 import (
+	"crypto/dsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"fmt"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestGetUserKey(t *testing.T) {
@@ -126,3 +134,94 @@ func TestValidateUser(t *testing.T) {
 		}
 	}
 }
+
+func TestParseExpireOn(t *testing.T) {
+	want := time.Date(2095, 4, 23, 12, 34, 56, 0, time.UTC)
+	table := []struct {
+		expireOn string
+		haserr   bool
+	}{
+		{"2095-04-23T12:34:56Z", false},
+		{"2095-04-23T12:34:56+00:00", false},
+		{"2095-04-23T12:34:56+0000", false},
+		{"2095-04-23T14:34:56+0200", false},
+		{"2095-04-23T07:34:56-05:00", false},
+		{"2095-04-23T14:34:56+02", false},
+		{"2095-04-23T12:34:56.000Z", false},
+		{"2095-04-23T12:34:56", false},
+		{"Apri 4, 2056", true},
+		{"", true},
+	}
+
+	for _, tt := range table {
+		got, err := ParseExpireOn(tt.expireOn)
+		if (err != nil) != tt.haserr {
+			t.Errorf("ParseExpireOn(%q) = %v, want error: %t", tt.expireOn, err, tt.haserr)
+			continue
+		}
+		if !tt.haserr && !got.Equal(want) {
+			t.Errorf("ParseExpireOn(%q) = %v, want %v", tt.expireOn, got, want)
+		}
+	}
+}
+
+func TestCheckKeyPolicy(t *testing.T) {
+	smallRSA := MakeRandRSAPubKey(t)
+	prv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	pub, err := ssh.NewPublicKey(prv.Public())
+	if err != nil {
+		t.Fatalf("error wrapping ssh public key: %v", err)
+	}
+	rsaKey := base64.StdEncoding.EncodeToString(pub.Marshal())
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating ed25519 key: %v", err)
+	}
+	pub, err = ssh.NewPublicKey(edPub)
+	if err != nil {
+		t.Fatalf("error wrapping ssh public key: %v", err)
+	}
+	edKey := base64.StdEncoding.EncodeToString(pub.Marshal())
+	var dsaPrv dsa.PrivateKey
+	if err := dsa.GenerateParameters(&dsaPrv.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatalf("error generating DSA parameters: %v", err)
+	}
+	if err := dsa.GenerateKey(&dsaPrv, rand.Reader); err != nil {
+		t.Fatalf("error generating DSA key: %v", err)
+	}
+	pub, err = ssh.NewPublicKey(&dsaPrv.PublicKey)
+	if err != nil {
+		t.Fatalf("error wrapping ssh public key: %v", err)
+	}
+	dsaKey := base64.StdEncoding.EncodeToString(pub.Marshal())
+
+	weak := KeyPolicy{RejectWeak: true}
+	edOnly := KeyPolicy{Types: []string{"ssh-ed25519"}}
+	table := []struct {
+		key    string
+		policy KeyPolicy
+		haserr bool
+	}{
+		{"ssh-rsa " + smallRSA + " user", KeyPolicy{}, false},
+		{"ssh-dss " + dsaKey + " user", KeyPolicy{}, false},
+		{"ssh-rsa " + smallRSA + " user", weak, true},
+		{"ssh-dss " + dsaKey + " user", weak, true},
+		{"ssh-rsa " + rsaKey + " user", weak, false},
+		{"ssh-ed25519 " + edKey + " user", weak, false},
+		{"ssh-rsa " + rsaKey + " user", edOnly, true},
+		{`restrict,pty ssh-ed25519 ` + edKey + ` google-ssh {"userName":"usera@example.com","expireOn":"2095-04-23T12:34:56+0000"}`, edOnly, false},
+		{"ssh-ed25519 " + edKey + " user\nssh-rsa " + rsaKey, KeyPolicy{}, true},
+		{"ssh-ed25519 AAAAnotakey user", KeyPolicy{}, true},
+		{"", KeyPolicy{}, true},
+	}
+
+	for _, tt := range table {
+		err := CheckKeyPolicy(tt.key, tt.policy)
+		if (err != nil) != tt.haserr {
+			t.Errorf("CheckKeyPolicy(%q, %+v) = %v, want error: %t", tt.key, tt.policy, err, tt.haserr)
+		}
+	}
+}